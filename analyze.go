@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// "history analyze" is a dry run whose report also breaks the links down by domain and by who posted them, for
+// sizing up a server before archiving it. The breakdown by domain and status can be sent along as a CSV.
+
+const (
+	// Rows of each breakdown in the embed, the CSV has them all
+	analyzeTopDomains   = 10
+	analyzeTopUploaders = 5
+)
+
+func (t *dryRunTally) links() int {
+	total := 0
+	for _, count := range t.statuses {
+		total += count
+	}
+	return total
+}
+
+// Keys of the tallies with the most links first.
+func sortDryRunTallies(tallies map[string]*dryRunTally) []string {
+	keys := make([]string, 0, len(tallies))
+	for key := range tallies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if tallies[keys[i]].links() != tallies[keys[j]].links() {
+			return tallies[keys[i]].links() > tallies[keys[j]].links()
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func formatDryRunTally(name string, tally *dryRunTally) string {
+	line := fmt.Sprintf("\n`%s` %s — %s would download, %s", formatNumber(int64(tally.links())), name,
+		formatNumber(int64(tally.statuses[downloadSuccess])), formatBytes(tally.bytes))
+	if tally.unknownSizes > 0 {
+		line += fmt.Sprintf(" _(+%d unknown)_", tally.unknownSizes)
+	}
+	return line
+}
+
+// The busiest domains and uploaders, for the end of the summary. Called with the report locked.
+func (r *dryRunReport) breakdowns() string {
+	breakdowns := fmt.Sprintf("\n\n**Domains** _(%d)_", len(r.domains))
+	domains := sortDryRunTallies(r.domains)
+	for i, domain := range domains {
+		if i == analyzeTopDomains {
+			breakdowns += fmt.Sprintf("\n_...and %d more_", len(domains)-i)
+			break
+		}
+		breakdowns += formatDryRunTally(domain, r.domains[domain])
+	}
+	breakdowns += fmt.Sprintf("\n\n**Busiest Uploaders** _(%d)_", len(r.uploaders))
+	for i, userID := range sortDryRunTallies(r.uploaders) {
+		if i == analyzeTopUploaders {
+			break
+		}
+		breakdowns += formatDryRunTally(r.names[userID], r.uploaders[userID])
+	}
+	return breakdowns
+}
+
+// One row for each status seen for each domain, bytes are only known for files that would be downloaded.
+func (r *dryRunReport) csv() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"domain", "status", "files", "bytes", "unknown_sizes"})
+	for _, domain := range sortDryRunTallies(r.domains) {
+		tally := r.domains[domain]
+		var statuses []int
+		for status := range tally.statuses {
+			statuses = append(statuses, int(status))
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			row := []string{domain, getDownloadStatusString(downloadStatus(status)), strconv.Itoa(tally.statuses[downloadStatus(status)]), "", ""}
+			if downloadStatus(status) == downloadSuccess {
+				row[3], row[4] = strconv.FormatInt(tally.bytes, 10), strconv.Itoa(tally.unknownSizes)
+			}
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes()
+}
+
+// Sends the report's CSV to where the command was used, if it asked for one.
+func sendDryRunCSV(commandingMessage *discordgo.Message, report *dryRunReport, label string) {
+	if commandingMessage == nil || !report.attachCSV {
+		return
+	}
+	if !hasPerms(commandingMessage.ChannelID, discordgo.PermissionAttachFiles) {
+		log.Println(logPrefixHistory, color.HiRedString("Bot can't attach files in %s, the analysis CSV wasn't sent", commandingMessage.ChannelID))
+		return
+	}
+	filename := fmt.Sprintf("analysis_%s_%s.csv", sanitizePathSegment(label), time.Now().Format("2006-01-02_15-04-05"))
+	_, err := bot.ChannelMessageSendComplex(commandingMessage.ChannelID, &discordgo.MessageSend{
+		Files: []*discordgo.File{{Name: filename, ContentType: "text/csv", Reader: bytes.NewReader(report.csv())}},
+	})
+	if err != nil {
+		log.Println(logPrefixHistory, color.HiRedString("Failed to send the analysis CSV:\t%s", err))
+	}
+}
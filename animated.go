@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Animated images are told apart by their structure instead of being decoded, GIFs by reaching a second frame,
+// APNGs by an acTL chunk before the image data and WebPs by the VP8X animation flag or an ANIM chunk. What's
+// read stops at the first sign either way, so a 50MB GIF costs about as much as its first frame.
+
+func isAnimatedImage(body []byte) bool {
+	switch {
+	case bytes.HasPrefix(body, []byte("GIF87a")) || bytes.HasPrefix(body, []byte("GIF89a")):
+		return isAnimatedGIF(body)
+	case bytes.HasPrefix(body, []byte("\x89PNG\r\n\x1a\n")):
+		return isAnimatedPNG(body)
+	case len(body) >= 12 && bytes.Equal(body[:4], []byte("RIFF")) && bytes.Equal(body[8:12], []byte("WEBP")):
+		return isAnimatedWebP(body)
+	}
+	return false
+}
+
+// Walks the blocks, skipping each frame's data by its sub-block lengths.
+func isAnimatedGIF(body []byte) bool {
+	if len(body) < 13 {
+		return false
+	}
+	i := 13
+	if body[10]&0x80 != 0 {
+		i += 3 << (body[10]&0x07 + 1) // global color table
+	}
+	frames := 0
+	for i < len(body) {
+		switch body[i] {
+		case 0x2C: // image descriptor
+			frames++
+			if frames > 1 {
+				return true
+			}
+			if i+10 > len(body) {
+				return false
+			}
+			flags := body[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1) // local color table
+			}
+			i++ // LZW minimum code size
+		case 0x21: // extension
+			i += 2
+		default: // trailer, or something that isn't a GIF
+			return false
+		}
+		// Sub-blocks, until one of length 0
+		for i < len(body) && body[i] != 0 {
+			i += int(body[i]) + 1
+		}
+		i++
+	}
+	return false
+}
+
+// acTL has to come before the first IDAT, and says how many frames there are.
+func isAnimatedPNG(body []byte) bool {
+	for i := 8; i+8 <= len(body); {
+		length := int(binary.BigEndian.Uint32(body[i:]))
+		switch string(body[i+4 : i+8]) {
+		case "acTL":
+			return i+12 <= len(body) && binary.BigEndian.Uint32(body[i+8:]) > 1
+		case "IDAT", "IEND":
+			return false
+		}
+		i += 12 + length
+	}
+	return false
+}
+
+func isAnimatedWebP(body []byte) bool {
+	for i := 12; i+8 <= len(body); {
+		length := int(binary.LittleEndian.Uint32(body[i+4:]))
+		switch string(body[i : i+4]) {
+		case "VP8X":
+			if i+9 <= len(body) && body[i+8]&0x02 != 0 {
+				return true
+			}
+		case "ANIM", "ANMF":
+			return true
+		case "VP8 ", "VP8L":
+			return false
+		}
+		i += 8 + length + length%2 // chunks are padded to even lengths
+	}
+	return false
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Local HTTP API so other tools can send links through the same handlers, filters, duplicate checks
+// and database as Discord messages. Off unless apiAddress is set, and every request needs apiToken.
+
+var logPrefixAPI = color.HiCyanString("[API]")
+
+const apiSearchLimitDefault = 50
+
+type apiDownloadRequest struct {
+	URL         string `json:"url"`
+	Destination string `json:"destination,omitempty"` // this or channelID
+	ChannelID   string `json:"channelID,omitempty"`   // borrows the channel's settings and destination
+}
+
+type apiDownloadResult struct {
+	URL      string        `json:"url"`
+	Filename string        `json:"filename,omitempty"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Saved    *downloadItem `json:"saved,omitempty"`
+}
+
+// Addresses without a host only listen locally.
+func getAPIListenAddress(address string) string {
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+	if strings.HasPrefix(address, ":") {
+		address = "127.0.0.1" + address
+	}
+	return address
+}
+
+func startAPI() {
+	settings := getConfig()
+	if settings.APIAddress == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", apiAuthorized(apiDownload))
+	mux.HandleFunc("/download/", apiAuthorized(apiDownloadJobStatus))
+	mux.HandleFunc("/downloads", apiAuthorized(apiDownloads))
+	mux.HandleFunc("/status", apiAuthorized(apiStatus))
+	mux.HandleFunc("/history/", apiAuthorized(apiHistory))
+
+	address := getAPIListenAddress(settings.APIAddress)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Println(logPrefixAPI, color.HiRedString("Failed to listen on %s:\t%s", address, err))
+		return
+	}
+	log.Println(logPrefixAPI, color.HiGreenString("Listening on http://%s", listener.Addr()))
+	go apiDownloadWorker()
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(listener); err != nil {
+		log.Println(logPrefixAPI, color.HiRedString("Stopped serving:\t%s", err))
+	}
+}
+
+func apiAuthorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings := getConfig()
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if settings.Credentials.APIToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(settings.Credentials.APIToken)) != 1 {
+			apiError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func apiJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, code int, message string) {
+	apiJSON(w, code, map[string]string{"error": message})
+}
+
+// POST /download {"url": ..., "destination": ... or "channelID": ...}, queued for the download worker.
+func apiDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var request apiDownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if request.URL == "" {
+		apiError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	destination := request.Destination
+	if request.ChannelID != "" {
+		if !isChannelRegistered(request.ChannelID) {
+			apiError(w, http.StatusNotFound, "channel isn't registered")
+			return
+		}
+		if destination == "" {
+			destination = getChannelConfig(request.ChannelID).Destination
+		}
+	}
+	if destination == "" {
+		apiError(w, http.StatusBadRequest, "destination or channelID is required")
+		return
+	}
+	// Only somewhere settings already write to, so the token can't be used to write files anywhere else
+	if request.Destination != "" && !isConfiguredDestination(request.Destination) {
+		apiError(w, http.StatusForbidden, "destination isn't one in settings or inside one")
+		return
+	}
+
+	job := &apiDownloadJob{
+		ID:          strconv.FormatInt(atomic.AddInt64(&apiDownloadJobCount, 1), 10),
+		URL:         request.URL,
+		Status:      apiJobQueued,
+		Queued:      time.Now(),
+		destination: destination,
+		channelID:   request.ChannelID,
+	}
+	apiDownloadJobsMutex.Lock()
+	apiDownloadJobs[job.ID] = job
+	apiDownloadJobsMutex.Unlock()
+	select {
+	case apiDownloadQueue <- job:
+	default:
+		forgetAPIDownloadJob(job.ID)
+		apiError(w, http.StatusServiceUnavailable, "download queue is full, try again later")
+		return
+	}
+	log.Println(logPrefixAPI, color.CyanString("Download queued for %s (job %s)", request.URL, job.ID))
+	w.Header().Set("Location", "/download/"+job.ID)
+	apiJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// GET /download/{id}, how a queued download is going and each file's result once it's done.
+func apiDownloadJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	apiDownloadJobsMutex.Lock()
+	job, exists := apiDownloadJobs[strings.Trim(strings.TrimPrefix(r.URL.Path, "/download/"), "/")]
+	apiDownloadJobsMutex.Unlock()
+	if !exists {
+		apiError(w, http.StatusNotFound, "no such job, finished jobs are forgotten after an hour")
+		return
+	}
+	apiJSON(w, http.StatusOK, job.snapshot())
+}
+
+//#region Download Queue
+
+const (
+	apiJobQueued  = "queued"
+	apiJobRunning = "running"
+	apiJobDone    = "done"
+
+	apiDownloadQueueSize = 1024
+	// Finished jobs can be looked up for this long
+	apiDownloadJobRetention = time.Hour
+)
+
+// A POST /download waiting for, or being handled by, the download worker.
+type apiDownloadJob struct {
+	ID       string              `json:"id"`
+	URL      string              `json:"url"`
+	Status   string              `json:"status"`
+	Queued   time.Time           `json:"queued"`
+	Finished *time.Time          `json:"finished,omitempty"`
+	Results  []apiDownloadResult `json:"results,omitempty"`
+
+	destination string
+	channelID   string
+}
+
+var (
+	apiDownloadQueue     = make(chan *apiDownloadJob, apiDownloadQueueSize)
+	apiDownloadJobs      = make(map[string]*apiDownloadJob)
+	apiDownloadJobsMutex sync.Mutex
+	apiDownloadJobCount  int64 // atomic, last job ID
+)
+
+// A copy to encode, the worker changes the job while it runs.
+func (job *apiDownloadJob) snapshot() apiDownloadJob {
+	apiDownloadJobsMutex.Lock()
+	defer apiDownloadJobsMutex.Unlock()
+	copied := *job
+	copied.Results = append([]apiDownloadResult(nil), job.Results...)
+	return copied
+}
+
+func (job *apiDownloadJob) update(change func(job *apiDownloadJob)) {
+	apiDownloadJobsMutex.Lock()
+	defer apiDownloadJobsMutex.Unlock()
+	change(job)
+}
+
+func forgetAPIDownloadJob(id string) {
+	apiDownloadJobsMutex.Lock()
+	defer apiDownloadJobsMutex.Unlock()
+	delete(apiDownloadJobs, id)
+}
+
+// Downloads queued jobs one at a time, through the same handlers, filters and throttling as links from Discord.
+func apiDownloadWorker() {
+	for job := range apiDownloadQueue {
+		job.update(func(job *apiDownloadJob) { job.Status = apiJobRunning })
+		results := runAPIDownload(job)
+		job.update(func(job *apiDownloadJob) {
+			finished := time.Now()
+			job.Status, job.Finished, job.Results = apiJobDone, &finished, results
+		})
+		id := job.ID
+		time.AfterFunc(apiDownloadJobRetention, func() { forgetAPIDownloadJob(id) })
+	}
+}
+
+func runAPIDownload(job *apiDownloadJob) []apiDownloadResult {
+	// There's no message behind these, the bot stands in as the author
+	message := &discordgo.Message{ChannelID: job.channelID, GuildID: getChannelGuildID(job.channelID), Author: user}
+	results := []apiDownloadResult{}
+	links := getDownloadLinks(job.URL, job.channelID)
+	if len(links) == 0 {
+		results = append(results, apiDownloadResult{URL: job.URL, Status: getDownloadStatusString(downloadSkipped), Error: "no downloadable links"})
+	}
+	for link, filename := range links {
+		status := startDownload(downloadRequestStruct{
+			InputURL:       link,
+			Filename:       filename,
+			Path:           job.destination,
+			Message:        message,
+			FileTime:       time.Now(),
+			Post:           getSitePost(link),
+			ManualDownload: true,
+			APIRequest:     true,
+			DryRun:         dryRunMode,
+		})
+		result := apiDownloadResult{URL: link, Filename: filename, Status: getDownloadStatusString(status.Status), Size: status.Size, Saved: status.Saved}
+		if status.Error != nil {
+			result.Error = status.Error.Error()
+		}
+		if status.Saved != nil {
+			result.Filename = status.Saved.Filename
+		}
+		results = append(results, result)
+	}
+	log.Println(logPrefixAPI, color.CyanString("Download job %s finished, %d result%s", job.ID, len(results), pluralS(len(results))))
+	return results
+}
+
+//#endregion
+
+// GET /downloads?query=...&channelID=...&tag=...&reaction=...&minReactions=...&limit=...
+func apiDownloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	limit := apiSearchLimitDefault
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			apiError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = parsed
+	}
+	minReactions := 0
+	if value := r.URL.Query().Get("minReactions"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			apiError(w, http.StatusBadRequest, "minReactions must be a number")
+			return
+		}
+		minReactions = parsed
+	}
+	matches := dbSearchDownloads(r.URL.Query().Get("query"), r.URL.Query().Get("channelID"),
+		r.URL.Query().Get("tag"), r.URL.Query().Get("reaction"), minReactions)
+	total := len(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	apiJSON(w, http.StatusOK, map[string]interface{}{"total": total, "downloads": matches})
+}
+
+// GET /status, the same figures as the status command.
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	snapshot := getStatusSnapshot()
+	statuses := make(map[string]int64, len(snapshot.statusCounts))
+	for status, count := range snapshot.statusCounts {
+		statuses[getDownloadStatusString(status)] = count
+	}
+	destinations := make(map[string]int64, len(snapshot.destinations))
+	for _, destination := range snapshot.destinations {
+		destinations[destination.path] = destination.free
+	}
+	histories := []map[string]interface{}{}
+	for _, progress := range snapshot.historyRunning {
+		histories = append(histories, map[string]interface{}{
+			"channel":  progress.channelName,
+			"files":    progress.files,
+			"messages": progress.messages,
+			"started":  progress.started,
+		})
+	}
+	var nextWindow interface{}
+	if !snapshot.nextWindow.IsZero() {
+		nextWindow = snapshot.nextWindow
+	}
+	apiJSON(w, http.StatusOK, map[string]interface{}{
+		"version":           projectVersion,
+		"started":           startTime,
+		"uptimeSeconds":     int64(time.Since(startTime).Seconds()),
+		"servers":           len(bot.State.Guilds),
+		"boundChannels":     getBoundChannelsCount(),
+		"boundServers":      getBoundServersCount(),
+		"heartbeatMs":       bot.HeartbeatLatency().Milliseconds(),
+		"bytesPerSecond":    atomic.LoadInt64(&downloadThroughput),
+		"activeDownloads":   snapshot.connected,
+		"inProgress":        snapshot.inProgress,
+		"queuedAutoHistory": snapshot.autoHistory,
+		"databaseRows":      snapshot.dbRows,
+		"databaseBytes":     snapshot.databaseSize,
+		"imageFilterImages": snapshot.imgStoreCount,
+		"pendingRecovered":  snapshot.pendingRecovered,
+		"pendingDropped":    snapshot.pendingDropped,
+		"deferred":          snapshot.deferred,
+		"nextWindow":        nextWindow,
+		"sessionStatuses":   statuses,
+		"destinationsFree":  destinations,
+		"historiesRunning":  histories,
+	})
+}
+
+// POST /history/{channelID}?before=...&since=..., runs in the background.
+func apiHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	channelID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/history/"), "/")
+	if !isNumeric(channelID) || !isChannelRegistered(channelID) {
+		apiError(w, http.StatusNotFound, "channel isn't registered")
+		return
+	}
+	if getHistoryStatus(channelID) != "" {
+		apiError(w, http.StatusConflict, "history is already running for this channel")
+		return
+	}
+	before, since := r.URL.Query().Get("before"), r.URL.Query().Get("since")
+	log.Println(logPrefixAPI, color.CyanString("History requested for %s", channelID))
+	go handleHistory(nil, channelID, before, since, historyOptions{})
+	apiJSON(w, http.StatusAccepted, map[string]string{"channelID": channelID, "status": "started"})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Downloads are queued for the worker and answered straight away, and the job can be looked up while it waits.
+func TestAPIDownloadQueued(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) {
+		settings.Channels = []configurationChannel{{ChannelID: "300", Destination: "downloads"}}
+	})
+
+	recorder := httptest.NewRecorder()
+	apiDownload(recorder, httptest.NewRequest(http.MethodPost, "/download", strings.NewReader(`{"url": "https://example.com/a.png", "channelID": "300"}`)))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("Replied %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+	var queued apiDownloadJob
+	if err := json.Unmarshal(recorder.Body.Bytes(), &queued); err != nil || queued.ID == "" || queued.Status != apiJobQueued {
+		t.Fatalf("Reply isn't a queued job: %s", recorder.Body)
+	}
+	defer forgetAPIDownloadJob(queued.ID)
+	if location := recorder.Header().Get("Location"); location != "/download/"+queued.ID {
+		t.Errorf("Location is %q, want /download/%s", location, queued.ID)
+	}
+	select {
+	case job := <-apiDownloadQueue:
+		if job.ID != queued.ID || job.URL != "https://example.com/a.png" || job.destination != "downloads" {
+			t.Errorf("Queued %+v", job)
+		}
+	default:
+		t.Fatal("Nothing was queued")
+	}
+
+	recorder = httptest.NewRecorder()
+	apiDownloadJobStatus(recorder, httptest.NewRequest(http.MethodGet, "/download/"+queued.ID, nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"status":"queued"`) {
+		t.Errorf("Job lookup replied %d: %s", recorder.Code, recorder.Body)
+	}
+	recorder = httptest.NewRecorder()
+	apiDownloadJobStatus(recorder, httptest.NewRequest(http.MethodGet, "/download/0", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Unknown job replied %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
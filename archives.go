@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var logPrefixArchive = color.HiCyanString("[Archive]")
+
+// True if the file is a zip the channel wants extracted. Only zips are supported.
+func isExtractableArchive(channelConfig configurationChannel, extension string, contentType string) bool {
+	return *channelConfig.ExtractArchives && extension == ".zip" && contentType == "application/zip"
+}
+
+// Limits on what a zip unpacks to whatever its entries claim, so a small archive can't fill the disk
+var (
+	archiveEntryLimit int64 = 1 << 30 // 1GB per file
+	archiveTotalLimit int64 = 4 << 30 // 4GB per archive, zips inside it included
+)
+
+var (
+	errArchiveEntryLimit = errors.New("bigger than the limit for a file in an archive")
+	errArchiveTotalLimit = errors.New("archive unpacks to more than the limit")
+)
+
+// State shared by an archive and the zips inside it.
+type archiveExtraction struct {
+	dir       string // entries are copied here before they're written
+	remaining int64  // bytes left of archiveTotalLimit
+}
+
+// Extracts a saved zip into a folder named after it, next to it. Inner files go through the channel's extension,
+// size and type filters and are recorded under the archive's URL. Zips inside are extracted too, but only one level deep.
+// Returns how many files were extracted, and false if anything went wrong so the archive should be kept.
+func extractArchive(storage storageBackend, archivePath string, data []byte, channelConfig configurationChannel, source downloadItem) (int, bool) {
+	dir, err := ioutil.TempDir("", "ddg-extract-")
+	if err != nil {
+		log.Println(logPrefixArchive, color.HiRedString("Failed to create temp folder for \"%s\": %s", archivePath, err))
+		return 0, false
+	}
+	defer os.RemoveAll(dir)
+	extraction := &archiveExtraction{dir: dir, remaining: archiveTotalLimit}
+	return extraction.extract(storage, archivePath, bytes.NewReader(data), int64(len(data)), channelConfig, source, 0)
+}
+
+func (extraction *archiveExtraction) extract(storage storageBackend, archivePath string, data io.ReaderAt, size int64, channelConfig configurationChannel, source downloadItem, depth int) (int, bool) {
+	reader, err := zip.NewReader(data, size)
+	if err != nil {
+		log.Println(logPrefixArchive, color.HiRedString("Failed to open \"%s\": %s", archivePath, err))
+		return 0, false
+	}
+
+	separator := storage.separator()
+	folder := archivePath[:len(archivePath)-len(path.Ext(archivePath))]
+	extracted, ok := 0, true
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name, safe := sanitizeArchiveEntry(file.Name, separator)
+		if !safe {
+			log.Println(logPrefixArchive, color.HiRedString("Skipping \"%s\" in \"%s\", it would be written outside the archive folder", file.Name, archivePath))
+			continue
+		}
+		extension := strings.ToLower(path.Ext(name))
+		if !isExtensionPermitted(channelConfig, extension) || !isSizePermitted(channelConfig, int64(file.UncompressedSize64)) {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Skipping \"%s\" in \"%s\", unpermitted extension or size", file.Name, archivePath))
+			}
+			continue
+		}
+
+		entry, err := extraction.copyEntry(file)
+		if err != nil {
+			log.Println(logPrefixArchive, color.HiRedString("Failed to read \"%s\" in \"%s\": %s", file.Name, archivePath, err))
+			ok = false
+			if errors.Is(err, errArchiveTotalLimit) {
+				break
+			}
+			continue
+		}
+		if !isSizePermitted(channelConfig, entry.size) {
+			os.Remove(entry.path)
+			continue
+		}
+		count, entryOk := extraction.writeEntry(storage, entry, folder+separator+name, file, channelConfig, source, depth)
+		os.Remove(entry.path)
+		extracted += count
+		ok = ok && entryOk
+	}
+	return extracted, ok
+}
+
+// An entry copied out of a zip, with what's needed to filter and record it.
+type archiveEntry struct {
+	path string // temp copy
+	size int64
+	head []byte // start of the file, for content type detection
+	hash string
+}
+
+// Copies an entry into the temp folder, stopping at the limits however big its header says it is.
+func (extraction *archiveExtraction) copyEntry(file *zip.File) (archiveEntry, error) {
+	limit, limitErr := archiveEntryLimit, errArchiveEntryLimit
+	if extraction.remaining < limit {
+		limit, limitErr = extraction.remaining, errArchiveTotalLimit
+	}
+	if file.UncompressedSize64 > uint64(limit) {
+		return archiveEntry{}, limitErr
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	defer reader.Close()
+	temp, err := ioutil.TempFile(extraction.dir, "entry-*")
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	defer temp.Close()
+
+	entry := archiveEntry{path: temp.Name(), head: make([]byte, 512)}
+	limited := io.LimitReader(reader, limit+1)
+	n, err := io.ReadFull(limited, entry.head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return entry, err
+	}
+	entry.head = entry.head[:n]
+	hasher := sha256.New()
+	entry.size, err = io.Copy(io.MultiWriter(temp, hasher), io.MultiReader(bytes.NewReader(entry.head), limited))
+	if err != nil {
+		return entry, err
+	}
+	if entry.size > limit {
+		return entry, limitErr
+	}
+	extraction.remaining -= entry.size
+	entry.hash = hex.EncodeToString(hasher.Sum(nil))
+	return entry, nil
+}
+
+// Writes out a copied entry, or extracts it if it's a zip. Returns how many files were extracted.
+func (extraction *archiveExtraction) writeEntry(storage storageBackend, entry archiveEntry, entryPath string, file *zip.File, channelConfig configurationChannel, source downloadItem, depth int) (int, bool) {
+	separator := storage.separator()
+	extension := strings.ToLower(path.Ext(entryPath))
+	contentType := http.DetectContentType(entry.head)
+	if depth == 0 && isExtractableArchive(channelConfig, extension, contentType) {
+		nested, err := os.Open(entry.path)
+		if err != nil {
+			log.Println(logPrefixArchive, color.HiRedString("Failed to open \"%s\": %s", entryPath, err))
+			return 0, false
+		}
+		defer nested.Close()
+		return extraction.extract(storage, entryPath, nested, entry.size, channelConfig, source, depth+1)
+	}
+	if !isContentTypePermitted(channelConfig, fixContentType(extension, strings.Split(contentType, "/")[0])) {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Skipping \"%s\", unpermitted filetype", entryPath))
+		}
+		return 0, true
+	}
+
+	if err := storage.mkdirAll(entryPath[:strings.LastIndex(entryPath, separator)]); err != nil {
+		log.Println(logPrefixArchive, color.HiRedString("Failed to create folder for \"%s\": %s", entryPath, err))
+		return 0, false
+	}
+	if exists, err := storage.exists(entryPath); exists || err != nil {
+		return 0, err == nil
+	}
+	if err := writeStorageFile(storage, entryPath, entry.path, file.Modified); err != nil {
+		log.Println(logPrefixArchive, color.HiRedString("Failed to write \"%s\": %s", entryPath, err))
+		return 0, false
+	}
+
+	// Only what it shares with the archive is kept, sizes, hashes and anything done to the archive's file aren't the entry's
+	record := downloadItem{
+		URL:             source.URL,
+		Time:            time.Now(),
+		Destination:     entryPath,
+		DestinationRoot: source.DestinationRoot,
+		Filename:        path.Base(strings.ReplaceAll(entryPath, separator, "/")),
+		ChannelID:       source.ChannelID,
+		UserID:          source.UserID,
+		MessageID:       source.MessageID,
+		GuildID:         source.GuildID,
+		Content:         source.Content,
+		Hash:            entry.hash,
+		FinalURL:        source.FinalURL,
+		Size:            entry.size,
+		FileSize:        entry.size,
+		Domain:          source.Domain,
+		AltText:         source.AltText,
+		EmbedTitle:      source.EmbedTitle,
+		Artist:          source.Artist,
+		PostID:          source.PostID,
+		PostTitle:       source.PostTitle,
+		Tags:            source.Tags,
+		Reactions:       source.Reactions,
+		ReactionCount:   source.ReactionCount,
+		IsNSFW:          source.IsNSFW,
+		DownloadedFrom:  source.DownloadedFrom,
+	}
+	if err := dbInsertDownload(&record); err != nil {
+		log.Println(logPrefixArchive, color.HiRedString("Error writing to database: %s", err))
+	}
+	return 1, true
+}
+
+// Turns a zip entry name into a path relative to the extraction folder.
+// Returns false for names that would escape it ("zip slip"), absolute paths and drive letters are made relative.
+func sanitizeArchiveEntry(name string, separator string) (string, bool) {
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if part == ".." {
+			return "", false
+		}
+		for _, key := range pathBlacklist {
+			part = strings.ReplaceAll(part, key, "")
+		}
+		if part == ".." {
+			return "", false
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, separator), true
+}
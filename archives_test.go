@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testArchiveEntry struct {
+	name     string
+	contents []byte
+	claimed  int64 // uncompressed size written in the header if set, to test lying archives
+}
+
+func buildTestArchive(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		if entry.claimed == 0 {
+			file, err := writer.Create(entry.name)
+			if err == nil {
+				_, err = file.Write(entry.contents)
+			}
+			if err != nil {
+				t.Fatalf("Failed to write %s: %s", entry.name, err)
+			}
+			continue
+		}
+		var compressed bytes.Buffer
+		deflater, _ := flate.NewWriter(&compressed, flate.BestCompression)
+		deflater.Write(entry.contents)
+		deflater.Close()
+		file, err := writer.CreateRaw(&zip.FileHeader{
+			Name:               entry.name,
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(entry.contents),
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: uint64(entry.claimed),
+		})
+		if err == nil {
+			_, err = file.Write(compressed.Bytes())
+		}
+		if err != nil {
+			t.Fatalf("Failed to write %s: %s", entry.name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	defer func(store downloadStore) { downloadsStore = store }(downloadsStore)
+	downloadsStore = openTestSQLite(t)
+	defer func(entry, total int64) { archiveEntryLimit, archiveTotalLimit = entry, total }(archiveEntryLimit, archiveTotalLimit)
+
+	var channelConfig configurationChannel
+	channelDefault(&channelConfig)
+	enabled := true
+	channelConfig.ExtractArchives, channelConfig.SaveTextFiles = &enabled, &enabled
+
+	text := func(size int) []byte { return bytes.Repeat([]byte("a"), size) }
+	inner := buildTestArchive(t, []testArchiveEntry{{name: "b.txt", contents: text(100)}})
+
+	cases := []struct {
+		name          string
+		entries       []testArchiveEntry
+		entry, total  int64
+		want          []string
+		wantExtracted int
+		wantOk        bool
+	}{
+		{
+			name:    "within limits",
+			entries: []testArchiveEntry{{name: "a.txt", contents: text(100)}, {name: "inner.zip", contents: inner}},
+			entry:   1000, total: 10000,
+			want:          []string{"a.txt", filepath.Join("inner", "b.txt")},
+			wantExtracted: 2, wantOk: true,
+		},
+		{
+			name:    "entry too big",
+			entries: []testArchiveEntry{{name: "a.txt", contents: text(100)}, {name: "big.txt", contents: text(2000)}},
+			entry:   1000, total: 10000,
+			want:          []string{"a.txt"},
+			wantExtracted: 1, wantOk: false,
+		},
+		{
+			name:    "header claims less than it holds",
+			entries: []testArchiveEntry{{name: "bomb.txt", contents: text(1 << 20), claimed: 10}, {name: "a.txt", contents: text(100)}},
+			entry:   1000, total: 10000,
+			want:          []string{"a.txt"},
+			wantExtracted: 1, wantOk: false,
+		},
+		{
+			name:    "total too big",
+			entries: []testArchiveEntry{{name: "a.txt", contents: text(600)}, {name: "b.txt", contents: text(600)}, {name: "c.txt", contents: text(10)}},
+			entry:   1000, total: 1000,
+			want:          []string{"a.txt"},
+			wantExtracted: 1, wantOk: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			archiveEntryLimit, archiveTotalLimit = c.entry, c.total
+			dir := t.TempDir()
+			archivePath := filepath.Join(dir, "archive.zip")
+			extracted, ok := extractArchive(localStorage{}, archivePath, buildTestArchive(t, c.entries), channelConfig, downloadItem{URL: "https://example.com/archive.zip"})
+			if extracted != c.wantExtracted || ok != c.wantOk {
+				t.Errorf("extracted %d, ok %v, want %d, %v", extracted, ok, c.wantExtracted, c.wantOk)
+			}
+			var written []string
+			filepath.Walk(filepath.Join(dir, "archive"), func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					relative, _ := filepath.Rel(filepath.Join(dir, "archive"), path)
+					written = append(written, relative)
+				}
+				return nil
+			})
+			if strings.Join(written, ",") != strings.Join(c.want, ",") {
+				t.Errorf("wrote %v, want %v", written, c.want)
+			}
+		})
+	}
+}
+
+// Rows for extracted files get their own sizes, not the archive's, and nothing done to the archive's file.
+func TestExtractArchiveRecords(t *testing.T) {
+	defer func(store downloadStore) { downloadsStore = store }(downloadsStore)
+	downloadsStore = openTestSQLite(t)
+
+	var channelConfig configurationChannel
+	channelDefault(&channelConfig)
+	enabled := true
+	channelConfig.ExtractArchives, channelConfig.SaveTextFiles = &enabled, &enabled
+
+	sizes := map[string]int64{"a.txt": 100, "b.txt": 250}
+	archive := buildTestArchive(t, []testArchiveEntry{
+		{name: "a.txt", contents: bytes.Repeat([]byte("a"), 100)},
+		{name: "b.txt", contents: bytes.Repeat([]byte("b"), 250)},
+	})
+	source := downloadItem{
+		ID: 7, URL: "https://example.com/archive.zip", ChannelID: "1", UserID: "2", MessageID: "3",
+		Size: int64(len(archive)), FileSize: int64(len(archive)), DownloadDurationMs: 1500,
+		OriginalExtension: ".rar", LinkedTo: "elsewhere.zip", BlobPath: "blobs/archive",
+		OriginalSize: 5000, CompressedSize: 4000, ThumbnailPath: "thumbs/archive.jpg",
+	}
+	archivePath := filepath.Join(t.TempDir(), "archive.zip")
+	if extracted, ok := extractArchive(localStorage{}, archivePath, archive, channelConfig, source); extracted != 2 || !ok {
+		t.Fatalf("extracted %d, ok %v, want 2, true", extracted, ok)
+	}
+
+	records := dbFindDownloadByURL(source.URL)
+	if len(records) != 2 {
+		t.Fatalf("%d rows inserted, want 2", len(records))
+	}
+	for _, record := range records {
+		want := sizes[record.Filename]
+		if record.Size != want || record.FileSize != want {
+			t.Errorf("%s recorded as %d/%d bytes, want %d", record.Filename, record.Size, record.FileSize, want)
+		}
+		if record.ID == source.ID || record.DownloadDurationMs != 0 || record.OriginalExtension != "" || record.LinkedTo != "" ||
+			record.BlobPath != "" || record.OriginalSize != 0 || record.CompressedSize != 0 || record.ThumbnailPath != "" {
+			t.Errorf("%s kept the archive's details: %+v", record.Filename, record)
+		}
+		if record.ChannelID != source.ChannelID || record.MessageID != source.MessageID {
+			t.Errorf("%s lost the archive's message: %+v", record.Filename, record)
+		}
+	}
+	if total := dbDownloadSizeByChannel(source.ChannelID, "", time.Time{}); total != 350 {
+		t.Errorf("Channel total is %d bytes, want 350", total)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// Site handlers that know who made a post record it along with the links they found, for divideFoldersByArtist,
+// the {artist}, {postID} and {postTitle} filenameFormat tokens and the database. Links no handler knows the post of
+// fall back to the Discord user who posted them for folders, and leave the tokens empty.
+
+// Kept for links found since, dropped all at once when there are too many. History runs use them straight away.
+const sitePostsMaxEntries = 50000
+
+type sitePost struct {
+	Artist    string // the account's handle or name on the site
+	PostID    string
+	PostTitle string
+}
+
+var (
+	sitePosts      = make(map[string]sitePost) // by link found
+	sitePostsMutex sync.Mutex
+)
+
+// Records the post for every link a handler found in it. Links another handler already knew the post of, like
+// a tweet linked from a Reddit post, keep that one.
+func setSitePost(links map[string]string, post sitePost) {
+	post.Artist = strings.TrimPrefix(strings.TrimSpace(post.Artist), "@")
+	post.PostTitle = strings.TrimSpace(post.PostTitle)
+	if post == (sitePost{}) {
+		return
+	}
+	sitePostsMutex.Lock()
+	defer sitePostsMutex.Unlock()
+	if len(sitePosts)+len(links) > sitePostsMaxEntries {
+		sitePosts = make(map[string]sitePost)
+	}
+	for link := range links {
+		if _, exists := sitePosts[link]; !exists {
+			sitePosts[link] = post
+		}
+	}
+}
+
+// The post a link was found in, empty if no handler knew.
+func getSitePost(link string) sitePost {
+	sitePostsMutex.Lock()
+	defer sitePostsMutex.Unlock()
+	return sitePosts[link]
+}
+
+// The artist's folder, or the Discord user's like divideFoldersByUser's when no handler knew who made it.
+func getArtistFolder(download downloadRequestStruct) string {
+	author := download.Message.Author
+	fallback := author.ID
+	if author.Username != "" {
+		fallback = sanitizeFolderName(author.Username+"#"+author.Discriminator, author.ID)
+	}
+	if download.Post.Artist == "" {
+		return fallback
+	}
+	return sanitizeFolderName(download.Post.Artist, fallback)
+}
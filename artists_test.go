@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// What handlers find about a post makes it to the artist folder and the filename tokens, and links without a
+// post fall back to whoever posted them on Discord.
+func TestArtistFromHandlers(t *testing.T) {
+	serveFixtures(t, fixtureRoutes{
+		"www.reddit.com/r/aww/comments/def456/my_cat.json": "reddit/image.json",
+		"mastodon.social/@artist/109876543210.json":        "mastodon/post.json",
+		"www.instagram.com/p/PiCtUrE/":                     "instagram/picture.html",
+		"api.streamable.com/videos/abc12":                  "streamable/video.json",
+	})
+
+	tests := []struct {
+		inputURL     string
+		wantFolder   string
+		wantFilename string
+	}{
+		{"https://www.reddit.com/r/aww/comments/def456/my_cat", "poster", "poster - def456 - My cat - file.jpg"},
+		{"https://mastodon.social/@artist/109876543210", "artist", "artist - 109876543210 - file.jpg"},
+		{"https://www.instagram.com/p/PiCtUrE/", "painter", "painter - PiCtUrE - file.jpg"},
+		// Streamable doesn't say who made it
+		{"https://streamable.com/abc12", "uploader#0001", "file.jpg"},
+	}
+	for _, test := range tests {
+		links := resolveDownloadLinks(test.inputURL, "", true, 0)
+		if len(links) == 0 {
+			t.Errorf("Nothing found for %s", test.inputURL)
+			continue
+		}
+		for link := range links {
+			download := downloadRequestStruct{
+				InputURL: link,
+				Filename: "file.jpg",
+				Message:  &discordgo.Message{Author: &discordgo.User{ID: "42", Username: "uploader", Discriminator: "0001"}},
+				Post:     getSitePost(link),
+			}
+			if folder := getArtistFolder(download); folder != test.wantFolder {
+				t.Errorf("%s went in folder %q, want %q", link, folder, test.wantFolder)
+			}
+			if filename := formatFilename("{artist} - {postID} - {postTitle} - {filename}", "", download); filename != test.wantFilename {
+				t.Errorf("%s was named %q, want %q", link, filename, test.wantFilename)
+			}
+		}
+	}
+}
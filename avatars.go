@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Avatars and server images are saved whenever they change in a tracked server, each hash only once.
+
+var logPrefixAvatars = color.HiMagentaString("[Avatars]")
+
+const avatarTimeFormat = "2006-01-02_15-04-05"
+
+func isAvatarTrackedGuild(guildID string) bool {
+	settings := getConfig()
+	return settings.AvatarTracking != nil && stringInSlice(guildID, settings.AvatarTracking.Guilds)
+}
+
+// Saves an image to the folder unless its hash was saved before. Returns true if it was saved.
+func saveTrackedImage(kind string, ownerID string, hash string, imageURL string, folder string) (bool, error) {
+	key := kind + ":" + ownerID + ":" + hash
+	if dbHasAvatar(key) {
+		return false, nil
+	}
+
+	storage, err := getStorageBackend(folder)
+	if err != nil {
+		return false, err
+	}
+	folder = strings.TrimSuffix(folder, storage.separator()) + storage.separator()
+	if err := storage.mkdirAll(folder); err != nil {
+		return false, err
+	}
+
+	response, err := getHTTPClient(imageURL).Get(imageURL)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s returned %s", imageURL, response.Status)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, err
+	}
+
+	extension := ".png"
+	if strings.HasPrefix(hash, "a_") {
+		extension = ".gif"
+	}
+	now := time.Now()
+	path := folder + strings.TrimSpace(kind+" "+now.Format(avatarTimeFormat)+" "+hash) + extension
+	if kind == "avatar" {
+		path = folder + now.Format(avatarTimeFormat) + " " + hash + extension
+	}
+	if err := storage.write(path, data, now); err != nil {
+		return false, err
+	}
+	if err := dbInsertAvatar(key, path); err != nil {
+		log.Println(logPrefixAvatars, color.HiRedString("Saved \"%s\" but failed to record it:\t%s", path, err))
+	}
+	return true, nil
+}
+
+// Saves a user's current avatar at full size into their own folder.
+func saveUserAvatar(u *discordgo.User) (bool, error) {
+	if u == nil || u.Avatar == "" {
+		return false, nil
+	}
+	saved, err := saveTrackedImage("avatar", u.ID, u.Avatar, u.AvatarURL("4096"), filepath.Join(getConfig().AvatarTracking.Destination, u.ID))
+	if saved {
+		log.Println(logPrefixAvatars, color.HiGreenString("Saved new avatar of %s", getUserIdentifier(*u)))
+	}
+	return saved, err
+}
+
+// Saves a server's current icon, banner and splash. Returns how many were new.
+func saveGuildImages(g *discordgo.Guild) (int, error) {
+	settings := getConfig()
+	if g == nil || !*settings.AvatarTracking.GuildImages {
+		return 0, nil
+	}
+	folder := filepath.Join(settings.AvatarTracking.Destination, "Server "+g.ID)
+	images := []struct {
+		kind, hash, url string
+	}{
+		{"icon", g.Icon, ""},
+		{"banner", g.Banner, discordgo.EndpointGuildBanner(g.ID, g.Banner)},
+		{"splash", g.Splash, discordgo.EndpointGuildSplash(g.ID, g.Splash)},
+	}
+	if g.Icon != "" {
+		images[0].url = g.IconURL()
+	}
+	count := 0
+	var lastErr error
+	for _, image := range images {
+		if image.hash == "" {
+			continue
+		}
+		if strings.HasPrefix(image.hash, "a_") {
+			image.url = strings.TrimSuffix(image.url, ".png") + ".gif"
+		}
+		saved, err := saveTrackedImage(image.kind, g.ID, image.hash, image.url+"?size=4096", folder)
+		if err != nil {
+			log.Println(logPrefixAvatars, color.HiRedString("Failed to save %s of server %s:\t%s", image.kind, g.ID, err))
+			lastErr = err
+		} else if saved {
+			log.Println(logPrefixAvatars, color.HiGreenString("Saved new %s of \"%s\"", image.kind, g.Name))
+			count++
+		}
+	}
+	return count, lastErr
+}
+
+//#region Events
+
+func avatarMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if m.Member == nil || !isAvatarTrackedGuild(m.GuildID) {
+		return
+	}
+	if _, err := saveUserAvatar(m.User); err != nil {
+		log.Println(logPrefixAvatars, color.HiRedString("Failed to save avatar of %s:\t%s", m.User.ID, err))
+	}
+}
+
+// Only sent for the bot's own account.
+func avatarUserUpdate(s *discordgo.Session, u *discordgo.UserUpdate) {
+	settings := getConfig()
+	if settings.AvatarTracking == nil || u.User == nil {
+		return
+	}
+	for _, guildID := range settings.AvatarTracking.Guilds {
+		if _, err := bot.State.Member(guildID, u.ID); err == nil {
+			if _, err := saveUserAvatar(u.User); err != nil {
+				log.Println(logPrefixAvatars, color.HiRedString("Failed to save avatar of %s:\t%s", u.ID, err))
+			}
+			return
+		}
+	}
+}
+
+func avatarGuildUpdate(s *discordgo.Session, g *discordgo.GuildUpdate) {
+	if g.Guild == nil || !isAvatarTrackedGuild(g.ID) {
+		return
+	}
+	saveGuildImages(g.Guild)
+}
+
+//#endregion
+
+// Saves every member's current avatar and the server's images, for the avatars command.
+func sweepGuildAvatars(guildID string) (saved int, unchanged int, failed int, err error) {
+	guild, err := bot.Guild(guildID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if count, err := saveGuildImages(guild); err != nil {
+		failed++
+	} else {
+		saved += count
+	}
+
+	after := ""
+	for {
+		members, err := bot.GuildMembers(guildID, after, 1000)
+		if err != nil {
+			return saved, unchanged, failed, err
+		}
+		for _, member := range members {
+			if member.User == nil || member.User.Avatar == "" {
+				continue
+			}
+			if ok, err := saveUserAvatar(member.User); err != nil {
+				log.Println(logPrefixAvatars, color.HiRedString("Failed to save avatar of %s:\t%s", member.User.ID, err))
+				failed++
+			} else if ok {
+				saved++
+			} else {
+				unchanged++
+			}
+		}
+		if len(members) < 1000 {
+			return saved, unchanged, failed, nil
+		}
+		after = members[len(members)-1].User.ID
+	}
+}
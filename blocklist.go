@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// URLs and domains in the blocklistFile are never looked up or downloaded. One entry per line: an exact URL,
+// a domain (subdomains included), a wildcard domain like *.grabify.link, or a regex on the whole URL after "re:".
+// Blank lines and lines starting with # are skipped.
+
+var logPrefixBlocklist = color.HiCyanString("[Blocklist]")
+
+type blocklistEntry struct {
+	url     string
+	domain  string
+	pattern *regexp.Regexp // wildcard domains are matched against the host, regexes against the URL
+	host    bool
+}
+
+var (
+	blocklist          []blocklistEntry
+	blocklistPath      string // what blocklist was loaded from, reloaded when the setting changes
+	blocklistMutex     sync.RWMutex
+	blocklistWatcher   *fsnotify.Watcher
+	blocklistWatchOnce sync.Once
+)
+
+func parseBlocklistEntry(line string) (*blocklistEntry, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	if strings.HasPrefix(line, "re:") {
+		pattern, err := regexp.Compile(strings.TrimPrefix(line, "re:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex \"%s\": %s", line, err)
+		}
+		return &blocklistEntry{pattern: pattern}, nil
+	}
+	if strings.Contains(line, "://") {
+		return &blocklistEntry{url: line}, nil
+	}
+	domain := strings.ToLower(strings.TrimSuffix(line, "/"))
+	if strings.Contains(domain, "/") {
+		return nil, fmt.Errorf("\"%s\" isn't a URL, domain or regex", line)
+	}
+	if strings.Contains(domain, "*") {
+		// *.example.com covers example.com itself too
+		expression := strings.ReplaceAll(regexp.QuoteMeta(domain), `\*`, `.*`)
+		if strings.HasPrefix(domain, "*.") {
+			expression = `(.*\.)?` + strings.TrimPrefix(expression, `.*\.`)
+		}
+		return &blocklistEntry{pattern: regexp.MustCompile("^" + expression + "$"), host: true}, nil
+	}
+	return &blocklistEntry{domain: domain}, nil
+}
+
+func (entry blocklistEntry) matches(inputURL string, host string) bool {
+	switch {
+	case entry.url != "":
+		return inputURL == entry.url
+	case entry.domain != "":
+		return host == entry.domain || strings.HasSuffix(host, "."+entry.domain)
+	case entry.host:
+		return entry.pattern.MatchString(host)
+	default:
+		return entry.pattern.MatchString(inputURL)
+	}
+}
+
+func loadBlocklist(path string) {
+	var entries []blocklistEntry
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(logPrefixBlocklist, color.HiRedString("Failed to open \"%s\":\t%s", path, err))
+		} else if err == nil {
+			scanner := bufio.NewScanner(file)
+			for line := 1; scanner.Scan(); line++ {
+				entry, err := parseBlocklistEntry(scanner.Text())
+				if err != nil {
+					log.Println(logPrefixBlocklist, color.HiRedString("Skipping line %d:\t%s", line, err))
+				} else if entry != nil {
+					entries = append(entries, *entry)
+				}
+			}
+			file.Close()
+			log.Println(logPrefixBlocklist, color.CyanString("Loaded %d pattern%s from \"%s\"", len(entries), pluralS(len(entries)), path))
+		}
+		watchBlocklist(path)
+	}
+	blocklistMutex.Lock()
+	blocklist, blocklistPath = entries, path
+	blocklistMutex.Unlock()
+}
+
+// Watches the file's folder rather than the file, editors often save by replacing it.
+func watchBlocklist(path string) {
+	blocklistWatchOnce.Do(func() {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Println(color.HiRedString("[Watchers] Error creating NewWatcher:\t%s", err))
+			return
+		}
+		blocklistWatcher = watcher
+		go func() {
+			for {
+				select {
+				case event, ok := <-watcher.Events:
+					if !ok {
+						return
+					}
+					blocklistMutex.RLock()
+					current := blocklistPath
+					blocklistMutex.RUnlock()
+					if current != "" && filepath.Clean(event.Name) == filepath.Clean(current) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+						log.Println(logPrefixBlocklist, color.YellowString("Detected changes in \"%s\", reloading...", current))
+						loadBlocklist(current)
+					}
+				case err, ok := <-watcher.Errors:
+					if !ok {
+						return
+					}
+					log.Println(color.HiRedString("[Watchers] Error:\t%s", err))
+				}
+			}
+		}()
+	})
+	if blocklistWatcher != nil {
+		if err := blocklistWatcher.Add(filepath.Dir(path)); err != nil {
+			log.Println(color.HiRedString("[Watchers] Error adding watcher for blocklist:\t%s", err))
+		}
+	}
+}
+
+// Whether a URL is on the blocklist, loading it first if the setting's changed.
+func isBlocklisted(inputURL string) bool {
+	settings := getConfig()
+	blocklistMutex.RLock()
+	loaded := blocklistPath == settings.BlocklistFile
+	blocklistMutex.RUnlock()
+	if !loaded {
+		loadBlocklist(settings.BlocklistFile)
+	}
+
+	blocklistMutex.RLock()
+	defer blocklistMutex.RUnlock()
+	if len(blocklist) == 0 {
+		return false
+	}
+	host := ""
+	if u, err := url.Parse(inputURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, entry := range blocklist {
+		if entry.matches(inputURL, host) {
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Ignoring blocklisted URL %s", inputURL))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Appends an entry to the blocklistFile, it's picked up by the watcher or the next check.
+func addBlocklistEntry(line string) error {
+	settings := getConfig()
+	if settings.BlocklistFile == "" {
+		return fmt.Errorf("blocklistFile isn't set")
+	}
+	entry, err := parseBlocklistEntry(line)
+	if err != nil {
+		return err
+	} else if entry == nil {
+		return fmt.Errorf("nothing to add")
+	}
+	if dir := filepath.Dir(settings.BlocklistFile); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(settings.BlocklistFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.TrimSpace(line) + "\n"); err != nil {
+		return err
+	}
+	blocklistMutex.Lock()
+	if blocklistPath == settings.BlocklistFile {
+		blocklist = append(blocklist, *entry)
+	}
+	blocklistMutex.Unlock()
+	return nil
+}
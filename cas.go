@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// With storageMode "cas" each file is saved once under casPath by its SHA-256, e.g. files/ab/cd/abcdef....jpg,
+// and the usual destination folders are filled with symlinks to it. The mode applies per channel, files already
+// saved the plain way are left as they are and the database says which is which.
+
+var logPrefixCAS = color.HiCyanString("[CAS]")
+
+const (
+	storageModePlain = "plain"
+	storageModeCAS   = "cas"
+	casPathDefault   = "files"
+	// Blobs written or reused more recently than this are left alone by gc, their rows may not be in yet
+	casGCGracePeriod = time.Hour
+)
+
+var storageModes = []string{storageModePlain, storageModeCAS}
+
+// Held while placing a blob, so gc can't take one out from under a download
+var casMutex sync.RWMutex
+
+// Storage mode for a channel's downloads. Remote destinations can't hold symlinks, so they're always plain.
+func getStorageMode(channelConfig configurationChannel, destination string) string {
+	mode := getConfig().StorageMode
+	if channelConfig.StorageMode != nil {
+		mode = *channelConfig.StorageMode
+	}
+	if mode != storageModeCAS || isRemoteDestination(destination) {
+		return storageModePlain
+	}
+	return mode
+}
+
+func getCASRoot() string {
+	settings := getConfig()
+	root := settings.CASPath
+	if root == "" {
+		root = casPathDefault
+	}
+	return applyBasePath(settings.BasePath, root)
+}
+
+// Where a file with this hash is kept, the extension's kept so blobs can still be opened on their own.
+func getBlobPath(hash string, extension string) string {
+	return filepath.Join(getCASRoot(), hash[0:2], hash[2:4], hash+strings.ToLower(extension))
+}
+
+// Saves data as a blob if it isn't one already and links path to it.
+// Returns the blob's path, and whether it had to be written.
+func placeBlob(path string, data []byte, hash string) (string, bool, error) {
+	casMutex.RLock()
+	defer casMutex.RUnlock()
+
+	blobPath := getBlobPath(hash, filepathExtension(path))
+	written := false
+	if _, err := os.Stat(blobPath); os.IsNotExist(err) {
+		if err := writeBlob(blobPath, data); err != nil {
+			return "", false, err
+		}
+		written = true
+	} else if err != nil {
+		return "", false, err
+	} else {
+		// Reused blobs count as new for gc's grace period
+		now := time.Now()
+		os.Chtimes(blobPath, now, now)
+	}
+
+	if _, err := linkDuplicate(blobPath, path, "symlink"); err != nil {
+		return "", false, err
+	}
+	return blobPath, written, nil
+}
+
+// Written beside where it's going under a name of its own, so two downloads of the same file can't mix.
+func writeBlob(blobPath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(blobPath), ".blob-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		log.Println(logPrefixCAS, color.RedString("Error while setting permissions on \"%s\": %s", blobPath, err))
+	}
+	return os.Rename(temp.Name(), blobPath)
+}
+
+// Deletes blobs no download row refers to anymore. Returns how many went and the bytes freed.
+func collectBlobGarbage() (int, int64, error) {
+	casMutex.Lock()
+	defer casMutex.Unlock()
+
+	root := getCASRoot()
+	referenced := make(map[string]bool)
+	for _, blobPath := range dbAllBlobPaths() {
+		referenced[filepath.Clean(blobPath)] = true
+	}
+
+	removed, freed := 0, int64(0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || referenced[filepath.Clean(path)] || time.Since(info.ModTime()) < casGCGracePeriod {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Println(logPrefixCAS, color.HiRedString("Failed to delete \"%s\":\t%s", path, err))
+			return nil
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	log.Println(logPrefixCAS, color.CyanString("Deleted %d unreferenced blob%s from \"%s\", %s freed", removed, pluralS(removed), root, formatBytes(freed)))
+	return removed, freed, err
+}
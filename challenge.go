@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Sites behind Cloudflare sometimes answer with a challenge page instead of the file. Those are failed without
+// retrying, unless flaresolverrURL is set, then a FlareSolverr instance gets past the challenge once and the
+// clearance cookies it ends up with are sent with direct requests to the domain until they expire.
+
+var logPrefixChallenge = color.HiYellowString("[Challenge]")
+
+const (
+	// FlareSolverr runs a browser, so this is well over the download timeout
+	flaresolverrTimeout = 90 * time.Second
+	// Cookies are kept at most this long, less if they expire sooner
+	challengeClearanceDefault = 30 * time.Minute
+)
+
+// Cookies only work with the user agent that got them.
+type challengeClearance struct {
+	cookies   []*http.Cookie
+	userAgent string
+	expires   time.Time
+}
+
+var (
+	challengeClearances      = make(map[string]challengeClearance) // keyed by domain
+	challengeClearancesMutex sync.Mutex
+)
+
+// Whether the response is a challenge page rather than what was asked for.
+func isBotChallenge(response *http.Response, body []byte) bool {
+	if response.Header.Get("Cf-Mitigated") == "challenge" {
+		return true
+	}
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		return false
+	}
+	if response.StatusCode == http.StatusForbidden && response.Header.Get("Cf-Ray") != "" {
+		return true
+	}
+	text := strings.ToLower(string(body))
+	return strings.Contains(text, "<title>just a moment...</title>") || strings.Contains(text, "/cdn-cgi/challenge-platform/")
+}
+
+// Adds the domain's clearance cookies to a request, if a challenge was solved for it and they haven't expired.
+func applyChallengeClearance(request *http.Request) {
+	if getConfig().FlareSolverrURL == "" {
+		return
+	}
+	challengeClearancesMutex.Lock()
+	clearance, exists := challengeClearances[request.URL.Hostname()]
+	if exists && time.Now().After(clearance.expires) {
+		delete(challengeClearances, request.URL.Hostname())
+		exists = false
+	}
+	challengeClearancesMutex.Unlock()
+	if !exists {
+		return
+	}
+	for _, cookie := range clearance.cookies {
+		request.AddCookie(cookie)
+	}
+	if clearance.userAgent != "" {
+		request.Header.Set("User-Agent", clearance.userAgent)
+	}
+}
+
+//#region FlareSolverr
+
+type flaresolverrRequest struct {
+	Cmd        string `json:"cmd"`
+	URL        string `json:"url"`
+	MaxTimeout int    `json:"maxTimeout"`
+	Proxy      *struct {
+		URL string `json:"url"`
+	} `json:"proxy,omitempty"`
+}
+
+type flaresolverrResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	Solution struct {
+		Status    int    `json:"status"`
+		UserAgent string `json:"userAgent"`
+		Cookies   []struct {
+			Name    string  `json:"name"`
+			Value   string  `json:"value"`
+			Expires float64 `json:"expires"` // seconds since the epoch, -1 for session cookies
+		} `json:"cookies"`
+	} `json:"solution"`
+}
+
+// Has FlareSolverr open the link and keeps the cookies it gets for the domain. Returns whether it got past the
+// challenge, always false without flaresolverrURL.
+func solveBotChallenge(inputURL string) bool {
+	if getConfig().FlareSolverrURL == "" {
+		return false
+	}
+	parsedURL, err := url.Parse(inputURL)
+	if err != nil {
+		return false
+	}
+	domain := parsedURL.Hostname()
+	started := time.Now()
+	clearance, err := requestFlareSolverr(inputURL, getProxyForHost(domain))
+	if err != nil {
+		log.Println(logPrefixChallenge, color.HiRedString("FlareSolverr couldn't get past the challenge for %s:\t%s", inputURL, err))
+		return false
+	}
+	challengeClearancesMutex.Lock()
+	challengeClearances[domain] = clearance
+	challengeClearancesMutex.Unlock()
+	log.Println(logPrefixChallenge, color.GreenString("FlareSolverr got past the challenge for %s in %s, cookies kept until %s",
+		domain, time.Since(started).Round(time.Second), clearance.expires.Format("15:04:05")))
+	return true
+}
+
+func requestFlareSolverr(inputURL string, proxy string) (challengeClearance, error) {
+	clearance := challengeClearance{expires: time.Now().Add(challengeClearanceDefault)}
+	payload := flaresolverrRequest{Cmd: "request.get", URL: inputURL, MaxTimeout: int(flaresolverrTimeout / time.Millisecond)}
+	if proxy != "" {
+		payload.Proxy = &struct {
+			URL string `json:"url"`
+		}{proxy}
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return clearance, err
+	}
+	client := &http.Client{Timeout: flaresolverrTimeout + 10*time.Second}
+	response, err := client.Post(strings.TrimSuffix(getConfig().FlareSolverrURL, "/")+"/v1", "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return clearance, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return clearance, err
+	}
+	var result flaresolverrResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return clearance, fmt.Errorf("%s from FlareSolverr, %s", response.Status, err)
+	}
+	if result.Status != "ok" {
+		return clearance, errors.New(result.Message)
+	}
+	if result.Solution.Status >= 400 {
+		return clearance, fmt.Errorf("page still answered %d", result.Solution.Status)
+	}
+
+	clearance.userAgent = result.Solution.UserAgent
+	for _, cookie := range result.Solution.Cookies {
+		clearance.cookies = append(clearance.cookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		// Kept until the first of them expires
+		if cookie.Expires > 0 {
+			if expires := time.Unix(int64(cookie.Expires), 0); expires.Before(clearance.expires) {
+				clearance.expires = expires
+			}
+		}
+	}
+	if len(clearance.cookies) == 0 {
+		return clearance, errors.New("no cookies came back")
+	}
+	return clearance, nil
+}
+
+//#endregion
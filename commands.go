@@ -4,16 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Necroforger/dgrouter/exrouter"
 	"github.com/bwmarrin/discordgo"
 	"github.com/fatih/color"
 	"github.com/hako/durafmt"
-	"github.com/kennygrant/sanitize"
+	"mvdan.cc/xurls/v2"
 )
 
 // Multiple use messages to save space and make cleaner.
@@ -23,12 +25,17 @@ const (
 		"\nTo use this command you must:" +
 		"\n• Be set as a bot administrator (in the settings)" +
 		"\n• Own this Discord Server" +
-		"\n• Have Server Administrator Permissions"
-	cmderrLackingBotAdminPerms = "You do not have permission to use this command. Your User ID must be set as a bot administrator in the settings file."
+		"\n• Have Server Administrator Permissions" +
+		"\n• Have one of this server's admin roles (in the settings)"
+	cmderrLackingBotAdminPerms = "You do not have permission to use this command. Your User ID must be set as a bot administrator in the settings file, or you need one of this server's admin roles."
 	cmderrChannelNotRegistered = "Specified channel is not registered in the bot settings."
 	cmderrHistoryCancelled     = "History cataloging was cancelled."
+	cmderrCommandsNotAllowed   = "Commands aren't allowed in this channel."
 )
 
+// Most results the search command lists
+const searchResultLimit = 10
+
 func handleCommands() *exrouter.Route {
 	router := exrouter.New()
 
@@ -87,7 +94,7 @@ func handleCommands() *exrouter.Route {
 			if isGlobalCommandAllowed(ctx.Msg) {
 				text := ""
 				for _, cmd := range router.Routes {
-					if cmd.Category != "Admin" || isBotAdmin(ctx.Msg) {
+					if cmd.Category != "Admin" || isAdmin(ctx.Msg) {
 						text += fmt.Sprintf("• \"%s\" : %s",
 							cmd.Name,
 							cmd.Description,
@@ -98,7 +105,7 @@ func handleCommands() *exrouter.Route {
 						text += "\n\n"
 					}
 				}
-				_, err := replyEmbed(ctx.Msg, "Command — Help", fmt.Sprintf("Use commands as ``\"%s<command> <arguments?>\"``\n```%s```\n%s", config.CommandPrefix, text, projectRepoURL))
+				_, err := replyEmbed(ctx.Msg, "Command — Help", fmt.Sprintf("Use commands as ``\"%s<command> <arguments?>\"``\n```%s```\n%s", getConfig().CommandPrefix, text, projectRepoURL))
 				// Failed to send
 				if err != nil {
 					log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
@@ -115,24 +122,94 @@ func handleCommands() *exrouter.Route {
 	//#region Info Commands
 
 	router.On("status", func(ctx *exrouter.Context) {
+		settings := getConfig()
 		logPrefixHere := color.CyanString("[dgrouter:status]")
 		if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
 			if isCommandableChannel(ctx.Msg) {
+				if !isAdmin(ctx.Msg) {
+					replyUnauthorized(ctx.Msg, "Command — Status", cmderrLackingBotAdminPerms)
+					log.Println(logPrefixHere, color.HiCyanString("%s tried to view status but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+					return
+				}
+				snapshot := getStatusSnapshot()
+				waiting := snapshot.inProgress - int64(snapshot.connected)
+				if waiting < 0 {
+					waiting = 0
+				}
 				message := fmt.Sprintf("• **Uptime —** %s\n"+
 					"• **Started at —** %s\n"+
 					"• **Joined Servers —** %d\n"+
 					"• **Bound Channels —** %d\n"+
 					"• **Bound Servers —** %d\n"+
 					"• **Admin Channels —** %d\n"+
-					"• **Heartbeat Latency —** %dms",
+					"• **Heartbeat Latency —** %dms\n"+
+					"• **Download Speed —** %s/s _(limit: %s)_\n"+
+					"• **Active Downloads —** %d _(%d waiting for a connection)_\n"+
+					"• **Queued Auto Histories —** %d\n"+
+					"• **Database —** %s entries, %s\n"+
+					"• **Image Filter —** %s images, ~%s _(%s average query)_",
 					durafmt.Parse(time.Since(startTime)).String(),
 					startTime.Format("03:04:05pm on Monday, January 2, 2006 (MST)"),
 					len(bot.State.Guilds),
 					getBoundChannelsCount(),
 					getBoundServersCount(),
-					len(config.AdminChannels),
+					len(settings.AdminChannels),
 					bot.HeartbeatLatency().Milliseconds(),
+					formatBytes(atomic.LoadInt64(&downloadThroughput)), downloadSpeedLimitLabel(),
+					snapshot.connected, waiting,
+					snapshot.autoHistory,
+					formatNumber(snapshot.dbRows), formatBytes(snapshot.databaseSize),
+					formatNumber(int64(snapshot.imgStoreCount)), formatBytes(snapshot.imgStoreMemory),
+					snapshot.imgStoreQuery.Round(time.Microsecond),
 				)
+				if snapshot.pendingRecovered > 0 || snapshot.pendingDropped > 0 {
+					message += fmt.Sprintf("\n• **Recovered at Launch —** %s downloads _(%s dropped)_",
+						formatNumber(snapshot.pendingRecovered), formatNumber(snapshot.pendingDropped))
+				}
+				if settings.Schedule != nil || snapshot.deferred > 0 {
+					window := "window open now"
+					if !snapshot.nextWindow.IsZero() {
+						window = "next window " + formatScheduleWindow(snapshot.nextWindow)
+					}
+					message += fmt.Sprintf("\n• **Deferred Downloads —** %s _(%s)_", formatNumber(snapshot.deferred), window)
+				}
+				message += "\n• **History Pacing —** " + getHistoryPacingLabel()
+				if len(snapshot.statusCounts) > 0 {
+					statuses := make([]downloadStatus, 0, len(snapshot.statusCounts))
+					for status := range snapshot.statusCounts {
+						statuses = append(statuses, status)
+					}
+					sort.Slice(statuses, func(i, j int) bool { return statuses[i] < statuses[j] })
+					message += "\n\n**This Session:**"
+					for _, status := range statuses {
+						message += fmt.Sprintf("\n• %s — %s", getDownloadStatusString(status), formatNumber(snapshot.statusCounts[status]))
+					}
+				}
+				if len(snapshot.destinations) > 0 {
+					message += fmt.Sprintf("\n\n**Free Space** _(as of %s ago)_**:**", durafmt.ParseShort(time.Since(snapshot.gaugesUpdated)))
+					for i, destination := range snapshot.destinations {
+						if i == 10 {
+							message += fmt.Sprintf("\n_...and %d more_", len(snapshot.destinations)-i)
+							break
+						}
+						free := "unknown"
+						if destination.free >= 0 {
+							free = formatBytes(destination.free)
+							if minimum, err := parseByteSize(settings.MinimumFreeSpace); settings.MinimumFreeSpace != "" && err == nil && destination.free < minimum {
+								free += " ⚠️ _under minimumFreeSpace_"
+							}
+						}
+						message += fmt.Sprintf("\n• `%s` — %s", destination.path, free)
+					}
+				}
+				if len(snapshot.historyRunning) > 0 {
+					message += "\n\n**Running Histories:**"
+					for _, history := range snapshot.historyRunning {
+						message += fmt.Sprintf("\n• %s — %s files from %s messages in %s",
+							history.channelName, formatNumber(history.files), formatNumber(history.messages),
+							durafmt.ParseShort(time.Since(history.started)))
+					}
+				}
 				if isChannelRegistered(ctx.Msg.ChannelID) {
 					configJson, _ := json.MarshalIndent(getChannelConfig(ctx.Msg.ChannelID), "", "\t")
 					message = message + fmt.Sprintf("\n• **Channel Settings...** ```%s```", string(configJson))
@@ -147,19 +224,31 @@ func handleCommands() *exrouter.Route {
 		} else {
 			log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
 		}
-	}).Cat("Info").Desc("Displays info regarding the current status of the bot")
+	}).Cat("Admin").Desc("Displays info regarding the current status of the bot")
 
 	router.On("stats", func(ctx *exrouter.Context) {
 		logPrefixHere := color.CyanString("[dgrouter:stats]")
 		if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
 			if isChannelRegistered(ctx.Msg.ChannelID) {
 				channelConfig := getChannelConfig(ctx.Msg.ChannelID)
-				if *channelConfig.AllowCommands {
+				if *channelConfig.AllowCommands && !isAdmin(ctx.Msg) {
+					replyUnauthorized(ctx.Msg, "Command — Stats", cmderrLackingBotAdminPerms)
+					log.Println(logPrefixHere, color.HiCyanString("%s tried to view stats but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+				} else if *channelConfig.AllowCommands {
 					content := fmt.Sprintf("• **Total Downloads —** %s\n"+
-						"• **Downloads in this Channel —** %s",
+						"• **Downloads in this Channel —** %s\n"+
+						"• **Downloaded this Session —** %s",
 						formatNumber(int64(dbDownloadCount())),
 						formatNumber(int64(dbDownloadCountByChannel(ctx.Msg.ChannelID))),
+						formatBytes(atomic.LoadInt64(&downloadBytesTotal)),
 					)
+					if quotas := getQuotaStats(channelConfig, ctx.Msg.ChannelID); quotas != "" {
+						content += "\n\n" + quotas
+					}
+					content += "\n\n" + getTransferStats(ctx.Msg.ChannelID)
+					if ignored := getIgnoredStats(); ignored != "" {
+						content += "\n" + ignored
+					}
 					//TODO: Count in channel by users
 					_, err := replyEmbed(ctx.Msg, "Command — Stats", content)
 					// Failed to send
@@ -167,12 +256,75 @@ func handleCommands() *exrouter.Route {
 						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
 					}
 					log.Println(logPrefixHere, color.HiCyanString("%s requested stats", getUserIdentifier(*ctx.Msg.Author)))
+				} else {
+					rejectCommandInteraction(ctx.Msg, cmderrCommandsNotAllowed)
 				}
+			} else {
+				rejectCommandInteraction(ctx.Msg, cmderrChannelNotRegistered)
 			}
 		} else {
 			log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
 		}
-	}).Cat("Info").Desc("Outputs statistics regarding this channel")
+	}).Cat("Admin").Desc("Outputs statistics regarding this channel")
+
+	router.On("search", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:search]")
+		if isCommandableChannel(ctx.Msg) {
+			if isAdmin(ctx.Msg) || isLocalAdmin(ctx.Msg) {
+				var words []string
+				var tag string
+				channelID := ctx.Msg.ChannelID
+				for k, v := range ctx.Args {
+					if k == 0 { // "search"
+						continue
+					}
+					if strings.HasPrefix(v, "--tag=") {
+						tag = strings.TrimPrefix(v, "--tag=")
+					} else if v == "--all" {
+						channelID = ""
+					} else {
+						words = append(words, v)
+					}
+				}
+				if channelID == "" && !isAdmin(ctx.Msg) {
+					replyUnauthorized(ctx.Msg, "Command — Search", cmderrLackingBotAdminPerms)
+					log.Println(logPrefixHere, color.HiCyanString("%s tried to search every channel but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+					return
+				}
+				query := strings.Join(words, " ")
+				if query == "" && tag == "" {
+					_, err := replyEmbed(ctx.Msg, "Command — Search", fmt.Sprintf("Usage: `%ssearch <text> [--tag=name] [--all]`\n"+
+						"Finds downloads from this channel with the text in their link, filename or destination, `--all` searches every channel.", getConfig().CommandPrefix))
+					if err != nil {
+						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+					}
+					return
+				}
+
+				matches := dbSearchDownloads(query, channelID, tag, "", 0)
+				content := fmt.Sprintf("No downloads match \"%s\".", query)
+				if len(matches) > 0 {
+					content = fmt.Sprintf("%s download%s match \"%s\"", formatNumber(int64(len(matches))), pluralS(len(matches)), query)
+					if len(matches) > searchResultLimit {
+						content += fmt.Sprintf(", the newest %d", searchResultLimit)
+						matches = matches[:searchResultLimit]
+					}
+					content += ":\n"
+					for _, download := range matches {
+						content += fmt.Sprintf("\n• [%s](%s) — %s", download.Filename, download.URL, download.Time.Format("2006-01-02 15:04"))
+					}
+				}
+				_, err := replyEmbed(ctx.Msg, "Command — Search", content)
+				if err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s searched downloads for \"%s\"", getUserIdentifier(*ctx.Msg.Author), query))
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Search", cmderrLackingLocalAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to search downloads but lacked proper permission.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Finds downloads by link, filename or destination")
 
 	router.On("info", func(ctx *exrouter.Context) {
 		logPrefixHere := color.CyanString("[dgrouter:info]")
@@ -204,6 +356,7 @@ func handleCommands() *exrouter.Route {
 	//#region Admin Commands
 
 	router.On("history", func(ctx *exrouter.Context) {
+		settings := getConfig()
 		logPrefixHere := color.CyanString("[dgrouter:history]")
 		// Vars
 		var channels []string
@@ -212,9 +365,21 @@ func handleCommands() *exrouter.Route {
 		var since string
 		var sinceID string
 		var stop bool
+		var server bool
+		var dryRun bool = dryRunMode
+		var analyze bool
+		var attachCSV bool
+		var pins bool
+		var noLimits bool
+		var noWait bool
+		var force bool
+		var missingOnly bool
+		var limit int64
 		// Keys
 		beforeKey := "--before="
 		sinceKey := "--since="
+		afterKey := "--after="
+		limitKey := "--limit="
 		// Parse Args
 		for k, v := range ctx.Args {
 			// Skip "history" segment
@@ -229,35 +394,57 @@ func handleCommands() *exrouter.Route {
 				} else if isNumeric(before) {
 					beforeID = before
 				}
-				if config.DebugOutput {
+				if settings.DebugOutput {
 					log.Println(logPrefixDebug, logPrefixHere, color.CyanString("Date range applied, before %s", beforeID))
 				}
-			} else if strings.Contains(strings.ToLower(v), sinceKey) {
-				since = strings.ReplaceAll(strings.ToLower(v), sinceKey, "")
+			} else if strings.Contains(strings.ToLower(v), sinceKey) || strings.Contains(strings.ToLower(v), afterKey) {
+				since = strings.ReplaceAll(strings.ReplaceAll(strings.ToLower(v), sinceKey, ""), afterKey, "")
 				if isDate(since) {
 					sinceID = discordTimestampToSnowflake("2006-01-02", since)
 				} else if isNumeric(since) {
 					sinceID = since
 				}
-				if config.DebugOutput {
+				if settings.DebugOutput {
 					log.Println(logPrefixDebug, logPrefixHere, color.CyanString("Date range applied, since %s", sinceID))
 				}
+			} else if strings.Contains(strings.ToLower(v), limitKey) {
+				limit, _ = strconv.ParseInt(strings.ReplaceAll(strings.ToLower(v), limitKey, ""), 10, 64)
+				if settings.DebugOutput {
+					log.Println(logPrefixDebug, logPrefixHere, color.CyanString("Limited to %d messages", limit))
+				}
 			} else if strings.Contains(strings.ToLower(v), "cancel") || strings.Contains(strings.ToLower(v), "stop") {
 				stop = true
+			} else if strings.ToLower(v) == "dryrun" {
+				dryRun = true
+			} else if strings.ToLower(v) == "analyze" {
+				dryRun, analyze = true, true
+			} else if strings.ToLower(v) == "csv" {
+				attachCSV = true
+			} else if strings.ToLower(v) == "pins" {
+				pins = true
+			} else if strings.ToLower(v) == "nolimits" {
+				noLimits = true
+			} else if strings.ToLower(v) == "nowait" {
+				noWait = true
+			} else if strings.ToLower(v) == "--force" {
+				force = true
+			} else if strings.ToLower(v) == "--missing-only" {
+				missingOnly = true
 			} else {
 				// Actual Source ID(s)
 				targets := strings.Split(ctx.Args.Get(k), ",")
 				for _, target := range targets {
+					target = strings.TrimSuffix(strings.TrimPrefix(target, "<#"), ">") // channel mentions
 					if isNumeric(target) {
 						// Test/Use if number is guild
 						guild, err := bot.State.Guild(target)
 						if err == nil {
-							if config.DebugOutput {
+							if settings.DebugOutput {
 								log.Println(logPrefixHere, logPrefixDebug, color.YellowString("Specified target %s is a guild: \"%s\", adding all channels...", target, guild.Name))
 							}
 							for _, ch := range guild.Channels {
 								channels = append(channels, ch.ID)
-								if config.DebugOutput {
+								if settings.DebugOutput {
 									log.Println(logPrefixHere, logPrefixDebug, color.YellowString("Added %s (#%s in \"%s\") to history queue", ch.ID, ch.Name, guild.Name))
 								}
 							}
@@ -265,43 +452,129 @@ func handleCommands() *exrouter.Route {
 							ch, err := bot.State.Channel(target)
 							if err == nil {
 								channels = append(channels, target)
-								if config.DebugOutput {
+								if settings.DebugOutput {
 									log.Println(logPrefixHere, logPrefixDebug, color.YellowString("Added %s (#%s in %s) to history queue", ch.ID, ch.Name, ch.GuildID))
 								}
 							}
 						}
 					} else if strings.Contains(strings.ToLower(target), "all") {
-						channels = getAllChannels()
+						if ctx.Msg.GuildID != "" {
+							server = true
+						} else {
+							channels = getAllChannels()
+						}
+					}
+				}
+			}
+		}
+		newReport := func() *dryRunReport {
+			report := newDryRunReport()
+			report.analyze, report.attachCSV = analyze, analyze && attachCSV
+			return report
+		}
+		// Server-wide, runs as a single sequential job for this server
+		if server {
+			if !isCommandableChannel(ctx.Msg) {
+				return
+			}
+			if !isAdmin(ctx.Msg) && !isLocalAdmin(ctx.Msg) {
+				replyUnauthorized(ctx.Msg, "Command — History", cmderrLackingLocalAdminPerms)
+				log.Println(logPrefixHere, color.CyanString("%s tried to cache history for server %s but lacked proper permission.", getUserIdentifier(*ctx.Msg.Author), ctx.Msg.GuildID))
+				return
+			}
+			if stop {
+				if cancelServerHistory(ctx.Msg.GuildID) {
+					for _, channel := range getBoundChannelsInGuild(ctx.Msg.GuildID) {
+						cancelHistory(channel)
+					}
+					if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+						_, err := replyEmbed(ctx.Msg, "Command — History", cmderrHistoryCancelled)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+						}
+					} else {
+						log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
 					}
+					log.Println(logPrefixHere, color.CyanString("%s cancelled history cataloging for server %s", getUserIdentifier(*ctx.Msg.Author), ctx.Msg.GuildID))
+				}
+			} else if getServerHistoryStatus(ctx.Msg.GuildID) == "" {
+				options := historyOptions{noLimits: noLimits, noWait: noWait, limit: limit, force: force, missingOnly: missingOnly}
+				if dryRun {
+					options.dryRun = newReport()
+				}
+				if settings.AsynchronousHistory {
+					go handleServerHistory(ctx.Msg, ctx.Msg.GuildID, beforeID, sinceID, options)
+				} else {
+					handleServerHistory(ctx.Msg, ctx.Msg.GuildID, beforeID, sinceID, options)
 				}
+			} else { // ALREADY RUNNING
+				rejectCommandInteraction(ctx.Msg, "History is already running for this server.")
+				log.Println(logPrefixHere, color.CyanString("%s tried using history command but server history is already running for %s...", getUserIdentifier(*ctx.Msg.Author), ctx.Msg.GuildID))
 			}
+			return
 		}
 		if len(channels) == 0 { // Local
 			channels = append(channels, ctx.Msg.ChannelID)
 		}
 		// Foreach Channel
 		for _, channel := range channels {
-			if config.DebugOutput {
+			if settings.DebugOutput {
 				log.Println(logPrefixHere, logPrefixDebug, color.YellowString("Processing %s...", channel))
 			}
 			// Registered check
 			if isCommandableChannel(ctx.Msg) {
 				// Permission check
-				if isBotAdmin(ctx.Msg) || isLocalAdmin(ctx.Msg) {
+				if isAdmin(ctx.Msg) || isLocalAdmin(ctx.Msg) {
 					// Run
 					if !stop {
-						_, historyCommandIsSet := historyStatus[channel]
-						if !historyCommandIsSet || historyStatus[channel] == "" {
-							if config.AsynchronousHistory {
-								go handleHistory(ctx.Msg, channel, beforeID, sinceID)
+						if getHistoryStatus(channel) == "" {
+							options := historyOptions{noLimits: noLimits, noWait: noWait, limit: limit, force: force, missingOnly: missingOnly}
+							if pins {
+								runPins := func(channel string) {
+									if !dryRun {
+										handlePinsHistory(ctx.Msg, channel, options)
+										return
+									}
+									report := newReport()
+									options.dryRun = report
+									handlePinsHistory(ctx.Msg, channel, options)
+									_, err := replyEmbed(ctx.Msg, "Command — History", fmt.Sprintf("_#%s pins_\n\n%s", getChannelName(channel), report.summary()))
+									if err != nil {
+										log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+									}
+									sendDryRunCSV(ctx.Msg, report, getChannelName(channel)+"_pins")
+								}
+								if settings.AsynchronousHistory {
+									go runPins(channel)
+								} else {
+									runPins(channel)
+								}
+							} else if dryRun {
+								runDryRun := func(channel string) {
+									report := newReport()
+									options.dryRun = report
+									handleHistory(ctx.Msg, channel, beforeID, sinceID, options)
+									_, err := replyEmbed(ctx.Msg, "Command — History", fmt.Sprintf("_#%s_\n\n%s", getChannelName(channel), report.summary()))
+									if err != nil {
+										log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+									}
+									sendDryRunCSV(ctx.Msg, report, getChannelName(channel))
+								}
+								if settings.AsynchronousHistory {
+									go runDryRun(channel)
+								} else {
+									runDryRun(channel)
+								}
+							} else if settings.AsynchronousHistory {
+								go handleHistory(ctx.Msg, channel, beforeID, sinceID, options)
 							} else {
-								handleHistory(ctx.Msg, channel, beforeID, sinceID)
+								handleHistory(ctx.Msg, channel, beforeID, sinceID, options)
 							}
 						} else { // ALREADY RUNNING
+							rejectCommandInteraction(ctx.Msg, fmt.Sprintf("History is already running for #%s.", getChannelName(channel)))
 							log.Println(logPrefixHere, color.CyanString("%s tried using history command but history is already running for %s...", getUserIdentifier(*ctx.Msg.Author), channel))
 						}
-					} else if historyStatus[channel] == "downloading" {
-						historyStatus[channel] = "cancel"
+					} else if cancelHistory(channel) {
 						if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
 							_, err := replyEmbed(ctx.Msg, "Command — History", cmderrHistoryCancelled)
 							if err != nil {
@@ -313,14 +586,7 @@ func handleCommands() *exrouter.Route {
 						log.Println(logPrefixHere, color.CyanString("%s cancelled history cataloging for \"%s\"", getUserIdentifier(*ctx.Msg.Author), channel))
 					}
 				} else { // DOES NOT HAVE PERMISSION
-					if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
-						_, err := replyEmbed(ctx.Msg, "Command — History", cmderrLackingLocalAdminPerms)
-						if err != nil {
-							log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
-						}
-					} else {
-						log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, channel))
-					}
+					replyUnauthorized(ctx.Msg, "Command — History", cmderrLackingLocalAdminPerms)
 					log.Println(logPrefixHere, color.CyanString("%s tried to cache history for %s but lacked proper permission.", getUserIdentifier(*ctx.Msg.Author), channel))
 				}
 			} else { // CHANNEL NOT REGISTERED
@@ -332,7 +598,7 @@ func handleCommands() *exrouter.Route {
 	router.On("exit", func(ctx *exrouter.Context) {
 		logPrefixHere := color.CyanString("[dgrouter:exit]")
 		if isCommandableChannel(ctx.Msg) {
-			if isBotAdmin(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
 				if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
 					_, err := replyEmbed(ctx.Msg, "Command — Exit", "Exiting...")
 					if err != nil {
@@ -344,121 +610,674 @@ func handleCommands() *exrouter.Route {
 				log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) requested exit, goodbye...", getUserIdentifier(*ctx.Msg.Author)))
 				properExit()
 			} else {
+				replyUnauthorized(ctx.Msg, "Command — Exit", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to exit but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Alias("kill").Cat("Admin").Desc("Kills the bot")
+
+	router.On("reload", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:reload]")
+		if isCommandableChannel(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				var content string
+				restartRequired, err := reloadConfig(true)
+				if err != nil {
+					content = fmt.Sprintf("Failed to reload settings, keeping previous settings...\n```%s```", err)
+					log.Println(logPrefixHere, color.HiRedString("%s (bot admin) requested reload, failed...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+				} else {
+					content = fmt.Sprintf("Reloaded settings, bound to %d channel%s and %d server%s.",
+						getBoundChannelsCount(), pluralS(getBoundChannelsCount()),
+						getBoundServersCount(), pluralS(getBoundServersCount()))
+					if len(restartRequired) > 0 {
+						content += fmt.Sprintf("\n\n**Changes to %s require a restart to apply.**", strings.Join(restartRequired, ", "))
+					}
+					log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) reloaded settings", getUserIdentifier(*ctx.Msg.Author)))
+					updateDiscordPresence()
+				}
 				if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
-					_, err := replyEmbed(ctx.Msg, "Command — Exit", cmderrLackingBotAdminPerms)
+					_, err := replyEmbed(ctx.Msg, "Command — Reload", content)
 					if err != nil {
 						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
 					}
 				} else {
 					log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
 				}
-				log.Println(logPrefixHere, color.HiCyanString("%s tried to exit but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Reload", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to reload settings but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Reloads settings without restarting")
+
+	router.On("dedupe", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:dedupe]")
+		if isCommandableChannel(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				reply := func(content string) {
+					if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+						_, err := replyEmbed(ctx.Msg, "Command — Dedupe", content)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+						}
+					} else {
+						log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
+					}
+				}
+				if strings.ToLower(ctx.Args.Get(1)) != "rebuild" {
+					reply(fmt.Sprintf("Usage: `%sdedupe rebuild`\nRegenerates the duplicate image filter from downloaded images still on disk.", settings.CommandPrefix))
+				} else if !settings.FilterDuplicateImages || imgStore == nil {
+					reply("The duplicate image filter isn't enabled.")
+				} else {
+					log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) requested the image filter to be rebuilt", getUserIdentifier(*ctx.Msg.Author)))
+					reply("Rebuilding the duplicate image filter from downloaded images, this may take a while...")
+					started := time.Now()
+					count, failed := rebuildImgStore()
+					content := fmt.Sprintf("Rebuilt the duplicate image filter with %s image%s in %s.",
+						formatNumber(int64(count)), pluralS(count), durafmt.ParseShort(time.Since(started)))
+					if failed > 0 {
+						content += fmt.Sprintf(" %s file%s couldn't be read as images.", formatNumber(int64(failed)), pluralS(failed))
+					}
+					log.Println(logPrefixHere, color.HiCyanString(content))
+					reply(content)
+				}
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Dedupe", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to rebuild the image filter but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
 			}
 		}
-	}).Alias("reload", "kill").Cat("Admin").Desc("Kills the bot")
+	}).Cat("Admin").Desc("Rebuilds the duplicate image filter from downloaded files")
 
 	router.On("emojis", func(ctx *exrouter.Context) {
 		logPrefixHere := color.CyanString("[dgrouter:emojis]")
 		if isGlobalCommandAllowed(ctx.Msg) {
-			if isBotAdmin(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
 				if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
-					args := ctx.Args.After(1)
+					args := strings.TrimSpace(ctx.Args.After(1))
+
+					// Custom emojis used in a channel
+					if strings.ToLower(ctx.Args.Get(1)) == "used" {
+						channelID := ctx.Msg.ChannelID
+						days := emojisUsedDaysDefault
+						for _, arg := range strings.Fields(ctx.Args.After(2)) {
+							target := strings.TrimSuffix(strings.TrimPrefix(arg, "<#"), ">") // channel mentions
+							if number, err := strconv.Atoi(target); err == nil && target == arg && number > 0 && len(arg) < 6 {
+								days = number
+							} else if isNumeric(target) {
+								channelID = target
+							} else {
+								replyEmbed(ctx.Msg, "Command — Emojis", fmt.Sprintf("`%s` isn't a channel or a number of days. Use `emojis used [#channel] [days]`.", arg))
+								return
+							}
+						}
+						destination, found, counts, err := downloadUsedEmojis(channelID, days, ctx.Msg.ChannelID)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to save emojis used in %s:\t%s", channelID, err))
+							replyEmbed(ctx.Msg, "Command — Emojis", fmt.Sprintf("Failed to save emojis used in <#%s>: %s", channelID, err))
+							return
+						}
+						destinationOut := destination
+						abs, err := filepath.Abs(destination)
+						if err == nil {
+							destinationOut = abs
+						}
+						_, err = replyEmbed(ctx.Msg, "Command — Emojis",
+							fmt.Sprintf("`%d` custom emojis used in <#%s> in the last %d day%s, `%d` downloaded, `%d` already saved, `%d` failed\n• Destination: `%s`",
+								found, channelID, days, pluralS(days), counts.saved, counts.skipped, counts.failed, destinationOut,
+							),
+						)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to send status message for emoji downloads:\t%s", err))
+						}
+						return
+					}
 
 					// Determine which guild(s)
 					guilds := []string{ctx.Msg.GuildID}
 					if args != "" {
 						guilds = nil
-						_guilds := strings.Split(args, ",")
-						if len(_guilds) > 0 {
-							for _, guild := range _guilds {
-								guild = strings.TrimSpace(guild)
-								guilds = append(guilds, guild)
-							}
+						for _, guild := range strings.Split(args, ",") {
+							guilds = append(guilds, strings.TrimSpace(guild))
 						}
 					}
 
 					for _, guild := range guilds {
-						i := 0
-						s := 0
-
-						guildName := guild
-						guildNameO := guild
-						guildInfo, err := bot.Guild(guild)
+						destination, emojis, stickers, deleted, err := downloadGuildEmojis(guild, ctx.Msg.ChannelID)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to save emojis for %s:\t%s", guild, err))
+							replyEmbed(ctx.Msg, "Command — Emojis", fmt.Sprintf("Failed to save emojis for `%s`: %s", getGuildName(guild), err))
+							continue
+						}
+						destinationOut := destination
+						abs, err := filepath.Abs(destination)
 						if err == nil {
-							guildName = sanitize.Name(guildInfo.Name)
-							guildNameO = guildInfo.Name
+							destinationOut = abs
+						}
+						content := fmt.Sprintf("`%d` emojis and `%d` stickers downloaded, `%d` already saved, `%d` failed\n• Destination: `%s`\n• Server: `%s`",
+							emojis.saved, stickers.saved, emojis.skipped+stickers.skipped, emojis.failed+stickers.failed, destinationOut, getGuildName(guild),
+						)
+						if len(deleted) > 0 {
+							content += fmt.Sprintf("\n• Deleted from the server since: `%s`", strings.Join(deleted, "`, `"))
+						}
+						_, err = replyEmbed(ctx.Msg, "Command — Emojis", content)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to send status message for emoji downloads:\t%s", err))
 						}
+					}
+				}
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Emojis", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to download emojis but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Alias("emoji").Cat("Admin").Desc("Saves all server emojis and stickers, or the custom emojis used in a channel, to download destination")
+
+	router.On("events", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:events]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+					args := strings.TrimSpace(ctx.Args.After(1))
 
-						destination := "emojis" + string(os.PathSeparator) + guildName + string(os.PathSeparator)
+					// Determine which guild(s), only registered ones are saved from
+					registered := getRegisteredGuilds()
+					guilds := []string{ctx.Msg.GuildID}
+					if strings.ToLower(args) == "all" {
+						guilds = registered
+					} else if args != "" {
+						guilds = nil
+						for _, guild := range strings.Split(args, ",") {
+							guilds = append(guilds, strings.TrimSpace(guild))
+						}
+					}
 
-						err = os.MkdirAll(destination, 0755)
+					for _, guild := range guilds {
+						if !stringInSlice(guild, registered) {
+							replyEmbed(ctx.Msg, "Command — Events", fmt.Sprintf("Server `%s` isn't registered, event covers are only saved from registered servers.", guild))
+							continue
+						}
+						destination, i, s, err := downloadEventCovers(guild, ctx.Msg.ChannelID)
 						if err != nil {
-							log.Println(logPrefixHere, color.HiRedString("Error while creating destination folder \"%s\": %s", destination, err))
-						} else {
-							emojis, err := bot.GuildEmojis(guild)
-							if err == nil {
-								for _, emoji := range emojis {
-									var message discordgo.Message
-									message.ChannelID = ctx.Msg.ChannelID
-									url := "https://cdn.discordapp.com/emojis/" + emoji.ID
-
-									status := startDownload(
-										downloadRequestStruct{
-											InputURL:   url,
-											Filename:   emoji.ID,
-											Path:       destination,
-											Message:    &message,
-											FileTime:   time.Now(),
-											HistoryCmd: false,
-											EmojiCmd:   true,
-										})
-
-									if status.Status == downloadSuccess {
-										i++
-									} else {
-										s++
-										log.Println(logPrefixHere, color.HiRedString("Failed to download emoji \"%s\": \t[%d - %s] %v", url, status.Status, getDownloadStatusString(status.Status), status.Error))
-									}
-								}
-								destinationOut := destination
-								abs, err := filepath.Abs(destination)
-								if err == nil {
-									destinationOut = abs
-								}
-								_, err = replyEmbed(ctx.Msg, "Command — Emojis",
-									fmt.Sprintf("`%d` emojis downloaded, `%d` skipped or failed\n• Destination: `%s`\n• Server: `%s`",
-										i, s, destinationOut, guildNameO,
-									),
-								)
-								if err != nil {
-									log.Println(logPrefixHere, color.HiRedString("Failed to send status message for emoji downloads:\t%s", err))
-								}
-							} else {
-								log.Println(err)
-							}
+							log.Println(logPrefixHere, color.HiRedString("Failed to save event covers for %s:\t%s", guild, err))
+							replyEmbed(ctx.Msg, "Command — Events", fmt.Sprintf("Failed to save event covers for `%s`: %s", getGuildName(guild), err))
+							continue
+						}
+						destinationOut := destination
+						abs, err := filepath.Abs(destination)
+						if err == nil {
+							destinationOut = abs
+						}
+						_, err = replyEmbed(ctx.Msg, "Command — Events",
+							fmt.Sprintf("`%d` event covers downloaded, `%d` skipped or failed\n• Destination: `%s`\n• Server: `%s`",
+								i, s, destinationOut, getGuildName(guild),
+							),
+						)
+						if err != nil {
+							log.Println(logPrefixHere, color.HiRedString("Failed to send status message for event cover downloads:\t%s", err))
 						}
 					}
 				}
 			} else {
-				if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
-					_, err := replyEmbed(ctx.Msg, "Command — Emojis", cmderrLackingBotAdminPerms)
+				replyUnauthorized(ctx.Msg, "Command — Events", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to download event covers but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Saves the cover images of scheduled events in registered servers")
+
+	router.On("avatars", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:avatars]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				if settings.AvatarTracking == nil {
+					replyEmbed(ctx.Msg, "Command — Avatars", "Avatar tracking isn't set up, add `avatarTracking` to your settings first.")
+					return
+				}
+				guild := strings.TrimSpace(ctx.Args.After(1))
+				if guild == "" {
+					guild = ctx.Msg.GuildID
+				}
+				if !isNumeric(guild) {
+					replyEmbed(ctx.Msg, "Command — Avatars", fmt.Sprintf("`%s` isn't a server ID.", guild))
+					return
+				}
+				reply, _ := replyEmbed(ctx.Msg, "Command — Avatars", "Saving avatars, this can take a while for big servers...")
+				saved, unchanged, failed, err := sweepGuildAvatars(guild)
+				description := fmt.Sprintf("`%d` new images saved, `%d` already saved, `%d` failed\n• Destination: `%s`\n• Server: `%s`",
+					saved, unchanged, failed, settings.AvatarTracking.Destination, guild)
+				if err != nil {
+					description += fmt.Sprintf("\n\nStopped early: %s", err)
+					log.Println(logPrefixHere, color.HiRedString("Failed to sweep avatars of %s:\t%s", guild, err))
+				}
+				if reply != nil {
+					editEmbed(reply, "Command — Avatars", description)
+				} else {
+					replyEmbed(ctx.Msg, "Command — Avatars", description)
+				}
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Avatars", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to save avatars but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Saves the avatars of every member of a server")
+
+	router.On("folders", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:folders]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				var confirm, merge bool
+				for _, arg := range strings.Fields(ctx.Args.After(1)) {
+					switch arg {
+					case "confirm":
+						confirm = true
+					case "merge":
+						merge = true
+					}
+				}
+				if strings.ToLower(ctx.Args.Get(1)) != "sync" {
+					replyEmbed(ctx.Msg, "Command — Folders", fmt.Sprintf("Usage: `%sfolders sync [confirm] [merge]`\nLists servers, channels and categories renamed since their folders were made, and with `confirm` moves the folders to the new names. Folders that already exist are only merged into with `merge`.", settings.CommandPrefix))
+					return
+				}
+				renames := getFolderRenames()
+				if len(renames) == 0 {
+					replyEmbed(ctx.Msg, "Command — Folders", "No renames found, every folder matches its current name.")
+					return
+				}
+				var lines []string
+				moves := 0
+				for _, rename := range renames {
+					lines = append(lines, fmt.Sprintf("• `%s` → `%s` _(%s, %d folder%s)_", rename.oldName, rename.newName, rename.key, len(rename.moves), pluralS(len(rename.moves))))
+					moves += len(rename.moves)
+				}
+				if len(lines) > 30 {
+					lines = append(lines[:30], fmt.Sprintf("_...and %d more_", len(lines)-30))
+				}
+				if !confirm {
+					replyEmbed(ctx.Msg, "Command — Folders", fmt.Sprintf("Found %d rename%s affecting %d folder%s:\n%s\n\nUse `%sfolders sync confirm` to move them and update the database.",
+						len(renames), pluralS(len(renames)), moves, pluralS(moves), strings.Join(lines, "\n"), settings.CommandPrefix))
+					return
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) requested %d folder rename%s (merge: %t)", getUserIdentifier(*ctx.Msg.Author), len(renames), pluralS(len(renames)), merge))
+				result := syncFolderRenames(renames, merge)
+				description := fmt.Sprintf("`%d` folders renamed, `%d` merged, `%d` database entries updated", result.renamed, result.merged, result.updated)
+				if result.conflicts > 0 {
+					description += fmt.Sprintf("\n`%d` files were left in the old folders, their names were already taken", result.conflicts)
+				}
+				if len(result.errors) > 0 {
+					log.Println(logPrefixHere, color.HiRedString("Folder sync finished with %d problem%s", len(result.errors), pluralS(len(result.errors))))
+					if len(result.errors) > 10 {
+						result.errors = append(result.errors[:10], fmt.Sprintf("_...and %d more_", len(result.errors)-10))
+					}
+					description += "\n\n**Problems:**\n• " + strings.Join(result.errors, "\n• ")
+				}
+				replyEmbed(ctx.Msg, "Command — Folders", description)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Folders", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to sync folders but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Moves folders of renamed servers and channels to their new names")
+
+	router.On("download", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:download]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				// Arguments are read from the original message, URLs are case-sensitive
+				var urls []string
+				var alias string
+				fresh := false
+				for i, arg := range getOriginalArgs(ctx.Msg) {
+					if i == 0 { // "download"
+						continue
+					}
+					if xurls.Strict().MatchString(arg) {
+						urls = append(urls, xurls.Strict().FindAllString(arg, -1)...)
+					} else if strings.ToLower(arg) == "fresh" {
+						fresh = true
+					} else {
+						alias = arg
+					}
+				}
+				attached, err := getAttachedURLs(ctx.Msg)
+				if err != nil {
+					log.Println(logPrefixHere, color.HiRedString("%s", err))
+				}
+				urls = append(urls, attached...)
+
+				destination, exists := getManualDestination(alias)
+				if !exists {
+					content := fmt.Sprintf("Unknown destination \"%s\".", alias)
+					if aliases := getManualDestinationAliases(); len(aliases) > 0 {
+						content += fmt.Sprintf(" Destinations are: `%s`", strings.Join(aliases, "`, `"))
+					}
+					_, err := replyEmbed(ctx.Msg, "Command — Download", content)
+					if err != nil {
+						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+					}
+					return
+				}
+				if len(urls) == 0 {
+					_, err := replyEmbed(ctx.Msg, "Command — Download", fmt.Sprintf("Usage: `%sdownload <url> [url...] [destination] [fresh]`\nURLs can also be attached in a .txt file.", getConfig().CommandPrefix))
 					if err != nil {
 						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
 					}
+					return
+				}
+
+				log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) requested %d URL%s be downloaded to \"%s\"", getUserIdentifier(*ctx.Msg.Author), len(urls), pluralS(len(urls)), destination))
+				status, err := replyEmbed(ctx.Msg, "Command — Download", fmt.Sprintf("Downloading %d URL%s to `%s`, please wait...", len(urls), pluralS(len(urls)), destination))
+				if err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+				}
+				content := formatManualResults(runManualDownloads(ctx.Msg, urls, destination, fresh))
+				if status != nil {
+					if _, err = editEmbed(status, "Command — Download", content); err == nil {
+						return
+					}
+				}
+				if _, err = replyEmbed(ctx.Msg, "Command — Download", content); err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+				}
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Download", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to download URLs but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Downloads URLs (or a .txt of them) into a manual destination")
+
+	router.On("scrape", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:scrape]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				site := strings.ToLower(ctx.Args.Get(1))
+				name := strings.TrimPrefix(strings.TrimPrefix(ctx.Args.Get(2), "@"), "r/")
+				limit := scrapeLimitDefault
+				if ctx.Args.Get(3) != "" {
+					parsed, err := strconv.Atoi(ctx.Args.Get(3))
+					if err != nil || parsed <= 0 {
+						name = ""
+					}
+					limit = parsed
+				}
+				content := ""
+				if !stringInSlice(site, scrapeSites) || name == "" {
+					content = fmt.Sprintf("Usage: `%sscrape <%s> <username|subreddit> [limit]`\nThe limit defaults to %d posts.", getConfig().CommandPrefix, strings.Join(scrapeSites, "|"), scrapeLimitDefault)
+				} else if site == "pixiv" {
+					content = "Pixiv can't be scraped, there's no Pixiv API access to list an account's posts with."
+				} else if site == "twitter" && !twitterConnected {
+					content = "Twitter can't be scraped without working Twitter API credentials."
+				} else if limit > scrapeLimitMax {
+					content = fmt.Sprintf("The limit can't be more than %d posts.", scrapeLimitMax)
+				}
+				if content != "" {
+					if _, err := replyEmbed(ctx.Msg, "Command — Scrape", content); err != nil {
+						log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+					}
+					return
+				}
+				handleScrape(ctx.Msg, site, name, limit)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Scrape", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to scrape an account but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Downloads everything from a Twitter account or subreddit")
+
+	router.On("retry-failed", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:retry-failed]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				channelID := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(ctx.Args.After(1)), "<#"), ">")
+				if channelID == "" {
+					channelID = ctx.Msg.ChannelID
+				}
+				if !isChannelRegistered(channelID) {
+					replyEmbed(ctx.Msg, "Command — Retry Failed", fmt.Sprintf("<#%s> isn't a registered channel.", channelID))
+					return
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s (bot admin) cleared failed URLs for %s", getUserIdentifier(*ctx.Msg.Author), channelID))
+				reply, _ := replyEmbed(ctx.Msg, "Command — Retry Failed", fmt.Sprintf("Retrying failed downloads in <#%s>, please wait...", channelID))
+				messages, downloads := retryFailedURLs(channelID)
+				content := fmt.Sprintf("Went through `%d` message%s with failed downloads in <#%s>, `%d` file%s saved this time.",
+					messages, pluralS(messages), channelID, downloads, pluralS(downloads))
+				if reply != nil {
+					if _, err := editEmbed(reply, "Command — Retry Failed", content); err == nil {
+						return
+					}
+				}
+				replyEmbed(ctx.Msg, "Command — Retry Failed", content)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Retry Failed", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to retry failed downloads but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Forgets a channel's failed downloads and tries them again")
+
+	router.On("markplaceholder", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:markplaceholder]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				// Read from the original message, URLs and paths are case-sensitive
+				content := getOriginalContent(ctx.Msg)
+				if strings.HasPrefix(strings.ToLower(content), strings.ToLower(settings.CommandPrefix)) {
+					content = content[len(settings.CommandPrefix):]
+				}
+				source := ""
+				if fields := strings.Fields(content); len(fields) > 1 {
+					source = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(content), fields[0]))
+				} else if len(ctx.Msg.Attachments) > 0 {
+					source = ctx.Msg.Attachments[0].URL
+				}
+				if source == "" {
+					replyEmbed(ctx.Msg, "Command — Mark Placeholder", fmt.Sprintf("Usage: `%smarkplaceholder <url or file>`\nThe image can also be attached.", settings.CommandPrefix))
+					return
+				}
+
+				body, err := readPlaceholderSource(source)
+				var hash uint64
+				if err == nil {
+					hash, err = hashPlaceholder(body)
+				}
+				if err != nil {
+					replyEmbed(ctx.Msg, "Command — Mark Placeholder", fmt.Sprintf("Couldn't read an image from `%s`: %s", source, err))
+					return
+				}
+				placeholder := placeholderImage{hash: hash, size: int64(len(body))}
+				added, err := learnPlaceholder(placeholder)
+				if err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to save placeholder:\t%s", err))
+					replyEmbed(ctx.Msg, "Command — Mark Placeholder", fmt.Sprintf("Failed to save placeholder: %s", err))
+					return
+				}
+				if !added {
+					replyEmbed(ctx.Msg, "Command — Mark Placeholder", fmt.Sprintf("`%s` is already a known placeholder.", formatPlaceholder(placeholder)))
+					return
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s marked %s as a placeholder (%s)", getUserIdentifier(*ctx.Msg.Author), source, formatPlaceholder(placeholder)))
+				replyEmbed(ctx.Msg, "Command — Mark Placeholder", fmt.Sprintf("Images like this will be skipped from now on.\n• Hash: `%s`\n• Saved to: `%s`", formatPlaceholder(placeholder), placeholderLearnedPath))
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Mark Placeholder", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to mark a placeholder but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Teaches the bot to skip an image sites serve in place of missing media")
+
+	router.On("blocklist", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:blocklist]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				// Read from the original message, URLs and regexes are case-sensitive
+				content := getOriginalContent(ctx.Msg)
+				if strings.HasPrefix(strings.ToLower(content), strings.ToLower(settings.CommandPrefix)) {
+					content = content[len(settings.CommandPrefix):]
+				}
+				fields := strings.Fields(content)
+				if len(fields) < 3 || strings.ToLower(fields[1]) != "add" {
+					replyEmbed(ctx.Msg, "Command — Blocklist", fmt.Sprintf("Usage: `%sblocklist add <url, domain, *.wildcard or re:regex>`", settings.CommandPrefix))
+					return
+				}
+				entry := strings.TrimSpace(content)
+				entry = strings.TrimSpace(entry[len(fields[0]):])
+				entry = strings.TrimSpace(entry[len(fields[1]):])
+				if err := addBlocklistEntry(entry); err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to add to blocklist:\t%s", err))
+					replyEmbed(ctx.Msg, "Command — Blocklist", fmt.Sprintf("Couldn't add `%s`: %s", entry, err))
+					return
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s added %s to the blocklist", getUserIdentifier(*ctx.Msg.Author), entry))
+				replyEmbed(ctx.Msg, "Command — Blocklist", fmt.Sprintf("Links matching `%s` will be ignored from now on.\n• Saved to: `%s`", entry, settings.BlocklistFile))
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Blocklist", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to add to the blocklist but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Adds a URL, domain or pattern to the blocklistFile")
+
+	router.On("cas", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:cas]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				args := ctx.Args.After(1)
+				if strings.ToLower(strings.TrimSpace(args)) != "gc" {
+					replyEmbed(ctx.Msg, "Command — CAS", fmt.Sprintf("Usage: `%scas gc`", getConfig().CommandPrefix))
+					return
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s requested unreferenced blobs be deleted", getUserIdentifier(*ctx.Msg.Author)))
+				removed, freed, err := collectBlobGarbage()
+				content := fmt.Sprintf("Deleted **%s** unreferenced blob%s from `%s`, freeing **%s**.", formatNumber(int64(removed)), pluralS(removed), getCASRoot(), formatBytes(freed))
+				if err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Error while deleting unreferenced blobs:\t%s", err))
+					content += fmt.Sprintf("\n\n_Stopped early, %s_", err)
+				}
+				replyEmbed(ctx.Msg, "Command — CAS", content)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — CAS", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to clean up blobs but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Deletes stored blobs no download refers to anymore")
+
+	router.On("thumbnails", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:thumbnails]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				if strings.ToLower(ctx.Args.Get(1)) != "backfill" {
+					replyEmbed(ctx.Msg, "Command — Thumbnails", fmt.Sprintf("Usage: `%sthumbnails backfill [#channel]`\nMakes thumbnails for saved images and videos that don't have one, from every channel if none is given.", getConfig().CommandPrefix))
+					return
+				}
+				channelID := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(ctx.Args.After(2)), "<#"), ">")
+				if channelID != "" && !isNumeric(channelID) {
+					replyEmbed(ctx.Msg, "Command — Thumbnails", fmt.Sprintf("`%s` isn't a channel.", channelID))
+					return
+				}
+				handleThumbnailBackfill(ctx.Msg, channelID)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Thumbnails", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to generate thumbnails but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Makes thumbnails for saved images and videos that don't have one")
+
+	router.On("handler", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:handler]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				if strings.ToLower(ctx.Args.Get(1)) != "selfcheck" {
+					replyEmbed(ctx.Msg, "Command — Handler Self-check", fmt.Sprintf("Usage: `%shandler selfcheck`\nRuns every enabled site handler against its `checkURL` from settings and reports which still work.", getConfig().CommandPrefix))
+					return
+				}
+				handleSiteHandlerSelfcheck(ctx.Msg)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Handler Self-check", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to run the handler self-check but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Checks site handlers still work against known-good links")
+
+	router.On("database", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:database]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				if strings.ToLower(ctx.Args.Get(1)) != "migrate" {
+					replyEmbed(ctx.Msg, "Command — Database Migrate", fmt.Sprintf("Usage: `%sdatabase migrate`\nCopies every download from the embedded database into the one set with `database` in settings.", getConfig().CommandPrefix))
+					return
+				}
+				handleDatabaseMigrate(ctx.Msg)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Database Migrate", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to migrate the database but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Copies downloads from the embedded database into the configured one")
+
+	router.On("integrity", func(ctx *exrouter.Context) {
+		settings := getConfig()
+		logPrefixHere := color.CyanString("[dgrouter:integrity]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				var repairs []string
+				if action := strings.ToLower(ctx.Args.Get(1)); action != "" && action != "check" {
+					var valid bool
+					if repairs, valid = parseIntegrityRepairs(strings.Fields(ctx.Args.After(2))); action != "repair" || !valid {
+						replyEmbed(ctx.Msg, "Command — Integrity", fmt.Sprintf("Usage: `%sintegrity [check]` or `%sintegrity repair <%s|all>`\n"+
+							"Checks the image store, database and files against each other. Repairing drops image store entries without a row, "+
+							"marks rows whose files are missing so they're downloaded again, and deletes orphaned temp files.",
+							settings.CommandPrefix, settings.CommandPrefix, strings.Join(integrityRepairs, "|")))
+						return
+					}
+				}
+				handleIntegrityCommand(ctx.Msg, repairs)
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Integrity", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to run the integrity check but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+			}
+		}
+	}).Cat("Admin").Desc("Cross-checks the image store, database and files, repairing if asked")
+
+	router.On("snapshot", func(ctx *exrouter.Context) {
+		logPrefixHere := color.CyanString("[dgrouter:snapshot]")
+		if isGlobalCommandAllowed(ctx.Msg) {
+			if isAdmin(ctx.Msg) {
+				targets := getSnapshotTargets()
+				guildID := ctx.Args.Get(1)
+				if guildID == "" {
+					guildID = ctx.Msg.GuildID
+				}
+				var content string
+				if strings.ToLower(guildID) == "all" {
+					written, failed := refreshSnapshots(true)
+					content = fmt.Sprintf("Saved snapshots of %d server%s.", written, pluralS(written))
+					if failed > 0 {
+						content += fmt.Sprintf("\n%d couldn't be saved, see the log.", failed)
+					}
+				} else if roots, exists := targets[guildID]; !exists {
+					content = fmt.Sprintf("Nothing from that server is downloaded to a local destination.\n\nUsage: `%ssnapshot [<server ID>|all]`", getConfig().CommandPrefix)
+				} else if written, err := refreshGuildSnapshot(guildID, roots, true); err != nil {
+					content = fmt.Sprintf("Couldn't take a snapshot of %s: %s", guildID, err)
 				} else {
-					log.Println(logPrefixHere, color.HiRedString(fmtBotSendPerm, ctx.Msg.ChannelID))
+					content = fmt.Sprintf("Saved a snapshot of **%s** to %d of %d destination%s.", getGuildName(guildID), len(written), len(roots), pluralS(len(roots)))
 				}
-				log.Println(logPrefixHere, color.HiCyanString("%s tried to download emojis but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+				if _, err := replyEmbed(ctx.Msg, "Command — Snapshot", content); err != nil {
+					log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*ctx.Msg.Author), err))
+				}
+				log.Println(logPrefixHere, color.HiCyanString("%s took a snapshot of %s", getUserIdentifier(*ctx.Msg.Author), guildID))
+			} else {
+				replyUnauthorized(ctx.Msg, "Command — Snapshot", cmderrLackingBotAdminPerms)
+				log.Println(logPrefixHere, color.HiCyanString("%s tried to take a snapshot but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
 			}
 		}
-	}).Cat("Admin").Desc("Saves all server emojis to download destination")
+	}).Cat("Admin").Desc("Saves the names, topics and settings of a server's channels to its destinations")
 
 	//#endregion
 
 	// Handler for Command Router
 	bot.AddHandler(func(_ *discordgo.Session, m *discordgo.MessageCreate) {
 		//NOTE: This setup makes it case-insensitive but message content will be lowercase, currently case sensitivity is not necessary.
-		router.FindAndExecute(bot, strings.ToLower(config.CommandPrefix), bot.State.User.ID, messageToLower(m.Message))
+		router.FindAndExecute(bot, strings.ToLower(getConfig().CommandPrefix), bot.State.User.ID, messageToLower(m.Message))
 	})
 
 	return router
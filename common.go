@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -42,11 +44,16 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 //#region Formatting
 
 func formatNumber(n int64) string {
 	var numberSeparator byte = ','
-	if config.NumberFormatEuropean {
+	if getConfig().NumberFormatEuropean {
 		numberSeparator = '.'
 	}
 
@@ -69,12 +76,13 @@ func formatNumber(n int64) string {
 }
 
 func formatNumberShort(x int64) string {
+	settings := getConfig()
 	var numberSeparator string = ","
-	if config.NumberFormatEuropean {
+	if settings.NumberFormatEuropean {
 		numberSeparator = "."
 	}
 	var decimalSeparator string = "."
-	if config.NumberFormatEuropean {
+	if settings.NumberFormatEuropean {
 		decimalSeparator = ","
 	}
 
@@ -94,6 +102,40 @@ func formatNumberShort(x int64) string {
 	return fmt.Sprint(x)
 }
 
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for x := n / unit; x >= unit; x /= unit {
+		div *= unit
+		exp++
+	}
+	out := fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+	if getConfig().NumberFormatEuropean {
+		out = strings.Replace(out, ".", ",", 1)
+	}
+	return out
+}
+
+// Parses sizes like "500", "500KB", "1.5 GB" into bytes. Units are binary, KB and KiB are the same.
+// Rates like "10MB/s" parse as their size per second.
+func parseByteSize(input string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(input)), "/S")
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		multiplier = int64(1) << (10 * uint(strings.IndexByte("KMGT", s[i])+1))
+		s = s[:i]
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size \"%s\"", input)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
 func boolS(val bool) string {
 	if val {
 		return "ON"
@@ -169,7 +211,7 @@ func isLatestGithubRelease() bool {
 }
 
 func getJSON(url string, target interface{}) error {
-	r, err := http.Get(url)
+	r, err := getHTTPClient(url).Get(url)
 	if err != nil {
 		return err
 	}
@@ -179,7 +221,7 @@ func getJSON(url string, target interface{}) error {
 }
 
 func getJSONwithHeaders(url string, target interface{}, headers map[string]string) error {
-	client := &http.Client{}
+	client := getHTTPClient(url)
 	req, _ := http.NewRequest("GET", url, nil)
 
 	for k, v := range headers {
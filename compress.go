@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// With compressOnSave, files with an extension on the channel's compressExtensions are saved as <name>.<ext>.zst
+// when it saves enough space. Decompress them with any zstd tool, e.g. "zstd -d file.txt.zst".
+
+const zstdExtension = ".zst"
+
+// Shared by every download, EncodeAll is safe to call from several at once
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+//#region Compress On Save
+
+// Formats that are compressed already, by extension or by how they start, and wouldn't shrink any further.
+var compressedExtensions = []string{
+	".zst", ".gz", ".tgz", ".bz2", ".xz", ".lz4", ".br", ".zip", ".7z", ".rar", ".cbz", ".cbr",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".heic", ".jxl",
+	".mp4", ".webm", ".mkv", ".mov", ".mp3", ".ogg", ".opus", ".flac", ".m4a",
+	".pdf", ".docx", ".xlsx", ".pptx", ".odt", ".epub", ".apk", ".jar",
+}
+var compressedSignatures = [][]byte{
+	{0x28, 0xB5, 0x2F, 0xFD},           // zstd
+	{0x1F, 0x8B},                       // gzip
+	{'B', 'Z', 'h'},                    // bzip2
+	{0xFD, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'P', 'K', 0x03, 0x04},             // zip
+	{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}, // 7z
+	{'R', 'a', 'r', '!', 0x1A, 0x07},   // rar
+	{0x04, 0x22, 0x4D, 0x18},           // lz4
+}
+
+func isCompressedFormat(extension string, data []byte) bool {
+	if stringInSlice(extension, compressedExtensions) {
+		return true
+	}
+	for _, signature := range compressedSignatures {
+		if bytes.HasPrefix(data, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// The compressed copy of data if the channel compresses files like it and it saves at least compressMinSavings
+// percent, nil if it's to be saved as it is.
+func compressForSaving(channelConfig configurationChannel, extension string, data []byte) []byte {
+	if !*channelConfig.CompressOnSave || len(data) == 0 ||
+		!stringInSlice(extension, *channelConfig.CompressExtensions) || isCompressedFormat(extension, data) {
+		return nil
+	}
+	compressed := zstdEncoder.EncodeAll(data, nil)
+	if saved := 100 - int64(len(compressed))*100/int64(len(data)); saved < int64(*channelConfig.CompressMinSavings) {
+		return nil
+	}
+	return compressed
+}
+
+// Extension of a saved file, with the one under .zst for compressed files so numbering goes before both.
+func savedExtension(path string) string {
+	extension := filepathExtension(path)
+	if strings.EqualFold(extension, zstdExtension) {
+		extension = filepathExtension(strings.TrimSuffix(path, extension)) + extension
+	}
+	return extension
+}
+
+//#endregion
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed files come back the same through a decoder, and text shrinks enough to be worth saving that way.
+func TestCompressForSaving(t *testing.T) {
+	var channelConfig configurationChannel
+	channelDefault(&channelConfig)
+	enabled, extensions, savings := true, []string{".txt"}, 20
+	channelConfig.CompressOnSave, channelConfig.CompressExtensions, channelConfig.CompressMinSavings = &enabled, &extensions, &savings
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 10000))
+	compressed := compressForSaving(channelConfig, ".txt", text)
+	if compressed == nil || len(compressed) > len(text)/20 {
+		t.Fatalf("Text wasn't compressed enough, %d of %d bytes", len(compressed), len(text))
+	}
+	if decompressed, err := decoder.DecodeAll(compressed, nil); err != nil || !bytes.Equal(decompressed, text) {
+		t.Fatalf("Text didn't come back the same: %v", err)
+	}
+	if compressForSaving(channelConfig, ".png", text) != nil {
+		t.Error("Compressed a file whose extension isn't on the list")
+	}
+}
@@ -3,21 +3,51 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
+	"github.com/BurntSushi/toml"
 	"github.com/bwmarrin/discordgo"
 	"github.com/fatih/color"
 	"github.com/muhammadmuzzammil1998/jsonc"
 	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	config = defaultConfiguration()
+	// The active config, swapped whole by reloads and never changed in place. Anything handling a message or
+	// download takes one snapshot with getConfig and keeps to it, so it never mixes an old and a new config
+	configPointer = func() *atomic.Pointer[configuration] {
+		pointer := new(atomic.Pointer[configuration])
+		defaults := defaultConfiguration()
+		pointer.Store(&defaults)
+		return pointer
+	}()
+	// Held while loading or reloading, so two reloads can't interleave
+	configMutex sync.Mutex
 )
 
+func getConfig() *configuration {
+	return configPointer.Load()
+}
+
+func setConfig(settings configuration) {
+	configPointer.Store(&settings)
+}
+
 //#region Credentials
 
 var (
@@ -32,13 +62,109 @@ type configurationCredentials struct {
 	Email    string `json:"email,omitempty"`    // required for login (this or token)
 	Password string `json:"password,omitempty"` // required for login (this or token)
 	UserBot  bool   `json:"userBot,omitempty"`  // required
+	// API
+	APIToken string `json:"apiToken,omitempty"` // optional, required for apiAddress
+	// Web Gallery
+	WebUsername string `json:"webUsername,omitempty"` // optional, required for webAddress
+	WebPassword string `json:"webPassword,omitempty"` // optional, required for webAddress
+	// History Readers
+	AltTokens []string `json:"altTokens,omitempty"` // optional, only used to read messages for history
 	// APIs
 	TwitterAccessToken         string `json:"twitterAccessToken,omitempty"`         // optional
 	TwitterAccessTokenSecret   string `json:"twitterAccessTokenSecret,omitempty"`   // optional
 	TwitterConsumerKey         string `json:"twitterConsumerKey,omitempty"`         // optional
 	TwitterConsumerSecret      string `json:"twitterConsumerSecret,omitempty"`      // optional
 	FlickrApiKey               string `json:"flickrApiKey,omitempty"`               // optional
+	GiphyApiKey                string `json:"giphyApiKey,omitempty"`                // optional
 	GoogleDriveCredentialsJSON string `json:"googleDriveCredentialsJSON,omitempty"` // optional
+	// Storage
+	S3Endpoint         string `json:"s3Endpoint,omitempty"`         // optional, required for s3:// destinations
+	S3AccessKey        string `json:"s3AccessKey,omitempty"`        // optional
+	S3SecretKey        string `json:"s3SecretKey,omitempty"`        // optional
+	S3Region           string `json:"s3Region,omitempty"`           // optional
+	S3DisableSSL       bool   `json:"s3DisableSSL,omitempty"`       // optional, defaults
+	WebDAVUsername     string `json:"webdavUsername,omitempty"`     // optional
+	WebDAVPassword     string `json:"webdavPassword,omitempty"`     // optional
+	SFTPUsername       string `json:"sftpUsername,omitempty"`       // optional
+	SFTPPassword       string `json:"sftpPassword,omitempty"`       // optional
+	SFTPKeyFile        string `json:"sftpKeyFile,omitempty"`        // optional
+	SFTPKeyPassphrase  string `json:"sftpKeyPassphrase,omitempty"`  // optional
+	SFTPKnownHostsFile string `json:"sftpKnownHostsFile,omitempty"` // optional
+}
+
+// Env prefix for credential overrides, e.g. DDG_TOKEN or DDG_GOOGLE_DRIVE_CREDENTIALS_JSON_FILE
+const credentialsEnvPrefix = "DDG_"
+
+// Overrides credentials from environment variables, or from files named by *_FILE variables (Docker secrets).
+// Empty variables are ignored so they don't clobber values from the settings file.
+func applyCredentialOverrides(credentials *configurationCredentials) {
+	v := reflect.ValueOf(credentials).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		envName := credentialsEnvPrefix + camelToEnvName(key)
+
+		value, source := os.Getenv(envName), envName
+		if value == "" {
+			if secretPath := os.Getenv(envName + "_FILE"); secretPath != "" {
+				secret, err := ioutil.ReadFile(secretPath)
+				if err != nil {
+					log.Println(logPrefixSettings, color.HiRedString("Failed to read %s_FILE \"%s\", keeping settings file value...\t%s", envName, secretPath, err))
+					continue
+				}
+				value, source = strings.TrimSpace(string(secret)), envName+"_FILE"
+			}
+		}
+		if value == "" {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Println(logPrefixSettings, color.HiRedString("Invalid boolean in %s, keeping settings file value...", source))
+				continue
+			}
+			field.SetBool(parsed)
+		case reflect.Slice:
+			var values []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+			field.Set(reflect.ValueOf(values))
+		}
+		log.Println(logPrefixSettings, color.YellowString("Using credentials.%s from %s (%s)", key, source, redactCredential(value)))
+	}
+}
+
+// googleDriveCredentialsJSON -> GOOGLE_DRIVE_CREDENTIALS_JSON
+func camelToEnvName(key string) string {
+	var out []rune
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToUpper(r))
+	}
+	return string(out)
+}
+
+// Never any of the secret itself, only whether it's set and how long it is.
+func redactCredential(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if value == "" {
+		return "empty"
+	}
+	return fmt.Sprintf("set, %d characters", len(value))
 }
 
 //#endregion
@@ -57,11 +183,13 @@ var (
 	cdCheckPermissions     bool   = true
 	cdAllowGlobalCommands  bool   = true
 	cdGithubUpdateChecking bool   = true
+	cdWatchSettings        bool   = true
 	// Appearance
 	cdPresenceEnabled     bool               = true
 	cdPresenceStatus      string             = string(discordgo.StatusIdle)
 	cdPresenceType        discordgo.GameType = discordgo.GameTypeGame
 	cdReactWhenDownloaded bool               = true
+	cdReactionsPerMinute  int                = 30
 	cdInflateCount        int64              = 0
 )
 
@@ -84,17 +212,30 @@ func defaultConfiguration() configuration {
 		AllowGlobalCommands:            cdAllowGlobalCommands,
 		AutorunHistory:                 false,
 		AsynchronousHistory:            false,
+		HistoryRequestDelay:            "0s",
+		HistoryBatchSize:               historyBatchSizeMax,
 		DownloadRetryMax:               3,
 		DownloadTimeout:                60,
+		MaxRedirects:                   downloadMaxRedirectsDefault,
 		GithubUpdateChecking:           cdGithubUpdateChecking,
+		WatchSettings:                  cdWatchSettings,
+		CreateDestinations:             false,
+		PreflightChecks:                false,
+		MaxDomainConnections:           2,
 		DiscordLogLevel:                discordgo.LogError,
 		FilterDuplicateImages:          false,
 		FilterDuplicateImagesThreshold: 0,
+		FilterDuplicateVideos:          false,
+		FilterDuplicateVideosThreshold: 0,
+		ValidateImages:                 false,
+		PostDownloadCommandLimit:       2,
+		ErrorLogInterval:               5,
 		// Appearance
 		PresenceEnabled:      cdPresenceEnabled,
 		PresenceStatus:       cdPresenceStatus,
 		PresenceType:         cdPresenceType,
 		ReactWhenDownloaded:  cdReactWhenDownloaded,
+		ReactionsPerMinute:   cdReactionsPerMinute,
 		FilenameDateFormat:   "2006-01-02_15-04-05 ",
 		InflateCount:         &cdInflateCount,
 		NumberFormatEuropean: false,
@@ -113,16 +254,49 @@ type configuration struct {
 	CommandPrefix                  string                      `json:"commandPrefix"`                            // optional, defaults
 	AllowSkipping                  bool                        `json:"allowSkipping"`                            // optional, defaults
 	ScanOwnMessages                bool                        `json:"scanOwnMessages"`                          // optional, defaults
+	IgnoreAuthors                  []string                    `json:"ignoreAuthors,omitempty"`                  // optional
+	IgnoreRecordedLinks            bool                        `json:"ignoreRecordedLinks,omitempty"`            // optional, defaults
 	CheckPermissions               bool                        `json:"checkPermissions,omitempty"`               // optional, defaults
 	AllowGlobalCommands            bool                        `json:"allowGlobalCommmands,omitempty"`           // optional, defaults
 	AutorunHistory                 bool                        `json:"autorunHistory,omitempty"`                 // optional, defaults
 	AsynchronousHistory            bool                        `json:"asyncHistory,omitempty"`                   // optional, defaults
+	HistoryRequestDelay            string                      `json:"historyRequestDelay,omitempty"`            // optional, defaults
+	HistoryBatchSize               int                         `json:"historyBatchSize,omitempty"`               // optional, defaults
+	APIBudget                      int                         `json:"apiBudget,omitempty"`                      // optional, unlimited if undefined
 	DownloadRetryMax               int                         `json:"downloadRetryMax,omitempty"`               // optional, defaults
 	DownloadTimeout                int                         `json:"downloadTimeout,omitempty"`                // optional, defaults
+	DownloadTimeoutDomains         map[string]int              `json:"downloadTimeoutDomains,omitempty"`         // optional
+	MaxRedirects                   int                         `json:"maxRedirects,omitempty"`                   // optional, defaults
+	MaxRedirectsDomains            map[string]int              `json:"maxRedirectsDomains,omitempty"`            // optional
+	InsecureSkipVerifyDomains      []string                    `json:"insecureSkipVerifyDomains,omitempty"`      // optional
 	GithubUpdateChecking           bool                        `json:"githubUpdateChecking"`                     // optional, defaults
+	WatchSettings                  bool                        `json:"watchSettings"`                            // optional, defaults
+	PreflightChecks                bool                        `json:"preflightChecks,omitempty"`                // optional, defaults
+	MaxDownloadSpeed               string                      `json:"maxDownloadSpeed,omitempty"`               // optional, unlimited if undefined
+	MaxDomainConnections           int                         `json:"maxDomainConnections,omitempty"`           // optional, defaults
+	DownloadProxy                  string                      `json:"downloadProxy,omitempty"`                  // optional
+	DownloadProxyDomains           map[string]string           `json:"downloadProxyDomains,omitempty"`           // optional
+	CreateDestinations             bool                        `json:"createDestinations,omitempty"`             // optional, defaults
 	DiscordLogLevel                int                         `json:"discordLogLevel,omitempty"`                // optional, defaults
 	FilterDuplicateImages          bool                        `json:"filterDuplicateImages,omitempty"`          // optional, defaults
 	FilterDuplicateImagesThreshold float64                     `json:"filterDuplicateImagesThreshold,omitempty"` // optional, defaults
+	FilterDuplicateImagesMaxSize   int                         `json:"filterDuplicateImagesMaxSize,omitempty"`   // optional, unlimited if undefined
+	FilterDuplicateVideos          bool                        `json:"filterDuplicateVideos,omitempty"`          // optional, defaults
+	FilterDuplicateVideosThreshold float64                     `json:"filterDuplicateVideosThreshold,omitempty"` // optional, defaults, requires ffmpegPath
+	ValidateImages                 bool                        `json:"validateImages,omitempty"`                 // optional, defaults
+	PostDownloadCommandLimit       int                         `json:"postDownloadCommandLimit,omitempty"`       // optional, defaults
+	FFmpegPath                     string                      `json:"ffmpegPath,omitempty"`                     // optional
+	TesseractPath                  string                      `json:"tesseractPath,omitempty"`                  // optional
+	ConvertExtensions              map[string]string           `json:"convertExtensions,omitempty"`              // optional, requires ffmpegPath
+	Notifications                  []configurationNotification `json:"notifications,omitempty"`                  // optional
+	Mirrors                        []configurationMirror       `json:"mirrors,omitempty"`                        // optional
+	Feeds                          *configurationFeeds         `json:"feeds,omitempty"`                          // optional
+	Database                       *configurationDatabase      `json:"database,omitempty"`                       // optional, the embedded database if undefined
+	Schedule                       *configurationSchedule      `json:"schedule,omitempty"`                       // optional, downloads whenever if undefined
+	Logging                        *configurationLogging       `json:"logging,omitempty"`                        // optional, console output if undefined
+	Digest                         *configurationDigest        `json:"digest,omitempty"`                         // optional
+	ErrorLogInterval               int                         `json:"errorLogInterval,omitempty"`               // optional, defaults
+	ErrorLogSuppress               []string                    `json:"errorLogSuppress,omitempty"`               // optional
 	// Appearance
 	PresenceEnabled          bool               `json:"presenceEnabled"`                    // optional, defaults
 	PresenceStatus           string             `json:"presenceStatus"`                     // optional, defaults
@@ -131,10 +305,73 @@ type configuration struct {
 	PresenceOverwriteDetails *string            `json:"presenceOverwriteDetails,omitempty"` // optional, unused if undefined
 	PresenceOverwriteState   *string            `json:"presenceOverwriteState,omitempty"`   // optional, unused if undefined
 	ReactWhenDownloaded      bool               `json:"reactWhenDownloaded,omitempty"`      // optional, defaults
+	ReactionsPerMinute       int                `json:"reactionsPerMinute,omitempty"`       // optional, defaults
 	FilenameDateFormat       string             `json:"filenameDateFormat,omitempty"`       // optional, defaults
 	EmbedColor               *string            `json:"embedColor,omitempty"`               // optional, defaults to role if undefined, then defaults random if no role color
 	InflateCount             *int64             `json:"inflateCount,omitempty"`             // optional, defaults to 0 if undefined
 	NumberFormatEuropean     bool               `json:"numberFormatEuropean,omitempty"`     // optional, defaults
+	// Presence Rotation
+	Presence *configurationPresence `json:"presence,omitempty"` // optional, presenceOverwrite settings are used if undefined
+	// Slash Commands
+	SlashCommands *configurationSlashCommands `json:"slashCommands,omitempty"` // optional, only prefix commands if undefined
+	// Manual Downloads
+	ManualDestinations map[string]string `json:"manualDestinations,omitempty"` // optional, alias to path for the download command
+	// Disk Space
+	MinimumFreeSpace string `json:"minimumFreeSpace,omitempty"` // optional, unchecked if undefined
+	PauseOnLowSpace  bool   `json:"pauseOnLowSpace,omitempty"`  // optional, defaults
+	// User Sessions
+	UserSession *configurationUserSession `json:"userSession,omitempty"` // optional, defaults, only used when logged in as a user
+	// Avatar Tracking
+	AvatarTracking *configurationAvatarTracking `json:"avatarTracking,omitempty"` // optional, avatars aren't saved if undefined
+	// Snapshots
+	Snapshots *configurationSnapshots `json:"snapshots,omitempty"` // optional, only taken with the snapshot command if undefined
+	// URL Unwrapping
+	URLRewrites          map[string]string `json:"urlRewrites,omitempty"`          // optional, domain to replacement domain, in addition to the built-in frontends
+	URLShorteners        []string          `json:"urlShorteners,omitempty"`        // optional, in addition to the built-in shorteners
+	URLShortenersIgnored []string          `json:"urlShortenersIgnored,omitempty"` // optional
+	// Placeholders
+	PlaceholderHashes []string `json:"placeholderHashes,omitempty"` // optional, in addition to ones learned with markPlaceholder
+	// Soft Failures
+	SoftFailurePhrases []string `json:"softFailurePhrases,omitempty"` // optional, in addition to the built-in phrases
+	// Bot Challenges
+	FlareSolverrURL string `json:"flaresolverrURL,omitempty"` // optional, challenges aren't solved if undefined
+	// Failed URLs
+	SkipFailedURLsAfter int    `json:"skipFailedURLsAfter,omitempty"` // optional, always retried if undefined
+	FailedURLCooldown   string `json:"failedURLCooldown,omitempty"`   // optional, no cooldown if undefined
+	// Pending Downloads
+	PendingDownloadMaxAge string `json:"pendingDownloadMaxAge,omitempty"` // optional, defaults
+	// Blocklist
+	BlocklistFile string `json:"blocklistFile,omitempty"` // optional, nothing is blocked if undefined
+	// NSFW
+	SeparateNSFW bool `json:"separateNSFW,omitempty"` // optional, NSFW files are kept with the rest if undefined
+	// Network
+	BindAddress               string            `json:"bindAddress,omitempty"`               // optional, the system picks if undefined
+	DNSServers                []string          `json:"dnsServers,omitempty"`                // optional, the system resolver if undefined
+	HostsOverrides            map[string]string `json:"hostsOverrides,omitempty"`            // optional
+	NetworkSettingsForDiscord bool              `json:"networkSettingsForDiscord,omitempty"` // optional, Discord connects as usual if undefined
+	// Storage Mode
+	StorageMode string `json:"storageMode,omitempty"` // optional, plain if undefined
+	CASPath     string `json:"casPath,omitempty"`     // optional, defaults
+	// Integrity Check
+	IntegrityCheckOnStartup bool     `json:"integrityCheckOnStartup,omitempty"` // optional
+	IntegrityRepairs        []string `json:"integrityRepairs,omitempty"`        // optional, only reported if undefined
+	// Embed Proxies
+	ProxyFirstDomains []string `json:"proxyFirstDomains,omitempty"` // optional, the original is always tried first if undefined
+	// Site Handlers
+	Handlers             map[string]configurationHandler `json:"handlers,omitempty"`             // optional, every handler is on with the default timeout if undefined
+	HandlerCacheDuration string                          `json:"handlerCacheDuration,omitempty"` // optional, defaults
+	TwitterVideoQuality  string                          `json:"twitterVideoQuality,omitempty"`  // optional, highest if undefined
+	// API
+	APIAddress string `json:"apiAddress,omitempty"` // optional, disabled if undefined, localhost if no host is given
+	// Web Gallery
+	WebAddress string `json:"webAddress,omitempty"` // optional, disabled if undefined, localhost if no host is given
+	// Destinations
+	BasePath             string `json:"basePath,omitempty"`             // optional, relative destinations are relative to the working directory if undefined
+	DestinationLiveNames bool   `json:"destinationLiveNames,omitempty"` // optional, first seen names are kept if undefined
+	FolderNameEmoji      string `json:"folderNameEmoji,omitempty"`      // optional, emoji are kept if undefined
+	// Channel Inheritance
+	ChannelDefaults *configurationChannel           `json:"channelDefaults,omitempty"` // optional, fills in anything entries leave undefined
+	Profiles        map[string]configurationChannel `json:"profiles,omitempty"`        // optional, named sets of settings for entries' "profile"
 	// Channels
 	All                  *configurationChannel  `json:"all,omitempty"`                  // optional, defaults
 	AllBlacklistChannels *[]string              `json:"allBlacklistChannels,omitempty"` // optional
@@ -176,6 +413,7 @@ var (
 	ccdDivideFoldersByServer  bool = false
 	ccdDivideFoldersByChannel bool = false
 	ccdDivideFoldersByUser    bool = false
+	ccdDivideFoldersByArtist  bool = false
 	ccdDivideFoldersByType    bool = true
 	ccdSaveImages             bool = true
 	ccdSaveVideos             bool = true
@@ -183,6 +421,56 @@ var (
 	ccdSaveTextFiles          bool = false
 	ccdSaveOtherFiles         bool = false
 	ccdSavePossibleDuplicates bool = false
+	// Embeds
+	ccdSaveEmbedThumbnails     bool = false
+	ccdSkipThumbnailsWithImage bool = true
+	ccdIncludeReplyReferences  bool = false
+	// Linked Messages
+	ccdFolderLinkedMessagesBySource bool = false
+	// Limits
+	ccdMaxLinksPerMessage int = 0
+	ccdMaxFilesPerAlbum   int = 0
+	// GIFs
+	ccdGifFormat string = gifFormatGIF
+	// Conversion
+	ccdConvertWebPToPNG bool = false
+	ccdConvertAVIFToPNG bool = false
+	// Metadata
+	ccdEmbedSourceMetadata bool = false
+	ccdStripEXIF           bool = false
+	// Archives
+	ccdExtractArchives         bool = false
+	ccdDeleteExtractedArchives bool = false
+	// Compression
+	ccdCompressOnSave     bool = false
+	ccdCompressMinSavings int  = 20
+	ccdCompressExtensions      = []string{".txt", ".log", ".json", ".csv", ".tsv", ".xml", ".md", ".html", ".htm", ".svg", ".sql"}
+	// Thumbnails
+	ccdGenerateThumbnails bool = false
+	// Post Download
+	ccdPostDownloadCommandBlocking  bool = false
+	ccdPostDownloadCommandTimeout   int  = 60
+	ccdPostDownloadCommandLogErrors bool = false
+	// Source Message
+	ccdDeleteAfterDownload   bool = false
+	ccdDeleteAfterDuplicates bool = false
+	// Pins
+	ccdAutoDownloadPins bool = false
+	// Deleted Messages
+	ccdMarkDeletedMessages bool = false
+	ccdDownloadOnDelete    bool = false
+	// Duplicates
+	ccdDuplicateAction              string = "skip"
+	ccdCheckFilesystemForDuplicates bool   = false
+	ccdRecordFilesystemDuplicates   bool   = false
+	// Filename Collisions
+	ccdCollisionStrategy   string = "id"
+	ccdSameNameAttachments string = "index"
+	// Quotas
+	ccdQuotaPeriod     string = "total"
+	ccdQuotaNotifyUser bool   = false
+	// Large Files
+	ccdLargeFileThreshold string = "1GB"
 )
 
 type configurationChannel struct {
@@ -193,13 +481,21 @@ type configurationChannel struct {
 	ServerIDs           *[]string `json:"servers,omitempty"`           // ---> alternative to ServerID
 	BlacklistChannelIDs *[]string `json:"blacklistChannels,omitempty"` // for server.ServerID & server.ServerIDs
 	Destination         string    `json:"destination"`                 // required
+	// Inheritance
+	Profile   string            `json:"profile,omitempty"` // optional, name of one of settings.profiles
+	inherited map[string]string // setting -> profile or channelDefaults it came from, for validation
+	entry     string            // e.g. "channels[2]", set by validateConfig for issues found after parsing
 	// Setup
-	Enabled                 *bool `json:"enabled,omitempty"`                 // optional, defaults
-	AllowCommands           *bool `json:"allowCommands,omitempty"`           // optional, defaults
-	ErrorMessages           *bool `json:"errorMessages,omitempty"`           // optional, defaults
-	ScanEdits               *bool `json:"scanEdits,omitempty"`               // optional, defaults
-	IgnoreBots              *bool `json:"ignoreBots,omitempty"`              // optional, defaults
-	OverwriteAutorunHistory *bool `json:"overwriteAutorunHistory,omitempty"` // optional
+	Enabled                 *bool   `json:"enabled,omitempty"`                 // optional, defaults
+	AllowCommands           *bool   `json:"allowCommands,omitempty"`           // optional, defaults
+	ErrorMessages           *bool   `json:"errorMessages,omitempty"`           // optional, defaults
+	ScanEdits               *bool   `json:"scanEdits,omitempty"`               // optional, defaults
+	IgnoreBots              *bool   `json:"ignoreBots,omitempty"`              // optional, defaults
+	OverwriteAutorunHistory *bool   `json:"overwriteAutorunHistory,omitempty"` // optional
+	AutoHistoryOnStart      *bool   `json:"autoHistoryOnStart,omitempty"`      // optional
+	AutoHistoryInterval     *string `json:"autoHistoryInterval,omitempty"`     // optional
+	// Permissions
+	AdminRoles *[]string `json:"adminRoles,omitempty"` // optional, role IDs treated as bot admins for the server(s)
 	// Appearance
 	UpdatePresence             *bool     `json:"updatePresence,omitempty"`             // optional, defaults
 	ReactWhenDownloaded        *bool     `json:"reactWhenDownloaded,omitempty"`        // optional, defaults
@@ -215,6 +511,7 @@ type configurationChannel struct {
 	DivideFoldersByServer  *bool `json:"divideFoldersByServer,omitempty"`  // optional, defaults
 	DivideFoldersByChannel *bool `json:"divideFoldersByChannel,omitempty"` // optional, defaults
 	DivideFoldersByUser    *bool `json:"divideFoldersByUser,omitempty"`    // optional, defaults
+	DivideFoldersByArtist  *bool `json:"divideFoldersByArtist,omitempty"`  // optional, defaults
 	DivideFoldersByType    *bool `json:"divideFoldersByType,omitempty"`    // optional, defaults
 	SaveImages             *bool `json:"saveImages,omitempty"`             // optional, defaults
 	SaveVideos             *bool `json:"saveVideos,omitempty"`             // optional, defaults
@@ -222,6 +519,83 @@ type configurationChannel struct {
 	SaveTextFiles          *bool `json:"saveTextFiles,omitempty"`          // optional, defaults
 	SaveOtherFiles         *bool `json:"saveOtherFiles,omitempty"`         // optional, defaults
 	SavePossibleDuplicates *bool `json:"savePossibleDuplicates,omitempty"` // optional, defaults
+	// Embeds
+	SaveEmbedThumbnails     *bool `json:"saveEmbedThumbnails,omitempty"`     // optional, defaults
+	SkipThumbnailsWithImage *bool `json:"skipThumbnailsWithImage,omitempty"` // optional, defaults
+	IncludeReplyReferences  *bool `json:"includeReplyReferences,omitempty"`  // optional, defaults
+	// Linked Messages
+	FolderLinkedMessagesBySource *bool `json:"folderLinkedMessagesBySource,omitempty"` // optional, defaults
+	// Limits
+	MaxLinksPerMessage *int `json:"maxLinksPerMessage,omitempty"` // optional, defaults, 0 for no limit
+	MaxFilesPerAlbum   *int `json:"maxFilesPerAlbum,omitempty"`   // optional, defaults, 0 for no limit
+	// Requests
+	DownloadTimeout           *int      `json:"downloadTimeout,omitempty"`           // optional, the global downloadTimeout if undefined
+	MaxRedirects              *int      `json:"maxRedirects,omitempty"`              // optional, the global maxRedirects if undefined
+	InsecureSkipVerifyDomains *[]string `json:"insecureSkipVerifyDomains,omitempty"` // optional, in addition to the global insecureSkipVerifyDomains
+	// GIFs
+	GifFormat *string `json:"gifFormat,omitempty"` // optional, defaults, gif or mp4
+	// Conversion
+	ConvertWebPToPNG *bool `json:"convertWebPToPNG,omitempty"` // optional, defaults
+	ConvertAVIFToPNG *bool `json:"convertAVIFToPNG,omitempty"` // optional, defaults, requires ffmpegPath
+	// Metadata
+	EmbedSourceMetadata *bool `json:"embedSourceMetadata,omitempty"` // optional, defaults
+	StripEXIF           *bool `json:"stripEXIF,omitempty"`           // optional, defaults
+	// Archives
+	ExtractArchives         *bool `json:"extractArchives,omitempty"`         // optional, defaults
+	DeleteExtractedArchives *bool `json:"deleteExtractedArchives,omitempty"` // optional, defaults
+	// Compression
+	CompressOnSave     *bool     `json:"compressOnSave,omitempty"`     // optional, defaults
+	CompressExtensions *[]string `json:"compressExtensions,omitempty"` // optional, defaults
+	CompressMinSavings *int      `json:"compressMinSavings,omitempty"` // optional, defaults, percent of the original size
+	// Thumbnails
+	GenerateThumbnails *bool `json:"generateThumbnails,omitempty"` // optional, defaults, videos require ffmpegPath
+	// Post Download
+	PostDownloadCommand          *string `json:"postDownloadCommand,omitempty"`          // optional
+	PostDownloadCommandBlocking  *bool   `json:"postDownloadCommandBlocking,omitempty"`  // optional, defaults
+	PostDownloadCommandTimeout   *int    `json:"postDownloadCommandTimeout,omitempty"`   // optional, defaults
+	PostDownloadCommandLogErrors *bool   `json:"postDownloadCommandLogErrors,omitempty"` // optional, defaults
+	// Message Content
+	SaveMessageContent       *string `json:"saveMessageContent,omitempty"`       // optional, unused if undefined
+	SaveMessageContentFormat *string `json:"saveMessageContentFormat,omitempty"` // optional, defaults to markdown
+	// Source Message
+	DeleteAfterDownload      *bool   `json:"deleteAfterDownload,omitempty"`      // optional, defaults
+	DeleteAfterDownloadDelay *string `json:"deleteAfterDownloadDelay,omitempty"` // optional, immediately if undefined
+	DeleteAfterDuplicates    *bool   `json:"deleteAfterDuplicates,omitempty"`    // optional, defaults
+	ReplyAfterDownload       *string `json:"replyAfterDownload,omitempty"`       // optional, no reply if undefined
+	// Pins
+	AutoDownloadPins *bool `json:"autoDownloadPins,omitempty"` // optional, defaults
+	// NSFW
+	NSFWDestinationOverride *string `json:"nsfwDestinationOverride,omitempty"` // optional, NSFW files go to the usual destination if undefined
+	// Large Files
+	LargeFileDestination *string `json:"largeFileDestination,omitempty"` // optional, large files go to the usual destination if undefined
+	LargeFileThreshold   *string `json:"largeFileThreshold,omitempty"`   // optional, defaults
+	// Deleted Messages
+	MarkDeletedMessages *bool   `json:"markDeletedMessages,omitempty"` // optional, defaults
+	DeletedFilesAction  *string `json:"deletedFilesAction,omitempty"`  // optional, files stay where they are if undefined
+	DownloadOnDelete    *bool   `json:"downloadOnDelete,omitempty"`    // optional, defaults
+	// Duplicates
+	DuplicateAction              *string `json:"duplicateAction,omitempty"`              // optional, defaults
+	CheckFilesystemForDuplicates *bool   `json:"checkFilesystemForDuplicates,omitempty"` // optional, defaults
+	RecordFilesystemDuplicates   *bool   `json:"recordFilesystemDuplicates,omitempty"`   // optional, defaults
+	// Filenames
+	FilenameFormat *string `json:"filenameFormat,omitempty"` // optional, date and filename if undefined
+	// Filename Collisions
+	CollisionStrategy   *string `json:"collisionStrategy,omitempty"`   // optional, defaults
+	SameNameAttachments *string `json:"sameNameAttachments,omitempty"` // optional, defaults
+	// Storage Mode
+	StorageMode *string `json:"storageMode,omitempty"` // optional, the global storageMode if undefined
+	// Quotas
+	MaxBytesPerUser    *string `json:"maxBytesPerUser,omitempty"`    // optional, unlimited if undefined
+	MaxBytesPerChannel *string `json:"maxBytesPerChannel,omitempty"` // optional, unlimited if undefined
+	QuotaPeriod        *string `json:"quotaPeriod,omitempty"`        // optional, defaults
+	QuotaTimezone      *string `json:"quotaTimezone,omitempty"`      // optional, local time if undefined
+	QuotaNotifyUser    *bool   `json:"quotaNotifyUser,omitempty"`    // optional, defaults
+	// Retention
+	Retention *string `json:"retention,omitempty"` // optional, files are kept for good if undefined
+	// Notifications
+	Notifications *[]configurationNotification `json:"notifications,omitempty"` // optional, in addition to global notifications
+	// Digest
+	Digest *configurationDigest `json:"digest,omitempty"` // optional, in addition to the global digest
 	// Misc Rules
 	Filters     *configurationChannelFilters `json:"filters,omitempty"`     // optional
 	LogLinks    *configurationChannelLog     `json:"logLinks,omitempty"`    // optional
@@ -247,6 +621,10 @@ var (
 		"don't save",
 		"no save",
 	}
+	ccfdSkipHTML            bool    = false
+	ccfdSaveAnimatedImages  bool    = true
+	ccfdSkipTextHeavyImages bool    = false
+	ccfdTextHeavyThreshold  float64 = 0.15
 )
 
 type configurationChannelFilters struct {
@@ -264,6 +642,27 @@ type configurationChannelFilters struct {
 
 	BlockedDomains *[]string `json:"blockedDomains,omitempty"` // optional
 	AllowedDomains *[]string `json:"allowedDomains,omitempty"` // optional
+	// Which hostnames the domain filters check when a link redirects: "original", "final" or "both"
+	BlockRedirectsToBlockedDomains *string `json:"blockRedirectsToBlockedDomains,omitempty"` // optional, both if undefined
+
+	BlockedMIMETypes *[]string `json:"blockedMIMETypes,omitempty"` // optional
+	AllowedMIMETypes *[]string `json:"allowedMIMETypes,omitempty"` // optional
+	// Which MIME type is filtered when the sniffed one and the Content-Type header disagree: "detected" or "header"
+	MIMETypeSource *string `json:"mimeTypeSource,omitempty"` // optional, detected if undefined
+	SkipHTML       *bool   `json:"skipHTML,omitempty"`       // optional, defaults
+
+	SaveAnimatedImages *bool `json:"saveAnimatedImages,omitempty"` // optional, defaults
+
+	MinFileSize *string `json:"minFileSize,omitempty"` // optional
+	MaxFileSize *string `json:"maxFileSize,omitempty"` // optional
+
+	// Images narrower or shorter than these are skipped, checked against the embed's size first when it gives one
+	MinWidth  *int `json:"minWidth,omitempty"`  // optional
+	MinHeight *int `json:"minHeight,omitempty"` // optional
+
+	// Images whose words, as read by Tesseract, cover more of them than the threshold are skipped
+	SkipTextHeavyImages *bool    `json:"skipTextHeavyImages,omitempty"` // optional, defaults, requires tesseractPath
+	TextHeavyThreshold  *float64 `json:"textHeavyThreshold,omitempty"`  // optional, defaults
 }
 
 var (
@@ -308,6 +707,7 @@ type configurationAdminChannel struct {
 	LogStatus      *bool     `json:"logStatus,omitempty"`      // optional, defaults
 	LogErrors      *bool     `json:"logErrors,omitempty"`      // optional, defaults
 	UnlockCommands *bool     `json:"unlockCommands,omitempty"` // optional, defaults
+	LogSeverities  *[]string `json:"logSeverities,omitempty"`  // optional, all if undefined
 
 	/* IDEAS / TODO:
 
@@ -319,25 +719,211 @@ type configurationAdminChannel struct {
 
 //#endregion
 
+//#region Logging
+
+var (
+	lcdFileMaxSizeMB int = 10
+	lcdFileMaxFiles  int = 5
+)
+
+type configurationLogging struct {
+	Format  string                    `json:"format,omitempty"`  // optional, defaults to "console"
+	Level   string                    `json:"level,omitempty"`   // optional, "info" or "debug" with debugOutput if undefined
+	Modules map[string]string         `json:"modules,omitempty"` // optional, module to level
+	File    *configurationLoggingFile `json:"file,omitempty"`    // optional, in addition to stdout
+}
+
+type configurationLoggingFile struct {
+	Path      string `json:"path"`                // required
+	Rotate    string `json:"rotate,omitempty"`    // optional, defaults to "size"
+	MaxSizeMB *int   `json:"maxSizeMB,omitempty"` // optional, defaults
+	MaxFiles  *int   `json:"maxFiles,omitempty"`  // optional, defaults
+}
+
+//#endregion
+
+//#region Digest
+
+type configurationDigest struct {
+	ChannelID       string `json:"channel"`                   // required
+	Interval        string `json:"interval,omitempty"`        // optional, defaults to "1h"
+	ReplaceMessages bool   `json:"replaceMessages,omitempty"` // optional, no reactions or failure messages for channels it covers
+}
+
+//#endregion
+
+//#region Presence
+
+var (
+	pcdInterval string = "30s"
+	pcdHistory  string = "History: {historyFiles} files in #{historyChannelName}"
+)
+
+type configurationPresence struct {
+	Templates []string `json:"templates,omitempty"` // optional, rotated through in order, presenceOverwrite or the counter if undefined
+	Interval  string   `json:"interval,omitempty"`  // optional, defaults
+	Type      string   `json:"type,omitempty"`      // optional, presenceType if undefined
+	Status    string   `json:"status,omitempty"`    // optional, presenceStatus if undefined
+	History   *string  `json:"history,omitempty"`   // optional, defaults, shown while history runs, empty to keep rotating
+}
+
+//#endregion
+
+//#region Slash Commands
+
+var (
+	scdScope       string = "guild"
+	scdPermissions string = "manageServer"
+)
+
+type configurationSlashCommands struct {
+	Scope       string   `json:"scope,omitempty"`       // optional, defaults, "guild" registers to each server for instant updates, "global" can take an hour to show
+	Guilds      []string `json:"guilds,omitempty"`      // optional, every server the bot is in if undefined, only used for "guild"
+	Permissions string   `json:"permissions,omitempty"` // optional, defaults
+}
+
+//#endregion
+
+//#region Avatar Tracking
+
+var actdGuildImages bool = true
+
+type configurationAvatarTracking struct {
+	Guilds      []string `json:"guilds"`                // required
+	Destination string   `json:"destination"`           // required
+	GuildImages *bool    `json:"guildImages,omitempty"` // optional, defaults, server icons, banners and splashes
+}
+
+//#endregion
+
+//#region Snapshots
+
+var (
+	sncdInterval string = "168h"
+	sncdKeep     int    = 10
+)
+
+type configurationSnapshots struct {
+	Interval string `json:"interval,omitempty"` // optional, defaults, also taken whenever something's renamed
+	Keep     int    `json:"keep,omitempty"`     // optional, defaults, snapshots kept for each server
+}
+
+//#endregion
+
+//#region Site Handlers
+
+type configurationHandler struct {
+	Enabled  *bool  `json:"enabled,omitempty"`  // optional, defaults to true
+	Timeout  string `json:"timeout,omitempty"`  // optional, defaults to 15s
+	CheckURL string `json:"checkURL,omitempty"` // optional, known-good link for the "handler selfcheck" command
+}
+
+//#endregion
+
+//#region User Sessions
+
+var (
+	usdReactions    bool   = false
+	usdPresence     bool   = false
+	usdEmbeds       bool   = false
+	usdHistoryDelay string = "2s"
+)
+
+type configurationUserSession struct {
+	Reactions    *bool  `json:"reactions,omitempty"`    // optional, defaults
+	Presence     *bool  `json:"presence,omitempty"`     // optional, defaults
+	Embeds       *bool  `json:"embeds,omitempty"`       // optional, defaults
+	HistoryDelay string `json:"historyDelay,omitempty"` // optional, defaults
+}
+
+//#endregion
+
+//#region Notifications
+
+type configurationNotification struct {
+	URL          string    `json:"url"`                    // required
+	Format       string    `json:"format,omitempty"`       // optional, defaults to "json"
+	Events       *[]string `json:"events,omitempty"`       // optional, all if undefined
+	BatchSeconds int       `json:"batchSeconds,omitempty"` // optional, sent as they happen if undefined
+}
+
+//#endregion
+
+//#region Database
+
+type configurationDatabase struct {
+	Type string `json:"type,omitempty"` // optional, "tiedot" if undefined
+	Path string `json:"path,omitempty"` // optional, for "sqlite", defaults to "downloads.db" in the database folder
+	DSN  string `json:"dsn,omitempty"`  // required for "postgres"
+}
+
+//#endregion
+
+//#region Feeds
+
+type configurationFeeds struct {
+	Folder  string `json:"folder,omitempty"`  // optional, defaults to "feeds" in basePath
+	Items   int    `json:"items,omitempty"`   // optional, defaults to 50
+	BaseURL string `json:"baseURL,omitempty"` // optional, links files through the web gallery if defined
+}
+
+//#endregion
+
+//#region Schedule
+
+type configurationSchedule struct {
+	Windows    []configurationScheduleWindow `json:"windows"`              // required
+	DeferAbove string                        `json:"deferAbove,omitempty"` // optional, defaults
+}
+
+// Windows past midnight belong to the day they start on.
+type configurationScheduleWindow struct {
+	Days  []string `json:"days,omitempty"` // optional, every day if undefined
+	Start string   `json:"start"`          // required, "15:04"
+	End   string   `json:"end"`            // required, "15:04"
+}
+
+//#endregion
+
+//#region Mirrors
+
+type configurationMirror struct {
+	ChannelID    string    `json:"channel"`                // required
+	ChannelIDs   *[]string `json:"channels,omitempty"`     // ---> alternative to ChannelID
+	BotToken     string    `json:"botToken"`               // required
+	ChatID       string    `json:"chatID"`                 // required
+	Caption      string    `json:"caption,omitempty"`      // optional, defaults
+	MaxSize      string    `json:"maxSize,omitempty"`      // optional, defaults to "50MB"
+	ContentTypes *[]string `json:"contentTypes,omitempty"` // optional, all if undefined
+}
+
+//#endregion
+
+// Determines which settings file to use, preferring JSON when multiple exist.
 func initConfig() {
+	configFile = configFileBase + ".json"
+	configFileC = false
+	configFileFormat = "json"
 	if _, err := os.Stat(configFileBase + ".jsonc"); err == nil {
 		configFile = configFileBase + ".jsonc"
 		configFileC = true
-	} else {
-		configFile = configFileBase + ".json"
-		configFileC = false
+		return
+	}
+	if _, err := os.Stat(configFileBase + ".json"); err == nil {
+		return
+	}
+	for _, ext := range []string{".yaml", ".yml", ".toml"} {
+		if _, err := os.Stat(configFileBase + ext); err == nil {
+			configFile = configFileBase + ext
+			configFileFormat = strings.TrimPrefix(strings.Replace(ext, ".yml", ".yaml", 1), ".")
+			return
+		}
 	}
 }
 
 func loadConfig() {
-	// Determine json type
-	if _, err := os.Stat(configFileBase + ".jsonc"); err == nil {
-		configFile = configFileBase + ".jsonc"
-		configFileC = true
-	} else {
-		configFile = configFileBase + ".json"
-		configFileC = false
-	}
+	// Determine file type
+	initConfig()
 	// .
 	log.Println(logPrefixSettings, color.YellowString("Loading from \"%s\"...", configFile))
 	// Load settings
@@ -347,68 +933,21 @@ func loadConfig() {
 		createConfig()
 		properExit()
 	} else {
-		fixed := string(configContent)
-		// Fix backslashes
-		fixed = strings.ReplaceAll(fixed, "\\", "\\\\")
-		for strings.Contains(fixed, "\\\\\\") {
-			fixed = strings.ReplaceAll(fixed, "\\\\\\", "\\\\")
-		}
-		//TODO: Not even sure if this is realistic to do but would be nice to have line comma & trailing comma fixing
-
-		// Parse
-		newConfig := defaultConfiguration()
-		if configFileC {
-			err = jsonc.Unmarshal([]byte(fixed), &newConfig)
-		} else {
-			err = json.Unmarshal([]byte(fixed), &newConfig)
-		}
+		newConfig, err := parseConfig(configContent)
 		if err != nil {
 			log.Println(logPrefixSettings, color.HiRedString("Failed to parse settings file...\t%s", err))
-			log.Println(logPrefixSettings, color.MagentaString("Please ensure you're following proper JSON format syntax."))
+			log.Println(logPrefixSettings, color.MagentaString("Please ensure you're following proper %s format syntax.", strings.ToUpper(configFileFormat)))
 			properExit()
 		}
-		// Constants
-		if newConfig.Constants != nil {
-			for key, value := range newConfig.Constants {
-				if strings.Contains(fixed, key) {
-					fixed = strings.ReplaceAll(fixed, key, value)
-				}
-			}
-			// Re-parse
-			newConfig = defaultConfiguration()
-			if configFileC {
-				err = jsonc.Unmarshal([]byte(fixed), &newConfig)
-			} else {
-				err = json.Unmarshal([]byte(fixed), &newConfig)
-			}
-			if err != nil {
-				log.Println(logPrefixSettings, color.HiRedString("Failed to re-parse settings file after replacing constants...\t%s", err))
-				log.Println(logPrefixSettings, color.MagentaString("Please ensure you're following proper JSON format syntax."))
-				properExit()
-			}
-			newConfig.Constants = nil
-		}
-		config = newConfig
-
-		// Channel Config Defaults
-		// this is dumb but don't see a better way to initialize defaults
-		for i := 0; i < len(config.Servers); i++ {
-			channelDefault(&config.Servers[i])
-		}
-		for i := 0; i < len(config.Channels); i++ {
-			channelDefault(&config.Channels[i])
-		}
-		if config.All != nil {
-			channelDefault(config.All)
-		}
-
-		for i := 0; i < len(config.AdminChannels); i++ {
-			adminChannelDefault(&config.AdminChannels[i])
-		}
+		logConfigIssues(checkConfigDestinations(&newConfig))
+		configMutex.Lock()
+		setConfig(newConfig)
+		configMutex.Unlock()
+		configureLogging(newConfig.Logging, newConfig.DebugOutput)
 
 		// Debug Output
-		if config.DebugOutput {
-			s, err := json.MarshalIndent(config, "", "\t")
+		if newConfig.DebugOutput {
+			s, err := json.MarshalIndent(newConfig, "", "\t")
 			if err != nil {
 				log.Println(logPrefixSettings, logPrefixDebug, color.HiRedString("Failed to output...\t%s", err))
 			} else {
@@ -419,9 +958,7 @@ func loadConfig() {
 		}
 
 		// Credentials Check
-		if (config.Credentials.Token == "" || config.Credentials.Token == placeholderToken) &&
-			(config.Credentials.Email == "" || config.Credentials.Email == placeholderEmail) &&
-			(config.Credentials.Password == "" || config.Credentials.Password == placeholderPassword) {
+		if !hasValidCredentials(newConfig) {
 			log.Println(logPrefixSettings, color.HiRedString("No valid discord login found. Token, Email, and Password are all invalid..."))
 			log.Println(logPrefixSettings, color.HiYellowString("Please save your credentials & info into \"%s\" then restart...", configFile))
 			log.Println(logPrefixSettings, color.MagentaString("If your credentials are already properly saved, please ensure you're following proper JSON format syntax."))
@@ -431,6 +968,186 @@ func loadConfig() {
 	}
 }
 
+// Parses settings file contents into a new configuration with all defaults applied, leaving the active config untouched.
+func parseConfig(configContent []byte) (configuration, error) {
+	var err error
+	fixed := string(configContent)
+	if configFileFormat == "json" {
+		fixed = fixConfigBackslashes(fixed)
+		//TODO: Not even sure if this is realistic to do but would be nice to have line comma & trailing comma fixing
+	}
+
+	// Parse
+	newConfig := defaultConfiguration()
+	if err = unmarshalConfig([]byte(fixed), &newConfig); err != nil {
+		return newConfig, err
+	}
+	// Constants
+	if newConfig.Constants != nil {
+		for key, value := range newConfig.Constants {
+			if strings.Contains(fixed, key) {
+				fixed = strings.ReplaceAll(fixed, key, value)
+			}
+		}
+		// Re-parse
+		newConfig = defaultConfiguration()
+		if err = unmarshalConfig([]byte(fixed), &newConfig); err != nil {
+			return newConfig, fmt.Errorf("failed to re-parse after replacing constants: %s", err)
+		}
+		newConfig.Constants = nil
+	}
+
+	// Environment overrides
+	applyCredentialOverrides(&newConfig.Credentials)
+
+	// Channel Inheritance, entry over profile over channelDefaults
+	inheritanceIssues := resolveChannelInheritance(&newConfig)
+
+	// Channel Config Defaults
+	// this is dumb but don't see a better way to initialize defaults
+	for i := 0; i < len(newConfig.Servers); i++ {
+		channelDefault(&newConfig.Servers[i])
+	}
+	for i := 0; i < len(newConfig.Channels); i++ {
+		channelDefault(&newConfig.Channels[i])
+	}
+	if newConfig.All != nil {
+		channelDefault(newConfig.All)
+	}
+
+	for i := 0; i < len(newConfig.AdminChannels); i++ {
+		adminChannelDefault(&newConfig.AdminChannels[i])
+	}
+
+	if newConfig.Logging != nil {
+		loggingDefault(newConfig.Logging)
+	}
+	if newConfig.Presence != nil {
+		presenceDefault(newConfig.Presence)
+	}
+	if newConfig.SlashCommands != nil {
+		slashCommandsDefault(newConfig.SlashCommands)
+	}
+	if newConfig.AvatarTracking != nil {
+		avatarTrackingDefault(newConfig.AvatarTracking)
+	}
+	if newConfig.Snapshots != nil {
+		snapshotsDefault(newConfig.Snapshots)
+	}
+	if newConfig.UserSession == nil {
+		newConfig.UserSession = &configurationUserSession{}
+	}
+	userSessionDefault(newConfig.UserSession)
+
+	logConfigIssues(append(inheritanceIssues, validateConfig(&newConfig)...))
+
+	return newConfig, nil
+}
+
+// Escapes lone backslashes so Windows paths written as "C:\Downloads" still decode.
+func fixConfigBackslashes(content string) string {
+	fixed := strings.ReplaceAll(content, "\\", "\\\\")
+	for strings.Contains(fixed, "\\\\\\") {
+		fixed = strings.ReplaceAll(fixed, "\\\\\\", "\\\\")
+	}
+	return fixed
+}
+
+// Decodes settings in the active file format. YAML & TOML are decoded generically and passed
+// through JSON so every format shares the json struct tags, including the *bool/*string optionals.
+func unmarshalConfig(data []byte, v interface{}) error {
+	var generic interface{}
+	switch configFileFormat {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+	case "toml":
+		var table map[string]interface{}
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return err
+		}
+		generic = table
+	default:
+		if configFileC {
+			return jsonc.Unmarshal(data, v)
+		}
+		return json.Unmarshal(data, v)
+	}
+	converted, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(converted, v)
+}
+
+func hasValidCredentials(c configuration) bool {
+	return !((c.Credentials.Token == "" || c.Credentials.Token == placeholderToken) &&
+		(c.Credentials.Email == "" || c.Credentials.Email == placeholderEmail) &&
+		(c.Credentials.Password == "" || c.Credentials.Password == placeholderPassword))
+}
+
+// Re-reads the settings file and swaps it in place of the active config.
+// On failure the active config is kept and the error returned.
+// Returns the names of changed settings that can't be applied without a restart, those keep their current values.
+// With checkDestinations the destinations are checked too, see checkConfigDestinations.
+func reloadConfig(checkDestinations bool) ([]string, error) {
+	var restartRequired []string
+
+	configContent, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return restartRequired, err
+	}
+	newConfig, err := parseConfig(configContent)
+	if err != nil {
+		return restartRequired, err
+	}
+	if checkDestinations {
+		logConfigIssues(checkConfigDestinations(&newConfig))
+	}
+	if !hasValidCredentials(newConfig) {
+		return restartRequired, fmt.Errorf("no valid discord login found, token, email, and password are all invalid")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	previous := getConfig()
+
+	// Settings only used during startup
+	if !reflect.DeepEqual(newConfig.Credentials, previous.Credentials) {
+		restartRequired = append(restartRequired, "credentials")
+		newConfig.Credentials = previous.Credentials
+	}
+	if newConfig.FilterDuplicateImages != previous.FilterDuplicateImages {
+		restartRequired = append(restartRequired, "filterDuplicateImages")
+		newConfig.FilterDuplicateImages = previous.FilterDuplicateImages
+	}
+	if newConfig.FilterDuplicateVideos != previous.FilterDuplicateVideos {
+		restartRequired = append(restartRequired, "filterDuplicateVideos")
+		newConfig.FilterDuplicateVideos = previous.FilterDuplicateVideos
+	}
+	if !reflect.DeepEqual(newConfig.SlashCommands, previous.SlashCommands) {
+		restartRequired = append(restartRequired, "slashCommands")
+		newConfig.SlashCommands = previous.SlashCommands
+	}
+	if !reflect.DeepEqual(newConfig.Database, previous.Database) {
+		restartRequired = append(restartRequired, "database")
+		newConfig.Database = previous.Database
+	}
+	if newConfig.WatchSettings != previous.WatchSettings {
+		restartRequired = append(restartRequired, "watchSettings")
+		newConfig.WatchSettings = previous.WatchSettings
+	}
+
+	setConfig(newConfig)
+	configureLogging(newConfig.Logging, newConfig.DebugOutput)
+	configureMessageCache()
+	// Let downloads waiting on a domain re-check the new limit
+	domainConnectionsCond.Broadcast()
+	go updateFilesystemIndexes()
+	return restartRequired, nil
+}
+
 func createConfig() {
 	log.Println(logPrefixSetup, color.YellowString("Creating new settings file..."))
 
@@ -715,6 +1432,9 @@ func channelDefault(channel *configurationChannel) {
 	if channel.DivideFoldersByUser == nil {
 		channel.DivideFoldersByUser = &ccdDivideFoldersByUser
 	}
+	if channel.DivideFoldersByArtist == nil {
+		channel.DivideFoldersByArtist = &ccdDivideFoldersByArtist
+	}
 	if channel.DivideFoldersByType == nil {
 		channel.DivideFoldersByType = &ccdDivideFoldersByType
 	}
@@ -736,6 +1456,112 @@ func channelDefault(channel *configurationChannel) {
 	if channel.SavePossibleDuplicates == nil {
 		channel.SavePossibleDuplicates = &ccdSavePossibleDuplicates
 	}
+	if channel.SaveEmbedThumbnails == nil {
+		channel.SaveEmbedThumbnails = &ccdSaveEmbedThumbnails
+	}
+	if channel.SkipThumbnailsWithImage == nil {
+		channel.SkipThumbnailsWithImage = &ccdSkipThumbnailsWithImage
+	}
+	if channel.IncludeReplyReferences == nil {
+		channel.IncludeReplyReferences = &ccdIncludeReplyReferences
+	}
+	if channel.FolderLinkedMessagesBySource == nil {
+		channel.FolderLinkedMessagesBySource = &ccdFolderLinkedMessagesBySource
+	}
+	if channel.MaxLinksPerMessage == nil {
+		channel.MaxLinksPerMessage = &ccdMaxLinksPerMessage
+	}
+	if channel.MaxFilesPerAlbum == nil {
+		channel.MaxFilesPerAlbum = &ccdMaxFilesPerAlbum
+	}
+	if channel.GifFormat == nil {
+		channel.GifFormat = &ccdGifFormat
+	}
+	if channel.ConvertWebPToPNG == nil {
+		channel.ConvertWebPToPNG = &ccdConvertWebPToPNG
+	}
+	if channel.ConvertAVIFToPNG == nil {
+		channel.ConvertAVIFToPNG = &ccdConvertAVIFToPNG
+	}
+	if channel.EmbedSourceMetadata == nil {
+		channel.EmbedSourceMetadata = &ccdEmbedSourceMetadata
+	}
+	if channel.StripEXIF == nil {
+		channel.StripEXIF = &ccdStripEXIF
+	}
+	if channel.ExtractArchives == nil {
+		channel.ExtractArchives = &ccdExtractArchives
+	}
+	if channel.DeleteExtractedArchives == nil {
+		channel.DeleteExtractedArchives = &ccdDeleteExtractedArchives
+	}
+	if channel.CompressOnSave == nil {
+		channel.CompressOnSave = &ccdCompressOnSave
+	}
+	if channel.CompressExtensions == nil {
+		channel.CompressExtensions = &ccdCompressExtensions
+	}
+	if channel.CompressMinSavings == nil {
+		channel.CompressMinSavings = &ccdCompressMinSavings
+	}
+	if channel.GenerateThumbnails == nil {
+		channel.GenerateThumbnails = &ccdGenerateThumbnails
+	}
+
+	if channel.PostDownloadCommandBlocking == nil {
+		channel.PostDownloadCommandBlocking = &ccdPostDownloadCommandBlocking
+	}
+	if channel.PostDownloadCommandTimeout == nil {
+		channel.PostDownloadCommandTimeout = &ccdPostDownloadCommandTimeout
+	}
+	if channel.PostDownloadCommandLogErrors == nil {
+		channel.PostDownloadCommandLogErrors = &ccdPostDownloadCommandLogErrors
+	}
+
+	if channel.DeleteAfterDownload == nil {
+		channel.DeleteAfterDownload = &ccdDeleteAfterDownload
+	}
+	if channel.DeleteAfterDuplicates == nil {
+		channel.DeleteAfterDuplicates = &ccdDeleteAfterDuplicates
+	}
+
+	if channel.AutoDownloadPins == nil {
+		channel.AutoDownloadPins = &ccdAutoDownloadPins
+	}
+
+	if channel.MarkDeletedMessages == nil {
+		channel.MarkDeletedMessages = &ccdMarkDeletedMessages
+	}
+	if channel.DownloadOnDelete == nil {
+		channel.DownloadOnDelete = &ccdDownloadOnDelete
+	}
+
+	if channel.DuplicateAction == nil {
+		channel.DuplicateAction = &ccdDuplicateAction
+	}
+	if channel.CheckFilesystemForDuplicates == nil {
+		channel.CheckFilesystemForDuplicates = &ccdCheckFilesystemForDuplicates
+	}
+	if channel.RecordFilesystemDuplicates == nil {
+		channel.RecordFilesystemDuplicates = &ccdRecordFilesystemDuplicates
+	}
+	if channel.CollisionStrategy == nil {
+		channel.CollisionStrategy = &ccdCollisionStrategy
+	}
+	if channel.SameNameAttachments == nil {
+		channel.SameNameAttachments = &ccdSameNameAttachments
+	}
+
+	if channel.QuotaPeriod == nil {
+		channel.QuotaPeriod = &ccdQuotaPeriod
+	}
+	if channel.QuotaNotifyUser == nil {
+		channel.QuotaNotifyUser = &ccdQuotaNotifyUser
+	}
+
+	if channel.LargeFileThreshold == nil {
+		channel.LargeFileThreshold = &ccdLargeFileThreshold
+	}
 
 	if channel.Filters == nil {
 		channel.Filters = &configurationChannelFilters{}
@@ -746,6 +1572,18 @@ func channelDefault(channel *configurationChannel) {
 	if channel.Filters.BlockedPhrases == nil {
 		channel.Filters.BlockedPhrases = &ccfdBlockedPhrases
 	}
+	if channel.Filters.SkipHTML == nil {
+		channel.Filters.SkipHTML = &ccfdSkipHTML
+	}
+	if channel.Filters.SaveAnimatedImages == nil {
+		channel.Filters.SaveAnimatedImages = &ccfdSaveAnimatedImages
+	}
+	if channel.Filters.SkipTextHeavyImages == nil {
+		channel.Filters.SkipTextHeavyImages = &ccfdSkipTextHeavyImages
+	}
+	if channel.Filters.TextHeavyThreshold == nil {
+		channel.Filters.TextHeavyThreshold = &ccfdTextHeavyThreshold
+	}
 
 	if channel.LogLinks == nil {
 		channel.LogLinks = &configurationChannelLog{}
@@ -801,15 +1639,1336 @@ func adminChannelDefault(channel *configurationAdminChannel) {
 	}
 }
 
-//#region Channel Checks/Returns
-
-func isChannelRegistered(ChannelID string) bool {
-	for _, item := range config.Channels {
-		// Single Channel Config
-		if ChannelID == item.ChannelID {
-			return true
+func loggingDefault(logging *configurationLogging) {
+	if logging.Format == "" {
+		logging.Format = "console"
+	}
+	if logging.File != nil {
+		if logging.File.Rotate == "" {
+			logging.File.Rotate = "size"
 		}
-		// Multi-Channel Config
+		if logging.File.MaxSizeMB == nil {
+			logging.File.MaxSizeMB = &lcdFileMaxSizeMB
+		}
+		if logging.File.MaxFiles == nil {
+			logging.File.MaxFiles = &lcdFileMaxFiles
+		}
+	}
+}
+
+func presenceDefault(presence *configurationPresence) {
+	if presence.Interval == "" {
+		presence.Interval = pcdInterval
+	}
+	if presence.History == nil {
+		presence.History = &pcdHistory
+	}
+}
+
+func slashCommandsDefault(slashCommands *configurationSlashCommands) {
+	if slashCommands.Scope == "" {
+		slashCommands.Scope = scdScope
+	}
+	if slashCommands.Permissions == "" {
+		slashCommands.Permissions = scdPermissions
+	}
+}
+
+func avatarTrackingDefault(avatarTracking *configurationAvatarTracking) {
+	if avatarTracking.GuildImages == nil {
+		avatarTracking.GuildImages = &actdGuildImages
+	}
+}
+
+func snapshotsDefault(snapshots *configurationSnapshots) {
+	if snapshots.Interval == "" {
+		snapshots.Interval = sncdInterval
+	}
+	if snapshots.Keep == 0 {
+		snapshots.Keep = sncdKeep
+	}
+}
+
+func userSessionDefault(userSession *configurationUserSession) {
+	if userSession.Reactions == nil {
+		userSession.Reactions = &usdReactions
+	}
+	if userSession.Presence == nil {
+		userSession.Presence = &usdPresence
+	}
+	if userSession.Embeds == nil {
+		userSession.Embeds = &usdEmbeds
+	}
+	if userSession.HistoryDelay == "" {
+		userSession.HistoryDelay = usdHistoryDelay
+	}
+}
+
+//#region Channel Inheritance
+
+// Which registration a channel or server is, never inherited
+var channelInheritanceSkipped = []string{"ChannelID", "ChannelIDs", "ServerID", "ServerIDs", "Profile"}
+
+// Fills what each entry leaves undefined from its profile and then channelDefaults, before channelDefault
+// fills the rest. Only nil pointers and empty values are filled, so an explicit false stays false.
+func resolveChannelInheritance(c *configuration) []configIssue {
+	var issues []configIssue
+	resolve := func(entry string, channel *configurationChannel) {
+		if channel.Profile != "" {
+			if profile, exists := c.Profiles[channel.Profile]; exists {
+				inheritChannelConfig(channel, &profile, "profiles."+channel.Profile)
+			} else {
+				issues = append(issues, configIssue{false, entry, "profile", fmt.Sprintf("\"%s\" isn't in profiles, only channelDefaults apply", channel.Profile)})
+			}
+		}
+		if c.ChannelDefaults != nil {
+			inheritChannelConfig(channel, c.ChannelDefaults, "channelDefaults")
+		}
+	}
+	for i := range c.Channels {
+		resolve(fmt.Sprintf("channels[%d]", i), &c.Channels[i])
+	}
+	for i := range c.Servers {
+		resolve(fmt.Sprintf("servers[%d]", i), &c.Servers[i])
+	}
+	if c.All != nil {
+		resolve("all", c.All)
+	}
+	return issues
+}
+
+func inheritChannelConfig(channel *configurationChannel, from *configurationChannel, source string) {
+	if channel.inherited == nil {
+		channel.inherited = make(map[string]string)
+	}
+	inheritFields(reflect.ValueOf(channel).Elem(), reflect.ValueOf(from).Elem(), "", source, channel.inherited)
+}
+
+// Nested settings like filters are merged field by field, so setting one filter doesn't drop the rest.
+func inheritFields(dst reflect.Value, src reflect.Value, path string, source string, inherited map[string]string) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || (path == "" && stringInSlice(field.Name, channelInheritanceSkipped)) {
+			continue
+		}
+		key := path + strings.Split(field.Tag.Get("json"), ",")[0]
+		dstField, srcField := dst.Field(i), src.Field(i)
+		if srcField.IsZero() {
+			continue
+		}
+		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			if dstField.IsNil() {
+				dstField.Set(reflect.New(field.Type.Elem()))
+			}
+			inheritFields(dstField.Elem(), srcField.Elem(), key+".", source, inherited)
+		} else if dstField.IsZero() {
+			dstField.Set(srcField)
+			inherited[key] = source
+		}
+	}
+}
+
+// Where an entry's setting was inherited from, "" if it was set on the entry itself.
+func (channel *configurationChannel) inheritedFrom(field string) string {
+	if source, exists := channel.inherited[field]; exists {
+		return source
+	}
+	return channel.inherited[strings.Split(field, ".")[0]]
+}
+
+//#endregion
+
+//#region Conversion
+
+// Writes the current JSON settings file as YAML, keeping key order and commenting each key with its type.
+func convertConfigToYAML() (string, error) {
+	initConfig()
+	if configFileFormat != "json" {
+		return "", fmt.Errorf("\"%s\" is not a JSON settings file", configFile)
+	}
+	configContent, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+	if configFileC {
+		configContent = jsonc.ToJSON(configContent)
+	}
+	// Make sure it's actually valid before writing anything
+	if _, err := parseConfig(configContent); err != nil {
+		return "", err
+	}
+
+	// JSON is valid YAML, decoding into a node keeps the original key order
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(fixConfigBackslashes(string(configContent))), &document); err != nil {
+		return "", err
+	}
+	for _, node := range document.Content {
+		annotateConfigNode(node, reflect.TypeOf(configuration{}))
+	}
+	out, err := yaml.Marshal(&document)
+	if err != nil {
+		return "", err
+	}
+
+	outFile := configFileBase + ".yaml"
+	if _, err := os.Stat(outFile); err == nil {
+		return "", fmt.Errorf("\"%s\" already exists", outFile)
+	}
+	return outFile, ioutil.WriteFile(outFile, out, 0644)
+}
+
+// Switches JSON flow style to block style and comments mapping keys using the matching struct field.
+func annotateConfigNode(node *yaml.Node, t reflect.Type) {
+	node.Style = 0
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			key.Style = 0
+			var fieldType reflect.Type
+			if t != nil && t.Kind() == reflect.Struct {
+				for f := 0; f < t.NumField(); f++ {
+					field := t.Field(f)
+					if strings.Split(field.Tag.Get("json"), ",")[0] == key.Value {
+						fieldType = field.Type
+						key.HeadComment = describeConfigField(field)
+						break
+					}
+				}
+			}
+			annotateConfigNode(value, fieldType)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			annotateConfigNode(item, t)
+		}
+	}
+}
+
+func describeConfigField(field reflect.StructField) string {
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var kind string
+	switch t.Kind() {
+	case reflect.Bool:
+		kind = "boolean"
+	case reflect.String:
+		kind = "string"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		kind = "number"
+	case reflect.Slice:
+		kind = "list"
+	case reflect.Map:
+		kind = "map"
+	default:
+		kind = "section"
+	}
+	if strings.Contains(field.Tag.Get("json"), "omitempty") || field.Type.Kind() == reflect.Ptr {
+		return kind + ", optional"
+	}
+	return kind
+}
+
+//#endregion
+
+//#region Validation
+
+type configIssue struct {
+	Fatal   bool   // entry is dropped from the loaded config
+	Entry   string // e.g. "channels[2]"
+	Field   string
+	Message string
+}
+
+// Checks every registered source for mistakes that would otherwise surface later as failed downloads or panics.
+// Entries with fatal issues are removed so the rest of the config can still be used.
+func validateConfig(c *configuration) []configIssue {
+	var issues []configIssue
+	seenChannels := make(map[string]string)
+
+	checkIDs := func(entry string, field string, ids []string) bool {
+		valid := true
+		for _, id := range ids {
+			if !isNumeric(strings.TrimSpace(id)) {
+				issues = append(issues, configIssue{true, entry, field, fmt.Sprintf("\"%s\" is not a numeric Discord ID", id)})
+				valid = false
+			}
+		}
+		return valid
+	}
+
+	// Drops entries that would turn certificate checks off for far more than the hosts meant
+	checkInsecureDomains := func(entry string, field string, domains []string) []string {
+		var kept []string
+		for _, domain := range domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			switch {
+			case domain == "" || strings.Contains(domain, "*"):
+				issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("\"%s\" would cover every host, dropped, list each domain", domain)})
+			case strings.Contains(domain, "/") || strings.Contains(domain, ":"):
+				issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("\"%s\" isn't a domain, dropped, use just the host", domain)})
+			default:
+				kept = append(kept, domain)
+			}
+		}
+		return kept
+	}
+
+	// Drops targets that can't be used, they'd fail every time anyway
+	checkNotifications := func(entry string, targets []configurationNotification) []configurationNotification {
+		var kept []configurationNotification
+		for i, target := range targets {
+			entry, field := entry, fmt.Sprintf("notifications[%d]", i)
+			if entry == "" {
+				entry, field = field, ""
+			}
+			target.Format = strings.ToLower(target.Format)
+			if target.Format == "" {
+				target.Format = "json"
+			}
+			if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("\"%s\" is not an http(s) URL, target disabled", target.URL)})
+				continue
+			}
+			if target.Format != "json" && target.Format != "discord" {
+				issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("unknown format \"%s\", target disabled", target.Format)})
+				continue
+			}
+			if target.Events != nil {
+				for _, event := range *target.Events {
+					if !stringInSlice(event, notificationEvents) {
+						issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("unknown event \"%s\", expected one of %s", event, strings.Join(notificationEvents, ", "))})
+					}
+				}
+			}
+			kept = append(kept, target)
+		}
+		return kept
+	}
+
+	// Fills in the interval, returns false if the digest can't be used
+	checkDigest := func(entry string, digest *configurationDigest) bool {
+		entry, field := entry, "digest"
+		if entry == "" {
+			entry, field = field, ""
+		}
+		if !isNumeric(digest.ChannelID) {
+			issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("\"%s\" is not a numeric Discord ID, digest disabled", digest.ChannelID)})
+			return false
+		}
+		if digest.Interval == "" {
+			digest.Interval = "1h"
+		}
+		if interval, err := time.ParseDuration(digest.Interval); err != nil || interval < time.Minute {
+			issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("invalid interval \"%s\", must be at least 1m, using 1h", digest.Interval)})
+			digest.Interval = "1h"
+		}
+		return true
+	}
+
+	validateEntry := func(entry string, item *configurationChannel, isServer bool) bool {
+		valid := true
+		// Inherited values are reported against the profile they came from
+		defer func(first int) {
+			for i := first; i < len(issues); i++ {
+				if source := item.inheritedFrom(issues[i].Field); source != "" && issues[i].Entry == entry {
+					issues[i].Entry = fmt.Sprintf("%s (from %s)", entry, source)
+				}
+			}
+		}(len(issues))
+		// Sources
+		var ids []string
+		if isServer {
+			if item.ServerIDs != nil {
+				ids = *item.ServerIDs
+			} else if item.ServerID != "" {
+				ids = []string{item.ServerID}
+			}
+			if len(ids) == 0 {
+				issues = append(issues, configIssue{true, entry, "server", "no server or servers specified"})
+				valid = false
+			} else if !checkIDs(entry, "server", ids) {
+				valid = false
+			}
+			if item.BlacklistChannelIDs != nil && !checkIDs(entry, "blacklistChannels", *item.BlacklistChannelIDs) {
+				valid = false
+			}
+		} else {
+			if item.ChannelIDs != nil {
+				ids = *item.ChannelIDs
+			} else if item.ChannelID != "" {
+				ids = []string{item.ChannelID}
+			}
+			if len(ids) == 0 {
+				issues = append(issues, configIssue{true, entry, "channel", "no channel or channels specified"})
+				valid = false
+			} else if !checkIDs(entry, "channel", ids) {
+				valid = false
+			}
+			for _, id := range ids {
+				if previous, exists := seenChannels[id]; exists {
+					issues = append(issues, configIssue{false, entry, "channel", fmt.Sprintf("%s is also registered by %s, only the first entry will be used", id, previous)})
+				} else {
+					seenChannels[id] = entry
+				}
+			}
+		}
+
+		// Destination, whether it can be written to is left to checkConfigDestinations
+		item.entry = entry
+		if strings.TrimSpace(item.Destination) == "" {
+			issues = append(issues, configIssue{true, entry, "destination", "required but empty"})
+			valid = false
+		}
+		if item.LargeFileThreshold != nil {
+			if _, err := parseByteSize(*item.LargeFileThreshold); err != nil {
+				issues = append(issues, configIssue{false, entry, "largeFileThreshold", fmt.Sprintf("\"%s\" isn't a size, using %s", *item.LargeFileThreshold, ccdLargeFileThreshold)})
+				item.LargeFileThreshold = nil
+			}
+		}
+
+		// Storage Mode
+		if item.StorageMode != nil {
+			mode := strings.ToLower(*item.StorageMode)
+			if !stringInSlice(mode, storageModes) {
+				issues = append(issues, configIssue{false, entry, "storageMode", fmt.Sprintf("\"%s\" isn't plain or cas, using plain", *item.StorageMode)})
+				mode = storageModePlain
+			} else if mode == storageModeCAS && isRemoteDestination(item.Destination) {
+				issues = append(issues, configIssue{false, entry, "storageMode", "cas needs a local destination, using plain"})
+				mode = storageModePlain
+			}
+			item.StorageMode = &mode
+		}
+
+		// Limits
+		if item.MaxLinksPerMessage != nil && *item.MaxLinksPerMessage < 0 {
+			issues = append(issues, configIssue{false, entry, "maxLinksPerMessage", fmt.Sprintf("%d can't be negative, not limiting links", *item.MaxLinksPerMessage)})
+			item.MaxLinksPerMessage = &ccdMaxLinksPerMessage
+		}
+		if item.MaxFilesPerAlbum != nil && *item.MaxFilesPerAlbum < 0 {
+			issues = append(issues, configIssue{false, entry, "maxFilesPerAlbum", fmt.Sprintf("%d can't be negative, not limiting albums", *item.MaxFilesPerAlbum)})
+			item.MaxFilesPerAlbum = &ccdMaxFilesPerAlbum
+		}
+
+		// Requests
+		if item.DownloadTimeout != nil && *item.DownloadTimeout <= 0 {
+			issues = append(issues, configIssue{false, entry, "downloadTimeout", fmt.Sprintf("%d isn't a number of seconds, using the global downloadTimeout", *item.DownloadTimeout)})
+			item.DownloadTimeout = nil
+		}
+		if item.MaxRedirects != nil && *item.MaxRedirects < 0 {
+			issues = append(issues, configIssue{false, entry, "maxRedirects", fmt.Sprintf("%d can't be negative, using the global maxRedirects", *item.MaxRedirects)})
+			item.MaxRedirects = nil
+		}
+		if item.InsecureSkipVerifyDomains != nil {
+			domains := checkInsecureDomains(entry, "insecureSkipVerifyDomains", *item.InsecureSkipVerifyDomains)
+			item.InsecureSkipVerifyDomains = &domains
+		}
+
+		// GIFs
+		if item.GifFormat != nil {
+			format := strings.ToLower(*item.GifFormat)
+			if !stringInSlice(format, gifFormats) {
+				issues = append(issues, configIssue{false, entry, "gifFormat", fmt.Sprintf("\"%s\" isn't gif or mp4, using gif", *item.GifFormat)})
+				format = gifFormatGIF
+			}
+			item.GifFormat = &format
+		}
+
+		// Compression
+		if item.CompressMinSavings != nil && (*item.CompressMinSavings < 0 || *item.CompressMinSavings > 99) {
+			issues = append(issues, configIssue{false, entry, "compressMinSavings", fmt.Sprintf("%d isn't a percent from 0 to 99, using %d", *item.CompressMinSavings, ccdCompressMinSavings)})
+			item.CompressMinSavings = &ccdCompressMinSavings
+		}
+
+		// Setup
+		if item.AutoHistoryInterval != nil && *item.AutoHistoryInterval != "" {
+			if _, err := time.ParseDuration(*item.AutoHistoryInterval); err != nil {
+				issues = append(issues, configIssue{false, entry, "autoHistoryInterval", fmt.Sprintf("invalid duration \"%s\", scheduled history disabled", *item.AutoHistoryInterval)})
+				item.AutoHistoryInterval = nil
+			}
+		}
+
+		// Message Content
+		if item.SaveMessageContent != nil {
+			mode := strings.ToLower(*item.SaveMessageContent)
+			if !stringInSlice(mode, messageContentModes) {
+				issues = append(issues, configIssue{false, entry, "saveMessageContent", fmt.Sprintf("\"%s\" isn't per-message or channel-log, message content won't be saved", *item.SaveMessageContent)})
+				item.SaveMessageContent = nil
+			} else {
+				item.SaveMessageContent = &mode
+			}
+		}
+		if item.SaveMessageContentFormat != nil {
+			format := strings.ToLower(*item.SaveMessageContentFormat)
+			if !stringInSlice(format, messageContentFormats) {
+				issues = append(issues, configIssue{false, entry, "saveMessageContentFormat", fmt.Sprintf("\"%s\" isn't markdown, text or jsonl, using markdown", *item.SaveMessageContentFormat)})
+				format = "markdown"
+			}
+			item.SaveMessageContentFormat = &format
+		}
+
+		// Source Message
+		if item.DeleteAfterDownloadDelay != nil && *item.DeleteAfterDownloadDelay != "" {
+			if _, err := time.ParseDuration(*item.DeleteAfterDownloadDelay); err != nil {
+				issues = append(issues, configIssue{false, entry, "deleteAfterDownloadDelay", fmt.Sprintf("invalid duration \"%s\", deleting immediately", *item.DeleteAfterDownloadDelay)})
+				item.DeleteAfterDownloadDelay = nil
+			}
+		}
+
+		// Deleted Messages
+		if item.DeletedFilesAction != nil {
+			action := strings.ToLower(*item.DeletedFilesAction)
+			if action != "move" && action != "copy" {
+				issues = append(issues, configIssue{false, entry, "deletedFilesAction", fmt.Sprintf("\"%s\" isn't move or copy, files of deleted messages will stay where they are", *item.DeletedFilesAction)})
+				item.DeletedFilesAction = nil
+			} else {
+				item.DeletedFilesAction = &action
+			}
+		}
+
+		// Conversion
+		if item.ConvertAVIFToPNG != nil && *item.ConvertAVIFToPNG && c.FFmpegPath == "" {
+			issues = append(issues, configIssue{false, entry, "convertAVIFToPNG", "requires ffmpegPath, AVIF files will be saved as they are"})
+		}
+
+		// Duplicates
+		if item.DuplicateAction != nil {
+			action := strings.ToLower(*item.DuplicateAction)
+			if !stringInSlice(action, []string{"skip", "save", "hardlink", "symlink"}) {
+				issues = append(issues, configIssue{false, entry, "duplicateAction", fmt.Sprintf("\"%s\" isn't skip, save, hardlink or symlink, duplicates will be skipped", *item.DuplicateAction)})
+				action = "skip"
+			}
+			item.DuplicateAction = &action
+		}
+		if item.FilenameFormat != nil && *item.FilenameFormat != "" && !strings.Contains(*item.FilenameFormat, filenameTokenFilename) {
+			issues = append(issues, configIssue{false, entry, "filenameFormat", "doesn't contain {filename}, files would lose their extension, unused"})
+			item.FilenameFormat = nil
+		}
+		if item.CollisionStrategy != nil {
+			strategy := strings.ToLower(*item.CollisionStrategy)
+			if strategy != "id" && strategy != "counter" {
+				issues = append(issues, configIssue{false, entry, "collisionStrategy", fmt.Sprintf("\"%s\" isn't id or counter, using id", *item.CollisionStrategy)})
+				strategy = "id"
+			}
+			item.CollisionStrategy = &strategy
+		}
+		if item.SameNameAttachments != nil {
+			naming := strings.ToLower(*item.SameNameAttachments)
+			if naming != "index" && naming != "id" {
+				issues = append(issues, configIssue{false, entry, "sameNameAttachments", fmt.Sprintf("\"%s\" isn't index or id, using index", *item.SameNameAttachments)})
+				naming = "index"
+			}
+			item.SameNameAttachments = &naming
+		}
+
+		// Quotas
+		checkQuota := func(field string, size **string) {
+			if *size == nil {
+				return
+			}
+			if _, err := parseByteSize(**size); err != nil {
+				issues = append(issues, configIssue{false, entry, field, fmt.Sprintf("%s, quota disabled", err)})
+				*size = nil
+			}
+		}
+		checkQuota("maxBytesPerUser", &item.MaxBytesPerUser)
+		checkQuota("maxBytesPerChannel", &item.MaxBytesPerChannel)
+		if item.QuotaPeriod != nil {
+			period := strings.ToLower(*item.QuotaPeriod)
+			if !stringInSlice(period, quotaPeriods) {
+				issues = append(issues, configIssue{false, entry, "quotaPeriod", fmt.Sprintf("\"%s\" isn't day, week, month or total, using total", *item.QuotaPeriod)})
+				period = "total"
+			}
+			item.QuotaPeriod = &period
+		}
+		if item.QuotaTimezone != nil {
+			if _, err := time.LoadLocation(*item.QuotaTimezone); err != nil {
+				issues = append(issues, configIssue{false, entry, "quotaTimezone", fmt.Sprintf("%s, using local time", err)})
+				item.QuotaTimezone = nil
+			}
+		}
+
+		// Retention
+		if item.Retention != nil {
+			if _, err := parseRetention(*item.Retention); err != nil {
+				issues = append(issues, configIssue{false, entry, "retention", fmt.Sprintf("%s, files will be kept", err)})
+				item.Retention = nil
+			}
+		}
+
+		// Notifications
+		if item.Notifications != nil {
+			targets := checkNotifications(entry, *item.Notifications)
+			item.Notifications = &targets
+		}
+
+		// Digest
+		if item.Digest != nil && !checkDigest(entry, item.Digest) {
+			item.Digest = nil
+		}
+
+		// Filters
+		if item.Filters != nil {
+			fixExtensions := func(field string, extensions *[]string) {
+				if extensions == nil {
+					return
+				}
+				for i, extension := range *extensions {
+					if !strings.HasPrefix(extension, ".") {
+						issues = append(issues, configIssue{false, entry, "filters." + field, fmt.Sprintf("\"%s\" should start with \".\", using \".%s\"", extension, extension)})
+						(*extensions)[i] = "." + extension
+					}
+				}
+			}
+			fixExtensions("blockedExtensions", item.Filters.BlockedExtensions)
+			fixExtensions("allowedExtensions", item.Filters.AllowedExtensions)
+			checkSize := func(field string, size **string) {
+				if *size == nil {
+					return
+				}
+				if _, err := parseByteSize(**size); err != nil {
+					issues = append(issues, configIssue{false, entry, "filters." + field, fmt.Sprintf("%s, filter disabled", err)})
+					*size = nil
+				}
+			}
+			checkSize("minFileSize", &item.Filters.MinFileSize)
+			checkSize("maxFileSize", &item.Filters.MaxFileSize)
+			checkDimension := func(field string, dimension **int) {
+				if *dimension != nil && **dimension <= 0 {
+					issues = append(issues, configIssue{false, entry, "filters." + field, fmt.Sprintf("%d isn't a size in pixels, filter disabled", **dimension)})
+					*dimension = nil
+				}
+			}
+			checkDimension("minWidth", &item.Filters.MinWidth)
+			checkDimension("minHeight", &item.Filters.MinHeight)
+			if item.Filters.SkipTextHeavyImages != nil && *item.Filters.SkipTextHeavyImages && c.TesseractPath == "" {
+				issues = append(issues, configIssue{false, entry, "filters.skipTextHeavyImages", "requires tesseractPath, images won't be checked for text"})
+			}
+			if item.Filters.TextHeavyThreshold != nil && (*item.Filters.TextHeavyThreshold <= 0 || *item.Filters.TextHeavyThreshold >= 1) {
+				issues = append(issues, configIssue{false, entry, "filters.textHeavyThreshold", fmt.Sprintf("%g isn't between 0 and 1, using %g", *item.Filters.TextHeavyThreshold, ccfdTextHeavyThreshold)})
+				item.Filters.TextHeavyThreshold = nil
+			}
+			fixMIMETypes := func(field string, mimeTypes *[]string) {
+				if mimeTypes == nil {
+					return
+				}
+				var valid []string
+				for _, mimeType := range *mimeTypes {
+					mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+					if _, err := path.Match(mimeType, ""); err != nil || !strings.Contains(mimeType, "/") {
+						issues = append(issues, configIssue{false, entry, "filters." + field, fmt.Sprintf("\"%s\" isn't a MIME type like \"application/pdf\" or \"image/*\", skipped", mimeType)})
+						continue
+					}
+					valid = append(valid, mimeType)
+				}
+				*mimeTypes = valid
+			}
+			fixMIMETypes("blockedMIMETypes", item.Filters.BlockedMIMETypes)
+			fixMIMETypes("allowedMIMETypes", item.Filters.AllowedMIMETypes)
+			if item.Filters.MIMETypeSource != nil {
+				source := strings.ToLower(*item.Filters.MIMETypeSource)
+				if source != "detected" && source != "header" {
+					issues = append(issues, configIssue{false, entry, "filters.mimeTypeSource", fmt.Sprintf("\"%s\" isn't detected or header, using detected", *item.Filters.MIMETypeSource)})
+					source = "detected"
+				}
+				item.Filters.MIMETypeSource = &source
+			}
+			if item.Filters.BlockRedirectsToBlockedDomains != nil {
+				mode := strings.ToLower(*item.Filters.BlockRedirectsToBlockedDomains)
+				if mode != "original" && mode != "final" && mode != "both" {
+					issues = append(issues, configIssue{false, entry, "filters.blockRedirectsToBlockedDomains", fmt.Sprintf("\"%s\" isn't original, final or both, checking both", *item.Filters.BlockRedirectsToBlockedDomains)})
+					mode = "both"
+				}
+				item.Filters.BlockRedirectsToBlockedDomains = &mode
+			}
+			if item.Filters.BlockedUsers != nil {
+				checkIDs(entry, "filters.blockedUsers", *item.Filters.BlockedUsers)
+			}
+			if item.Filters.AllowedUsers != nil {
+				checkIDs(entry, "filters.allowedUsers", *item.Filters.AllowedUsers)
+			}
+		}
+		if item.AdminRoles != nil {
+			checkIDs(entry, "adminRoles", *item.AdminRoles)
+		}
+
+		return valid
+	}
+
+	var channels []configurationChannel
+	for i := range c.Channels {
+		if validateEntry(fmt.Sprintf("channels[%d]", i), &c.Channels[i], false) {
+			channels = append(channels, c.Channels[i])
+		}
+	}
+	var servers []configurationChannel
+	for i := range c.Servers {
+		if validateEntry(fmt.Sprintf("servers[%d]", i), &c.Servers[i], true) {
+			servers = append(servers, c.Servers[i])
+		}
+	}
+	if c.All != nil {
+		if strings.TrimSpace(c.All.Destination) == "" {
+			issues = append(issues, configIssue{true, "all", "destination", "required but empty, all mode disabled"})
+			c.All = nil
+		}
+	}
+
+	// Conversion
+	if c.FFmpegPath != "" {
+		if _, err := exec.LookPath(c.FFmpegPath); err != nil {
+			issues = append(issues, configIssue{false, "ffmpegPath", "", fmt.Sprintf("%s, conversions using ffmpeg will fail and save originals", err)})
+		}
+	} else if len(c.ConvertExtensions) > 0 {
+		issues = append(issues, configIssue{false, "convertExtensions", "", "requires ffmpegPath, files will be saved as they are"})
+	}
+	if c.TesseractPath != "" {
+		if _, err := exec.LookPath(c.TesseractPath); err != nil {
+			issues = append(issues, configIssue{false, "tesseractPath", "", fmt.Sprintf("%s, images won't be checked for text", err)})
+		}
+	}
+	checkIDs("ignoreAuthors", "", c.IgnoreAuthors)
+	// History Pacing
+	if delay, err := time.ParseDuration(c.HistoryRequestDelay); c.HistoryRequestDelay != "" && (err != nil || delay < 0) {
+		issues = append(issues, configIssue{false, "historyRequestDelay", "", fmt.Sprintf("invalid duration \"%s\", not waiting between requests", c.HistoryRequestDelay)})
+		c.HistoryRequestDelay = "0s"
+	}
+	if c.HistoryBatchSize < 0 || c.HistoryBatchSize > historyBatchSizeMax {
+		issues = append(issues, configIssue{false, "historyBatchSize", "", fmt.Sprintf("%d isn't from 1 to %d, using %d", c.HistoryBatchSize, historyBatchSizeMax, historyBatchSizeMax)})
+		c.HistoryBatchSize = historyBatchSizeMax
+	}
+	if c.APIBudget < 0 {
+		issues = append(issues, configIssue{false, "apiBudget", "", fmt.Sprintf("%d can't be negative, unlimited", c.APIBudget)})
+		c.APIBudget = 0
+	}
+	// Requests
+	for domain, seconds := range c.DownloadTimeoutDomains {
+		if seconds <= 0 {
+			issues = append(issues, configIssue{false, "downloadTimeoutDomains", domain, fmt.Sprintf("%d isn't a number of seconds, unused", seconds)})
+			delete(c.DownloadTimeoutDomains, domain)
+		}
+	}
+	if c.MaxRedirects < 0 {
+		issues = append(issues, configIssue{false, "maxRedirects", "", fmt.Sprintf("%d can't be negative, using %d", c.MaxRedirects, downloadMaxRedirectsDefault)})
+		c.MaxRedirects = downloadMaxRedirectsDefault
+	}
+	for domain, redirects := range c.MaxRedirectsDomains {
+		if redirects < 0 {
+			issues = append(issues, configIssue{false, "maxRedirectsDomains", domain, fmt.Sprintf("%d can't be negative, unused", redirects)})
+			delete(c.MaxRedirectsDomains, domain)
+		}
+	}
+	c.InsecureSkipVerifyDomains = checkInsecureDomains("insecureSkipVerifyDomains", "", c.InsecureSkipVerifyDomains)
+	// Notifications
+	c.Notifications = checkNotifications("", c.Notifications)
+
+	// Mirrors, unusable ones are dropped
+	var mirrorsKept []configurationMirror
+	for i, target := range c.Mirrors {
+		entry := fmt.Sprintf("mirrors[%d]", i)
+		if target.ChannelID == "" && target.ChannelIDs == nil {
+			issues = append(issues, configIssue{false, entry, "channel", "no channel to mirror, mirror disabled"})
+			continue
+		}
+		if target.ChannelID != "" && !checkIDs(entry, "channel", []string{target.ChannelID}) ||
+			target.ChannelIDs != nil && !checkIDs(entry, "channels", *target.ChannelIDs) {
+			continue
+		}
+		if target.BotToken == "" || target.ChatID == "" {
+			issues = append(issues, configIssue{false, entry, "", "botToken and chatID are required, mirror disabled"})
+			continue
+		}
+		if target.Caption == "" {
+			target.Caption = mirrorCaptionDefault
+		}
+		if target.MaxSize == "" {
+			target.MaxSize = mirrorMaxSizeDefault
+		}
+		if size, err := parseByteSize(target.MaxSize); err != nil || size <= 0 {
+			issues = append(issues, configIssue{false, entry, "maxSize", fmt.Sprintf("invalid size \"%s\", using %s", target.MaxSize, mirrorMaxSizeDefault)})
+			target.MaxSize = mirrorMaxSizeDefault
+		}
+		if target.ContentTypes != nil {
+			for _, kind := range *target.ContentTypes {
+				if !stringInSlice(kind, fileTypes) {
+					issues = append(issues, configIssue{false, entry, "contentTypes", fmt.Sprintf("unknown type \"%s\", expected one of %s", kind, strings.Join(fileTypes, ", "))})
+				}
+			}
+		}
+		mirrorsKept = append(mirrorsKept, target)
+	}
+	c.Mirrors = mirrorsKept
+
+	// Database
+	if c.Database != nil {
+		c.Database.Type = strings.ToLower(c.Database.Type)
+		switch c.Database.Type {
+		case "", databaseTypeTiedot:
+			c.Database.Type = databaseTypeTiedot
+		case databaseTypeSQLite:
+			if c.Database.Path == "" {
+				c.Database.Path = databasePath + string(os.PathSeparator) + databasePathDefault
+			}
+		case databaseTypePostgres:
+			if c.Database.DSN == "" {
+				issues = append(issues, configIssue{true, "database", "dsn", "required for postgres"})
+			}
+		default:
+			issues = append(issues, configIssue{true, "database", "type", fmt.Sprintf("unknown type \"%s\", expected \"tiedot\", \"sqlite\" or \"postgres\"", c.Database.Type)})
+		}
+	}
+
+	// Feeds
+	if c.Feeds != nil {
+		if c.Feeds.Folder == "" {
+			c.Feeds.Folder = feedsFolderDefault
+		} else if isRemoteDestination(c.Feeds.Folder) {
+			issues = append(issues, configIssue{false, "feeds", "folder", fmt.Sprintf("\"%s\" is remote, feeds can only be written locally, using \"%s\"", c.Feeds.Folder, feedsFolderDefault)})
+			c.Feeds.Folder = feedsFolderDefault
+		}
+		if c.Feeds.Items <= 0 {
+			c.Feeds.Items = feedItemsDefault
+		}
+		if c.Feeds.BaseURL != "" {
+			if u, err := url.Parse(c.Feeds.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				issues = append(issues, configIssue{false, "feeds", "baseURL", fmt.Sprintf("\"%s\" is not an http(s) URL, linking saved files by path instead", c.Feeds.BaseURL)})
+				c.Feeds.BaseURL = ""
+			}
+		}
+	}
+
+	// Schedule
+	if c.Schedule != nil {
+		var windows []configurationScheduleWindow
+		for i, window := range c.Schedule.Windows {
+			entry := fmt.Sprintf("schedule.windows[%d]", i)
+			if _, err := time.Parse(scheduleTimeLayout, window.Start); err != nil {
+				issues = append(issues, configIssue{false, entry, "start", fmt.Sprintf("\"%s\" isn't a time like \"01:00\", window ignored", window.Start)})
+				continue
+			}
+			if _, err := time.Parse(scheduleTimeLayout, window.End); err != nil {
+				issues = append(issues, configIssue{false, entry, "end", fmt.Sprintf("\"%s\" isn't a time like \"07:00\", window ignored", window.End)})
+				continue
+			}
+			valid := true
+			for _, day := range window.Days {
+				if _, ok := getScheduleWeekday(day); !ok {
+					issues = append(issues, configIssue{false, entry, "days", fmt.Sprintf("\"%s\" isn't a day of the week, window ignored", day)})
+					valid = false
+				}
+			}
+			if valid {
+				windows = append(windows, window)
+			}
+		}
+		c.Schedule.Windows = windows
+		if c.Schedule.DeferAbove == "" {
+			c.Schedule.DeferAbove = scheduleDeferAboveDefault
+		} else if _, err := parseByteSize(c.Schedule.DeferAbove); err != nil {
+			issues = append(issues, configIssue{false, "schedule", "deferAbove", fmt.Sprintf("invalid size \"%s\", using %s", c.Schedule.DeferAbove, scheduleDeferAboveDefault)})
+			c.Schedule.DeferAbove = scheduleDeferAboveDefault
+		}
+		if len(windows) == 0 {
+			issues = append(issues, configIssue{false, "schedule", "windows", "no valid windows, downloading whenever"})
+			c.Schedule = nil
+		}
+	}
+
+	// Digest
+	if c.Digest != nil && !checkDigest("", c.Digest) {
+		c.Digest = nil
+	}
+
+	// Presence, anything invalid falls back to the defaults
+	if c.Presence != nil {
+		if interval, err := time.ParseDuration(c.Presence.Interval); err != nil || interval < presenceMinInterval {
+			issues = append(issues, configIssue{false, "presence", "interval", fmt.Sprintf("invalid interval \"%s\", must be at least %s, using %s", c.Presence.Interval, presenceMinInterval, pcdInterval)})
+			c.Presence.Interval = pcdInterval
+		}
+		c.Presence.Type = strings.ToLower(c.Presence.Type)
+		if _, ok := presenceActivityTypes[c.Presence.Type]; !ok && c.Presence.Type != "" {
+			issues = append(issues, configIssue{false, "presence", "type", fmt.Sprintf("unknown type \"%s\", expected playing, listening or watching", c.Presence.Type)})
+			c.Presence.Type = ""
+		}
+		c.Presence.Status = strings.ToLower(c.Presence.Status)
+		if !stringInSlice(c.Presence.Status, presenceStatuses) && c.Presence.Status != "" {
+			issues = append(issues, configIssue{false, "presence", "status", fmt.Sprintf("unknown status \"%s\", expected one of %s", c.Presence.Status, strings.Join(presenceStatuses, ", "))})
+			c.Presence.Status = ""
+		}
+	}
+
+	// Slash Commands
+	if c.SlashCommands != nil {
+		c.SlashCommands.Scope = strings.ToLower(c.SlashCommands.Scope)
+		if c.SlashCommands.Scope != "guild" && c.SlashCommands.Scope != "global" {
+			issues = append(issues, configIssue{false, "slashCommands", "scope", fmt.Sprintf("unknown scope \"%s\", expected guild or global, using %s", c.SlashCommands.Scope, scdScope)})
+			c.SlashCommands.Scope = scdScope
+		}
+		if _, ok := slashCommandPermissions[c.SlashCommands.Permissions]; !ok && !isNumeric(c.SlashCommands.Permissions) {
+			issues = append(issues, configIssue{false, "slashCommands", "permissions", fmt.Sprintf("unknown permissions \"%s\", expected manageServer, administrator, everyone or a permission bitfield, using %s", c.SlashCommands.Permissions, scdPermissions)})
+			c.SlashCommands.Permissions = scdPermissions
+		}
+		var guilds []string
+		for _, guild := range c.SlashCommands.Guilds {
+			if isNumeric(guild) {
+				guilds = append(guilds, guild)
+			} else {
+				issues = append(issues, configIssue{false, "slashCommands", "guilds", fmt.Sprintf("\"%s\" is not a numeric Discord ID, skipped", guild)})
+			}
+		}
+		c.SlashCommands.Guilds = guilds
+	}
+
+	// Avatar Tracking
+	if c.Snapshots != nil {
+		if interval, err := time.ParseDuration(c.Snapshots.Interval); err != nil || interval < time.Hour {
+			issues = append(issues, configIssue{false, "snapshots", "interval", fmt.Sprintf("invalid interval \"%s\", must be at least 1h, using %s", c.Snapshots.Interval, sncdInterval)})
+			c.Snapshots.Interval = sncdInterval
+		}
+		if c.Snapshots.Keep < 1 {
+			issues = append(issues, configIssue{false, "snapshots", "keep", fmt.Sprintf("%d isn't at least 1, keeping %d", c.Snapshots.Keep, sncdKeep)})
+			c.Snapshots.Keep = sncdKeep
+		}
+	}
+	if c.AvatarTracking != nil {
+		if strings.TrimSpace(c.AvatarTracking.Destination) == "" {
+			issues = append(issues, configIssue{true, "avatarTracking", "destination", "required but empty, avatars won't be saved"})
+			c.AvatarTracking = nil
+		} else {
+			var guilds []string
+			for _, guild := range c.AvatarTracking.Guilds {
+				if isNumeric(guild) {
+					guilds = append(guilds, guild)
+				} else {
+					issues = append(issues, configIssue{false, "avatarTracking", "guilds", fmt.Sprintf("\"%s\" is not a numeric Discord ID, skipped", guild)})
+				}
+			}
+			c.AvatarTracking.Guilds = guilds
+		}
+	}
+
+	// URL Unwrapping, domains are matched case-insensitively
+	if c.URLRewrites != nil {
+		rewrites := make(map[string]string)
+		for domain, replacement := range c.URLRewrites {
+			replacement = strings.ToLower(strings.TrimSpace(replacement))
+			if strings.Contains(replacement, "/") {
+				issues = append(issues, configIssue{false, "urlRewrites", domain, fmt.Sprintf("\"%s\" should be a domain without a scheme or path, skipped", replacement)})
+				continue
+			}
+			rewrites[strings.ToLower(strings.TrimSpace(domain))] = replacement
+		}
+		c.URLRewrites = rewrites
+	}
+	for i, domain := range c.URLShorteners {
+		c.URLShorteners[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+	for i, domain := range c.URLShortenersIgnored {
+		c.URLShortenersIgnored[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+
+	// Placeholders
+	for _, entry := range c.PlaceholderHashes {
+		if _, err := parsePlaceholder(entry); err != nil {
+			issues = append(issues, configIssue{false, "placeholderHashes", entry, fmt.Sprintf("%s, skipped", err)})
+		}
+	}
+
+	// Manual Downloads, aliases are matched case-insensitively
+	if c.ManualDestinations != nil {
+		destinations := make(map[string]string)
+		for alias, path := range c.ManualDestinations {
+			if path == "" {
+				issues = append(issues, configIssue{false, "manualDestinations", alias, "empty path, alias skipped"})
+				continue
+			}
+			destinations[strings.ToLower(alias)] = path
+		}
+		c.ManualDestinations = destinations
+	}
+
+	// Disk Space
+	if c.MinimumFreeSpace != "" {
+		if _, err := parseByteSize(c.MinimumFreeSpace); err != nil {
+			issues = append(issues, configIssue{false, "settings", "minimumFreeSpace", fmt.Sprintf("%s, free space won't be checked", err)})
+			c.MinimumFreeSpace = ""
+		}
+	}
+
+	// Failed URLs
+	if c.SkipFailedURLsAfter < 0 {
+		issues = append(issues, configIssue{false, "settings", "skipFailedURLsAfter", "can't be negative, failed URLs will always be retried"})
+		c.SkipFailedURLsAfter = 0
+	}
+	if c.FailedURLCooldown != "" {
+		if _, err := time.ParseDuration(c.FailedURLCooldown); err != nil {
+			issues = append(issues, configIssue{false, "settings", "failedURLCooldown", fmt.Sprintf("invalid duration \"%s\", failed URLs won't wait to be retried", c.FailedURLCooldown)})
+			c.FailedURLCooldown = ""
+		}
+	}
+
+	// Reactions
+	if c.ReactionsPerMinute < 1 {
+		issues = append(issues, configIssue{false, "settings", "reactionsPerMinute", fmt.Sprintf("%d isn't at least 1, using %d", c.ReactionsPerMinute, cdReactionsPerMinute)})
+		c.ReactionsPerMinute = cdReactionsPerMinute
+	}
+
+	// Bot Challenges
+	if c.FlareSolverrURL != "" {
+		if parsed, err := url.Parse(c.FlareSolverrURL); err != nil || parsed.Host == "" {
+			issues = append(issues, configIssue{false, "settings", "flaresolverrURL", fmt.Sprintf("\"%s\" isn't a URL, challenges won't be solved", c.FlareSolverrURL)})
+			c.FlareSolverrURL = ""
+		}
+	}
+
+	// Network, an address that can't be bound stops the bot at launch
+	if c.BindAddress != "" {
+		if _, err := getBindIP(c.BindAddress); err != nil {
+			issues = append(issues, configIssue{false, "settings", "bindAddress", err.Error()})
+		}
+	}
+	var dnsServers []string
+	for _, server := range c.DNSServers {
+		if strings.HasPrefix(server, "https://") {
+			if _, err := url.Parse(server); err != nil {
+				issues = append(issues, configIssue{false, "settings", "dnsServers", fmt.Sprintf("\"%s\" isn't a valid URL, skipped", server)})
+				continue
+			}
+		} else if host, _, err := net.SplitHostPort(server); (err == nil && net.ParseIP(host) == nil) || (err != nil && net.ParseIP(server) == nil) {
+			issues = append(issues, configIssue{false, "settings", "dnsServers", fmt.Sprintf("\"%s\" isn't an IP address or DNS over HTTPS URL, skipped", server)})
+			continue
+		}
+		dnsServers = append(dnsServers, server)
+	}
+	c.DNSServers = dnsServers
+	for hostname, ip := range c.HostsOverrides {
+		if net.ParseIP(ip) == nil {
+			issues = append(issues, configIssue{false, "settings", "hostsOverrides", fmt.Sprintf("\"%s\" for %s isn't an IP address, skipped", ip, hostname)})
+			delete(c.HostsOverrides, hostname)
+		}
+	}
+
+	// Storage Mode
+	if c.StorageMode != "" {
+		mode := strings.ToLower(c.StorageMode)
+		if !stringInSlice(mode, storageModes) {
+			issues = append(issues, configIssue{false, "settings", "storageMode", fmt.Sprintf("\"%s\" isn't plain or cas, using plain", c.StorageMode)})
+			mode = storageModePlain
+		}
+		c.StorageMode = mode
+	}
+	if isRemoteDestination(c.CASPath) {
+		issues = append(issues, configIssue{false, "settings", "casPath", fmt.Sprintf("blobs have to be stored locally, using \"%s\"", casPathDefault)})
+		c.CASPath = ""
+	}
+
+	// Destinations
+	if c.FolderNameEmoji != "" && !stringInSlice(strings.ToLower(c.FolderNameEmoji), folderNameEmojiModes) {
+		issues = append(issues, configIssue{false, "settings", "folderNameEmoji", fmt.Sprintf("\"%s\" isn't %s, emoji will be kept", c.FolderNameEmoji, strings.Join(folderNameEmojiModes, ", "))})
+		c.FolderNameEmoji = ""
+	}
+
+	// Integrity Check
+	var repairs []string
+	for _, repair := range c.IntegrityRepairs {
+		if !stringInSlice(strings.ToLower(repair), integrityRepairs) {
+			issues = append(issues, configIssue{false, "settings", "integrityRepairs", fmt.Sprintf("\"%s\" isn't %s, it'll be ignored", repair, strings.Join(integrityRepairs, ", "))})
+		} else if !stringInSlice(strings.ToLower(repair), repairs) {
+			repairs = append(repairs, strings.ToLower(repair))
+		}
+	}
+	c.IntegrityRepairs = repairs
+
+	// Site Handlers
+	if _, isHeight := parseTwitterVideoMaxHeight(c.TwitterVideoQuality); c.TwitterVideoQuality != "" && !isHeight &&
+		!strings.EqualFold(c.TwitterVideoQuality, twitterVideoQualityHighest) && !strings.EqualFold(c.TwitterVideoQuality, twitterVideoQualityLowest) {
+		issues = append(issues, configIssue{false, "settings", "twitterVideoQuality", fmt.Sprintf("\"%s\" isn't highest, lowest or a height like 720, using highest", c.TwitterVideoQuality)})
+		c.TwitterVideoQuality = ""
+	}
+	for name, handler := range c.Handlers {
+		if !stringInSlice(name, getSiteHandlerNames()) {
+			issues = append(issues, configIssue{false, "settings", "handlers", fmt.Sprintf("\"%s\" isn't a handler, it'll be ignored. Handlers are %s", name, strings.Join(getSiteHandlerNames(), ", "))})
+			continue
+		}
+		if handler.Timeout != "" {
+			if timeout, err := time.ParseDuration(handler.Timeout); err != nil || timeout <= 0 {
+				issues = append(issues, configIssue{false, "settings", "handlers." + name + ".timeout", fmt.Sprintf("invalid duration \"%s\", using %s", handler.Timeout, siteHandlerTimeoutDefault)})
+				handler.Timeout = ""
+				c.Handlers[name] = handler
+			}
+		}
+		if handler.CheckURL != "" {
+			for _, site := range siteHandlers {
+				if site.name == name && !site.matches(handler.CheckURL) {
+					issues = append(issues, configIssue{false, "settings", "handlers." + name + ".checkURL", fmt.Sprintf("\"%s\" isn't a link %s handles, the self-check will fail", handler.CheckURL, name)})
+				}
+			}
+		}
+	}
+
+	if c.HandlerCacheDuration != "" {
+		if _, err := time.ParseDuration(c.HandlerCacheDuration); err != nil {
+			issues = append(issues, configIssue{false, "settings", "handlerCacheDuration", fmt.Sprintf("invalid duration \"%s\", defaulting to %s", c.HandlerCacheDuration, siteCacheDurationDefault)})
+			c.HandlerCacheDuration = ""
+		}
+	}
+
+	// API
+	if c.APIAddress != "" && c.Credentials.APIToken == "" {
+		issues = append(issues, configIssue{true, "settings", "apiAddress", "requires credentials.apiToken, the API is disabled"})
+		c.APIAddress = ""
+	}
+
+	// Web Gallery
+	if c.WebAddress != "" && (c.Credentials.WebUsername == "" || c.Credentials.WebPassword == "") {
+		issues = append(issues, configIssue{true, "settings", "webAddress", "requires credentials.webUsername and credentials.webPassword, the gallery is disabled"})
+		c.WebAddress = ""
+	}
+
+	// User Sessions
+	if _, err := time.ParseDuration(c.UserSession.HistoryDelay); err != nil {
+		issues = append(issues, configIssue{false, "settings", "userSession.historyDelay", fmt.Sprintf("invalid duration \"%s\", defaulting to %s", c.UserSession.HistoryDelay, usdHistoryDelay)})
+		c.UserSession.HistoryDelay = usdHistoryDelay
+	}
+
+	// Pending Downloads
+	if c.PendingDownloadMaxAge != "" {
+		if _, err := time.ParseDuration(c.PendingDownloadMaxAge); err != nil {
+			issues = append(issues, configIssue{false, "settings", "pendingDownloadMaxAge", fmt.Sprintf("invalid duration \"%s\", defaulting to %s", c.PendingDownloadMaxAge, pendingDownloadMaxAgeDefault)})
+			c.PendingDownloadMaxAge = ""
+		}
+	}
+
+	// Logging, anything invalid falls back to the defaults
+	if c.Logging != nil {
+		c.Logging.Format = strings.ToLower(c.Logging.Format)
+		if c.Logging.Format != "console" && c.Logging.Format != "json" {
+			issues = append(issues, configIssue{false, "logging", "format", fmt.Sprintf("unknown format \"%s\", expected console or json", c.Logging.Format)})
+			c.Logging.Format = "console"
+		}
+		c.Logging.Level = strings.ToLower(c.Logging.Level)
+		if _, ok := logLevels[c.Logging.Level]; !ok && c.Logging.Level != "" {
+			issues = append(issues, configIssue{false, "logging", "level", fmt.Sprintf("unknown level \"%s\", expected one of %s", c.Logging.Level, strings.Join(logLevelNames, ", "))})
+			c.Logging.Level = ""
+		}
+		for module, level := range c.Logging.Modules {
+			if !stringInSlice(module, logModules) {
+				issues = append(issues, configIssue{false, "logging", "modules", fmt.Sprintf("unknown module \"%s\", expected one of %s", module, strings.Join(logModules, ", "))})
+				delete(c.Logging.Modules, module)
+			} else if _, ok := logLevels[strings.ToLower(level)]; !ok {
+				issues = append(issues, configIssue{false, "logging", "modules", fmt.Sprintf("unknown level \"%s\" for %s, expected one of %s", level, module, strings.Join(logLevelNames, ", "))})
+				delete(c.Logging.Modules, module)
+			} else {
+				c.Logging.Modules[module] = strings.ToLower(level)
+			}
+		}
+		if c.Logging.File != nil {
+			if c.Logging.File.Path == "" {
+				issues = append(issues, configIssue{false, "logging", "file", "path is required, logging to file disabled"})
+				c.Logging.File = nil
+			} else if c.Logging.File.Rotate = strings.ToLower(c.Logging.File.Rotate); c.Logging.File.Rotate != "size" && c.Logging.File.Rotate != "daily" {
+				issues = append(issues, configIssue{false, "logging", "file", fmt.Sprintf("unknown rotation \"%s\", expected size or daily", c.Logging.File.Rotate)})
+				c.Logging.File.Rotate = "size"
+			}
+		}
+	}
+
+	// Duplicates
+	if c.FilterDuplicateVideos && c.FFmpegPath == "" {
+		issues = append(issues, configIssue{false, "filterDuplicateVideos", "", "without ffmpegPath only exact copies are detected"})
+	}
+	for from, to := range c.ConvertExtensions {
+		fixed := "." + strings.TrimPrefix(strings.ToLower(from), ".")
+		if fixed != from {
+			delete(c.ConvertExtensions, from)
+		}
+		c.ConvertExtensions[fixed] = "." + strings.TrimPrefix(strings.ToLower(to), ".")
+	}
+
+	// Error Log
+	for _, name := range c.ErrorLogSuppress {
+		if _, ok := downloadFailureNames[name]; !ok {
+			issues = append(issues, configIssue{false, "errorLogSuppress", "", fmt.Sprintf("unknown status \"%s\"", name)})
+		}
+	}
+	if c.ErrorLogInterval < 1 {
+		c.ErrorLogInterval = 1
+	}
+
+	// Admin channels are only warned about, dropping them would lock admins out of commands
+	for i, adminChannel := range c.AdminChannels {
+		entry := fmt.Sprintf("adminChannels[%d]", i)
+		if adminChannel.ChannelIDs != nil {
+			for _, id := range *adminChannel.ChannelIDs {
+				if !isNumeric(id) {
+					issues = append(issues, configIssue{false, entry, "channels", fmt.Sprintf("\"%s\" is not a numeric Discord ID", id)})
+				}
+			}
+		} else if !isNumeric(adminChannel.ChannelID) {
+			issues = append(issues, configIssue{false, entry, "channel", fmt.Sprintf("\"%s\" is not a numeric Discord ID", adminChannel.ChannelID)})
+		}
+		if adminChannel.LogSeverities != nil {
+			for _, severity := range *adminChannel.LogSeverities {
+				if !stringInSlice(severity, errorLogSeverities) {
+					issues = append(issues, configIssue{false, entry, "logSeverities", fmt.Sprintf("unknown severity \"%s\", expected one of %s", severity, strings.Join(errorLogSeverities, ", "))})
+				}
+			}
+		}
+	}
+	for _, admin := range c.Admins {
+		if !isNumeric(admin) {
+			issues = append(issues, configIssue{false, "admins", "", fmt.Sprintf("\"%s\" is not a numeric Discord ID", admin)})
+		}
+	}
+
+	c.Channels = channels
+	c.Servers = servers
+	return issues
+}
+
+// Checks each source's destinations can be written to, connecting to remote ones and creating local ones with
+// createDestinations. Kept out of parseConfig since it touches the disk and the network, it's run at launch and by
+// the reload command. Entries whose destination can't be used are removed, like validateConfig's fatal issues.
+func checkConfigDestinations(c *configuration) []configIssue {
+	var issues []configIssue
+	checkEntry := func(item *configurationChannel) bool {
+		valid := true
+		report := func(fatal bool, field string, message string) {
+			entry := item.entry
+			if source := item.inheritedFrom(field); source != "" {
+				entry = fmt.Sprintf("%s (from %s)", entry, source)
+			}
+			issues = append(issues, configIssue{fatal, entry, field, message})
+		}
+		check := func(destination string) error {
+			if isRemoteDestination(destination) {
+				return checkRemoteDestination(getDestinationRoot(c.BasePath, destination), c.Credentials)
+			}
+			return checkDestinationWritable(getDestinationRoot(c.BasePath, destination), c.CreateDestinations)
+		}
+
+		// Missing local folders are only reported, downloads create them anyway
+		if err := check(item.Destination); err != nil {
+			fatal := isRemoteDestination(item.Destination) || !os.IsNotExist(err)
+			report(fatal, "destination", err.Error())
+			valid = !fatal
+		}
+		if item.NSFWDestinationOverride != nil && strings.TrimSpace(*item.NSFWDestinationOverride) != "" {
+			if err := check(*item.NSFWDestinationOverride); err != nil && !os.IsNotExist(err) {
+				report(false, "nsfwDestinationOverride", err.Error()+", NSFW files will go to the usual destination")
+				item.NSFWDestinationOverride = nil
+			}
+		}
+		if item.LargeFileDestination != nil && strings.TrimSpace(*item.LargeFileDestination) != "" {
+			if err := check(*item.LargeFileDestination); err != nil && !os.IsNotExist(err) {
+				report(false, "largeFileDestination", err.Error()+", large files will go to the usual destination")
+				item.LargeFileDestination = nil
+			}
+		}
+		return valid
+	}
+
+	var channels []configurationChannel
+	for i := range c.Channels {
+		if checkEntry(&c.Channels[i]) {
+			channels = append(channels, c.Channels[i])
+		}
+	}
+	var servers []configurationChannel
+	for i := range c.Servers {
+		if checkEntry(&c.Servers[i]) {
+			servers = append(servers, c.Servers[i])
+		}
+	}
+	c.Channels, c.Servers = channels, servers
+	return issues
+}
+
+// Returns nil if files can be written to the destination.
+// Missing destinations are created if allowed, otherwise the os.IsNotExist error is returned since downloads create them anyways.
+func checkDestinationWritable(destination string, create bool) error {
+	if _, err := os.Stat(destination); os.IsNotExist(err) {
+		if !create {
+			return err
+		}
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			return fmt.Errorf("failed to create folder: %s", err)
+		}
+	}
+	file, err := ioutil.TempFile(destination, ".ddg-write-check-*")
+	if err != nil {
+		return fmt.Errorf("folder is not writable: %s", err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return nil
+}
+
+// Prints issues grouped by config entry.
+func logConfigIssues(issues []configIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	fatal := 0
+	var entries []string
+	grouped := make(map[string][]configIssue)
+	for _, issue := range issues {
+		if _, exists := grouped[issue.Entry]; !exists {
+			entries = append(entries, issue.Entry)
+		}
+		grouped[issue.Entry] = append(grouped[issue.Entry], issue)
+		if issue.Fatal {
+			fatal++
+		}
+	}
+	log.Println(logPrefixSettings, color.HiRedString("Found %d problem%s in settings (%d error%s, %d warning%s):",
+		len(issues), pluralS(len(issues)), fatal, pluralS(fatal), len(issues)-fatal, pluralS(len(issues)-fatal)))
+	for _, entry := range entries {
+		log.Println(logPrefixSettings, color.HiYellowString("  %s", entry))
+		for _, issue := range grouped[entry] {
+			field := issue.Field
+			if field != "" {
+				field += ": "
+			}
+			if issue.Fatal {
+				log.Println(logPrefixSettings, color.HiRedString("    ERROR\t%s%s", field, issue.Message))
+			} else {
+				log.Println(logPrefixSettings, color.YellowString("    WARNING\t%s%s", field, issue.Message))
+			}
+		}
+	}
+	if fatal > 0 {
+		log.Println(logPrefixSettings, color.HiRedString("Entries with errors have been skipped, everything else will still be used."))
+	}
+}
+
+//#endregion
+
+//#region Channel Checks/Returns
+
+func isChannelRegistered(ChannelID string) bool {
+	return getConfig().isChannelRegistered(ChannelID)
+}
+
+func getChannelConfig(ChannelID string) configurationChannel {
+	return getConfig().getChannelConfig(ChannelID)
+}
+
+// Checks against this snapshot of the config, for callers that have to see the same one throughout.
+func (settings *configuration) isChannelRegistered(ChannelID string) bool {
+	for _, item := range settings.Channels {
+		// Single Channel Config
+		if ChannelID == item.ChannelID {
+			return true
+		}
+		// Multi-Channel Config
 		if item.ChannelIDs != nil {
 			if stringInSlice(ChannelID, *item.ChannelIDs) {
 				return true
@@ -817,7 +2976,7 @@ func isChannelRegistered(ChannelID string) bool {
 		}
 	}
 	// Server Config
-	for _, item := range config.Servers {
+	for _, item := range settings.Servers {
 		if item.ServerID != "" {
 			guild, err := bot.State.Guild(item.ServerID)
 			if err == nil {
@@ -855,16 +3014,16 @@ func isChannelRegistered(ChannelID string) bool {
 		}
 	}
 	// All
-	if config.All != nil {
-		if config.AllBlacklistChannels != nil {
-			if stringInSlice(ChannelID, *config.AllBlacklistChannels) {
+	if settings.All != nil {
+		if settings.AllBlacklistChannels != nil {
+			if stringInSlice(ChannelID, *settings.AllBlacklistChannels) {
 				return false
 			}
 		}
-		if config.AllBlacklistServers != nil {
+		if settings.AllBlacklistServers != nil {
 			guild, err := bot.State.Guild(ChannelID)
 			if err == nil {
-				if stringInSlice(guild.ID, *config.AllBlacklistServers) {
+				if stringInSlice(guild.ID, *settings.AllBlacklistServers) {
 					return false
 				}
 			} else {
@@ -876,8 +3035,8 @@ func isChannelRegistered(ChannelID string) bool {
 	return false
 }
 
-func getChannelConfig(ChannelID string) configurationChannel {
-	for _, item := range config.Channels {
+func (settings *configuration) getChannelConfig(ChannelID string) configurationChannel {
+	for _, item := range settings.Channels {
 		// Single Channel Config
 		if ChannelID == item.ChannelID {
 			return item
@@ -892,7 +3051,7 @@ func getChannelConfig(ChannelID string) configurationChannel {
 		}
 	}
 	// Server Config
-	for _, item := range config.Servers {
+	for _, item := range settings.Servers {
 		if item.ServerID != "" {
 			guild, err := bot.State.Guild(item.ServerID)
 			if err == nil {
@@ -917,15 +3076,16 @@ func getChannelConfig(ChannelID string) configurationChannel {
 			}
 		}
 	}
-	if config.All != nil {
-		return *config.All
+	if settings.All != nil {
+		return *settings.All
 	}
 	return configurationChannel{}
 }
 
 func isAdminChannelRegistered(ChannelID string) bool {
-	if config.AdminChannels != nil {
-		for _, item := range config.AdminChannels {
+	settings := getConfig()
+	if settings.AdminChannels != nil {
+		for _, item := range settings.AdminChannels {
 			// Single Channel Config
 			if ChannelID == item.ChannelID {
 				return true
@@ -942,8 +3102,9 @@ func isAdminChannelRegistered(ChannelID string) bool {
 }
 
 func getAdminChannelConfig(ChannelID string) configurationAdminChannel {
-	if config.AdminChannels != nil {
-		for _, item := range config.AdminChannels {
+	settings := getConfig()
+	if settings.AdminChannels != nil {
+		for _, item := range settings.AdminChannels {
 			// Single Channel Config
 			if ChannelID == item.ChannelID {
 				return item
@@ -966,15 +3127,16 @@ func isCommandableChannel(m *discordgo.Message) bool {
 		return true
 	} else if isChannelRegistered(m.ChannelID) {
 		channelConfig := getChannelConfig(m.ChannelID)
-		if *channelConfig.AllowCommands || isBotAdmin(m) {
+		if *channelConfig.AllowCommands || isAdmin(m) {
 			return true
 		}
 	}
+	rejectCommandInteraction(m, cmderrCommandsNotAllowed)
 	return false
 }
 
 func isGlobalCommandAllowed(m *discordgo.Message) bool {
-	if config.AllowGlobalCommands || isCommandableChannel(m) {
+	if getConfig().AllowGlobalCommands || isCommandableChannel(m) {
 		return true
 	}
 	return false
@@ -982,7 +3144,7 @@ func isGlobalCommandAllowed(m *discordgo.Message) bool {
 
 func getBoundServers() []string {
 	var servers []string
-	for _, item := range config.Servers {
+	for _, item := range getConfig().Servers {
 		if item.ServerID != "" {
 			if !stringInSlice(item.ServerID, servers) {
 				servers = append(servers, item.ServerID)
@@ -1006,7 +3168,7 @@ func getBoundServersCount() int {
 
 func getBoundChannels() []string {
 	var channels []string
-	for _, item := range config.Channels {
+	for _, item := range getConfig().Channels {
 		if item.ChannelID != "" {
 			if !stringInSlice(item.ChannelID, channels) {
 				channels = append(channels, item.ChannelID)
@@ -1028,4 +3190,22 @@ func getBoundChannelsCount() int {
 	return len(getBoundChannels())
 }
 
+// Returns every registered text channel within a server, including channels covered by server registrations.
+func getBoundChannelsInGuild(guildID string) []string {
+	var channels []string
+	guild, err := bot.State.Guild(guildID)
+	if err != nil {
+		return channels
+	}
+	for _, channel := range guild.Channels {
+		if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+			continue
+		}
+		if isChannelRegistered(channel.ID) && !stringInSlice(channel.ID, channels) {
+			channels = append(channels, channel.ID)
+		}
+	}
+	return channels
+}
+
 //#endregion
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// The same settings in each format, with pointer fields set to their zero values so a dropped field shows up as
+// the default instead.
+var configFormatFixtures = map[string]string{
+	"json": `{
+	"credentials": {"token": "abc"},
+	"presenceOverwrite": "",
+	"inflateCount": 0,
+	"channels": [
+		{"channel": "1", "destination": "C:\Downloads\Art", "enabled": false, "scanEdits": false, "reactWhenDownloadedEmoji": ""},
+		{"channel": "2", "destination": "downloads"}
+	]
+}`,
+	"yaml": `credentials:
+  token: abc
+presenceOverwrite: ""
+inflateCount: 0
+channels:
+  - channel: "1"
+    destination: 'C:\Downloads\Art'
+    enabled: false
+    scanEdits: false
+    reactWhenDownloadedEmoji: ""
+  - channel: "2"
+    destination: downloads
+`,
+	"toml": `presenceOverwrite = ""
+inflateCount = 0
+
+[credentials]
+token = "abc"
+
+[[channels]]
+channel = "1"
+destination = 'C:\Downloads\Art'
+enabled = false
+scanEdits = false
+reactWhenDownloadedEmoji = ""
+
+[[channels]]
+channel = "2"
+destination = "downloads"
+`,
+}
+
+// Switches into an empty folder with the given settings format, validation may create folders.
+func useTestConfigFormat(t *testing.T, format string) {
+	t.Helper()
+	useTempDir(t)
+	format, configFileFormat = configFileFormat, format
+	t.Cleanup(func() {
+		configFileFormat = format
+		configFileC = false
+	})
+}
+
+func checkParsedConfig(t *testing.T, c configuration) {
+	t.Helper()
+	if c.Credentials.Token != "abc" {
+		t.Errorf("token is %q", c.Credentials.Token)
+	}
+	if c.PresenceOverwrite == nil || *c.PresenceOverwrite != "" {
+		t.Errorf("presenceOverwrite is %v, want an empty string", c.PresenceOverwrite)
+	}
+	if c.InflateCount == nil || *c.InflateCount != 0 {
+		t.Errorf("inflateCount is %v, want 0", c.InflateCount)
+	}
+	if len(c.Channels) != 2 {
+		t.Fatalf("got %d channels, want 2", len(c.Channels))
+	}
+	set, unset := c.Channels[0], c.Channels[1]
+	if set.Destination != `C:\Downloads\Art` {
+		t.Errorf("destination is %q", set.Destination)
+	}
+	if *set.Enabled || *set.ScanEdits || *set.ReactWhenDownloadedEmoji != "" {
+		t.Errorf("set fields became enabled=%v scanEdits=%v reactWhenDownloadedEmoji=%q",
+			*set.Enabled, *set.ScanEdits, *set.ReactWhenDownloadedEmoji)
+	}
+	if *unset.Enabled != ccdEnabled || *unset.ScanEdits != ccdScanEdits || *unset.ReactWhenDownloadedEmoji != ccdReactWhenDownloadedEmoji {
+		t.Errorf("unset fields didn't get their defaults")
+	}
+}
+
+func TestParseConfigFormats(t *testing.T) {
+	for _, format := range []string{"json", "yaml", "toml"} {
+		t.Run(format, func(t *testing.T) {
+			useTestConfigFormat(t, format)
+			c, err := parseConfig([]byte(configFormatFixtures[format]))
+			if err != nil {
+				t.Fatalf("Failed to parse: %s", err)
+			}
+			checkParsedConfig(t, c)
+		})
+	}
+}
+
+// JSON settings with unescaped Windows paths convert, and the YAML reads back the same.
+func TestConvertConfigToYAML(t *testing.T) {
+	useTestConfigFormat(t, "json")
+	if err := ioutil.WriteFile(configFileBase+".json", []byte(configFormatFixtures["json"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outFile, err := convertConfigToYAML()
+	if err != nil {
+		t.Fatalf("Failed to convert: %s", err)
+	}
+	converted, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFileFormat = "yaml"
+	c, err := parseConfig(converted)
+	if err != nil {
+		t.Fatalf("Failed to parse the converted settings: %s\n%s", err, converted)
+	}
+	checkParsedConfig(t, c)
+}
+
+// Parsing leaves the disk alone, destinations are only created once they're checked.
+func TestCheckConfigDestinations(t *testing.T) {
+	useTestConfigFormat(t, "json")
+	c, err := parseConfig([]byte(`{
+	"credentials": {"token": "abc"},
+	"createDestinations": true,
+	"channels": [
+		{"channel": "1", "destination": "downloads/one", "largeFileDestination": "downloads/large"},
+		{"channel": "2", "destination": "settings.json/nested"}
+	]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse: %s", err)
+	}
+	if _, err := os.Stat("downloads"); !os.IsNotExist(err) {
+		t.Fatalf("Parsing touched the disk: %v", err)
+	}
+
+	// A file in the way can't be made a folder
+	ioutil.WriteFile("settings.json", nil, 0644)
+	issues := checkConfigDestinations(&c)
+	for _, folder := range []string{"downloads/one", "downloads/large"} {
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			t.Errorf("%s wasn't created: %v", folder, err)
+		}
+	}
+	if len(c.Channels) != 1 || c.Channels[0].ChannelID != "1" {
+		t.Errorf("Kept %d channels, want only the first", len(c.Channels))
+	}
+	if len(issues) != 1 || !issues[0].Fatal || issues[0].Entry != "channels[1]" || issues[0].Field != "destination" {
+		t.Errorf("Got issues %+v, want one for channels[1].destination", issues)
+	}
+}
+
+func TestRedactCredential(t *testing.T) {
+	cases := map[string]string{
+		"":                             "empty",
+		"true":                         "true",
+		"hunter2":                      "set, 7 characters",
+		"MTIzNDU2Nzg5.abcdef.ghijklmn": "set, 28 characters",
+	}
+	for value, want := range cases {
+		if got := redactCredential(value); got != want {
+			t.Errorf("redactCredential(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// A snapshot keeps its channels while reloads swap in others, and reads never see a half-swapped config.
+func TestConfigSnapshot(t *testing.T) {
+	channel := func(id string, destination string) configurationChannel {
+		item := configurationChannel{ChannelID: id, Destination: destination}
+		channelDefault(&item)
+		return item
+	}
+	useTestConfig(t, func(settings *configuration) {
+		settings.All = nil
+		settings.Channels = []configurationChannel{channel("100", "before")}
+	})
+	snapshot := getConfig()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			settings := *getConfig()
+			if i%2 == 0 {
+				settings.Channels = []configurationChannel{channel("100", "after")}
+			} else {
+				settings.Channels = []configurationChannel{channel("100", "before")}
+			}
+			setConfig(settings)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if destination := getChannelConfig("100").Destination; destination != "before" && destination != "after" {
+			t.Fatalf("Read a channel destination of %q mid-reload", destination)
+		}
+	}
+	<-done
+
+	if !snapshot.isChannelRegistered("100") || snapshot.getChannelConfig("100").Destination != "before" {
+		t.Errorf("Snapshot changed after reloads, destination is now %q", snapshot.getChannelConfig("100").Destination)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/image/webp"
+)
+
+var logPrefixConvert = color.HiBlueString("[Convert]")
+
+// Longest a single ffmpeg conversion may take before it's abandoned and the original is saved.
+const ffmpegTimeout = 10 * time.Minute
+
+// Converts a downloaded file if the channel or convertExtensions asks for it.
+// Returns the new contents and extension, or the originals if nothing applies or the conversion failed.
+func convertDownload(body []byte, extension string, channelConfig configurationChannel) ([]byte, string) {
+	settings := getConfig()
+	var converted []byte
+	var target string
+	var err error
+	switch {
+	case extension == ".webp" && *channelConfig.ConvertWebPToPNG:
+		target = ".png"
+		converted, err = convertWebPToPNG(body)
+	case extension == ".avif" && *channelConfig.ConvertAVIFToPNG:
+		// No pure Go AVIF decoder, ffmpeg it is
+		if settings.FFmpegPath == "" {
+			return body, extension
+		}
+		target = ".png"
+		converted, err = convertWithFFmpeg(body, extension, target)
+	case settings.FFmpegPath != "" && settings.ConvertExtensions[extension] != "":
+		target = settings.ConvertExtensions[extension]
+		converted, err = convertWithFFmpeg(body, extension, target)
+	default:
+		return body, extension
+	}
+
+	if err != nil {
+		log.Println(logPrefixConvert, color.HiRedString("Failed to convert %s to %s, saving the original...\t%s", extension, target, err))
+		return body, extension
+	}
+	if settings.DebugOutput {
+		log.Println(logPrefixDebug, color.YellowString("Converted %s (%s) to %s (%s)", extension, formatBytes(int64(len(body))), target, formatBytes(int64(len(converted)))))
+	}
+	return converted, target
+}
+
+func convertWebPToPNG(body []byte) ([]byte, error) {
+	img, err := webp.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// ffmpeg needs seekable input for most containers, so the conversion goes through temp files.
+func convertWithFFmpeg(body []byte, extension string, target string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "ddg-convert-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input, output := filepath.Join(dir, "input"+extension), filepath.Join(dir, "output"+target)
+	if err := ioutil.WriteFile(input, body, 0644); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, getConfig().FFmpegPath, "-y", "-loglevel", "error", "-i", input, output)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return nil, err
+		}
+		if len(message) > 300 {
+			message = message[:300] + "..."
+		}
+		return nil, fmt.Errorf("%s: %s", err, message)
+	}
+	return ioutil.ReadFile(output)
+}
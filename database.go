@@ -4,109 +4,1115 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HouzuoGuo/tiedot/db"
 	"github.com/fatih/color"
 )
 
-// Trim files already downloaded and stored in database
-func trimDownloadedLinks(linkList map[string]string, channelID string) map[string]string {
-	channelConfig := getChannelConfig(channelID)
-
-	newList := make(map[string]string, 0)
-	for link, filename := range linkList {
-		downloadedFiles := dbFindDownloadByURL(link)
-		alreadyDownloaded := false
-		for _, downloadedFile := range downloadedFiles {
-			if downloadedFile.ChannelID == channelID {
-				alreadyDownloaded = true
+// Whether the link was already downloaded for the channel, and the row to update if it's downloaded again, -1 for
+// none. Rows whose files were found missing don't count. History with --force downloads everything again, with
+// --missing-only only links whose rows' files are gone. Returns downloadSuccess if it should be downloaded.
+func getRecordedDownload(channelConfig configurationChannel, download downloadRequestStruct) (downloadStatus, int) {
+	link, channelID := download.InputURL, download.Message.ChannelID
+	if download.SourceURL != "" {
+		link = download.SourceURL
+	}
+	var recorded, gone []*downloadItem
+	for _, downloadedFile := range dbFindDownloadByURL(link) {
+		if downloadedFile.ChannelID != channelID {
+			continue
+		}
+		if !downloadedFile.Missing.IsZero() || (download.HistoryMissing && isDownloadFileGone(downloadedFile)) {
+			gone = append(gone, downloadedFile)
+		} else {
+			recorded = append(recorded, downloadedFile)
+		}
+	}
+	switch {
+	case download.HistoryMissing:
+		if len(gone) == 0 {
+			if len(recorded) > 0 {
+				return downloadSkippedAlreadyRecorded, -1
 			}
+			return downloadIgnored, -1
 		}
-
-		if !alreadyDownloaded || *channelConfig.SavePossibleDuplicates {
-			newList[link] = filename
-		} else if config.DebugOutput {
-			log.Println(logPrefixFileSkip, color.GreenString("Found URL has already been downloaded for this channel: %s", link))
+		return downloadSuccess, gone[0].ID
+	case download.HistoryForce:
+		if rows := append(gone, recorded...); len(rows) > 0 {
+			return downloadSuccess, rows[0].ID
 		}
+	case len(recorded) > 0 && !*channelConfig.SavePossibleDuplicates:
+		return downloadSkippedAlreadyRecorded, -1
+	case len(gone) > 0:
+		return downloadSuccess, gone[0].ID
+	}
+	return downloadSuccess, -1
+}
+
+// Files deleted by retention are gone on purpose, and ones that can't be checked aren't taken for gone.
+func isDownloadFileGone(download *downloadItem) bool {
+	if download.Destination == "" || !download.Pruned.IsZero() {
+		return false
+	}
+	storage, err := getStorageBackend(download.Destination)
+	if err != nil {
+		return false
+	}
+	exists, err := storage.exists(download.Destination)
+	return err == nil && !exists
+}
+
+func dbDownloadDoc(download *downloadItem) map[string]interface{} {
+	return map[string]interface{}{
+		"URL":                download.URL,
+		"CanonicalURL":       canonicalizeURL(download.URL),
+		"FinalURL":           download.FinalURL,
+		"Time":               download.Time.String(),
+		"Destination":        download.Destination,
+		"DestinationRoot":    download.DestinationRoot,
+		"Filename":           download.Filename,
+		"ChannelID":          download.ChannelID,
+		"UserID":             download.UserID,
+		"MessageID":          download.MessageID,
+		"GuildID":            download.GuildID,
+		"Content":            download.Content,
+		"OriginalExtension":  download.OriginalExtension,
+		"Hash":               download.Hash,
+		"LinkedTo":           download.LinkedTo,
+		"Size":               download.Size,
+		"FileSize":           download.FileSize,
+		"DownloadDurationMs": download.DownloadDurationMs,
+		"Domain":             download.Domain,
+		"AltText":            download.AltText,
+		"EmbedTitle":         download.EmbedTitle,
+		"Artist":             download.Artist,
+		"PostID":             download.PostID,
+		"PostTitle":          download.PostTitle,
+		"Tags":               download.Tags,
+		"Reactions":          download.Reactions,
+		"ReactionCount":      download.ReactionCount,
+		"IsNSFW":             download.IsNSFW,
+		"IsAnimated":         download.IsAnimated,
+		"DownloadedFrom":     download.DownloadedFrom,
+		"BlobPath":           download.BlobPath,
+		"OriginalSize":       download.OriginalSize,
+		"CompressedSize":     download.CompressedSize,
+		"ThumbnailPath":      download.ThumbnailPath,
+		"TextCoverage":       download.TextCoverage,
+		"TextConfidence":     download.TextConfidence,
 	}
-	return newList
 }
 
 func dbInsertDownload(download *downloadItem) error {
-	_, err := myDB.Use("Downloads").Insert(map[string]interface{}{
-		"URL":         download.URL,
-		"Time":        download.Time.String(),
-		"Destination": download.Destination,
-		"Filename":    download.Filename,
-		"ChannelID":   download.ChannelID,
-		"UserID":      download.UserID,
-	})
+	id, err := dbDownloads().Insert(dbDownloadDoc(download))
+	if err == nil {
+		download.ID = id
+		atomic.AddInt64(&dbRowCount, 1)
+		noteLatestMessageID(download.ChannelID, download.MessageID)
+	}
 	return err
 }
 
+// For files downloaded again, the row's replaced so the link still has the one.
+func dbReplaceDownload(id int, download *downloadItem) error {
+	err := dbDownloads().Update(id, dbDownloadDoc(download))
+	if err == nil {
+		download.ID = id
+	}
+	return err
+}
+
+// Older rows may be missing fields added in later versions, so read them safely
+func dbReadString(doc map[string]interface{}, key string) string {
+	if value, ok := doc[key].(string); ok {
+		return value
+	}
+	return ""
+}
+
+func dbReadFloat64(doc map[string]interface{}, key string) float64 {
+	value, _ := doc[key].(float64)
+	return value
+}
+
+func dbReadInt64(doc map[string]interface{}, key string) int64 {
+	if value, ok := doc[key].(float64); ok {
+		return int64(value)
+	}
+	return 0
+}
+
+// Times are stored with time.String(), which can end in a monotonic clock reading that won't parse
+func dbReadBool(doc map[string]interface{}, key string) bool {
+	value, _ := doc[key].(bool)
+	return value
+}
+
+func dbReadStrings(doc map[string]interface{}, key string) []string {
+	var values []string
+	if list, ok := doc[key].([]interface{}); ok {
+		for _, item := range list {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func dbReadCounts(doc map[string]interface{}, key string) map[string]int {
+	object, ok := doc[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	counts := make(map[string]int, len(object))
+	for name, value := range object {
+		if count, ok := value.(float64); ok {
+			counts[name] = int(count)
+		}
+	}
+	return counts
+}
+
+func dbReadTime(doc map[string]interface{}, key string) time.Time {
+	value := dbReadString(doc, key)
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+	parsed, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	return parsed
+}
+
 func dbFindDownloadByID(id int) *downloadItem {
-	downloads := myDB.Use("Downloads")
+	downloads := dbDownloads()
 	readBack, err := downloads.Read(id)
 	if err != nil {
 		log.Println(color.HiRedString("Failed to read database:\t%s", err))
 	}
-	timeT, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", readBack["Time"].(string))
 	return &downloadItem{
-		URL:         readBack["URL"].(string),
-		Time:        timeT,
-		Destination: readBack["Destination"].(string),
-		Filename:    readBack["Filename"].(string),
-		ChannelID:   readBack["ChannelID"].(string),
-		UserID:      readBack["UserID"].(string),
+		ID:                 id,
+		URL:                dbReadString(readBack, "URL"),
+		FinalURL:           dbReadString(readBack, "FinalURL"),
+		Time:               dbReadTime(readBack, "Time"),
+		Destination:        dbReadString(readBack, "Destination"),
+		DestinationRoot:    dbReadString(readBack, "DestinationRoot"),
+		Filename:           dbReadString(readBack, "Filename"),
+		ChannelID:          dbReadString(readBack, "ChannelID"),
+		UserID:             dbReadString(readBack, "UserID"),
+		MessageID:          dbReadString(readBack, "MessageID"),
+		GuildID:            dbReadString(readBack, "GuildID"),
+		Content:            dbReadString(readBack, "Content"),
+		OriginalExtension:  dbReadString(readBack, "OriginalExtension"),
+		Hash:               dbReadString(readBack, "Hash"),
+		LinkedTo:           dbReadString(readBack, "LinkedTo"),
+		Size:               dbReadInt64(readBack, "Size"),
+		FileSize:           dbReadInt64(readBack, "FileSize"),
+		DownloadDurationMs: dbReadInt64(readBack, "DownloadDurationMs"),
+		Domain:             dbReadString(readBack, "Domain"),
+		SourceDeleted:      dbReadTime(readBack, "SourceDeleted"),
+		Missing:            dbReadTime(readBack, "Missing"),
+		Pruned:             dbReadTime(readBack, "Pruned"),
+		AltText:            dbReadString(readBack, "AltText"),
+		EmbedTitle:         dbReadString(readBack, "EmbedTitle"),
+		Artist:             dbReadString(readBack, "Artist"),
+		PostID:             dbReadString(readBack, "PostID"),
+		PostTitle:          dbReadString(readBack, "PostTitle"),
+		Tags:               dbReadStrings(readBack, "Tags"),
+		Reactions:          dbReadCounts(readBack, "Reactions"),
+		ReactionCount:      int(dbReadInt64(readBack, "ReactionCount")),
+		Recounted:          dbReadTime(readBack, "Recounted"),
+		IsNSFW:             dbReadBool(readBack, "IsNSFW"),
+		IsAnimated:         dbReadBool(readBack, "IsAnimated"),
+		DownloadedFrom:     dbReadString(readBack, "DownloadedFrom"),
+		BlobPath:           dbReadString(readBack, "BlobPath"),
+		OriginalSize:       dbReadInt64(readBack, "OriginalSize"),
+		CompressedSize:     dbReadInt64(readBack, "CompressedSize"),
+		ThumbnailPath:      dbReadString(readBack, "ThumbnailPath"),
+		TextCoverage:       dbReadFloat64(readBack, "TextCoverage"),
+		TextConfidence:     dbReadFloat64(readBack, "TextConfidence"),
 	}
 }
 
+// Rows for any form of the link, see canonicalizeURL. Rows not back-filled yet are found by the exact link.
 func dbFindDownloadByURL(inputURL string) []*downloadItem {
+	queryResult := dbDownloads().FindBy("URL", inputURL)
+	if dbDownloads().HasIndex("CanonicalURL") {
+		found := make(map[int]bool, len(queryResult))
+		for _, id := range queryResult {
+			found[id] = true
+		}
+		for _, id := range dbDownloads().FindBy("CanonicalURL", canonicalizeURL(inputURL)) {
+			if !found[id] {
+				queryResult = append(queryResult, id)
+			}
+		}
+	}
+
+	downloadedImages := make([]*downloadItem, 0)
+	for _, id := range queryResult {
+		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
+	}
+	return downloadedImages
+}
+
+// Sets CanonicalURL on rows from before it was recorded, so they match other forms of their link. Run at startup,
+// rows that have it are left alone.
+func dbBackfillCanonicalURLs() {
+	canonical := make(map[int]string)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		if _, exists := doc["CanonicalURL"]; !exists {
+			canonical[id] = canonicalizeURL(dbReadString(doc, "URL"))
+		}
+		return true
+	})
+	if len(canonical) == 0 {
+		return
+	}
+	log.Println(logPrefixDatabase, color.YellowString("Recording canonical links for %s older downloads, please wait...", formatNumber(int64(len(canonical)))))
+	failed := 0
+	for id, link := range canonical {
+		doc, err := dbDownloads().Read(id)
+		if err == nil {
+			doc["CanonicalURL"] = link
+			err = dbDownloads().Update(id, doc)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+	log.Println(logPrefixDatabase, color.HiYellowString("Recorded canonical links for %s older downloads, %d failed", formatNumber(int64(len(canonical)-failed)), failed))
+}
+
+func dbFindDownloadByDestination(destination string) []*downloadItem {
+	if !dbDownloads().HasIndex("Destination") {
+		return nil
+	}
+	queryResult := dbDownloads().FindBy("Destination", destination)
+
+	downloads := make([]*downloadItem, 0)
+	for _, id := range queryResult {
+		downloads = append(downloads, dbFindDownloadByID(id))
+	}
+	return downloads
+}
+
+func dbFindDownloadByHash(hash string) []*downloadItem {
+	queryResult := dbDownloads().FindBy("Hash", hash)
+
+	downloadedFiles := make([]*downloadItem, 0)
+	for _, id := range queryResult {
+		downloadedFiles = append(downloadedFiles, dbFindDownloadByID(id))
+	}
+	return downloadedFiles
+}
+
+// Latest message ID recorded per channel, worked out from the rows the first time it's asked for and kept up as
+// downloads are added, so catch-ups don't go through every row each time they're queued
+var (
+	latestMessageIDs      = make(map[string]int64)
+	latestMessageIDsMutex sync.Mutex
+)
+
+// Latest message ID with a recorded download in the channel, used to resume history from where it left off
+func dbFindLatestMessageIDByChannel(channelID string) string {
+	latestMessageIDsMutex.Lock()
+	defer latestMessageIDsMutex.Unlock()
+	latest, known := latestMessageIDs[channelID]
+	if !known {
+		for _, id := range dbDownloads().FindBy("ChannelID", channelID) {
+			messageID, err := strconv.ParseInt(dbFindDownloadByID(id).MessageID, 10, 64)
+			if err == nil && messageID > latest {
+				latest = messageID
+			}
+		}
+		latestMessageIDs[channelID] = latest
+	}
+	if latest == 0 {
+		return ""
+	}
+	return strconv.FormatInt(latest, 10)
+}
+
+// Channels not looked up yet are left for the first lookup to work out.
+func noteLatestMessageID(channelID string, messageID string) {
+	id, err := strconv.ParseInt(messageID, 10, 64)
+	if err != nil {
+		return
+	}
+	latestMessageIDsMutex.Lock()
+	defer latestMessageIDsMutex.Unlock()
+	if latest, known := latestMessageIDs[channelID]; known && id > latest {
+		latestMessageIDs[channelID] = id
+	}
+}
+
+// Row IDs of downloads from a message
+func dbFindDownloadIDsByMessage(messageID string) []int {
+	return dbDownloads().FindBy("MessageID", messageID)
+}
+
+// Records the deletion of a download's source message, and where the file went if it was moved
+// Rows of files deleted by retention are kept, so the links still count as downloaded.
+func dbMarkPruned(id int, pruned time.Time) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["Pruned"] = pruned.String()
+	return downloads.Update(id, doc)
+}
+
+func dbMarkMissing(id int, missing time.Time) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["Missing"] = missing.String()
+	return downloads.Update(id, doc)
+}
+
+func dbMarkSourceDeleted(id int, deleted time.Time, destination string) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["SourceDeleted"] = deleted.String()
+	if destination != "" {
+		doc["Destination"] = destination
+	}
+	return downloads.Update(id, doc)
+}
+
+//#region Failed URLs
+
+// Failures are remembered per URL and channel
+func dbFindFailedURL(inputURL string, channelID string) (int, map[string]interface{}) {
+	failed := myDB.Use("FailedURLs")
+	if failed == nil {
+		return -1, nil
+	}
 	var query interface{}
 	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["URL"]}]`, inputURL)), &query)
 	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
+	db.EvalQuery(query, failed, &queryResult)
+	for id := range queryResult {
+		if doc, err := failed.Read(id); err == nil && dbReadString(doc, "ChannelID") == channelID {
+			return id, doc
+		}
+	}
+	return -1, nil
+}
 
-	downloadedImages := make([]*downloadItem, 0)
+func dbRecordFailedURL(inputURL string, channelID string, messageID string, status downloadStatus) error {
+	failed := myDB.Use("FailedURLs")
+	if failed == nil {
+		return fmt.Errorf("failed URLs collection is missing")
+	}
+	id, doc := dbFindFailedURL(inputURL, channelID)
+	if doc == nil {
+		_, err := failed.Insert(map[string]interface{}{
+			"URL":         inputURL,
+			"ChannelID":   channelID,
+			"MessageID":   messageID,
+			"Status":      int(status),
+			"Attempts":    1,
+			"LastAttempt": time.Now().String(),
+		})
+		return err
+	}
+	doc["MessageID"] = messageID
+	doc["Status"] = int(status)
+	doc["Attempts"] = dbReadInt64(doc, "Attempts") + 1
+	doc["LastAttempt"] = time.Now().String()
+	return failed.Update(id, doc)
+}
+
+func dbClearFailedURL(inputURL string, channelID string) {
+	if id, doc := dbFindFailedURL(inputURL, channelID); doc != nil {
+		myDB.Use("FailedURLs").Delete(id)
+	}
+}
+
+// Forgets every failure in the channel, returns the IDs of the messages they came from.
+func dbClearFailedURLsByChannel(channelID string) []string {
+	failed := myDB.Use("FailedURLs")
+	if failed == nil {
+		return nil
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["ChannelID"]}]`, channelID)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, failed, &queryResult)
+
+	var messageIDs []string
 	for id := range queryResult {
-		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
+		if doc, err := failed.Read(id); err == nil {
+			if messageID := dbReadString(doc, "MessageID"); messageID != "" && !stringInSlice(messageID, messageIDs) {
+				messageIDs = append(messageIDs, messageID)
+			}
+		}
+		failed.Delete(id)
 	}
-	return downloadedImages
+	return messageIDs
 }
 
-//#region Statistics
+//#endregion
 
-func dbDownloadCount() int {
-	i := 0
-	myDB.Use("Downloads").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
-		i++
+//#region Pending Downloads
+
+type pendingDownload struct {
+	request   downloadRequestStruct // Message is left nil, it's fetched again on recovery
+	channelID string
+	messageID string
+	queued    time.Time
+	deferred  bool // waiting for the schedule's window rather than left over
+}
+
+func dbInsertPendingDownload(download downloadRequestStruct, deferred bool) (int, error) {
+	pending := myDB.Use("Pending")
+	if pending == nil {
+		return -1, fmt.Errorf("pending downloads collection is missing")
+	}
+	return pending.Insert(map[string]interface{}{
+		"URL":          download.InputURL,
+		"ChannelID":    download.Message.ChannelID,
+		"MessageID":    download.Message.ID,
+		"Filename":     download.Filename,
+		"Destination":  download.Path,
+		"FileTime":     download.FileTime.String(),
+		"ExpectedSize": download.ExpectedSize,
+		"AttachmentID": download.AttachmentID,
+		"AltText":      download.AltText,
+		"EmbedTitle":   download.EmbedTitle,
+		"Artist":       download.Post.Artist,
+		"PostID":       download.Post.PostID,
+		"PostTitle":    download.Post.PostTitle,
+		"FallbackURL":  download.FallbackURL,
+		"WidthHint":    download.WidthHint,
+		"HeightHint":   download.HeightHint,
+		"History":      download.HistoryCmd,
+		"Queued":       time.Now().String(),
+		"Deferred":     deferred,
+	})
+}
+
+func dbDeletePendingDownload(id int) error {
+	pending := myDB.Use("Pending")
+	if pending == nil {
+		return nil
+	}
+	return pending.Delete(id)
+}
+
+func dbGetPendingDownloads() map[int]pendingDownload {
+	items := make(map[int]pendingDownload)
+	pending := myDB.Use("Pending")
+	if pending == nil {
+		return items
+	}
+	pending.ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		history, _ := doc["History"].(bool)
+		deferred, _ := doc["Deferred"].(bool)
+		items[id] = pendingDownload{
+			request: downloadRequestStruct{
+				InputURL:     dbReadString(doc, "URL"),
+				Filename:     dbReadString(doc, "Filename"),
+				Path:         dbReadString(doc, "Destination"),
+				FileTime:     dbReadTime(doc, "FileTime"),
+				ExpectedSize: dbReadInt64(doc, "ExpectedSize"),
+				AttachmentID: dbReadString(doc, "AttachmentID"),
+				AltText:      dbReadString(doc, "AltText"),
+				EmbedTitle:   dbReadString(doc, "EmbedTitle"),
+				Post: sitePost{
+					Artist:    dbReadString(doc, "Artist"),
+					PostID:    dbReadString(doc, "PostID"),
+					PostTitle: dbReadString(doc, "PostTitle"),
+				},
+				FallbackURL: dbReadString(doc, "FallbackURL"),
+				WidthHint:   int(dbReadInt64(doc, "WidthHint")),
+				HeightHint:  int(dbReadInt64(doc, "HeightHint")),
+				HistoryCmd:  history,
+			},
+			channelID: dbReadString(doc, "ChannelID"),
+			messageID: dbReadString(doc, "MessageID"),
+			queued:    dbReadTime(doc, "Queued"),
+			deferred:  deferred,
+		}
 		return true
 	})
-	return i
+	return items
 }
 
-func dbDownloadCountByChannel(channelID string) int {
+//#endregion
+
+//#region Destination Names
+
+func dbGetStableName(key string) string {
+	names := myDB.Use("Names")
+	if names == nil {
+		return ""
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, names, &queryResult)
+	for id := range queryResult {
+		if doc, err := names.Read(id); err == nil {
+			return dbReadString(doc, "Name")
+		}
+	}
+	return ""
+}
+
+func dbSetStableName(key string, name string) {
+	names := myDB.Use("Names")
+	if names == nil {
+		return
+	}
+	if _, err := names.Insert(map[string]interface{}{
+		"Key":  key,
+		"Name": name,
+		"Time": time.Now().String(),
+	}); err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to save name for %s:\t%s", key, err))
+	}
+}
+
+func dbGetStableNames() map[string]string {
+	stored := make(map[string]string)
+	names := myDB.Use("Names")
+	if names == nil {
+		return stored
+	}
+	names.ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) == nil {
+			stored[dbReadString(doc, "Key")] = dbReadString(doc, "Name")
+		}
+		return true
+	})
+	return stored
+}
+
+func dbUpdateStableName(key string, name string) error {
+	names := myDB.Use("Names")
+	if names == nil {
+		return fmt.Errorf("names collection is missing")
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, names, &queryResult)
+	for id := range queryResult {
+		doc, err := names.Read(id)
+		if err != nil {
+			return err
+		}
+		doc["Name"] = name
+		doc["Time"] = time.Now().String()
+		return names.Update(id, doc)
+	}
+	dbSetStableName(key, name)
+	return nil
+}
+
+// Destinations of a channel's downloads by row.
+func dbDownloadDestinationsByChannel(channelID string) map[int]string {
+	queryResult := dbDownloads().FindBy("ChannelID", channelID)
+
+	destinations := make(map[int]string)
+	for _, id := range queryResult {
+		if doc, err := dbDownloads().Read(id); err == nil {
+			destinations[id] = dbReadString(doc, "Destination")
+		}
+	}
+	return destinations
+}
+
+// Saved images and videos on local destinations without a thumbnail, or whose thumbnail is gone, by row.
+// From every channel if channelID is empty.
+func dbThumbnailsMissing(channelID string) map[int]string {
+	missing := make(map[int]string)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		if channelID != "" && dbReadString(doc, "ChannelID") != channelID {
+			return true
+		}
+		destination := dbReadString(doc, "Destination")
+		if destination == "" || isRemoteDestination(destination) ||
+			!isThumbnailable(getExpectedContentType(filepathExtension(destination))) {
+			return true
+		}
+		if thumbnail := dbReadString(doc, "ThumbnailPath"); thumbnail != "" {
+			if _, err := os.Stat(thumbnail); err == nil {
+				return true
+			}
+		}
+		missing[id] = destination
+		return true
+	})
+	return missing
+}
+
+func dbSetThumbnailPath(id int, path string) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["ThumbnailPath"] = path
+	return downloads.Update(id, doc)
+}
+
+func dbUpdateDownloadDestination(id int, destination string) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["Destination"] = destination
+	return downloads.Update(id, doc)
+}
+
+//#endregion
+
+//#region Pins
+
+func dbPinnedMessageIDs(channelID string) []string {
+	pins := myDB.Use("Pins")
+	if pins == nil {
+		return nil
+	}
 	var query interface{}
 	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["ChannelID"]}]`, channelID)), &query)
 	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
+	db.EvalQuery(query, pins, &queryResult)
 
-	downloadedImages := make([]*downloadItem, 0)
+	var messageIDs []string
 	for id := range queryResult {
+		if doc, err := pins.Read(id); err == nil {
+			messageIDs = append(messageIDs, dbReadString(doc, "MessageID"))
+		}
+	}
+	return messageIDs
+}
+
+func dbAddPinnedMessage(channelID string, messageID string) error {
+	pins := myDB.Use("Pins")
+	if pins == nil {
+		return fmt.Errorf("pins collection is missing")
+	}
+	if stringInSlice(messageID, dbPinnedMessageIDs(channelID)) {
+		return nil
+	}
+	_, err := pins.Insert(map[string]interface{}{
+		"ChannelID": channelID,
+		"MessageID": messageID,
+		"Time":      time.Now().String(),
+	})
+	return err
+}
+
+//#endregion
+
+//#region Reactions
+
+type messageKey struct {
+	channelID, messageID string
+}
+
+// Rows between after and window old that haven't had their reactions counted again, by message.
+func dbReactionRefreshDue(after time.Duration, window time.Duration, limit int) map[messageKey][]int {
+	due := make(map[messageKey][]int)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		age := time.Since(dbReadTime(doc, "Time"))
+		if age < after || age > window || dbReadString(doc, "MessageID") == "" || !dbReadTime(doc, "Recounted").IsZero() {
+			return true
+		}
+		key := messageKey{dbReadString(doc, "ChannelID"), dbReadString(doc, "MessageID")}
+		if _, exists := due[key]; !exists && len(due) >= limit {
+			return true
+		}
+		due[key] = append(due[key], id)
+		return true
+	})
+	return due
+}
+
+// Marks a row as recounted, replacing its reactions if they could be counted.
+func dbUpdateReactions(id int, reactions map[string]int, total int, counted bool) error {
+	downloads := dbDownloads()
+	doc, err := downloads.Read(id)
+	if err != nil {
+		return err
+	}
+	if counted {
+		doc["Reactions"] = reactions
+		doc["ReactionCount"] = total
+	}
+	doc["Recounted"] = time.Now().String()
+	return downloads.Update(id, doc)
+}
+
+//#endregion
+
+//#region Scrapes
+
+// Newest post ID saved for a scrape key ("site:name"), empty if it's never been scraped.
+func dbGetScrapeCursor(key string) string {
+	scrapes := myDB.Use("Scrapes")
+	if scrapes == nil {
+		return ""
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, scrapes, &queryResult)
+	for id := range queryResult {
+		if doc, err := scrapes.Read(id); err == nil {
+			return dbReadString(doc, "LastID")
+		}
+	}
+	return ""
+}
+
+func dbSetScrapeCursor(key string, lastID string) error {
+	scrapes := myDB.Use("Scrapes")
+	if scrapes == nil {
+		return fmt.Errorf("scrapes collection is missing")
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, scrapes, &queryResult)
+	for id := range queryResult {
+		doc, err := scrapes.Read(id)
+		if err != nil {
+			return err
+		}
+		doc["LastID"] = lastID
+		doc["Time"] = time.Now().String()
+		return scrapes.Update(id, doc)
+	}
+	_, err := scrapes.Insert(map[string]interface{}{
+		"Key":    key,
+		"LastID": lastID,
+		"Time":   time.Now().String(),
+	})
+	return err
+}
+
+//#endregion
+
+//#region Avatars
+
+func dbHasAvatar(key string) bool {
+	avatars := myDB.Use("Avatars")
+	if avatars == nil {
+		return false
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, avatars, &queryResult)
+	return len(queryResult) > 0
+}
+
+func dbInsertAvatar(key string, path string) error {
+	avatars := myDB.Use("Avatars")
+	if avatars == nil {
+		return fmt.Errorf("avatars collection is missing")
+	}
+	_, err := avatars.Insert(map[string]interface{}{
+		"Key":  key,
+		"Path": path,
+		"Time": time.Now().String(),
+	})
+	return err
+}
+
+//#endregion
+
+//#region Emojis
+
+func dbHasEmoji(key string) bool {
+	emojis := myDB.Use("Emojis")
+	if emojis == nil {
+		return false
+	}
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["Key"]}]`, key)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, emojis, &queryResult)
+	return len(queryResult) > 0
+}
+
+func dbInsertEmoji(key string, guildID string, name string, path string) error {
+	emojis := myDB.Use("Emojis")
+	if emojis == nil {
+		return fmt.Errorf("emojis collection is missing")
+	}
+	_, err := emojis.Insert(map[string]interface{}{
+		"Key":     key,
+		"GuildID": guildID,
+		"Name":    name,
+		"Path":    path,
+		"Time":    time.Now().String(),
+	})
+	return err
+}
+
+// A server's recorded emojis and stickers by row, leaving out ones already marked deleted.
+func dbGetGuildEmojis(guildID string) map[int]savedEmoji {
+	saved := make(map[int]savedEmoji)
+	emojis := myDB.Use("Emojis")
+	if emojis == nil {
+		return saved
+	}
+	emojis.ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) == nil && dbReadString(doc, "GuildID") == guildID && dbReadString(doc, "Deleted") == "" {
+			saved[id] = savedEmoji{dbReadString(doc, "Key"), dbReadString(doc, "Name")}
+		}
+		return true
+	})
+	return saved
+}
+
+func dbMarkEmojiDeleted(id int, deleted time.Time) error {
+	emojis := myDB.Use("Emojis")
+	if emojis == nil {
+		return fmt.Errorf("emojis collection is missing")
+	}
+	doc, err := emojis.Read(id)
+	if err != nil {
+		return err
+	}
+	doc["Deleted"] = deleted.String()
+	return emojis.Update(id, doc)
+}
+
+//#endregion
+
+//#region Statistics
+
+// Downloads with the query in their URL, filename or destination, ignoring case, optionally only from one channel.
+// Newest first.
+func dbSearchDownloads(query string, channelID string, tag string, reaction string, minReactions int) []*downloadItem {
+	var matches []*downloadItem
+	for _, download := range dbSearchDownloadRows(query, channelID, tag, reaction, minReactions) {
+		matches = append(matches, download)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Time.After(matches[j].Time) })
+	return matches
+}
+
+// dbSearchDownloads by row.
+func dbSearchDownloadRows(query string, channelID string, tag string, reaction string, minReactions int) map[int]*downloadItem {
+	query = strings.ToLower(query)
+	matches := make(map[int]*downloadItem)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		if channelID != "" && dbReadString(doc, "ChannelID") != channelID {
+			return true
+		}
+		if query != "" && !strings.Contains(strings.ToLower(dbReadString(doc, "URL")), query) &&
+			!strings.Contains(strings.ToLower(dbReadString(doc, "Filename")), query) &&
+			!strings.Contains(strings.ToLower(dbReadString(doc, "Destination")), query) {
+			return true
+		}
+		if tag != "" {
+			tagged := false
+			for _, name := range dbReadStrings(doc, "Tags") {
+				if strings.EqualFold(name, tag) {
+					tagged = true
+				}
+			}
+			if !tagged {
+				return true
+			}
+		}
+		if reaction != "" && dbReadCounts(doc, "Reactions")[reaction] < minReactions {
+			return true
+		} else if reaction == "" && int(dbReadInt64(doc, "ReactionCount")) < minReactions {
+			return true
+		}
+		matches[id] = dbFindDownloadByID(id)
+		return true
+	})
+	return matches
+}
+
+// Unique destinations of every recorded download.
+func dbAllDestinations() []string {
+	var destinations []string
+	seen := make(map[string]bool)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) == nil {
+			if destination := dbReadString(doc, "Destination"); destination != "" && !seen[destination] {
+				seen[destination] = true
+				destinations = append(destinations, destination)
+			}
+		}
+		return true
+	})
+	return destinations
+}
+
+// Blobs recorded downloads are stored as, for storageMode "cas".
+func dbAllBlobPaths() []string {
+	var blobPaths []string
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) == nil {
+			if _, pruned := doc["Pruned"]; pruned {
+				return true
+			}
+			if blobPath := dbReadString(doc, "BlobPath"); blobPath != "" {
+				blobPaths = append(blobPaths, blobPath)
+			}
+		}
+		return true
+	})
+	return blobPaths
+}
+
+// Newest limit downloads of every channel, newest first.
+func dbRecentDownloadsByChannel(limit int) map[string][]*downloadItem {
+	type row struct {
+		id   int
+		time time.Time
+	}
+	rows := make(map[string][]row)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) == nil {
+			channelID := dbReadString(doc, "ChannelID")
+			rows[channelID] = append(rows[channelID], row{id, dbReadTime(doc, "Time")})
+		}
+		return true
+	})
+	recent := make(map[string][]*downloadItem)
+	for channelID, channelRows := range rows {
+		sort.Slice(channelRows, func(i, j int) bool { return channelRows[i].time.After(channelRows[j].time) })
+		if len(channelRows) > limit {
+			channelRows = channelRows[:limit]
+		}
+		for _, r := range channelRows {
+			recent[channelID] = append(recent[channelID], dbFindDownloadByID(r.id))
+		}
+	}
+	return recent
+}
+
+// Bytes received per channel, and per domain with the time spent and how many downloads were timed, from rows
+// that recorded it. Also bytes saved per destination root, for rows that recorded which.
+func dbTransferTotals() (map[string]int64, map[string]*transferTotal, map[string]int64) {
+	channels := make(map[string]int64)
+	domains := make(map[string]*transferTotal)
+	roots := make(map[string]int64)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		size := dbReadInt64(doc, "FileSize")
+		if size == 0 {
+			size = dbReadInt64(doc, "Size")
+		}
+		channels[dbReadString(doc, "ChannelID")] += size
+		if root := dbReadString(doc, "DestinationRoot"); root != "" {
+			roots[root] += dbReadInt64(doc, "Size")
+		}
+		if domain, duration := dbReadString(doc, "Domain"), dbReadInt64(doc, "DownloadDurationMs"); domain != "" {
+			if domains[domain] == nil {
+				domains[domain] = &transferTotal{}
+			}
+			domains[domain].bytes += dbReadInt64(doc, "FileSize")
+			domains[domain].ms += duration
+			domains[domain].count++
+		}
+		return true
+	})
+	return channels, domains, roots
+}
+
+func dbDownloadCount() int {
+	return dbDownloads().Count()
+}
+
+func dbDownloadCountByChannel(channelID string) int {
+	queryResult := dbDownloads().FindBy("ChannelID", channelID)
+
+	downloadedImages := make([]*downloadItem, 0)
+	for _, id := range queryResult {
 		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
 	}
 	return len(downloadedImages)
 }
 
+// Bytes recorded for a channel since the given time, only the user's if userID isn't empty.
+func dbDownloadSizeByChannel(channelID string, userID string, since time.Time) int64 {
+	queryResult := dbDownloads().FindBy("ChannelID", channelID)
+
+	var total int64
+	for _, id := range queryResult {
+		download := dbFindDownloadByID(id)
+		if (userID == "" || download.UserID == userID) && !download.Time.Before(since) {
+			total += download.Size
+		}
+	}
+	return total
+}
+
+// Bytes recorded for a channel since the given time, by user.
+func dbDownloadSizeByChannelUsers(channelID string, since time.Time) map[string]int64 {
+	queryResult := dbDownloads().FindBy("ChannelID", channelID)
+
+	sizes := make(map[string]int64)
+	for _, id := range queryResult {
+		download := dbFindDownloadByID(id)
+		if !download.Time.Before(since) && download.Size > 0 {
+			sizes[download.UserID] += download.Size
+		}
+	}
+	return sizes
+}
+
 func dbDownloadCountByUser(userID string) int {
-	var query interface{}
-	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["UserID"]}]`, userID)), &query)
-	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
+	queryResult := dbDownloads().FindBy("UserID", userID)
 
 	downloadedImages := make([]*downloadItem, 0)
-	for id := range queryResult {
+	for _, id := range queryResult {
 		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
 	}
 	return len(downloadedImages)
@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rivo/duplo"
+)
+
+//#region Image Store
+
+const (
+	storeFlushInterval = 30 * time.Second
+	// Files the image store is split into, each entry goes in the one its ID hashes to. Every shard takes a couple
+	// of MB before anything's in it, so there aren't many.
+	imgStoreShardCount = 16
+	// Rough memory taken by an entry besides its ID, its candidate and a slot in the index for each of the top
+	// coefficients of each colour channel
+	imgStoreEntryBytes = 96 + 3*4*40
+)
+
+// The image store split into shards by a hash of each entry's ID, so a change only rewrites the shard it's in and
+// queries go through the shards side by side. Each entry's in exactly one shard, so queries find the same matches
+// with the same scores as a single store would.
+type shardedStore struct {
+	shards     []*duplo.Store
+	dirty      []int32 // atomic, by shard, set when its hashes changed
+	orderDirty []int32 // atomic, by shard, set when the order of its entries changed
+}
+
+func newShardedStore() *shardedStore {
+	store := &shardedStore{
+		shards:     make([]*duplo.Store, imgStoreShardCount),
+		dirty:      make([]int32, imgStoreShardCount),
+		orderDirty: make([]int32, imgStoreShardCount),
+	}
+	for i := range store.shards {
+		store.shards[i] = duplo.New()
+	}
+	return store
+}
+
+func (s *shardedStore) shardOf(id interface{}) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(fmt.Sprint(id)))
+	return int(hash.Sum32() % uint32(len(s.shards)))
+}
+
+func (s *shardedStore) Has(id interface{}) bool {
+	return s.shards[s.shardOf(id)].Has(id)
+}
+
+func (s *shardedStore) Add(id interface{}, hash duplo.Hash) {
+	shard := s.shardOf(id)
+	s.shards[shard].Add(id, hash)
+	atomic.StoreInt32(&s.dirty[shard], 1)
+}
+
+func (s *shardedStore) Delete(id interface{}) {
+	shard := s.shardOf(id)
+	if s.shards[shard].Has(id) {
+		s.shards[shard].Delete(id)
+		atomic.StoreInt32(&s.dirty[shard], 1)
+		atomic.StoreInt32(&s.orderDirty[shard], 1)
+	}
+}
+
+func (s *shardedStore) IDs() []interface{} {
+	var ids []interface{}
+	for _, shard := range s.shards {
+		ids = append(ids, shard.IDs()...)
+	}
+	return ids
+}
+
+// Matches from every shard, unsorted like duplo's.
+func (s *shardedStore) Query(hash duplo.Hash) duplo.Matches {
+	results := make([]duplo.Matches, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard *duplo.Store) {
+			defer wg.Done()
+			results[i] = shard.Query(hash)
+		}(i, shard)
+	}
+	wg.Wait()
+	var matches duplo.Matches
+	for _, result := range results {
+		matches = append(matches, result...)
+	}
+	return matches
+}
+
+func (s *shardedStore) touch(id interface{}) {
+	atomic.StoreInt32(&s.orderDirty[s.shardOf(id)], 1)
+}
+
+var (
+	imgStoreShardsPath = imgStorePath + ".shards"
+	// From before the store was sharded, migrated at launch
+	imgStoreOrderPath = imgStorePath + ".order"
+
+	// Recency of store entries for evicting once filterDuplicateImagesMaxSize is reached, most recent at the front.
+	// Entries are keyed by the path they were saved to, older stores used download numbers. Each shard keeps when
+	// its entries were last touched, the order's put back together from those at launch.
+	imgStoreOrder      = list.New()
+	imgStoreElements   = make(map[interface{}]*list.Element)
+	imgStoreStamps     = make(map[interface{}]int64)
+	imgStoreStamp      int64
+	imgStoreOrderMutex sync.Mutex
+
+	imgStoreQueries    int64 // atomic
+	imgStoreQueryNanos int64 // atomic, spent on all of them
+
+	// Held for reading by downloads between checking the store and adding to it, rebuilds swap it in under the lock
+	imgStoreSwapMutex sync.RWMutex
+
+	storeFlushOnce sync.Once
+)
+
+// When each of a shard's entries was last added or matched, higher is more recent.
+type imgStoreShardOrder struct {
+	IDs    []interface{}
+	Stamps []int64
+}
+
+func getImgStoreShardPath(shard int) string {
+	return imgStoreShardsPath + string(os.PathSeparator) + fmt.Sprintf("%02d", shard)
+}
+
+// Loads the store's shards and their entry order from disk, migrating a store from before it was sharded, and
+// starts flushing changes in the background.
+func loadImgStore() {
+	started := time.Now()
+	imgStore = newShardedStore()
+	var order []interface{}
+	if _, err := os.Stat(imgStoreShardsPath); err == nil {
+		log.Println(logPrefixDatabase, color.YellowString("Opening image filter database..."))
+		order = loadImgStoreShards()
+	} else if _, err := os.Stat(imgStorePath); err == nil {
+		log.Println(logPrefixDatabase, color.YellowString("Migrating image filter database into %d shards...", imgStoreShardCount))
+		order = migrateImgStore()
+	}
+	resetImgStoreOrder(order)
+	trimImgStore()
+
+	log.Println(logPrefixDatabase, color.HiYellowString("Image filter database opened, contains %d image%s (loaded in %s)",
+		imgStoreCount(), pluralS(imgStoreCount()), time.Since(started).Round(time.Millisecond)))
+
+	startStoreFlushing()
+}
+
+// Returns the entries of every shard, most recent first. Shards that can't be read start over.
+func loadImgStoreShards() []interface{} {
+	stamps := make(map[interface{}]int64)
+	for i, shard := range imgStore.shards {
+		shardPath := getImgStoreShardPath(i)
+		if shardFile, err := ioutil.ReadFile(shardPath); err == nil {
+			if err := shard.GobDecode(shardFile); err != nil {
+				log.Println(logPrefixDatabase, color.HiRedString("Error decoding imgStore shard %d, starting it over:\t%s", i, err))
+				imgStore.shards[i] = duplo.New()
+				atomic.StoreInt32(&imgStore.dirty[i], 1)
+			}
+		} else if !os.IsNotExist(err) {
+			log.Println(logPrefixDatabase, color.HiRedString("Error opening imgStore shard %d:\t%s", i, err))
+		}
+		var shardOrder imgStoreShardOrder
+		if orderFile, err := ioutil.ReadFile(shardPath + ".order"); err == nil {
+			gob.NewDecoder(bytes.NewReader(orderFile)).Decode(&shardOrder)
+		}
+		for j, id := range shardOrder.IDs {
+			if j < len(shardOrder.Stamps) {
+				stamps[id] = shardOrder.Stamps[j]
+			}
+		}
+	}
+	order := make([]interface{}, 0, len(stamps))
+	for id := range stamps {
+		order = append(order, id)
+	}
+	sort.Slice(order, func(i, j int) bool { return stamps[order[i]] > stamps[order[j]] })
+	return order
+}
+
+// Splits the single file store from before it was sharded into shards, keeping its entry order. The old files are
+// deleted once the shards are written, so a failed migration is tried again at the next launch.
+func migrateImgStore() []interface{} {
+	storeFile, err := ioutil.ReadFile(imgStorePath)
+	if err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Error opening imgStore file:\t%s", err))
+		return nil
+	}
+	legacy := duplo.New()
+	if err := legacy.GobDecode(storeFile); err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Error decoding imgStore, starting over:\t%s", err))
+		return nil
+	}
+	// Encoded again so older versions of the format come out in the current one
+	encoded, err := legacy.GobEncode()
+	if err == nil {
+		var shards [][]byte
+		if shards, err = splitImgStore(encoded, imgStoreShardCount, imgStore.shardOf); err == nil {
+			for i, shard := range shards {
+				if err = imgStore.shards[i].GobDecode(shard); err != nil {
+					break
+				}
+				atomic.StoreInt32(&imgStore.dirty[i], 1)
+			}
+		}
+	}
+	if err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Error splitting imgStore, starting over:\t%s", err))
+		imgStore = newShardedStore()
+		return nil
+	}
+
+	var order []interface{}
+	if orderFile, err := ioutil.ReadFile(imgStoreOrderPath); err == nil {
+		gob.NewDecoder(bytes.NewReader(orderFile)).Decode(&order)
+	}
+	resetImgStoreOrder(order)
+	if flushImgStore() {
+		os.Remove(imgStorePath)
+		os.Remove(imgStoreOrderPath)
+	}
+	return order
+}
+
+// Splits a store as duplo encodes it into a store of the same encoding for each shard, without the slots of
+// deleted entries. Hashes can't be read back out of a duplo store, so this is the only way to move them.
+func splitImgStore(encoded []byte, count int, shardOf func(interface{}) int) ([][]byte, error) {
+	decompressor, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	decoder := gob.NewDecoder(decompressor)
+	var version, size int
+	if err := decoder.Decode(&version); err != nil {
+		return nil, err
+	}
+	if version != 3 {
+		return nil, fmt.Errorf("store version %d isn't supported", version)
+	}
+	if err := decoder.Decode(&size); err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		id        interface{}
+		scaleCoef [3]float64
+		ratio     float64
+		dHash     [2]uint64
+		histogram uint64
+		histoMax  [3]float32
+	}
+	candidates := make([][]candidate, count)
+	ids := make([]map[interface{}]uint32, count)
+	for shard := range ids {
+		ids[shard] = make(map[interface{}]uint32)
+	}
+	// By index in the store, the shard it's moving to and its index there, -1 for deleted slots
+	shards := make([]int, size)
+	moved := make([]uint32, size)
+	for index := 0; index < size; index++ {
+		var c candidate
+		if err := decoder.Decode(&c.id); err != nil {
+			return nil, err
+		}
+		for _, field := range []interface{}{&c.scaleCoef, &c.ratio, &c.dHash, &c.histogram, &c.histoMax} {
+			if err := decoder.Decode(field); err != nil {
+				return nil, err
+			}
+		}
+		shards[index] = -1
+		if c.id != nil {
+			shard := shardOf(c.id)
+			shards[index], moved[index] = shard, uint32(len(candidates[shard]))
+			ids[shard][c.id] = moved[index]
+			candidates[shard] = append(candidates[shard], c)
+		}
+	}
+	var storeIDs map[interface{}]uint32
+	var storeIndices [][]uint32
+	if err := decoder.Decode(&storeIDs); err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(&storeIndices); err != nil {
+		return nil, err
+	}
+	indices := make([][][]uint32, count)
+	for shard := range indices {
+		indices[shard] = make([][]uint32, len(storeIndices))
+	}
+	for location, list := range storeIndices {
+		for _, index := range list {
+			if int(index) < size && shards[index] != -1 {
+				indices[shards[index]][location] = append(indices[shards[index]][location], moved[index])
+			}
+		}
+	}
+
+	split := make([][]byte, count)
+	for shard := range split {
+		var buffer bytes.Buffer
+		compressor := gzip.NewWriter(&buffer)
+		encoder := gob.NewEncoder(compressor)
+		values := []interface{}{version, len(candidates[shard])}
+		for i := range candidates[shard] {
+			c := &candidates[shard][i]
+			values = append(values, &c.id, c.scaleCoef, c.ratio, c.dHash, c.histogram, c.histoMax)
+		}
+		values = append(values, ids[shard], indices[shard])
+		for _, value := range values {
+			if err := encoder.Encode(value); err != nil {
+				return nil, err
+			}
+		}
+		if err := compressor.Close(); err != nil {
+			return nil, err
+		}
+		split[shard] = buffer.Bytes()
+	}
+	return split, nil
+}
+
+// Both stores share one background flush, started by whichever loads first.
+func startStoreFlushing() {
+	storeFlushOnce.Do(func() {
+		go func() {
+			for range time.Tick(storeFlushInterval) {
+				flushImgStore()
+				flushVidStore()
+			}
+		}()
+	})
+}
+
+// Orders entries as given, with any the order is missing appended after.
+func resetImgStoreOrder(order []interface{}) {
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	imgStoreOrder.Init()
+	imgStoreElements = make(map[interface{}]*list.Element)
+	for _, id := range order {
+		if _, exists := imgStoreElements[id]; !exists && imgStore.Has(id) {
+			imgStoreElements[id] = imgStoreOrder.PushBack(id)
+		}
+	}
+	for _, id := range imgStore.IDs() {
+		if _, exists := imgStoreElements[id]; !exists {
+			imgStoreElements[id] = imgStoreOrder.PushBack(id)
+		}
+	}
+	imgStoreStamps = make(map[interface{}]int64)
+	imgStoreStamp = int64(imgStoreOrder.Len())
+	stamp := imgStoreStamp
+	for element := imgStoreOrder.Front(); element != nil; element = element.Next() {
+		imgStoreStamps[element.Value] = stamp
+		stamp--
+	}
+	for i := range imgStore.orderDirty {
+		atomic.StoreInt32(&imgStore.orderDirty[i], 1)
+	}
+}
+
+// Evicts the least recently added or matched entries over filterDuplicateImagesMaxSize. Returns true if any were.
+func trimImgStore() bool {
+	settings := getConfig()
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	trimmed := false
+	for settings.FilterDuplicateImagesMaxSize > 0 && imgStoreOrder.Len() > settings.FilterDuplicateImagesMaxSize {
+		oldest := imgStoreOrder.Back()
+		imgStoreOrder.Remove(oldest)
+		delete(imgStoreElements, oldest.Value)
+		delete(imgStoreStamps, oldest.Value)
+		imgStore.Delete(oldest.Value)
+		trimmed = true
+	}
+	return trimmed
+}
+
+// duplo keeps a small slot for deleted entries until the store is rebuilt, so its Size() can't be used for this.
+func imgStoreCount() int {
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	return imgStoreOrder.Len()
+}
+
+// Rough memory the store takes, with the slots of deleted entries.
+func imgStoreMemory() int64 {
+	var total int64
+	for _, shard := range imgStore.shards {
+		total += int64(shard.Size()) * imgStoreEntryBytes
+	}
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	for id := range imgStoreElements {
+		if path, isPath := id.(string); isPath {
+			total += int64(len(path))
+		}
+	}
+	return total
+}
+
+// Average time a query through every shard has taken this run, zero before the first.
+func imgStoreAverageQuery() time.Duration {
+	queries := atomic.LoadInt64(&imgStoreQueries)
+	if queries == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&imgStoreQueryNanos) / queries)
+}
+
+func touchImgStore(id interface{}) {
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	if element, exists := imgStoreElements[id]; exists {
+		imgStoreOrder.MoveToFront(element)
+	} else {
+		imgStoreElements[id] = imgStoreOrder.PushFront(id)
+	}
+	imgStoreStamp++
+	imgStoreStamps[id] = imgStoreStamp
+	imgStore.touch(id)
+}
+
+func addToImgStore(path string, hash duplo.Hash) {
+	imgStore.Add(path, hash)
+	touchImgStore(path)
+	trimImgStore()
+}
+
+// For files that were deleted, so later copies aren't taken for duplicates of them.
+func removeFromImgStore(id interface{}) {
+	if imgStore == nil {
+		return
+	}
+	imgStoreOrderMutex.Lock()
+	defer imgStoreOrderMutex.Unlock()
+	if element, exists := imgStoreElements[id]; exists {
+		imgStoreOrder.Remove(element)
+		delete(imgStoreElements, id)
+		delete(imgStoreStamps, id)
+	}
+	imgStore.Delete(id)
+}
+
+// Returns the best match under filterDuplicateImagesThreshold, or nil.
+func findDuplicateImage(hash duplo.Hash) *duplo.Match {
+	started := time.Now()
+	matches := imgStore.Query(hash)
+	atomic.AddInt64(&imgStoreQueryNanos, int64(time.Since(started)))
+	atomic.AddInt64(&imgStoreQueries, 1)
+	sort.Sort(matches)
+	for _, match := range matches {
+		if match.Score < getConfig().FilterDuplicateImagesThreshold {
+			touchImgStore(match.ID)
+			return match
+		}
+	}
+	return nil
+}
+
+// GIFs are hashed by their first frame. Animated WebP isn't supported by the decoder and fails.
+func hashImage(body []byte) (duplo.Hash, error) {
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return duplo.Hash{}, err
+	}
+	hash, _ := duplo.CreateHash(img)
+	return hash, nil
+}
+
+// Writes the shards whose hashes or order changed since the last flush. Returns false if any failed to, they're
+// tried again at the next flush.
+func flushImgStore() bool {
+	if imgStore == nil {
+		return true
+	}
+	flushed := true
+	var orders map[int]*imgStoreShardOrder
+	for i, shard := range imgStore.shards {
+		if !atomic.CompareAndSwapInt32(&imgStore.dirty[i], 1, 0) {
+			continue
+		}
+		encodedShard, err := shard.GobEncode()
+		if err == nil {
+			err = writeFileAtomic(getImgStoreShardPath(i), encodedShard)
+		}
+		if err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Failed to update imgStore shard %d:\t%s", i, err))
+			atomic.StoreInt32(&imgStore.dirty[i], 1)
+			flushed = false
+		}
+	}
+	for i := range imgStore.shards {
+		if atomic.CompareAndSwapInt32(&imgStore.orderDirty[i], 1, 0) {
+			if orders == nil {
+				orders = make(map[int]*imgStoreShardOrder)
+			}
+			orders[i] = &imgStoreShardOrder{}
+		}
+	}
+	if orders == nil {
+		return flushed
+	}
+	imgStoreOrderMutex.Lock()
+	for id, stamp := range imgStoreStamps {
+		if order, exists := orders[imgStore.shardOf(id)]; exists {
+			order.IDs = append(order.IDs, id)
+			order.Stamps = append(order.Stamps, stamp)
+		}
+	}
+	imgStoreOrderMutex.Unlock()
+	for i, order := range orders {
+		var encodedOrder bytes.Buffer
+		err := gob.NewEncoder(&encodedOrder).Encode(order)
+		if err == nil {
+			err = writeFileAtomic(getImgStoreShardPath(i)+".order", encodedOrder.Bytes())
+		}
+		if err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Failed to update imgStore shard %d order:\t%s", i, err))
+			atomic.StoreInt32(&imgStore.orderDirty[i], 1)
+			flushed = false
+		}
+	}
+	return flushed
+}
+
+// Writes to a temporary file first so a crash mid-write doesn't leave a corrupt file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// Regenerates the store from downloaded images still on disk. Remote destinations are skipped.
+func rebuildImgStore() (int, int) {
+	rebuilt := newShardedStore()
+	var order []interface{}
+	failed := 0
+	for _, destination := range dbAllDestinations() {
+		if isRemoteDestination(destination) {
+			continue
+		}
+		switch strings.ToLower(filepathExtension(destination)) {
+		case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		default:
+			continue
+		}
+		body, err := ioutil.ReadFile(destination)
+		if err != nil {
+			continue
+		}
+		hash, err := hashImage(body)
+		if err != nil {
+			failed++
+			continue
+		}
+		rebuilt.Add(destination, hash)
+		order = append(order, destination)
+	}
+	// Every shard's rewritten, including ones left empty
+	for i := range rebuilt.dirty {
+		rebuilt.dirty[i] = 1
+	}
+
+	// Swapped once no downloads are using the old store
+	imgStoreSwapMutex.Lock()
+	imgStore = rebuilt
+	resetImgStoreOrder(order)
+	trimImgStore()
+	imgStoreSwapMutex.Unlock()
+
+	flushImgStore()
+	return imgStoreCount(), failed
+}
+
+//#endregion
+
+//#region Video Store
+
+// Longest sampling a single video's frames may take, so a corrupt file can't hold up a download worker.
+const videoHashTimeout = 2 * time.Minute
+
+// Where frames are sampled from, as fractions of the video's duration.
+var videoSamplePoints = []float64{0.1, 0.5, 0.9}
+
+var (
+	vidStore      *duplo.Store
+	vidStorePath  = cachePath + string(os.PathSeparator) + "vidStore"
+	vidStoreDirty int32 // atomic, set when there are changes to flush
+)
+
+func loadVidStore() {
+	started := time.Now()
+	vidStore = duplo.New()
+	if storeFile, err := ioutil.ReadFile(vidStorePath); err == nil {
+		log.Println(logPrefixDatabase, color.YellowString("Opening video filter database..."))
+		if err := vidStore.GobDecode(storeFile); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Error decoding vidStore, starting over:\t%s", err))
+			vidStore = duplo.New()
+		}
+	} else if !os.IsNotExist(err) {
+		log.Println(logPrefixDatabase, color.HiRedString("Error opening vidStore file:\t%s", err))
+	}
+
+	videos := make(map[string]bool)
+	for _, id := range vidStore.IDs() {
+		if path, _, ok := parseVideoFrameID(id); ok {
+			videos[path] = true
+		}
+	}
+	log.Println(logPrefixDatabase, color.HiYellowString("Video filter database opened, contains %d video%s (loaded in %s)",
+		len(videos), pluralS(len(videos)), time.Since(started).Round(time.Millisecond)))
+
+	startStoreFlushing()
+}
+
+// Frames are stored individually, keyed by the video's path and which sample point they're from.
+func videoFrameID(path string, frame int) string {
+	return fmt.Sprintf("%s#%d", path, frame)
+}
+
+func parseVideoFrameID(id interface{}) (string, int, bool) {
+	str, ok := id.(string)
+	if !ok {
+		return "", 0, false
+	}
+	i := strings.LastIndex(str, "#")
+	if i == -1 {
+		return "", 0, false
+	}
+	frame, err := strconv.Atoi(str[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return str[:i], frame, true
+}
+
+func addToVidStore(path string, hashes []duplo.Hash) {
+	for i, hash := range hashes {
+		vidStore.Add(videoFrameID(path, i), hash)
+	}
+	atomic.StoreInt32(&vidStoreDirty, 1)
+}
+
+// Returns the path of a stored video whose frames are all under filterDuplicateVideosThreshold
+// against the same sample points of this one, or an empty string.
+func findDuplicateVideo(hashes []duplo.Hash) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	matched := make(map[string]int)
+	for i, hash := range hashes {
+		seen := make(map[string]bool)
+		for _, match := range vidStore.Query(hash) {
+			path, frame, ok := parseVideoFrameID(match.ID)
+			if ok && frame == i && !seen[path] && match.Score < getConfig().FilterDuplicateVideosThreshold {
+				seen[path] = true
+				matched[path]++
+			}
+		}
+	}
+	for path, count := range matched {
+		if count == len(hashes) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Extracts a frame at each sample point with ffmpeg and hashes them.
+func hashVideo(body []byte, extension string) ([]duplo.Hash, error) {
+	dir, err := ioutil.TempDir("", "ddg-dedupe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input"+extension)
+	if err := ioutil.WriteFile(input, body, 0644); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), videoHashTimeout)
+	defer cancel()
+
+	duration, err := probeVideoDuration(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []duplo.Hash
+	for i, point := range videoSamplePoints {
+		output := filepath.Join(dir, fmt.Sprintf("frame%d.png", i))
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, getConfig().FFmpegPath, "-y", "-loglevel", "error",
+			"-ss", strconv.FormatFloat(duration*point, 'f', 3, 64), "-i", input, "-frames:v", "1", output)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("timed out after %s", videoHashTimeout)
+			}
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		frame, err := ioutil.ReadFile(output)
+		if err != nil {
+			// Nothing written, e.g. a seek past the last keyframe of a very short video
+			return nil, fmt.Errorf("no frame at %.0f%% of the video", point*100)
+		}
+		hash, err := hashImage(frame)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+var regexFFmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// ffmpeg without an output prints the input's details and exits with an error, which is expected.
+func probeVideoDuration(ctx context.Context, input string) (float64, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, getConfig().FFmpegPath, "-hide_banner", "-i", input)
+	cmd.Stderr = &stderr
+	cmd.Run()
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("timed out after %s", videoHashTimeout)
+	}
+	match := regexFFmpegDuration.FindStringSubmatch(stderr.String())
+	if match == nil {
+		return 0, fmt.Errorf("couldn't read the video's duration")
+	}
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	return float64(hours*3600+minutes*60) + seconds, nil
+}
+
+func flushVidStore() {
+	if vidStore == nil || !atomic.CompareAndSwapInt32(&vidStoreDirty, 1, 0) {
+		return
+	}
+	encodedStore, err := vidStore.GobEncode()
+	if err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to encode vidStore:\t%s", err))
+		return
+	}
+	if err := writeFileAtomic(vidStorePath, encodedStore); err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to update vidStore file:\t%s", err))
+	}
+}
+
+//#endregion
+
+//#region Duplicate Links
+
+var logPrefixDuplicate = color.HiGreenString("[Duplicate]")
+
+// Windows refuses symlinks without admin rights or Developer Mode with ERROR_PRIVILEGE_NOT_HELD.
+const errorPrivilegeNotHeld = syscall.Errno(1314)
+
+// Decides what happens to a duplicate of original per the channel's duplicateAction.
+// Returns the file to link to, if any, and whether the download should be skipped instead.
+func handleDuplicate(channelConfig configurationChannel, downloadPath string, original string) (string, bool) {
+	switch *channelConfig.DuplicateAction {
+	case "save":
+		return "", false
+	case "hardlink", "symlink":
+		// Links need both ends on the local filesystem, otherwise there's nothing to do but save it again
+		if original == "" || isRemoteDestination(original) || isRemoteDestination(downloadPath) {
+			return "", false
+		}
+		if _, err := os.Stat(original); err != nil {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDuplicate, color.YellowString("Duplicate's original \"%s\" is gone, saving it again", original))
+			}
+			return "", false
+		}
+		return original, false
+	}
+	return "", true
+}
+
+// Links path to original, falling back from symlink to hardlink to copying when the previous fails.
+// Returns how the file ended up linked.
+func linkDuplicate(original string, path string, action string) (string, error) {
+	if action == "symlink" {
+		target, err := filepath.Abs(original)
+		if err == nil {
+			err = os.Symlink(target, path)
+		}
+		if err == nil {
+			return "symlink", nil
+		}
+		if errors.Is(err, errorPrivilegeNotHeld) {
+			log.Println(logPrefixDuplicate, color.HiYellowString("Creating symlinks on Windows needs Developer Mode or running as administrator, hardlinking \"%s\" instead", path))
+		} else {
+			log.Println(logPrefixDuplicate, color.HiYellowString("Failed to symlink \"%s\", hardlinking instead:\t%s", path, err))
+		}
+	}
+	err := os.Link(original, path)
+	if err == nil {
+		return "hardlink", nil
+	}
+	// Usually the destinations being on different filesystems
+	log.Println(logPrefixDuplicate, color.HiYellowString("Failed to hardlink \"%s\", copying instead:\t%s", path, err))
+	return "copy", copyFile(original, path)
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return err
+	}
+	return out.Close()
+}
+
+//#endregion
+
+//#region Filename Collisions
+
+// Whether the file already at path has these contents, by its database row or by reading it if it's local.
+func isSameContent(storage storageBackend, path string, hash string, size int64) bool {
+	for _, existing := range dbFindDownloadByDestination(path) {
+		if existing.Hash != "" {
+			return existing.Hash == hash
+		}
+	}
+	if _, local := storage.(localStorage); !local {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != size {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	return err == nil && hashBytes(data) == hash
+}
+
+//#endregion
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rivo/duplo"
+)
+
+func newTestImageHash(seed int64, tweak bool) duplo.Hash {
+	random := rand.New(rand.NewSource(seed))
+	picture := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x += 8 {
+		for y := 0; y < 64; y += 8 {
+			block := color.RGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), 255}
+			for i := 0; i < 64; i++ {
+				picture.Set(x+i%8, y+i/8, block)
+			}
+		}
+	}
+	// Close enough to be a duplicate of the untweaked one
+	if tweak {
+		picture.Set(0, 0, color.RGBA{255, 255, 255, 255})
+	}
+	hash, _ := duplo.CreateHash(picture)
+	return hash
+}
+
+type testMatch struct {
+	ID    interface{}
+	Score float64
+}
+
+func sortedMatches(matches duplo.Matches) []testMatch {
+	sorted := make([]testMatch, len(matches))
+	for i, match := range matches {
+		sorted[i] = testMatch{match.ID, match.Score}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Score < sorted[j].Score })
+	return sorted
+}
+
+// A store from before sharding is split into shards on launch, answers queries exactly as it did, keeps the order
+// entries are evicted in, and reads back the same from the shards after.
+func TestMigrateImgStore(t *testing.T) {
+	useTempDir(t)
+	previousStore := imgStore
+	defer func() { imgStore = previousStore }()
+	useTestConfig(t, func(settings *configuration) { settings.FilterDuplicateImagesThreshold = -60 })
+
+	// Older stores used download numbers, newer ones paths
+	idOf := func(i int) interface{} {
+		if i%4 == 0 {
+			return i
+		}
+		return fmt.Sprintf("downloads/image%d.png", i)
+	}
+	legacy := duplo.New()
+	var ids []interface{}
+	for i := 0; i < 24; i++ {
+		legacy.Add(idOf(i), newTestImageHash(int64(i), false))
+		ids = append(ids, idOf(i))
+	}
+	// Deleted entries leave a slot behind in the encoded store
+	legacy.Delete(idOf(5))
+	ids = append(ids[:5], ids[6:]...)
+	encoded, err := legacy.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(cachePath, 0755)
+	if err := ioutil.WriteFile(imgStorePath, encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Most recent first, the reverse of when they were added
+	order := make([]interface{}, len(ids))
+	for i, id := range ids {
+		order[len(ids)-1-i] = id
+	}
+	var encodedOrder bytes.Buffer
+	if err := gob.NewEncoder(&encodedOrder).Encode(order); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(imgStoreOrderPath, encodedOrder.Bytes(), 0644)
+
+	imgStore = newShardedStore()
+	migrated := migrateImgStore()
+	if !reflect.DeepEqual(migrated, order) {
+		t.Errorf("Order wasn't kept, got %v", migrated)
+	}
+	if _, err := os.Stat(imgStorePath); !os.IsNotExist(err) {
+		t.Errorf("The old store is still there: %v", err)
+	}
+	if _, err := os.Stat(getImgStoreShardPath(0)); err != nil {
+		t.Errorf("Shards weren't written: %s", err)
+	}
+	if count := imgStoreCount(); count != len(ids) {
+		t.Errorf("Migrated %d entries, want %d", count, len(ids))
+	}
+	if imgStore.Has(idOf(5)) {
+		t.Error("The deleted entry came back")
+	}
+
+	checkQueries := func(t *testing.T) {
+		t.Helper()
+		for i := 0; i < 24; i++ {
+			for _, tweak := range []bool{false, true} {
+				hash := newTestImageHash(int64(i), tweak)
+				want, got := sortedMatches(legacy.Query(hash)), sortedMatches(imgStore.Query(hash))
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("Image %d (tweaked %v) matched %v, want %v", i, tweak, got, want)
+				}
+				var wantID interface{}
+				for _, match := range want {
+					if match.Score < getConfig().FilterDuplicateImagesThreshold {
+						wantID = match.ID
+						break
+					}
+				}
+				var gotID interface{}
+				if match := findDuplicateImage(hash); match != nil {
+					gotID = match.ID
+				}
+				if gotID != wantID {
+					t.Errorf("Image %d (tweaked %v) is a duplicate of %v, want %v", i, tweak, gotID, wantID)
+				}
+				if i != 5 && gotID != idOf(i) {
+					t.Errorf("Image %d (tweaked %v) is a duplicate of %v, not itself", i, tweak, gotID)
+				}
+			}
+		}
+	}
+	checkQueries(t)
+
+	// Read back from the shards like the next launch would
+	imgStore = newShardedStore()
+	reloaded := loadImgStoreShards()
+	if len(reloaded) != len(ids) {
+		t.Errorf("Reloaded %d entries, want %d", len(reloaded), len(ids))
+	}
+	checkQueries(t)
+}
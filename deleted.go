@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Messages kept per channel for downloadOnDelete, attachments of deleted messages are only recoverable from these
+const deletedMessageCacheSize = 100
+
+var logPrefixDeleted = color.HiRedString("[Deleted]")
+
+var (
+	// Deleted by deleteAfterDownload, those aren't tracked
+	botDeletedMessages sync.Map
+	// Being downloaded after deletion, so there's nothing left to delete
+	lateDownloadMessages sync.Map
+)
+
+// Discord only sends the ID of a deleted message, so the state has to keep recent ones for downloadOnDelete.
+func configureMessageCache() {
+	settings := getConfig()
+	if bot == nil || bot.State == nil {
+		return
+	}
+	enabled := func(channel configurationChannel) bool {
+		return channel.DownloadOnDelete != nil && *channel.DownloadOnDelete
+	}
+	size := 0
+	if settings.All != nil && enabled(*settings.All) {
+		size = deletedMessageCacheSize
+	}
+	for _, item := range settings.Servers {
+		if enabled(item) {
+			size = deletedMessageCacheSize
+		}
+	}
+	for _, item := range settings.Channels {
+		if enabled(item) {
+			size = deletedMessageCacheSize
+		}
+	}
+	bot.State.MaxMessageCount = size
+}
+
+//#region Events
+
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	handleDeletedMessage(m.ChannelID, m.ID, m.BeforeDelete)
+}
+
+// The state drops bulk deleted messages before handlers see them, so they can't be downloaded late.
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	for _, messageID := range m.Messages {
+		handleDeletedMessage(m.ChannelID, messageID, nil)
+	}
+}
+
+//#endregion
+
+func handleDeletedMessage(channelID string, messageID string, cached *discordgo.Message) {
+	if _, ours := botDeletedMessages.Load(messageID); ours {
+		botDeletedMessages.Delete(messageID)
+		return
+	}
+	if !isChannelRegistered(channelID) {
+		return
+	}
+	channelConfig := getChannelConfig(channelID)
+	deleted := time.Now()
+
+	ids := dbFindDownloadIDsByMessage(messageID)
+	// Never processed, Discord keeps attachments around briefly after deletion
+	if len(ids) == 0 {
+		if cached != nil && cached.Author != nil && len(cached.Attachments) > 0 && *channelConfig.DownloadOnDelete {
+			log.Println(logPrefixDeleted, color.YellowString("Message %s in %s was deleted before it was processed, downloading its attachments...", messageID, getChannelName(channelID)))
+			lateDownloadMessages.Store(messageID, true)
+			handleMessage(cached, false, nil)
+			lateDownloadMessages.Delete(messageID)
+			ids = dbFindDownloadIDsByMessage(messageID)
+		}
+		if len(ids) == 0 {
+			return
+		}
+	}
+
+	action := ""
+	if channelConfig.DeletedFilesAction != nil {
+		action = *channelConfig.DeletedFilesAction
+	}
+	if !*channelConfig.MarkDeletedMessages && action == "" {
+		return
+	}
+
+	folder := filepath.Join(resolveDestination(channelConfig.Destination, &discordgo.Message{ChannelID: channelID}), "deleted", "CID_"+channelID)
+	for _, id := range ids {
+		download := dbFindDownloadByID(id)
+		destination := ""
+		if action != "" && download.Destination != "" && !isRemoteDestination(download.Destination) {
+			path, err := archiveDeletedFile(download.Destination, folder, action == "move")
+			if err != nil {
+				log.Println(logPrefixDeleted, color.HiRedString("Failed to %s \"%s\" for deleted message %s:\t%s", action, download.Destination, messageID, err))
+			} else if action == "move" {
+				destination = path
+			}
+		}
+		if *channelConfig.MarkDeletedMessages || destination != "" {
+			if err := dbMarkSourceDeleted(id, deleted, destination); err != nil {
+				log.Println(logPrefixDeleted, color.HiRedString("Failed to record deletion of message %s:\t%s", messageID, err))
+			}
+		}
+	}
+	if getConfig().DebugOutput {
+		log.Println(logPrefixDebug, color.YellowString("Message %s in %s was deleted, %d file%s recorded", messageID, getChannelName(channelID), len(ids), pluralS(len(ids))))
+	}
+}
+
+// Moves or copies a saved file into the folder, keeping its name. Returns the new path.
+func archiveDeletedFile(path string, folder string, move bool) (string, error) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return "", err
+	}
+	target := filepath.Join(folder, filepath.Base(path))
+	if move {
+		if err := os.Rename(path, target); err == nil {
+			return target, nil
+		}
+		// Different drive, copy then remove
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return "", err
+	}
+	destination, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		os.Remove(target)
+		return "", err
+	}
+	if err := destination.Close(); err != nil {
+		return "", err
+	}
+	os.Chtimes(target, info.ModTime(), info.ModTime())
+
+	if move {
+		source.Close()
+		if err := os.Remove(path); err != nil {
+			return target, err
+		}
+	}
+	return target, nil
+}
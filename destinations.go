@@ -0,0 +1,311 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/unicode/runenames"
+)
+
+// Destinations can be relative to basePath and contain {serverName}, {serverID}, {channelName}, {channelID}
+// and {categoryName}, filled in when each file is downloaded.
+
+const (
+	folderNameEmojiKeep          = "keep"
+	folderNameEmojiStrip         = "strip"
+	folderNameEmojiTransliterate = "transliterate"
+)
+
+var (
+	destinationTokens   = []string{"{serverName}", "{serverID}", "{channelName}", "{channelID}", "{categoryName}"}
+	repeatedSeparators  = regexp.MustCompile(`[/\\]{2,}`)
+	destinationNames    sync.Map // "kind:id" -> name, in front of the database
+	destinationNamesMux sync.Mutex
+
+	folderNameEmojiModes = []string{folderNameEmojiKeep, folderNameEmojiStrip, folderNameEmojiTransliterate}
+	repeatedWhitespace   = regexp.MustCompile(`\s+`)
+	regionalIndicators   = regexp.MustCompile(`[\x{1F1E6}-\x{1F1FF}]+`)
+)
+
+// Joins relative local destinations onto basePath.
+func applyBasePath(basePath string, destination string) string {
+	if basePath == "" || destination == "" || isRemoteDestination(destination) || filepath.IsAbs(destination) {
+		return destination
+	}
+	return filepath.Join(basePath, destination)
+}
+
+func isTemplatedDestination(destination string) bool {
+	for _, token := range destinationTokens {
+		if strings.Contains(destination, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// The part of a destination that's the same for every download, for checks that can't fill in tokens.
+func getDestinationRoot(basePath string, destination string) string {
+	destination = applyBasePath(basePath, destination)
+	if i := strings.Index(destination, "{"); i >= 0 && isTemplatedDestination(destination) {
+		root := destination[:i]
+		if j := strings.LastIndexAny(root, `/\`); j >= 0 {
+			return root[:j+1]
+		}
+		return "."
+	}
+	return destination
+}
+
+// Every destination in settings, before basePath and templates are applied.
+func getConfiguredDestinations() []string {
+	settings := getConfig()
+	var destinations []string
+	add := func(destination string) {
+		if destination != "" {
+			destinations = append(destinations, destination)
+		}
+	}
+	channels := append(append([]configurationChannel{}, settings.Channels...), settings.Servers...)
+	if settings.All != nil {
+		channels = append(channels, *settings.All)
+	}
+	for _, channel := range channels {
+		add(channel.Destination)
+		if channel.NSFWDestinationOverride != nil {
+			add(*channel.NSFWDestinationOverride)
+		}
+	}
+	for _, destination := range settings.ManualDestinations {
+		add(destination)
+	}
+	if _, exists := settings.ManualDestinations["scrape"]; !exists {
+		add(scrapeDestinationDefault)
+	}
+	if settings.AvatarTracking != nil {
+		add(settings.AvatarTracking.Destination)
+	}
+	return destinations
+}
+
+// Whether destination is one in settings or a folder inside one. Local paths are compared after basePath is
+// applied and ".." is cleaned out, remote ones by prefix.
+func isConfiguredDestination(destination string) bool {
+	if destination == "" {
+		return false
+	}
+	inside := func(root string, path string) bool {
+		relative, err := filepath.Rel(root, path)
+		return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+	}
+	absolute := func(path string) string {
+		if resolved, err := filepath.Abs(applyBasePath(getConfig().BasePath, path)); err == nil {
+			return resolved
+		}
+		return filepath.Clean(path)
+	}
+	for _, configured := range getConfiguredDestinations() {
+		if destination == configured {
+			return true
+		}
+		if isRemoteDestination(configured) != isRemoteDestination(destination) || isTemplatedDestination(destination) {
+			continue
+		}
+		if isRemoteDestination(configured) {
+			if !strings.Contains(destination, "/../") && !strings.HasSuffix(destination, "/..") &&
+				strings.HasPrefix(destination, strings.TrimSuffix(configured, "/")+"/") {
+				return true
+			}
+			continue
+		}
+		if inside(absolute(getDestinationRoot("", configured)), absolute(destination)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fills in a destination for where the message came from.
+func resolveDestination(destination string, message *discordgo.Message) string {
+	destination = applyBasePath(getConfig().BasePath, destination)
+	if message == nil || !isTemplatedDestination(destination) {
+		return destination
+	}
+
+	channelID, guildID := message.ChannelID, message.GuildID
+	if guildID == "" {
+		guildID = getChannelGuildID(channelID)
+	}
+	serverName, channelName, categoryName := guildID, channelID, ""
+	if channel, err := bot.State.Channel(channelID); err == nil && channel != nil {
+		switch channel.Type {
+		case discordgo.ChannelTypeDM:
+			serverName = "Direct Messages"
+		case discordgo.ChannelTypeGroupDM:
+			serverName = "Group Messages"
+		}
+		if channel.Name != "" {
+			channelName = getStableName("channel", channelID, channel.Name)
+		}
+		if channel.ParentID != "" {
+			if parent, err := bot.State.Channel(channel.ParentID); err == nil && parent != nil && parent.Name != "" {
+				categoryName = getStableName("category", parent.ID, parent.Name)
+			}
+		}
+	}
+	if guild, err := bot.State.Guild(guildID); err == nil && guild != nil && guild.Name != "" {
+		serverName = getStableName("server", guildID, guild.Name)
+	}
+
+	i := strings.Index(destination, "{")
+	root, templated := destination[:i], destination[i:]
+	// Names with nothing left once sanitized fall back to the ID
+	for _, key := range [][]string{
+		{"{serverName}", serverName, guildID},
+		{"{serverID}", guildID, guildID},
+		{"{channelName}", channelName, channelID},
+		{"{channelID}", channelID, channelID},
+		{"{categoryName}", categoryName, ""},
+	} {
+		templated = strings.ReplaceAll(templated, key[0], sanitizeFolderName(key[1], key[2]))
+	}
+	// Empty values like a missing category would otherwise leave an empty folder name
+	separator := "/"
+	if !isRemoteDestination(destination) && strings.Contains(destination, `\`) {
+		separator = `\`
+	}
+	templated = repeatedSeparators.ReplaceAllString(templated, separator)
+	if strings.HasSuffix(root, "/") || strings.HasSuffix(root, `\`) {
+		templated = strings.TrimLeft(templated, `/\`)
+	}
+	return root + templated
+}
+
+// Same as subfolder names. Letters and numbers in any script are kept, characters paths can't have and invisible
+// ones (control codes, zero-width and direction marks, variation selectors) are dropped, and the name is normalized
+// to NFC so names that look the same are the same folder. Emoji are handled as folderNameEmoji says.
+func sanitizePathSegment(name string) string {
+	emoji := strings.ToLower(getConfig().FolderNameEmoji)
+	name = norm.NFC.String(name)
+	if emoji == folderNameEmojiTransliterate {
+		// Flags are pairs of letters
+		name = regionalIndicators.ReplaceAllStringFunc(name, func(flag string) string {
+			var letters strings.Builder
+			for _, r := range flag {
+				letters.WriteRune('A' + r - 0x1F1E6)
+			}
+			return " " + letters.String() + " "
+		})
+	}
+	var sanitized strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			sanitized.WriteRune(' ')
+		case r == '\uFE0E' || r == '\uFE0F' || unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+		case isEmojiRune(r) && emoji == folderNameEmojiStrip:
+		case isEmojiRune(r) && emoji == folderNameEmojiTransliterate:
+			// Spaced apart so names don't run together
+			sanitized.WriteString(" " + transliterateEmoji(r) + " ")
+		default:
+			sanitized.WriteRune(r)
+		}
+	}
+	name = sanitized.String()
+	for _, character := range pathBlacklist {
+		name = strings.ReplaceAll(name, character, "")
+	}
+	// Windows drops trailing dots and spaces
+	return strings.TrimRight(strings.TrimSpace(repeatedWhitespace.ReplaceAllString(name, " ")), ". ")
+}
+
+// A sanitized server, channel or user name for a folder, or fallback (its ID) if nothing's left of it.
+func sanitizeFolderName(name string, fallback string) string {
+	if sanitized := sanitizePathSegment(name); sanitized != "" {
+		return sanitized
+	}
+	return fallback
+}
+
+// Pictographs, flags and the skin tones that go with them. Box drawing and other symbols used as separators in
+// channel names aren't emoji.
+func isEmojiRune(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) || (r >= 0x2B00 && r <= 0x2BFF) ||
+		(r >= 0xE0020 && r <= 0xE007F) || r == 0x20E3 || r == 0x3030 || r == 0x303D || r == 0x3297 || r == 0x3299
+}
+
+// The emoji's name in lowercase, skin tones and the like are left out.
+func transliterateEmoji(r rune) string {
+	if (r >= 0x1F3FB && r <= 0x1F3FF) || (r >= 0xE0020 && r <= 0xE007F) || r == 0x20E3 {
+		return ""
+	}
+	return strings.ToLower(runenames.Name(r))
+}
+
+// The first name seen for a server, channel or category, so renaming one doesn't split its files
+// across folders. With destinationLiveNames the current name is always used.
+func getStableName(kind string, id string, liveName string) string {
+	if getConfig().DestinationLiveNames {
+		return liveName
+	}
+	key := kind + ":" + id
+	if name, exists := destinationNames.Load(key); exists {
+		return name.(string)
+	}
+	destinationNamesMux.Lock()
+	defer destinationNamesMux.Unlock()
+	name := dbGetStableName(key)
+	if name == "" {
+		name = liveName
+		dbSetStableName(key, name)
+	}
+	destinationNames.Store(key, name)
+	return name
+}
+
+//#region NSFW
+
+// Channels in state say for themselves, threads and forum posts aren't kept there so they're looked up,
+// marked NSFW themselves or under an NSFW parent.
+func isChannelNSFW(channelID string) bool {
+	if channel, err := bot.State.Channel(channelID); err == nil && channel != nil {
+		return channel.NSFW
+	}
+	thread := getChannelInfo(channelID)
+	if thread == nil {
+		return false
+	}
+	if thread.NSFW || thread.ParentID == "" {
+		return thread.NSFW
+	}
+	if parent, err := bot.State.Channel(thread.ParentID); err == nil && parent != nil {
+		return parent.NSFW
+	}
+	if parent := getChannelInfo(thread.ParentID); parent != nil {
+		return parent.NSFW
+	}
+	return false
+}
+
+// The channel's nsfwDestinationOverride, or an "nsfw" folder in the destination with separateNSFW.
+func getNSFWDestination(destination string, message *discordgo.Message) string {
+	channelConfig := getChannelConfig(message.ChannelID)
+	if channelConfig.NSFWDestinationOverride != nil && *channelConfig.NSFWDestinationOverride != "" {
+		return resolveDestination(*channelConfig.NSFWDestinationOverride, message)
+	}
+	if !getConfig().SeparateNSFW {
+		return destination
+	}
+	separator := "/"
+	if !isRemoteDestination(destination) && strings.Contains(destination, `\`) {
+		separator = `\`
+	}
+	return strings.TrimRight(destination, `/\`) + separator + "nsfw"
+}
+
+//#endregion
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSanitizeFolderName(t *testing.T) {
+
+	tests := []struct {
+		emoji string
+		name  string
+		want  string
+	}{
+		// Emoji
+		{"", "🌸│fanart", "🌸│fanart"},
+		{"strip", "🌸│fanart", "│fanart"},
+		{"transliterate", "🌸│fanart", "cherry blossom │fanart"},
+		{"", "👍🏽 ok", "👍🏽 ok"},
+		{"strip", "👍🏽 ok", "ok"},
+		{"transliterate", "👍🏽 ok", "thumbs up sign ok"},
+		{"transliterate", "🇰🇷 server", "KR server"},
+		{"strip", "1\uFE0F\u20E3 one", "1 one"},
+		{"strip", "🌸🌸", "123"},
+		// RTL, direction marks are dropped
+		{"", "עברית", "עברית"},
+		{"", "\u200Fعربي\u200F", "عربي"},
+		{"", "\u202Bمرحبا\u202C", "مرحبا"},
+		// CJK
+		{"", "한국어 서버", "한국어 서버"},
+		{"strip", "日本語チャンネル", "日本語チャンネル"},
+		{"", "中文：频道", "中文：频道"},
+		// What paths can't have
+		{"", "fan/art:*?", "fanart"},
+		{"", `a\b<c>d"e|f`, "abcdef"},
+		{"", "  spaced\tout\n ", "spaced out"},
+		{"", "trailing...", "trailing"},
+		{"", "...", "123"},
+		{"", "\u200B\u200D", "123"},
+		{"", "", "123"},
+	}
+	for _, test := range tests {
+		useTestConfig(t, func(settings *configuration) { settings.FolderNameEmoji = test.emoji })
+		if got := sanitizeFolderName(test.name, "123"); got != test.want {
+			t.Errorf("sanitizeFolderName(%q) with folderNameEmoji %q = %q, want %q", test.name, test.emoji, got, test.want)
+		}
+	}
+}
+
+// Names that look the same end up in the same folder.
+func TestSanitizeFolderNameCollisions(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) { settings.FolderNameEmoji = "" })
+
+	groups := [][]string{
+		{"café", "cafe\u0301"},
+		{"한국", "\u1112\u1161\u11AB\u1100\u116E\u11A8"},
+		{"fanart", "fan\u200Bart", "fan\u00ADart", "\uFEFFfanart"},
+		{"🌸│fanart", "🌸\uFE0F│fanart"},
+		{"my server", "my  server", "my\u00A0server", " my server "},
+	}
+	for _, names := range groups {
+		want := sanitizeFolderName(names[0], "123")
+		for _, name := range names[1:] {
+			if got := sanitizeFolderName(name, "123"); got != want {
+				t.Errorf("%q became %q, but %q became %q", name, got, names[0], want)
+			}
+		}
+	}
+}
+
+func TestIsConfiguredDestination(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) {
+		*settings = configuration{
+			BasePath:           "data",
+			Channels:           []configurationChannel{{Destination: "downloads/{serverName}/{channelName}"}},
+			ManualDestinations: map[string]string{"art": "/srv/art", "remote": "s3://bucket/art"},
+		}
+	})
+
+	cases := []struct {
+		destination string
+		want        bool
+	}{
+		{"downloads/{serverName}/{channelName}", true},
+		{"downloads", true},
+		{"downloads/elsewhere", true},
+		{"/srv/art/sketches", true},
+		{"s3://bucket/art/sketches", true},
+		{"scrape/twitter", true},
+		{"", false},
+		{"downloads/../../etc", false},
+		{"/srv/artwork", false},
+		{"/etc", false},
+		{"s3://bucket/other", false},
+		{"s3://bucket/art/../other", false},
+		{"downloads/{channelID}", false},
+	}
+	for _, c := range cases {
+		if got := isConfiguredDestination(c.destination); got != c.want {
+			t.Errorf("isConfiguredDestination(%q) = %v, want %v", c.destination, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+)
+
+var logPrefixDigest = color.HiCyanString("[Digest]")
+
+const (
+	// Channels and domains listed in a digest, the rest are only counted
+	digestTopChannels = 10
+	digestTopDomains  = 5
+)
+
+// Counters for one digest target between flushes, shared by every download worker.
+type digest struct {
+	mutex  sync.Mutex
+	target configurationDigest
+	since  time.Time
+	// Source channel to status to count
+	channels      map[string]map[downloadStatus]int
+	bytes         int64
+	failedDomains map[string]int
+}
+
+var (
+	digests      = make(map[string]*digest)
+	digestsMutex sync.Mutex
+)
+
+// Digests are kept across settings reloads as long as their channel and interval don't change.
+func getDigest(target configurationDigest) *digest {
+	key := target.ChannelID + "|" + target.Interval
+	digestsMutex.Lock()
+	defer digestsMutex.Unlock()
+	if d, exists := digests[key]; exists {
+		return d
+	}
+	d := &digest{target: target}
+	d.reset()
+	digests[key] = d
+
+	interval, _ := time.ParseDuration(target.Interval)
+	go func() {
+		for range time.Tick(interval) {
+			d.flush()
+		}
+	}()
+	return d
+}
+
+func (d *digest) reset() {
+	d.since = time.Now()
+	d.channels = make(map[string]map[downloadStatus]int)
+	d.bytes = 0
+	d.failedDomains = make(map[string]int)
+}
+
+func (d *digest) add(channelID string, status downloadStatusStruct, inputURL string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.channels[channelID] == nil {
+		d.channels[channelID] = make(map[downloadStatus]int)
+	}
+	d.channels[channelID][status.Status]++
+	if status.Status == downloadSuccess && status.Size > 0 {
+		d.bytes += status.Size
+	}
+	if status.Status >= downloadFailed {
+		domain := "unknown"
+		if u, err := url.Parse(inputURL); err == nil && u.Hostname() != "" {
+			domain = u.Hostname()
+		}
+		d.failedDomains[domain]++
+	}
+}
+
+// Sends what's been counted since the last flush, if anything.
+func (d *digest) flush() {
+	d.mutex.Lock()
+	if len(d.channels) == 0 {
+		d.mutex.Unlock()
+		return
+	}
+	channels, bytes, failedDomains, since := d.channels, d.bytes, d.failedDomains, d.since
+	d.reset()
+	d.mutex.Unlock()
+
+	type channelTally struct {
+		id                        string
+		saved, duplicates, failed int
+	}
+	var tallies []channelTally
+	var saved, duplicates, skipped, failed, limited int
+	for channelID, statuses := range channels {
+		tally := channelTally{id: channelID}
+		for status, count := range statuses {
+			switch {
+			case status == downloadSuccess:
+				tally.saved += count
+			case status == downloadSkippedDuplicate || status == downloadSkippedDetectedDuplicate:
+				tally.duplicates += count
+			case status == downloadSkippedLimitReached:
+				// Counted once for each message or album cut short, not for every file
+				limited += count
+			case status >= downloadFailed:
+				tally.failed += count
+			default:
+				skipped += count
+			}
+		}
+		saved += tally.saved
+		duplicates += tally.duplicates
+		failed += tally.failed
+		tallies = append(tallies, tally)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		return tallies[i].saved+tallies[i].failed > tallies[j].saved+tallies[j].failed
+	})
+
+	content := fmt.Sprintf("Downloaded **%s** file%s (%s)", formatNumber(int64(saved)), pluralS(saved), formatBytes(bytes))
+	if duplicates > 0 || skipped > 0 {
+		content += fmt.Sprintf("\nSkipped **%s** duplicate%s", formatNumber(int64(duplicates)), pluralS(duplicates))
+		if skipped > 0 {
+			content += fmt.Sprintf(" and **%s** other%s", formatNumber(int64(skipped)), pluralS(skipped))
+		}
+	}
+	if limited > 0 {
+		content += fmt.Sprintf("\nLink and album limits were reached **%s** time%s", formatNumber(int64(limited)), pluralS(limited))
+	}
+	if failed > 0 {
+		content += fmt.Sprintf("\n**%s** failure%s", formatNumber(int64(failed)), pluralS(failed))
+		var errorChannels []string
+		for _, adminChannel := range getConfig().AdminChannels {
+			if *adminChannel.LogErrors {
+				errorChannels = append(errorChannels, "<#"+adminChannel.ChannelID+">")
+			}
+		}
+		if len(errorChannels) > 0 {
+			content += ", see " + strings.Join(errorChannels, " ")
+		}
+	}
+
+	if len(tallies) > 1 {
+		content += "\n\n**Channels:**"
+		for i, tally := range tallies {
+			if i == digestTopChannels {
+				content += fmt.Sprintf("\n_...and %d more_", len(tallies)-i)
+				break
+			}
+			content += fmt.Sprintf("\n<#%s> — %s saved", tally.id, formatNumber(int64(tally.saved)))
+			if tally.duplicates > 0 {
+				content += fmt.Sprintf(", %s duplicate%s", formatNumber(int64(tally.duplicates)), pluralS(tally.duplicates))
+			}
+			if tally.failed > 0 {
+				content += fmt.Sprintf(", %s failed", formatNumber(int64(tally.failed)))
+			}
+		}
+	}
+
+	if len(failedDomains) > 0 {
+		var domains []string
+		for domain := range failedDomains {
+			domains = append(domains, domain)
+		}
+		sort.Slice(domains, func(i, j int) bool {
+			if failedDomains[domains[i]] == failedDomains[domains[j]] {
+				return domains[i] < domains[j]
+			}
+			return failedDomains[domains[i]] > failedDomains[domains[j]]
+		})
+		content += "\n\n**Top failing domains:**"
+		for i, domain := range domains {
+			if i == digestTopDomains {
+				break
+			}
+			content += fmt.Sprintf("\n`%s` — %s", domain, formatNumber(int64(failedDomains[domain])))
+		}
+	}
+
+	title := fmt.Sprintf("Digest — Last %s", durafmt.ParseShort(time.Since(since)))
+	if bot == nil || !hasPerms(d.target.ChannelID, discordgo.PermissionSendMessages) {
+		log.Println(logPrefixDigest, color.HiRedString(fmtBotSendPerm, d.target.ChannelID))
+		return
+	}
+	if _, err := bot.ChannelMessageSendEmbed(d.target.ChannelID, buildEmbed(d.target.ChannelID, title, content)); err != nil {
+		log.Println(logPrefixDigest, color.HiRedString("Failed to send digest to %s:\t%s", d.target.ChannelID, err))
+	}
+}
+
+// Global digest plus the channel's own, if set.
+func getDigestTargets(channelID string) []configurationDigest {
+	settings := getConfig()
+	var targets []configurationDigest
+	if settings.Digest != nil {
+		targets = append(targets, *settings.Digest)
+	}
+	if isChannelRegistered(channelID) {
+		channelConfig := getChannelConfig(channelID)
+		if channelConfig.Digest != nil {
+			targets = append(targets, *channelConfig.Digest)
+		}
+	}
+	return targets
+}
+
+// Reactions and failure messages are left out for channels whose digest replaces them.
+func digestReplacesMessages(channelID string) bool {
+	for _, target := range getDigestTargets(channelID) {
+		if target.ReplaceMessages {
+			return true
+		}
+	}
+	return false
+}
+
+func recordDigest(download downloadRequestStruct, status downloadStatusStruct) {
+	// Links already downloaded would be counted again every time they're posted again
+	if status.Status == downloadIgnored || status.Status == downloadSkippedAlreadyRecorded {
+		return
+	}
+	recordChannelDigest(download.Message.ChannelID, status, download.InputURL)
+}
+
+func recordChannelDigest(channelID string, status downloadStatusStruct, inputURL string) {
+	targets := getDigestTargets(channelID)
+	for _, target := range targets {
+		getDigest(target).add(channelID, status, inputURL)
+	}
+}
+
+// Flushes the digests covering a channel, or all of them if channelID is empty.
+func flushDigests(channelID string) {
+	var flushing []*digest
+	if channelID == "" {
+		digestsMutex.Lock()
+		for _, d := range digests {
+			flushing = append(flushing, d)
+		}
+		digestsMutex.Unlock()
+	} else {
+		targets := getDigestTargets(channelID)
+		for _, target := range targets {
+			flushing = append(flushing, getDigest(target))
+		}
+	}
+	for _, d := range flushing {
+		d.flush()
+	}
+}
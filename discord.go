@@ -40,8 +40,12 @@ func discordSnowflakeToTimestamp(snowflake string, format string) string {
 }
 
 func getAllChannels() []string {
+	return getConfig().getAllChannels()
+}
+
+func (settings *configuration) getAllChannels() []string {
 	var channels []string
-	if config.All != nil { // ALL MODE
+	if settings.All != nil { // ALL MODE
 		for _, guild := range bot.State.Guilds {
 			for _, channel := range guild.Channels {
 				if hasPerms(channel.ID, discordgo.PermissionReadMessages) && hasPerms(channel.ID, discordgo.PermissionReadMessageHistory) {
@@ -51,7 +55,7 @@ func getAllChannels() []string {
 		}
 	} else { // STANDARD MODE
 		// Compile all config channels
-		for _, channel := range config.Channels {
+		for _, channel := range settings.Channels {
 			if channel.ChannelIDs != nil {
 				for _, subchannel := range *channel.ChannelIDs {
 					channels = append(channels, subchannel)
@@ -61,7 +65,7 @@ func getAllChannels() []string {
 			}
 		}
 		// Compile all channels sourced from config servers
-		for _, server := range config.Servers {
+		for _, server := range settings.Servers {
 			if server.ServerIDs != nil {
 				for _, subserver := range *server.ServerIDs {
 					guild, err := bot.State.Guild(subserver)
@@ -91,9 +95,10 @@ func getAllChannels() []string {
 //#region Presence
 
 func presenceKeyReplacement(input string) string {
+	settings := getConfig()
 	//TODO: Case-insensitive key replacement. -- If no streamlined way to do it, convert to lower to find substring location but replace normally
 	if strings.Contains(input, "{{") && strings.Contains(input, "}}") {
-		countInt := int64(dbDownloadCount()) + *config.InflateCount
+		countInt := presenceDownloadCount() + *settings.InflateCount
 		timeNow := time.Now()
 		keys := [][]string{
 			{"{{dgVersion}}", discordgo.VERSION},
@@ -105,8 +110,8 @@ func presenceKeyReplacement(input string) string {
 			{"{{numServers}}", fmt.Sprint(len(bot.State.Guilds))},
 			{"{{numBoundChannels}}", fmt.Sprint(getBoundChannelsCount())},
 			{"{{numBoundServers}}", fmt.Sprint(getBoundServersCount())},
-			{"{{numAdminChannels}}", fmt.Sprint(len(config.AdminChannels))},
-			{"{{numAdmins}}", fmt.Sprint(len(config.Admins))},
+			{"{{numAdminChannels}}", fmt.Sprint(len(settings.AdminChannels))},
+			{"{{numAdmins}}", fmt.Sprint(len(settings.Admins))},
 			{"{{timeSavedShort}}", timeLastUpdated.Format("3:04pm")},
 			{"{{timeSavedShortTZ}}", timeLastUpdated.Format("3:04pm MST")},
 			{"{{timeSavedMid}}", timeLastUpdated.Format("3:04pm MST 1/2/2006")},
@@ -135,9 +140,13 @@ func presenceKeyReplacement(input string) string {
 }
 
 func updateDiscordPresence() {
-	if config.PresenceEnabled {
+	settings := getConfig()
+	if !canUpdatePresence() {
+		return
+	}
+	if settings.PresenceEnabled {
 		// Vars
-		countInt := int64(dbDownloadCount()) + *config.InflateCount
+		countInt := presenceDownloadCount() + *settings.InflateCount
 		count := formatNumber(countInt)
 		countShort := formatNumberShort(countInt)
 		timeShort := timeLastUpdated.Format("3:04pm")
@@ -149,40 +158,46 @@ func updateDiscordPresence() {
 		statusState := fmt.Sprintf("%s files total", count)
 
 		// Overwrite Presence
-		if config.PresenceOverwrite != nil {
-			status = *config.PresenceOverwrite
+		if settings.PresenceOverwrite != nil {
+			status = *settings.PresenceOverwrite
 			if status != "" {
 				status = presenceKeyReplacement(status)
 			}
 		}
 		// Overwrite Details
-		if config.PresenceOverwriteDetails != nil {
-			statusDetails = *config.PresenceOverwriteDetails
+		if settings.PresenceOverwriteDetails != nil {
+			statusDetails = *settings.PresenceOverwriteDetails
 			if statusDetails != "" {
 				statusDetails = presenceKeyReplacement(statusDetails)
 			}
 		}
 		// Overwrite State
-		if config.PresenceOverwriteState != nil {
-			statusState = *config.PresenceOverwriteState
+		if settings.PresenceOverwriteState != nil {
+			statusState = *settings.PresenceOverwriteState
 			if statusState != "" {
 				statusState = presenceKeyReplacement(statusState)
 			}
 		}
 
+		// Rotation & History Progress
+		if rotation := presenceRotationText(); rotation != "" {
+			status = rotation
+		}
+
 		// Update
+		activityType, onlineStatus := presenceActivity()
 		bot.UpdateStatusComplex(discordgo.UpdateStatusData{
 			Game: &discordgo.Game{
 				Name:    status,
-				Type:    config.PresenceType,
+				Type:    activityType,
 				Details: statusDetails, // Only visible if real user
 				State:   statusState,   // Only visible if real user
 			},
-			Status: config.PresenceStatus,
+			Status: onlineStatus,
 		})
-	} else if config.PresenceStatus != string(discordgo.StatusOnline) {
+	} else if _, onlineStatus := presenceActivity(); onlineStatus != string(discordgo.StatusOnline) {
 		bot.UpdateStatusComplex(discordgo.UpdateStatusData{
-			Status: config.PresenceStatus,
+			Status: onlineStatus,
 		})
 	}
 }
@@ -192,14 +207,15 @@ func updateDiscordPresence() {
 //#region Embeds
 
 func getEmbedColor(channelID string) int {
+	settings := getConfig()
 	var err error
 	var color *string
 	var channelInfo *discordgo.Channel
 
 	// Assign Defined Color
-	if config.EmbedColor != nil {
-		if *config.EmbedColor != "" {
-			color = config.EmbedColor
+	if settings.EmbedColor != nil {
+		if *settings.EmbedColor != "" {
+			color = settings.EmbedColor
 		}
 	}
 	// Overwrite with Defined Color for Channel
@@ -279,19 +295,44 @@ func buildEmbed(channelID string, title string, description string) *discordgo.M
 // Shortcut function for quickly replying a styled embed with Title & Description
 func replyEmbed(m *discordgo.Message, title string, description string) (*discordgo.Message, error) {
 	if m != nil {
+		// Slash Command
+		if ci := getCommandInteraction(m.ID); ci != nil {
+			reply, err := ci.reply(buildEmbed(m.ChannelID, title, description))
+			if err == nil {
+				return reply, nil
+			}
+			log.Println(logPrefixSlash, color.HiRedString("Failed to reply to slash command, sending to channel instead:\t%s", err))
+		}
 		if hasPerms(m.ChannelID, discordgo.PermissionSendMessages) {
-			return bot.ChannelMessageSendComplex(m.ChannelID,
-				&discordgo.MessageSend{
-					Content: m.Author.Mention(),
-					Embed:   buildEmbed(m.ChannelID, title, description),
-				},
-			)
+			return bot.ChannelMessageSendComplex(m.ChannelID, embedMessage(m.ChannelID, m.Author.Mention(), title, description))
 		}
 		log.Println(color.HiRedString(fmtBotSendPerm, m.ChannelID))
 	}
 	return nil, nil
 }
 
+// Edits a message sent by replyEmbed, slash command replies can only be edited through their interaction.
+func editEmbed(message *discordgo.Message, title string, description string) (*discordgo.Message, error) {
+	if ci := getCommandInteraction(message.ID); ci != nil {
+		return ci.edit(message.ID, buildEmbed(message.ChannelID, title, description))
+	}
+	if !canSendEmbeds() {
+		return bot.ChannelMessageEdit(message.ChannelID, message.ID, embedText("", title, description))
+	}
+	return bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      message.ID,
+		Channel: message.ChannelID,
+		Embed:   buildEmbed(message.ChannelID, title, description),
+	})
+}
+
+func deleteReply(message *discordgo.Message) error {
+	if ci := getCommandInteraction(message.ID); ci != nil {
+		return ci.delete(message.ID)
+	}
+	return bot.ChannelMessageDelete(message.ChannelID, message.ID)
+}
+
 type logStatusType int
 
 const (
@@ -313,7 +354,8 @@ func logStatusLabel(status logStatusType) string {
 }
 
 func logStatusMessage(status logStatusType) {
-	for _, adminChannel := range config.AdminChannels {
+	settings := getConfig()
+	for _, adminChannel := range settings.AdminChannels {
 		if *adminChannel.LogStatus {
 			var message string
 			var label string
@@ -324,7 +366,7 @@ func logStatusMessage(status logStatusType) {
 				message += fmt.Sprintf("\n• Uptime is %s", uptime())
 				message += fmt.Sprintf("\n• %s total downloads", formatNumber(int64(dbDownloadCount())))
 				message += fmt.Sprintf("\n• Bound to %d channel%s and %d server%s", getBoundChannelsCount(), pluralS(getBoundChannelsCount()), getBoundServersCount(), pluralS(getBoundServersCount()))
-				if config.All != nil {
+				if settings.All != nil {
 					message += "\n• **ALL MODE ENABLED -** Bot will use all available channels"
 				}
 				allChannels := getAllChannels()
@@ -343,7 +385,7 @@ func logStatusMessage(status logStatusType) {
 				message += fmt.Sprintf("\n• Bound to %d channel%s and %d server%s", getBoundChannelsCount(), pluralS(getBoundChannelsCount()), getBoundServersCount(), pluralS(getBoundServersCount()))
 			}
 			// Send
-			if config.DebugOutput {
+			if settings.DebugOutput {
 				log.Println(logPrefixDebug, color.HiCyanString("Sending log for %s to admin channel %s", label, adminChannel.ChannelID))
 			}
 			if hasPerms(adminChannel.ChannelID, discordgo.PermissionEmbedLinks) {
@@ -358,34 +400,98 @@ func logStatusMessage(status logStatusType) {
 }
 
 func logErrorMessage(err string) {
-	for _, adminChannel := range config.AdminChannels {
-		if *adminChannel.LogErrors {
-			// Send
-			if hasPerms(adminChannel.ChannelID, discordgo.PermissionEmbedLinks) { // not confident this is the right permission
-				if config.DebugOutput {
-					log.Println(logPrefixDebug, color.HiCyanString("Sending embed log for error to %s", adminChannel.ChannelID))
-				}
-				bot.ChannelMessageSendEmbed(adminChannel.ChannelID, buildEmbed(adminChannel.ChannelID, "Log — Error", err))
-			} else if hasPerms(adminChannel.ChannelID, discordgo.PermissionSendMessages) {
-				if config.DebugOutput {
-					log.Println(logPrefixDebug, color.HiCyanString("Sending message log for error to %s", adminChannel.ChannelID))
-				}
-				bot.ChannelMessageSend(adminChannel.ChannelID, err)
-			} else {
-				log.Println(logPrefixDebug, color.HiRedString("Perms checks failed for sending error log to %s", adminChannel.ChannelID))
+	sendErrorLog(errorLogWarnings, "Log — Error", err)
+}
+
+//#endregion
+
+//#region Permissions
+
+// Application owner (or team members) fetched at startup, always treated as admins.
+var (
+	botOwners        []string
+	botApplicationID string
+)
+
+func loadBotOwners() {
+	if user == nil || !canUseBotOnlyAPIs() {
+		return
+	}
+	app, err := bot.Application("@me")
+	if err != nil || app == nil {
+		log.Println(logPrefixDiscord, color.HiRedString("Failed to fetch bot application owner:\t%s", err))
+		return
+	}
+	botApplicationID = app.ID
+	botOwners = nil
+	if app.Team != nil {
+		for _, member := range app.Team.Members {
+			if member.User != nil {
+				botOwners = append(botOwners, member.User.ID)
 			}
 		}
+	} else if app.Owner != nil {
+		botOwners = append(botOwners, app.Owner.ID)
+	}
+	if getConfig().DebugOutput {
+		log.Println(logPrefixDebug, logPrefixDiscord, color.YellowString("Bot owner(s): %s", strings.Join(botOwners, ", ")))
 	}
 }
 
-//#endregion
+// Admin roles from the server entries covering the guild, plus the channel's own.
+func getAdminRoles(guildID string, channelID string) []string {
+	var roles []string
+	for _, item := range getConfig().Servers {
+		if item.AdminRoles == nil {
+			continue
+		}
+		if item.ServerID == guildID || (item.ServerIDs != nil && stringInSlice(guildID, *item.ServerIDs)) {
+			roles = append(roles, *item.AdminRoles...)
+		}
+	}
+	if isChannelRegistered(channelID) {
+		channelConfig := getChannelConfig(channelID)
+		if channelConfig.AdminRoles != nil {
+			roles = append(roles, *channelConfig.AdminRoles...)
+		}
+	}
+	return roles
+}
 
-//#region Permissions
+func hasAdminRole(m *discordgo.Message) bool {
+	if m.GuildID == "" {
+		return false
+	}
+	roles := getAdminRoles(m.GuildID, m.ChannelID)
+	if len(roles) == 0 {
+		return false
+	}
+	member := m.Member
+	if member == nil {
+		member, _ = bot.State.Member(m.GuildID, m.Author.ID)
+	}
+	if member == nil {
+		member, _ = bot.GuildMember(m.GuildID, m.Author.ID)
+	}
+	if member == nil {
+		return false
+	}
+	for _, role := range member.Roles {
+		if stringInSlice(role, roles) {
+			return true
+		}
+	}
+	return false
+}
 
-// Checks if message author is a specified bot admin.
-func isBotAdmin(m *discordgo.Message) bool {
-	// No Admins or Admin Channels
-	if len(config.Admins) == 0 && len(config.AdminChannels) == 0 {
+// Checks if message author is the bot owner, a specified bot admin, or has one of the server's admin roles.
+func isAdmin(m *discordgo.Message) bool {
+	settings := getConfig()
+	if m == nil || m.Author == nil {
+		return false
+	}
+	// Nobody to check against, only when the owner couldn't be fetched
+	if len(botOwners) == 0 && len(settings.Admins) == 0 && len(settings.AdminChannels) == 0 {
 		return true
 	}
 	// configurationAdminChannel.UnlockCommands Bypass
@@ -396,41 +502,64 @@ func isBotAdmin(m *discordgo.Message) bool {
 		}
 	}
 
-	return m.Author.ID == user.ID || stringInSlice(m.Author.ID, config.Admins)
+	if m.Author.ID == user.ID || stringInSlice(m.Author.ID, botOwners) || stringInSlice(m.Author.ID, settings.Admins) {
+		return true
+	}
+	return hasAdminRole(m)
+}
+
+// Brief reply for commands the author isn't allowed to use, removed after a few seconds to not clutter the channel.
+// Falls back to a reaction where the bot can't send messages.
+func replyUnauthorized(m *discordgo.Message, title string, content string) {
+	if hasPerms(m.ChannelID, discordgo.PermissionSendMessages) {
+		reply, err := replyEmbed(m, title, content)
+		if err != nil {
+			log.Println(color.HiRedString("Failed to send command embed message (requested by %s)...\t%s", getUserIdentifier(*m.Author), err))
+		} else if reply != nil {
+			time.AfterFunc(15*time.Second, func() {
+				deleteReply(reply)
+			})
+		}
+	} else if canReact() && hasPerms(m.ChannelID, discordgo.PermissionAddReactions) {
+		queueReaction(m.ChannelID, m.ID, "⛔", false)
+	} else {
+		log.Println(color.HiRedString(fmtBotSendPerm, m.ChannelID))
+	}
 }
 
 // Checks if message author is a specified bot admin OR is server admin OR has message management perms in channel
 func isLocalAdmin(m *discordgo.Message) bool {
+	settings := getConfig()
 	if m == nil {
-		if config.DebugOutput {
+		if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.YellowString("isLocalAdmin check failed due to empty message"))
 		}
 		return true
 	}
 	sourceChannel, err := bot.State.Channel(m.ChannelID)
 	if err != nil || sourceChannel == nil {
-		if config.DebugOutput {
+		if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.YellowString("isLocalAdmin check failed due to an error or received empty channel info for message:\t%s", err))
 		}
-		return true
+		return isAdmin(m)
 	} else if sourceChannel.Name == "" || sourceChannel.GuildID == "" {
-		if config.DebugOutput {
+		if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.YellowString("isLocalAdmin check failed due to incomplete channel info"))
 		}
-		return true
+		return isAdmin(m)
 	}
 
 	guild, _ := bot.State.Guild(m.GuildID)
 	localPerms, err := bot.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
 	if err != nil {
-		if config.DebugOutput {
+		if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.YellowString("isLocalAdmin check failed due to error when checking permissions:\t%s", err))
 		}
-		return true
+		return isAdmin(m)
 	}
 
 	botSelf := m.Author.ID == user.ID
-	botAdmin := stringInSlice(m.Author.ID, config.Admins)
+	botAdmin := isAdmin(m)
 	guildOwner := m.Author.ID == guild.OwnerID
 	guildAdmin := localPerms&discordgo.PermissionAdministrator > 0
 	localManageMessages := localPerms&discordgo.PermissionManageMessages > 0
@@ -439,7 +568,7 @@ func isLocalAdmin(m *discordgo.Message) bool {
 }
 
 func hasPerms(channelID string, permission int) bool {
-	if !config.CheckPermissions {
+	if !getConfig().CheckPermissions {
 		return true
 	}
 
@@ -541,8 +670,13 @@ func messageToLower(message *discordgo.Message) *discordgo.Message {
 }
 
 func fixMessage(m *discordgo.Message) *discordgo.Message {
+	settings := getConfig()
 	// If message content is empty (likely due to userbot/selfbot)
 	ubIssue := "Message is corrupted due to endpoint restriction"
+	// Forwards are empty too, getRawLinks reads what they forward
+	if isPossibleForward(m) {
+		return m
+	}
 	if m.Content == "" && len(m.Attachments) == 0 && len(m.Embeds) == 0 {
 		// Get message history
 		mCache, err := bot.ChannelMessages(m.ChannelID, 20, "", "", "")
@@ -559,20 +693,20 @@ func fixMessage(m *discordgo.Message) *discordgo.Message {
 							m.GuildID = guildID
 						}
 						// Parse commands
-						dgr.FindAndExecute(bot, strings.ToLower(config.CommandPrefix), bot.State.User.ID, messageToLower(m))
+						dgr.FindAndExecute(bot, strings.ToLower(settings.CommandPrefix), bot.State.User.ID, messageToLower(m))
 
 						break
 					}
 				}
-			} else if config.DebugOutput {
+			} else if settings.DebugOutput {
 				log.Println(logPrefixDebug, color.RedString("%s, and an attempt to get channel messages found nothing...", ubIssue))
 			}
-		} else if config.DebugOutput {
+		} else if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.HiRedString("%s, and an attempt to get channel messages encountered an error:\t%s", ubIssue, err))
 		}
 	}
 	if m.Content == "" && len(m.Attachments) == 0 && len(m.Embeds) == 0 {
-		if config.DebugOutput {
+		if settings.DebugOutput {
 			log.Println(logPrefixDebug, color.YellowString("%s, and attempts to fix seem to have failed...", ubIssue))
 		}
 	}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var logPrefixDiskSpace = color.HiYellowString("[Disk Space]")
+
+// Free space is read at most this often per destination, downloads in between use the last reading
+const diskSpaceCheckInterval = time.Minute
+
+type diskSpaceReading struct {
+	free    int64
+	checked time.Time
+	low     bool
+}
+
+var (
+	diskSpaceReadings = make(map[string]*diskSpaceReading)
+	diskSpaceMutex    sync.Mutex
+)
+
+// Returns true if a local destination is under minimumFreeSpace, along with its free space.
+// Going under or back over it is logged and sent to the error log once.
+func isDiskSpaceLow(destination string) (bool, int64) {
+	settings := getConfig()
+	minimum, err := parseByteSize(settings.MinimumFreeSpace)
+	if settings.MinimumFreeSpace == "" || err != nil || minimum <= 0 || isRemoteDestination(destination) {
+		return false, -1
+	}
+	destination = filepath.Clean(destination)
+
+	diskSpaceMutex.Lock()
+	reading, exists := diskSpaceReadings[destination]
+	if !exists {
+		reading = &diskSpaceReading{free: -1}
+		diskSpaceReadings[destination] = reading
+	}
+	if time.Since(reading.checked) < diskSpaceCheckInterval {
+		defer diskSpaceMutex.Unlock()
+		return reading.low, reading.free
+	}
+	reading.checked = time.Now()
+	diskSpaceMutex.Unlock()
+
+	free, err := getDiskFreeSpace(getExistingParent(destination))
+	if err != nil {
+		if settings.DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Couldn't read free space for \"%s\":\t%s", destination, err))
+		}
+		diskSpaceMutex.Lock()
+		defer diskSpaceMutex.Unlock()
+		return reading.low, reading.free
+	}
+
+	diskSpaceMutex.Lock()
+	wasLow := reading.low
+	reading.free, reading.low = free, free < minimum
+	low := reading.low
+	diskSpaceMutex.Unlock()
+
+	if low && !wasLow {
+		action := "skipped"
+		if settings.PauseOnLowSpace {
+			action = "paused"
+		}
+		content := fmt.Sprintf("Only %s free for `%s`, under the minimum of %s. Downloads to it are %s until space is freed.",
+			formatBytes(free), destination, formatBytes(minimum), action)
+		log.Println(logPrefixDiskSpace, color.HiRedString("Only %s free for \"%s\", downloads to it are %s", formatBytes(free), destination, action))
+		sendErrorLog(errorLogWarnings, "Log — Low Disk Space", content)
+	} else if wasLow && !low {
+		log.Println(logPrefixDiskSpace, color.HiGreenString("%s free for \"%s\" again, downloads to it resumed", formatBytes(free), destination))
+		sendErrorLog(errorLogWarnings, "Log — Disk Space Recovered", fmt.Sprintf("%s free for `%s` again, downloads to it resumed.", formatBytes(free), destination))
+	}
+	return low, free
+}
+
+// Holds a download until its destination is back over minimumFreeSpace, for pauseOnLowSpace.
+func waitForDiskSpace(destination string) {
+	for {
+		if low, _ := isDiskSpaceLow(destination); !low {
+			return
+		}
+		time.Sleep(diskSpaceCheckInterval)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+import "errors"
+
+func getDiskFreeSpace(path string) (int64, error) {
+	return 0, errors.New("free space isn't available on this platform")
+}
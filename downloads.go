@@ -2,8 +2,13 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -11,10 +16,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -24,12 +32,73 @@ import (
 )
 
 type downloadItem struct {
+	// Database row, set once it's inserted or read
+	ID          int
 	URL         string
 	Time        time.Time
 	Destination string
-	Filename    string
-	ChannelID   string
-	UserID      string
+	// Destination folder it was saved under, largeFileDestination's for files over largeFileThreshold
+	DestinationRoot string
+	Filename        string
+	ChannelID       string
+	UserID          string
+	MessageID       string
+	// Server of the message it came from, empty for DMs and rows from older versions
+	GuildID string
+	// Text of the message it came from
+	Content string
+	// Set if the file was converted before saving, e.g. ".webp"
+	OriginalExtension string
+	// SHA-256 of the saved file
+	Hash string
+	// Set if the file is a link to (or copy of) a duplicate saved earlier, the original's Destination
+	LinkedTo string
+	// Where URL redirected to, empty if it didn't
+	FinalURL string
+	// Bytes written, 0 for linked duplicates and rows from older versions
+	Size int64
+	// Bytes received, how long requesting and reading them took and the host they came from, after redirects.
+	// Also set for duplicates, 0 and empty for rows from older versions
+	FileSize           int64
+	DownloadDurationMs int64
+	Domain             string
+	// When the message it came from was deleted, zero if it wasn't or deletions aren't tracked
+	SourceDeleted time.Time
+	// When the integrity check found its file gone or empty and marked it, zero otherwise
+	Missing time.Time
+	// When retention deleted its file, zero otherwise
+	Pruned time.Time
+	// Alt text of the attachment and title of the embed it came from, empty if there wasn't any. Alt text is only
+	// looked up for channels whose filenameFormat uses it
+	AltText    string
+	EmbedTitle string
+	// Who made the post a site handler found it in, its ID and title, empty if the handler didn't know
+	Artist    string
+	PostID    string
+	PostTitle string
+	// Names of the forum tags on the post it came from
+	Tags []string
+	// Reactions on the message by emoji name and in total, counted again a day after downloading
+	Reactions     map[string]int
+	ReactionCount int
+	Recounted     time.Time
+	// From an NSFW channel, or a post in one
+	IsNSFW bool
+	// A GIF, APNG or WebP with more than one frame
+	IsAnimated bool
+	// Where the file was actually saved from if not URL, e.g. Discord's proxied copy of a dead embed
+	DownloadedFrom string
+	// With storageMode "cas", the blob Destination links to
+	BlobPath string
+	// With compressOnSave, the size before and after compressing, 0 if it wasn't compressed
+	OriginalSize   int64
+	CompressedSize int64
+	// With generateThumbnails, the thumbnail made for it
+	ThumbnailPath string
+	// With skipTextHeavyImages, how much of the image the words OCR found cover and how sure it was of them, out of
+	// 1. Only images that were checked and kept have them
+	TextCoverage   float64
+	TextConfidence float64
 }
 
 type downloadStatus int
@@ -45,6 +114,17 @@ const (
 	downloadSkippedUnpermittedType
 	downloadSkippedUnpermittedExtension
 	downloadSkippedDetectedDuplicate
+	downloadSkippedUnpermittedSize
+	downloadSkippedLowDiskSpace
+	downloadSkippedQuotaExceeded
+	downloadSkippedPlaceholder
+	downloadSkippedFailedBefore
+	downloadSkippedUnpermittedDimensions
+	downloadSkippedWrongContent
+	downloadSkippedLimitReached
+	downloadSkippedDeferred
+	downloadSkippedAlreadyRecorded
+	downloadSkippedTextImage
 
 	downloadFailed
 	downloadFailed404
@@ -57,11 +137,18 @@ const (
 	downloadFailedCreatingSubfolder
 	downloadFailedWritingFile
 	downloadFailedWritingDatabase
+	downloadFailedIncompleteBody
+	downloadFailedStorageUnavailable
+	downloadFailed403
+	downloadFailed410
+	downloadFailedBotChallenge
 )
 
 type downloadStatusStruct struct {
 	Status downloadStatus
 	Error  error
+	Size   int64         // bytes saved, or Content-Length for dry runs, -1 if unknown
+	Saved  *downloadItem // what was recorded to the database, set on success
 }
 
 func mDownloadStatus(status downloadStatus, _error ...error) downloadStatusStruct {
@@ -97,6 +184,28 @@ func getDownloadStatusString(status downloadStatus) string {
 		return "Download Skipped - Unpermitted File Extension"
 	case downloadSkippedDetectedDuplicate:
 		return "Download Skipped - Detected Duplicate"
+	case downloadSkippedUnpermittedSize:
+		return "Download Skipped - Unpermitted File Size"
+	case downloadSkippedLowDiskSpace:
+		return "Download Skipped - Low Disk Space"
+	case downloadSkippedQuotaExceeded:
+		return "Download Skipped - Quota Exceeded"
+	case downloadSkippedPlaceholder:
+		return "Download Skipped - Placeholder Image"
+	case downloadSkippedFailedBefore:
+		return "Download Skipped - Failed Before"
+	case downloadSkippedUnpermittedDimensions:
+		return "Download Skipped - Unpermitted Dimensions"
+	case downloadSkippedWrongContent:
+		return "Download Skipped - Wrong Content"
+	case downloadSkippedLimitReached:
+		return "Download Skipped - Limit Reached"
+	case downloadSkippedDeferred:
+		return "Download Deferred - Outside Schedule"
+	case downloadSkippedAlreadyRecorded:
+		return "Download Skipped - Already Downloaded"
+	case downloadSkippedTextImage:
+		return "Download Skipped - Text Heavy Image"
 	//
 	case downloadFailed:
 		return "Download Failed"
@@ -120,6 +229,16 @@ func getDownloadStatusString(status downloadStatus) string {
 		return "Download Failed - Error Writing File"
 	case downloadFailedWritingDatabase:
 		return "Download Failed - Error Writing to Database"
+	case downloadFailedIncompleteBody:
+		return "Download Failed - Incomplete or Corrupt File"
+	case downloadFailedStorageUnavailable:
+		return "Download Failed - Destination Unreachable"
+	case downloadFailed403:
+		return "Download Failed - 403 FORBIDDEN"
+	case downloadFailed410:
+		return "Download Failed - 410 GONE"
+	case downloadFailedBotChallenge:
+		return "Download Failed - Bot Challenge"
 	}
 	return "Unknown Error"
 }
@@ -130,42 +249,111 @@ func trimDuplicateLinks(fileItems []*fileItem) []*fileItem {
 	seen := map[string]bool{}
 
 	for _, item := range fileItems {
-		if seen[item.Link] {
+		if seen[canonicalizeURL(item.Link)] {
 			continue
 		}
 
-		seen[item.Link] = true
+		seen[canonicalizeURL(item.Link)] = true
 		result = append(result, item)
 	}
 
 	return result
 }
 
-func getRawLinks(m *discordgo.Message) []*fileItem {
+// Attachments sharing a filename within the message, like pasted images all called image.png, get their place in
+// the message ("index", image_1.png) or their attachment ID ("id", image_<id>.png) added to it. Done before anything
+// looks for the file on disk, so which one ends up with which name doesn't depend on the order they finish in.
+func nameSameNamedAttachments(attachments []*fileItem, naming string) {
+	counts := make(map[string]int)
+	for _, attachment := range attachments {
+		counts[strings.ToLower(attachment.Filename)]++
+	}
+	for _, attachment := range attachments {
+		if attachment.Filename == "" || counts[strings.ToLower(attachment.Filename)] < 2 {
+			continue
+		}
+		suffix := strconv.Itoa(attachment.AttachmentIndex)
+		if naming == "id" && attachment.AttachmentID != "" {
+			suffix = attachment.AttachmentID
+		}
+		extension := filepath.Ext(attachment.Filename)
+		attachment.Filename = strings.TrimSuffix(attachment.Filename, extension) + "_" + suffix + extension
+	}
+}
+
+// Links in the message, then in messages it forwards or (with includeReplyReferences) replies to, at their own time.
+// Links already found earlier in the message are left out.
+func getRawLinks(m *discordgo.Message, settings *configuration) []*fileItem {
+	links := getMessageRawLinks(m, settings)
+	seen := make(map[string]bool)
+	for _, link := range links {
+		seen[canonicalizeURL(link.Link)] = true
+	}
+	for _, referenced := range getReferencedMessages(m) {
+		referencedTime, _ := referenced.Timestamp.Parse()
+		for _, link := range getMessageRawLinks(referenced, settings) {
+			if seen[canonicalizeURL(link.Link)] {
+				continue
+			}
+			seen[canonicalizeURL(link.Link)] = true
+			link.Time = referencedTime
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Channel settings come from the given config snapshot, so every link in a message is found with the same ones.
+func getMessageRawLinks(m *discordgo.Message, settings *configuration) []*fileItem {
 	var links []*fileItem
 
 	if m.Author == nil {
 		m.Author = new(discordgo.User)
 	}
 
-	for _, attachment := range m.Attachments {
+	saveThumbnails, skipThumbnailsWithImage, sameNameAttachments := false, true, ccdSameNameAttachments
+	if settings.isChannelRegistered(m.ChannelID) {
+		channelConfig := settings.getChannelConfig(m.ChannelID)
+		saveThumbnails = channelConfig.SaveEmbedThumbnails != nil && *channelConfig.SaveEmbedThumbnails
+		skipThumbnailsWithImage = channelConfig.SkipThumbnailsWithImage == nil || *channelConfig.SkipThumbnailsWithImage
+		if channelConfig.SameNameAttachments != nil {
+			sameNameAttachments = *channelConfig.SameNameAttachments
+		}
+	}
+
+	descriptions := getAttachmentDescriptions(m)
+	for i, attachment := range m.Attachments {
 		links = append(links, &fileItem{
-			Link:     attachment.URL,
-			Filename: attachment.Filename,
+			Link:            attachment.URL,
+			Filename:        attachment.Filename,
+			Size:            int64(attachment.Size),
+			AttachmentID:    attachment.ID,
+			AttachmentIndex: i + 1,
+			AltText:         descriptions[attachment.ID],
 		})
 	}
+	nameSameNamedAttachments(links, sameNameAttachments)
 
+	// Links in the message keep the title of the embed Discord made for them
+	embedTitles := make(map[string]string)
+	for _, embed := range m.Embeds {
+		if embed.URL != "" && embed.Title != "" {
+			embedTitles[embed.URL] = embed.Title
+		}
+	}
 	foundLinks := xurls.Strict().FindAllString(m.Content, -1)
 	for _, foundLink := range foundLinks {
 		links = append(links, &fileItem{
-			Link: foundLink,
+			Link:       foundLink,
+			EmbedTitle: embedTitles[foundLink],
 		})
 	}
 
 	for _, embed := range m.Embeds {
 		if embed.URL != "" {
 			links = append(links, &fileItem{
-				Link: embed.URL,
+				Link:       embed.URL,
+				EmbedTitle: embed.Title,
 			})
 		}
 
@@ -180,23 +368,62 @@ func getRawLinks(m *discordgo.Message) []*fileItem {
 		}*/
 
 		if embed.Image != nil && embed.Image.URL != "" {
-			links = append(links, &fileItem{
-				Link: embed.Image.URL,
-			})
+			embedImage := &fileItem{
+				Link:       embed.Image.URL,
+				Width:      embed.Image.Width,
+				Height:     embed.Image.Height,
+				EmbedTitle: embed.Title,
+			}
+			if embed.Image.ProxyURL != embed.Image.URL {
+				embedImage.FallbackLink = embed.Image.ProxyURL
+			}
+			links = append(links, embedImage)
 		}
 
+		// discordgo doesn't have the video's proxy_url yet
 		if embed.Video != nil && embed.Video.URL != "" {
 			links = append(links, &fileItem{
-				Link: embed.Video.URL,
+				Link:       embed.Video.URL,
+				Width:      embed.Video.Width,
+				Height:     embed.Video.Height,
+				EmbedTitle: embed.Title,
 			})
 		}
+
+		// Thumbnails, provider and footer icons are never saved
+		if saveThumbnails && embed.Thumbnail != nil && embed.Thumbnail.URL != "" &&
+			!(skipThumbnailsWithImage && embed.Image != nil && embed.Image.URL != "") {
+			thumbnail := &fileItem{
+				Link:       embed.Thumbnail.URL,
+				Width:      embed.Thumbnail.Width,
+				Height:     embed.Thumbnail.Height,
+				EmbedTitle: embed.Title,
+			}
+			if embed.Thumbnail.ProxyURL != embed.Thumbnail.URL {
+				thumbnail.FallbackLink = embed.Thumbnail.ProxyURL
+			}
+			links = append(links, thumbnail)
+		}
 	}
 
 	return links
 }
 
 func getDownloadLinks(inputURL string, channelID string) map[string]string {
-	logPrefixErrorHere := color.HiRedString("[getDownloadLinks]")
+	return resolveDownloadLinks(inputURL, channelID, false, getMaxFilesPerAlbum(channelID))
+}
+
+// Like getDownloadLinks, but asks the site handlers again rather than using what they found earlier.
+func getFreshDownloadLinks(inputURL string, channelID string) map[string]string {
+	return resolveDownloadLinks(inputURL, channelID, true, getMaxFilesPerAlbum(channelID))
+}
+
+// Albums stop at albumLimit files, 0 for all of them.
+func resolveDownloadLinks(inputURL string, channelID string, fresh bool, albumLimit int) map[string]string {
+	// Checked again once shortened links are unwrapped
+	if isBlocklisted(inputURL) {
+		return nil
+	}
 
 	/* TODO: Download Support...
 	- TikTok: Tried, once the connection is closed the cdn URL is rendered invalid
@@ -204,252 +431,691 @@ func getDownloadLinks(inputURL string, channelID string) map[string]string {
 	- Facebook Videos: Previously supported but they split mp4 into separate audio and video streams
 	*/
 
-	if regexUrlTwitter.MatchString(inputURL) {
-		links, err := getTwitterUrls(inputURL)
-		if err != nil {
-			if !strings.Contains(err.Error(), "suspended") {
-				log.Println(logPrefixErrorHere, color.RedString("Twitter Media fetch failed for %s -- %s", inputURL, err))
-			}
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	inputURL = normalizeURL(inputURL)
+	if isBlocklisted(inputURL) {
+		return nil
+	}
+
+	if regexUrlDiscordMessage.MatchString(inputURL) {
+		return getLinkedMessageLinks(inputURL, channelID, fresh, albumLimit)
+	}
+
+	if links := matchSiteHandlersCached(inputURL, channelID, fresh, albumLimit); len(links) > 0 {
+		return links
+	}
+
+	if strings.HasPrefix(inputURL, "https://cdn.discordapp.com/emojis/") {
+		return nil
+	}
+
+	// Match once more without queries, except on Discord's CDN where they're the signature.
+	// Whatever that finds is final, so a handler returning links with queries can't send it round again.
+	parsedURL, err := url.Parse(inputURL)
+	if err == nil && !isDiscordCDNURL(inputURL) && parsedURL.RawQuery != "" {
+		parsedURL.RawQuery = ""
+		inputURL = parsedURL.String()
+		if links := matchSiteHandlersCached(inputURL, channelID, fresh, albumLimit); len(links) > 0 {
+			return links
 		}
 	}
-	if regexUrlTwitterStatus.MatchString(inputURL) {
-		links, err := getTwitterStatusUrls(inputURL, channelID)
-		if err != nil {
-			if !strings.Contains(err.Error(), "suspended") && !strings.Contains(err.Error(), "No status found") {
-				log.Println(logPrefixErrorHere, color.RedString("Twitter Status fetch failed for %s -- %s", inputURL, err))
+
+	return map[string]string{inputURL: ""}
+}
+
+// Most links resolved at once for a single message, handlers are mostly waiting on other sites
+const linkResolveConcurrency = 4
+
+// Messages from history runs come with the run's options, live ones with nil.
+func getFileLinks(m *discordgo.Message, run *historyOptions, settings *configuration) []*fileItem {
+	var fileItems []*fileItem
+
+	linkTime, err := m.Timestamp.Parse()
+	if err != nil {
+		linkTime = time.Now()
+	}
+
+	maxLinks, albumLimit := 0, 0
+	if settings.isChannelRegistered(m.ChannelID) {
+		channelConfig := settings.getChannelConfig(m.ChannelID)
+		maxLinks, albumLimit = *channelConfig.MaxLinksPerMessage, *channelConfig.MaxFilesPerAlbum
+	}
+	if run != nil && run.noLimits {
+		maxLinks, albumLimit = 0, 0
+	}
+
+	rawLinks := trimRecordedLinks(m.ChannelID, getRawLinks(m, settings), run)
+	if maxLinks > 0 && len(rawLinks) > maxLinks {
+		recordLimitReached(m.ChannelID, "message "+m.ID, "link", maxLinks, len(rawLinks)-maxLinks)
+		rawLinks = rawLinks[:maxLinks]
+	}
+
+	// Resolved side by side, then gone through in the order they're in the message
+	resolved := make([]map[string]string, len(rawLinks))
+	semaphore := make(chan struct{}, linkResolveConcurrency)
+	var wg sync.WaitGroup
+	for i, rawLink := range rawLinks {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, link string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			resolved[i] = resolveDownloadLinks(link, m.ChannelID, false, albumLimit)
+		}(i, rawLink.Link)
+	}
+	wg.Wait()
+
+	for i, rawLink := range rawLinks {
+		for link, filename := range resolved[i] {
+			if rawLink.Filename != "" {
+				filename = rawLink.Filename
+			}
+			// Expected size, fallback, dimensions and attachment only apply if the link wasn't swapped out by a site handler
+			item := &fileItem{
+				Link:       link,
+				Filename:   filename,
+				Time:       linkTime,
+				AltText:    rawLink.AltText,
+				EmbedTitle: rawLink.EmbedTitle,
+				Post:       getSitePost(link),
+			}
+			if !rawLink.Time.IsZero() {
+				item.Time = rawLink.Time
+			}
+			if link == rawLink.Link {
+				item.Size = rawLink.Size
+				item.FallbackLink = rawLink.FallbackLink
+				item.Width, item.Height = rawLink.Width, rawLink.Height
+				item.AttachmentID = rawLink.AttachmentID
+				item.AttachmentIndex = rawLink.AttachmentIndex
 			}
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+
+			fileItems = append(fileItems, item)
 		}
 	}
 
-	if regexUrlInstagram.MatchString(inputURL) {
-		links, err := getInstagramUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Instagram fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
-		}
+	fileItems = trimDuplicateLinks(fileItems)
+
+	return fileItems
+}
+
+type downloadRequestStruct struct {
+	InputURL       string
+	Filename       string
+	Path           string
+	Message        *discordgo.Message
+	FileTime       time.Time
+	ExpectedSize   int64    // optional, verified against the response body when set
+	AttachmentID   string   // optional, lets expired Discord links be refreshed from the message
+	AltText        string   // optional, the attachment's, for filenameFormat
+	EmbedTitle     string   // optional, the title of the embed the link came from, for filenameFormat
+	Post           sitePost // optional, the post a site handler found the link in
+	FallbackURL    string   // optional, Discord's proxied copy, tried if InputURL is gone
+	WidthHint      int      // optional, from the embed, checked against the dimension filters before downloading
+	HeightHint     int      // optional
+	SourceURL      string   // set by startDownload when InputURL is the fallback, recorded as the URL instead
+	HistoryCmd     bool
+	HistoryQuiet   bool // from a scheduled catch-up, what's saved isn't logged
+	HistoryForce   bool // from history with --force, see getRecordedDownload
+	HistoryMissing bool // from history with --missing-only
+	EmojiCmd       bool
+	ManualDownload bool
+	APIRequest     bool           // from the HTTP API, there's no message to reply to or react on
+	NSFW           bool           // set by startDownload, from an NSFW channel or post
+	DryRun         bool           // nothing is written to disk, the database, or Discord
+	DryRunReport   *dryRunReport  // optional, tallies dry run results
+	Settings       *configuration // optional, the config snapshot of the message it came from, the current one if nil
+}
+
+//#region Dry Run
+
+// Set by the -dryrun flag, every download is treated as a dry run
+var dryRunMode bool
+
+type dryRunTally struct {
+	statuses     map[downloadStatus]int
+	bytes        int64
+	unknownSizes int
+}
+
+type dryRunReport struct {
+	mutex sync.Mutex
+	dryRunTally
+	// Broken down by where the links go and who posted them, for history analyze
+	domains   map[string]*dryRunTally
+	uploaders map[string]*dryRunTally // by user ID
+	names     map[string]string       // user ID to name
+	analyze   bool                    // summary includes the breakdowns
+	attachCSV bool                    // the breakdown by domain is sent as a CSV too
+}
+
+func newDryRunReport() *dryRunReport {
+	return &dryRunReport{
+		dryRunTally: dryRunTally{statuses: make(map[downloadStatus]int)},
+		domains:     make(map[string]*dryRunTally),
+		uploaders:   make(map[string]*dryRunTally),
+		names:       make(map[string]string),
 	}
+}
 
-	if regexUrlImgurSingle.MatchString(inputURL) {
-		links, err := getImgurSingleUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Imgur Media fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+func (t *dryRunTally) add(status downloadStatusStruct) {
+	t.statuses[status.Status]++
+	if status.Status == downloadSuccess {
+		if status.Size >= 0 {
+			t.bytes += status.Size
+		} else {
+			t.unknownSizes++
 		}
 	}
-	if regexUrlImgurAlbum.MatchString(inputURL) {
-		links, err := getImgurAlbumUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Imgur Album fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
-		}
+}
+
+func (r *dryRunReport) add(download downloadRequestStruct, status downloadStatusStruct) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dryRunTally.add(status)
+	domain := "unknown"
+	if parsedURL, err := url.Parse(download.InputURL); err == nil && parsedURL.Hostname() != "" {
+		domain = strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+	}
+	getDryRunTally(r.domains, domain).add(status)
+	if download.Message != nil && download.Message.Author != nil && download.Message.Author.ID != "" {
+		author := download.Message.Author
+		getDryRunTally(r.uploaders, author.ID).add(status)
+		r.names[author.ID] = getUserIdentifier(*author)
+	}
+}
+
+func getDryRunTally(tallies map[string]*dryRunTally, key string) *dryRunTally {
+	if tallies[key] == nil {
+		tallies[key] = &dryRunTally{statuses: make(map[downloadStatus]int)}
 	}
+	return tallies[key]
+}
 
-	if regexUrlStreamable.MatchString(inputURL) {
-		links, err := getStreamableUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Streamable fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+func (r *dryRunReport) summary() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	summary := fmt.Sprintf("**DRY RUN** — nothing was saved\n**%s files would be downloaded**, about %s",
+		formatNumber(int64(r.statuses[downloadSuccess])), formatBytes(r.bytes))
+	if r.unknownSizes > 0 {
+		summary += fmt.Sprintf(" _(+%d of unknown size)_", r.unknownSizes)
+	}
+	var statuses []int
+	for status := range r.statuses {
+		if status != downloadSuccess {
+			statuses = append(statuses, int(status))
 		}
 	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		summary += fmt.Sprintf("\n`%s` %s", formatNumber(int64(r.statuses[downloadStatus(status)])), getDownloadStatusString(downloadStatus(status)))
+	}
+	if r.analyze {
+		summary += r.breakdowns()
+	}
+	return summary
+}
 
-	if regexUrlGfycat.MatchString(inputURL) {
-		links, err := getGfycatUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Gfycat fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+//#endregion
+
+//#region Filters
+
+func isExtensionPermitted(channelConfig configurationChannel, extension string) bool {
+	if channelConfig.Filters.AllowedExtensions != nil {
+		return stringInSlice(extension, *channelConfig.Filters.AllowedExtensions)
+	}
+	if channelConfig.Filters.BlockedExtensions != nil {
+		return !stringInSlice(extension, *channelConfig.Filters.BlockedExtensions)
+	}
+	return true
+}
+
+func isDomainPermitted(channelConfig configurationChannel, domain string) bool {
+	if channelConfig.Filters.AllowedDomains != nil {
+		return stringInSlice(domain, *channelConfig.Filters.AllowedDomains)
+	}
+	if channelConfig.Filters.BlockedDomains != nil {
+		return !stringInSlice(domain, *channelConfig.Filters.BlockedDomains)
+	}
+	return true
+}
+
+// Checks the input URL's hostname, the one it redirected to, or both, going by blockRedirectsToBlockedDomains.
+// Returns the first hostname that isn't permitted, or "" if they all are.
+func getUnpermittedDomain(channelConfig configurationChannel, inputURL string, finalURL *url.URL) string {
+	mode := "both"
+	if channelConfig.Filters.BlockRedirectsToBlockedDomains != nil {
+		mode = *channelConfig.Filters.BlockRedirectsToBlockedDomains
+	}
+	var domains []string
+	if mode != "final" {
+		if parsedURL, err := url.Parse(inputURL); err == nil {
+			domains = append(domains, parsedURL.Hostname())
+		}
+	}
+	if mode != "original" && finalURL != nil {
+		domains = append(domains, finalURL.Hostname())
+	}
+	for _, domain := range domains {
+		if !isDomainPermitted(channelConfig, domain) {
+			return domain
 		}
 	}
+	return ""
+}
 
-	if regexUrlFlickrPhoto.MatchString(inputURL) {
-		links, err := getFlickrPhotoUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Flickr Photo fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+// Unknown dimensions (0) are always permitted.
+func isDimensionPermitted(channelConfig configurationChannel, width int, height int) bool {
+	if channelConfig.Filters.MinWidth != nil && width > 0 && width < *channelConfig.Filters.MinWidth {
+		return false
+	}
+	if channelConfig.Filters.MinHeight != nil && height > 0 && height < *channelConfig.Filters.MinHeight {
+		return false
+	}
+	return true
+}
+
+// Unknown sizes (negative) are always permitted.
+func isSizePermitted(channelConfig configurationChannel, size int64) bool {
+	if size < 0 {
+		return true
+	}
+	if channelConfig.Filters.MinFileSize != nil {
+		if min, err := parseByteSize(*channelConfig.Filters.MinFileSize); err == nil && size < min {
+			return false
 		}
 	}
-	if regexUrlFlickrAlbum.MatchString(inputURL) {
-		links, err := getFlickrAlbumUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Flickr Album fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	if channelConfig.Filters.MaxFileSize != nil {
+		if max, err := parseByteSize(*channelConfig.Filters.MaxFileSize); err == nil && size > max {
+			return false
 		}
 	}
-	if regexUrlFlickrAlbumShort.MatchString(inputURL) {
-		links, err := getFlickrAlbumShortUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Flickr Album (short) fetch failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	return true
+}
+
+// Corrects sniffed content types for formats http.DetectContentType doesn't know.
+func fixContentType(extension string, contentTypeFound string) string {
+	if stringInSlice(extension, []string{".mov"}) ||
+		stringInSlice(extension, []string{".mp4"}) ||
+		stringInSlice(extension, []string{".webm"}) {
+		return "video"
+	} else if stringInSlice(extension, []string{".psd"}) ||
+		stringInSlice(extension, []string{".nef"}) ||
+		stringInSlice(extension, []string{".dng"}) ||
+		stringInSlice(extension, []string{".tif"}) ||
+		stringInSlice(extension, []string{".tiff"}) {
+		return "image"
+	}
+	return contentTypeFound
+}
+
+// MIME type the filters go by, without parameters. The sniffed type is used unless mimeTypeSource prefers the header,
+// either one falls back to the other when it has nothing better than octet-stream.
+func getFilteredMIMEType(channelConfig configurationChannel, detected string, header string) string {
+	clean := func(mimeType string) string {
+		if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+			return parsed
 		}
+		return ""
+	}
+	detected, header = clean(detected), clean(header)
+	first, second := detected, header
+	if channelConfig.Filters.MIMETypeSource != nil && *channelConfig.Filters.MIMETypeSource == "header" {
+		first, second = header, detected
+	}
+	if first == "" || (first == "application/octet-stream" && second != "") {
+		return second
 	}
+	return first
+}
 
-	if config.Credentials.GoogleDriveCredentialsJSON != "" {
-		if regexUrlGoogleDrive.MatchString(inputURL) {
-			links, err := getGoogleDriveUrls(inputURL)
-			if err != nil {
-				log.Println(logPrefixErrorHere, color.RedString("Google Drive Album URL for %s -- %s", inputURL, err))
-			} else if len(links) > 0 {
-				return trimDownloadedLinks(links, channelID)
+// Entries can be wildcards, e.g. "image/*" or "application/*+json".
+func isMIMETypePermitted(channelConfig configurationChannel, mimeType string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, mimeType); matched {
+				return true
 			}
 		}
-		if regexUrlGoogleDriveFolder.MatchString(inputURL) {
-			links, err := getGoogleDriveFolderUrls(inputURL)
-			if err != nil {
-				log.Println(logPrefixErrorHere, color.RedString("Google Drive Folder URL for %s -- %s", inputURL, err))
-			} else if len(links) > 0 {
-				return trimDownloadedLinks(links, channelID)
-			}
+		return false
+	}
+	if channelConfig.Filters.AllowedMIMETypes != nil {
+		return matches(*channelConfig.Filters.AllowedMIMETypes)
+	}
+	if channelConfig.Filters.BlockedMIMETypes != nil {
+		return !matches(*channelConfig.Filters.BlockedMIMETypes)
+	}
+	return true
+}
+
+func isContentTypePermitted(channelConfig configurationChannel, contentTypeFound string) bool {
+	return (*channelConfig.SaveImages && contentTypeFound == "image") ||
+		(*channelConfig.SaveVideos && contentTypeFound == "video") ||
+		(*channelConfig.SaveAudioFiles && contentTypeFound == "audio") ||
+		(*channelConfig.SaveTextFiles && contentTypeFound == "text") ||
+		(*channelConfig.SaveOtherFiles && contentTypeFound == "application")
+}
+
+// Sends a HEAD request and checks the response headers against the channel filters.
+// Returns skip=false whenever the headers can't be trusted or the server doesn't support HEAD,
+// leaving the decision to the usual checks after the GET.
+func preflightDownload(client *http.Client, download downloadRequestStruct, channelConfig configurationChannel) (downloadStatusStruct, bool) {
+	request, err := http.NewRequest("HEAD", download.InputURL, nil)
+	if err != nil {
+		return downloadStatusStruct{}, false
+	}
+	request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.139 Safari/537.36")
+	// Content-Length has to be the file's size for the size filters
+	request.Header.Add("Accept-Encoding", "identity")
+	response, err := client.Do(request)
+	if err != nil {
+		return downloadStatusStruct{}, false
+	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Preflight got %d for %s, falling back to GET...", response.StatusCode, download.InputURL))
 		}
+		return downloadStatusStruct{}, false
 	}
 
-	if regexUrlTistory.MatchString(inputURL) {
-		links, err := getTistoryUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Tistory URL failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	// Extension
+	filename := download.Filename
+	if filename == "" {
+		filename = filenameFromURL(response.Request.URL.String())
+		if disposition := response.Header.Get("Content-Disposition"); disposition != "" {
+			if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+				filename = params["filename"]
+			}
 		}
 	}
-	if regexUrlTistoryLegacy.MatchString(inputURL) {
-		links, err := getLegacyTistoryUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Legacy Tistory URL failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	if extension := strings.ToLower(filepath.Ext(filename)); extension != "" && !isExtensionPermitted(channelConfig, extension) {
+		if !download.HistoryCmd {
+			log.Println(logPrefixFileSkip, color.GreenString("Unpermitted extension (%s) found at %s (preflight)", extension, download.InputURL))
 		}
+		return mDownloadStatus(downloadSkippedUnpermittedExtension), true
 	}
 
-	if regexUrlRedditPost.MatchString(inputURL) {
-		links, err := getRedditPostUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Reddit Post URL failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	// Domain, before and after redirects
+	if domain := getUnpermittedDomain(channelConfig, download.InputURL, response.Request.URL); domain != "" {
+		if !download.HistoryCmd {
+			log.Println(logPrefixFileSkip, color.GreenString("Unpermitted domain (%s) found at %s (preflight)", domain, download.InputURL))
 		}
+		return mDownloadStatus(downloadSkippedUnpermittedDomain), true
 	}
 
-	if regexUrlMastodonPost1.MatchString(inputURL) || regexUrlMastodonPost2.MatchString(inputURL) {
-		links, err := getMastodonPostUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Mastodon Post URL failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	// Size
+	if !isSizePermitted(channelConfig, response.ContentLength) {
+		if !download.HistoryCmd {
+			log.Println(logPrefixFileSkip, color.GreenString("Unpermitted size (%s) found at %s (preflight)", formatBytes(response.ContentLength), download.InputURL))
 		}
+		return mDownloadStatus(downloadSkippedUnpermittedSize), true
 	}
 
-	// The original project has this as an option,
-	if regexUrlPossibleTistorySite.MatchString(inputURL) {
-		links, err := getPossibleTistorySiteUrls(inputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Checking for Tistory site failed for %s -- %s", inputURL, err))
-		} else if len(links) > 0 {
-			return trimDownloadedLinks(links, channelID)
+	// Content type, only when specific enough to trust; the body is still sniffed after the GET
+	if contentType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type")); err == nil && contentType != "application/octet-stream" {
+		contentTypeFound := strings.Split(contentType, "/")[0]
+		if stringInSlice(contentTypeFound, []string{"image", "video", "audio"}) && !isContentTypePermitted(channelConfig, contentTypeFound) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted filetype (%s) found at %s (preflight)", contentTypeFound, download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedType), true
+		}
+		// MIME type filters can trust the header when told to
+		if channelConfig.Filters.MIMETypeSource != nil && *channelConfig.Filters.MIMETypeSource == "header" &&
+			((*channelConfig.Filters.SkipHTML && contentType == "text/html") || !isMIMETypePermitted(channelConfig, contentType)) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted MIME type (%s) found at %s (preflight)", contentType, download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedType), true
 		}
 	}
 
-	if strings.HasPrefix(inputURL, "https://cdn.discordapp.com/emojis/") {
-		return nil
+	return downloadStatusStruct{}, false
+}
+
+//#endregion
+
+func isDiscordCDNURL(inputURL string) bool {
+	if parsedURL, err := url.Parse(inputURL); err == nil {
+		host := strings.ToLower(parsedURL.Hostname())
+		return host == "cdn.discordapp.com" || host == "media.discordapp.net"
 	}
+	return false
+}
+
+// Discord's query parameters for resizing, converting and signing, none of them change which file it is
+var discordCDNVariantParams = []string{"ex", "is", "hm", "width", "height", "format", "size", "quality"}
 
-	// Try without queries
+// The same link for every form of a Discord CDN file, to tell whether it's been downloaded already. Attachments
+// are keyed on their channel, ID and filename alone. Links elsewhere are left as they are. Files are still
+// downloaded from the link as it was, the signature's needed for that.
+func canonicalizeURL(inputURL string) string {
+	if !isDiscordCDNURL(inputURL) {
+		return inputURL
+	}
 	parsedURL, err := url.Parse(inputURL)
-	if err == nil {
+	if err != nil {
+		return inputURL
+	}
+	parsedURL.Scheme, parsedURL.Host, parsedURL.Fragment = "https", "cdn.discordapp.com", ""
+	if strings.HasPrefix(parsedURL.Path, "/attachments/") || strings.HasPrefix(parsedURL.Path, "/ephemeral-attachments/") {
 		parsedURL.RawQuery = ""
-		inputURLWithoutQueries := parsedURL.String()
-		if inputURLWithoutQueries != inputURL {
-			return trimDownloadedLinks(getDownloadLinks(inputURLWithoutQueries, channelID), channelID)
-		}
+		return parsedURL.String()
 	}
-
-	return trimDownloadedLinks(map[string]string{inputURL: ""}, channelID)
+	query := parsedURL.Query()
+	for _, param := range discordCDNVariantParams {
+		query.Del(param)
+	}
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String()
 }
 
-func getFileLinks(m *discordgo.Message) []*fileItem {
-	var fileItems []*fileItem
-
-	linkTime, err := m.Timestamp.Parse()
+// Fetches the message again for a freshly signed link to the attachment, "" if there isn't a different one.
+func refreshAttachmentURL(download downloadRequestStruct) string {
+	if download.Message == nil || download.Message.ID == "" || !isDiscordCDNURL(download.InputURL) {
+		return ""
+	}
+	message, err := bot.ChannelMessage(download.Message.ChannelID, download.Message.ID)
 	if err != nil {
-		linkTime = time.Now()
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Couldn't fetch message %s to refresh %s:\t%s", download.Message.ID, download.InputURL, err))
+		}
+		return ""
 	}
-
-	rawLinks := getRawLinks(m)
-	for _, rawLink := range rawLinks {
-		downloadLinks := getDownloadLinks(
-			rawLink.Link,
-			m.ChannelID,
-		)
-		for link, filename := range downloadLinks {
-			if rawLink.Filename != "" {
-				filename = rawLink.Filename
+	// Forwarded attachments are signed again in the snapshot
+	attachments := message.Attachments
+	for _, referenced := range getReferencedMessages(message) {
+		attachments = append(attachments, referenced.Attachments...)
+	}
+	for _, attachment := range attachments {
+		if attachment.ID == download.AttachmentID || (attachment.Filename == download.Filename && download.Filename != "") {
+			if attachment.URL != download.InputURL {
+				return attachment.URL
 			}
-
-			fileItems = append(fileItems, &fileItem{
-				Link:     link,
-				Filename: filename,
-				Time:     linkTime,
-			})
+			break
 		}
 	}
+	return ""
+}
 
-	fileItems = trimDuplicateLinks(fileItems)
-
-	return fileItems
+// Whether the file's gone from where it was, retrying won't help but another copy might.
+func isDownloadGone(status downloadStatus) bool {
+	return status == downloadFailed404 || status == downloadFailed403 || status == downloadFailed410
 }
 
-type downloadRequestStruct struct {
-	InputURL       string
-	Filename       string
-	Path           string
-	Message        *discordgo.Message
-	FileTime       time.Time
-	HistoryCmd     bool
-	EmojiCmd       bool
-	ManualDownload bool
+// Whether Discord's proxied copy should be tried before the link itself, for domains in proxyFirstDomains.
+func isProxyPreferred(inputURL string) bool {
+	settings := getConfig()
+	if len(settings.ProxyFirstDomains) == 0 {
+		return false
+	}
+	parsedURL, err := url.Parse(inputURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, domain := range settings.ProxyFirstDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
 }
 
 func startDownload(download downloadRequestStruct) downloadStatusStruct {
+	// One snapshot for every attempt, handed down to them
+	if download.Settings == nil {
+		download.Settings = getConfig()
+	}
+	settings := download.Settings
 	status := mDownloadStatus(downloadFailed)
 	logPrefixErrorHere := color.HiRedString("[startDownload]")
+	started := time.Now()
+	atomic.AddInt64(&downloadsInProgress, 1)
+	defer atomic.AddInt64(&downloadsInProgress, -1)
 
-	for i := 0; i < config.DownloadRetryMax; i++ {
-		status = tryDownload(download)
-		if status.Status < downloadFailed || status.Status == downloadFailed404 { // Success or Skip
-			break
+	// Links found by handlers or recovered from the journal haven't all been through getDownloadLinks
+	if isBlocklisted(download.InputURL) {
+		return mDownloadStatus(downloadIgnored)
+	}
+
+	// Big files wait for the schedule's window, it's journaled again for then
+	if deferDownload(download) {
+		return mDownloadStatus(downloadSkippedDeferred)
+	}
+
+	download.Path = resolveDestination(download.Path, download.Message)
+
+	// NSFW channels and posts can be kept apart, subfolders are still divided beneath
+	if !download.ManualDownload && !download.EmojiCmd && download.Message != nil {
+		if download.NSFW = isChannelNSFW(download.Message.ChannelID); download.NSFW {
+			download.Path = getNSFWDestination(download.Path, download.Message)
+		}
+	}
+
+	if settings.PauseOnLowSpace && !download.DryRun {
+		waitForDiskSpace(download.Path)
+	}
+
+	// Links that keep failing are left alone for a while, or for good
+	if !download.ManualDownload && !download.EmojiCmd {
+		if reason := getFailedURLSkipReason(download.InputURL, download.Message.ChannelID); reason != "" {
+			if download.HistoryCmd {
+				recordFailedURLSkip(download.Message.ChannelID)
+			} else if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Skipping %s, %s", download.InputURL, reason))
+			}
+			status = mDownloadStatus(downloadSkippedFailedBefore, errors.New(reason))
+			if download.DryRun && download.DryRunReport != nil {
+				download.DryRunReport.add(download, status)
+			}
+			return status
+		}
+	}
+
+	// Discord's proxied copy of an embed is tried if the original's gone, or first for flaky domains
+	originalURL := download.InputURL
+	sources := []string{originalURL}
+	if download.FallbackURL != "" && download.FallbackURL != originalURL {
+		if isProxyPreferred(originalURL) {
+			sources = []string{download.FallbackURL, originalURL}
 		} else {
-			time.Sleep(5 * time.Second)
+			sources = append(sources, download.FallbackURL)
+		}
+	}
+
+	attempts := 0
+	for n, source := range sources {
+		download.InputURL, download.SourceURL = source, ""
+		if source != originalURL {
+			download.SourceURL = originalURL
+		}
+		solved := false
+		for i := 0; i < settings.DownloadRetryMax; i++ {
+			attempts++
+			status = tryDownload(download)
+			if status.Status == downloadFailedBotChallenge {
+				// Trying again won't change anything, unless FlareSolverr gets past it first
+				if solved || !solveBotChallenge(download.InputURL) {
+					break
+				}
+				solved = true
+			} else if status.Status < downloadFailed || isDownloadGone(status.Status) { // Success or Skip
+				break
+			} else {
+				time.Sleep(5 * time.Second)
+			}
+		}
+		// Discord's copy might still be there for a challenged link
+		if !isDownloadGone(status.Status) && status.Status != downloadFailedBotChallenge {
+			break
+		}
+		if n+1 < len(sources) && settings.DebugOutput {
+			log.Println(logPrefixDebug, color.CyanString("%s is gone, trying %s", source, sources[n+1]))
+		}
+	}
+	// Failures are remembered against the link from the message, whichever copy was tried last
+	download.InputURL, download.SourceURL = originalURL, ""
+
+	// Expired Discord links can be signed again by fetching the message
+	if isDownloadGone(status.Status) && download.AttachmentID != "" {
+		if refreshedURL := refreshAttachmentURL(download); refreshedURL != "" {
+			expiredURL := download.InputURL
+			download.InputURL = refreshedURL
+			attempts++
+			status = tryDownload(download)
+			if status.Status == downloadSuccess {
+				log.Println(logPrefixDiscord, color.HiGreenString("Refreshed expired link %s from message %s, downloaded after all", expiredURL, download.Message.ID))
+			}
+		}
+	}
+
+	if download.DryRun {
+		if download.DryRunReport != nil {
+			download.DryRunReport.add(download, status)
+		}
+		return status
+	}
+
+	if !download.EmojiCmd {
+		if status.Status >= downloadFailed {
+			if err := dbRecordFailedURL(download.InputURL, download.Message.ChannelID, download.Message.ID, status.Status); err != nil {
+				log.Println(logPrefixErrorHere, color.HiRedString("Failed to remember failed URL %s:\t%s", download.InputURL, err))
+			}
+		} else if status.Status == downloadSuccess {
+			dbClearFailedURL(download.InputURL, download.Message.ChannelID)
 		}
 	}
 
+	if download.HistoryCmd {
+		recordHistorySkip(download.Message.ChannelID, status.Status)
+	}
+	logDownloadEvent(download, status, attempts, time.Since(started))
+	recordSessionStatus(status.Status)
+	sendDownloadNotifications(download, status)
+	sendDownloadMirrors(download, status)
+	if status.Status == downloadSuccess {
+		recordFeed(status.Saved)
+	}
+	recordDigest(download, status)
+
 	// Any kind of failure
 	if status.Status >= downloadFailed && !download.HistoryCmd && !download.EmojiCmd {
-		log.Println(logPrefixErrorHere, color.RedString("Gave up on downloading %s after %d failed attempts...\t%s", download.InputURL, config.DownloadRetryMax, getDownloadStatusString(status.Status)))
-		if isChannelRegistered(download.Message.ChannelID) {
-			channelConfig := getChannelConfig(download.Message.ChannelID)
-			if !download.HistoryCmd && *channelConfig.ErrorMessages {
+		log.Println(logPrefixErrorHere, color.RedString("Gave up on downloading %s after %d failed attempts...\t%s", download.InputURL, settings.DownloadRetryMax, getDownloadStatusString(status.Status)))
+		if settings.isChannelRegistered(download.Message.ChannelID) {
+			channelConfig := settings.getChannelConfig(download.Message.ChannelID)
+			if !download.HistoryCmd && !download.APIRequest && *channelConfig.ErrorMessages && !digestReplacesMessages(download.Message.ChannelID) {
 				content := fmt.Sprintf(
 					"Gave up trying to download\n<%s>\nafter %d failed attempts...\n\n``%s``",
-					download.InputURL, config.DownloadRetryMax, getDownloadStatusString(status.Status))
+					download.InputURL, settings.DownloadRetryMax, getDownloadStatusString(status.Status))
 				if status.Error != nil {
 					content += fmt.Sprintf("\n```ERROR: %s```", status.Error)
 				}
 				// Failure Notice
 				if hasPerms(download.Message.ChannelID, discordgo.PermissionSendMessages) {
 					_, err := bot.ChannelMessageSendComplex(download.Message.ChannelID,
-						&discordgo.MessageSend{
-							Content: fmt.Sprintf("<@!%s>", download.Message.Author.ID),
-							Embed:   buildEmbed(download.Message.ChannelID, "Download Failure", content),
-						})
+						embedMessage(download.Message.ChannelID, fmt.Sprintf("<@!%s>", download.Message.Author.ID), "Download Failure", content))
 					if err != nil {
 						log.Println(logPrefixErrorHere, color.HiRedString("Failed to send failure message to %s: %s", download.Message.ChannelID, err))
 					}
@@ -457,15 +1123,15 @@ func startDownload(download downloadRequestStruct) downloadStatusStruct {
 					log.Println(logPrefixErrorHere, color.HiRedString(fmtBotSendPerm, download.Message.ChannelID))
 				}
 			}
-			if status.Error != nil {
-				logErrorMessage(fmt.Sprintf("**%s**\n\n%s", getDownloadStatusString(status.Status), status.Error))
-			}
 		}
 	}
+	if status.Status >= downloadFailed && settings.isChannelRegistered(download.Message.ChannelID) {
+		logDownloadFailure(download, status)
+	}
 
 	// Log Links to File
-	if isChannelRegistered(download.Message.ChannelID) {
-		channelConfig := getChannelConfig(download.Message.ChannelID)
+	if settings.isChannelRegistered(download.Message.ChannelID) {
+		channelConfig := settings.getChannelConfig(download.Message.ChannelID)
 		if channelConfig.LogLinks != nil {
 			if channelConfig.LogLinks.Destination != "" {
 				logPath := channelConfig.LogLinks.Destination
@@ -503,6 +1169,8 @@ func startDownload(download downloadRequestStruct) downloadStatusStruct {
 						if *channelConfig.LogLinks.DivideLogsByStatus == true {
 							if status.Status >= downloadFailed {
 								logPath += " - FAILED"
+							} else if status.Status == downloadSkippedAlreadyRecorded {
+								logPath += " - ALREADY DOWNLOADED"
 							} else if status.Status >= downloadSkipped {
 								logPath += " - SKIPPED"
 							} else if status.Status == downloadIgnored {
@@ -578,8 +1246,20 @@ func startDownload(download downloadRequestStruct) downloadStatusStruct {
 }
 
 func tryDownload(download downloadRequestStruct) downloadStatusStruct {
+	// Settings as they were when the message was handled, a reload partway through doesn't change them
+	settings := download.Settings
+	if settings == nil {
+		settings = getConfig()
+	}
+	registered := stringInSlice(download.Message.ChannelID, settings.getAllChannels())
+	var channelConfig configurationChannel
+	if settings.isChannelRegistered(download.Message.ChannelID) {
+		channelConfig = settings.getChannelConfig(download.Message.ChannelID)
+	} else {
+		channelDefault(&channelConfig)
+	}
+
 	cachedDownloadID++
-	thisDownloadID := cachedDownloadID
 
 	logPrefixErrorHere := color.HiRedString("[tryDownload]")
 	logPrefix := ""
@@ -587,14 +1267,7 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 		logPrefix = logPrefixHistory + " "
 	}
 
-	if stringInSlice(download.Message.ChannelID, getAllChannels()) || download.EmojiCmd || download.ManualDownload {
-		var channelConfig configurationChannel
-		if isChannelRegistered(download.Message.ChannelID) {
-			channelConfig = getChannelConfig(download.Message.ChannelID)
-		} else {
-			channelDefault(&channelConfig)
-		}
-
+	if registered || download.EmojiCmd || download.ManualDownload {
 		var err error
 
 		// Source validation
@@ -603,26 +1276,78 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			return mDownloadStatus(downloadFailedInvalidSource, err)
 		}
 
+		// Already downloaded for the channel, a row whose file is gone is updated if it's downloaded again
+		recordedRow := -1
+		if !download.EmojiCmd {
+			var recorded downloadStatus
+			if recorded, recordedRow = getRecordedDownload(channelConfig, download); recorded != downloadSuccess {
+				if recorded == downloadSkippedAlreadyRecorded && settings.DebugOutput {
+					log.Println(logPrefixFileSkip, color.GreenString("Found URL has already been downloaded for this channel: %s", download.InputURL))
+				}
+				return mDownloadStatus(recorded)
+			}
+		}
+
+		// Storage
+		storage, err := getStorageBackend(download.Path)
+		if err != nil {
+			log.Println(logPrefixErrorHere, color.HiRedString("Error while opening destination \"%s\": %s", download.Path, err))
+			return mDownloadStatus(storageFailureStatus(err, downloadFailedInvalidPath), err)
+		}
+		pathSeparator := storage.separator()
+
 		// Clean/fix path
-		if download.Path == "" || download.Path == string(os.PathSeparator) {
+		if download.Path == "" || download.Path == pathSeparator {
 			log.Println(logPrefixErrorHere, color.HiRedString("Destination cannot be empty path..."))
 			return mDownloadStatus(downloadFailedInvalidPath, err)
 		}
-		if !strings.HasSuffix(download.Path, string(os.PathSeparator)) {
-			download.Path = download.Path + string(os.PathSeparator)
+		if !strings.HasSuffix(download.Path, pathSeparator) {
+			download.Path = download.Path + pathSeparator
 		}
 
 		// Create folder
-		err = os.MkdirAll(download.Path, 0755)
+		if !download.DryRun {
+			err = storage.mkdirAll(download.Path)
+		}
 		if err != nil {
 			log.Println(logPrefixErrorHere, color.HiRedString("Error while creating destination folder \"%s\": %s", download.Path, err))
-			return mDownloadStatus(downloadFailedCreatingFolder, err)
+			return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingFolder), err)
+		}
+
+		// Dimensions, from the embed when it gave them
+		if !isDimensionPermitted(channelConfig, download.WidthHint, download.HeightHint) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted dimensions (%dx%d) found at %s (embed)", download.WidthHint, download.HeightHint, download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedDimensions)
+		}
+
+		// Free space, checked before anything is requested
+		if !download.DryRun {
+			if low, free := isDiskSpaceLow(download.Path); low {
+				return mDownloadStatus(downloadSkippedLowDiskSpace, fmt.Errorf("%s free in \"%s\", below minimumFreeSpace", formatBytes(free), download.Path))
+			}
+		}
+
+		// Quotas, manual and emoji downloads aren't counted against anyone
+		if !download.ManualDownload && !download.EmojiCmd && download.Message.Author != nil {
+			if exceeded := getExceededQuota(channelConfig, download.Message.ChannelID, download.Message.Author.ID); exceeded != nil {
+				if *channelConfig.QuotaNotifyUser && !download.DryRun {
+					go notifyQuotaExceeded(*exceeded, channelConfig, download.Message.ChannelID, download.Message.Author.ID)
+				}
+				return mDownloadStatus(downloadSkippedQuotaExceeded, fmt.Errorf("%s quota of %s reached %s", exceeded.scope, formatBytes(exceeded.limit), getQuotaPeriodLabel(channelConfig)))
+			}
 		}
 
 		// Request
-		timeout := time.Duration(time.Duration(config.DownloadTimeout) * time.Second)
-		client := &http.Client{
-			Timeout: timeout,
+		client, maxRedirects := getDownloadClient(download.InputURL, channelConfig)
+		var redirects []string
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			redirects = append(redirects, req.URL.String())
+			if len(via) > maxRedirects {
+				return errTooManyRedirects
+			}
+			return nil
 		}
 		request, err := http.NewRequest("GET", download.InputURL, nil)
 		request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.139 Safari/537.36")
@@ -630,8 +1355,31 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			log.Println(logPrefixErrorHere, color.HiRedString("Error while requesting \"%s\": %s", download.InputURL, err))
 			return mDownloadStatus(downloadFailedRequesting, err)
 		}
-		request.Header.Add("Accept-Encoding", "identity")
+
+		// Wait for a free connection to this domain, released once the body is read
+		releaseConnection := acquireDomainConnection(request.URL.Hostname())
+		defer releaseConnection()
+
+		// Preflight, skip early using headers when possible
+		if settings.PreflightChecks {
+			if status, skip := preflightDownload(client, download, channelConfig); skip {
+				return status
+			}
+			redirects = nil
+		}
+
+		applyChallengeClearance(request)
+		requested := time.Now()
 		response, err := client.Do(request)
+		if isProxyError(err) {
+			log.Println(logPrefixErrorHere, color.HiRedString("Error while requesting \"%s\" through proxy %s: %s", download.InputURL, redactProxy(getProxyForHost(request.URL.Hostname())), err))
+			return mDownloadStatus(downloadFailedRequesting, err)
+		}
+		if errors.Is(err, errTooManyRedirects) {
+			log.Println(logPrefixErrorHere, color.HiRedString("Stopped after %d redirect%s requesting \"%s\": %s", maxRedirects, pluralS(maxRedirects),
+				download.InputURL, strings.Join(append([]string{download.InputURL}, redirects...), " -> ")))
+			return mDownloadStatus(downloadFailedRequesting, fmt.Errorf("more than %d redirect%s", maxRedirects, pluralS(maxRedirects)))
+		}
 		if err != nil {
 			if !strings.Contains(err.Error(), "no such host") && !strings.Contains(err.Error(), "connection refused") {
 				log.Println(logPrefixErrorHere, color.HiRedString("Error while receiving response from \"%s\": %s", download.InputURL, err))
@@ -639,19 +1387,107 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			return mDownloadStatus(downloadFailedDownloadingResponse, err)
 		}
 		defer response.Body.Close()
+		finalURL := ""
+		if response.Request.URL.String() != download.InputURL {
+			finalURL = response.Request.URL.String()
+		}
+		if settings.DebugOutput && len(redirects) > 0 {
+			log.Println(logPrefixDebug, color.CyanString("Redirected %s -> %s", download.InputURL, strings.Join(redirects, " -> ")))
+		}
 
-		// Read
-		bodyOfResp, err := ioutil.ReadAll(response.Body)
+		// Read, dry runs only need enough to sniff the content type
+		var bodyOfResp []byte
+		if download.DryRun {
+			bodyOfResp, err = ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		} else {
+			bodyOfResp, err = ioutil.ReadAll(newThrottledReader(response.Body))
+		}
+		downloadDuration := time.Since(requested)
+		releaseConnection()
 		if err != nil {
 			log.Println(logPrefixErrorHere, color.HiRedString("Could not read response from \"%s\": %s", download.InputURL, err))
 			return mDownloadStatus(downloadFailedReadResponse, err)
 		}
 
+		// Challenge pages come back as 403, 503 or even 200
+		if isBotChallenge(response, bodyOfResp) {
+			log.Println(logPrefixErrorHere, color.HiRedString("Bot challenge instead of file: %s", download.InputURL))
+			return mDownloadStatus(downloadFailedBotChallenge, fmt.Errorf("%s answered with a challenge page", request.URL.Hostname()))
+		}
+
 		// 404
 		if response.StatusCode == http.StatusNotFound {
 			log.Println(logPrefixErrorHere, color.HiRedString("FILE IS 404: %s", download.InputURL))
 			return mDownloadStatus(downloadFailed404, err)
 		}
+		// 403, Discord's answer to expired links
+		if response.StatusCode == http.StatusForbidden {
+			log.Println(logPrefixErrorHere, color.HiRedString("FILE IS 403: %s", download.InputURL))
+			return mDownloadStatus(downloadFailed403, err)
+		}
+		// 410
+		if response.StatusCode == http.StatusGone {
+			log.Println(logPrefixErrorHere, color.HiRedString("FILE IS 410: %s", download.InputURL))
+			return mDownloadStatus(downloadFailed410, err)
+		}
+
+		// Verify size, retried if the connection dropped partway. Content-Length is what was sent, before decoding.
+		if !download.DryRun && response.StatusCode < 300 && response.ContentLength > 0 && int64(len(bodyOfResp)) != response.ContentLength {
+			err = fmt.Errorf("received %d of %d bytes", len(bodyOfResp), response.ContentLength)
+			log.Println(logPrefixErrorHere, color.HiRedString("Incomplete download from \"%s\": %s", download.InputURL, err))
+			return mDownloadStatus(downloadFailedIncompleteBody, err)
+		}
+
+		// Decode, so what's sniffed, hashed and saved is the file and not the compressed copy of it
+		contentLength := response.ContentLength
+		if isResponseEncoded(response) {
+			encoding := response.Header.Get("Content-Encoding")
+			if bodyOfResp, err = decodeResponseBody(encoding, bodyOfResp, download.DryRun); err != nil {
+				log.Println(logPrefixErrorHere, color.HiRedString("Could not decode %s response from \"%s\": %s", encoding, download.InputURL, err))
+				return mDownloadStatus(downloadFailedReadResponse, err)
+			}
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.CyanString("Decoded %s response from %s", encoding, download.InputURL))
+			}
+			// Dry runs don't decode enough to know the size
+			contentLength = -1
+			if download.DryRun && len(bodyOfResp) > 512 {
+				bodyOfResp = bodyOfResp[:512]
+			}
+		}
+		if !download.DryRun && response.StatusCode < 300 && download.ExpectedSize > 0 && int64(len(bodyOfResp)) != download.ExpectedSize {
+			err = fmt.Errorf("received %d of %d bytes", len(bodyOfResp), download.ExpectedSize)
+			log.Println(logPrefixErrorHere, color.HiRedString("Incomplete download from \"%s\": %s", download.InputURL, err))
+			return mDownloadStatus(downloadFailedIncompleteBody, err)
+		}
+
+		// Size tiers, files over largeFileThreshold go under largeFileDestination instead, either is checked for existing files
+		otherRoot := ""
+		if largeRoot := getLargeFileRoot(channelConfig, download); largeRoot != "" {
+			size := int64(len(bodyOfResp))
+			if download.DryRun {
+				size = contentLength
+			}
+			otherRoot = largeRoot
+			if isLargeFile(channelConfig, size) {
+				if storage, err = getStorageBackend(largeRoot); err != nil {
+					log.Println(logPrefixErrorHere, color.HiRedString("Error while opening large file destination \"%s\": %s", largeRoot, err))
+					return mDownloadStatus(storageFailureStatus(err, downloadFailedInvalidPath), err)
+				}
+				pathSeparator = storage.separator()
+				otherRoot, download.Path = download.Path, strings.TrimRight(largeRoot, `/\`)+pathSeparator
+				if !download.DryRun {
+					if low, free := isDiskSpaceLow(download.Path); low {
+						return mDownloadStatus(downloadSkippedLowDiskSpace, fmt.Errorf("%s free in \"%s\", below minimumFreeSpace", formatBytes(free), download.Path))
+					}
+					err = storage.mkdirAll(download.Path)
+				}
+				if err != nil {
+					log.Println(logPrefixErrorHere, color.HiRedString("Error while creating large file destination folder \"%s\": %s", download.Path, err))
+					return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingFolder), err)
+				}
+			}
+		}
 
 		// Filename
 		if download.Filename == "" {
@@ -672,55 +1508,42 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			}
 		}
 
+		// Names taken from the input URL can be missing the extension the redirect shows
+		if filepath.Ext(download.Filename) == "" && finalURL != "" {
+			download.Filename += filepath.Ext(filenameFromURL(finalURL))
+		}
+
 		extension := strings.ToLower(filepath.Ext(download.Filename))
 
 		contentType := http.DetectContentType(bodyOfResp)
 		contentTypeParts := strings.Split(contentType, "/")
 		contentTypeFound := contentTypeParts[0]
 
-		parsedURL, err := url.Parse(download.InputURL)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Error while parsing url:\t%s", err))
-		}
-
 		// Check extension
-		if channelConfig.Filters.AllowedExtensions != nil || channelConfig.Filters.BlockedExtensions != nil {
-			shouldAbort := false
-			if channelConfig.Filters.AllowedExtensions != nil {
-				shouldAbort = true
+		if !isExtensionPermitted(channelConfig, extension) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted extension (%s) found at %s", extension, download.InputURL))
 			}
+			return mDownloadStatus(downloadSkippedUnpermittedExtension)
+		}
 
-			if channelConfig.Filters.BlockedExtensions != nil {
-				if stringInSlice(extension, *channelConfig.Filters.BlockedExtensions) {
-					shouldAbort = true
-				}
-			}
-			if channelConfig.Filters.AllowedExtensions != nil {
-				if stringInSlice(extension, *channelConfig.Filters.AllowedExtensions) {
-					shouldAbort = false
-				}
-			}
+		// Fix content type
+		contentTypeFound = fixContentType(extension, contentTypeFound)
+		isAnimated := contentTypeFound == "image" && isAnimatedImage(bodyOfResp)
 
-			// Abort
-			if shouldAbort {
-				if !download.HistoryCmd {
-					log.Println(logPrefixFileSkip, color.GreenString("Unpermitted extension (%s) found at %s", extension, download.InputURL))
+		// Login walls and "not found" pages sent with a 200, goes by what was sniffed before it's fixed
+		if reason := detectSoftFailure(download.InputURL, extension, contentType, bodyOfResp); reason != "" {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Soft failure (%s) found at %s", reason, download.InputURL))
+			}
+			if settings.DebugOutput {
+				preview := bodyOfResp
+				if len(preview) > 200 {
+					preview = preview[:200]
 				}
-				return mDownloadStatus(downloadSkippedUnpermittedExtension)
+				log.Println(logPrefixDebug, color.YellowString("Start of %s:\n%s", download.InputURL, preview))
 			}
-		}
-
-		// Fix content type
-		if stringInSlice(extension, []string{".mov"}) ||
-			stringInSlice(extension, []string{".mp4"}) ||
-			stringInSlice(extension, []string{".webm"}) {
-			contentTypeFound = "video"
-		} else if stringInSlice(extension, []string{".psd"}) ||
-			stringInSlice(extension, []string{".nef"}) ||
-			stringInSlice(extension, []string{".dng"}) ||
-			stringInSlice(extension, []string{".tif"}) ||
-			stringInSlice(extension, []string{".tiff"}) {
-			contentTypeFound = "image"
+			return mDownloadStatus(downloadSkippedWrongContent, fmt.Errorf("soft failure, %s", reason))
 		}
 
 		// Filename extension fix
@@ -731,75 +1554,207 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			}
 		}
 
-		// Check Domain
-		if channelConfig.Filters.AllowedDomains != nil || channelConfig.Filters.BlockedDomains != nil {
-			shouldAbort := false
-			if channelConfig.Filters.AllowedDomains != nil {
-				shouldAbort = true
+		// Check Domain, before and after redirects
+		if domain := getUnpermittedDomain(channelConfig, download.InputURL, response.Request.URL); domain != "" {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted domain (%s) found at %s", domain, download.InputURL))
 			}
+			return mDownloadStatus(downloadSkippedUnpermittedDomain)
+		}
 
-			if channelConfig.Filters.BlockedDomains != nil {
-				if stringInSlice(parsedURL.Hostname(), *channelConfig.Filters.BlockedDomains) {
-					shouldAbort = true
-				}
+		// Check size
+		fileSize := int64(len(bodyOfResp))
+		if download.DryRun {
+			fileSize = contentLength
+		}
+		if !isSizePermitted(channelConfig, fileSize) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted size (%s) found at %s", formatBytes(fileSize), download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedSize)
+		}
+
+		// Check content type, archives being extracted are filtered by their contents instead. Emojis and stickers
+		// are saved whatever they are, Lottie stickers are JSON
+		if !download.EmojiCmd && !isContentTypePermitted(channelConfig, contentTypeFound) && !isExtractableArchive(channelConfig, extension, contentType) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted filetype (%s) found at %s", contentTypeFound, download.InputURL))
 			}
-			if channelConfig.Filters.AllowedDomains != nil {
-				if stringInSlice(parsedURL.Hostname(), *channelConfig.Filters.AllowedDomains) {
-					shouldAbort = false
+			return mDownloadStatus(downloadSkippedUnpermittedType)
+		}
+		if isAnimated && !*channelConfig.Filters.SaveAnimatedImages && !download.EmojiCmd {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted animated image found at %s", download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedType)
+		}
+
+		// Check MIME type, HTML is almost always a page a handler couldn't get the file out of
+		mimeType := getFilteredMIMEType(channelConfig, contentType, response.Header.Get("Content-Type"))
+		if (*channelConfig.Filters.SkipHTML && mimeType == "text/html") || !isMIMETypePermitted(channelConfig, mimeType) {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted MIME type (%s) found at %s", mimeType, download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedUnpermittedType)
+		}
+
+		// Placeholders, checked as served and before they can get into the duplicate filter
+		if reason := detectPlaceholder(response.Request.URL.String(), bodyOfResp, contentTypeFound == "image" && !download.DryRun); reason != "" {
+			if !download.HistoryCmd {
+				log.Println(logPrefixFileSkip, color.GreenString("Placeholder image (%s) found at %s", reason, download.InputURL))
+			}
+			return mDownloadStatus(downloadSkippedPlaceholder, fmt.Errorf("placeholder image, %s", reason))
+		}
+
+		// Dimensions, as served, dry runs only have them if the header fit in what was read
+		if contentTypeFound == "image" && (channelConfig.Filters.MinWidth != nil || channelConfig.Filters.MinHeight != nil) {
+			if imageConfig, _, err := image.DecodeConfig(bytes.NewReader(bodyOfResp)); err == nil &&
+				!isDimensionPermitted(channelConfig, imageConfig.Width, imageConfig.Height) {
+				if !download.HistoryCmd {
+					log.Println(logPrefixFileSkip, color.GreenString("Unpermitted dimensions (%dx%d) found at %s", imageConfig.Width, imageConfig.Height, download.InputURL))
 				}
+				return mDownloadStatus(downloadSkippedUnpermittedDimensions)
 			}
+		}
 
-			// Abort
-			if shouldAbort {
+		// Mostly text, like screenshots. Needs the full image so dry runs can't check it
+		var textCheck *imageTextCheck
+		if contentTypeFound == "image" && *channelConfig.Filters.SkipTextHeavyImages && settings.TesseractPath != "" && !download.DryRun {
+			var textHeavy bool
+			if textHeavy, textCheck = isTextHeavyImage(channelConfig, bodyOfResp, download.InputURL); textHeavy {
+				reason := fmt.Sprintf("text covers %.0f%% of it (%.0f%% confidence)", textCheck.coverage*100, textCheck.confidence*100)
 				if !download.HistoryCmd {
-					log.Println(logPrefixFileSkip, color.GreenString("Unpermitted domain (%s) found at %s", parsedURL.Hostname(), download.InputURL))
+					log.Println(logPrefixFileSkip, color.GreenString("Text heavy image, %s, found at %s", reason, download.InputURL))
 				}
-				return mDownloadStatus(downloadSkippedUnpermittedDomain)
+				return mDownloadStatus(downloadSkippedTextImage, errors.New(reason))
 			}
 		}
 
-		// Check content type
-		if !((*channelConfig.SaveImages && contentTypeFound == "image") ||
-			(*channelConfig.SaveVideos && contentTypeFound == "video") ||
-			(*channelConfig.SaveAudioFiles && contentTypeFound == "audio") ||
-			(*channelConfig.SaveTextFiles && contentTypeFound == "text") ||
-			(*channelConfig.SaveOtherFiles && contentTypeFound == "application")) {
-			if !download.HistoryCmd {
-				log.Println(logPrefixFileSkip, color.GreenString("Unpermitted filetype (%s) found at %s", contentTypeFound, download.InputURL))
+		// Convert, before hashing so the converted file is what gets deduplicated and saved
+		originalExtension := ""
+		if !download.DryRun {
+			var convertedExtension string
+			bodyOfResp, convertedExtension = convertDownload(bodyOfResp, extension, channelConfig)
+			if convertedExtension != extension {
+				originalExtension = extension
+				download.Filename = strings.TrimSuffix(download.Filename, filepath.Ext(download.Filename)) + convertedExtension
+				extension = convertedExtension
+				contentType = http.DetectContentType(bodyOfResp)
+				contentTypeFound = fixContentType(extension, strings.Split(contentType, "/")[0])
+				isAnimated = contentTypeFound == "image" && isAnimatedImage(bodyOfResp)
 			}
-			return mDownloadStatus(downloadSkippedUnpermittedType)
 		}
 
-		// Duplicate Image Filter
-		if config.FilterDuplicateImages && contentTypeFound == "image" && extension != ".gif" && extension != ".webp" {
-			img, _, err := image.Decode(bytes.NewReader(bodyOfResp))
+		// Metadata, after converting so converted images get it too
+		if !download.DryRun && contentTypeFound == "image" && (*channelConfig.StripEXIF || *channelConfig.EmbedSourceMetadata) {
+			var source *sourceMetadata
+			if *channelConfig.EmbedSourceMetadata {
+				source = getSourceMetadata(download.Message)
+			}
+			bodyOfResp = processImageMetadata(bodyOfResp, *channelConfig.StripEXIF, source)
+		}
+
+		// Duplicate Image Filter, needs the full image so dry runs can't check it.
+		// Added to the store once saved, keyed by where it was saved.
+		// Duplicates may still be saved or linked to the original depending on duplicateAction.
+		var imageHash *duplo.Hash
+		duplicateOf := ""
+		if settings.FilterDuplicateImages && imgStore != nil && !download.DryRun && contentTypeFound == "image" {
+			// Until it's added, so a rebuild doesn't swap the store out in between
+			imgStoreSwapMutex.RLock()
+			defer imgStoreSwapMutex.RUnlock()
+			hash, err := hashImage(bodyOfResp)
 			if err != nil {
 				log.Println(color.HiRedString("Error converting buffer to image for hashing:\t%s", err))
 			} else {
-				hash, _ := duplo.CreateHash(img)
-				matches := imgStore.Query(hash)
-				sort.Sort(matches)
-				for _, match := range matches {
-					/*if config.DebugOutput {
-						log.Println(color.YellowString("Similarity Score: %f", match.Score))
-					}*/
-					if match.Score < config.FilterDuplicateImagesThreshold {
-						log.Println(logPrefixFileSkip, color.GreenString("Duplicate detected (Score of %f) found at %s", match.Score, download.InputURL))
+				if match := findDuplicateImage(hash); match != nil {
+					log.Println(logPrefixFileSkip, color.GreenString("Duplicate detected (Score of %f) found at %s", match.Score, download.InputURL))
+					original, _ := match.ID.(string) // older stores used download numbers, which can't be linked
+					var skip bool
+					if duplicateOf, skip = handleDuplicate(channelConfig, download.Path, original); skip {
 						return mDownloadStatus(downloadSkippedDetectedDuplicate)
 					}
 				}
-				imgStore.Add(cachedDownloadID, hash)
+				if duplicateOf == "" {
+					imageHash = &hash
+				}
+			}
+		}
+
+		// Files already under the destination the database doesn't know about, e.g. copied in from elsewhere
+		contentHash := hashBytes(bodyOfResp)
+		if *channelConfig.CheckFilesystemForDuplicates && !download.DryRun && duplicateOf == "" {
+			if existing := findFilesystemDuplicate(download.Path, contentHash, int64(len(bodyOfResp))); existing != "" {
+				if !download.HistoryCmd {
+					log.Println(logPrefixFileSkip, color.GreenString("Already on disk as \"%s\", found at %s", existing, download.InputURL))
+				}
+				// Recorded as if it had been saved there, so it isn't downloaded to find that out again
+				if *channelConfig.RecordFilesystemDuplicates && download.Message.ID != "" {
+					userID := user.ID
+					if download.Message.Author != nil {
+						userID = download.Message.Author.ID
+					}
+					record := downloadItem{
+						URL:         download.InputURL,
+						Time:        time.Now(),
+						Destination: existing,
+						Filename:    filepath.Base(existing),
+						ChannelID:   download.Message.ChannelID,
+						UserID:      userID,
+						MessageID:   download.Message.ID,
+						GuildID:     download.Message.GuildID,
+						Hash:        contentHash,
+						Size:        int64(len(bodyOfResp)),
+						FileSize:    int64(len(bodyOfResp)),
+					}
+					var err error
+					if recordedRow >= 0 {
+						err = dbReplaceDownload(recordedRow, &record)
+					} else {
+						err = dbInsertDownload(&record)
+					}
+					if err != nil {
+						log.Println(logPrefixErrorHere, color.HiRedString("Error recording \"%s\" in the database:\t%s", existing, err))
+					}
+				}
+				return mDownloadStatus(downloadSkippedDetectedDuplicate)
+			}
+		}
+
+		// Duplicate Video Filter, exact copies by hash and re-encodes by sampled frames when ffmpeg is available
+		var videoHashes []duplo.Hash
+		if settings.FilterDuplicateVideos && !download.DryRun && contentTypeFound == "video" {
+			original := ""
+			if matches := dbFindDownloadByHash(contentHash); len(matches) > 0 {
+				original = matches[0].Destination
+				log.Println(logPrefixFileSkip, color.GreenString("Duplicate detected (exact copy of %s) found at %s", original, download.InputURL))
+			} else if vidStore != nil {
+				hashes, err := hashVideo(bodyOfResp, extension)
+				if err != nil {
+					log.Println(color.HiRedString("Error sampling video frames for hashing:\t%s", err))
+				} else if original = findDuplicateVideo(hashes); original != "" {
+					log.Println(logPrefixFileSkip, color.GreenString("Duplicate detected (similar to %s) found at %s", original, download.InputURL))
+				} else {
+					videoHashes = hashes
+				}
+			}
+			if original != "" {
+				var skip bool
+				if duplicateOf, skip = handleDuplicate(channelConfig, download.Path, original); skip {
+					return mDownloadStatus(downloadSkippedDetectedDuplicate)
+				}
 			}
 		}
 
 		// Names
-		sourceChannelName := download.Message.ChannelID
+		folderChannelID := getFolderChannelID(download, channelConfig)
+		sourceChannelName := folderChannelID
 		sourceName := "UNKNOWN"
-		sourceChannel, _ := bot.State.Channel(download.Message.ChannelID)
+		sourceChannel, _ := bot.State.Channel(folderChannelID)
 		if sourceChannel != nil {
-			// Channel Naming
+			// Channel Naming, folders keep the first name seen so renames don't split them
 			if sourceChannel.Name != "" {
-				sourceChannelName = sourceChannel.Name
+				sourceChannelName = getStableName("channel", sourceChannel.ID, sourceChannel.Name)
 			}
 			switch sourceChannel.Type {
 			case discordgo.ChannelTypeGuildText:
@@ -807,7 +1762,7 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 				if sourceChannel.GuildID != "" {
 					sourceGuild, _ := bot.State.Guild(sourceChannel.GuildID)
 					if sourceGuild != nil && sourceGuild.Name != "" {
-						sourceName = "\"" + sourceGuild.Name + "\""
+						sourceName = "\"" + getStableName("server", sourceGuild.ID, sourceGuild.Name) + "\""
 					}
 				}
 				// Category Naming
@@ -815,7 +1770,7 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 					sourceParent, _ := bot.State.Channel(sourceChannel.ParentID)
 					if sourceParent != nil {
 						if sourceParent.Name != "" {
-							sourceChannelName = sourceParent.Name + " - " + sourceChannelName
+							sourceChannelName = getStableName("category", sourceParent.ID, sourceParent.Name) + " - " + sourceChannelName
 						}
 					}
 				}
@@ -832,19 +1787,19 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			if *channelConfig.DivideFoldersByServer {
 				subfolderSuffix := ""
 				if sourceName != "" && sourceName != "UNKNOWN" {
-					subfolderSuffix = sourceName
-					for _, key := range pathBlacklist {
-						subfolderSuffix = strings.ReplaceAll(subfolderSuffix, key, "")
-					}
+					subfolderSuffix = sanitizeFolderName(sourceName, sourceChannel.GuildID)
 				}
 				if subfolderSuffix != "" {
-					subfolderSuffix = subfolderSuffix + string(os.PathSeparator)
+					subfolderSuffix = subfolderSuffix + pathSeparator
 					subfolder = subfolder + subfolderSuffix
 					// Create folder.
-					err := os.MkdirAll(download.Path+subfolder, 0755)
+					var err error
+					if !download.DryRun {
+						err = storage.mkdirAll(download.Path + subfolder)
+					}
 					if err != nil {
 						log.Println(logPrefixErrorHere, color.HiRedString("Error while creating server subfolder \"%s\": %s", download.Path, err))
-						return mDownloadStatus(downloadFailedCreatingSubfolder, err)
+						return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingSubfolder), err)
 					}
 				}
 			}
@@ -852,18 +1807,18 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			if *channelConfig.DivideFoldersByChannel {
 				subfolderSuffix := ""
 				if sourceChannelName != "" {
-					subfolderSuffix = sourceChannelName
-					for _, key := range pathBlacklist {
-						subfolderSuffix = strings.ReplaceAll(subfolderSuffix, key, "")
-					}
+					subfolderSuffix = sanitizeFolderName(sourceChannelName, folderChannelID)
 				}
 				if subfolderSuffix != "" {
-					subfolder = subfolder + subfolderSuffix + string(os.PathSeparator)
+					subfolder = subfolder + subfolderSuffix + pathSeparator
 					// Create folder.
-					err := os.MkdirAll(download.Path+subfolder, 0755)
+					var err error
+					if !download.DryRun {
+						err = storage.mkdirAll(download.Path + subfolder)
+					}
 					if err != nil {
 						log.Println(logPrefixErrorHere, color.HiRedString("Error while creating channel subfolder \"%s\": %s", download.Path, err))
-						return mDownloadStatus(downloadFailedCreatingSubfolder, err)
+						return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingSubfolder), err)
 					}
 				}
 			}
@@ -872,21 +1827,36 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			if *channelConfig.DivideFoldersByUser {
 				subfolderSuffix := download.Message.Author.ID
 				if download.Message.Author.Username != "" {
-					subfolderSuffix = download.Message.Author.Username + "#" + download.Message.Author.Discriminator
-					for _, key := range pathBlacklist {
-						subfolderSuffix = strings.ReplaceAll(subfolderSuffix, key, "")
-					}
+					subfolderSuffix = sanitizeFolderName(download.Message.Author.Username+"#"+download.Message.Author.Discriminator,
+						download.Message.Author.ID)
 				}
 				if subfolderSuffix != "" {
-					subfolder = subfolder + subfolderSuffix + string(os.PathSeparator)
+					subfolder = subfolder + subfolderSuffix + pathSeparator
 					// Create folder.
-					err := os.MkdirAll(download.Path+subfolder, 0755)
+					var err error
+					if !download.DryRun {
+						err = storage.mkdirAll(download.Path + subfolder)
+					}
 					if err != nil {
 						log.Println(logPrefixErrorHere, color.HiRedString("Error while creating user subfolder \"%s\": %s", download.Path, err))
-						return mDownloadStatus(downloadFailedCreatingSubfolder, err)
+						return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingSubfolder), err)
 					}
 				}
 			}
+
+			// Subfolder Division - Artist Nesting
+			if *channelConfig.DivideFoldersByArtist {
+				subfolder = subfolder + getArtistFolder(download) + pathSeparator
+				// Create folder.
+				var err error
+				if !download.DryRun {
+					err = storage.mkdirAll(download.Path + subfolder)
+				}
+				if err != nil {
+					log.Println(logPrefixErrorHere, color.HiRedString("Error while creating artist subfolder \"%s\": %s", download.Path+subfolder, err))
+					return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingSubfolder), err)
+				}
+			}
 		}
 
 		// Subfolder Division - Content Type
@@ -895,6 +1865,9 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			switch contentTypeFound {
 			case "image":
 				subfolderSuffix = "images"
+				if isAnimated {
+					subfolderSuffix = "animated"
+				}
 			case "video":
 				subfolderSuffix = "videos"
 			case "audio":
@@ -905,18 +1878,21 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 				subfolderSuffix = "applications"
 			}
 			if subfolderSuffix != "" {
-				subfolder = subfolder + subfolderSuffix + string(os.PathSeparator)
+				subfolder = subfolder + subfolderSuffix + pathSeparator
 				// Create folder.
-				err := os.MkdirAll(download.Path+subfolder, 0755)
+				var err error
+				if !download.DryRun {
+					err = storage.mkdirAll(download.Path + subfolder)
+				}
 				if err != nil {
 					log.Println(logPrefixErrorHere, color.HiRedString("Error while creating type subfolder \"%s\": %s", download.Path+subfolder, err))
-					return mDownloadStatus(downloadFailedCreatingSubfolder, err)
+					return mDownloadStatus(storageFailureStatus(err, downloadFailedCreatingSubfolder), err)
 				}
 			}
 		}
 
 		// Format filename/path
-		filenameDateFormat := config.FilenameDateFormat
+		filenameDateFormat := settings.FilenameDateFormat
 		if channelConfig.OverwriteFilenameDateFormat != nil {
 			if *channelConfig.OverwriteFilenameDateFormat != "" {
 				filenameDateFormat = *channelConfig.OverwriteFilenameDateFormat
@@ -930,17 +1906,64 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			}
 		}
 		completePath := download.Path + subfolder + messageTime.Format(filenameDateFormat) + download.Filename
+		if channelConfig.FilenameFormat != nil && *channelConfig.FilenameFormat != "" {
+			completePath = download.Path + subfolder + formatFilename(*channelConfig.FilenameFormat, messageTime.Format(filenameDateFormat), download)
+		}
+		if duplicateOf != "" {
+			// A link has the original's contents, so it gets the original's extension too
+			completePath = strings.TrimSuffix(completePath, filepathExtension(completePath)) + filepathExtension(duplicateOf)
+		}
 
-		// Check if exists
-		if _, err := os.Stat(completePath); err == nil {
-			if *channelConfig.SavePossibleDuplicates {
+		// Compress, as name.ext.zst when it's worth it. Links and blobs are left as they are.
+		var compressed []byte
+		if duplicateOf == "" && getStorageMode(channelConfig, completePath) == storageModePlain {
+			if compressed = compressForSaving(channelConfig, extension, bodyOfResp); compressed != nil {
+				completePath += zstdExtension
+			}
+		}
+
+		// Check if exists, under the other size tier's root too
+		existingStorage, existingPath, exists, err := findTieredFile(storage, completePath, download.Path, otherRoot)
+		if err != nil {
+			log.Println(logPrefixErrorHere, color.HiRedString("Error while checking for existing file \"%s\": %s", completePath, err))
+			return mDownloadStatus(storageFailureStatus(err, downloadFailedWritingFile), err)
+		}
+		if exists && *channelConfig.CollisionStrategy == "id" {
+			// Same contents is the same file, anything else is kept apart by where it came from so re-runs land on the same name
+			if !download.DryRun && isSameContent(existingStorage, existingPath, contentHash, int64(len(bodyOfResp))) {
+				if !download.HistoryCmd {
+					log.Println(logPrefixFileSkip, color.GreenString("Matching filename and contents, already saved as \"%s\"", existingPath))
+				}
+				return mDownloadStatus(downloadSkippedDuplicate)
+			}
+			id := download.AttachmentID
+			if id == "" {
+				id = download.Message.ID
+			}
+			if id != "" {
+				tmpPath := completePath
+				completePath = strings.TrimSuffix(tmpPath, savedExtension(tmpPath)) + "-" + id + savedExtension(tmpPath)
+				if existingStorage, existingPath, exists, err = findTieredFile(storage, completePath, download.Path, otherRoot); err != nil {
+					log.Println(logPrefixErrorHere, color.HiRedString("Error while checking for existing file \"%s\": %s", completePath, err))
+					return mDownloadStatus(storageFailureStatus(err, downloadFailedWritingFile), err)
+				}
+				if exists && !download.DryRun && isSameContent(existingStorage, existingPath, contentHash, int64(len(bodyOfResp))) {
+					return mDownloadStatus(downloadSkippedDuplicate)
+				}
+				if !exists && !download.HistoryCmd {
+					log.Println(color.GreenString("Matching filename with different contents, saving \"%s\" as \"%s\"", tmpPath, completePath))
+				}
+			}
+		}
+		if exists {
+			if *channelConfig.SavePossibleDuplicates || *channelConfig.CollisionStrategy == "id" {
 				tmpPath := completePath
 				i := 1
 				for {
 					// Append number to name
-					completePath = tmpPath[0:len(tmpPath)-len(filepathExtension(tmpPath))] +
-						"-" + strconv.Itoa(i) + filepathExtension(tmpPath)
-					if _, err := os.Stat(completePath); os.IsNotExist(err) {
+					completePath = tmpPath[0:len(tmpPath)-len(savedExtension(tmpPath))] +
+						"-" + strconv.Itoa(i) + savedExtension(tmpPath)
+					if _, _, exists, err := findTieredFile(storage, completePath, download.Path, otherRoot); !exists || err != nil {
 						break
 					}
 					i = i + 1
@@ -956,42 +1979,181 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			}
 		}
 
-		// Write
-		err = ioutil.WriteFile(completePath, bodyOfResp, 0644)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.HiRedString("Error while writing file to disk \"%s\": %s", download.InputURL, err))
-			return mDownloadStatus(downloadFailedWritingFile, err)
+		// Verify image data is readable
+		if settings.ValidateImages && !download.DryRun && contentTypeFound == "image" {
+			if _, _, err := image.DecodeConfig(bytes.NewReader(bodyOfResp)); err != nil && err != image.ErrFormat {
+				log.Println(logPrefixErrorHere, color.HiRedString("Corrupt image from \"%s\": %s", download.InputURL, err))
+				return mDownloadStatus(downloadFailedIncompleteBody, err)
+			}
 		}
 
-		// Change file time
-		err = os.Chtimes(completePath, download.FileTime, download.FileTime)
-		if err != nil {
-			log.Println(logPrefixErrorHere, color.RedString("Error while changing metadata date \"%s\": %s", download.InputURL, err))
+		// Dry run stops short of writing anything
+		if download.DryRun {
+			size := "unknown size"
+			if contentLength >= 0 {
+				size = formatBytes(contentLength)
+			}
+			if !download.HistoryQuiet {
+				log.Println(logPrefix + color.GreenString("DRY RUN: Would save %s (%s) sent in %s#%s to \"%s\"", strings.ToUpper(contentTypeFound), size, sourceName, sourceChannelName, completePath))
+			}
+			status := mDownloadStatus(downloadSuccess)
+			status.Size = contentLength
+			return status
 		}
 
-		// Output
-		log.Println(logPrefix + color.HiGreenString("SAVED %s sent in %s#%s to \"%s\"", strings.ToUpper(contentTypeFound), sourceName, sourceChannelName, completePath))
+		// Write, or link to the original for duplicates or to the blob it's stored as
+		domain := response.Request.URL.Hostname()
+		transfer := fmt.Sprintf("%s in %s from %s", formatBytes(int64(len(bodyOfResp))), downloadDuration.Round(time.Millisecond), domain)
+		blobPath := ""
+		bytesWritten := int64(len(bodyOfResp))
+		if duplicateOf != "" {
+			linkedAs, err := linkDuplicate(duplicateOf, completePath, *channelConfig.DuplicateAction)
+			if err != nil {
+				log.Println(logPrefixErrorHere, color.HiRedString("Error while linking duplicate \"%s\" to \"%s\": %s", completePath, duplicateOf, err))
+				return mDownloadStatus(downloadFailedWritingFile, err)
+			}
+			if !download.HistoryQuiet {
+				log.Println(logPrefix + color.HiGreenString("LINKED %s sent in %s#%s to \"%s\" (%s of \"%s\", %s)", strings.ToUpper(contentTypeFound), sourceName, sourceChannelName, completePath, linkedAs, duplicateOf, transfer))
+			}
+		} else if getStorageMode(channelConfig, completePath) == storageModeCAS {
+			var written bool
+			blobPath, written, err = placeBlob(completePath, bodyOfResp, contentHash)
+			if err != nil {
+				log.Println(logPrefixErrorHere, color.HiRedString("Error while storing \"%s\" as a blob: %s", completePath, err))
+				return mDownloadStatus(storageFailureStatus(err, downloadFailedWritingFile), err)
+			}
+			if !written {
+				bytesWritten = 0
+			}
+			if !download.HistoryQuiet {
+				log.Println(logPrefix + color.HiGreenString("SAVED %s sent in %s#%s to \"%s\" (blob \"%s\", %s)", strings.ToUpper(contentTypeFound), sourceName, sourceChannelName, completePath, blobPath, transfer))
+			}
+		} else {
+			data := bodyOfResp
+			if compressed != nil {
+				data, bytesWritten = compressed, int64(len(compressed))
+			}
+			err = storage.write(completePath, data, download.FileTime)
+			if errors.Is(err, errIncompleteWrite) {
+				log.Println(logPrefixErrorHere, color.HiRedString("Incomplete write of \"%s\": %s", completePath, err))
+				return mDownloadStatus(downloadFailedIncompleteBody, err)
+			} else if err != nil {
+				log.Println(logPrefixErrorHere, color.HiRedString("Error while writing file to disk \"%s\": %s", download.InputURL, err))
+				return mDownloadStatus(storageFailureStatus(err, downloadFailedWritingFile), err)
+			}
+
+			// Output
+			if !download.HistoryQuiet {
+				if compressed != nil {
+					log.Println(logPrefix + color.HiGreenString("SAVED %s sent in %s#%s to \"%s\" (%s, compressed to %s)", strings.ToUpper(contentTypeFound), sourceName, sourceChannelName, completePath,
+						transfer, formatBytes(bytesWritten)))
+				} else {
+					log.Println(logPrefix + color.HiGreenString("SAVED %s sent in %s#%s to \"%s\" (%s)", strings.ToUpper(contentTypeFound), sourceName, sourceChannelName, completePath, transfer))
+				}
+			}
+		}
 
 		userID := user.ID
 		if download.Message.Author != nil {
 			userID = download.Message.Author.ID
 		}
-		// Store in db
-		err = dbInsertDownload(&downloadItem{
+
+		// Post download command, blocking ones finish before the download is recorded
+		handlePostDownloadCommand(channelConfig, postDownloadInfo{
+			Path:        completePath,
 			URL:         download.InputURL,
-			Time:        time.Now(),
-			Destination: completePath,
-			Filename:    download.Filename,
 			ChannelID:   download.Message.ChannelID,
 			UserID:      userID,
+			ContentType: contentType,
 		})
+
+		// Thumbnail, a file without one is still downloaded
+		thumbnailPath := ""
+		if *channelConfig.GenerateThumbnails && isThumbnailable(contentTypeFound) && !isRemoteDestination(completePath) {
+			if thumbnailPath, err = generateThumbnail(completePath, contentTypeFound); err != nil {
+				log.Println(logPrefixThumbnails, color.HiRedString("Couldn't make a thumbnail for \"%s\":\t%s", completePath, err))
+				thumbnailPath = ""
+			}
+		}
+
+		// Store in db
+		record := downloadItem{
+			URL:                download.InputURL,
+			FinalURL:           finalURL,
+			Time:               time.Now(),
+			Destination:        completePath,
+			DestinationRoot:    download.Path,
+			Filename:           download.Filename,
+			ChannelID:          download.Message.ChannelID,
+			UserID:             userID,
+			MessageID:          download.Message.ID,
+			GuildID:            download.Message.GuildID,
+			Content:            download.Message.Content,
+			OriginalExtension:  originalExtension,
+			Hash:               contentHash,
+			LinkedTo:           duplicateOf,
+			AltText:            download.AltText,
+			EmbedTitle:         download.EmbedTitle,
+			Artist:             download.Post.Artist,
+			PostID:             download.Post.PostID,
+			PostTitle:          download.Post.PostTitle,
+			Tags:               getMessageTags(download.Message.ChannelID),
+			IsNSFW:             download.NSFW,
+			IsAnimated:         isAnimated,
+			BlobPath:           blobPath,
+			FileSize:           int64(len(bodyOfResp)),
+			DownloadDurationMs: downloadDuration.Milliseconds(),
+			Domain:             domain,
+			ThumbnailPath:      thumbnailPath,
+		}
+		record.Reactions, record.ReactionCount = getReactionCounts(download.Message.Reactions)
+		if textCheck != nil {
+			record.TextCoverage, record.TextConfidence = textCheck.coverage, textCheck.confidence
+		}
+		// Saved from Discord's copy, it's still looked up by the link from the message
+		if download.SourceURL != "" {
+			record.URL, record.DownloadedFrom = download.SourceURL, download.InputURL
+		}
+		if duplicateOf == "" {
+			record.Size = bytesWritten
+		}
+		if compressed != nil {
+			record.OriginalSize, record.CompressedSize = int64(len(bodyOfResp)), bytesWritten
+		}
+		if recordedRow >= 0 {
+			err = dbReplaceDownload(recordedRow, &record)
+		} else {
+			err = dbInsertDownload(&record)
+		}
 		if err != nil {
 			log.Println(logPrefixErrorHere, color.HiRedString("Error writing to database: %s", err))
 			return mDownloadStatus(downloadFailedWritingDatabase, err)
 		}
+		if imageHash != nil {
+			addToImgStore(completePath, *imageHash)
+		}
+		if videoHashes != nil {
+			addToVidStore(completePath, videoHashes)
+		}
+		if duplicateOf == "" && blobPath == "" && compressed == nil && !isRemoteDestination(completePath) {
+			addToFilesystemIndex(completePath, contentHash)
+		}
+
+		// Extract archive, failures are logged but the archive itself still counts as downloaded
+		if duplicateOf == "" && isExtractableArchive(channelConfig, extension, contentType) {
+			extracted, ok := extractArchive(storage, completePath, bodyOfResp, channelConfig, record)
+			if !download.HistoryQuiet {
+				log.Println(logPrefixArchive, color.HiGreenString("Extracted %d file%s from \"%s\"", extracted, pluralS(extracted), completePath))
+			}
+			if ok && *channelConfig.DeleteExtractedArchives {
+				if err := storage.remove(completePath); err != nil {
+					log.Println(logPrefixArchive, color.HiRedString("Failed to delete \"%s\": %s", completePath, err))
+				}
+			}
+		}
 
 		// React
-		shouldReact := config.ReactWhenDownloaded
+		shouldReact := settings.ReactWhenDownloaded
 		if channelConfig.ReactWhenDownloaded != nil {
 			shouldReact = *channelConfig.ReactWhenDownloaded
 		}
@@ -1000,6 +2162,9 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 				shouldReact = false
 			}
 		}
+		if digestReplacesMessages(download.Message.ChannelID) || !canReact() || download.APIRequest {
+			shouldReact = false
+		}
 		if download.Message.Author != nil && shouldReact {
 			reaction := ""
 			if *channelConfig.ReactWhenDownloadedEmoji == "" {
@@ -1029,48 +2194,30 @@ func tryDownload(download downloadRequestStruct) downloadStatusStruct {
 			} else {
 				reaction = *channelConfig.ReactWhenDownloadedEmoji
 			}
-			// Add Reaction
+			// Add Reaction, sent from its own queue so it can't hold up downloads
 			if hasPerms(download.Message.ChannelID, discordgo.PermissionAddReactions) {
-				err = bot.MessageReactionAdd(download.Message.ChannelID, download.Message.ID, reaction)
-				if err != nil {
-					log.Println(logPrefixErrorHere, color.RedString("Error adding reaction to message: %s", err))
-				}
+				queueReaction(download.Message.ChannelID, download.Message.ID, reaction, download.HistoryCmd)
 			} else {
 				log.Println(logPrefixErrorHere, color.RedString("Bot does not have permission to add reactions in %s", download.Message.ChannelID))
 			}
 		}
 
+		status := mDownloadStatus(downloadSuccess)
+		status.Saved = &record
+		if duplicateOf == "" {
+			status.Size = int64(len(bodyOfResp))
+		}
+		recordPresenceDownload(download.Message.ChannelID, record.Filename, status.Size)
+		recordQuotaUsage(download.Message.ChannelID, userID, record.Size)
+
 		if !download.HistoryCmd {
 			timeLastUpdated = time.Now()
 			if *channelConfig.UpdatePresence {
-				updateDiscordPresence()
-			}
-		}
-
-		if thisDownloadID > 0 {
-			// Filter Duplicate Images
-			if config.FilterDuplicateImages {
-				encodedStore, err := imgStore.GobEncode()
-				if err != nil {
-					log.Println(color.HiRedString("Failed to encode imgStore:\t%s"))
-				} else {
-					f, err := os.OpenFile(imgStorePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-					if err != nil {
-						log.Println(color.HiRedString("Failed to open imgStore file:\t%s"))
-					}
-					_, err = f.Write(encodedStore)
-					if err != nil {
-						log.Println(color.HiRedString("Failed to update imgStore file:\t%s"))
-					}
-					err = f.Close()
-					if err != nil {
-						log.Println(color.HiRedString("Failed to close imgStore file:\t%s"))
-					}
-				}
+				queuePresenceUpdate()
 			}
 		}
 
-		return mDownloadStatus(downloadSuccess)
+		return status
 	}
 
 	return mDownloadStatus(downloadIgnored)
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Swaps every site handler's fetch for one that only says which handler it was, keeping the order and matching.
+// Returns the URLs each handler was asked for.
+func stubSiteHandlers(t *testing.T, handlers []siteHandler) func() map[string][]string {
+	t.Helper()
+	var mutex sync.Mutex
+	calls := make(map[string][]string)
+	stubbed := make([]siteHandler, len(handlers))
+	for i, handler := range handlers {
+		name := handler.name
+		handler.fetch = func(inputURL string, _ string, _ int) (map[string]string, error) {
+			mutex.Lock()
+			calls[name] = append(calls[name], inputURL)
+			mutex.Unlock()
+			return map[string]string{"stub://" + name: ""}, nil
+		}
+		stubbed[i] = handler
+	}
+
+	previousHandlers := siteHandlers
+	siteHandlers = stubbed
+	t.Cleanup(func() { siteHandlers = previousHandlers })
+	useTestConfig(t, func(settings *configuration) { settings.HandlerCacheDuration = "0" })
+	return func() map[string][]string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls
+	}
+}
+
+// Each site's links still reach its own handler, and only that one.
+func TestDispatchSiteHandlers(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) { settings.Credentials.GoogleDriveCredentialsJSON = "{}" })
+	calls := stubSiteHandlers(t, siteHandlers)
+
+	tests := []struct {
+		inputURL string
+		handler  string
+	}{
+		{"https://pbs.twimg.com/media/ABC123.jpg", "twitter"},
+		{"https://twitter.com/user/status/1234567890", "twitterStatus"},
+		{"https://x.com/user/status/1234567890", "twitterStatus"},
+		{"https://nitter.net/user/status/1234567890#m", "twitterStatus"},
+		{"https://www.instagram.com/p/ABC123/", "instagram"},
+		{"https://imgur.com/AbC1234", "imgur"},
+		{"https://imgur.com/a/XyZ12", "imgurAlbum"},
+		{"https://streamable.com/abc12", "streamable"},
+		{"https://gfycat.com/SomeGfyName", "gfycat"},
+		{"https://www.flickr.com/photos/12345678@N00/9999", "flickr"},
+		{"https://www.flickr.com/photos/12345678@N00/albums/72157", "flickrAlbum"},
+		{"https://drive.google.com/file/d/abcDEF123/view", "googleDrive"},
+		{"https://drive.google.com/drive/folders/abcDEF123", "googleDriveFolder"},
+		{"https://www.reddit.com/r/pics/comments/abc123/a_title/", "reddit"},
+		{"https://rxddit.com/r/pics/comments/abc123/a_title/", "reddit"},
+		{"https://mastodon.social/@someone/109876543210", "mastodon"},
+		{"https://tenor.com/view/cat-gif-12345", "tenor"},
+		{"https://giphy.com/gifs/cat-abc123", "giphy"},
+	}
+	for _, test := range tests {
+		before := len(calls()[test.handler])
+		links := resolveDownloadLinks(test.inputURL, "", true, 0)
+		want := map[string]string{"stub://" + test.handler: ""}
+		if !reflect.DeepEqual(links, want) {
+			t.Errorf("%s went to %v, want %s", test.inputURL, links, test.handler)
+			continue
+		}
+		if len(calls()[test.handler]) != before+1 {
+			t.Errorf("%s asked %s %d times, want once", test.inputURL, test.handler, len(calls()[test.handler])-before)
+		}
+	}
+}
+
+// Links are matched with their query, then once without it, and never again after that.
+func TestDispatchQueryStrip(t *testing.T) {
+	calls := stubSiteHandlers(t, []siteHandler{
+		{name: "noQuery", matches: func(u string) bool {
+			return strings.HasPrefix(u, "https://site.example/post") && !strings.Contains(u, "?")
+		}},
+		{name: "returnsQuery", matches: func(u string) bool { return strings.HasPrefix(u, "https://loop.example/") }},
+	})
+
+	tests := []struct {
+		inputURL string
+		want     map[string]string
+	}{
+		{"https://site.example/post/1?utm_source=x", map[string]string{"stub://noQuery": ""}},
+		{"https://loop.example/a?b=c", map[string]string{"stub://returnsQuery": ""}},
+		// Nothing matches either way, the link goes as it is without the query
+		{"https://other.example/file.png?width=100", map[string]string{"https://other.example/file.png": ""}},
+		// Queries on Discord's CDN are the signature, they stay
+		{"https://cdn.discordapp.com/attachments/1/2/a.png?ex=1&is=2&hm=3", map[string]string{"https://cdn.discordapp.com/attachments/1/2/a.png?ex=1&is=2&hm=3": ""}},
+	}
+	for _, test := range tests {
+		if links := resolveDownloadLinks(test.inputURL, "", true, 0); !reflect.DeepEqual(links, test.want) {
+			t.Errorf("%s gave %v, want %v", test.inputURL, links, test.want)
+		}
+	}
+	got := calls()
+	if !reflect.DeepEqual(got["noQuery"], []string{"https://site.example/post/1"}) {
+		t.Errorf("noQuery was asked for %v", got["noQuery"])
+	}
+	if !reflect.DeepEqual(got["returnsQuery"], []string{"https://loop.example/a?b=c"}) {
+		t.Errorf("returnsQuery was asked for %v", got["returnsQuery"])
+	}
+}
+
+// Attachments all called image.png get the same names, in the same order, however many times the message is gone
+// through and whichever finishes first.
+func TestSameNamedAttachments(t *testing.T) {
+	byID := "id"
+	idChannel := configurationChannel{ChannelID: "200", Destination: "downloads", SameNameAttachments: &byID}
+	channelDefault(&idChannel)
+	useTestConfig(t, func(settings *configuration) { settings.Channels = []configurationChannel{idChannel} })
+
+	message := func(channelID string) *discordgo.Message {
+		m := &discordgo.Message{ID: "300", ChannelID: channelID, Author: &discordgo.User{ID: "1"}}
+		for i := 1; i <= 8; i++ {
+			m.Attachments = append(m.Attachments, &discordgo.MessageAttachment{
+				ID:       fmt.Sprintf("9%02d", i),
+				URL:      fmt.Sprintf("https://cdn.discordapp.com/attachments/%s/9%02d/image.png?ex=1&is=2&hm=3", channelID, i),
+				Filename: "image.png",
+			})
+		}
+		m.Attachments = append(m.Attachments, &discordgo.MessageAttachment{
+			ID:       "999",
+			URL:      "https://cdn.discordapp.com/attachments/" + channelID + "/999/other.png",
+			Filename: "other.png",
+		})
+		return m
+	}
+
+	tests := []struct {
+		channelID string
+		nameOf    func(i int) string
+	}{
+		// Channels that aren't set up use the default, their place in the message
+		{"100", func(i int) string { return fmt.Sprintf("image_%d.png", i) }},
+		{"200", func(i int) string { return fmt.Sprintf("image_9%02d.png", i) }},
+	}
+	for _, test := range tests {
+		for run := 0; run < 2; run++ {
+			var got []string
+			for _, item := range getFileLinks(message(test.channelID), &historyOptions{}, getConfig()) {
+				got = append(got, item.Filename)
+			}
+			var want []string
+			for i := 1; i <= 8; i++ {
+				want = append(want, test.nameOf(i))
+			}
+			want = append(want, "other.png")
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Channel %s run %d named them %v, want %v", test.channelID, run+1, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/kennygrant/sanitize"
+)
+
+// The emojis command saves a server's emojis, animated ones as GIFs, and its stickers as Discord serves them, named
+// "name_ID.ext". Stickers aren't in the version of discordgo used here, so they're asked for directly. "emojis used"
+// saves the custom emojis used in a channel's recent messages and reactions instead, which can be from servers the
+// bot isn't in since the CDN serves them to anyone. Everything saved is recorded by ID, so running it again only
+// saves what's new, and emojis and stickers a server no longer has are reported once.
+
+const (
+	emojisUsedDaysDefault = 7
+	emojisUsedFolder      = "used"
+	stickersFolder        = "stickers"
+)
+
+var regexEmojiUsed = regexp.MustCompile(`<(a?):(\w+):(\d+)>`)
+
+// Discord's sticker formats
+const (
+	stickerFormatPNG    = 1
+	stickerFormatAPNG   = 2
+	stickerFormatLottie = 3
+	stickerFormatGIF    = 4
+)
+
+type guildSticker struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	FormatType int    `json:"format_type"`
+}
+
+// An emoji or sticker recorded in the database.
+type savedEmoji struct {
+	key  string // "emoji:ID" or "sticker:ID"
+	name string
+}
+
+type emojiDownloadCounts struct {
+	saved, skipped, failed int
+}
+
+func (c *emojiDownloadCounts) add(status downloadStatus) {
+	switch status {
+	case downloadSuccess:
+		c.saved++
+	case downloadSkippedAlreadyRecorded:
+		c.skipped++
+	default:
+		c.failed++
+	}
+}
+
+func getGuildStickers(guildID string) ([]guildSticker, error) {
+	endpoint := discordgo.EndpointGuild(guildID) + "/stickers"
+	raw, err := bot.RequestWithBucketID("GET", endpoint, nil, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var stickers []guildSticker
+	err = json.Unmarshal(raw, &stickers)
+	return stickers, err
+}
+
+func getEmojiURL(emojiID string, animated bool) string {
+	if animated {
+		return discordgo.EndpointCDN + "emojis/" + emojiID + ".gif"
+	}
+	return discordgo.EndpointCDN + "emojis/" + emojiID + ".png"
+}
+
+// The sticker's link and extension, APNG stickers are .png like Discord serves them and Lottie ones are JSON.
+func getStickerURL(sticker guildSticker) (string, string) {
+	switch sticker.FormatType {
+	case stickerFormatLottie:
+		return discordgo.EndpointCDN + "stickers/" + sticker.ID + ".json", ".json"
+	case stickerFormatGIF:
+		return "https://media.discordapp.net/stickers/" + sticker.ID + ".gif", ".gif"
+	default:
+		return discordgo.EndpointCDN + "stickers/" + sticker.ID + ".png", ".png"
+	}
+}
+
+func getEmojiFilename(name string, id string, extension string) string {
+	return sanitize.Name(name) + "_" + id + extension
+}
+
+// Saves an emoji or sticker unless its ID is already recorded.
+func saveEmoji(key string, guildID string, name string, url string, filename string, destination string, channelID string) downloadStatus {
+	logPrefixHere := color.CyanString("[dgrouter:emojis]")
+	if dbHasEmoji(key) {
+		return downloadSkippedAlreadyRecorded
+	}
+	var message discordgo.Message
+	message.ChannelID = channelID
+	status := startDownload(
+		downloadRequestStruct{
+			InputURL: url,
+			Filename: filename,
+			Path:     destination,
+			Message:  &message,
+			FileTime: time.Now(),
+			EmojiCmd: true,
+			DryRun:   dryRunMode,
+		})
+	if status.Status != downloadSuccess {
+		log.Println(logPrefixHere, color.HiRedString("Failed to download \"%s\" (%s): \t[%d - %s] %v", name, url, status.Status, getDownloadStatusString(status.Status), status.Error))
+		return status.Status
+	}
+	if !dryRunMode {
+		if err := dbInsertEmoji(key, guildID, name, destination+filename); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Failed to record %s:\t%s", key, err))
+		}
+	}
+	return status.Status
+}
+
+// Saves a server's emojis to emojis/<server>/ and stickers to emojis/<server>/stickers/, returning where to, how
+// many emojis and stickers were saved, skipped and failed, and the names of ones the server no longer has.
+func downloadGuildEmojis(guildID string, channelID string) (string, emojiDownloadCounts, emojiDownloadCounts, []string, error) {
+	logPrefixHere := color.CyanString("[dgrouter:emojis]")
+	var emojiCounts, stickerCounts emojiDownloadCounts
+	guildName := guildID
+	if guild, err := bot.Guild(guildID); err == nil {
+		guildName = guild.Name
+	}
+	destination := "emojis" + string(os.PathSeparator) + sanitize.Name(guildName) + string(os.PathSeparator)
+	stickerDestination := destination + stickersFolder + string(os.PathSeparator)
+
+	emojis, err := bot.GuildEmojis(guildID)
+	if err != nil {
+		return destination, emojiCounts, stickerCounts, nil, fmt.Errorf("couldn't get emojis: %s", err)
+	}
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return destination, emojiCounts, stickerCounts, nil, fmt.Errorf("couldn't create destination folder: %s", err)
+	}
+	current := make(map[string]bool)
+	for _, emoji := range emojis {
+		key := "emoji:" + emoji.ID
+		current[key] = true
+		extension := ".png"
+		if emoji.Animated {
+			extension = ".gif"
+		}
+		emojiCounts.add(saveEmoji(key, guildID, emoji.Name, getEmojiURL(emoji.ID, emoji.Animated),
+			getEmojiFilename(emoji.Name, emoji.ID, extension), destination, channelID))
+	}
+
+	stickers, err := getGuildStickers(guildID)
+	if err != nil {
+		// Emojis were saved, deleted stickers can't be told apart without the list
+		log.Println(logPrefixHere, color.HiRedString("Couldn't get the stickers of \"%s\":\t%s", guildName, err))
+		return destination, emojiCounts, stickerCounts, getDeletedEmojis(guildID, current, "emoji:"), nil
+	}
+	if len(stickers) > 0 {
+		if err := os.MkdirAll(stickerDestination, 0755); err != nil {
+			return destination, emojiCounts, stickerCounts, nil, fmt.Errorf("couldn't create destination folder: %s", err)
+		}
+	}
+	for _, sticker := range stickers {
+		key := "sticker:" + sticker.ID
+		current[key] = true
+		url, extension := getStickerURL(sticker)
+		stickerCounts.add(saveEmoji(key, guildID, sticker.Name, url,
+			getEmojiFilename(sticker.Name, sticker.ID, extension), stickerDestination, channelID))
+	}
+	return destination, emojiCounts, stickerCounts, getDeletedEmojis(guildID, current, ""), nil
+}
+
+// Names of the server's recorded emojis and stickers starting with the prefix that it no longer has, marking them
+// so they're only reported once.
+func getDeletedEmojis(guildID string, current map[string]bool, prefix string) []string {
+	var deleted []string
+	if dryRunMode {
+		return deleted
+	}
+	for id, saved := range dbGetGuildEmojis(guildID) {
+		if current[saved.key] || !strings.HasPrefix(saved.key, prefix) {
+			continue
+		}
+		if err := dbMarkEmojiDeleted(id, time.Now()); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Failed to mark %s deleted:\t%s", saved.key, err))
+		}
+		deleted = append(deleted, saved.name)
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+// Saves the custom emojis used in the channel's messages and their reactions over the last days to emojis/used/,
+// returning where to, how many unique emojis were found, and how many were saved, skipped and failed.
+func downloadUsedEmojis(channelID string, days int, replyChannelID string) (string, int, emojiDownloadCounts, error) {
+	var counts emojiDownloadCounts
+	destination := "emojis" + string(os.PathSeparator) + emojisUsedFolder + string(os.PathSeparator)
+	since := time.Now().AddDate(0, 0, -days)
+
+	type usedEmoji struct {
+		name     string
+		animated bool
+	}
+	used := make(map[string]usedEmoji)
+	var lastRequest time.Time
+	beforeID := ""
+	for {
+		waitHistoryRequest(&lastRequest)
+		messages, err := getHistoryMessages(channelID, getHistoryBatchSize(), beforeID, "")
+		if err != nil {
+			return destination, 0, counts, fmt.Errorf("couldn't read messages: %s", err)
+		}
+		done := len(messages) == 0
+		for _, message := range messages {
+			if sent, err := message.Timestamp.Parse(); err == nil && sent.Before(since) {
+				done = true
+				break
+			}
+			for _, match := range regexEmojiUsed.FindAllStringSubmatch(message.Content, -1) {
+				used[match[3]] = usedEmoji{match[2], match[1] == "a"}
+			}
+			for _, reaction := range message.Reactions {
+				if reaction.Emoji != nil && reaction.Emoji.ID != "" {
+					used[reaction.Emoji.ID] = usedEmoji{reaction.Emoji.Name, reaction.Emoji.Animated}
+				}
+			}
+			beforeID = message.ID
+		}
+		if done {
+			break
+		}
+	}
+
+	if len(used) > 0 {
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			return destination, len(used), counts, fmt.Errorf("couldn't create destination folder: %s", err)
+		}
+	}
+	for id, emoji := range used {
+		extension := ".png"
+		if emoji.animated {
+			extension = ".gif"
+		}
+		counts.add(saveEmoji("emoji:"+id, "", emoji.name, getEmojiURL(id, emoji.animated),
+			getEmojiFilename(emoji.name, id, extension), destination, replyChannelID))
+	}
+	return destination, len(used), counts, nil
+}
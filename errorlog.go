@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Error log messages go to admin channels with logErrors enabled, filtered by their logSeverities
+const (
+	errorLogFailures = "failures" // downloads that gave up, aggregated
+	errorLogWarnings = "warnings" // everything else, sent as it happens
+)
+
+var errorLogSeverities = []string{errorLogFailures, errorLogWarnings}
+
+// Soonest a new kind of failure flushes the ones already collected, so alternating errors can't spam either
+const errorLogMinFlushGap = 30 * time.Second
+
+// Names for failure statuses in errorLogSuppress
+var downloadFailureNames = map[string]downloadStatus{
+	"failed":                    downloadFailed,
+	"failed404":                 downloadFailed404,
+	"failedInvalidSource":       downloadFailedInvalidSource,
+	"failedInvalidPath":         downloadFailedInvalidPath,
+	"failedCreatingFolder":      downloadFailedCreatingFolder,
+	"failedRequesting":          downloadFailedRequesting,
+	"failedDownloadingResponse": downloadFailedDownloadingResponse,
+	"failedReadResponse":        downloadFailedReadResponse,
+	"failedCreatingSubfolder":   downloadFailedCreatingSubfolder,
+	"failedWritingFile":         downloadFailedWritingFile,
+	"failedWritingDatabase":     downloadFailedWritingDatabase,
+	"failedIncompleteBody":      downloadFailedIncompleteBody,
+	"failedStorageUnavailable":  downloadFailedStorageUnavailable,
+	"failed403":                 downloadFailed403,
+	"failed410":                 downloadFailed410,
+}
+
+type errorLogKey struct {
+	status downloadStatus
+	domain string
+}
+
+type errorLogEntry struct {
+	count                     int
+	firstURL, lastURL         string
+	firstChannel, lastChannel string
+	lastError                 string
+}
+
+var (
+	errorLogEntries   = make(map[errorLogKey]*errorLogEntry)
+	errorLogLastFlush = time.Now()
+	errorLogMutex     sync.Mutex
+	errorLogOnce      sync.Once
+)
+
+// Sends to every admin channel taking the severity.
+func sendErrorLog(severity string, title string, content string) {
+	settings := getConfig()
+	for _, adminChannel := range settings.AdminChannels {
+		if !*adminChannel.LogErrors || (adminChannel.LogSeverities != nil && !stringInSlice(severity, *adminChannel.LogSeverities)) {
+			continue
+		}
+		if canSendEmbeds() && hasPerms(adminChannel.ChannelID, discordgo.PermissionEmbedLinks) { // not confident this is the right permission
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.HiCyanString("Sending embed log for error to %s", adminChannel.ChannelID))
+			}
+			bot.ChannelMessageSendEmbed(adminChannel.ChannelID, buildEmbed(adminChannel.ChannelID, title, content))
+		} else if hasPerms(adminChannel.ChannelID, discordgo.PermissionSendMessages) {
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.HiCyanString("Sending message log for error to %s", adminChannel.ChannelID))
+			}
+			bot.ChannelMessageSend(adminChannel.ChannelID, embedText("", title, content))
+		} else {
+			log.Println(logPrefixDebug, color.HiRedString("Perms checks failed for sending error log to %s", adminChannel.ChannelID))
+		}
+	}
+}
+
+// Collects a failed download to be sent with others like it, flushed every errorLogInterval minutes
+// or sooner when a different kind of failure shows up.
+func logDownloadFailure(download downloadRequestStruct, status downloadStatusStruct) {
+	for _, name := range getConfig().ErrorLogSuppress {
+		if downloadFailureNames[name] == status.Status {
+			return
+		}
+	}
+	errorLogOnce.Do(func() {
+		go func() {
+			for range time.Tick(time.Minute) {
+				errorLogMutex.Lock()
+				due := time.Since(errorLogLastFlush) >= time.Duration(getConfig().ErrorLogInterval)*time.Minute
+				errorLogMutex.Unlock()
+				if due {
+					flushErrorLog()
+				}
+			}
+		}()
+	})
+
+	domain := "unknown"
+	if u, err := url.Parse(download.InputURL); err == nil && u.Hostname() != "" {
+		domain = u.Hostname()
+	}
+	key := errorLogKey{status.Status, domain}
+	message := ""
+	if status.Error != nil {
+		message = status.Error.Error()
+	}
+
+	errorLogMutex.Lock()
+	entry, exists := errorLogEntries[key]
+	changed := !exists && len(errorLogEntries) > 0 && time.Since(errorLogLastFlush) >= errorLogMinFlushGap
+	errorLogMutex.Unlock()
+	if changed {
+		flushErrorLog()
+	}
+
+	errorLogMutex.Lock()
+	defer errorLogMutex.Unlock()
+	if entry, exists = errorLogEntries[key]; !exists {
+		entry = &errorLogEntry{
+			firstURL:     download.InputURL,
+			firstChannel: download.Message.ChannelID,
+		}
+		errorLogEntries[key] = entry
+	}
+	entry.count++
+	entry.lastURL = download.InputURL
+	entry.lastChannel = download.Message.ChannelID
+	entry.lastError = message
+}
+
+// Sends everything collected as one embed.
+func flushErrorLog() {
+	errorLogMutex.Lock()
+	entries := errorLogEntries
+	errorLogEntries = make(map[errorLogKey]*errorLogEntry)
+	errorLogLastFlush = time.Now()
+	errorLogMutex.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	keys := make([]errorLogKey, 0, len(entries))
+	total := 0
+	for key, entry := range entries {
+		keys = append(keys, key)
+		total += entry.count
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return entries[keys[i]].count > entries[keys[j]].count
+	})
+
+	var sections []string
+	length := 0
+	for i, key := range keys {
+		entry := entries[key]
+		section := fmt.Sprintf("**%s** from `%s` — %s time%s\n", getDownloadStatusString(key.status), key.domain, formatNumber(int64(entry.count)), pluralS(entry.count))
+		section += fmt.Sprintf("First: <%s> in <#%s>\n", entry.firstURL, entry.firstChannel)
+		if entry.count > 1 {
+			section += fmt.Sprintf("Last: <%s> in <#%s>\n", entry.lastURL, entry.lastChannel)
+		}
+		if entry.lastError != "" {
+			lastError := entry.lastError
+			if len(lastError) > 300 {
+				lastError = lastError[:300] + "..."
+			}
+			section += fmt.Sprintf("```%s```", lastError)
+		}
+		// Embed descriptions are limited to 4096 characters
+		if length+len(section) > 3800 {
+			sections = append(sections, fmt.Sprintf("_...and %d more_", len(keys)-i))
+			break
+		}
+		length += len(section)
+		sections = append(sections, section)
+	}
+
+	title := fmt.Sprintf("Log — %s Failed Download%s", formatNumber(int64(total)), pluralS(total))
+	sendErrorLog(errorLogFailures, title, strings.Join(sections, "\n"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/kennygrant/sanitize"
+)
+
+// Scheduled events aren't in the version of discordgo used here, so their covers are found by asking for the
+// server's events directly. Discord only lists events that haven't ended.
+
+type guildScheduledEvent struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"` // cover hash, empty without a cover
+}
+
+func getGuildScheduledEvents(guildID string) ([]guildScheduledEvent, error) {
+	endpoint := discordgo.EndpointGuild(guildID) + "/scheduled-events"
+	raw, err := bot.RequestWithBucketID("GET", endpoint, nil, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var events []guildScheduledEvent
+	err = json.Unmarshal(raw, &events)
+	return events, err
+}
+
+// Servers registered themselves or with a registered channel.
+func getRegisteredGuilds() []string {
+	guilds := getBoundServers()
+	for _, channelID := range getBoundChannels() {
+		if guildID := getChannelGuildID(channelID); guildID != "" && !stringInSlice(guildID, guilds) {
+			guilds = append(guilds, guildID)
+		}
+	}
+	return guilds
+}
+
+// Saves the covers of a server's events to events/<server>/, returning where to and how many were saved and
+// skipped or failed.
+func downloadEventCovers(guildID string, channelID string) (string, int, int, error) {
+	logPrefixHere := color.CyanString("[dgrouter:events]")
+	destination := "events" + string(os.PathSeparator) + sanitize.Name(getGuildName(guildID)) + string(os.PathSeparator)
+	events, err := getGuildScheduledEvents(guildID)
+	if err != nil {
+		return destination, 0, 0, fmt.Errorf("couldn't get events: %s", err)
+	}
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return destination, 0, 0, fmt.Errorf("couldn't create destination folder: %s", err)
+	}
+
+	saved, failed := 0, 0
+	for _, event := range events {
+		if event.Image == "" {
+			continue
+		}
+		var message discordgo.Message
+		message.ChannelID = channelID
+		url := fmt.Sprintf("https://cdn.discordapp.com/guild-events/%s/%s.png?size=4096", event.ID, event.Image)
+		status := startDownload(
+			downloadRequestStruct{
+				InputURL: url,
+				// A new cover is saved alongside the old one
+				Filename: event.ID + "_" + event.Image,
+				Path:     destination,
+				Message:  &message,
+				FileTime: time.Now(),
+				EmojiCmd: true,
+				DryRun:   dryRunMode,
+			})
+		if status.Status == downloadSuccess {
+			saved++
+		} else {
+			failed++
+			log.Println(logPrefixHere, color.HiRedString("Failed to download cover of event \"%s\": \t[%d - %s] %v", event.Name, status.Status, getDownloadStatusString(status.Status), status.Error))
+		}
+	}
+	return destination, saved, failed, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hako/durafmt"
+)
+
+// Previously failed URLs skipped during history, keyed by channel and read for the history summary
+var (
+	failedURLSkips      = make(map[string]int64)
+	failedURLSkipsMutex sync.Mutex
+)
+
+// Why a URL that failed before shouldn't be tried again yet, "" if it should.
+func getFailedURLSkipReason(inputURL string, channelID string) string {
+	settings := getConfig()
+	if settings.SkipFailedURLsAfter <= 0 && settings.FailedURLCooldown == "" {
+		return ""
+	}
+	_, doc := dbFindFailedURL(inputURL, channelID)
+	if doc == nil {
+		return ""
+	}
+	attempts := dbReadInt64(doc, "Attempts")
+	if settings.SkipFailedURLsAfter > 0 && attempts >= int64(settings.SkipFailedURLsAfter) {
+		return fmt.Sprintf("failed %d time%s, reached skipFailedURLsAfter", attempts, pluralS(int(attempts)))
+	}
+	if cooldown, err := time.ParseDuration(settings.FailedURLCooldown); err == nil && cooldown > 0 {
+		if remaining := cooldown - time.Since(dbReadTime(doc, "LastAttempt")); remaining > 0 {
+			return fmt.Sprintf("failed recently, retrying in %s", durafmt.ParseShort(remaining))
+		}
+	}
+	return ""
+}
+
+func recordFailedURLSkip(channelID string) {
+	failedURLSkipsMutex.Lock()
+	defer failedURLSkipsMutex.Unlock()
+	failedURLSkips[channelID]++
+}
+
+// Returns and resets the channel's count.
+func takeFailedURLSkips(channelID string) int64 {
+	failedURLSkipsMutex.Lock()
+	defer failedURLSkipsMutex.Unlock()
+	count := failedURLSkips[channelID]
+	delete(failedURLSkips, channelID)
+	return count
+}
+
+// Forgets the channel's failed URLs and goes through the messages they came from again.
+// Messages are fetched fresh, so expired Discord links are signed again.
+func retryFailedURLs(channelID string) (int, int) {
+	messageIDs := dbClearFailedURLsByChannel(channelID)
+	var downloads int64
+	for _, messageID := range messageIDs {
+		message, err := bot.ChannelMessage(channelID, messageID)
+		if err != nil || message == nil {
+			continue
+		}
+		if message.GuildID == "" {
+			message.GuildID = getChannelGuildID(channelID)
+		}
+		if count := handleMessage(message, false, &historyOptions{}); count > 0 {
+			downloads += count
+		}
+	}
+	return len(messageIDs), int(downloads)
+}
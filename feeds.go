@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// With feeds set, Atom feeds of the newest downloads are kept in a folder, all.xml for everything and
+// {channel ID}.xml for each channel. They're written a little while after downloads stop coming in rather than for
+// every file, and the web gallery serves them under /feeds/.
+
+var logPrefixFeeds = color.HiCyanString("[Feeds]")
+
+const (
+	feedsFolderDefault = "feeds"
+	feedItemsDefault   = 50
+	// Feeds are written once downloads have stopped for this long...
+	feedDebounce = 15 * time.Second
+	// ...or this long after the first one, whichever comes first
+	feedMaxDelay = 2 * time.Minute
+	feedAllName  = "all"
+)
+
+var feedNamePattern = regexp.MustCompile(`^(all|[0-9]+)\.xml$`)
+
+var feeds = struct {
+	mutex sync.Mutex
+	// Held while writing so two writes never race on the same file
+	writing sync.Mutex
+	// Newest first
+	channels map[string][]*downloadItem
+	dirty    map[string]bool
+	since    time.Time
+	timer    *time.Timer
+}{channels: make(map[string][]*downloadItem), dirty: make(map[string]bool)}
+
+func getFeedsFolder() string {
+	settings := getConfig()
+	return applyBasePath(settings.BasePath, settings.Feeds.Folder)
+}
+
+// Loads the newest downloads from the database and writes every feed.
+func startFeeds() {
+	settings := getConfig()
+	if settings.Feeds == nil {
+		return
+	}
+	recent := dbRecentDownloadsByChannel(settings.Feeds.Items)
+	feeds.mutex.Lock()
+	for channelID, downloads := range recent {
+		feeds.channels[channelID] = downloads
+		feeds.dirty[channelID] = true
+	}
+	feeds.mutex.Unlock()
+	writeFeeds()
+	log.Println(logPrefixFeeds, color.HiGreenString("Wrote feeds for %d channel%s to \"%s\"", len(recent), pluralS(len(recent)), getFeedsFolder()))
+}
+
+// Adds a saved file to its channel's feed, the feeds are written once things quiet down.
+func recordFeed(saved *downloadItem) {
+	settings := getConfig()
+	if settings.Feeds == nil || saved == nil {
+		return
+	}
+	feeds.mutex.Lock()
+	defer feeds.mutex.Unlock()
+	channel := append([]*downloadItem{saved}, feeds.channels[saved.ChannelID]...)
+	if len(channel) > settings.Feeds.Items {
+		channel = channel[:settings.Feeds.Items]
+	}
+	feeds.channels[saved.ChannelID] = channel
+	feeds.dirty[saved.ChannelID] = true
+
+	if feeds.since.IsZero() {
+		feeds.since = time.Now()
+	}
+	delay := feedDebounce
+	if remaining := feedMaxDelay - time.Since(feeds.since); remaining < delay {
+		delay = remaining
+	}
+	if feeds.timer == nil {
+		feeds.timer = time.AfterFunc(delay, writeFeeds)
+	} else {
+		feeds.timer.Reset(delay)
+	}
+}
+
+// Writes the feeds of channels that changed, and all.xml if any did.
+func writeFeeds() {
+	settings := getConfig()
+	if settings.Feeds == nil {
+		return
+	}
+	feeds.writing.Lock()
+	defer feeds.writing.Unlock()
+	feeds.mutex.Lock()
+	changed := make(map[string][]*downloadItem)
+	var all []*downloadItem
+	for channelID, downloads := range feeds.channels {
+		if feeds.dirty[channelID] {
+			changed[channelID] = downloads
+		}
+		all = append(all, downloads...)
+	}
+	feeds.dirty = make(map[string]bool)
+	feeds.since = time.Time{}
+	feeds.mutex.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	folder := getFeedsFolder()
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		log.Println(logPrefixFeeds, color.HiRedString("Failed to create \"%s\":\t%s", folder, err))
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Time.After(all[j].Time) })
+	if len(all) > settings.Feeds.Items {
+		all = all[:settings.Feeds.Items]
+	}
+	changed[feedAllName] = all
+	for name, downloads := range changed {
+		path := filepath.Join(folder, name+".xml")
+		if err := writeFeed(path, name, downloads); err != nil {
+			log.Println(logPrefixFeeds, color.HiRedString("Failed to write \"%s\":\t%s", path, err))
+		}
+	}
+	if settings.DebugOutput {
+		log.Println(logPrefixDebug, logPrefixFeeds, color.CyanString("Wrote %d feed%s", len(changed), pluralS(len(changed))))
+	}
+}
+
+//#region Atom
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// Written to a temporary file first so readers never see half a feed.
+func writeFeed(path string, name string, downloads []*downloadItem) error {
+	feed := atomFeed{
+		Title:   projectName,
+		ID:      "urn:" + projectName + ":feed:" + name,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if name != feedAllName {
+		feed.Title += " — #" + getChannelName(name)
+		if guildID := getChannelGuildID(name); guildID != "" {
+			feed.Title += " in " + getGuildName(guildID)
+		}
+	}
+	if baseURL := strings.TrimSuffix(getConfig().Feeds.BaseURL, "/"); baseURL != "" {
+		feed.Links = append(feed.Links, atomLink{Rel: "self", Href: baseURL + "/feeds/" + name + ".xml", Type: "application/atom+xml"})
+	}
+	if len(downloads) > 0 {
+		feed.Updated = downloads[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, download := range downloads {
+		feed.Entries = append(feed.Entries, getFeedEntry(download))
+	}
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	if err := ioutil.WriteFile(temporary, append([]byte(xml.Header), output...), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
+
+func getFeedEntry(download *downloadItem) atomEntry {
+	guildID := download.GuildID
+	if guildID == "" {
+		guildID = getChannelGuildID(download.ChannelID)
+	}
+	title := download.Filename
+	if title == "" {
+		title = filepath.Base(download.Destination)
+	}
+	entry := atomEntry{
+		Title:   title,
+		ID:      "urn:" + projectName + ":download:" + strconv.Itoa(download.ID),
+		Updated: download.Time.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{getGalleryUserName(guildID, download.UserID)},
+		Summary: download.Content,
+	}
+	if guildID == "" {
+		guildID = "@me"
+	}
+	if download.MessageID != "" {
+		entry.Links = append(entry.Links, atomLink{Rel: "alternate", Href: fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, download.ChannelID, download.MessageID)})
+	}
+	entry.Links = append(entry.Links, atomLink{Rel: "related", Href: download.URL})
+	if enclosure := getFeedEnclosure(download); enclosure != "" {
+		entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Href: enclosure,
+			Type: mime.TypeByExtension(filepathExtension(download.Destination)), Length: download.Size})
+	}
+	return entry
+}
+
+// The file through the web gallery if baseURL is set, otherwise where it was saved. Remote files are left out.
+func getFeedEnclosure(download *downloadItem) string {
+	if baseURL := strings.TrimSuffix(getConfig().Feeds.BaseURL, "/"); baseURL != "" {
+		return baseURL + "/file/" + strconv.Itoa(download.ID)
+	}
+	if download.Destination == "" || isRemoteDestination(download.Destination) {
+		return ""
+	}
+	path, err := filepath.Abs(download.Destination)
+	if err != nil {
+		return ""
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+//#endregion
+
+// GET /feeds/{name}.xml on the web gallery.
+func galleryFeed(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	if getConfig().Feeds == nil || !feedNamePattern.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	http.ServeFile(w, r, filepath.Join(getFeedsFolder(), name))
+}
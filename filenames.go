@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// With filenameFormat, files are named from {date}, {filename}, {altText}, {embedTitle}, and {artist}, {postID} and
+// {postTitle} from site handlers, instead of the date prefix and filename. Alt text isn't in the version of discordgo used here, so messages with attachments are
+// fetched again as they are for it, only for channels whose format uses it.
+
+const (
+	filenameTokenDate       = "{date}"
+	filenameTokenFilename   = "{filename}"
+	filenameTokenAltText    = "{altText}"
+	filenameTokenEmbedTitle = "{embedTitle}"
+	filenameTokenArtist     = "{artist}"
+	filenameTokenPostID     = "{postID}"
+	filenameTokenPostTitle  = "{postTitle}"
+	// Characters kept from alt text and embed titles, the rest of the name needs room too
+	filenameTokenMaxLength = 80
+	// Stands in for empty tokens until the separators around them are dealt with
+	filenameTokenEmpty = "\x00"
+)
+
+var (
+	filenameTokenSeparators = regexp.MustCompile("[ _.-]*(?:" + filenameTokenEmpty + "[ _.-]*)+")
+	filenameWhitespace      = regexp.MustCompile(`\s+`)
+)
+
+// Parts of an attachment discordgo.MessageAttachment leaves out.
+type attachmentDescriptions struct {
+	Attachments []struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+	} `json:"attachments"`
+}
+
+func usesFilenameToken(channelID string, token string) bool {
+	if !isChannelRegistered(channelID) {
+		return false
+	}
+	format := getChannelConfig(channelID).FilenameFormat
+	return format != nil && strings.Contains(*format, token)
+}
+
+// Alt text by attachment ID, empty unless the channel's filenameFormat uses it.
+func getAttachmentDescriptions(m *discordgo.Message) map[string]string {
+	descriptions := make(map[string]string)
+	if len(m.Attachments) == 0 || m.ID == "" || !usesFilenameToken(m.ChannelID, filenameTokenAltText) {
+		return descriptions
+	}
+	raw, err := bot.RequestWithBucketID("GET", discordgo.EndpointChannelMessage(m.ChannelID, m.ID), nil,
+		discordgo.EndpointChannelMessage(m.ChannelID, ""))
+	if err != nil {
+		log.Println(logPrefixDiscord, color.HiRedString("Failed to fetch message %s for alt text:\t%s", m.ID, err))
+		return descriptions
+	}
+	var message attachmentDescriptions
+	if err := json.Unmarshal(raw, &message); err != nil {
+		log.Println(logPrefixDiscord, color.HiRedString("Failed to read alt text of message %s:\t%s", m.ID, err))
+		return descriptions
+	}
+	for _, attachment := range message.Attachments {
+		if attachment.Description != "" {
+			descriptions[attachment.ID] = attachment.Description
+		}
+	}
+	return descriptions
+}
+
+// One line, without characters that can't be in filenames, cut to filenameTokenMaxLength.
+func sanitizeFilenameToken(value string) string {
+	value = strings.TrimSpace(filenameWhitespace.ReplaceAllString(sanitizePathSegment(value), " "))
+	if runes := []rune(value); len(runes) > filenameTokenMaxLength {
+		value = strings.TrimSpace(string(runes[:filenameTokenMaxLength]))
+	}
+	// Windows drops trailing dots
+	return strings.TrimRight(value, ". ")
+}
+
+// Fills in filenameFormat. Empty tokens take the separators around them along, so "{altText} - {filename}" without
+// alt text is just the filename.
+func formatFilename(format string, date string, download downloadRequestStruct) string {
+	tokens := [][]string{
+		{filenameTokenAltText, sanitizeFilenameToken(download.AltText)},
+		{filenameTokenEmbedTitle, sanitizeFilenameToken(download.EmbedTitle)},
+		{filenameTokenArtist, sanitizeFilenameToken(download.Post.Artist)},
+		{filenameTokenPostID, sanitizeFilenameToken(download.Post.PostID)},
+		{filenameTokenPostTitle, sanitizeFilenameToken(download.Post.PostTitle)},
+		{filenameTokenDate, date},
+		{filenameTokenFilename, download.Filename},
+	}
+	for _, token := range tokens {
+		value := token[1]
+		if value == "" {
+			value = filenameTokenEmpty
+		}
+		format = strings.ReplaceAll(format, token[0], value)
+	}
+	var name strings.Builder
+	last := 0
+	for _, match := range filenameTokenSeparators.FindAllStringIndex(format, -1) {
+		name.WriteString(format[last:match[0]])
+		last = match[1]
+		// Nothing's needed at either end of the name, otherwise the shorter separator is kept
+		if match[0] == 0 || match[1] == len(format) {
+			continue
+		}
+		separators := format[match[0]:match[1]]
+		before := separators[:strings.Index(separators, filenameTokenEmpty)]
+		after := separators[strings.LastIndex(separators, filenameTokenEmpty)+len(filenameTokenEmpty):]
+		if before == "" || (after != "" && len(after) < len(before)) {
+			before = after
+		}
+		name.WriteString(before)
+	}
+	name.WriteString(format[last:])
+	return name.String()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Folders are named with the first name seen for a server, channel or category. When the live name
+// changes, "folders sync" can move the folders and the database over to it.
+
+type folderRename struct {
+	key              string // "kind:id" in the names collection
+	oldName, newName string
+	// Folder names the old name could have ended up in, and what they become
+	segments   map[string]string
+	channelIDs []string
+	// Folders on disk and their new paths
+	moves map[string]string
+}
+
+type folderSyncResult struct {
+	renamed, merged, collisions, conflicts, updated int
+	errors                                          []string
+}
+
+// Names that have changed since they were stored, with the folders that would move.
+func getFolderRenames() []*folderRename {
+	var renames []*folderRename
+	for key, stored := range dbGetStableNames() {
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) != 2 || stored == "" {
+			continue
+		}
+		kind, id := parts[0], parts[1]
+		rename := &folderRename{key: key, oldName: stored, segments: make(map[string]string), moves: make(map[string]string)}
+		switch kind {
+		case "server":
+			guild, err := bot.State.Guild(id)
+			if err != nil || guild == nil || guild.Name == stored || guild.Name == "" {
+				continue
+			}
+			rename.newName = guild.Name
+			rename.segments[sanitizePathSegment(stored)] = sanitizePathSegment(guild.Name)
+			for _, channel := range guild.Channels {
+				rename.channelIDs = append(rename.channelIDs, channel.ID)
+			}
+		case "channel":
+			channel, err := bot.State.Channel(id)
+			if err != nil || channel == nil || channel.Name == stored || channel.Name == "" {
+				continue
+			}
+			rename.newName = channel.Name
+			rename.segments[sanitizePathSegment(stored)] = sanitizePathSegment(channel.Name)
+			if channel.ParentID != "" {
+				if category := getStoredName("category", channel.ParentID); category != "" {
+					rename.segments[sanitizePathSegment(category+" - "+stored)] = sanitizePathSegment(category + " - " + channel.Name)
+				}
+			}
+			rename.channelIDs = []string{channel.ID}
+		case "category":
+			category, err := bot.State.Channel(id)
+			if err != nil || category == nil || category.Name == stored || category.Name == "" {
+				continue
+			}
+			rename.newName = category.Name
+			rename.segments[sanitizePathSegment(stored)] = sanitizePathSegment(category.Name)
+			if guild, err := bot.State.Guild(category.GuildID); err == nil {
+				for _, channel := range guild.Channels {
+					if channel.ParentID != id {
+						continue
+					}
+					rename.channelIDs = append(rename.channelIDs, channel.ID)
+					if name := getStoredName("channel", channel.ID); name != "" {
+						rename.segments[sanitizePathSegment(stored+" - "+name)] = sanitizePathSegment(category.Name + " - " + name)
+					}
+				}
+			}
+		default:
+			continue
+		}
+		// Folders are found from where files were saved, the closest one to the file named after the old name
+		for _, channelID := range rename.channelIDs {
+			for _, destination := range dbDownloadDestinationsByChannel(channelID) {
+				if destination == "" || isRemoteDestination(destination) {
+					continue
+				}
+				folders := strings.Split(filepath.Dir(destination), string(os.PathSeparator))
+				for i := len(folders) - 1; i >= 0; i-- {
+					if newSegment, exists := rename.segments[folders[i]]; exists && newSegment != folders[i] {
+						oldPath := strings.Join(folders[:i+1], string(os.PathSeparator))
+						rename.moves[oldPath] = filepath.Join(filepath.Dir(oldPath), newSegment)
+						break
+					}
+				}
+			}
+		}
+		renames = append(renames, rename)
+	}
+	sort.Slice(renames, func(i, j int) bool { return renames[i].key < renames[j].key })
+	return renames
+}
+
+func getStoredName(kind string, id string) string {
+	if name, exists := destinationNames.Load(kind + ":" + id); exists {
+		return name.(string)
+	}
+	return dbGetStableName(kind + ":" + id)
+}
+
+// Moves each rename's folders and repoints the database at them, then keeps the new name.
+// Existing folders at the new path are only merged into with merge set.
+func syncFolderRenames(renames []*folderRename, merge bool) folderSyncResult {
+	var result folderSyncResult
+	for _, rename := range renames {
+		complete := true
+		for oldPath, newPath := range rename.moves {
+			if _, err := os.Stat(oldPath); err != nil {
+				// Already moved by hand, the database may still need catching up
+				updated, _ := repointDestinations(rename.channelIDs, oldPath, newPath)
+				result.updated += updated
+				continue
+			}
+			merging := false
+			if _, err := os.Stat(newPath); err == nil {
+				if !merge {
+					result.collisions++
+					result.errors = append(result.errors, fmt.Sprintf("`%s` already exists, not merging without `merge`", newPath))
+					complete = false
+					continue
+				}
+				merging = true
+			}
+			if merging {
+				conflicts, err := mergeFolder(oldPath, newPath)
+				result.conflicts += conflicts
+				if err != nil {
+					result.errors = append(result.errors, fmt.Sprintf("merging `%s`: %s", oldPath, err))
+					complete = false
+					continue
+				}
+				result.merged++
+			} else {
+				if err := os.Rename(oldPath, newPath); err != nil {
+					result.errors = append(result.errors, fmt.Sprintf("renaming `%s`: %s", oldPath, err))
+					complete = false
+					continue
+				}
+				result.renamed++
+			}
+			updated, err := repointDestinations(rename.channelIDs, oldPath, newPath)
+			if err != nil {
+				// Put the folder back so files and database still agree
+				if !merging {
+					os.Rename(newPath, oldPath)
+					result.renamed--
+				}
+				result.errors = append(result.errors, fmt.Sprintf("updating the database for `%s`, changes undone: %s", oldPath, err))
+				complete = false
+				continue
+			}
+			result.updated += updated
+		}
+		if complete {
+			if err := dbUpdateStableName(rename.key, rename.newName); err != nil {
+				result.errors = append(result.errors, fmt.Sprintf("saving the new name for %s: %s", rename.key, err))
+				continue
+			}
+			destinationNames.Store(rename.key, rename.newName)
+		}
+	}
+	return result
+}
+
+// Updates the Destination of every download moved out of the folder, undoing them all if one fails.
+func repointDestinations(channelIDs []string, oldPath string, newPath string) (int, error) {
+	prefix := oldPath + string(os.PathSeparator)
+	previous := make(map[int]string)
+	for _, channelID := range channelIDs {
+		for id, destination := range dbDownloadDestinationsByChannel(channelID) {
+			if !strings.HasPrefix(destination, prefix) {
+				continue
+			}
+			if _, err := os.Stat(destination); err == nil {
+				continue // left behind by a merge conflict
+			}
+			if err := dbUpdateDownloadDestination(id, filepath.Join(newPath, destination[len(prefix):])); err != nil {
+				for id, destination := range previous {
+					dbUpdateDownloadDestination(id, destination)
+				}
+				return 0, err
+			}
+			previous[id] = destination
+		}
+	}
+	return len(previous), nil
+}
+
+// Moves everything into an existing folder, leaving files whose names are already taken where they are.
+func mergeFolder(from string, to string) (int, error) {
+	entries, err := ioutil.ReadDir(from)
+	if err != nil {
+		return 0, err
+	}
+	conflicts := 0
+	for _, entry := range entries {
+		source, target := filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name())
+		if _, err := os.Stat(target); err == nil {
+			if entry.IsDir() {
+				nested, err := mergeFolder(source, target)
+				conflicts += nested
+				if err != nil {
+					return conflicts, err
+				}
+			} else {
+				conflicts++
+			}
+			continue
+		}
+		if err := os.Rename(source, target); err != nil {
+			return conflicts, err
+		}
+	}
+	os.Remove(from) // only succeeds once it's empty
+	return conflicts, nil
+}
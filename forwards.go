@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Forwarded messages carry what they forward in message_snapshots, which the version of discordgo used here
+// doesn't know about, so messages that could be forwards are fetched again as they are and read from that.
+
+// Parts of a message discordgo.Message leaves out.
+type messageReferences struct {
+	MessageReference *struct {
+		Type int `json:"type"` // 0 for replies, 1 for forwards
+	} `json:"message_reference"`
+	MessageSnapshots []struct {
+		Message discordgo.Message `json:"message"`
+	} `json:"message_snapshots"`
+	ReferencedMessage *discordgo.Message `json:"referenced_message"`
+}
+
+const messageReferenceForward = 1
+
+// Forwards have nothing of their own, anything else with a reference is a reply or crosspost.
+func isPossibleForward(m *discordgo.Message) bool {
+	return m.MessageReference != nil && m.Content == "" && len(m.Attachments) == 0 && len(m.Embeds) == 0
+}
+
+// Messages m forwards, and the one it replies to if the channel has includeReplyReferences. They take m's channel,
+// so its settings apply to them.
+func getReferencedMessages(m *discordgo.Message) []*discordgo.Message {
+	if m.MessageReference == nil || m.ID == "" {
+		return nil
+	}
+	includeReplies := false
+	if isChannelRegistered(m.ChannelID) {
+		includeReplies = *getChannelConfig(m.ChannelID).IncludeReplyReferences
+	}
+	if !includeReplies && !isPossibleForward(m) {
+		return nil
+	}
+
+	raw, err := bot.RequestWithBucketID("GET", discordgo.EndpointChannelMessage(m.ChannelID, m.ID), nil,
+		discordgo.EndpointChannelMessage(m.ChannelID, ""))
+	if err != nil {
+		log.Println(logPrefixDiscord, color.HiRedString("Failed to fetch message %s for what it references:\t%s", m.ID, err))
+		return nil
+	}
+	var references messageReferences
+	if err := json.Unmarshal(raw, &references); err != nil {
+		log.Println(logPrefixDiscord, color.HiRedString("Failed to read what message %s references:\t%s", m.ID, err))
+		return nil
+	}
+
+	var referenced []*discordgo.Message
+	if references.MessageReference != nil && references.MessageReference.Type == messageReferenceForward {
+		for i := range references.MessageSnapshots {
+			snapshot := &references.MessageSnapshots[i].Message
+			snapshot.ChannelID = m.ChannelID
+			referenced = append(referenced, snapshot)
+		}
+	} else if includeReplies && references.ReferencedMessage != nil {
+		references.ReferencedMessage.ChannelID = m.ChannelID
+		referenced = append(referenced, references.ReferencedMessage)
+	}
+	if getConfig().DebugOutput && len(referenced) > 0 {
+		log.Println(logPrefixDebug, color.CyanString("Message %s references %d message%s, including their links", m.ID, len(referenced), pluralS(len(referenced))))
+	}
+	return referenced
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// With checkFilesystemForDuplicates, every file under the channel's destination is hashed into an index, so files
+// put there some other way aren't downloaded again under a different name. The hashes are kept in a cache file,
+// files that haven't changed size or modification time since aren't read again on the next start.
+
+var logPrefixFilesystemIndex = color.HiGreenString("[Filesystem Index]")
+
+const (
+	filesystemIndexPath = cachePath + string(os.PathSeparator) + "fsindex.json"
+	// How often building an index logs how far along it is
+	filesystemIndexProgressInterval = 10 * time.Second
+	// How often changes to the indexes are written to the cache file
+	filesystemIndexSaveInterval = time.Minute
+)
+
+type filesystemIndexEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // unix nanoseconds
+	Hash    string `json:"hash"`
+}
+
+type filesystemIndex struct {
+	files  map[string]filesystemIndexEntry // by path
+	hashes map[string][]string             // to paths
+	ready  bool
+}
+
+var (
+	filesystemIndexes      = make(map[string]*filesystemIndex) // by destination root
+	filesystemIndexesMutex sync.Mutex
+	filesystemIndexesDirty bool
+	filesystemIndexSaver   sync.Once
+)
+
+func (index *filesystemIndex) add(path string, entry filesystemIndexEntry) {
+	index.remove(path)
+	index.files[path] = entry
+	index.hashes[entry.Hash] = append(index.hashes[entry.Hash], path)
+}
+
+func (index *filesystemIndex) remove(path string) {
+	entry, exists := index.files[path]
+	if !exists {
+		return
+	}
+	delete(index.files, path)
+	paths := index.hashes[entry.Hash]
+	for i := range paths {
+		if paths[i] == path {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+	if len(paths) == 0 {
+		delete(index.hashes, entry.Hash)
+	} else {
+		index.hashes[entry.Hash] = paths
+	}
+}
+
+// Local destination roots of channels with checkFilesystemForDuplicates.
+func getFilesystemIndexRoots() []string {
+	settings := getConfig()
+	var roots []string
+	channels := append(append([]configurationChannel{}, settings.Channels...), settings.Servers...)
+	if settings.All != nil {
+		channels = append(channels, *settings.All)
+	}
+	for _, channel := range channels {
+		if channel.CheckFilesystemForDuplicates == nil || !*channel.CheckFilesystemForDuplicates ||
+			channel.Destination == "" || isRemoteDestination(channel.Destination) {
+			continue
+		}
+		root := filepath.Clean(getDestinationRoot(settings.BasePath, channel.Destination))
+		if !stringInSlice(root, roots) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// Starts building indexes for roots that don't have one yet, run at startup and again after settings reload.
+func updateFilesystemIndexes() {
+	roots := getFilesystemIndexRoots()
+	if len(roots) == 0 {
+		return
+	}
+	cached := loadFilesystemIndexCache()
+	filesystemIndexesMutex.Lock()
+	defer filesystemIndexesMutex.Unlock()
+	for _, root := range roots {
+		if _, exists := filesystemIndexes[root]; exists {
+			continue
+		}
+		index := &filesystemIndex{files: make(map[string]filesystemIndexEntry), hashes: make(map[string][]string)}
+		filesystemIndexes[root] = index
+		go buildFilesystemIndex(root, index, cached[root])
+	}
+	filesystemIndexSaver.Do(func() {
+		go func() {
+			for {
+				time.Sleep(filesystemIndexSaveInterval)
+				saveFilesystemIndexCache()
+			}
+		}()
+	})
+}
+
+// Walks the root, hashing files that are new or changed since they were cached. Files gone since are left out.
+func buildFilesystemIndex(root string, index *filesystemIndex, cached map[string]filesystemIndexEntry) {
+	log.Println(logPrefixFilesystemIndex, color.CyanString("Indexing files under \"%s\"...", root))
+	started, lastProgress := time.Now(), time.Now()
+	var files, hashed int
+	var size int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		entry, exists := cached[path]
+		if !exists || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+			hash, err := hashFile(path)
+			if err != nil {
+				return nil
+			}
+			entry = filesystemIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hash}
+			hashed++
+		}
+		filesystemIndexesMutex.Lock()
+		index.add(path, entry)
+		filesystemIndexesMutex.Unlock()
+		files++
+		size += info.Size()
+		if time.Since(lastProgress) >= filesystemIndexProgressInterval {
+			log.Println(logPrefixFilesystemIndex, color.CyanString("Indexed %s files (%s) under \"%s\" so far, %s hashed", formatNumber(int64(files)), formatBytes(size), root, formatNumber(int64(hashed))))
+			lastProgress = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(logPrefixFilesystemIndex, color.HiRedString("Failed to index \"%s\":\t%s", root, err))
+	}
+	filesystemIndexesMutex.Lock()
+	index.ready = true
+	filesystemIndexesDirty = true
+	filesystemIndexesMutex.Unlock()
+	saveFilesystemIndexCache()
+	log.Println(logPrefixFilesystemIndex, color.HiCyanString("Indexed %s files (%s) under \"%s\" in %s, %s hashed", formatNumber(int64(files)), formatBytes(size), root,
+		time.Since(started).Round(time.Second), formatNumber(int64(hashed))))
+}
+
+// SHA-256 read a piece at a time, like hashBytes for files too big to read at once.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Indexes path is under, an index still being built is searched as far as it's got.
+func getFilesystemIndexesFor(path string) []*filesystemIndex {
+	path = filepath.Clean(path)
+	var indexes []*filesystemIndex
+	for root, index := range filesystemIndexes {
+		if path == root || strings.HasPrefix(path, root+string(os.PathSeparator)) || root == "." {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// An indexed file under the same root as destination with the same contents, "" if there isn't one. Files that
+// were deleted or changed since they were indexed are dropped from the index.
+func findFilesystemDuplicate(destination string, hash string, size int64) string {
+	filesystemIndexesMutex.Lock()
+	defer filesystemIndexesMutex.Unlock()
+	for _, index := range getFilesystemIndexesFor(destination) {
+		for _, path := range append([]string{}, index.hashes[hash]...) {
+			info, err := os.Stat(path)
+			if err != nil || info.Size() != size || info.ModTime().UnixNano() != index.files[path].ModTime {
+				index.remove(path)
+				filesystemIndexesDirty = true
+				continue
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// Adds a file the bot saved to the index it's under, if any.
+func addToFilesystemIndex(path string, hash string) {
+	filesystemIndexesMutex.Lock()
+	defer filesystemIndexesMutex.Unlock()
+	indexes := getFilesystemIndexesFor(path)
+	if len(indexes) == 0 {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	for _, index := range indexes {
+		index.add(filepath.Clean(path), filesystemIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hash})
+	}
+	filesystemIndexesDirty = true
+}
+
+func loadFilesystemIndexCache() map[string]map[string]filesystemIndexEntry {
+	cached := make(map[string]map[string]filesystemIndexEntry)
+	data, err := ioutil.ReadFile(filesystemIndexPath)
+	if err != nil {
+		return cached
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Println(logPrefixFilesystemIndex, color.HiRedString("Failed to read \"%s\", every file will be hashed again:\t%s", filesystemIndexPath, err))
+	}
+	return cached
+}
+
+// Writes the indexes to the cache file if they've changed, once none are still being built.
+func saveFilesystemIndexCache() {
+	filesystemIndexesMutex.Lock()
+	if !filesystemIndexesDirty {
+		filesystemIndexesMutex.Unlock()
+		return
+	}
+	cached := make(map[string]map[string]filesystemIndexEntry)
+	for root, index := range filesystemIndexes {
+		if !index.ready {
+			filesystemIndexesMutex.Unlock()
+			return
+		}
+		files := make(map[string]filesystemIndexEntry, len(index.files))
+		for path, entry := range index.files {
+			files[path] = entry
+		}
+		cached[root] = files
+	}
+	filesystemIndexesDirty = false
+	filesystemIndexesMutex.Unlock()
+
+	data, err := json.Marshal(cached)
+	if err == nil {
+		if err = os.MkdirAll(cachePath, 0755); err == nil {
+			err = ioutil.WriteFile(filesystemIndexPath, data, 0644)
+		}
+	}
+	if err != nil {
+		log.Println(logPrefixFilesystemIndex, color.HiRedString("Failed to save \"%s\":\t%s", filesystemIndexPath, err))
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"html/template"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Web gallery for browsing what's been saved, off unless webAddress is set and behind basic auth. Files are only
+// served by their database row, and only from inside the configured destinations.
+
+var logPrefixGallery = color.HiCyanString("[Gallery]")
+
+const galleryPageSize = 60
+
+// Kinds of file the gallery filters by and mirrors can be limited to
+var fileTypes = []string{"image", "video", "audio", "text", "other"}
+
+type galleryLink struct {
+	Name string
+	URL  string
+}
+
+type galleryItem struct {
+	ID        int
+	Filename  string
+	Type      string
+	Size      string
+	Thumbnail bool
+	User      galleryLink
+	Tags      []galleryLink
+}
+
+type galleryGroup struct {
+	Server  string
+	Channel galleryLink
+	Date    string
+	Items   []galleryItem
+}
+
+type galleryPage struct {
+	Query, Channel, Type, User, Tag string
+	Types                           []string
+	Groups                          []galleryGroup
+	Total, Page, Pages              int
+	NewerURL, OlderURL              string
+}
+
+func startGallery() {
+	settings := getConfig()
+	if settings.WebAddress == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", galleryAuthorized(galleryIndex))
+	mux.HandleFunc("/file/", galleryAuthorized(galleryFile))
+	mux.HandleFunc("/thumb/", galleryAuthorized(galleryFile))
+	mux.HandleFunc("/feeds/", galleryAuthorized(galleryFeed))
+
+	address := getAPIListenAddress(settings.WebAddress)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Println(logPrefixGallery, color.HiRedString("Failed to listen on %s:\t%s", address, err))
+		return
+	}
+	log.Println(logPrefixGallery, color.HiGreenString("Listening on http://%s", listener.Addr()))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(listener); err != nil {
+		log.Println(logPrefixGallery, color.HiRedString("Stopped serving:\t%s", err))
+	}
+}
+
+func galleryAuthorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings := getConfig()
+		username, password, ok := r.BasicAuth()
+		if !ok || settings.Credentials.WebPassword == "" ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(settings.Credentials.WebUsername)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(settings.Credentials.WebPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="discord-downloader-go", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// "image", "video", "audio", "text" or "other", by extension. Compressed files go by the extension under .zst.
+func getFileType(path string) string {
+	extension := savedExtension(path)
+	if strings.HasSuffix(strings.ToLower(extension), zstdExtension) {
+		extension = strings.TrimSuffix(strings.ToLower(extension), zstdExtension)
+	}
+	extension = strings.ToLower(extension)
+	kind := fixContentType(extension, strings.Split(mime.TypeByExtension(extension), "/")[0])
+	if !stringInSlice(kind, fileTypes) {
+		return "other"
+	}
+	return kind
+}
+
+//#region Files
+
+// Local folders files can be served from, the destinations in settings and the folders the bot keeps files in.
+func getGalleryRoots() []string {
+	settings := getConfig()
+	var roots []string
+	for _, destination := range getConfiguredDestinations() {
+		if !isRemoteDestination(destination) {
+			roots = append(roots, getDestinationRoot(settings.BasePath, destination))
+		}
+	}
+	return append(roots, getCASRoot(), applyBasePath(settings.BasePath, thumbnailsFolder))
+}
+
+// Whether path, once links are followed, is inside one of the gallery roots.
+func isGalleryPath(path string) bool {
+	resolve := func(path string) string {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		if absolute, err := filepath.Abs(path); err == nil {
+			path = absolute
+		}
+		return path
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	path = resolve(path)
+	for _, root := range getGalleryRoots() {
+		inside, err := filepath.Rel(resolve(root), path)
+		if err == nil && inside != ".." && !strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /file/{row} and /thumb/{row}. ServeContent handles ranges, so videos can be seeked.
+func galleryFile(w http.ResponseWriter, r *http.Request) {
+	thumbnail := strings.HasPrefix(r.URL.Path, "/thumb/")
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/file/"), "/thumb/"), "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := dbDownloads().Read(id); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	download := dbFindDownloadByID(id)
+	path := download.Destination
+	if thumbnail {
+		path = download.ThumbnailPath
+	}
+	if path == "" || isRemoteDestination(path) || !isGalleryPath(path) {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+}
+
+//#endregion
+
+//#region Pages
+
+// GET /?q=...&channel=...&type=...&user=...&tag=...&page=..., newest first.
+func galleryIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	values := r.URL.Query()
+	page := galleryPage{
+		Query:   values.Get("q"),
+		Channel: values.Get("channel"),
+		Type:    values.Get("type"),
+		User:    values.Get("user"),
+		Tag:     values.Get("tag"),
+		Types:   fileTypes,
+	}
+	linkTo := func(key string, value string) string {
+		linked := url.Values{}
+		for _, name := range []string{"q", "channel", "type", "user", "tag"} {
+			if values.Get(name) != "" {
+				linked.Set(name, values.Get(name))
+			}
+		}
+		linked.Set(key, value)
+		return "/?" + linked.Encode()
+	}
+
+	rows := dbSearchDownloadRows(page.Query, page.Channel, page.Tag, "", 0)
+	var ids []int
+	for id, download := range rows {
+		if (page.User == "" || download.UserID == page.User) &&
+			(page.Type == "" || getFileType(download.Destination) == page.Type) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return rows[ids[i]].Time.After(rows[ids[j]].Time) })
+
+	page.Total = len(ids)
+	page.Pages = (len(ids) + galleryPageSize - 1) / galleryPageSize
+	if page.Pages == 0 {
+		page.Pages = 1
+	}
+	page.Page, _ = strconv.Atoi(values.Get("page"))
+	if page.Page < 1 || page.Page > page.Pages {
+		page.Page = 1
+	}
+	if page.Page > 1 {
+		page.NewerURL = linkTo("page", strconv.Itoa(page.Page-1))
+	}
+	if page.Page < page.Pages {
+		page.OlderURL = linkTo("page", strconv.Itoa(page.Page+1))
+	}
+	start := (page.Page - 1) * galleryPageSize
+	end := start + galleryPageSize
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	// Grouped by server, channel and day, in the order they come
+	for _, id := range ids[start:end] {
+		download := rows[id]
+		guildID := getChannelGuildID(download.ChannelID)
+		server := "Direct Messages"
+		if guildID != "" {
+			server = getGuildName(guildID)
+		}
+		channel := galleryLink{getChannelName(download.ChannelID), linkTo("channel", download.ChannelID)}
+		date := download.Time.Format("2006-01-02")
+		if len(page.Groups) == 0 || page.Groups[len(page.Groups)-1].Channel != channel || page.Groups[len(page.Groups)-1].Date != date {
+			page.Groups = append(page.Groups, galleryGroup{Server: server, Channel: channel, Date: date})
+		}
+
+		item := galleryItem{
+			ID:        id,
+			Filename:  filepath.Base(download.Destination),
+			Type:      getFileType(download.Destination),
+			Size:      formatBytes(download.Size),
+			Thumbnail: download.ThumbnailPath != "",
+			User:      galleryLink{getGalleryUserName(guildID, download.UserID), linkTo("user", download.UserID)},
+		}
+		for _, tag := range download.Tags {
+			item.Tags = append(item.Tags, galleryLink{tag, linkTo("tag", tag)})
+		}
+		group := &page.Groups[len(page.Groups)-1]
+		group.Items = append(group.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := galleryTemplate.Execute(w, page); err != nil {
+		log.Println(logPrefixGallery, color.HiRedString("Failed to render page:\t%s", err))
+	}
+}
+
+// Username if the member's cached, their ID if not.
+func getGalleryUserName(guildID string, userID string) string {
+	if guildID != "" {
+		if member, err := bot.State.Member(guildID, userID); err == nil && member.User != nil {
+			return member.User.Username
+		}
+	}
+	return userID
+}
+
+// Built into the binary from the templates folder.
+//
+//go:embed templates
+var galleryTemplates embed.FS
+
+var galleryTemplate = template.Must(template.ParseFS(galleryTemplates, "templates/gallery.html"))
+
+//#endregion
@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -16,6 +18,20 @@ type fileItem struct {
 	Link     string
 	Filename string
 	Time     time.Time
+	Size     int64 // expected size if known, e.g. attachments, 0 otherwise
+	// Tried instead if Link is gone, Discord's proxied copy of an embed image
+	FallbackLink string
+	// Size the embed says the image is, 0 if unknown
+	Width, Height int
+	// Set for attachments, so an expired link can be signed again
+	AttachmentID string
+	// Where the attachment is in the message, from 1, 0 for anything else
+	AttachmentIndex int
+	// Attachment alt text and the title of the embed it came from, for filenameFormat
+	AltText    string
+	EmbedTitle string
+	// The post a site handler found it in
+	Post sitePost
 }
 
 var (
@@ -30,18 +46,22 @@ var (
 //#region Events
 
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	handleMessage(m.Message, false, false)
+	handleMessage(m.Message, false, nil)
 }
 
 func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
 	if m.EditedTimestamp != discordgo.Timestamp("") {
-		handleMessage(m.Message, true, false)
+		handleMessage(m.Message, true, nil)
 	}
 }
 
-func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
-	// Ignore own messages unless told not to
-	if m.Author.ID == user.ID && !config.ScanOwnMessages {
+// Messages from history runs come with the run's options, live ones with nil.
+func handleMessage(m *discordgo.Message, edited bool, run *historyOptions) int64 {
+	settings := getConfig()
+	history := run != nil
+
+	// Ignore own messages unless told not to, and ignoreAuthors
+	if isIgnoredAuthor(m) {
 		return -1
 	}
 
@@ -66,8 +86,12 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 	}
 
 	// Registered Channel
-	if isChannelRegistered(m.ChannelID) {
-		channelConfig := getChannelConfig(m.ChannelID)
+	if settings.isChannelRegistered(m.ChannelID) {
+		channelConfig := settings.getChannelConfig(m.ChannelID)
+		// Links for the download command go to its own destination
+		if isManualDownloadCommand(m) {
+			return -1
+		}
 		// Ignore bots if told to do so
 		if m.Author.Bot && *channelConfig.IgnoreBots {
 			return -1
@@ -76,11 +100,23 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 		if (!history && !*channelConfig.Enabled) || (edited && !*channelConfig.ScanEdits) {
 			return -1
 		}
+		// History runs hold off requesting more until live messages are handled
+		if !history {
+			atomic.AddInt64(&liveMessagesHandling, 1)
+			defer atomic.AddInt64(&liveMessagesHandling, -1)
+		}
 
 		m = fixMessage(m)
 
+		// Dry runs skip everything that writes
+		var dryRunReport *dryRunReport
+		if history {
+			dryRunReport = run.dryRun
+		}
+		dryRun := dryRunMode || dryRunReport != nil
+
 		// Log
-		if config.MessageOutput {
+		if settings.MessageOutput {
 			sendLabel := fmt.Sprintf("%s in \"%s\"#%s",
 				getUserIdentifier(*m.Author),
 				getGuildName(m.GuildID), getChannelName(m.ChannelID),
@@ -98,7 +134,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 		}
 
 		// Log Messages to File
-		if channelConfig.LogMessages != nil {
+		if channelConfig.LogMessages != nil && !dryRun {
 			if channelConfig.LogMessages.Destination != "" {
 				logPath := channelConfig.LogMessages.Destination
 				if *channelConfig.LogMessages.DestinationIsFolder == true {
@@ -196,6 +232,11 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 			}
 		}
 
+		// Transcript
+		if channelConfig.SaveMessageContent != nil && *channelConfig.SaveMessageContent == messageContentChannelLog && !dryRun {
+			appendTranscript(m, channelConfig, edited, history)
+		}
+
 		// Filters
 		if channelConfig.Filters != nil {
 			shouldAbort := false
@@ -204,7 +245,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 				channelConfig.Filters.AllowedUsers != nil ||
 				channelConfig.Filters.AllowedRoles != nil {
 				shouldAbort = true
-				if config.DebugOutput {
+				if settings.DebugOutput {
 					log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("Filter will be ignoring by default..."))
 				}
 			}
@@ -213,7 +254,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 				for _, phrase := range *channelConfig.Filters.BlockedPhrases {
 					if strings.Contains(m.Content, phrase) {
 						shouldAbort = true
-						if config.DebugOutput {
+						if settings.DebugOutput {
 							log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("blockedPhrases found \"%s\" in message, planning to abort...", phrase))
 						}
 						break
@@ -224,7 +265,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 				for _, phrase := range *channelConfig.Filters.AllowedPhrases {
 					if strings.Contains(m.Content, phrase) {
 						shouldAbort = false
-						if config.DebugOutput {
+						if settings.DebugOutput {
 							log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("allowedPhrases found \"%s\" in message, planning to process...", phrase))
 						}
 						break
@@ -235,7 +276,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 			if channelConfig.Filters.BlockedUsers != nil {
 				if stringInSlice(m.Author.ID, *channelConfig.Filters.BlockedUsers) {
 					shouldAbort = true
-					if config.DebugOutput {
+					if settings.DebugOutput {
 						log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("blockedUsers caught %s, planning to abort...", m.Author.ID))
 					}
 				}
@@ -243,7 +284,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 			if channelConfig.Filters.AllowedUsers != nil {
 				if stringInSlice(m.Author.ID, *channelConfig.Filters.AllowedUsers) {
 					shouldAbort = false
-					if config.DebugOutput {
+					if settings.DebugOutput {
 						log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("allowedUsers caught %s, planning to process...", m.Author.ID))
 					}
 				}
@@ -253,7 +294,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 				for _, role := range m.Member.Roles {
 					if stringInSlice(role, *channelConfig.Filters.BlockedRoles) {
 						shouldAbort = true
-						if config.DebugOutput {
+						if settings.DebugOutput {
 							log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("blockedRoles caught %s, planning to abort...", role))
 						}
 						break
@@ -264,7 +305,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 				for _, role := range m.Member.Roles {
 					if stringInSlice(role, *channelConfig.Filters.AllowedRoles) {
 						shouldAbort = false
-						if config.DebugOutput {
+						if settings.DebugOutput {
 							log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.YellowString("allowedRoles caught %s, planning to allow...", role))
 						}
 						break
@@ -274,7 +315,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 
 			// Abort
 			if shouldAbort {
-				if config.DebugOutput {
+				if settings.DebugOutput {
 					log.Println(logPrefixDebug, color.HiMagentaString("(FILTER)"), color.HiYellowString("Filter decided to ignore message..."))
 				}
 				return -1
@@ -286,7 +327,7 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 		}
 
 		// Skipping
-		canSkip := config.AllowSkipping
+		canSkip := settings.AllowSkipping
 		if channelConfig.OverwriteAllowSkipping != nil {
 			canSkip = *channelConfig.OverwriteAllowSkipping
 		}
@@ -301,27 +342,60 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 
 		// Process Files
 		var downloadCount int64
-		files := getFileLinks(m)
-		for _, file := range files {
+		var statuses []downloadStatusStruct
+		files := getFileLinks(m, run, settings)
+		// Everything is journaled first so a restart partway through doesn't lose the rest
+		requests := make([]downloadRequestStruct, len(files))
+		journaled := make([]int, len(files))
+		for i, file := range files {
+			journaled[i] = -1
 			if file.Link == "" {
 				continue
 			}
-			if config.DebugOutput {
+			requests[i] = downloadRequestStruct{
+				InputURL:       file.Link,
+				Filename:       file.Filename,
+				Path:           channelConfig.Destination,
+				Message:        m,
+				FileTime:       file.Time,
+				ExpectedSize:   file.Size,
+				AttachmentID:   file.AttachmentID,
+				AltText:        file.AltText,
+				EmbedTitle:     file.EmbedTitle,
+				Post:           file.Post,
+				FallbackURL:    file.FallbackLink,
+				WidthHint:      file.Width,
+				HeightHint:     file.Height,
+				HistoryCmd:     history,
+				HistoryQuiet:   history && run.quiet,
+				HistoryForce:   history && run.force,
+				HistoryMissing: history && run.missingOnly,
+				EmojiCmd:       false,
+				DryRun:         dryRun,
+				DryRunReport:   dryRunReport,
+				Settings:       settings,
+			}
+			journaled[i] = journalPendingDownload(requests[i])
+		}
+		for i, file := range files {
+			if file.Link == "" {
+				continue
+			}
+			if settings.DebugOutput {
 				log.Println(logPrefixDebug, color.CyanString("FOUND FILE: "+file.Link))
 			}
-			status := startDownload(
-				downloadRequestStruct{
-					InputURL:   file.Link,
-					Filename:   file.Filename,
-					Path:       channelConfig.Destination,
-					Message:    m,
-					FileTime:   file.Time,
-					HistoryCmd: history,
-					EmojiCmd:   false,
-				})
+			status := startDownload(requests[i])
+			completePendingDownload(journaled[i])
 			if status.Status == downloadSuccess {
 				downloadCount++
 			}
+			statuses = append(statuses, status)
+		}
+		if channelConfig.SaveMessageContent != nil && *channelConfig.SaveMessageContent == messageContentPerMessage && !dryRun {
+			saveMessageContentFile(m, channelConfig, statuses)
+		}
+		if !history && !dryRun {
+			handleDownloadedMessage(m, channelConfig, statuses)
 		}
 		return downloadCount
 	}
@@ -329,4 +403,72 @@ func handleMessage(m *discordgo.Message, edited bool, history bool) int64 {
 	return -1
 }
 
+// Channels already warned about deleteAfterDownload lacking Manage Messages
+var deleteAfterDownloadWarned sync.Map
+
+// Replies to and/or deletes a message once its files are handled, per replyAfterDownload and deleteAfterDownload.
+func handleDownloadedMessage(m *discordgo.Message, channelConfig configurationChannel, statuses []downloadStatusStruct) {
+	var count int
+	var totalSize int64
+	handled := len(statuses) > 0
+	for _, status := range statuses {
+		switch {
+		case status.Status == downloadSuccess:
+			count++
+			if status.Size > 0 {
+				totalSize += status.Size
+			}
+		case *channelConfig.DeleteAfterDuplicates &&
+			(status.Status == downloadSkippedDuplicate || status.Status == downloadSkippedDetectedDuplicate ||
+				status.Status == downloadSkippedAlreadyRecorded):
+			// Already saved from somewhere, counts as handled
+		default:
+			handled = false
+		}
+	}
+	if count > 0 && channelConfig.ReplyAfterDownload != nil && *channelConfig.ReplyAfterDownload != "" {
+		content := *channelConfig.ReplyAfterDownload
+		for _, key := range [][]string{
+			{"{count}", formatNumber(int64(count))},
+			{"{totalSize}", formatBytes(totalSize)},
+			{"{user}", "<@" + m.Author.ID + ">"},
+		} {
+			content = strings.ReplaceAll(content, key[0], key[1])
+		}
+		if hasPerms(m.ChannelID, discordgo.PermissionEmbedLinks) {
+			_, err := bot.ChannelMessageSendEmbed(m.ChannelID, buildEmbed(m.ChannelID, "", content))
+			if err != nil {
+				log.Println(logPrefixErrorLabel("replyAfterDownload"), color.HiRedString("Failed to reply in %s:\t%s", m.ChannelID, err))
+			}
+		} else {
+			log.Println(logPrefixErrorLabel("replyAfterDownload"), color.HiRedString(fmtBotSendPerm, m.ChannelID))
+		}
+	}
+
+	if !*channelConfig.DeleteAfterDownload || !handled {
+		return
+	}
+	if _, late := lateDownloadMessages.Load(m.ID); late {
+		return
+	}
+	if m.Author.ID != user.ID && !hasPerms(m.ChannelID, discordgo.PermissionManageMessages) {
+		if _, warned := deleteAfterDownloadWarned.LoadOrStore(m.ChannelID, true); !warned {
+			log.Println(logPrefixErrorLabel("deleteAfterDownload"), color.HiRedString("Bot needs Manage Messages in %s to delete messages, leaving them", m.ChannelID))
+		}
+		return
+	}
+	var delay time.Duration
+	if channelConfig.DeleteAfterDownloadDelay != nil {
+		delay, _ = time.ParseDuration(*channelConfig.DeleteAfterDownloadDelay)
+	}
+	go func() {
+		time.Sleep(delay)
+		botDeletedMessages.Store(m.ID, true)
+		if err := bot.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+			botDeletedMessages.Delete(m.ID)
+			log.Println(logPrefixErrorLabel("deleteAfterDownload"), color.HiRedString("Failed to delete message %s in %s:\t%s", m.ID, m.ChannelID, err))
+		}
+	}()
+}
+
 //#endregion
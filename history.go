@@ -5,7 +5,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -14,10 +17,130 @@ import (
 )
 
 var (
-	historyStatus map[string]string
+	historyStatus      map[string]string
+	historyStatusMutex sync.RWMutex
+	// Links skipped during history, keyed by channel and read for the history summary
+	historySkips      = make(map[string]*historySkipTally)
+	historySkipsMutex sync.Mutex
+
+	historyServerStatus      = make(map[string]string) // keyed by guild ID
+	historyServerStatusMutex sync.RWMutex
+
+	autoHistoryQueue   = make(chan string, 1024)
+	autoHistoryPending = make(map[string]bool)
+	autoHistoryLastRun = make(map[string]time.Time)
+	autoHistoryMutex   sync.Mutex
 )
 
-func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string, before string, since string) int {
+// How a history run was asked to go. Handed from whoever starts the run down to each message and download it
+// handles, so live messages and other runs in the channel never pick it up.
+type historyOptions struct {
+	quiet    bool          // scheduled catch-ups only log their summary
+	dryRun   *dryRunReport // set for dry runs, tallies what would be downloaded
+	noLimits bool          // maxLinksPerMessage and maxFilesPerAlbum are ignored
+	noWait   bool          // starts without waiting for the download schedule's window
+	limit    int64         // messages to check at most in each channel, 0 for all
+	// Links are downloaded again even if they already were, or only if their files are gone. Either way the rows
+	// are updated rather than added again
+	force, missingOnly bool
+}
+
+// History status per channel, "downloading" or "cancel", read and written from commands, the API and runs
+func getHistoryStatus(channelID string) string {
+	historyStatusMutex.RLock()
+	defer historyStatusMutex.RUnlock()
+	return historyStatus[channelID]
+}
+
+func setHistoryStatus(channelID string, status string) {
+	historyStatusMutex.Lock()
+	historyStatus[channelID] = status
+	historyStatusMutex.Unlock()
+}
+
+func clearHistoryStatus(channelID string) {
+	historyStatusMutex.Lock()
+	delete(historyStatus, channelID)
+	historyStatusMutex.Unlock()
+}
+
+// Server history status per guild, the same as historyStatus is per channel
+func getServerHistoryStatus(guildID string) string {
+	historyServerStatusMutex.RLock()
+	defer historyServerStatusMutex.RUnlock()
+	return historyServerStatus[guildID]
+}
+
+// Marks server history as downloading, false if it already was running
+func startServerHistoryStatus(guildID string) bool {
+	historyServerStatusMutex.Lock()
+	defer historyServerStatusMutex.Unlock()
+	if historyServerStatus[guildID] != "" {
+		return false
+	}
+	historyServerStatus[guildID] = "downloading"
+	return true
+}
+
+func clearServerHistoryStatus(guildID string) {
+	historyServerStatusMutex.Lock()
+	delete(historyServerStatus, guildID)
+	historyServerStatusMutex.Unlock()
+}
+
+// Asks a running server history to stop, false if none was downloading
+func cancelServerHistory(guildID string) bool {
+	historyServerStatusMutex.Lock()
+	defer historyServerStatusMutex.Unlock()
+	if historyServerStatus[guildID] != "downloading" {
+		return false
+	}
+	historyServerStatus[guildID] = "cancel"
+	return true
+}
+
+// Asks a running history to stop, false if none was downloading
+func cancelHistory(channelID string) bool {
+	historyStatusMutex.Lock()
+	defer historyStatusMutex.Unlock()
+	if historyStatus[channelID] != "downloading" {
+		return false
+	}
+	historyStatus[channelID] = "cancel"
+	return true
+}
+
+// Where a history run asks for its next batch. Runs back from before (or the newest message) a batch at a time,
+// except runs with only since, which page forward from it to the newest message. Paging back from the newest
+// would stop at the first batch older than since, leaving out everything between.
+type historyPager struct {
+	beforeID, sinceID string
+	forward           bool
+}
+
+func newHistoryPager(before string, since string) *historyPager {
+	return &historyPager{beforeID: before, sinceID: since, forward: since != "" && before == ""}
+}
+
+// Moves the pager past a batch, returning it in the order it's gone through. Batches come newest first.
+func (pager *historyPager) advance(messages []*discordgo.Message) []*discordgo.Message {
+	if !pager.forward {
+		pager.beforeID = messages[len(messages)-1].ID
+		pager.sinceID = ""
+		return messages
+	}
+	ordered := append([]*discordgo.Message{}, messages...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, _ := strconv.ParseInt(ordered[i].ID, 10, 64)
+		b, _ := strconv.ParseInt(ordered[j].ID, 10, 64)
+		return a < b
+	})
+	pager.sinceID = ordered[len(ordered)-1].ID
+	return ordered
+}
+
+func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string, before string, since string, options historyOptions) int {
+	settings := getConfig()
 	// Identifier
 	var commander string = "AUTORUN"
 	if commandingMessage != nil {
@@ -33,22 +156,23 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	}
 
 	// Mark active
-	historyStatus[subjectChannelID] = "downloading"
+	setHistoryStatus(subjectChannelID, "downloading")
+
+	// Dry runs don't download anything, so they never wait
+	if options.dryRun == nil && !options.noWait {
+		if !waitForScheduleWindow(commandingMessage, subjectChannelID) {
+			clearHistoryStatus(subjectChannelID)
+			return 0
+		}
+	}
 
 	var i int64 = 0
 	var d int64 = 0
 	var batch int = 0
+	var lastRequest time.Time
 
-	var beforeID string
-	if before != "" {
-		beforeID = before
-	}
-	var beforeTime time.Time
-
-	var sinceID string
-	if since != "" {
-		sinceID = since
-	}
+	pager := newHistoryPager(before, since)
+	var batchTime time.Time // of the last message requested from, zero before the first batch
 
 	rangeContent := ""
 	if since != "" {
@@ -72,21 +196,24 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	var err error
 	var message *discordgo.Message = nil
 
-	if isChannelRegistered(subjectChannelID) {
-		channelConfig := getChannelConfig(subjectChannelID)
+	if settings.isChannelRegistered(subjectChannelID) {
+		channelConfig := settings.getChannelConfig(subjectChannelID)
 
 		// Open Cache File?
-		if historyCachePath != "" {
+		if historyCachePath != "" && options.dryRun == nil && !pager.forward {
 			filepath := historyCachePath + string(os.PathSeparator) + subjectChannelID
 			if f, err := ioutil.ReadFile(filepath); err == nil {
-				beforeID = string(f)
-				if commandingMessage != nil && config.DebugOutput {
+				pager.beforeID = string(f)
+				if commandingMessage != nil && settings.DebugOutput {
 					log.Println(logPrefixDebug, color.YellowString(logPrefix+"Found a cache file, picking up where we left off...", subjectChannelID, commander))
 				}
 			}
 		}
 
 		historyStartTime := time.Now()
+		takeFailedURLSkips(subjectChannelID) // counted fresh for this run
+		takeHistorySkips(subjectChannelID)
+		takeReactionsDropped(subjectChannelID)
 
 		// Initial Status Message
 		if commandingMessage != nil {
@@ -102,16 +229,18 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 				log.Println(logPrefixHistory, color.HiRedString(logPrefix+fmtBotSendPerm, commandingMessage.ChannelID))
 			}
 		}
-		log.Println(logPrefixHistory, color.CyanString(logPrefix+"Began checking history for %s...", subjectChannelID))
+		if !options.quiet {
+			log.Println(logPrefixHistory, color.CyanString(logPrefix+"Began checking history for %s...", subjectChannelID))
+		}
 
 	MessageRequestingLoop:
 		for true {
-			// Next 100
-			if beforeTime != (time.Time{}) {
+			// Next batch
+			if batchTime != (time.Time{}) {
 				batch++
 
 				// Write to cache file
-				if historyCachePath != "" {
+				if historyCachePath != "" && options.dryRun == nil && !pager.forward {
 					err := os.MkdirAll(historyCachePath, 0755)
 					if err != nil {
 						log.Println(logPrefixHistory, color.HiRedString("Error while creating history cache folder \"%s\": %s", historyCachePath, err))
@@ -122,9 +251,9 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 					if err != nil {
 						log.Println(logPrefixHistory, color.RedString("Failed to open cache file:\t%s", err))
 					}
-					if _, err = f.WriteString(beforeID); err != nil {
+					if _, err = f.WriteString(pager.beforeID); err != nil {
 						log.Println(logPrefixHistory, color.RedString("Failed to write cache file:\t%s", err))
-					} else if commandingMessage != nil && config.DebugOutput {
+					} else if commandingMessage != nil && settings.DebugOutput {
 						log.Println(logPrefixDebug, logPrefixHistory, color.YellowString(logPrefix+"Wrote to cache file."))
 					}
 					f.Close()
@@ -132,8 +261,12 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 
 				// Status Update
 				if commandingMessage != nil {
-					log.Println(logPrefixHistory, color.CyanString(logPrefix+"Requesting 100 more, %d downloaded, %d processed — Before %s",
-						d, i, beforeTime))
+					direction := "Before"
+					if pager.forward {
+						direction = "After"
+					}
+					log.Println(logPrefixHistory, color.CyanString(logPrefix+"Requesting %d more, %d downloaded, %d processed — %s %s",
+						getHistoryBatchSize(), d, i, direction, batchTime))
 					if message != nil {
 						if hasPerms(message.ChannelID, discordgo.PermissionSendMessages) {
 							content := fmt.Sprintf("``%s:`` **%s files downloaded**\n``%s messages processed``\n\n`Server:` **%s**\n`Channel:` _#%s_\n\n%s`(%d)` _Processing more messages, please wait..._",
@@ -142,11 +275,7 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 								getGuildName(getChannelGuildID(subjectChannelID)),
 								getChannelName(subjectChannelID),
 								rangeContent, batch)
-							message, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
-								ID:      message.ID,
-								Channel: message.ChannelID,
-								Embed:   buildEmbed(message.ChannelID, "Command — History", content),
-							})
+							message, err = editEmbed(message, "Command — History", content)
 							// Edit failure, so send replacement status
 							if err != nil {
 								log.Println(logPrefixHistory, color.RedString(logPrefix+"Failed to edit status message, sending new one:\t%s", err))
@@ -164,35 +293,39 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 				}
 				// Update presence
 				timeLastUpdated = time.Now()
+				setPresenceHistory(subjectChannelID, d, i, historyStartTime)
 				if *channelConfig.UpdatePresence {
-					updateDiscordPresence()
+					queuePresenceUpdate()
 				}
 			}
 
 			// Request More
-			messages, err := bot.ChannelMessages(subjectChannelID, 100, beforeID, sinceID, "")
+			if batchTime != (time.Time{}) {
+				waitUserSessionHistory()
+			}
+			waitHistoryRequest(&lastRequest)
+			messages, err := getHistoryMessages(subjectChannelID, getHistoryBatchSize(), pager.beforeID, pager.sinceID)
 			if err == nil {
 				// No More Messages
 				if len(messages) <= 0 {
-					delete(historyStatus, subjectChannelID)
+					clearHistoryStatus(subjectChannelID)
 					break MessageRequestingLoop
 				}
-				// Go Back
-				beforeID = messages[len(messages)-1].ID
-				beforeTime, err = messages[len(messages)-1].Timestamp.Parse()
+				// Next batch starts past this one
+				messages = pager.advance(messages)
+				batchTime, err = messages[len(messages)-1].Timestamp.Parse()
 				if err != nil {
 					log.Println(logPrefixHistory, color.RedString(logPrefix+"Failed to fetch message timestamp:\t%s", err))
 				}
-				sinceID = ""
 				// Process Messages
-				if *channelConfig.TypeWhileProcessing && hasPerms(commandingMessage.ChannelID, discordgo.PermissionSendMessages) {
+				if *channelConfig.TypeWhileProcessing && commandingMessage != nil && hasPerms(commandingMessage.ChannelID, discordgo.PermissionSendMessages) {
 					bot.ChannelTyping(commandingMessage.ChannelID)
 				}
 				for _, message := range messages {
 
 					// Ordered to Cancel
-					if historyStatus[message.ChannelID] == "cancel" {
-						delete(historyStatus, message.ChannelID)
+					if getHistoryStatus(message.ChannelID) == "cancel" {
+						clearHistoryStatus(message.ChannelID)
 						break MessageRequestingLoop
 					}
 
@@ -204,23 +337,29 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 					} else if before != "" {
 						before64, _ := strconv.ParseInt(before, 10, 64)
 						if message64 > before64 {
-							delete(historyStatus, message.ChannelID)
+							clearHistoryStatus(message.ChannelID)
 							break MessageRequestingLoop
 						}
 					} else if since != "" {
 						since64, _ := strconv.ParseInt(since, 10, 64)
 						if message64 < since64 {
-							delete(historyStatus, message.ChannelID)
+							clearHistoryStatus(message.ChannelID)
 							break MessageRequestingLoop
 						}
 					}
 
 					// Process
-					downloadCount := handleMessage(message, false, true)
+					downloadCount := handleMessage(message, false, &options)
 					if downloadCount > 0 {
 						d += downloadCount
 					}
 					i++
+
+					// Reached Limit
+					if options.limit > 0 && i >= options.limit {
+						clearHistoryStatus(subjectChannelID)
+						break MessageRequestingLoop
+					}
 				}
 			} else {
 				// Error requesting messages
@@ -235,28 +374,39 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 					}
 				}
 				log.Println(logPrefixHistory, color.HiRedString(logPrefix+"Error requesting messages:\t%s", err))
-				delete(historyStatus, subjectChannelID)
+				clearHistoryStatus(subjectChannelID)
 				break MessageRequestingLoop
 			}
 		}
 
+		failedSkips := takeFailedURLSkips(subjectChannelID)
+		failedContent := ""
+		if failedSkips > 0 {
+			failedContent = fmt.Sprintf("Skipped ``%s`` URL%s that failed before\n\n", formatNumber(failedSkips), pluralS(int(failedSkips)))
+		}
+		skips := takeHistorySkips(subjectChannelID)
+		if skips.alreadyRecorded+skips.duplicates+skips.filtered > 0 {
+			failedContent += fmt.Sprintf("Skipped ``%s`` already downloaded, ``%s`` duplicate%s, ``%s`` filtered out\n\n",
+				formatNumber(skips.alreadyRecorded), formatNumber(skips.duplicates), pluralS(int(skips.duplicates)), formatNumber(skips.filtered))
+		}
+		reactionsDropped := takeReactionsDropped(subjectChannelID)
+		if reactionsDropped > 0 {
+			failedContent += fmt.Sprintf("Dropped ``%s`` reaction%s to keep up with downloads\n\n", formatNumber(reactionsDropped), pluralS(int(reactionsDropped)))
+		}
+
 		// Final status update
 		if commandingMessage != nil {
 			if message != nil {
 				if hasPerms(message.ChannelID, discordgo.PermissionSendMessages) {
-					contentFinal := fmt.Sprintf("``%s:`` **%s total files downloaded!**\n``%s total messages processed``\n\n`Server:` **%s**\n`Channel:` _#%s_\n\n**FINISHED!**\nRan ``%d`` message history requests\n\n%s_Duration was %s_",
+					contentFinal := fmt.Sprintf("``%s:`` **%s total files downloaded!**\n``%s total messages processed``\n\n`Server:` **%s**\n`Channel:` _#%s_\n\n**FINISHED!**\nRan ``%d`` message history requests\n\n%s%s_Duration was %s_",
 						durafmt.ParseShort(time.Since(historyStartTime)).String(),
 						formatNumber(int64(d)), formatNumber(int64(i)),
 						getGuildName(getChannelGuildID(subjectChannelID)),
 						getChannelName(subjectChannelID),
-						batch, rangeContent,
+						batch, rangeContent, failedContent,
 						durafmt.Parse(time.Since(historyStartTime)).String(),
 					)
-					message, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
-						ID:      message.ID,
-						Channel: message.ChannelID,
-						Embed:   buildEmbed(message.ChannelID, "Command — History", contentFinal),
-					})
+					message, err = editEmbed(message, "Command — History", contentFinal)
 					// Edit failure
 					if err != nil {
 						log.Println(logPrefixHistory, color.RedString(logPrefix+"Failed to edit status message, sending new one:\t%s", err))
@@ -273,17 +423,27 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 			}
 		}
 
+		clearPresenceHistory(subjectChannelID)
+		sortTranscripts(subjectChannelID)
+
 		// Final log
-		log.Println(logPrefixHistory, color.HiCyanString(logPrefix+"Finished history, %s files", formatNumber(d)))
+		if !options.quiet {
+			log.Println(logPrefixHistory, color.HiCyanString(logPrefix+"Finished history, %s files, %s already downloaded, %s duplicates, %s filtered out, %s previously failed URLs skipped, %s reactions dropped",
+				formatNumber(d), formatNumber(skips.alreadyRecorded), formatNumber(skips.duplicates), formatNumber(skips.filtered), formatNumber(failedSkips), formatNumber(reactionsDropped)))
+		}
+		if options.dryRun == nil {
+			sendHistoryNotification(subjectChannelID, int(d), int(i), time.Since(historyStartTime))
+			flushDigests(subjectChannelID)
+		}
 
 		// Delete Cache File
-		if historyCachePath != "" {
+		if historyCachePath != "" && options.dryRun == nil {
 			filepath := historyCachePath + string(os.PathSeparator) + subjectChannelID
 			if _, err := os.Stat(filepath); err == nil {
 				err = os.Remove(filepath)
 				if err != nil {
 					log.Println(logPrefixHistory, color.HiRedString(logPrefix+"Encountered error deleting cache file:\t%s", err))
-				} else if commandingMessage != nil && config.DebugOutput {
+				} else if commandingMessage != nil && settings.DebugOutput {
 					log.Println(logPrefixDebug, logPrefixHistory, color.YellowString(logPrefix+"Deleted cache file."))
 				}
 			}
@@ -293,3 +453,225 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 
 	return int(d)
 }
+
+type historySkipTally struct {
+	alreadyRecorded, duplicates, filtered int64
+}
+
+// Links that failed before are counted apart by recordFailedURLSkip, deferred ones aren't skipped for good.
+func recordHistorySkip(channelID string, status downloadStatus) {
+	if status < downloadSkipped || status >= downloadFailed || status == downloadSkippedDeferred {
+		return
+	}
+	historySkipsMutex.Lock()
+	defer historySkipsMutex.Unlock()
+	tally := historySkips[channelID]
+	if tally == nil {
+		tally = &historySkipTally{}
+		historySkips[channelID] = tally
+	}
+	switch status {
+	case downloadSkippedAlreadyRecorded:
+		tally.alreadyRecorded++
+	case downloadSkippedDuplicate, downloadSkippedDetectedDuplicate:
+		tally.duplicates++
+	default:
+		tally.filtered++
+	}
+}
+
+// Returns and resets the channel's counts.
+func takeHistorySkips(channelID string) historySkipTally {
+	historySkipsMutex.Lock()
+	defer historySkipsMutex.Unlock()
+	tally := historySkips[channelID]
+	delete(historySkips, channelID)
+	if tally == nil {
+		return historySkipTally{}
+	}
+	return *tally
+}
+
+//#region Server History
+
+// Runs history for every registered channel in a server one after another, keeping a single combined status message.
+// Every channel is run with the same options, dry runs tally into the one report.
+func handleServerHistory(commandingMessage *discordgo.Message, guildID string, before string, since string, options historyOptions) {
+	report := options.dryRun
+	if !startServerHistoryStatus(guildID) {
+		log.Println(logPrefixHistory, color.CyanString("Server history is already running for %s...", guildID))
+		return
+	}
+	defer clearServerHistoryStatus(guildID)
+
+	startTime := time.Now()
+	channels := getBoundChannelsInGuild(guildID)
+	var skipped []string
+	var totalDownloads int
+	cancelled := false
+
+	statusContent := func(done int, footer string) string {
+		return fmt.Sprintf("``%s:`` **%s files downloaded**\n``%d/%d channels processed``\n\n`Server:` **%s**\n\n%s",
+			durafmt.ParseShort(time.Since(startTime)).String(),
+			formatNumber(int64(totalDownloads)), done, len(channels),
+			getGuildName(guildID), footer)
+	}
+	status, err := replyEmbed(commandingMessage, "Command — History", statusContent(0, "_Starting, please wait..._"))
+	if err != nil {
+		log.Println(logPrefixHistory, color.HiRedString("Failed to send server history status message:\t%s", err))
+	}
+	updateStatus := func(content string) {
+		if status == nil {
+			return
+		}
+		edited, err := editEmbed(status, "Command — History", content)
+		if err != nil {
+			log.Println(logPrefixHistory, color.RedString("Failed to edit server history status message:\t%s", err))
+		} else {
+			status = edited
+		}
+	}
+
+	log.Println(logPrefixHistory, color.CyanString("Began server history for \"%s\" (%d channels)...", getGuildName(guildID), len(channels)))
+
+	for i, channel := range channels {
+		if getServerHistoryStatus(guildID) == "cancel" {
+			cancelled = true
+			break
+		}
+		if !hasPerms(channel, discordgo.PermissionReadMessageHistory) {
+			skipped = append(skipped, channel)
+			continue
+		}
+		if getHistoryStatus(channel) != "" {
+			log.Println(logPrefixHistory, color.CyanString("History already running for %s, skipping within server history...", channel))
+			continue
+		}
+		updateStatus(statusContent(i, fmt.Sprintf("_Processing #%s, please wait..._", getChannelName(channel))))
+		totalDownloads += handleHistory(commandingMessage, channel, before, since, options)
+	}
+	if getServerHistoryStatus(guildID) == "cancel" {
+		cancelled = true
+	}
+
+	footer := "**FINISHED!**"
+	if cancelled {
+		footer = "**CANCELLED!**"
+	}
+	if len(skipped) > 0 {
+		var names []string
+		for _, channel := range skipped {
+			names = append(names, "#"+getChannelName(channel))
+		}
+		footer += fmt.Sprintf("\n\nSkipped %d channel%s lacking Read Message History permission:\n_%s_",
+			len(skipped), pluralS(len(skipped)), strings.Join(names, ", "))
+	}
+	if report != nil {
+		footer += "\n\n" + report.summary()
+	}
+	updateStatus(statusContent(len(channels), footer))
+	if report != nil {
+		sendDryRunCSV(commandingMessage, report, getGuildName(guildID))
+	}
+
+	log.Println(logPrefixHistory, color.HiCyanString("Finished server history for \"%s\", %s files, %d channel%s skipped",
+		getGuildName(guildID), formatNumber(int64(totalDownloads)), len(skipped), pluralS(len(skipped))))
+}
+
+//#endregion
+
+//#region Auto History
+
+// Queues a bounded catch-up run for the channel, ignored if one is already queued. Dropped if the queue is full,
+// the channel's interval queues it again later.
+func queueAutoHistory(channelID string) {
+	autoHistoryMutex.Lock()
+	if autoHistoryPending[channelID] {
+		autoHistoryMutex.Unlock()
+		return
+	}
+	autoHistoryPending[channelID] = true
+	autoHistoryMutex.Unlock()
+
+	select {
+	case autoHistoryQueue <- channelID:
+	default:
+		autoHistoryMutex.Lock()
+		delete(autoHistoryPending, channelID)
+		autoHistoryMutex.Unlock()
+		log.Println(logPrefixHistory, color.YellowString("%s: Catch-up queue is full, dropped scheduled catch-up", channelID))
+	}
+}
+
+// Processes queued catch-up runs one channel at a time so startup doesn't hammer the API.
+func autoHistoryWorker() {
+	for channelID := range autoHistoryQueue {
+		autoHistoryMutex.Lock()
+		delete(autoHistoryPending, channelID)
+		autoHistoryLastRun[channelID] = time.Now()
+		autoHistoryMutex.Unlock()
+
+		if getHistoryStatus(channelID) != "" {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, logPrefixHistory, color.YellowString("%s: History already running, skipping scheduled catch-up...", channelID))
+			}
+			continue
+		}
+		if !isChannelRegistered(channelID) {
+			continue
+		}
+
+		// Only fetch messages newer than what has already been recorded
+		sinceID := dbFindLatestMessageIDByChannel(channelID)
+
+		startTime := time.Now()
+		downloadCount := handleHistory(nil, channelID, "", sinceID, historyOptions{quiet: true})
+
+		log.Println(logPrefixHistory, color.CyanString("Scheduled catch-up for %s finished in %s, %d file%s downloaded",
+			getSourceName(getChannelGuildID(channelID), channelID),
+			durafmt.ParseShort(time.Since(startTime)).String(),
+			downloadCount, pluralS(downloadCount),
+		))
+	}
+}
+
+// Queues catch-up runs for channels configured to run on launch, then keeps
+// queueing channels as their configured intervals elapse.
+func startAutoHistory() {
+	go autoHistoryWorker()
+
+	for _, channel := range getAllChannels() {
+		channelConfig := getChannelConfig(channel)
+		if channelConfig.AutoHistoryOnStart != nil && *channelConfig.AutoHistoryOnStart {
+			queueAutoHistory(channel)
+		}
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for range ticker.C {
+			for _, channel := range getAllChannels() {
+				channelConfig := getChannelConfig(channel)
+				if channelConfig.AutoHistoryInterval == nil || *channelConfig.AutoHistoryInterval == "" {
+					continue
+				}
+				interval, err := time.ParseDuration(*channelConfig.AutoHistoryInterval)
+				if err != nil || interval <= 0 {
+					continue
+				}
+				autoHistoryMutex.Lock()
+				lastRun, exists := autoHistoryLastRun[channel]
+				if !exists {
+					// Start counting from launch rather than firing every channel at once
+					autoHistoryLastRun[channel] = time.Now()
+				}
+				autoHistoryMutex.Unlock()
+				if exists && time.Since(lastRun) >= interval {
+					queueAutoHistory(channel)
+				}
+			}
+		}
+	}()
+}
+
+//#endregion
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Pages through a channel the way Discord answers, batches newest first, and returns the IDs in the order they're
+// gone through.
+func pageTestHistory(t *testing.T, count int, before string, since string) []int {
+	t.Helper()
+	const batchSize = 100
+	fetch := func(beforeID string, sinceID string) []*discordgo.Message {
+		var ids []int
+		if sinceID != "" && beforeID == "" {
+			after, _ := strconv.Atoi(sinceID)
+			for id := after + 1; id <= count && len(ids) < batchSize; id++ {
+				ids = append([]int{id}, ids...)
+			}
+		} else {
+			newest := count
+			if beforeID != "" {
+				newest, _ = strconv.Atoi(beforeID)
+				newest--
+			}
+			for id := newest; id >= 1 && len(ids) < batchSize; id-- {
+				ids = append(ids, id)
+			}
+		}
+		messages := make([]*discordgo.Message, len(ids))
+		for i, id := range ids {
+			messages[i] = &discordgo.Message{ID: strconv.Itoa(id)}
+		}
+		return messages
+	}
+
+	var seen []int
+	pager := newHistoryPager(before, since)
+	for batches := 0; ; batches++ {
+		if batches > count {
+			t.Fatalf("Still paging after %d batches", batches)
+		}
+		messages := fetch(pager.beforeID, pager.sinceID)
+		if len(messages) == 0 {
+			return seen
+		}
+		for _, message := range pager.advance(messages) {
+			id, _ := strconv.Atoi(message.ID)
+			seen = append(seen, id)
+		}
+	}
+}
+
+func TestHistoryPager(t *testing.T) {
+	expect := func(t *testing.T, got []int, from int, to int) {
+		t.Helper()
+		step := 1
+		if from > to {
+			step = -1
+		}
+		var want []int
+		for id := from; id != to+step; id += step {
+			want = append(want, id)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Went through %d messages, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("Message %d was %d, want %d", i, got[i], want[i])
+			}
+		}
+	}
+
+	// A catch-up after more than a batch of messages gets all of them, oldest first
+	t.Run("since", func(t *testing.T) { expect(t, pageTestHistory(t, 350, "", "20"), 21, 350) })
+	t.Run("since, one batch", func(t *testing.T) { expect(t, pageTestHistory(t, 350, "", "300"), 301, 350) })
+	t.Run("since, up to date", func(t *testing.T) {
+		if got := pageTestHistory(t, 350, "", "350"); len(got) != 0 {
+			t.Errorf("Went through %d messages, want none", len(got))
+		}
+	})
+	t.Run("before", func(t *testing.T) { expect(t, pageTestHistory(t, 350, "250", ""), 249, 1) })
+	t.Run("everything", func(t *testing.T) { expect(t, pageTestHistory(t, 350, "", ""), 350, 1) })
+}
+
+// Only one of many commands at once gets to start a server's history, and it can be cancelled and cleared.
+func TestServerHistoryStatus(t *testing.T) {
+	const guildID = "500"
+	defer clearServerHistoryStatus(guildID)
+
+	var started int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if startServerHistoryStatus(guildID) {
+				atomic.AddInt64(&started, 1)
+			}
+			getServerHistoryStatus(guildID)
+		}()
+	}
+	wg.Wait()
+	if started != 1 {
+		t.Fatalf("Server history started %d times, want once", started)
+	}
+	if !cancelServerHistory(guildID) || getServerHistoryStatus(guildID) != "cancel" {
+		t.Fatalf("Running server history wasn't cancelled, status is %q", getServerHistoryStatus(guildID))
+	}
+	if cancelServerHistory(guildID) {
+		t.Error("Cancelled server history that was already cancelled")
+	}
+	clearServerHistoryStatus(guildID)
+	if !startServerHistoryStatus(guildID) {
+		t.Error("Server history couldn't start again after it was cleared")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var logPrefixPostDownload = color.HiMagentaString("[Post Download]")
+
+type postDownloadInfo struct {
+	Path        string
+	URL         string
+	ChannelID   string
+	UserID      string
+	ContentType string
+}
+
+//#region Command Slots
+
+var (
+	postDownloadCommandsRunning int
+	postDownloadCommandsMutex   sync.Mutex
+	postDownloadCommandsCond    = sync.NewCond(&postDownloadCommandsMutex)
+)
+
+// Blocks until fewer than postDownloadCommandLimit commands are running, returning a func to release the slot.
+func acquirePostDownloadSlot() func() {
+	postDownloadCommandsMutex.Lock()
+	for getConfig().PostDownloadCommandLimit > 0 && postDownloadCommandsRunning >= getConfig().PostDownloadCommandLimit {
+		postDownloadCommandsCond.Wait()
+	}
+	postDownloadCommandsRunning++
+	postDownloadCommandsMutex.Unlock()
+
+	return func() {
+		postDownloadCommandsMutex.Lock()
+		postDownloadCommandsRunning--
+		postDownloadCommandsMutex.Unlock()
+		postDownloadCommandsCond.Broadcast()
+	}
+}
+
+//#endregion
+
+// Runs the channel's postDownloadCommand for a saved file, in the background unless postDownloadCommandBlocking is set.
+func handlePostDownloadCommand(channelConfig configurationChannel, info postDownloadInfo) {
+	if channelConfig.PostDownloadCommand == nil || *channelConfig.PostDownloadCommand == "" {
+		return
+	}
+	// Copied so the command isn't affected by settings reloading while it waits
+	command := *channelConfig.PostDownloadCommand
+	timeout := time.Duration(*channelConfig.PostDownloadCommandTimeout) * time.Second
+	logErrors := *channelConfig.PostDownloadCommandLogErrors
+
+	if *channelConfig.PostDownloadCommandBlocking {
+		runPostDownloadCommand(command, timeout, logErrors, info)
+	} else {
+		go runPostDownloadCommand(command, timeout, logErrors, info)
+	}
+}
+
+func runPostDownloadCommand(command string, timeout time.Duration, logErrors bool, info postDownloadInfo) {
+	args, err := splitCommandLine(command)
+	if err != nil || len(args) == 0 {
+		log.Println(logPrefixPostDownload, color.HiRedString("Invalid postDownloadCommand \"%s\": %s", command, err))
+		return
+	}
+	// Placeholders are filled per argument rather than in the whole line, so values are never parsed as extra arguments.
+	// Shell scripts should use the DDG_* environment variables instead of placeholders.
+	replacer := strings.NewReplacer(
+		"{path}", info.Path,
+		"{url}", info.URL,
+		"{channelID}", info.ChannelID,
+		"{userID}", info.UserID,
+		"{contentType}", info.ContentType,
+	)
+	for i := range args {
+		args[i] = replacer.Replace(args[i])
+	}
+
+	release := acquirePostDownloadSlot()
+	defer release()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"DDG_PATH="+info.Path,
+		"DDG_URL="+info.URL,
+		"DDG_CHANNEL_ID="+info.ChannelID,
+		"DDG_USER_ID="+info.UserID,
+		"DDG_CONTENT_TYPE="+info.ContentType,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	started := time.Now()
+	err = cmd.Run()
+	if err == nil {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixPostDownload, color.YellowString("%s finished for \"%s\" in %s", args[0], info.Path, time.Since(started).Round(time.Millisecond)))
+		}
+		return
+	}
+
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	reason := fmt.Sprintf("exited with code %d", exitCode)
+	if ctx.Err() == context.DeadlineExceeded {
+		reason = fmt.Sprintf("timed out after %s", timeout)
+	} else if exitCode == -1 {
+		reason = err.Error()
+	}
+	output := strings.TrimSpace(stderr.String())
+	if len(output) > 500 {
+		output = output[:500] + "..."
+	}
+
+	log.Println(logPrefixPostDownload, color.HiRedString("%s %s for \"%s\"", args[0], reason, info.Path))
+	if output != "" {
+		log.Println(logPrefixPostDownload, color.RedString(output))
+	}
+	if logErrors {
+		message := fmt.Sprintf("Post download command `%s` %s for `%s`", args[0], reason, info.Path)
+		if output != "" {
+			message += fmt.Sprintf("\n```\n%s\n```", output)
+		}
+		logErrorMessage(message)
+	}
+}
+
+// Splits a command into arguments on spaces, keeping single or double quoted sections together.
+func splitCommandLine(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unclosed quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// The integrity check cross-checks the image store, the database and what's on disk, reporting image store entries
+// without a row, rows whose files are gone or empty, and temp files left in destinations by runs that were cut
+// short. Nothing's changed unless the categories to repair are asked for, with integrityRepairs or the command.
+
+var logPrefixIntegrity = color.HiMagentaString("[Integrity]")
+
+const (
+	integrityRepairHashes = "hashes" // drops image store entries without a row
+	integrityRepairRows   = "rows"   // marks rows whose files are gone as missing, so they're downloaded again
+	integrityRepairTemps  = "temps"  // deletes orphaned temp files
+	// Paths logged for each category, the rest are only counted
+	integrityListed = 20
+)
+
+var (
+	integrityRepairs = []string{integrityRepairHashes, integrityRepairRows, integrityRepairTemps}
+	// Prefixes and extensions of files only ever left behind part written
+	integrityTempPrefixes   = []string{".blob-", ".ddg-write-check-"}
+	integrityTempExtensions = []string{".part", ".tmp"}
+
+	integrityRunning int32 // atomic
+)
+
+type integrityReport struct {
+	danglingHashes []interface{}
+	missingRows    map[int]string // by row, the path that's gone
+	emptyRows      map[int]string // by row, the path that's zero-byte
+	orphanTemps    []string
+	orphanBytes    int64
+	repaired       map[string]int
+	took           time.Duration
+}
+
+func (r *integrityReport) issues() int {
+	return len(r.danglingHashes) + len(r.missingRows) + len(r.emptyRows) + len(r.orphanTemps)
+}
+
+// Local roots of every destination and the CAS folder, without roots already under another.
+func getIntegrityRoots() []string {
+	settings := getConfig()
+	var roots []string
+	channels := append(append([]configurationChannel{}, settings.Channels...), settings.Servers...)
+	if settings.All != nil {
+		channels = append(channels, *settings.All)
+	}
+	for _, channel := range channels {
+		if channel.Destination != "" && !isRemoteDestination(channel.Destination) {
+			roots = append(roots, filepath.Clean(getDestinationRoot(settings.BasePath, channel.Destination)))
+		}
+	}
+	roots = append(roots, filepath.Clean(getCASRoot()))
+	sort.Strings(roots)
+	var outer []string
+	for _, root := range roots {
+		nested := false
+		for _, parent := range outer {
+			if root == parent || strings.HasPrefix(root, parent+string(os.PathSeparator)) || parent == "." {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outer = append(outer, root)
+		}
+	}
+	return outer
+}
+
+func isOrphanTempFile(info os.FileInfo) bool {
+	// Anything older than this run can't still be being written
+	if !info.Mode().IsRegular() || !info.ModTime().Before(startTime) {
+		return false
+	}
+	name := strings.ToLower(info.Name())
+	for _, prefix := range integrityTempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, extension := range integrityTempExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the check, repairing the categories given. Returns false if a check was already running.
+func checkIntegrity(repairs []string) (integrityReport, bool) {
+	report := integrityReport{
+		missingRows: make(map[int]string),
+		emptyRows:   make(map[int]string),
+		repaired:    make(map[string]int),
+	}
+	if !atomic.CompareAndSwapInt32(&integrityRunning, 0, 1) {
+		return report, false
+	}
+	defer atomic.StoreInt32(&integrityRunning, 0)
+	started := time.Now()
+	log.Println(logPrefixIntegrity, color.CyanString("Checking the image store, database and files..."))
+
+	// Rows
+	rows := make(map[int]bool)
+	destinations := make(map[string]bool)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		rows[id] = true
+		destination := dbReadString(doc, "Destination")
+		if destination == "" {
+			return true
+		}
+		destinations[filepath.Clean(destination)] = true
+		_, pruned := doc["Pruned"]
+		_, missing := doc["Missing"]
+		if pruned || missing || isRemoteDestination(destination) {
+			return true
+		}
+		info, err := os.Stat(destination)
+		if os.IsNotExist(err) {
+			report.missingRows[id] = destination
+		} else if err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			report.emptyRows[id] = destination
+		}
+		return true
+	})
+
+	// Image store, entries are keyed by path or by row in older stores
+	if imgStore != nil {
+		for _, id := range imgStore.IDs() {
+			if path, isPath := id.(string); isPath {
+				if !destinations[filepath.Clean(path)] {
+					report.danglingHashes = append(report.danglingHashes, id)
+				}
+			} else if row, err := strconv.Atoi(fmt.Sprint(id)); err != nil || !rows[row] {
+				report.danglingHashes = append(report.danglingHashes, id)
+			}
+		}
+	}
+
+	// Temp files
+	for _, root := range getIntegrityRoots() {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && isOrphanTempFile(info) {
+				report.orphanTemps = append(report.orphanTemps, path)
+				report.orphanBytes += info.Size()
+			}
+			return nil
+		})
+	}
+
+	logIntegrityReport(&report)
+	if stringInSlice(integrityRepairHashes, repairs) {
+		for _, id := range report.danglingHashes {
+			removeFromImgStore(id)
+			report.repaired[integrityRepairHashes]++
+		}
+	}
+	if stringInSlice(integrityRepairRows, repairs) {
+		missing := time.Now()
+		for _, paths := range []map[int]string{report.missingRows, report.emptyRows} {
+			for id, path := range paths {
+				if err := dbMarkMissing(id, missing); err != nil {
+					log.Println(logPrefixIntegrity, color.HiRedString("Failed to mark download %d as missing:\t%s", id, err))
+					continue
+				}
+				// Its hash would have it skipped as a duplicate of itself
+				removeFromImgStore(path)
+				report.repaired[integrityRepairRows]++
+			}
+		}
+	}
+	if stringInSlice(integrityRepairTemps, repairs) {
+		for _, path := range report.orphanTemps {
+			if err := os.Remove(path); err != nil {
+				log.Println(logPrefixIntegrity, color.HiRedString("Failed to delete \"%s\":\t%s", path, err))
+				continue
+			}
+			report.repaired[integrityRepairTemps]++
+		}
+	}
+	report.took = time.Since(started)
+	log.Println(logPrefixIntegrity, color.HiCyanString("Integrity check finished in %s, %s",
+		report.took.Round(time.Millisecond), strings.ReplaceAll(report.summary(), "\n", ", ")))
+	return report, true
+}
+
+// Lists the first paths of each category found.
+func logIntegrityReport(report *integrityReport) {
+	logPaths := func(label string, paths []string) {
+		sort.Strings(paths)
+		for i, path := range paths {
+			if i == integrityListed {
+				log.Println(logPrefixIntegrity, color.YellowString("...and %d more", len(paths)-i))
+				break
+			}
+			log.Println(logPrefixIntegrity, color.YellowString("%s: \"%s\"", label, path))
+		}
+	}
+	var hashes, missing, empty []string
+	for _, id := range report.danglingHashes {
+		hashes = append(hashes, fmt.Sprint(id))
+	}
+	for _, path := range report.missingRows {
+		missing = append(missing, path)
+	}
+	for _, path := range report.emptyRows {
+		empty = append(empty, path)
+	}
+	logPaths("Image store entry without a row", hashes)
+	logPaths("Missing file", missing)
+	logPaths("Zero-byte file", empty)
+	logPaths("Orphaned temp file", append([]string{}, report.orphanTemps...))
+}
+
+func (r *integrityReport) summary() string {
+	if r.issues() == 0 {
+		return "no issues found"
+	}
+	line := func(count int, label string, category string) string {
+		text := fmt.Sprintf("%s %s", formatNumber(int64(count)), label)
+		if repaired, exists := r.repaired[category]; exists {
+			text += fmt.Sprintf(" (%s repaired)", formatNumber(int64(repaired)))
+		}
+		return text
+	}
+	return strings.Join([]string{
+		line(len(r.danglingHashes), "image store entries without a row", integrityRepairHashes),
+		line(len(r.missingRows)+len(r.emptyRows), fmt.Sprintf("rows with missing files (%d zero-byte)", len(r.emptyRows)), integrityRepairRows),
+		line(len(r.orphanTemps), fmt.Sprintf("orphaned temp files (%s)", formatBytes(r.orphanBytes)), integrityRepairTemps),
+	}, "\n")
+}
+
+// Run at launch with integrityCheckOnStartup, repairing what integrityRepairs says to.
+func startupIntegrityCheck() {
+	settings := getConfig()
+	if !settings.IntegrityCheckOnStartup {
+		return
+	}
+	report, _ := checkIntegrity(settings.IntegrityRepairs)
+	if report.issues() > 0 && len(settings.IntegrityRepairs) == 0 {
+		log.Println(logPrefixIntegrity, color.YellowString("Nothing was changed, set integrityRepairs or use the integrity command to repair"))
+	}
+}
+
+// For the integrity command, "repair" followed by categories, or "all" of them.
+func handleIntegrityCommand(commandingMessage *discordgo.Message, repairs []string) {
+	action := "Checking"
+	if len(repairs) > 0 {
+		action = "Checking and repairing " + strings.Join(repairs, ", ") + " in"
+	}
+	reply, _ := replyEmbed(commandingMessage, "Command — Integrity", action+" the image store, database and files...")
+	report, ran := checkIntegrity(repairs)
+	content := fmt.Sprintf("Finished in %s:\n%s", report.took.Round(time.Millisecond), report.summary())
+	if !ran {
+		content = "A check is already running."
+	} else if report.issues() > 0 && len(repairs) == 0 {
+		content += fmt.Sprintf("\n\nNothing was changed, use `%sintegrity repair <%s|all>` to repair.",
+			getConfig().CommandPrefix, strings.Join(integrityRepairs, "|"))
+	}
+	if reply != nil {
+		editEmbed(reply, "Command — Integrity", content)
+	}
+}
+
+// Categories from the command's arguments, false if one isn't.
+func parseIntegrityRepairs(args []string) ([]string, bool) {
+	var repairs []string
+	for _, arg := range args {
+		for _, category := range strings.Split(strings.ToLower(arg), ",") {
+			switch {
+			case category == "":
+			case category == "all":
+				return append([]string{}, integrityRepairs...), true
+			case stringInSlice(category, integrityRepairs):
+				if !stringInSlice(category, repairs) {
+					repairs = append(repairs, category)
+				}
+			default:
+				return nil, false
+			}
+		}
+	}
+	return repairs, len(repairs) > 0
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Necroforger/dgrouter/exrouter"
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+var logPrefixSlash = color.HiMagentaString("[Slash Commands]")
+
+// This discordgo version predates interactions, so they're handled from the raw gateway event and plain REST calls.
+var interactionsEndpoint = discordgo.EndpointDiscord + "api/v10/"
+
+const (
+	interactionTypeCommand      = 2
+	interactionResponseDeferred = 5
+
+	commandOptionString  = 3
+	commandOptionInteger = 4
+	commandOptionBoolean = 5
+	commandOptionChannel = 7
+
+	// Interaction tokens stop working after 15 minutes, replies after that go to the channel like prefix commands
+	interactionTokenLifetime = 14 * time.Minute
+)
+
+var slashCommandPermissions = map[string]string{
+	"manageServer":  fmt.Sprint(discordgo.PermissionManageServer),
+	"administrator": fmt.Sprint(discordgo.PermissionAdministrator),
+	"everyone":      "",
+}
+
+//#region Definitions
+
+type applicationCommand struct {
+	Name                     string                     `json:"name"`
+	Description              string                     `json:"description"`
+	Options                  []applicationCommandOption `json:"options,omitempty"`
+	DefaultMemberPermissions *string                    `json:"default_member_permissions"`
+	DMPermission             bool                       `json:"dm_permission"`
+}
+
+type applicationCommandOption struct {
+	Type        int    `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Slash commands run the prefix command of the same name, each option becomes one of its arguments.
+type slashCommand struct {
+	command applicationCommand
+	prefix  string
+	args    map[string]string // option to argument format, booleans are added as-is when true
+}
+
+var slashCommands = []slashCommand{
+	{
+		command: applicationCommand{
+			Name:        "history",
+			Description: "Catalogs history for a channel",
+			Options: []applicationCommandOption{
+				{Type: commandOptionChannel, Name: "channel", Description: "Channel to catalog, this one if not set"},
+				{Type: commandOptionString, Name: "before", Description: "Only messages before this date (YYYY-MM-DD) or message ID"},
+				{Type: commandOptionString, Name: "after", Description: "Only messages after this date (YYYY-MM-DD) or message ID"},
+				{Type: commandOptionInteger, Name: "limit", Description: "Most messages to check"},
+				{Type: commandOptionBoolean, Name: "dryrun", Description: "Only report what would be downloaded"},
+				{Type: commandOptionBoolean, Name: "analyze", Description: "Dry run broken down by domain and uploader"},
+				{Type: commandOptionBoolean, Name: "csv", Description: "Send the analysis as a CSV too"},
+				{Type: commandOptionBoolean, Name: "nolimits", Description: "Ignore the channel's link and album limits"},
+				{Type: commandOptionBoolean, Name: "nowait", Description: "Start now even outside the download schedule"},
+				{Type: commandOptionBoolean, Name: "force", Description: "Download links again even if they already were"},
+				{Type: commandOptionBoolean, Name: "missing-only", Description: "Only download links again whose files are gone"},
+				{Type: commandOptionBoolean, Name: "cancel", Description: "Cancel history running for the channel"},
+			},
+		},
+		prefix: "history",
+		args: map[string]string{
+			"channel":      "%s",
+			"before":       "--before=%s",
+			"after":        "--since=%s",
+			"limit":        "--limit=%s",
+			"dryrun":       "dryrun",
+			"analyze":      "analyze",
+			"csv":          "csv",
+			"nolimits":     "nolimits",
+			"nowait":       "nowait",
+			"force":        "--force",
+			"missing-only": "--missing-only",
+			"cancel":       "cancel",
+		},
+	},
+	{
+		command: applicationCommand{Name: "status", Description: "Displays info regarding the current status of the bot"},
+		prefix:  "status",
+	},
+	{
+		command: applicationCommand{Name: "stats", Description: "Outputs statistics regarding this channel"},
+		prefix:  "stats",
+	},
+	{
+		command: applicationCommand{
+			Name:        "emoji",
+			Description: "Saves all server emojis to download destination",
+			Options: []applicationCommandOption{
+				{Type: commandOptionString, Name: "servers", Description: "Server IDs separated by commas, this one if not set"},
+			},
+		},
+		prefix: "emojis",
+		args:   map[string]string{"servers": "%s"},
+	},
+	{
+		command: applicationCommand{
+			Name:        "search",
+			Description: "Finds downloads by link, filename or destination",
+			Options: []applicationCommandOption{
+				{Type: commandOptionString, Name: "query", Description: "Text to look for"},
+				{Type: commandOptionString, Name: "tag", Description: "Only downloads from posts with this forum tag"},
+				{Type: commandOptionBoolean, Name: "all", Description: "Search every channel, not just this one"},
+			},
+		},
+		prefix: "search",
+		args:   map[string]string{"query": "%s", "tag": "--tag=%s", "all": "--all"},
+	},
+	{
+		command: applicationCommand{
+			Name:        "download",
+			Description: "Downloads URLs into a manual destination",
+			Options: []applicationCommandOption{
+				{Type: commandOptionString, Name: "url", Description: "URLs to download, separated by spaces", Required: true},
+				{Type: commandOptionString, Name: "destination", Description: "Alias from the manualDestinations setting"},
+			},
+		},
+		prefix: "download",
+		args:   map[string]string{"url": "%s", "destination": "%s"},
+	},
+}
+
+func getSlashCommand(name string) *slashCommand {
+	for i := range slashCommands {
+		if slashCommands[i].command.Name == name {
+			return &slashCommands[i]
+		}
+	}
+	return nil
+}
+
+// Builds the prefix command's arguments for a slash command, options in the order they're defined. Values are
+// never split on spaces, so one can't add arguments of its own.
+func (c *slashCommand) arguments(options map[string]interface{}) []string {
+	parts := []string{c.prefix}
+	for _, option := range c.command.Options {
+		value, exists := options[option.Name]
+		if !exists {
+			continue
+		}
+		switch v := value.(type) {
+		case bool:
+			if v {
+				parts = append(parts, c.args[option.Name])
+			}
+		case float64:
+			parts = append(parts, fmt.Sprintf(c.args[option.Name], fmt.Sprint(int64(v))))
+		default:
+			parts = append(parts, fmt.Sprintf(c.args[option.Name], fmt.Sprint(v)))
+		}
+	}
+	return parts
+}
+
+//#endregion
+
+//#region Registration
+
+var (
+	slashRegistered = make(map[string]bool) // guild IDs, empty for global
+	slashStarted    bool
+	slashMutex      sync.Mutex
+)
+
+func getApplicationID() string {
+	if botApplicationID != "" {
+		return botApplicationID
+	}
+	return user.ID
+}
+
+func putSlashCommands(guildID string, commands []applicationCommand) error {
+	endpoint := interactionsEndpoint + "applications/" + getApplicationID() + "/commands"
+	if guildID != "" {
+		endpoint = interactionsEndpoint + "applications/" + getApplicationID() + "/guilds/" + guildID + "/commands"
+	}
+	_, err := bot.RequestWithBucketID("PUT", endpoint, commands, endpoint)
+	return err
+}
+
+func registerSlashCommands(guildID string) {
+	settings := getConfig()
+	slashMutex.Lock()
+	if slashRegistered[guildID] {
+		slashMutex.Unlock()
+		return
+	}
+	slashRegistered[guildID] = true
+	slashMutex.Unlock()
+
+	var permissions *string
+	if bitfield := slashCommandPermissions[settings.SlashCommands.Permissions]; bitfield != "" {
+		permissions = &bitfield
+	} else if isNumeric(settings.SlashCommands.Permissions) {
+		permissions = &settings.SlashCommands.Permissions
+	}
+	var commands []applicationCommand
+	for _, c := range slashCommands {
+		command := c.command
+		command.DefaultMemberPermissions = permissions
+		commands = append(commands, command)
+	}
+
+	scope := "globally"
+	if guildID != "" {
+		scope = "for " + getGuildName(guildID)
+	}
+	if err := putSlashCommands(guildID, commands); err != nil {
+		log.Println(logPrefixSlash, color.HiRedString("Failed to register slash commands %s:\t%s", scope, err))
+		slashMutex.Lock()
+		delete(slashRegistered, guildID)
+		slashMutex.Unlock()
+	} else if settings.DebugOutput {
+		log.Println(logPrefixDebug, logPrefixSlash, color.YellowString("Registered %d slash commands %s", len(commands), scope))
+	}
+}
+
+// Registers slash commands in the configured scope and clears the other, so switching doesn't leave duplicates behind.
+func startSlashCommands() {
+	settings := getConfig()
+	if settings.SlashCommands == nil || !canUseBotOnlyAPIs() {
+		return
+	}
+	var guilds []string
+	if len(settings.SlashCommands.Guilds) > 0 {
+		guilds = settings.SlashCommands.Guilds
+	} else {
+		for _, guild := range bot.State.Guilds {
+			guilds = append(guilds, guild.ID)
+		}
+	}
+
+	if settings.SlashCommands.Scope == "global" {
+		registerSlashCommands("")
+		for _, guild := range guilds {
+			if err := putSlashCommands(guild, []applicationCommand{}); err != nil && settings.DebugOutput {
+				log.Println(logPrefixDebug, logPrefixSlash, color.YellowString("Failed to clear slash commands for %s:\t%s", guild, err))
+			}
+		}
+	} else {
+		if err := putSlashCommands("", []applicationCommand{}); err != nil && settings.DebugOutput {
+			log.Println(logPrefixDebug, logPrefixSlash, color.YellowString("Failed to clear global slash commands:\t%s", err))
+		}
+		for _, guild := range guilds {
+			registerSlashCommands(guild)
+		}
+	}
+	slashMutex.Lock()
+	slashStarted = true
+	slashMutex.Unlock()
+	log.Println(logPrefixSlash, color.HiGreenString("Slash commands enabled (%s)", settings.SlashCommands.Scope))
+}
+
+// Servers joined later get them too.
+func guildCreateSlashCommands(_ *discordgo.Session, g *discordgo.GuildCreate) {
+	settings := getConfig()
+	if settings.SlashCommands == nil || !canUseBotOnlyAPIs() || settings.SlashCommands.Scope != "guild" || len(settings.SlashCommands.Guilds) > 0 {
+		return
+	}
+	slashMutex.Lock()
+	started := slashStarted
+	slashMutex.Unlock()
+	if started {
+		registerSlashCommands(g.ID)
+	}
+}
+
+//#endregion
+
+//#region Handling
+
+type interactionCreate struct {
+	ID            string            `json:"id"`
+	ApplicationID string            `json:"application_id"`
+	Type          int               `json:"type"`
+	Token         string            `json:"token"`
+	GuildID       string            `json:"guild_id"`
+	ChannelID     string            `json:"channel_id"`
+	Member        *discordgo.Member `json:"member"`
+	User          *discordgo.User   `json:"user"`
+	Data          struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// A slash command being answered, replies to its message go through the interaction instead of the channel.
+type commandInteraction struct {
+	mutex         sync.Mutex
+	applicationID string
+	token         string
+	args          []string // prefix command arguments before they're lowercased for matching
+	replied       bool
+	rejected      string // why the command didn't run, sent if it didn't reply anything else
+}
+
+var (
+	commandInteractions      = make(map[string]*commandInteraction) // command and reply message IDs
+	commandInteractionsMutex sync.Mutex
+)
+
+func getCommandInteraction(messageID string) *commandInteraction {
+	commandInteractionsMutex.Lock()
+	defer commandInteractionsMutex.Unlock()
+	return commandInteractions[messageID]
+}
+
+func (ci *commandInteraction) track(messageID string) {
+	commandInteractionsMutex.Lock()
+	defer commandInteractionsMutex.Unlock()
+	commandInteractions[messageID] = ci
+}
+
+func (ci *commandInteraction) forget() {
+	commandInteractionsMutex.Lock()
+	defer commandInteractionsMutex.Unlock()
+	for id, tracked := range commandInteractions {
+		if tracked == ci {
+			delete(commandInteractions, id)
+		}
+	}
+}
+
+// Notes why a slash command didn't run, prefix commands ignore rejections silently but an interaction left
+// "thinking" would never be answered.
+func rejectCommandInteraction(m *discordgo.Message, reason string) {
+	if m == nil {
+		return
+	}
+	if ci := getCommandInteraction(m.ID); ci != nil {
+		ci.mutex.Lock()
+		if ci.rejected == "" {
+			ci.rejected = reason
+		}
+		ci.mutex.Unlock()
+	}
+}
+
+func (ci *commandInteraction) webhookEndpoint() string {
+	return interactionsEndpoint + "webhooks/" + ci.applicationID + "/" + ci.token
+}
+
+func (ci *commandInteraction) request(method string, endpoint string, data interface{}) (*discordgo.Message, error) {
+	response, err := bot.RequestWithBucketID(method, endpoint, data, ci.webhookEndpoint())
+	if err != nil || len(response) == 0 {
+		return nil, err
+	}
+	var message discordgo.Message
+	if err := json.Unmarshal(response, &message); err != nil {
+		return nil, err
+	}
+	ci.track(message.ID)
+	return &message, nil
+}
+
+// The first reply replaces the "thinking" response, the rest are sent as follow-ups.
+func (ci *commandInteraction) reply(embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	data := map[string]interface{}{"embeds": []*discordgo.MessageEmbed{embed}}
+	if !ci.replied {
+		message, err := ci.request("PATCH", ci.webhookEndpoint()+"/messages/@original", data)
+		if err == nil {
+			ci.replied = true
+		}
+		return message, err
+	}
+	return ci.request("POST", ci.webhookEndpoint()+"?wait=true", data)
+}
+
+func (ci *commandInteraction) edit(messageID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return ci.request("PATCH", ci.webhookEndpoint()+"/messages/"+messageID, map[string]interface{}{"embeds": []*discordgo.MessageEmbed{embed}})
+}
+
+func (ci *commandInteraction) delete(messageID string) error {
+	_, err := bot.RequestWithBucketID("DELETE", ci.webhookEndpoint()+"/messages/"+messageID, nil, ci.webhookEndpoint())
+	return err
+}
+
+// Raw gateway events, only interactions are picked out.
+func interactionCreateEvent(_ *discordgo.Session, e *discordgo.Event) {
+	settings := getConfig()
+	if e.Type != "INTERACTION_CREATE" || settings.SlashCommands == nil {
+		return
+	}
+	var interaction interactionCreate
+	if err := json.Unmarshal(e.RawData, &interaction); err != nil {
+		log.Println(logPrefixSlash, color.HiRedString("Failed to parse interaction:\t%s", err))
+		return
+	}
+	if interaction.Type != interactionTypeCommand {
+		return
+	}
+	command := getSlashCommand(interaction.Data.Name)
+	if command == nil {
+		return
+	}
+
+	// Long operations can't answer within the 3 seconds Discord waits, so every command is deferred
+	callback := interactionsEndpoint + "interactions/" + interaction.ID + "/" + interaction.Token + "/callback"
+	if _, err := bot.RequestWithBucketID("POST", callback, map[string]int{"type": interactionResponseDeferred}, interactionsEndpoint+"interactions"); err != nil {
+		log.Println(logPrefixSlash, color.HiRedString("Failed to respond to /%s:\t%s", interaction.Data.Name, err))
+		return
+	}
+
+	author := interaction.User
+	if interaction.Member != nil {
+		interaction.Member.GuildID = interaction.GuildID
+		if interaction.Member.User != nil {
+			author = interaction.Member.User
+		}
+	}
+	if author == nil {
+		return
+	}
+	options := make(map[string]interface{})
+	for _, option := range interaction.Data.Options {
+		options[option.Name] = option.Value
+	}
+	args := command.arguments(options)
+	message := &discordgo.Message{
+		ID:        interaction.ID,
+		ChannelID: interaction.ChannelID,
+		GuildID:   interaction.GuildID,
+		Author:    author,
+		Member:    interaction.Member,
+		Content:   settings.CommandPrefix + strings.Join(args, " "),
+		Timestamp: discordgo.Timestamp(time.Now().Format(time.RFC3339)),
+	}
+
+	ci := &commandInteraction{applicationID: interaction.ApplicationID, token: interaction.Token, args: args}
+	ci.track(message.ID)
+	time.AfterFunc(interactionTokenLifetime, ci.forget)
+
+	log.Println(logPrefixSlash, color.HiCyanString("%s used /%s", getUserIdentifier(*author), interaction.Data.Name))
+	// Run straight from the arguments, the content is only for logs and would be split again on spaces
+	route, depth := dgr.FindFull(command.prefix)
+	if depth == 0 {
+		return
+	}
+	lowered := make(exrouter.Args, len(args))
+	for i, arg := range args {
+		lowered[i] = strings.ToLower(arg)
+	}
+	route.Handler(exrouter.NewContext(bot, messageToLower(message), lowered, route))
+
+	// Commands that run in the background reply when they get going, only ones that were turned away are answered here
+	ci.mutex.Lock()
+	rejected := ci.rejected
+	if ci.replied {
+		rejected = ""
+	}
+	ci.mutex.Unlock()
+	if rejected != "" {
+		title := "Command — " + strings.ToUpper(command.prefix[:1]) + command.prefix[1:]
+		if _, err := ci.reply(buildEmbed(message.ChannelID, title, rejected)); err != nil {
+			log.Println(logPrefixSlash, color.HiRedString("Failed to reply to /%s:\t%s", interaction.Data.Name, err))
+		}
+	}
+}
+
+//#endregion
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSlashCommandArguments(t *testing.T) {
+	cases := []struct {
+		command string
+		options map[string]interface{}
+		want    []string
+	}{
+		{"history", map[string]interface{}{}, []string{"history"}},
+		{"history", map[string]interface{}{"limit": float64(50), "nowait": true, "force": false, "channel": "123"},
+			[]string{"history", "123", "--limit=50", "nowait"}},
+		// Values stay one argument, spaces and all
+		{"history", map[string]interface{}{"before": "2024-01-01 --force", "after": "x cancel"},
+			[]string{"history", "--before=2024-01-01 --force", "--since=x cancel"}},
+		{"download", map[string]interface{}{"destination": "art fresh", "url": "https://example.com/a.png https://example.com/b.png"},
+			[]string{"download", "https://example.com/a.png https://example.com/b.png", "art fresh"}},
+		{"search", map[string]interface{}{"all": true, "tag": "art", "query": "cat pics"},
+			[]string{"search", "cat pics", "--tag=art", "--all"}},
+	}
+	for _, c := range cases {
+		if got := getSlashCommand(c.command).arguments(c.options); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("/%s %v = %q, want %q", c.command, c.options, got, c.want)
+		}
+	}
+}
+
+// The first reason a slash command was turned away is kept, other messages aren't affected.
+func TestRejectCommandInteraction(t *testing.T) {
+	ci := &commandInteraction{}
+	ci.track("100")
+	defer ci.forget()
+
+	rejectCommandInteraction(&discordgo.Message{ID: "200"}, "elsewhere")
+	rejectCommandInteraction(&discordgo.Message{ID: "100"}, cmderrCommandsNotAllowed)
+	rejectCommandInteraction(&discordgo.Message{ID: "100"}, cmderrChannelNotRegistered)
+	rejectCommandInteraction(nil, "nothing")
+	if ci.rejected != cmderrCommandsNotAllowed {
+		t.Errorf("Rejected with %q, want %q", ci.rejected, cmderrCommandsNotAllowed)
+	}
+}
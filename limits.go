@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+)
+
+// maxLinksPerMessage and maxFilesPerAlbum cap what's taken from a single message or album, so one spammy message
+// can't queue hundreds of downloads. History runs with "nolimits" ignore them, see historyOptions.
+
+func getMaxLinksPerMessage(channelID string) int {
+	if !isChannelRegistered(channelID) {
+		return 0
+	}
+	return *getChannelConfig(channelID).MaxLinksPerMessage
+}
+
+func getMaxFilesPerAlbum(channelID string) int {
+	if !isChannelRegistered(channelID) {
+		return 0
+	}
+	return *getChannelConfig(channelID).MaxFilesPerAlbum
+}
+
+// Album handlers are given the channel's limit and stop looking once they reach it, returning how many they
+// left out, or -1 if they can't tell without looking further.
+func limitAlbum(fetch func(inputURL string, limit int) (map[string]string, int, error)) func(string, string, int) (map[string]string, error) {
+	return func(inputURL string, channelID string, limit int) (map[string]string, error) {
+		links, skipped, err := fetch(inputURL, limit)
+		if err == nil && skipped != 0 {
+			recordLimitReached(channelID, inputURL, "file", limit, skipped)
+		}
+		return links, err
+	}
+}
+
+// One line for everything left out, and once towards the session and digest counts for each time it happens.
+func recordLimitReached(channelID string, source string, noun string, kept int, skipped int) {
+	left := "the rest"
+	if skipped > 0 {
+		left = fmt.Sprintf("%d more", skipped)
+	}
+	log.Println(logPrefixFileSkip, color.GreenString("Limit reached for %s in %s, kept the first %d %s%s and skipped %s",
+		source, getSourceName(getChannelGuildID(channelID), channelID), kept, noun, pluralS(kept), left))
+	status := mDownloadStatus(downloadSkippedLimitReached)
+	recordSessionStatus(status.Status)
+	recordChannelDigest(channelID, status, source)
+}
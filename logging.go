@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelError
+	logLevelOff
+)
+
+var logLevels = map[string]int{
+	"debug": logLevelDebug,
+	"info":  logLevelInfo,
+	"error": logLevelError,
+	"off":   logLevelOff,
+}
+
+var logLevelNames = []string{"debug", "info", "error", "off"}
+
+// Source files grouped into the modules levels can be set for, anything else is "main"
+var logModuleFiles = map[string]string{
+	"downloads.go":     "downloads",
+	"archives.go":      "downloads",
+	"convert.go":       "downloads",
+	"dedupe.go":        "downloads",
+	"hooks.go":         "downloads",
+	"metadata.go":      "downloads",
+	"network.go":       "downloads",
+	"notifications.go": "downloads",
+	"storage.go":       "downloads",
+	"history.go":       "history",
+	"handlers.go":      "handlers",
+	"database.go":      "database",
+}
+
+var logModules = []string{"main", "downloads", "history", "handlers", "database"}
+
+var (
+	regexLogColor = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	// What log's Ldate|Ltime|Lshortfile flags put before each message
+	regexLogHeader = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} ([^:\s]+):(\d+): `)
+)
+
+//#region Output
+
+// Everything logged goes through here to be filtered by level and written as console text or JSON lines,
+// to stdout and optionally a rotating file.
+type logWriter struct {
+	mutex        sync.Mutex
+	console      io.Writer
+	consoleColor bool
+	json         bool
+	level        int
+	modules      map[string]int
+	file         *rotatingFile
+}
+
+var logOutput *logWriter
+
+// Colors are always generated so errors can be told apart, and stripped again where they don't belong.
+func initLogging() {
+	logOutput = &logWriter{
+		console:      color.Output,
+		consoleColor: !color.NoColor,
+		level:        logLevelDebug,
+	}
+	color.NoColor = false
+}
+
+// Applies the logging settings, called whenever settings are loaded.
+func configureLogging(settings *configurationLogging, debugOutput bool) {
+	if logOutput == nil {
+		return
+	}
+	logOutput.mutex.Lock()
+	defer logOutput.mutex.Unlock()
+
+	// Debug lines are only logged with debugOutput anyway, so they're shown by default when it's on
+	logOutput.level = logLevelInfo
+	if debugOutput {
+		logOutput.level = logLevelDebug
+	}
+	logOutput.json = false
+	logOutput.modules = make(map[string]int)
+	var fileSettings *configurationLoggingFile
+	if settings != nil {
+		logOutput.json = settings.Format == "json"
+		if level, ok := logLevels[settings.Level]; ok {
+			logOutput.level = level
+		}
+		for module, name := range settings.Modules {
+			if level, ok := logLevels[name]; ok {
+				logOutput.modules[module] = level
+			}
+		}
+		fileSettings = settings.File
+	}
+
+	if fileSettings == nil || fileSettings.Path == "" {
+		if logOutput.file != nil {
+			logOutput.file.close()
+			logOutput.file = nil
+		}
+	} else if logOutput.file == nil || logOutput.file.path != fileSettings.Path {
+		if logOutput.file != nil {
+			logOutput.file.close()
+		}
+		logOutput.file = &rotatingFile{path: fileSettings.Path}
+	}
+	if logOutput.file != nil {
+		logOutput.file.daily = fileSettings.Rotate == "daily"
+		logOutput.file.maxSize = int64(*fileSettings.MaxSizeMB) * 1024 * 1024
+		logOutput.file.maxFiles = *fileSettings.MaxFiles
+	}
+}
+
+func (w *logWriter) enabled(module string, level int) bool {
+	threshold, ok := w.modules[module]
+	if !ok {
+		threshold = w.level
+	}
+	return level >= threshold
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	line := string(p)
+	module, source := "main", ""
+	if header := regexLogHeader.FindStringSubmatch(line); header != nil {
+		if group, ok := logModuleFiles[header[1]]; ok {
+			module = group
+		}
+		source = header[1] + ":" + header[2]
+		line = line[len(header[0]):]
+	}
+	level := logLevelInfo
+	if strings.Contains(line, logPrefixDebug) {
+		level = logLevelDebug
+	} else if strings.Contains(line, "\x1b[31m") || strings.Contains(line, "\x1b[91m") {
+		level = logLevelError
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.enabled(module, level) {
+		return len(p), nil
+	}
+	if w.json {
+		record := w.jsonRecord(time.Now(), level, module, strings.TrimSpace(regexLogColor.ReplaceAllString(line, "")), nil)
+		record["source"] = source
+		w.writeJSON(record)
+		return len(p), nil
+	}
+	if w.consoleColor {
+		w.console.Write(p)
+	} else {
+		w.console.Write(regexLogColor.ReplaceAll(p, nil))
+	}
+	if w.file != nil {
+		w.file.Write(regexLogColor.ReplaceAll(p, nil))
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) jsonRecord(timestamp time.Time, level int, module string, message string, fields map[string]interface{}) map[string]interface{} {
+	record := map[string]interface{}{
+		"time":   timestamp.Format(time.RFC3339Nano),
+		"level":  logLevelNames[level],
+		"module": module,
+		"msg":    message,
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	return record
+}
+
+func (w *logWriter) writeJSON(record map[string]interface{}) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	w.console.Write(data)
+	if w.file != nil {
+		w.file.Write(data)
+	}
+}
+
+// Logs an event with fields attached. Only JSON output has a place for the fields,
+// console output already has its own lines for these.
+func logEvent(module string, level int, message string, fields map[string]interface{}) {
+	if logOutput == nil {
+		return
+	}
+	logOutput.mutex.Lock()
+	defer logOutput.mutex.Unlock()
+	if !logOutput.json || !logOutput.enabled(module, level) {
+		return
+	}
+	logOutput.writeJSON(logOutput.jsonRecord(time.Now(), level, module, message, fields))
+}
+
+func logDownloadEvent(download downloadRequestStruct, status downloadStatusStruct, attempts int, duration time.Duration) {
+	level := logLevelInfo
+	if status.Status >= downloadFailed {
+		level = logLevelError
+	}
+	fields := map[string]interface{}{
+		"channelID":  download.Message.ChannelID,
+		"messageID":  download.Message.ID,
+		"url":        download.InputURL,
+		"status":     getDownloadStatusString(status.Status),
+		"durationMs": duration.Milliseconds(),
+		"attempts":   attempts,
+		"history":    download.HistoryCmd,
+	}
+	if status.Saved != nil {
+		fields["destination"] = status.Saved.Destination
+	}
+	if status.Error != nil {
+		fields["error"] = status.Error.Error()
+	}
+	logEvent("downloads", level, "Download finished", fields)
+}
+
+//#endregion
+
+//#region Rotating File
+
+// Log file rotated by size or date, older files are renamed with when they were rotated and removed past maxFiles.
+type rotatingFile struct {
+	path     string
+	daily    bool
+	maxSize  int64
+	maxFiles int
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (f *rotatingFile) open() error {
+	if dir := filepath.Dir(f.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), info.ModTime()
+	if info.Size() == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+func (f *rotatingFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func (f *rotatingFile) needsRotating(length int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.daily {
+		return f.opened.Format("2006-01-02") != time.Now().Format("2006-01-02")
+	}
+	return f.maxSize > 0 && f.size+int64(length) > f.maxSize
+}
+
+func (f *rotatingFile) rotate() {
+	f.close()
+	suffix := f.opened.Format("2006-01-02")
+	if !f.daily {
+		suffix = time.Now().Format("2006-01-02_15-04-05")
+	}
+	rotated := f.path + "." + suffix
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", f.path, suffix, i)
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to rotate log file \"%s\": %s\n", f.path, err)
+	}
+
+	// Timestamped names sort oldest first
+	if f.maxFiles > 0 {
+		old, _ := filepath.Glob(f.path + ".*")
+		sort.Strings(old)
+		for len(old) > f.maxFiles {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+}
+
+// Failures go to stderr, logging them would come straight back here.
+func (f *rotatingFile) Write(p []byte) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if f.file == nil {
+			if err := f.open(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open log file \"%s\": %s\n", f.path, err)
+				return
+			}
+		}
+		if !f.needsRotating(len(p)) {
+			break
+		}
+		f.rotate()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write log file \"%s\": %s\n", f.path, err)
+	}
+}
+
+//#endregion
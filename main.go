@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -19,7 +20,7 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
-	"github.com/rivo/duplo"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 )
@@ -29,7 +30,7 @@ var (
 	user     *discordgo.User
 	dgr      *exrouter.Route
 	myDB     *db.DB
-	imgStore *duplo.Store
+	imgStore *shardedStore
 	loop     chan os.Signal
 
 	twitterConnected     bool
@@ -53,7 +54,8 @@ func init() {
 	historyStatus = make(map[string]string)
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(color.Output)
+	initLogging()
+	log.SetOutput(logOutput)
 	log.Println(color.HiCyanString(wrapHyphensW(fmt.Sprintf("Welcome to %s v%s", projectName, projectVersion))))
 	log.Println(logPrefixVersion, color.CyanString("discord-go v%s using Discord API v%s", discordgo.VERSION, discordgo.APIVersion))
 }
@@ -61,15 +63,43 @@ func init() {
 func main() {
 	var err error
 
+	convertConfig := flag.Bool("convert-config", false, "write the JSON settings file as "+configFileBase+".yaml and exit")
+	setup := flag.Bool("setup", false, "make a settings file step by step, or migrate one from upstream discord-downloader-go, and exit")
+	flag.BoolVar(&dryRunMode, "dryrun", false, "process everything as usual but don't save files, write to the database, or react")
+	flag.Parse()
+	if *setup {
+		runSetup()
+		os.Exit(0)
+	}
+	if *convertConfig {
+		outFile, err := convertConfigToYAML()
+		if err != nil {
+			log.Println(logPrefixSettings, color.HiRedString("Failed to convert settings...\t%s", err))
+			os.Exit(1)
+		}
+		log.Println(logPrefixSettings, color.HiGreenString("Converted \"%s\" to \"%s\", remove or rename the old file to use it.", configFile, outFile))
+		os.Exit(0)
+	}
+
 	// Config
 	loadConfig()
+	if dryRunMode {
+		log.Println(logPrefixSetup, color.HiYellowString("DRY RUN MODE - nothing will be saved, written to the database, or reacted to"))
+	}
 	log.Println(logPrefixSettings, color.HiYellowString("Loaded - bound to %d channel%s and %d server%s",
 		getBoundChannelsCount(), pluralS(getBoundChannelsCount()),
 		getBoundServersCount(), pluralS(getBoundServersCount()),
 	))
 
+	// Proxies
+	checkProxies()
+	warnInsecureDomains()
+
+	// Network
+	checkNetworkSettings()
+
 	// Github Update Check
-	if config.GithubUpdateChecking {
+	if getConfig().GithubUpdateChecking {
 		if !isLatestGithubRelease() {
 			log.Println(logPrefixVersion, color.HiCyanString("*** Update Available! ***"))
 			log.Println(logPrefixVersion, color.CyanString(projectReleaseURL))
@@ -109,28 +139,114 @@ func main() {
 		}
 		log.Println(logPrefixSetup, color.HiYellowString("Created database indexes..."))
 	}
+	// Hash index was added later, existing databases need it created
+	if !(tiedotStore{myDB.Use("Downloads")}).HasIndex("Hash") {
+		log.Println(logPrefixDatabase, color.YellowString("Indexing database by file hash, please wait..."))
+		if err := myDB.Use("Downloads").Index([]string{"Hash"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Hash: %s", err))
+		}
+	}
+	// MessageID index was added later, for finding the files of deleted messages
+	if !(tiedotStore{myDB.Use("Downloads")}).HasIndex("MessageID") {
+		log.Println(logPrefixDatabase, color.YellowString("Indexing database by message ID, please wait..."))
+		if err := myDB.Use("Downloads").Index([]string{"MessageID"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for MessageID: %s", err))
+		}
+	}
+	// Destination index was added later, for telling files with the same name apart
+	if !(tiedotStore{myDB.Use("Downloads")}).HasIndex("Destination") {
+		log.Println(logPrefixDatabase, color.YellowString("Indexing database by destination, please wait..."))
+		if err := myDB.Use("Downloads").Index([]string{"Destination"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Destination: %s", err))
+		}
+	}
+	// CanonicalURL index was added later, for finding downloads under other forms of their Discord link
+	if !(tiedotStore{myDB.Use("Downloads")}).HasIndex("CanonicalURL") {
+		log.Println(logPrefixDatabase, color.YellowString("Indexing database by canonical link, please wait..."))
+		if err := myDB.Use("Downloads").Index([]string{"CanonicalURL"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for CanonicalURL: %s", err))
+		}
+	}
+	// Failed downloads are remembered separately so dead links aren't retried forever
+	if myDB.Use("FailedURLs") == nil {
+		if err := myDB.Create("FailedURLs"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create failed URLs collection: %s", err))
+		} else {
+			for _, field := range []string{"URL", "ChannelID"} {
+				if err := myDB.Use("FailedURLs").Index([]string{field}); err != nil {
+					log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for %s: %s", field, err))
+				}
+			}
+		}
+	}
+	// Downloads journaled before they start, recovered after a restart
+	if myDB.Use("Pending") == nil {
+		if err := myDB.Create("Pending"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create pending downloads collection: %s", err))
+		}
+	}
+	// First seen names for destination templates
+	if myDB.Use("Names") == nil {
+		if err := myDB.Create("Names"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create names collection: %s", err))
+		} else if err := myDB.Use("Names").Index([]string{"Key"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Key: %s", err))
+		}
+	}
+	// Pinned messages already processed, for autoDownloadPins
+	if myDB.Use("Pins") == nil {
+		if err := myDB.Create("Pins"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create pins collection: %s", err))
+		} else if err := myDB.Use("Pins").Index([]string{"ChannelID"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for ChannelID: %s", err))
+		}
+	}
+	// Newest post seen for each scraped account, so scrape can pick up where it left off
+	if myDB.Use("Scrapes") == nil {
+		if err := myDB.Create("Scrapes"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create scrapes collection: %s", err))
+		} else if err := myDB.Use("Scrapes").Index([]string{"Key"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Key: %s", err))
+		}
+	}
+	// Avatar tracking records the hashes it's saved separately
+	if myDB.Use("Avatars") == nil {
+		if err := myDB.Create("Avatars"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create avatars collection: %s", err))
+		} else if err := myDB.Use("Avatars").Index([]string{"Key"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Key: %s", err))
+		}
+	}
+	// Emojis and stickers the emojis command has saved, by ID
+	if myDB.Use("Emojis") == nil {
+		if err := myDB.Create("Emojis"); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create emojis collection: %s", err))
+		} else if err := myDB.Use("Emojis").Index([]string{"Key"}); err != nil {
+			log.Println(logPrefixDatabase, color.HiRedString("Unable to create database index for Key: %s", err))
+		}
+	}
+	// Downloads can be kept elsewhere
+	if downloadsStore, err = openDownloadStore(); err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Unable to open %s database: %s", getConfig().Database.Type, err))
+		return
+	}
+	if getConfig().Database != nil && getConfig().Database.Type != databaseTypeTiedot {
+		log.Println(logPrefixDatabase, color.HiYellowString("Downloads are kept in %s", getConfig().Database.Type))
+	}
 	// Cache download tally
 	cachedDownloadID = dbDownloadCount()
+	presenceStats.downloads = int64(cachedDownloadID)
+	dbRowCount = int64(cachedDownloadID)
 	log.Println(logPrefixDatabase, color.HiYellowString("Database opened, contains %d entries...", cachedDownloadID))
+	dbBackfillCanonicalURLs()
 
 	// Image Store
-	if config.FilterDuplicateImages {
-		imgStore = duplo.New()
-		if _, err := os.Stat(imgStorePath); err == nil {
-			log.Println(logPrefixDatabase, color.YellowString("Opening image filter database..."))
-			storeFile, err := ioutil.ReadFile(imgStorePath)
-			if err != nil {
-				log.Println(logPrefixDatabase, color.HiRedString("Error opening imgStore file:\t%s", err))
-			} else {
-				err = imgStore.GobDecode(storeFile)
-				if err != nil {
-					log.Println(logPrefixDatabase, color.HiRedString("Error decoding imgStore:\t%s", err))
-				}
-				if imgStore != nil {
-					log.Println(logPrefixDatabase, color.HiYellowString("filterDuplicateImages database opened", imgStore.Size()))
-				}
-			}
-		}
+	if getConfig().FilterDuplicateImages {
+		loadImgStore()
+	}
+	// Video Store
+	if getConfig().FilterDuplicateVideos && getConfig().FFmpegPath != "" {
+		loadVidStore()
 	}
 
 	//#endregion
@@ -145,19 +261,20 @@ func main() {
 	}
 
 	// Twitter API
-	if config.Credentials.TwitterAccessToken != "" &&
-		config.Credentials.TwitterAccessTokenSecret != "" &&
-		config.Credentials.TwitterConsumerKey != "" &&
-		config.Credentials.TwitterConsumerSecret != "" {
+	if getConfig().Credentials.TwitterAccessToken != "" &&
+		getConfig().Credentials.TwitterAccessTokenSecret != "" &&
+		getConfig().Credentials.TwitterConsumerKey != "" &&
+		getConfig().Credentials.TwitterConsumerSecret != "" {
 
 		log.Println(logPrefixTwitter, color.MagentaString("Connecting to API..."))
 
 		twitterClient = anaconda.NewTwitterApiWithCredentials(
-			config.Credentials.TwitterAccessToken,
-			config.Credentials.TwitterAccessTokenSecret,
-			config.Credentials.TwitterConsumerKey,
-			config.Credentials.TwitterConsumerSecret,
+			getConfig().Credentials.TwitterAccessToken,
+			getConfig().Credentials.TwitterAccessTokenSecret,
+			getConfig().Credentials.TwitterConsumerKey,
+			getConfig().Credentials.TwitterConsumerSecret,
 		)
+		twitterClient.HttpClient = getHTTPClient("https://api.twitter.com")
 
 		twitterSelf, err := twitterClient.GetSelf(url.Values{})
 		if err != nil {
@@ -171,11 +288,22 @@ func main() {
 		log.Println(logPrefixTwitter, color.MagentaString("API credentials missing, the bot won't use the Twitter API."))
 	}
 
+	// Flickr API
+	if getConfig().Credentials.FlickrApiKey != "" {
+		if err := validateFlickrApiKey(); flickrAPIKeyRejected {
+			log.Println(logPrefixFlickr, color.HiRedString("Flickr rejected the API key in flickrApiKey, the bot won't use the Flickr API:\t%s", err))
+		} else if err != nil {
+			log.Println(logPrefixFlickr, color.MagentaString("Couldn't check the API key, it'll be tried anyway:\t%s", err))
+		} else {
+			log.Println(logPrefixFlickr, color.HiMagentaString("API key accepted"))
+		}
+	}
+
 	// Google Drive Client
-	if config.Credentials.GoogleDriveCredentialsJSON != "" {
+	if getConfig().Credentials.GoogleDriveCredentialsJSON != "" {
 		log.Println(logPrefixGoogleDrive, color.MagentaString("Connecting..."))
-		ctx := context.Background()
-		authJson, err := ioutil.ReadFile(config.Credentials.GoogleDriveCredentialsJSON)
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, getHTTPClient("https://www.googleapis.com"))
+		authJson, err := ioutil.ReadFile(getConfig().Credentials.GoogleDriveCredentialsJSON)
 		if err != nil {
 			log.Println(logPrefixGoogleDrive, color.HiRedString("Error opening Google Credentials JSON:\t%s", err))
 		} else {
@@ -199,19 +327,32 @@ func main() {
 
 	//#region Discord Initialization
 	botLogin()
+	openHistoryReaders()
 
 	// Event Handlers
 	dgr = handleCommands()
 	bot.AddHandler(messageCreate)
 	bot.AddHandler(messageUpdate)
+	bot.AddHandler(messageDelete)
+	bot.AddHandler(messageDeleteBulk)
+	bot.AddHandler(channelPinsUpdate)
+	bot.AddHandler(interactionCreateEvent)
+	bot.AddHandler(guildCreateSlashCommands)
+	bot.AddHandler(avatarMemberUpdate)
+	bot.AddHandler(avatarUserUpdate)
+	bot.AddHandler(avatarGuildUpdate)
+	bot.AddHandler(snapshotChannelUpdate)
+	bot.AddHandler(snapshotGuildUpdate)
+	bot.AddHandler(reactionRateLimited)
+	go startSlashCommands()
 
 	// Source Validation
-	if config.DebugOutput {
+	if getConfig().DebugOutput {
 		log.Println(logPrefixDebugLabel("Validation"), color.HiYellowString("Validating configured channels/servers..."))
 	}
 	//-
-	if config.AdminChannels != nil {
-		for _, adminChannel := range config.AdminChannels {
+	if getConfig().AdminChannels != nil {
+		for _, adminChannel := range getConfig().AdminChannels {
 			if adminChannel.ChannelIDs != nil {
 				for _, subchannel := range *adminChannel.ChannelIDs {
 					_, err := bot.State.Channel(subchannel)
@@ -231,7 +372,7 @@ func main() {
 		}
 	}
 	//-
-	for _, server := range config.Servers {
+	for _, server := range getConfig().Servers {
 		if server.ServerIDs != nil {
 			for _, subserver := range *server.ServerIDs {
 				_, err := bot.State.Guild(subserver)
@@ -248,7 +389,7 @@ func main() {
 			}
 		}
 	}
-	for _, channel := range config.Channels {
+	for _, channel := range getConfig().Channels {
 		if channel.ChannelIDs != nil {
 			for _, subchannel := range *channel.ChannelIDs {
 				_, err := bot.State.Channel(subchannel)
@@ -281,18 +422,31 @@ func main() {
 			logMsg += fmt.Sprintf("\n**- Download Channels: (%d)** - %s", len(invalidChannels), strings.Join(invalidChannels, ", "))
 		}
 		logErrorMessage(logMsg)
-	} else if config.DebugOutput {
+	} else if getConfig().DebugOutput {
 		log.Println(logPrefixDebugLabel("Validation"), color.HiGreenString("All channels/servers successfully validated!"))
 	}
 
 	// Start Presence
 	timeLastUpdated = time.Now()
 	updateDiscordPresence()
+	startPresenceRotation()
+	startSideEffectQueue()
+	startStatusGauges()
+	startSnapshots()
+	go recoverPendingDownloads()
+	go startSchedule()
+	go updateFilesystemIndexes()
+	go startRetention()
+	go startupIntegrityCheck()
+	startReactionRefresh()
+	go startAPI()
+	go startGallery()
+	go startFeeds()
 
 	//#endregion
 
 	// Output Done
-	if config.DebugOutput {
+	if getConfig().DebugOutput {
 		log.Println(color.YellowString("Startup finished, took %s...", uptime()))
 	}
 	log.Println(color.HiCyanString(wrapHyphensW(fmt.Sprintf("%s v%s is online and connected to %d server%s", projectLabel, projectVersion, len(bot.State.Guilds), pluralS(len(bot.State.Guilds))))))
@@ -313,7 +467,7 @@ func main() {
 		serverKey = strings.ToUpper(serverKey)
 		if constants[serverKey] == "" {
 			constants[serverKey] = server.ID
-		} else if config.DebugOutput {
+		} else if getConfig().DebugOutput {
 			log.Println(logPrefixDebug, "[Constants]", color.HiYellowString("%s already cached (processing %s, has %s stored)", serverKey, server.ID, constants[serverKey]))
 		}
 		for _, channel := range server.Channels {
@@ -333,7 +487,7 @@ func main() {
 				channelKey = strings.ToUpper(channelKey)
 				if constants[channelKey] == "" {
 					constants[channelKey] = channel.ID
-				} else if config.DebugOutput {
+				} else if getConfig().DebugOutput {
 					log.Println(logPrefixDebug, "[Constants]", color.HiYellowString("%s already cached (processing %s/%s, has %s stored)", channelKey, server.ID, channel.ID, constants[channelKey]))
 				}
 			}
@@ -398,16 +552,16 @@ func main() {
 			}
 			continue
 		}
-		if config.AutorunHistory {
+		if getConfig().AutorunHistory {
 			autorunHistoryChannels = append(autorunHistoryChannels, channel)
 		}
 	}
 	// Process autorun history
 	for _, channel := range autorunHistoryChannels {
-		if config.AsynchronousHistory {
-			go handleHistory(nil, channel, "", "")
+		if getConfig().AsynchronousHistory {
+			go handleHistory(nil, channel, "", "", historyOptions{})
 		} else {
-			handleHistory(nil, channel, "", "")
+			handleHistory(nil, channel, "", "", historyOptions{})
 		}
 	}
 	if len(autorunHistoryChannels) > 0 {
@@ -415,46 +569,59 @@ func main() {
 		log.Println(color.CyanString("Waiting for something else to do..."))
 	}
 
+	// Scheduled catch-up history
+	startAutoHistory()
+
 	// Settings Watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Println(color.HiRedString("[Watchers] Error creating NewWatcher:\t%s", err))
-	}
-	defer watcher.Close()
-	err = watcher.Add(configFile)
-	if err != nil {
-		log.Println(color.HiRedString("[Watchers] Error adding watcher for settings:\t%s", err))
-	}
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					// It double-fires the event without time check, might depend on OS but this works anyways
-					if time.Now().Sub(configReloadLastTime).Milliseconds() > 1 {
-						time.Sleep(1 * time.Second)
-						log.Println(logPrefixSettings, color.YellowString("Detected changes in \"%s\", reloading...", configFile))
-						loadConfig()
-						log.Println(logPrefixSettings, color.HiYellowString("Reloaded - bound to %d channel%s and %d server%s",
-							getBoundChannelsCount(), pluralS(getBoundChannelsCount()),
-							getBoundServersCount(), pluralS(getBoundServersCount()),
-						))
-
-						updateDiscordPresence()
+	if getConfig().WatchSettings {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Println(color.HiRedString("[Watchers] Error creating NewWatcher:\t%s", err))
+		}
+		defer watcher.Close()
+		err = watcher.Add(configFile)
+		if err != nil {
+			log.Println(color.HiRedString("[Watchers] Error adding watcher for settings:\t%s", err))
+		}
+		go func() {
+			for {
+				select {
+				case event, ok := <-watcher.Events:
+					if !ok {
+						return
 					}
-					configReloadLastTime = time.Now()
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
+					if event.Op&fsnotify.Write == fsnotify.Write {
+						// It double-fires the event without time check, might depend on OS but this works anyways
+						if time.Now().Sub(configReloadLastTime).Milliseconds() > 1 {
+							time.Sleep(1 * time.Second)
+							log.Println(logPrefixSettings, color.YellowString("Detected changes in \"%s\", reloading...", configFile))
+							// Destinations aren't checked on every save, the reload command does that
+							restartRequired, err := reloadConfig(false)
+							if err != nil {
+								log.Println(logPrefixSettings, color.HiRedString("Failed to reload, keeping previous settings...\t%s", err))
+								logErrorMessage(fmt.Sprintf("Failed to reload settings, keeping previous settings...\n```%s```", err))
+							} else {
+								log.Println(logPrefixSettings, color.HiYellowString("Reloaded - bound to %d channel%s and %d server%s",
+									getBoundChannelsCount(), pluralS(getBoundChannelsCount()),
+									getBoundServersCount(), pluralS(getBoundServersCount()),
+								))
+								if len(restartRequired) > 0 {
+									log.Println(logPrefixSettings, color.HiRedString("Changes to %s require a restart to apply...", strings.Join(restartRequired, ", ")))
+								}
+								updateDiscordPresence()
+							}
+						}
+						configReloadLastTime = time.Now()
+					}
+				case err, ok := <-watcher.Errors:
+					if !ok {
+						return
+					}
+					log.Println(color.HiRedString("[Watchers] Error:\t%s", err))
 				}
-				log.Println(color.HiRedString("[Watchers] Error:\t%s", err))
 			}
-		}
-	}()
+		}()
+	}
 
 	//#endregion
 
@@ -464,29 +631,36 @@ func main() {
 
 	logStatusMessage(logStatusExit)
 
+	flushDigests("")
+	flushErrorLog()
+
 	log.Println(logPrefixDiscord, color.GreenString("Logging out of discord..."))
 	bot.Close()
 
 	log.Println(logPrefixDatabase, color.YellowString("Closing database..."))
+	downloadsStore.Close()
 	myDB.Close()
+	flushImgStore()
+	flushVidStore()
 
 	log.Println(color.HiRedString("Exiting... "))
 }
 
 func botLogin() {
+	settings := getConfig()
 	var err error
 
-	if config.Credentials.Token != "" && config.Credentials.Token != placeholderToken {
+	if settings.Credentials.Token != "" && settings.Credentials.Token != placeholderToken {
 		log.Println(logPrefixDiscord, color.GreenString("Connecting to Discord via Token..."))
-		if config.Credentials.UserBot {
-			bot, err = discordgo.New(config.Credentials.Token)
+		if settings.Credentials.UserBot {
+			bot, err = discordgo.New(settings.Credentials.Token)
 		} else {
-			bot, err = discordgo.New("Bot " + config.Credentials.Token)
+			bot, err = discordgo.New("Bot " + settings.Credentials.Token)
 		}
-	} else if (config.Credentials.Email != "" && config.Credentials.Email != placeholderEmail) &&
-		(config.Credentials.Password != "" && config.Credentials.Password != placeholderPassword) {
+	} else if (settings.Credentials.Email != "" && settings.Credentials.Email != placeholderEmail) &&
+		(settings.Credentials.Password != "" && settings.Credentials.Password != placeholderPassword) {
 		log.Println(logPrefixDiscord, color.GreenString("Connecting via Login..."))
-		bot, err = discordgo.New(config.Credentials.Email, config.Credentials.Password)
+		bot, err = discordgo.New(settings.Credentials.Email, settings.Credentials.Password)
 	} else {
 		log.Println(logPrefixDiscord, color.HiRedString("No valid credentials for Discord..."))
 		properExit()
@@ -499,6 +673,13 @@ func botLogin() {
 		}
 	}
 
+	applyNetworkSettingsToDiscord(bot)
+	if settings.Credentials.Token != "" && settings.Credentials.Token != placeholderToken {
+		bot = detectTokenType(bot)
+	}
+
+	configureMessageCache()
+
 	// Connect Bot
 	bot.LogLevel = -1 // to ignore dumb wsapi error
 	err = bot.Open()
@@ -506,7 +687,7 @@ func botLogin() {
 		log.Println(logPrefixDiscord, color.HiRedString("Discord login failed:\t%s", err))
 		properExit()
 	}
-	bot.LogLevel = config.DiscordLogLevel // reset
+	bot.LogLevel = settings.DiscordLogLevel // reset
 	bot.ShouldReconnectOnError = true
 
 	// Fetch Bot's User Info
@@ -535,4 +716,9 @@ func botLogin() {
 			log.Println(logPrefixDiscord, color.MagentaString("- If you wish to avoid this, use a Bot account if possible."))
 		}
 	}
+	if user != nil && !user.Bot {
+		isUserSession = true
+		log.Println(logPrefixDiscord, color.MagentaString("- Reactions, presence updates and embeds are off unless enabled in userSession, history is paced at %s or more per batch.", settings.UserSession.HistoryDelay))
+	}
+	loadBotOwners()
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Patterns are compiled by main, tests need them too.
+func TestMain(m *testing.M) {
+	if err := compileRegex(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs the rest of the test from an empty folder, for code that works relative to where the bot was started.
+func useTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// Swaps in a copy of the config with edit applied, the previous one comes back when the test ends.
+func useTestConfig(t *testing.T, edit func(settings *configuration)) {
+	t.Helper()
+	previous := getConfig()
+	settings := *previous
+	edit(&settings)
+	setConfig(settings)
+	t.Cleanup(func() { configPointer.Store(previous) })
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"mvdan.cc/xurls/v2"
+)
+
+const (
+	// Default folder when no alias is given and manualDestinations has no "default"
+	manualDestinationDefault = "manual"
+	// URL lists bigger than this are cut off rather than read into memory
+	manualListMaxSize = 1024 * 1024
+)
+
+type manualDownloadResult struct {
+	url      string
+	filename string
+	status   downloadStatusStruct
+	skipped  string // set instead of status when nothing was attempted
+}
+
+// Commands are matched lowercase, this gets back the original text for arguments where case matters.
+func getOriginalContent(m *discordgo.Message) string {
+	if original, err := bot.State.Message(m.ChannelID, m.ID); err == nil && original != nil {
+		return original.Content
+	}
+	if original, err := bot.ChannelMessage(m.ChannelID, m.ID); err == nil && original != nil {
+		return original.Content
+	}
+	return m.Content
+}
+
+// Arguments of the original message after the prefix, the command's name first. Slash commands keep each
+// option as one argument, even with spaces in it.
+func getOriginalArgs(m *discordgo.Message) []string {
+	settings := getConfig()
+	if ci := getCommandInteraction(m.ID); ci != nil && len(ci.args) > 0 {
+		return ci.args
+	}
+	content := getOriginalContent(m)
+	if strings.HasPrefix(strings.ToLower(content), strings.ToLower(settings.CommandPrefix)) {
+		content = content[len(settings.CommandPrefix):]
+	}
+	return strings.Fields(content)
+}
+
+func isManualDownloadCommand(m *discordgo.Message) bool {
+	content, prefix := strings.ToLower(m.Content), strings.ToLower(getConfig().CommandPrefix)
+	if !strings.HasPrefix(content, prefix) {
+		return false
+	}
+	fields := strings.Fields(content[len(prefix):])
+	return len(fields) > 0 && fields[0] == "download"
+}
+
+// Path for a manualDestinations alias, an empty alias uses "default" or the manual folder.
+func getManualDestination(alias string) (string, bool) {
+	alias = strings.ToLower(alias)
+	if path, exists := getConfig().ManualDestinations[alias]; exists {
+		return path, true
+	}
+	if alias == "" || alias == "default" {
+		return manualDestinationDefault, true
+	}
+	return "", false
+}
+
+func getManualDestinationAliases() []string {
+	var aliases []string
+	for alias := range getConfig().ManualDestinations {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// URLs from .txt attachments, one or more per line.
+func getAttachedURLs(m *discordgo.Message) ([]string, error) {
+	var urls []string
+	for _, attachment := range m.Attachments {
+		if strings.ToLower(filepath.Ext(attachment.Filename)) != ".txt" {
+			continue
+		}
+		response, err := getHTTPClient(attachment.URL).Get(attachment.URL)
+		if err != nil {
+			return urls, fmt.Errorf("failed to read %s: %s", attachment.Filename, err)
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, manualListMaxSize))
+		response.Body.Close()
+		if err != nil {
+			return urls, fmt.Errorf("failed to read %s: %s", attachment.Filename, err)
+		}
+		urls = append(urls, xurls.Strict().FindAllString(string(body), -1)...)
+	}
+	return urls, nil
+}
+
+// Resolves each URL through the site handlers and downloads what it finds, one file at a time.
+// Fresh skips what the handlers found for the same URLs earlier.
+func runManualDownloads(m *discordgo.Message, urls []string, destination string, fresh bool) []manualDownloadResult {
+	var results []manualDownloadResult
+	for _, inputURL := range urls {
+		var links map[string]string
+		if fresh {
+			links = getFreshDownloadLinks(inputURL, m.ChannelID)
+		} else {
+			links = getDownloadLinks(inputURL, m.ChannelID)
+		}
+		if len(links) == 0 {
+			results = append(results, manualDownloadResult{url: inputURL, skipped: "Already downloaded from this channel"})
+			continue
+		}
+		for link, filename := range links {
+			status := startDownload(downloadRequestStruct{
+				InputURL:       link,
+				Filename:       filename,
+				Path:           destination,
+				Message:        m,
+				FileTime:       time.Now(),
+				Post:           getSitePost(link),
+				ManualDownload: true,
+				DryRun:         dryRunMode,
+			})
+			if status.Saved != nil {
+				filename = status.Saved.Filename
+			}
+			results = append(results, manualDownloadResult{url: link, filename: filename, status: status})
+		}
+	}
+	return results
+}
+
+func formatManualResults(results []manualDownloadResult) string {
+	saved := 0
+	var lines []string
+	length := 0
+	for i, result := range results {
+		label := result.filename
+		if label == "" {
+			label = result.url
+		}
+		if len(label) > 100 {
+			label = label[:100] + "..."
+		}
+		reason := result.skipped
+		if reason == "" {
+			reason = getDownloadStatusString(result.status.Status)
+			if result.status.Status == downloadSuccess {
+				saved++
+				if result.status.Size > 0 {
+					reason += fmt.Sprintf(" (%s)", formatBytes(result.status.Size))
+				}
+			} else if result.status.Error != nil {
+				reason += fmt.Sprintf(": %s", result.status.Error)
+			}
+		}
+		line := fmt.Sprintf("`%s` — %s", label, reason)
+		// Embed descriptions are limited to 4096 characters
+		if length+len(line) > 3600 {
+			lines = append(lines, fmt.Sprintf("_...and %d more_", len(results)-i))
+			break
+		}
+		length += len(line)
+		lines = append(lines, line)
+	}
+	return fmt.Sprintf("Saved **%d** of %d file%s\n\n%s", saved, len(results), pluralS(len(results)), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Links to other Discord messages are swapped for what's in the message, if the bot can read it. Links in the
+// linked message to further messages aren't followed.
+
+// Files found through a linked message, to the channel it's in, for folderLinkedMessagesBySource
+var linkedMessageSources sync.Map
+
+func getLinkedMessageLinks(inputURL string, channelID string, fresh bool, albumLimit int) map[string]string {
+	settings := getConfig()
+	matches := regexUrlDiscordMessage.FindStringSubmatch(inputURL)
+	sourceChannelID, messageID := matches[6], matches[7]
+	if bot == nil {
+		return nil
+	}
+	if _, err := bot.State.Channel(sourceChannelID); err != nil {
+		log.Println(logPrefixFileSkip, color.GreenString("Linked message %s is in a channel the bot isn't in, skipping %s", messageID, inputURL))
+		return nil
+	}
+	if !hasPerms(sourceChannelID, discordgo.PermissionReadMessageHistory) {
+		log.Println(logPrefixFileSkip, color.GreenString("Bot can't read message history in %s, skipping linked message %s", getSourceName(getChannelGuildID(sourceChannelID), sourceChannelID), messageID))
+		return nil
+	}
+	linked, err := bot.ChannelMessage(sourceChannelID, messageID)
+	if err != nil {
+		log.Println(logPrefixFileSkip, color.GreenString("Linked message %s couldn't be fetched, skipping %s:\t%s", messageID, inputURL, err))
+		return nil
+	}
+
+	// Gone through with the settings of the channel it was linked in
+	linked.ChannelID = channelID
+	links := make(map[string]string)
+	for _, rawLink := range getMessageRawLinks(linked, settings) {
+		if regexUrlDiscordMessage.MatchString(rawLink.Link) {
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Not following %s from linked message %s, only one link deep", rawLink.Link, messageID))
+			}
+			continue
+		}
+		for link, filename := range resolveDownloadLinks(rawLink.Link, channelID, fresh, albumLimit) {
+			if rawLink.Filename != "" {
+				filename = rawLink.Filename
+			}
+			links[link] = filename
+			linkedMessageSources.Store(link, sourceChannelID)
+		}
+	}
+	if settings.DebugOutput {
+		log.Println(logPrefixDebug, color.CyanString("Linked message %s has %d file%s", messageID, len(links), pluralS(len(links))))
+	}
+	return links
+}
+
+// Channel downloads are foldered under, the one a linked message is in if the channel asks for that.
+func getFolderChannelID(download downloadRequestStruct, channelConfig configurationChannel) string {
+	if *channelConfig.FolderLinkedMessagesBySource {
+		if source, linked := linkedMessageSources.Load(download.InputURL); linked {
+			return source.(string)
+		}
+	}
+	return download.Message.ChannelID
+}
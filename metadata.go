@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Where a saved image came from, written into it by embedSourceMetadata.
+type sourceMetadata struct {
+	URL    string
+	Author string
+	Time   time.Time
+}
+
+func getSourceMetadata(message *discordgo.Message) *sourceMetadata {
+	guildID := message.GuildID
+	if guildID == "" {
+		guildID = "@me"
+	}
+	source := &sourceMetadata{
+		URL: fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, message.ChannelID, message.ID),
+	}
+	if message.Author != nil {
+		source.Author = message.Author.Username + "#" + message.Author.Discriminator
+	}
+	if timestamp, err := message.Timestamp.Parse(); err == nil {
+		source.Time = timestamp
+	}
+	return source
+}
+
+// Applies stripEXIF and embedSourceMetadata to JPEG and PNG files, anything else is returned untouched.
+// Only metadata is rewritten, image data is copied as is. If the file can't be parsed the original is returned,
+// a file with its metadata intact beats a broken one.
+func processImageMetadata(body []byte, strip bool, source *sourceMetadata) []byte {
+	var out []byte
+	var err error
+	switch {
+	case bytes.HasPrefix(body, []byte{0xFF, 0xD8}):
+		out, err = processJPEGMetadata(body, strip, source)
+	case bytes.HasPrefix(body, pngSignature):
+		out, err = processPNGMetadata(body, strip, source)
+	default:
+		return body
+	}
+	if err != nil {
+		log.Println(color.HiRedString("Failed to update image metadata, saving it unchanged...\t%s", err))
+		return body
+	}
+	return out
+}
+
+//#region JPEG
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	jpegXMPHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+const (
+	tiffTagImageDescription = 0x010E
+	tiffTagOrientation      = 0x0112
+	tiffTagDateTime         = 0x0132
+	tiffTagArtist           = 0x013B
+
+	tiffTypeASCII = 2
+	tiffTypeShort = 3
+)
+
+// Rewrites the APP segments before the image data. Stripping drops EXIF (except orientation) and XMP,
+// which is where GPS and camera details live. Source details go into the EXIF IFD0.
+func processJPEGMetadata(body []byte, strip bool, source *sourceMetadata) ([]byte, error) {
+	var exif []byte
+	var segments [][]byte
+	pos := 2
+	for {
+		if pos+2 > len(body) || body[pos] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := body[pos+1]
+		if marker == 0xFF { // fill byte
+			pos++
+			continue
+		}
+		// Start of scan, everything from here on is image data and is copied untouched
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) { // markers without a length
+			segments = append(segments, body[pos:pos+2])
+			pos += 2
+			continue
+		}
+		if pos+4 > len(body) {
+			return nil, errors.New("truncated JPEG")
+		}
+		length := int(binary.BigEndian.Uint16(body[pos+2:]))
+		if length < 2 || pos+2+length > len(body) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segment := body[pos : pos+2+length]
+		payload := segment[4:]
+		pos += 2 + length
+
+		if marker == 0xE1 && bytes.HasPrefix(payload, jpegExifHeader) {
+			if exif == nil {
+				exif = payload[len(jpegExifHeader):]
+			}
+			continue
+		}
+		if marker == 0xE1 && strip && bytes.HasPrefix(payload, jpegXMPHeader) {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	var tiff []byte
+	var err error
+	switch {
+	case strip:
+		var entries []tiffEntry
+		if orientation := tiffOrientation(exif); orientation != 0 {
+			value := make([]byte, 2)
+			binary.LittleEndian.PutUint16(value, orientation)
+			entries = append(entries, tiffEntry{tag: tiffTagOrientation, kind: tiffTypeShort, count: 1, value: value})
+		}
+		entries = append(entries, sourceTIFFEntries(source)...)
+		if len(entries) > 0 {
+			tiff = buildTIFF(entries)
+		}
+	case source != nil && exif != nil:
+		tiff, err = addTIFFEntries(exif, sourceTIFFEntries(source))
+		if err != nil {
+			return nil, err
+		}
+	case source != nil:
+		tiff = buildTIFF(sourceTIFFEntries(source))
+	default:
+		tiff = exif
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(body)+1024))
+	out.Write(body[:2])
+	// JFIF wants to be first, EXIF goes right after it
+	if len(segments) > 0 && segments[0][1] == 0xE0 {
+		out.Write(segments[0])
+		segments = segments[1:]
+	}
+	if tiff != nil {
+		length := 2 + len(jpegExifHeader) + len(tiff)
+		if length > 0xFFFF {
+			return nil, errors.New("EXIF too large")
+		}
+		out.Write([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)})
+		out.Write(jpegExifHeader)
+		out.Write(tiff)
+	}
+	for _, segment := range segments {
+		out.Write(segment)
+	}
+	out.Write(body[pos:])
+	return out.Bytes(), nil
+}
+
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte // new values, in the byte order of the TIFF being written
+	raw   []byte // 4 byte value/offset field copied from an existing IFD
+}
+
+func asciiTIFFEntry(tag uint16, value string) tiffEntry {
+	data := append([]byte(value), 0)
+	return tiffEntry{tag: tag, kind: tiffTypeASCII, count: uint32(len(data)), value: data}
+}
+
+func sourceTIFFEntries(source *sourceMetadata) []tiffEntry {
+	if source == nil {
+		return nil
+	}
+	entries := []tiffEntry{asciiTIFFEntry(tiffTagImageDescription, source.URL)}
+	if !source.Time.IsZero() {
+		entries = append(entries, asciiTIFFEntry(tiffTagDateTime, source.Time.Format("2006:01:02 15:04:05")))
+	}
+	if source.Author != "" {
+		entries = append(entries, asciiTIFFEntry(tiffTagArtist, source.Author))
+	}
+	return entries
+}
+
+func tiffByteOrder(tiff []byte) (binary.ByteOrder, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("truncated EXIF")
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian, nil
+	case "MM":
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("invalid EXIF byte order")
+}
+
+// Reads IFD0's entries and the offset of the IFD after it.
+func readTIFFIFD0(tiff []byte) (binary.ByteOrder, []tiffEntry, uint32, error) {
+	order, err := tiffByteOrder(tiff)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return nil, nil, 0, errors.New("invalid EXIF IFD offset")
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	if offset+2+count*12+4 > len(tiff) {
+		return nil, nil, 0, errors.New("truncated EXIF IFD")
+	}
+	entries := make([]tiffEntry, count)
+	for i := range entries {
+		entry := tiff[offset+2+i*12:]
+		entries[i] = tiffEntry{
+			tag:   order.Uint16(entry),
+			kind:  order.Uint16(entry[2:]),
+			count: order.Uint32(entry[4:]),
+			raw:   entry[8:12],
+		}
+	}
+	return order, entries, order.Uint32(tiff[offset+2+count*12:]), nil
+}
+
+func tiffOrientation(tiff []byte) uint16 {
+	if tiff == nil {
+		return 0
+	}
+	order, entries, _, err := readTIFFIFD0(tiff)
+	if err != nil {
+		return 0
+	}
+	for _, entry := range entries {
+		if entry.tag == tiffTagOrientation && entry.kind == tiffTypeShort && entry.count == 1 {
+			return order.Uint16(entry.raw)
+		}
+	}
+	return 0
+}
+
+// Writes a new IFD0 with the entries added (replacing any with the same tags) to the end of the existing EXIF.
+// The old data stays where it is, so offsets in the copied entries, sub-IFDs and maker notes remain valid.
+func addTIFFEntries(tiff []byte, added []tiffEntry) ([]byte, error) {
+	order, entries, next, err := readTIFFIFD0(tiff)
+	if err != nil {
+		return nil, err
+	}
+	replaced := make(map[uint16]bool)
+	for _, entry := range added {
+		replaced[entry.tag] = true
+	}
+	var merged []tiffEntry
+	for _, entry := range entries {
+		if !replaced[entry.tag] {
+			merged = append(merged, entry)
+		}
+	}
+	merged = append(merged, added...)
+
+	out := append([]byte{}, tiff...)
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	order.PutUint32(out[4:], uint32(len(out)))
+	return appendTIFFIFD(out, order, merged, next), nil
+}
+
+func buildTIFF(entries []tiffEntry) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	return appendTIFFIFD(tiff, binary.LittleEndian, entries, 0)
+}
+
+// Appends an IFD to the TIFF data, with values too big to fit in an entry stored right after it.
+func appendTIFFIFD(tiff []byte, order binary.ByteOrder, entries []tiffEntry, next uint32) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	ifd := make([]byte, 2+len(entries)*12+4)
+	order.PutUint16(ifd, uint16(len(entries)))
+	order.PutUint32(ifd[2+len(entries)*12:], next)
+
+	dataOffset := len(tiff) + len(ifd)
+	var data []byte
+	for i, entry := range entries {
+		field := ifd[2+i*12:]
+		order.PutUint16(field, entry.tag)
+		order.PutUint16(field[2:], entry.kind)
+		order.PutUint32(field[4:], entry.count)
+		switch {
+		case entry.raw != nil:
+			copy(field[8:12], entry.raw)
+		case len(entry.value) <= 4:
+			copy(field[8:12], entry.value)
+		default:
+			order.PutUint32(field[8:], uint32(dataOffset+len(data)))
+			data = append(data, entry.value...)
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+	}
+	return append(append(tiff, ifd...), data...)
+}
+
+//#endregion
+
+//#region PNG
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngSourceKeywords = []string{"Source URL", "Author", "Creation Time"}
+
+// Stripping drops eXIf and XMP chunks. Source details are added as iTXt chunks before the image data.
+func processPNGMetadata(body []byte, strip bool, source *sourceMetadata) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(body)+512))
+	out.Write(pngSignature)
+	inserted := false
+	for pos := len(pngSignature); pos < len(body); {
+		if pos+12 > len(body) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(body[pos:]))
+		if length < 0 || pos+12+length > len(body) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		kind := string(body[pos+4 : pos+8])
+		data := body[pos+8 : pos+8+length]
+		chunk := body[pos : pos+12+length]
+		pos += 12 + length
+
+		if strip && (kind == "eXIf" || (kind == "iTXt" && bytes.HasPrefix(data, []byte("XML:com.adobe.xmp\x00")))) {
+			continue
+		}
+		if source != nil && kind == "iTXt" && isPNGSourceChunk(data) {
+			continue
+		}
+		if source != nil && !inserted && kind == "IDAT" {
+			out.Write(pngTextChunk("Source URL", source.URL))
+			if source.Author != "" {
+				out.Write(pngTextChunk("Author", source.Author))
+			}
+			if !source.Time.IsZero() {
+				out.Write(pngTextChunk("Creation Time", source.Time.Format(time.RFC1123Z)))
+			}
+			inserted = true
+		}
+		out.Write(chunk)
+	}
+	return out.Bytes(), nil
+}
+
+func isPNGSourceChunk(data []byte) bool {
+	for _, keyword := range pngSourceKeywords {
+		if bytes.HasPrefix(data, append([]byte(keyword), 0)) {
+			return true
+		}
+	}
+	return false
+}
+
+// iTXt rather than tEXt, since usernames aren't limited to Latin-1.
+func pngTextChunk(keyword string, text string) []byte {
+	data := append([]byte(keyword), 0, 0, 0, 0, 0) // null, uncompressed, compression method, no language, no translated keyword
+	data = append(data, text...)
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "iTXt")
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+//#endregion
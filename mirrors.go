@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Mirrors re-post files saved from a Discord channel to a Telegram chat through a bot. Uploads happen in the
+// background and never change how a download went.
+
+var logPrefixMirror = color.HiBlueString("[Mirrors]")
+
+const (
+	telegramAPI = "https://api.telegram.org/bot"
+	// Largest upload the Bot API takes
+	mirrorMaxSizeDefault = "50MB"
+	// Photos bigger than this are sent as documents, Telegram won't take them as photos
+	telegramPhotoMaxSize = 10 << 20
+	// Telegram cuts captions off at this many characters
+	telegramCaptionMax   = 1024
+	mirrorCaptionDefault = "{server} #{channel}\n{user}\n{url}"
+	// Uploads waiting on a mirror before new ones are dropped
+	mirrorQueueSize = 64
+	// Attempts per upload, waiting longer between each
+	mirrorRetries = 3
+)
+
+var mirrorClient = &http.Client{Timeout: 5 * time.Minute}
+
+type mirrorUpload struct {
+	path    string
+	kind    string
+	caption string
+}
+
+type mirror struct {
+	target configurationMirror
+	queue  chan mirrorUpload
+}
+
+var (
+	mirrors      = make(map[string]*mirror)
+	mirrorsMutex sync.Mutex
+)
+
+// Chats get a worker each, kept across settings reloads so uploads go out one at a time.
+func getMirror(target configurationMirror) *mirror {
+	key := target.BotToken + "|" + target.ChatID
+	mirrorsMutex.Lock()
+	defer mirrorsMutex.Unlock()
+	if m, exists := mirrors[key]; exists {
+		m.target = target
+		return m
+	}
+	m := &mirror{target: target, queue: make(chan mirrorUpload, mirrorQueueSize)}
+	mirrors[key] = m
+	go func() {
+		for upload := range m.queue {
+			m.send(upload)
+		}
+	}()
+	return m
+}
+
+// Mirrors the Discord channel goes to.
+func getChannelMirrors(channelID string) []configurationMirror {
+	var targets []configurationMirror
+	for _, target := range getConfig().Mirrors {
+		if target.ChannelID == channelID || (target.ChannelIDs != nil && stringInSlice(channelID, *target.ChannelIDs)) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Queues a saved file for every mirror of its channel that takes its type and size.
+func sendDownloadMirrors(download downloadRequestStruct, status downloadStatusStruct) {
+	if status.Status != downloadSuccess || status.Saved == nil || download.Message == nil {
+		return
+	}
+	targets := getChannelMirrors(download.Message.ChannelID)
+	if len(targets) == 0 {
+		return
+	}
+	path := status.Saved.Destination
+	if isRemoteDestination(path) {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, logPrefixMirror, color.YellowString("Not mirroring \"%s\", remote files can't be uploaded", path))
+		}
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Println(logPrefixMirror, color.HiRedString("Can't mirror \"%s\":\t%s", path, err))
+		return
+	}
+	kind := getFileType(path)
+	for _, target := range targets {
+		if target.ContentTypes != nil && !stringInSlice(kind, *target.ContentTypes) {
+			continue
+		}
+		maxSize, _ := parseByteSize(target.MaxSize)
+		if info.Size() > maxSize {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, logPrefixMirror, color.YellowString("Not mirroring \"%s\" to %s, %s is over %s", path, target.ChatID, formatBytes(info.Size()), target.MaxSize))
+			}
+			continue
+		}
+		m := getMirror(target)
+		select {
+		case m.queue <- mirrorUpload{path, kind, formatMirrorCaption(target.Caption, download, status.Saved)}:
+		default:
+			log.Println(logPrefixMirror, color.HiRedString("Queue for %s is full, not mirroring \"%s\"", target.ChatID, path))
+		}
+	}
+}
+
+func formatMirrorCaption(caption string, download downloadRequestStruct, saved *downloadItem) string {
+	server, user := "Direct Messages", "unknown"
+	if guildID := getChannelGuildID(saved.ChannelID); guildID != "" {
+		server = getGuildName(guildID)
+	}
+	if download.Message.Author != nil {
+		user = download.Message.Author.Username
+	}
+	for _, key := range [][]string{
+		{"{server}", server},
+		{"{channel}", getChannelName(saved.ChannelID)},
+		{"{user}", user},
+		{"{url}", saved.URL},
+		{"{filename}", filepath.Base(saved.Destination)},
+		{"{messageURL}", fmt.Sprintf("https://discord.com/channels/%s/%s/%s", getChannelGuildID(saved.ChannelID), saved.ChannelID, saved.MessageID)},
+	} {
+		caption = strings.ReplaceAll(caption, key[0], key[1])
+	}
+	if runes := []rune(caption); len(runes) > telegramCaptionMax {
+		caption = string(runes[:telegramCaptionMax-1]) + "…"
+	}
+	return caption
+}
+
+//#region Telegram
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Bot API method and form field for a file, photos and animations show inline, anything else goes as a document.
+func getTelegramMethod(path string, kind string, size int64) (string, string) {
+	switch {
+	case strings.EqualFold(filepathExtension(path), ".gif"):
+		return "sendAnimation", "animation"
+	case kind == "image" && size <= telegramPhotoMaxSize:
+		return "sendPhoto", "photo"
+	case kind == "video":
+		return "sendVideo", "video"
+	case kind == "audio":
+		return "sendAudio", "audio"
+	}
+	return "sendDocument", "document"
+}
+
+// Retries failed requests, server errors and rate limits, giving up after mirrorRetries attempts.
+func (m *mirror) send(upload mirrorUpload) {
+	for attempt := 1; attempt <= mirrorRetries; attempt++ {
+		wait := time.Duration(attempt*10) * time.Second
+		retry, err := m.post(upload, &wait)
+		if err == nil {
+			return
+		}
+		if !retry {
+			log.Println(logPrefixMirror, color.HiRedString("Telegram refused \"%s\" for %s:\t%s", upload.path, m.target.ChatID, err))
+			return
+		}
+		if attempt == mirrorRetries {
+			log.Println(logPrefixMirror, color.HiRedString("Gave up mirroring \"%s\" to %s after %d attempts:\t%s", upload.path, m.target.ChatID, attempt, err))
+			return
+		}
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, logPrefixMirror, color.YellowString("Failed to mirror \"%s\" to %s, retrying in %s:\t%s", upload.path, m.target.ChatID, wait, err))
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Uploads the file once, returns whether it's worth trying again. Rate limits set how long to wait.
+func (m *mirror) post(upload mirrorUpload, wait *time.Duration) (bool, error) {
+	file, err := os.Open(upload.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	method, field := getTelegramMethod(upload.path, upload.kind, info.Size())
+
+	// Streamed, files can be up to 50MB
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		form.WriteField("chat_id", m.target.ChatID)
+		if upload.caption != "" {
+			form.WriteField("caption", upload.caption)
+		}
+		part, err := form.CreateFormFile(field, filepath.Base(upload.path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	request, err := http.NewRequest("POST", telegramAPI+m.target.BotToken+"/"+method, reader)
+	if err != nil {
+		reader.Close()
+		return false, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	response, err := mirrorClient.Do(request)
+	if err != nil {
+		// The token's in the URL, so it's left out of the error
+		if urlErr, ok := err.(interface{ Unwrap() error }); ok {
+			err = urlErr.Unwrap()
+		}
+		return true, err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	var result telegramResponse
+	json.Unmarshal(body, &result)
+	if response.StatusCode < 300 && result.OK {
+		return false, nil
+	}
+	err = fmt.Errorf("%s %s", response.Status, result.Description)
+	if result.Parameters.RetryAfter > 0 {
+		*wait = time.Duration(result.Parameters.RetryAfter+1) * time.Second
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500, err
+}
+
+//#endregion
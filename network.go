@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+//#region HTTP Clients
+
+var (
+	httpTransports      = make(map[string]*http.Transport) // keyed by proxy URL, "" for direct, and insecure domains
+	httpTransportsMutex sync.Mutex
+)
+
+// Redirects a download follows unless maxRedirects says otherwise
+const downloadMaxRedirectsDefault = 10
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// Returns the proxy to use for a hostname, "" for a direct connection.
+// Entries in downloadProxyDomains also match subdomains and take priority over downloadProxy.
+func getProxyForHost(host string) string {
+	settings := getConfig()
+	host = strings.ToLower(host)
+	for domain, proxy := range settings.DownloadProxyDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			if proxy == "direct" {
+				return ""
+			}
+			return proxy
+		}
+	}
+	return settings.DownloadProxy
+}
+
+// Returns a client for requesting the URL, routed through a proxy if one is configured for its domain.
+// Clients share transports so connections are reused.
+func getHTTPClient(rawURL string) *http.Client {
+	proxy := ""
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		proxy = getProxyForHost(parsedURL.Hostname())
+	}
+	return &http.Client{Transport: getHTTPTransport(proxy, nil)}
+}
+
+// Returns a client for downloading the URL for the channel, with its timeout and the most redirects it may follow.
+// Entries in downloadTimeoutDomains and maxRedirectsDomains take priority over the channel's, then the global,
+// settings.
+func getDownloadClient(rawURL string, channelConfig configurationChannel) (*http.Client, int) {
+	settings := getConfig()
+	host, proxy := "", ""
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(parsedURL.Hostname())
+		proxy = getProxyForHost(host)
+	}
+	insecureDomains := settings.InsecureSkipVerifyDomains
+	if channelConfig.InsecureSkipVerifyDomains != nil {
+		insecureDomains = append(append([]string{}, insecureDomains...), *channelConfig.InsecureSkipVerifyDomains...)
+	}
+
+	timeout := settings.DownloadTimeout
+	if channelConfig.DownloadTimeout != nil {
+		timeout = *channelConfig.DownloadTimeout
+	}
+	if domainTimeout, exists := getDomainSetting(host, settings.DownloadTimeoutDomains); exists {
+		timeout = domainTimeout
+	}
+	maxRedirects := settings.MaxRedirects
+	if channelConfig.MaxRedirects != nil {
+		maxRedirects = *channelConfig.MaxRedirects
+	}
+	if domainRedirects, exists := getDomainSetting(host, settings.MaxRedirectsDomains); exists {
+		maxRedirects = domainRedirects
+	}
+
+	return &http.Client{
+		Transport: getHTTPTransport(proxy, insecureDomains),
+		Timeout:   time.Duration(timeout) * time.Second,
+	}, maxRedirects
+}
+
+// The value for the most specific domain host is under, false if it's under none.
+func getDomainSetting(host string, domains map[string]int) (int, bool) {
+	match := ""
+	for domain := range domains {
+		lowered := strings.ToLower(domain)
+		if (host == lowered || strings.HasSuffix(host, "."+lowered)) && len(domain) > len(match) {
+			match = domain
+		}
+	}
+	if match == "" {
+		return 0, false
+	}
+	return domains[match], true
+}
+
+func isInsecureDomain(host string, insecureDomains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range insecureDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// A shared transport through the proxy. With insecure domains, certificates are still checked as usual for every
+// other host, so a redirect away from one of them isn't trusted blindly.
+func getHTTPTransport(proxy string, insecureDomains []string) *http.Transport {
+	key := proxy
+	if len(insecureDomains) > 0 {
+		sorted := make([]string, len(insecureDomains))
+		for i, domain := range insecureDomains {
+			sorted[i] = strings.ToLower(domain)
+		}
+		sort.Strings(sorted)
+		key += "\n" + strings.Join(sorted, ",")
+	}
+
+	httpTransportsMutex.Lock()
+	defer httpTransportsMutex.Unlock()
+	transport, exists := httpTransports[key]
+	if !exists {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialNetwork
+		if proxy != "" {
+			proxyURL, err := url.Parse(proxy)
+			if err != nil {
+				log.Println(logPrefixSettings, color.HiRedString("Invalid proxy \"%s\", connecting directly...\t%s", redactProxy(proxy), err))
+			} else {
+				transport.Proxy = http.ProxyURL(proxyURL)
+			}
+		}
+		if len(insecureDomains) > 0 {
+			transport.TLSClientConfig = &tls.Config{
+				InsecureSkipVerify: true,
+				VerifyConnection: func(state tls.ConnectionState) error {
+					if isInsecureDomain(state.ServerName, insecureDomains) {
+						return nil
+					}
+					options := x509.VerifyOptions{DNSName: state.ServerName, Intermediates: x509.NewCertPool()}
+					for _, certificate := range state.PeerCertificates[1:] {
+						options.Intermediates.AddCert(certificate)
+					}
+					_, err := state.PeerCertificates[0].Verify(options)
+					return err
+				},
+			}
+		}
+		httpTransports[key] = transport
+	}
+	return transport
+}
+
+// Warns loudly about every domain whose certificates aren't checked, so it's never left on by accident.
+func warnInsecureDomains() {
+	settings := getConfig()
+	domains := make(map[string]bool)
+	for _, domain := range settings.InsecureSkipVerifyDomains {
+		domains[domain] = true
+	}
+	channels := append(append([]configurationChannel{}, settings.Channels...), settings.Servers...)
+	if settings.All != nil {
+		channels = append(channels, *settings.All)
+	}
+	for _, channel := range channels {
+		if channel.InsecureSkipVerifyDomains != nil {
+			for _, domain := range *channel.InsecureSkipVerifyDomains {
+				domains[domain] = true
+			}
+		}
+	}
+	if len(domains) == 0 {
+		return
+	}
+	listed := make([]string, 0, len(domains))
+	for domain := range domains {
+		listed = append(listed, domain)
+	}
+	sort.Strings(listed)
+	log.Println(logPrefixSettings, color.HiRedString("*** TLS CERTIFICATES ARE NOT CHECKED FOR %s ***", strings.Join(listed, ", ")))
+	log.Println(logPrefixSettings, color.RedString("Anyone between the bot and these hosts could read or swap out what's downloaded from them, "+
+		"only list hosts you run yourself"))
+}
+
+// Strips the password from a proxy URL for logging.
+func redactProxy(proxy string) string {
+	if proxyURL, err := url.Parse(proxy); err == nil && proxyURL.User != nil {
+		if _, hasPassword := proxyURL.User.Password(); hasPassword {
+			proxyURL.User = url.UserPassword(proxyURL.User.Username(), "redacted")
+		}
+		return proxyURL.String()
+	}
+	return proxy
+}
+
+// True if the error came from failing to connect through a proxy rather than from the destination.
+func isProxyError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "proxyconnect") || strings.Contains(err.Error(), "socks connect"))
+}
+
+// Warns about configured proxies that can't be reached.
+func checkProxies() {
+	settings := getConfig()
+	proxies := make(map[string]bool)
+	if settings.DownloadProxy != "" {
+		proxies[settings.DownloadProxy] = true
+	}
+	for _, proxy := range settings.DownloadProxyDomains {
+		if proxy != "" && proxy != "direct" {
+			proxies[proxy] = true
+		}
+	}
+	for proxy := range proxies {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			log.Println(logPrefixSettings, color.HiRedString("Invalid proxy \"%s\"", redactProxy(proxy)))
+			continue
+		}
+		host := proxyURL.Host
+		if proxyURL.Port() == "" {
+			switch proxyURL.Scheme {
+			case "https":
+				host = net.JoinHostPort(proxyURL.Hostname(), "443")
+			case "socks5", "socks5h":
+				host = net.JoinHostPort(proxyURL.Hostname(), "1080")
+			default:
+				host = net.JoinHostPort(proxyURL.Hostname(), "80")
+			}
+		}
+		conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+		if err != nil {
+			log.Println(logPrefixSettings, color.HiRedString("Proxy %s is unreachable, downloads using it will fail...\t%s", redactProxy(proxy), err))
+			continue
+		}
+		conn.Close()
+		if settings.DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Proxy %s is reachable", redactProxy(proxy)))
+		}
+	}
+}
+
+//#endregion
+
+//#region Dialing
+
+// bindAddress, dnsServers and hostsOverrides apply to downloads and site handlers, and to Discord only with
+// networkSettingsForDiscord. They're read on every connection, so changes apply without restarting.
+
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+	// DNS over HTTPS requests give up after this long
+	dohTimeout = 10 * time.Second
+)
+
+// Turns bindAddress into the local IP to connect from. It can be an IP, or an interface whose first
+// global address is used.
+func getBindIP(bindAddress string) (net.IP, error) {
+	if ip := net.ParseIP(bindAddress); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(bindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\" isn't an IP address or network interface", bindAddress)
+	}
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the addresses of \"%s\": %s", bindAddress, err)
+	}
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("\"%s\" has no usable address", bindAddress)
+}
+
+// Checks bindAddress can actually be connected from, the bot stops at launch if it can't.
+func checkBindAddress(bindAddress string) error {
+	ip, err := getBindIP(bindAddress)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		return fmt.Errorf("can't bind to %s: %s", ip, err)
+	}
+	return listener.Close()
+}
+
+// Dialer for new connections per the current settings. Resolving goes through dnsServers unless told not to.
+func getNetDialer(customDNS bool) *net.Dialer {
+	settings := getConfig()
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive}
+	if settings.BindAddress != "" {
+		if ip, err := getBindIP(settings.BindAddress); err == nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if customDNS && len(settings.DNSServers) > 0 {
+		dialer.Resolver = getCustomResolver(settings.DNSServers)
+	}
+	return dialer
+}
+
+// Where hostsOverrides points a hostname, only exact matches.
+func getHostsOverride(host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for hostname, ip := range getConfig().HostsOverrides {
+		if strings.EqualFold(hostname, host) {
+			return ip, true
+		}
+	}
+	return "", false
+}
+
+func dialNetwork(ctx context.Context, network string, address string) (net.Conn, error) {
+	return dialWith(ctx, network, address, true)
+}
+
+func dialWith(ctx context.Context, network string, address string, customDNS bool) (net.Conn, error) {
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ip, overridden := getHostsOverride(host); overridden {
+			address = net.JoinHostPort(ip, port)
+		}
+	}
+	return getNetDialer(customDNS).DialContext(ctx, network, address)
+}
+
+var dnsServerNext uint32
+
+// Resolver asking dnsServers in turn, each an IP with an optional port or a DNS over HTTPS URL.
+func getCustomResolver(servers []string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			server := servers[int(atomic.AddUint32(&dnsServerNext, 1))%len(servers)]
+			if strings.HasPrefix(server, "https://") {
+				return &dohConn{ctx: ctx, url: server}, nil
+			}
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			// The resolver's own connections can't go through itself
+			return dialWith(ctx, network, server, false)
+		},
+	}
+}
+
+// DNS over HTTPS dressed up as a connection to a DNS server, each query written is sent as its own request.
+// It isn't a net.PacketConn, so Go talks to it like TCP with messages prefixed by their length.
+type dohConn struct {
+	ctx      context.Context
+	url      string
+	response bytes.Buffer
+}
+
+var dohClient = &http.Client{
+	Timeout: dohTimeout,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return dialWith(ctx, network, address, false)
+		},
+		TLSHandshakeTimeout: dohTimeout,
+	},
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, errors.New("short DNS message")
+	}
+	request, err := http.NewRequestWithContext(c.ctx, "POST", c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	response, err := dohClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s responded %s", c.url, response.Status)
+	}
+	answer, err := ioutil.ReadAll(io.LimitReader(response.Body, 65535))
+	if err != nil {
+		return 0, err
+	}
+	c.response.Reset()
+	c.response.Write([]byte{byte(len(answer) >> 8), byte(len(answer))})
+	c.response.Write(answer)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
+
+// Sends Discord's API requests and gateway connection through the same dialing as downloads.
+func applyNetworkSettingsToDiscord(session *discordgo.Session) {
+	if !getConfig().NetworkSettingsForDiscord {
+		return
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialNetwork
+	session.Client = &http.Client{Timeout: session.Client.Timeout, Transport: transport}
+	websocket.DefaultDialer.NetDialContext = dialNetwork
+}
+
+// Checks the network settings at launch. Anything that would stop connections working stops the bot instead.
+func checkNetworkSettings() {
+	settings := getConfig()
+	if settings.BindAddress != "" {
+		if err := checkBindAddress(settings.BindAddress); err != nil {
+			log.Println(logPrefixSettings, color.HiRedString("bindAddress: %s", err))
+			properExit()
+		}
+		if settings.DebugOutput {
+			ip, _ := getBindIP(settings.BindAddress)
+			log.Println(logPrefixDebug, color.YellowString("Connecting from %s", ip))
+		}
+	}
+}
+
+//#endregion
+
+//#region Bandwidth
+
+var (
+	downloadLimiter      = rate.NewLimiter(rate.Inf, 0)
+	downloadLimiterSpeed string // setting the limiter was last configured with
+	downloadLimiterMutex sync.Mutex
+
+	downloadBytesTotal  int64 // atomic
+	downloadThroughput  int64 // atomic, bytes per second averaged over the last few seconds
+	downloadSamplerOnce sync.Once
+)
+
+// Returns the shared limiter, updating it first if maxDownloadSpeed changed since it was last configured.
+func getDownloadLimiter() *rate.Limiter {
+	settings := getConfig()
+	downloadLimiterMutex.Lock()
+	defer downloadLimiterMutex.Unlock()
+	if settings.MaxDownloadSpeed != downloadLimiterSpeed {
+		downloadLimiterSpeed = settings.MaxDownloadSpeed
+		speed, err := parseByteSize(settings.MaxDownloadSpeed)
+		if settings.MaxDownloadSpeed == "" || err != nil || speed <= 0 {
+			if settings.MaxDownloadSpeed != "" {
+				log.Println(logPrefixSettings, color.HiRedString("Invalid maxDownloadSpeed \"%s\", downloads won't be throttled", settings.MaxDownloadSpeed))
+			}
+			downloadLimiter.SetLimit(rate.Inf)
+			downloadLimiter.SetBurst(0)
+		} else {
+			downloadLimiter.SetLimit(rate.Limit(speed))
+			// Burst caps how much a single read can take at once, keep it to a fraction of a second
+			downloadLimiter.SetBurst(int(math.Max(float64(speed)/4, 4096)))
+		}
+	}
+	return downloadLimiter
+}
+
+func downloadSpeedLimitLabel() string {
+	settings := getConfig()
+	if speed, err := parseByteSize(settings.MaxDownloadSpeed); settings.MaxDownloadSpeed != "" && err == nil && speed > 0 {
+		return formatBytes(speed) + "/s"
+	}
+	return "none"
+}
+
+type throttledReader struct {
+	reader io.Reader
+}
+
+// Wraps a response body so reads are held to maxDownloadSpeed, shared across all downloads.
+func newThrottledReader(reader io.Reader) io.Reader {
+	downloadSamplerOnce.Do(func() {
+		go sampleDownloadThroughput()
+	})
+	return &throttledReader{reader: reader}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	limiter := getDownloadLimiter()
+	if limiter.Limit() != rate.Inf && len(p) > limiter.Burst() {
+		p = p[:limiter.Burst()]
+	}
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&downloadBytesTotal, int64(n))
+		if limiter.Limit() != rate.Inf {
+			if waitErr := limiter.WaitN(context.Background(), n); waitErr != nil && err == nil {
+				err = waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+func sampleDownloadThroughput() {
+	const interval = 5 * time.Second
+	last := atomic.LoadInt64(&downloadBytesTotal)
+	for range time.Tick(interval) {
+		current := atomic.LoadInt64(&downloadBytesTotal)
+		atomic.StoreInt64(&downloadThroughput, int64(float64(current-last)/interval.Seconds()))
+		last = current
+	}
+}
+
+//#endregion
+
+//#region Domain Connections
+
+var (
+	domainConnections      = make(map[string]int)
+	domainConnectionsMutex sync.Mutex
+	domainConnectionsCond  = sync.NewCond(&domainConnectionsMutex)
+)
+
+// Blocks until a connection slot for the domain is free, returning a func to release it.
+// The release func is safe to call more than once.
+func acquireDomainConnection(domain string) func() {
+	domainConnectionsMutex.Lock()
+	for getConfig().MaxDomainConnections > 0 && domainConnections[domain] >= getConfig().MaxDomainConnections {
+		domainConnectionsCond.Wait()
+	}
+	domainConnections[domain]++
+	domainConnectionsMutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			domainConnectionsMutex.Lock()
+			domainConnections[domain]--
+			if domainConnections[domain] <= 0 {
+				delete(domainConnections, domain)
+			}
+			domainConnectionsMutex.Unlock()
+			domainConnectionsCond.Broadcast()
+		})
+	}
+}
+
+// Returns the number of downloads currently connected, across all domains.
+func getActiveDomainConnections() int {
+	domainConnectionsMutex.Lock()
+	defer domainConnectionsMutex.Unlock()
+	total := 0
+	for _, count := range domainConnections {
+		total += count
+	}
+	return total
+}
+
+//#endregion
+
+//#region Content Encoding
+
+// Go undoes gzip itself when it asked for it, and takes the Content-Encoding header off when it does.
+// Some servers compress whether or not they were asked, anything still marked as encoded is undone here.
+func isResponseEncoded(response *http.Response) bool {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	return encoding != "" && encoding != "identity"
+}
+
+// Decodes a body per its Content-Encoding, applied in the order listed so they're undone last first.
+// Partial bodies, e.g. a dry run's first bytes, decode as far as they go.
+func decodeResponseBody(encoding string, body []byte, partial bool) ([]byte, error) {
+	encodings := strings.Split(encoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			reader, err = newDeflateReader(bytes.NewReader(body))
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding \"%s\"", strings.TrimSpace(encodings[i]))
+		}
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := ioutil.ReadAll(reader)
+		if err != nil && !(partial && err == io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// "deflate" is meant to be zlib wrapped, but plenty of servers send it raw.
+func newDeflateReader(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+//#endregion
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func encodeTestBody(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "rawDeflate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(&buffer)
+	default:
+		t.Fatalf("No encoder for %s", encoding)
+	}
+	writer.Write(body)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// Servers that compress images, asked to or not, still give back the image once it's been through the same
+// request, read and decode steps as tryDownload.
+func TestDownloadContentEncoding(t *testing.T) {
+	var original bytes.Buffer
+	picture := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range picture.Pix {
+		picture.Pix[i] = byte(i * 7)
+	}
+	if err := png.Encode(&original, picture); err != nil {
+		t.Fatal(err)
+	}
+	gzipped := encodeTestBody(t, "gzip", original.Bytes())
+
+	bodies := map[string][]byte{
+		"/gzip":       gzipped,
+		"/deflate":    encodeTestBody(t, "deflate", original.Bytes()),
+		"/rawDeflate": encodeTestBody(t, "rawDeflate", original.Bytes()),
+		"/br":         encodeTestBody(t, "br", original.Bytes()),
+		"/stacked":    encodeTestBody(t, "br", gzipped),
+	}
+	headers := map[string]string{
+		"/gzip":       "gzip",
+		"/deflate":    "deflate",
+		"/rawDeflate": "deflate",
+		"/br":         "br",
+		"/stacked":    "gzip, br",
+	}
+	serveTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/plain" {
+			w.Write(original.Bytes())
+			return
+		}
+		// Compressed only if asked, which lets Go's transport undo it
+		if r.URL.Path == "/negotiated" {
+			if r.Header.Get("Accept-Encoding") != "gzip" {
+				w.Write(original.Bytes())
+				return
+			}
+			r.URL.Path = "/gzip"
+		}
+		w.Header().Set("Content-Encoding", headers[r.URL.Path])
+		w.Write(bodies[r.URL.Path])
+	}))
+
+	var channelConfig configurationChannel
+	channelDefault(&channelConfig)
+	for _, path := range []string{"/plain", "/negotiated", "/gzip", "/deflate", "/rawDeflate", "/br", "/stacked"} {
+		t.Run(path[1:], func(t *testing.T) {
+			inputURL := "https://files.example" + path
+			client, _ := getDownloadClient(inputURL, channelConfig)
+			request, _ := http.NewRequest("GET", inputURL, nil)
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatalf("Request failed: %s", err)
+			}
+			defer response.Body.Close()
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Fatalf("Read failed: %s", err)
+			}
+			if isResponseEncoded(response) {
+				if body, err = decodeResponseBody(response.Header.Get("Content-Encoding"), body, false); err != nil {
+					t.Fatalf("Decode failed: %s", err)
+				}
+			}
+			if !bytes.Equal(body, original.Bytes()) {
+				t.Errorf("Got %d bytes that aren't the original %d", len(body), original.Len())
+			}
+			if sniffed := http.DetectContentType(body); sniffed != "image/png" {
+				t.Errorf("Sniffed %s, want image/png", sniffed)
+			}
+		})
+	}
+}
+
+// Dry runs only read the start of the body, which decodes as far as it goes.
+func TestDecodePartialResponseBody(t *testing.T) {
+	original := bytes.Repeat([]byte("partial body "), 1000)
+	encoded := encodeTestBody(t, "gzip", original)
+	decoded, err := decodeResponseBody("gzip", encoded[:len(encoded)/2], true)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if len(decoded) == 0 || !bytes.HasPrefix(original, decoded) {
+		t.Errorf("Decoded %d bytes that aren't the start of the original", len(decoded))
+	}
+	if _, err := decodeResponseBody("gzip", encoded[:len(encoded)/2], false); err == nil {
+		t.Error("A cut off body decoded without an error outside a dry run")
+	}
+	if _, err := decodeResponseBody("compress", encoded, false); err == nil {
+		t.Error("An unsupported encoding decoded without an error")
+	}
+}
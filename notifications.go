@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var logPrefixNotify = color.HiBlueString("[Notifications]")
+
+const (
+	notificationEventSuccess         = "success"
+	notificationEventFailure         = "failure"
+	notificationEventSkipDuplicate   = "skip-duplicate"
+	notificationEventHistoryComplete = "history-complete"
+
+	// Events waiting on a target before new ones are dropped, so a down endpoint can't hold onto memory forever
+	notificationQueueSize = 256
+	// Attempts per payload, waiting longer between each
+	notificationRetries = 3
+	// Individual events kept in a batch payload, the summary still counts all of them
+	notificationBatchMax = 100
+)
+
+var notificationEvents = []string{notificationEventSuccess, notificationEventFailure, notificationEventSkipDuplicate, notificationEventHistoryComplete}
+
+var notificationClient = &http.Client{Timeout: 15 * time.Second}
+
+// Same fields as the database row, plus what happened
+type notificationPayload struct {
+	Event  string `json:"event"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*downloadItem
+	// history-complete only
+	Files    int    `json:"files,omitempty"`
+	Messages int    `json:"messages,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+type notificationBatch struct {
+	Event   string                `json:"event"` // always "batch"
+	Count   int                   `json:"count"`
+	Summary map[string]int        `json:"summary"`
+	Events  []notificationPayload `json:"events"` // up to notificationBatchMax, oldest first
+}
+
+//#region Targets
+
+type notifier struct {
+	target configurationNotification
+	queue  chan notificationPayload
+}
+
+var (
+	notifiers      = make(map[string]*notifier)
+	notifiersMutex sync.Mutex
+)
+
+// Targets get a worker each, shared by every channel using the same one and kept across settings reloads.
+func getNotifier(target configurationNotification) *notifier {
+	key := fmt.Sprintf("%s|%s|%d", target.URL, target.Format, target.BatchSeconds)
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	if n, exists := notifiers[key]; exists {
+		return n
+	}
+	n := &notifier{target: target, queue: make(chan notificationPayload, notificationQueueSize)}
+	notifiers[key] = n
+	go n.run()
+	return n
+}
+
+func (n *notifier) enqueue(payload notificationPayload) {
+	select {
+	case n.queue <- payload:
+	default:
+		log.Println(logPrefixNotify, color.HiRedString("Queue for %s is full, dropping %s notification", n.target.URL, payload.Event))
+	}
+}
+
+func (n *notifier) run() {
+	if n.target.BatchSeconds <= 0 {
+		for payload := range n.queue {
+			n.send(payload)
+		}
+		return
+	}
+
+	// Batched, anything arriving within the interval goes out together
+	var pending []notificationPayload
+	summary := make(map[string]int)
+	ticker := time.NewTicker(time.Duration(n.target.BatchSeconds) * time.Second)
+	for {
+		select {
+		case payload := <-n.queue:
+			summary[payload.Event]++
+			if len(pending) < notificationBatchMax {
+				pending = append(pending, payload)
+			}
+		case <-ticker.C:
+			count := 0
+			for _, c := range summary {
+				count += c
+			}
+			if count == 1 {
+				n.send(pending[0])
+			} else if count > 1 {
+				n.send(notificationBatch{
+					Event:   "batch",
+					Count:   count,
+					Summary: summary,
+					Events:  pending,
+				})
+			}
+			pending = nil
+			summary = make(map[string]int)
+		}
+	}
+}
+
+// Retries failed requests, server errors and rate limits, giving up after notificationRetries attempts.
+func (n *notifier) send(payload interface{}) {
+	var body interface{} = payload
+	if n.target.Format == "discord" {
+		body = formatDiscordNotification(payload)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Println(logPrefixNotify, color.HiRedString("Failed to encode notification for %s:\t%s", n.target.URL, err))
+		return
+	}
+
+	for attempt := 1; attempt <= notificationRetries; attempt++ {
+		wait := time.Duration(attempt*5) * time.Second
+		request, err := http.NewRequest("POST", n.target.URL, bytes.NewReader(data))
+		if err != nil {
+			log.Println(logPrefixNotify, color.HiRedString("Invalid notification target %s:\t%s", n.target.URL, err))
+			return
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", projectName+"/"+projectVersion)
+		response, err := notificationClient.Do(request)
+		if err == nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+			if response.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("%s", response.Status)
+			if response.StatusCode != http.StatusTooManyRequests && response.StatusCode < 500 {
+				log.Println(logPrefixNotify, color.HiRedString("Notification rejected by %s:\t%s", n.target.URL, err))
+				return
+			}
+			if retryAfter, convErr := strconv.ParseFloat(response.Header.Get("Retry-After"), 64); convErr == nil && retryAfter > 0 {
+				wait = time.Duration(retryAfter * float64(time.Second))
+			}
+		}
+		if attempt == notificationRetries {
+			log.Println(logPrefixNotify, color.HiRedString("Gave up sending notification to %s after %d attempts:\t%s", n.target.URL, attempt, err))
+			return
+		}
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, logPrefixNotify, color.YellowString("Failed to notify %s, retrying in %s:\t%s", n.target.URL, wait, err))
+		}
+		time.Sleep(wait)
+	}
+}
+
+func wantsNotification(target configurationNotification, event string) bool {
+	return target.Events == nil || stringInSlice(event, *target.Events)
+}
+
+// Global targets get events from every channel, channel targets only their own.
+func notify(channelID string, payload notificationPayload) {
+	var targets []configurationNotification
+	targets = append(targets, getConfig().Notifications...)
+	if isChannelRegistered(channelID) {
+		channelConfig := getChannelConfig(channelID)
+		if channelConfig.Notifications != nil {
+			targets = append(targets, *channelConfig.Notifications...)
+		}
+	}
+
+	for _, target := range targets {
+		if wantsNotification(target, payload.Event) {
+			getNotifier(target).enqueue(payload)
+		}
+	}
+}
+
+//#endregion
+
+//#region Events
+
+func sendDownloadNotifications(download downloadRequestStruct, status downloadStatusStruct) {
+	var event string
+	switch {
+	case status.Status == downloadSuccess:
+		event = notificationEventSuccess
+	case status.Status >= downloadFailed:
+		event = notificationEventFailure
+	case status.Status == downloadSkippedDetectedDuplicate || status.Status == downloadSkippedDuplicate:
+		event = notificationEventSkipDuplicate
+	default:
+		return
+	}
+
+	record := status.Saved
+	if record == nil {
+		record = &downloadItem{
+			URL:       download.InputURL,
+			Time:      time.Now(),
+			Filename:  download.Filename,
+			ChannelID: download.Message.ChannelID,
+			MessageID: download.Message.ID,
+		}
+		if download.Message.Author != nil {
+			record.UserID = download.Message.Author.ID
+		}
+	}
+	payload := notificationPayload{
+		Event:        event,
+		Status:       getDownloadStatusString(status.Status),
+		downloadItem: record,
+	}
+	if status.Error != nil {
+		payload.Error = status.Error.Error()
+	}
+	notify(download.Message.ChannelID, payload)
+}
+
+func sendHistoryNotification(channelID string, files int, messages int, duration time.Duration) {
+	notify(channelID, notificationPayload{
+		Event:        notificationEventHistoryComplete,
+		Status:       "History Finished",
+		downloadItem: &downloadItem{ChannelID: channelID, Time: time.Now()},
+		Files:        files,
+		Messages:     messages,
+		Duration:     duration.Round(time.Second).String(),
+	})
+}
+
+//#endregion
+
+//#region Discord Webhooks
+
+type discordWebhookPayload struct {
+	Username string                `json:"username"`
+	Embeds   []discordWebhookEmbed `json:"embeds"`
+}
+
+type discordWebhookEmbed struct {
+	Title       string                     `json:"title"`
+	Description string                     `json:"description,omitempty"`
+	Color       int                        `json:"color"`
+	Timestamp   string                     `json:"timestamp,omitempty"`
+	Fields      []discordWebhookEmbedField `json:"fields,omitempty"`
+}
+
+type discordWebhookEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+func notificationColor(event string) int {
+	switch event {
+	case notificationEventSuccess, notificationEventHistoryComplete:
+		return 0x43B581
+	case notificationEventFailure:
+		return 0xF04747
+	}
+	return 0xFAA61A
+}
+
+func formatDiscordNotification(payload interface{}) discordWebhookPayload {
+	webhook := discordWebhookPayload{Username: projectLabel}
+	switch p := payload.(type) {
+	case notificationPayload:
+		embed := discordWebhookEmbed{
+			Title:     p.Status,
+			Color:     notificationColor(p.Event),
+			Timestamp: p.Time.Format(time.RFC3339),
+		}
+		if p.Event == notificationEventHistoryComplete {
+			embed.Description = fmt.Sprintf("Finished history for <#%s>, %s file%s from %s message%s in %s",
+				p.ChannelID, formatNumber(int64(p.Files)), pluralS(p.Files), formatNumber(int64(p.Messages)), pluralS(p.Messages), p.Duration)
+		} else {
+			embed.Description = p.URL
+			if p.Destination != "" {
+				embed.Fields = append(embed.Fields, discordWebhookEmbedField{Name: "Destination", Value: p.Destination})
+			}
+			embed.Fields = append(embed.Fields, discordWebhookEmbedField{Name: "Channel", Value: "<#" + p.ChannelID + ">", Inline: true})
+			if p.UserID != "" {
+				embed.Fields = append(embed.Fields, discordWebhookEmbedField{Name: "User", Value: "<@" + p.UserID + ">", Inline: true})
+			}
+			if p.Error != "" {
+				message := p.Error
+				if len(message) > 1000 {
+					message = message[:1000] + "..."
+				}
+				embed.Fields = append(embed.Fields, discordWebhookEmbedField{Name: "Error", Value: message})
+			}
+		}
+		webhook.Embeds = append(webhook.Embeds, embed)
+	case notificationBatch:
+		var lines []string
+		for _, event := range notificationEvents {
+			if count := p.Summary[event]; count > 0 {
+				lines = append(lines, fmt.Sprintf("**%s** %s", formatNumber(int64(count)), event))
+			}
+		}
+		embedColor := notificationColor(notificationEventSuccess)
+		if p.Summary[notificationEventFailure] > 0 {
+			embedColor = notificationColor(notificationEventFailure)
+		}
+		webhook.Embeds = append(webhook.Embeds, discordWebhookEmbed{
+			Title:       fmt.Sprintf("%s events", formatNumber(int64(p.Count))),
+			Description: strings.Join(lines, "\n"),
+			Color:       embedColor,
+			Timestamp:   time.Now().Format(time.RFC3339),
+		})
+	}
+	return webhook
+}
+
+//#endregion
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// With filters.skipTextHeavyImages, images are read with Tesseract and skipped when the words it finds cover more
+// of them than filters.textHeavyThreshold, for screenshots of text. A few workers run OCR for every download, so
+// screenshots piling up can't hold up anything else, and images that can't be checked in time are saved as usual.
+
+var logPrefixOCR = color.HiBlueString("[OCR]")
+
+const (
+	ocrWorkers = 2
+	// Longest an image may wait for a worker and be read, so a huge or odd image can't hold up a download
+	ocrTimeout = 30 * time.Second
+	// Images with more pixels than this aren't checked
+	ocrMaxPixels = 25000000
+	// Words Tesseract is less sure of than this, out of 100, are taken for noise in photos
+	ocrMinWordConfidence = 60
+)
+
+type imageTextCheck struct {
+	coverage   float64 // of the image's area, by the words' boxes
+	confidence float64 // Tesseract's average for the words counted, out of 1
+	words      int
+}
+
+type ocrJob struct {
+	ctx    context.Context
+	body   []byte
+	result chan ocrResult
+}
+
+type ocrResult struct {
+	check imageTextCheck
+	err   error
+}
+
+var (
+	ocrJobs        = make(chan ocrJob)
+	ocrWorkersOnce sync.Once
+)
+
+func startOCRWorkers() {
+	for i := 0; i < ocrWorkers; i++ {
+		go func() {
+			for job := range ocrJobs {
+				check, err := readImageText(job.ctx, job.body)
+				job.result <- ocrResult{check, err}
+			}
+		}()
+	}
+}
+
+// Waits for a worker to read the image, giving up after ocrTimeout all told.
+func checkImageText(body []byte) (imageTextCheck, error) {
+	if imageConfig, _, err := image.DecodeConfig(bytes.NewReader(body)); err == nil && imageConfig.Width*imageConfig.Height > ocrMaxPixels {
+		return imageTextCheck{}, fmt.Errorf("%dx%d is too big to check", imageConfig.Width, imageConfig.Height)
+	}
+	ocrWorkersOnce.Do(startOCRWorkers)
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+	job := ocrJob{ctx: ctx, body: body, result: make(chan ocrResult, 1)}
+	select {
+	case ocrJobs <- job:
+	case <-ctx.Done():
+		return imageTextCheck{}, fmt.Errorf("no worker was free within %s", ocrTimeout)
+	}
+	select {
+	case result := <-job.result:
+		return result.check, result.err
+	case <-ctx.Done():
+		return imageTextCheck{}, fmt.Errorf("timed out after %s", ocrTimeout)
+	}
+}
+
+// Runs Tesseract for its TSV output, a row for each page, block, paragraph, line and word with their boxes.
+func readImageText(ctx context.Context, body []byte) (imageTextCheck, error) {
+	dir, err := ioutil.TempDir("", "ddg-ocr-")
+	if err != nil {
+		return imageTextCheck{}, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(input, body, 0644); err != nil {
+		return imageTextCheck{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, getConfig().TesseractPath, input, "stdout", "tsv")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return imageTextCheck{}, fmt.Errorf("timed out after %s", ocrTimeout)
+		}
+		return imageTextCheck{}, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTesseractTSV(stdout.Bytes())
+}
+
+func parseTesseractTSV(tsv []byte) (imageTextCheck, error) {
+	var check imageTextCheck
+	var pageArea, wordArea, confidence float64
+	scanner := bufio.NewScanner(bytes.NewReader(tsv))
+	for scanner.Scan() {
+		// level page_num block_num par_num line_num word_num left top width height conf text
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 11 {
+			continue
+		}
+		level, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue // the header
+		}
+		width, _ := strconv.ParseFloat(fields[8], 64)
+		height, _ := strconv.ParseFloat(fields[9], 64)
+		switch level {
+		case 1:
+			pageArea += width * height
+		case 5:
+			wordConfidence, _ := strconv.ParseFloat(fields[10], 64)
+			if len(fields) < 12 || strings.TrimSpace(fields[11]) == "" || wordConfidence < ocrMinWordConfidence {
+				continue
+			}
+			wordArea += width * height
+			confidence += wordConfidence
+			check.words++
+		}
+	}
+	if pageArea == 0 {
+		return check, fmt.Errorf("no page in Tesseract's output")
+	}
+	check.coverage = wordArea / pageArea
+	if check.words > 0 {
+		check.confidence = confidence / float64(check.words) / 100
+	}
+	return check, nil
+}
+
+// Whether the image is mostly text per the channel's filters, and what was found. Images that can't be checked
+// aren't.
+func isTextHeavyImage(channelConfig configurationChannel, body []byte, inputURL string) (bool, *imageTextCheck) {
+	check, err := checkImageText(body)
+	if err != nil {
+		log.Println(logPrefixOCR, color.YellowString("Couldn't check %s for text, saving it as usual:\t%s", inputURL, err))
+		return false, nil
+	}
+	if getConfig().DebugOutput {
+		log.Println(logPrefixDebug, logPrefixOCR, color.CyanString("%d word%s covering %.1f%% of %s (%.0f%% confidence)",
+			check.words, pluralS(check.words), check.coverage*100, inputURL, check.confidence*100))
+	}
+	return check.coverage > *channelConfig.Filters.TextHeavyThreshold, &check
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// History runs page through channels with only as much of the bot's API rate limit as they're given, so live
+// messages elsewhere aren't held up behind them. Each run waits historyRequestDelay between requests for
+// historyBatchSize messages, all runs together make at most apiBudget requests a minute, and none makes one while
+// a live message is being handled. The settings are read as they're used, so reloading changes running histories.
+
+const (
+	historyBatchSizeMax = 100 // Discord's most per request
+	// How often paused history runs check whether live messages are done
+	historyPreemptPoll = 250 * time.Millisecond
+)
+
+var (
+	liveMessagesHandling int64 // atomic
+
+	historyBudget          = rate.NewLimiter(rate.Inf, 1)
+	historyBudgetPerMinute int // apiBudget the bucket was last set for, 0 for unlimited
+	historyBudgetMutex     sync.Mutex
+)
+
+func getHistoryBatchSize() int {
+	settings := getConfig()
+	if settings.HistoryBatchSize <= 0 || settings.HistoryBatchSize > historyBatchSizeMax {
+		return historyBatchSizeMax
+	}
+	return settings.HistoryBatchSize
+}
+
+func getHistoryRequestDelay() time.Duration {
+	delay, err := time.ParseDuration(getConfig().HistoryRequestDelay)
+	if err != nil || delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// The bucket every run takes from, refilled at apiBudget a minute. Holds a single request so the budget can't be
+// spent in a burst.
+func getHistoryBudget() *rate.Limiter {
+	settings := getConfig()
+	historyBudgetMutex.Lock()
+	defer historyBudgetMutex.Unlock()
+	if settings.APIBudget != historyBudgetPerMinute {
+		historyBudgetPerMinute = settings.APIBudget
+		if historyBudgetPerMinute > 0 {
+			historyBudget.SetLimit(rate.Limit(float64(historyBudgetPerMinute) / 60))
+		} else {
+			historyBudget.SetLimit(rate.Inf)
+		}
+	}
+	return historyBudget
+}
+
+// Waits until the run may request more messages: historyRequestDelay after its last request, with room in the
+// budget, and once no live message is being handled.
+func waitHistoryRequest(lastRequest *time.Time) {
+	if !lastRequest.IsZero() {
+		if wait := getHistoryRequestDelay() - time.Since(*lastRequest); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	getHistoryBudget().Wait(context.Background())
+	for atomic.LoadInt64(&liveMessagesHandling) > 0 {
+		time.Sleep(historyPreemptPoll)
+	}
+	*lastRequest = time.Now()
+}
+
+// For the status command.
+func getHistoryPacingLabel() string {
+	settings := getConfig()
+	budget := "no API budget"
+	if settings.APIBudget > 0 {
+		budget = fmt.Sprintf("%s requests a minute across all runs", formatNumber(int64(settings.APIBudget)))
+	}
+	return fmt.Sprintf("%s between requests of %d messages, %s", getHistoryRequestDelay(), getHistoryBatchSize(), budget)
+}
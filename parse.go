@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +17,8 @@ import (
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/Jeffail/gabs"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/fatih/color"
+	"github.com/kennygrant/sanitize"
 	"golang.org/x/net/html"
 	"google.golang.org/api/googleapi"
 )
@@ -53,18 +58,37 @@ func getTwitterStatusUrls(inputURL string, channelID string) (map[string]string,
 		return nil, err
 	}
 
+	return getTweetMediaUrls(tweet, channelID), nil
+}
+
+// Best quality of each photo in a tweet and each video in twitterVideoQuality, plus whatever its links lead to.
+// Videos are named by the tweet's ID, numbered if there's more than one.
+func getTweetMediaUrls(tweet anaconda.Tweet, channelID string) map[string]string {
+	settings := getConfig()
 	links := make(map[string]string)
+	videos := 0
 	for _, tweetMedia := range tweet.ExtendedEntities.Media {
 		if len(tweetMedia.VideoInfo.Variants) > 0 {
-			var lastVideoVariant anaconda.Variant
-			for _, videoVariant := range tweetMedia.VideoInfo.Variants {
-				if videoVariant.Bitrate >= lastVideoVariant.Bitrate {
-					lastVideoVariant = videoVariant
-				}
+			videos++
+		}
+	}
+	video := 0
+	for _, tweetMedia := range tweet.ExtendedEntities.Media {
+		if len(tweetMedia.VideoInfo.Variants) > 0 {
+			video++
+			variant, found := pickTwitterVideoVariant(tweetMedia.VideoInfo.Variants, settings.TwitterVideoQuality)
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Tweet %s video %d, picked %s for twitterVideoQuality \"%s\" from %s",
+					tweet.IdStr, video, formatTwitterVideoVariant(variant), settings.TwitterVideoQuality, formatTwitterVideoVariants(tweetMedia.VideoInfo.Variants)))
+			}
+			if !found {
+				continue
 			}
-			if lastVideoVariant.Url != "" {
-				links[lastVideoVariant.Url] = ""
+			filename := ""
+			if videos > 1 {
+				filename = fmt.Sprintf("%s_%d%s", tweet.IdStr, video, filepathExtension(variant.Url))
 			}
+			links[variant.Url] = filename
 		} else {
 			foundUrls := getDownloadLinks(tweetMedia.Media_url_https, channelID)
 			for foundUrlKey, foundUrlValue := range foundUrls {
@@ -78,8 +102,91 @@ func getTwitterStatusUrls(inputURL string, channelID string) (map[string]string,
 			links[foundUrlKey] = foundUrlValue
 		}
 	}
+	text := tweet.FullText
+	if text == "" {
+		text = tweet.Text
+	}
+	setSitePost(links, sitePost{Artist: tweet.User.ScreenName, PostID: tweet.IdStr, PostTitle: text})
 
-	return links, nil
+	return links
+}
+
+const (
+	twitterVideoQualityHighest = "highest"
+	twitterVideoQualityLowest  = "lowest"
+)
+
+var regexTwitterVideoResolution = regexp.MustCompile(`/(\d+)x(\d+)/`)
+
+// Height from the variant's link, like .../vid/1280x720/..., 0 if it doesn't say.
+func getTwitterVideoHeight(variant anaconda.Variant) int {
+	if match := regexTwitterVideoResolution.FindStringSubmatch(variant.Url); match != nil {
+		height, _ := strconv.Atoi(match[2])
+		return height
+	}
+	return 0
+}
+
+// Parses twitterVideoQuality as a height, like 720 or "720p". Returns false for highest, lowest and anything else.
+func parseTwitterVideoMaxHeight(quality string) (int, bool) {
+	height, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(quality)), "p"))
+	return height, err == nil && height > 0
+}
+
+// The MP4 variant twitterVideoQuality asks for, the m3u8 playlist can't be saved as a file. A height picks the best
+// variant that isn't taller, or the smallest if they all are. False if there's no MP4.
+func pickTwitterVideoVariant(variants []anaconda.Variant, quality string) (anaconda.Variant, bool) {
+	var mp4s []anaconda.Variant
+	for _, variant := range variants {
+		if strings.EqualFold(variant.ContentType, "video/mp4") && variant.Url != "" {
+			mp4s = append(mp4s, variant)
+		}
+	}
+	if len(mp4s) == 0 {
+		return anaconda.Variant{}, false
+	}
+	// Smallest first, by height when the links say and bitrate otherwise
+	sort.SliceStable(mp4s, func(i, j int) bool {
+		if hi, hj := getTwitterVideoHeight(mp4s[i]), getTwitterVideoHeight(mp4s[j]); hi != hj {
+			return hi < hj
+		}
+		return mp4s[i].Bitrate < mp4s[j].Bitrate
+	})
+	if strings.EqualFold(quality, twitterVideoQualityLowest) {
+		return mp4s[0], true
+	}
+	if maxHeight, ok := parseTwitterVideoMaxHeight(quality); ok {
+		picked := mp4s[0]
+		for _, variant := range mp4s {
+			if height := getTwitterVideoHeight(variant); height > 0 && height <= maxHeight {
+				picked = variant
+			}
+		}
+		return picked, true
+	}
+	return mp4s[len(mp4s)-1], true
+}
+
+func formatTwitterVideoVariant(variant anaconda.Variant) string {
+	if variant.Url == "" {
+		return "nothing"
+	}
+	label := variant.ContentType
+	if height := getTwitterVideoHeight(variant); height > 0 {
+		label += fmt.Sprintf(" %dp", height)
+	}
+	if variant.Bitrate > 0 {
+		label += fmt.Sprintf(" %dkbps", variant.Bitrate/1000)
+	}
+	return label
+}
+
+func formatTwitterVideoVariants(variants []anaconda.Variant) string {
+	labels := make([]string, len(variants))
+	for i, variant := range variants {
+		labels[i] = formatTwitterVideoVariant(variant)
+	}
+	return strings.Join(labels, ", ")
 }
 
 //#endregion
@@ -89,10 +196,16 @@ func getTwitterStatusUrls(inputURL string, channelID string) (map[string]string,
 func getInstagramUrls(url string) (map[string]string, error) {
 	username, shortcode := getInstagramInfo(url)
 	filename := fmt.Sprintf("instagram %s - %s", username, shortcode)
+	var post sitePost
+	if username != "unknown" {
+		post = sitePost{Artist: username, PostID: shortcode}
+	}
 	// if instagram video
 	videoUrl := getInstagramVideoUrl(url)
 	if videoUrl != "" {
-		return map[string]string{videoUrl: filename + filepathExtension(videoUrl)}, nil
+		links := map[string]string{videoUrl: filename + filepathExtension(videoUrl)}
+		setSitePost(links, post)
+		return links, nil
 	}
 	// if instagram album
 	albumUrls := getInstagramAlbumUrls(url)
@@ -101,17 +214,20 @@ func getInstagramUrls(url string) (map[string]string, error) {
 		for i, albumUrl := range albumUrls {
 			links[albumUrl] = filename + " " + strconv.Itoa(i+1) + filepathExtension(albumUrl)
 		}
+		setSitePost(links, post)
 		return links, nil
 	}
 	// if instagram picture
 	afterLastSlash := strings.LastIndex(url, "/")
 	mediaUrl := url[:afterLastSlash]
 	mediaUrl += strings.Replace(strings.Replace(url[afterLastSlash:], "?", "&", -1), "/", "/media/?size=l", -1)
-	return map[string]string{mediaUrl: filename + ".jpg"}, nil
+	links := map[string]string{mediaUrl: filename + ".jpg"}
+	setSitePost(links, post)
+	return links, nil
 }
 
 func getInstagramInfo(url string) (string, string) {
-	resp, err := http.Get(url)
+	resp, err := getHTTPClient(url).Get(url)
 
 	if err != nil {
 		return "unknown", "unknown"
@@ -162,7 +278,7 @@ ParseLoop:
 }
 
 func getInstagramVideoUrl(url string) string {
-	resp, err := http.Get(url)
+	resp, err := getHTTPClient(url).Get(url)
 
 	if err != nil {
 		return ""
@@ -198,7 +314,7 @@ func getInstagramVideoUrl(url string) string {
 
 func getInstagramAlbumUrls(url string) []string {
 	var links []string
-	resp, err := http.Get(url)
+	resp, err := getHTTPClient(url).Get(url)
 
 	if err != nil {
 		return links
@@ -276,7 +392,7 @@ type imgurAlbumObject struct {
 	}
 }
 
-func getImgurAlbumUrls(url string) (map[string]string, error) {
+func getImgurAlbumUrls(url string, limit int) (map[string]string, int, error) {
 	url = regexp.MustCompile(`(#[A-Za-z0-9]+)?$`).ReplaceAllString(url, "") // remove anchor
 	afterLastSlash := strings.LastIndex(url, "/")
 	albumId := url[afterLastSlash+1:]
@@ -285,14 +401,20 @@ func getImgurAlbumUrls(url string) (map[string]string, error) {
 	imgurAlbumObject := new(imgurAlbumObject)
 	getJSONwithHeaders("https://api.imgur.com/3/album/"+albumId+"/images", imgurAlbumObject, headers)
 	links := make(map[string]string)
+	skipped := 0
 	for _, v := range imgurAlbumObject.Data {
+		if limit > 0 && len(links) >= limit {
+			skipped++
+			continue
+		}
 		links[v.Link] = ""
 	}
 	if len(links) <= 0 {
-		return getImgurSingleUrls(url)
+		links, err := getImgurSingleUrls(url)
+		return links, 0, err
 	}
-	log.Printf("Found imgur album with %d images (url: %s)\n", len(links), url)
-	return links, nil
+	log.Printf("Found imgur album with %d images (url: %s)\n", len(links)+skipped, url)
+	return links, skipped, nil
 }
 
 //#endregion
@@ -369,6 +491,89 @@ func getGfycatUrls(url string) (map[string]string, error) {
 
 //#region Flickr
 
+// Every request goes through getFlickrAPI, which turns Flickr's "fail" answers into a flickrError.
+
+type flickrStatus struct {
+	Stat    string `json:"stat"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type flickrError flickrStatus
+
+func (e flickrError) Error() string {
+	return fmt.Sprintf("Flickr API error %d: %s", e.Code, e.Message)
+}
+
+const (
+	flickrErrorNotFound         = 1 // also what private photos give
+	flickrErrorPermission       = 2
+	flickrErrorInvalidAPIKey    = 100
+	flickrPerPage               = 500
+	flickrListExtras            = "url_o,url_k,url_h,url_l,url_c,url_z"
+	flickrErrorPrivateOrLimited = "private or restricted"
+)
+
+// Set at startup when Flickr turns the key down, so handlers don't ask with it again.
+var flickrAPIKeyRejected bool
+
+func getFlickrAPI(method string, params url.Values, target interface{}) error {
+	settings := getConfig()
+	if settings.Credentials.FlickrApiKey == "" || flickrAPIKeyRejected {
+		return errors.New("Invalid Flickr API Key Set")
+	}
+	params.Set("method", method)
+	params.Set("api_key", settings.Credentials.FlickrApiKey)
+	params.Set("format", "json")
+	params.Set("nojsoncallback", "1")
+	reqUrl := "https://www.flickr.com/services/rest/?" + params.Encode()
+	response, err := getHTTPClient(reqUrl).Get(reqUrl)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var status flickrStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("Failed to parse json from Flickr:\t%s", err)
+	}
+	if status.Stat != "ok" {
+		return flickrError(status)
+	}
+	return json.Unmarshal(body, target)
+}
+
+func isFlickrErrorCode(err error, codes ...int) bool {
+	if flickrErr, ok := err.(flickrError); ok {
+		for _, code := range codes {
+			if flickrErr.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Asks Flickr to echo back, the only way to tell the key works. Keys it rejects aren't used again.
+func validateFlickrApiKey() error {
+	err := getFlickrAPI("flickr.test.echo", url.Values{}, &struct{}{})
+	if isFlickrErrorCode(err, flickrErrorInvalidAPIKey) {
+		flickrAPIKeyRejected = true
+	}
+	return err
+}
+
+// Title and ID, with the extension of the link.
+func getFlickrFilename(title string, photoId string, link string) string {
+	if title = strings.Trim(sanitize.BaseName(strings.TrimSpace(title)), "-"); title != "" {
+		return title + " " + photoId + filepathExtension(link)
+	}
+	return photoId + filepathExtension(link)
+}
+
 type flickrPhotoSizeObject struct {
 	Label  string `json:"label"`
 	Width  int    `json:"width,int,string"`
@@ -385,98 +590,164 @@ type flickrPhotoObject struct {
 		Candownload int                     `json:"candownload"`
 		Size        []flickrPhotoSizeObject `json:"size"`
 	} `json:"sizes"`
-	Stat string `json:"stat"`
 }
 
-func getFlickrUrlFromPhotoId(photoId string) string {
-	reqUrl := fmt.Sprintf("https://www.flickr.com/services/rest/?format=json&nojsoncallback=1&method=%s&api_key=%s&photo_id=%s",
-		"flickr.photos.getSizes", config.Credentials.FlickrApiKey, photoId)
+type flickrPhotoInfoObject struct {
+	Photo struct {
+		Title struct {
+			Content string `json:"_content"`
+		} `json:"title"`
+	} `json:"photo"`
+}
+
+// The original if the owner allows it, the largest size otherwise.
+func getFlickrUrlFromPhotoId(photoId string) (string, error) {
 	flickrPhoto := new(flickrPhotoObject)
-	getJSON(reqUrl, flickrPhoto)
+	if err := getFlickrAPI("flickr.photos.getSizes", url.Values{"photo_id": {photoId}}, flickrPhoto); err != nil {
+		return "", err
+	}
 	var bestSize flickrPhotoSizeObject
 	for _, size := range flickrPhoto.Sizes.Size {
-		if bestSize.Label == "" {
+		if size.Label == "Original" {
+			return size.Source, nil
+		}
+		if size.Width*size.Height > bestSize.Width*bestSize.Height {
 			bestSize = size
-		} else {
-			if size.Width > bestSize.Width || size.Height > bestSize.Height {
-				bestSize = size
-			}
 		}
 	}
-	return bestSize.Source
+	if bestSize.Source == "" {
+		return "", errors.New("Flickr photo has no sizes")
+	}
+	return bestSize.Source, nil
 }
 
-func getFlickrPhotoUrls(url string) (map[string]string, error) {
-	if config.Credentials.FlickrApiKey == "" {
-		return nil, errors.New("Invalid Flickr API Key Set")
-	}
-	matches := regexUrlFlickrPhoto.FindStringSubmatch(url)
+func getFlickrPhotoUrls(link string) (map[string]string, error) {
+	matches := regexUrlFlickrPhoto.FindStringSubmatch(link)
 	photoId := matches[5]
 	if photoId == "" {
 		return nil, errors.New("Unable to get Photo ID from URL")
 	}
-	return map[string]string{getFlickrUrlFromPhotoId(photoId): ""}, nil
-}
-
-type flickrAlbumObject struct {
-	Photoset struct {
-		ID        string `json:"id"`
-		Primary   string `json:"primary"`
-		Owner     string `json:"owner"`
-		Ownername string `json:"ownername"`
-		Photo     []struct {
-			ID        string `json:"id"`
-			Secret    string `json:"secret"`
-			Server    string `json:"server"`
-			Farm      int    `json:"farm"`
-			Title     string `json:"title"`
-			Isprimary string `json:"isprimary"`
-			Ispublic  int    `json:"ispublic"`
-			Isfriend  int    `json:"isfriend"`
-			Isfamily  int    `json:"isfamily"`
-		} `json:"photo"`
-		Page    int    `json:"page"`
-		PerPage int    `json:"per_page"`
-		Perpage int    `json:"perpage"`
-		Pages   int    `json:"pages"`
-		Total   string `json:"total"`
-		Title   string `json:"title"`
-	} `json:"photoset"`
-	Stat string `json:"stat"`
-}
-
-func getFlickrAlbumUrls(url string) (map[string]string, error) {
-	if config.Credentials.FlickrApiKey == "" {
-		return nil, errors.New("Invalid Flickr API Key Set")
-	}
-	matches := regexUrlFlickrAlbum.FindStringSubmatch(url)
-	if len(matches) < 10 || matches[9] == "" {
-		return nil, errors.New("Unable to find Flickr Album ID in URL")
-	}
-	albumId := matches[9]
-	if albumId == "" {
-		return nil, errors.New("Unable to get Album ID from URL")
+	media, err := getFlickrUrlFromPhotoId(photoId)
+	if isFlickrErrorCode(err, flickrErrorNotFound, flickrErrorPermission) {
+		log.Println(logPrefixFileSkip, color.GreenString("Flickr photo %s is private or restricted, skipping %s", photoId, link))
+		return nil, errors.New(flickrErrorPrivateOrLimited)
+	} else if err != nil {
+		return nil, err
 	}
-	reqUrl := fmt.Sprintf("https://www.flickr.com/services/rest/?format=json&nojsoncallback=1&method=%s&api_key=%s&photoset_id=%s&per_page=500",
-		"flickr.photosets.getPhotos", config.Credentials.FlickrApiKey, albumId)
-	flickrAlbum := new(flickrAlbumObject)
-	getJSON(reqUrl, flickrAlbum)
+	// Named by ID alone if the title can't be had
+	info := new(flickrPhotoInfoObject)
+	getFlickrAPI("flickr.photos.getInfo", url.Values{"photo_id": {photoId}}, info)
+	return map[string]string{media: getFlickrFilename(info.Photo.Title.Content, photoId, media)}, nil
+}
+
+// Photos of an album, gallery or group pool, with their links from the extras so they don't take a request each.
+type flickrPhotoListObject struct {
+	Photo []struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+		URLO  string `json:"url_o"`
+		URLK  string `json:"url_k"`
+		URLH  string `json:"url_h"`
+		URLL  string `json:"url_l"`
+		URLC  string `json:"url_c"`
+		URLZ  string `json:"url_z"`
+	} `json:"photo"`
+	Page  int         `json:"page"`
+	Pages int         `json:"pages"`
+	Total interface{} `json:"total"` // a string from some methods, a number from others
+}
+
+type flickrPhotoListResponse struct {
+	Photoset *flickrPhotoListObject `json:"photoset"` // albums
+	Photos   *flickrPhotoListObject `json:"photos"`   // galleries and group pools
+}
+
+// Goes through the pages until limit, returning how many photos it didn't get to.
+func getFlickrPhotoList(method string, params url.Values, limit int) (map[string]string, int, error) {
 	links := make(map[string]string)
-	for _, photo := range flickrAlbum.Photoset.Photo {
-		links[getFlickrUrlFromPhotoId(photo.ID)] = ""
+	perPage := flickrPerPage
+	if limit > 0 && limit < perPage {
+		perPage = limit
 	}
-	return links, nil
+	params.Set("extras", flickrListExtras)
+	params.Set("per_page", strconv.Itoa(perPage))
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+		response := new(flickrPhotoListResponse)
+		if err := getFlickrAPI(method, params, response); err != nil {
+			return nil, 0, err
+		}
+		list := response.Photoset
+		if list == nil {
+			list = response.Photos
+		}
+		if list == nil {
+			return nil, 0, errors.New("Flickr gave no photos")
+		}
+		for _, photo := range list.Photo {
+			if limit > 0 && len(links) >= limit {
+				break
+			}
+			for _, link := range []string{photo.URLO, photo.URLK, photo.URLH, photo.URLL, photo.URLC, photo.URLZ} {
+				if link != "" {
+					links[link] = getFlickrFilename(photo.Title, photo.ID, link)
+					break
+				}
+			}
+		}
+		if page >= list.Pages || len(list.Photo) == 0 || (limit > 0 && len(links) >= limit) {
+			skipped := 0
+			if total, err := strconv.Atoi(fmt.Sprint(list.Total)); err == nil && limit > 0 && total > len(links) {
+				skipped = total - len(links)
+			}
+			return links, skipped, nil
+		}
+	}
+}
+
+func getFlickrAlbumUrls(link string, limit int) (map[string]string, int, error) {
+	matches := regexUrlFlickrAlbum.FindStringSubmatch(link)
+	if len(matches) < 10 || matches[9] == "" {
+		return nil, 0, errors.New("Unable to find Flickr Album ID in URL")
+	}
+	return getFlickrPhotoList("flickr.photosets.getPhotos", url.Values{"photoset_id": {matches[9]}}, limit)
 }
 
-func getFlickrAlbumShortUrls(url string) (map[string]string, error) {
-	result, err := http.Get(url)
+func getFlickrAlbumShortUrls(link string, limit int) (map[string]string, int, error) {
+	result, err := getHTTPClient(link).Get(link)
 	if err != nil {
-		return nil, errors.New("Error getting long URL from shortened Flickr Album URL: " + err.Error())
+		return nil, 0, errors.New("Error getting long URL from shortened Flickr Album URL: " + err.Error())
 	}
+	result.Body.Close()
 	if regexUrlFlickrAlbum.MatchString(result.Request.URL.String()) {
-		return getFlickrAlbumUrls(result.Request.URL.String())
+		return getFlickrAlbumUrls(result.Request.URL.String(), limit)
+	}
+	return nil, 0, errors.New("Encountered invalid URL while trying to get long URL from short Flickr Album URL")
+}
+
+// Gallery and group URLs use names Flickr has to look up the IDs of.
+func getFlickrGalleryUrls(link string, limit int) (map[string]string, int, error) {
+	lookup := new(struct {
+		Gallery struct {
+			ID string `json:"id"`
+		} `json:"gallery"`
+	})
+	if err := getFlickrAPI("flickr.urls.lookupGallery", url.Values{"url": {link}}, lookup); err != nil {
+		return nil, 0, err
+	}
+	return getFlickrPhotoList("flickr.galleries.getPhotos", url.Values{"gallery_id": {lookup.Gallery.ID}}, limit)
+}
+
+func getFlickrGroupPoolUrls(link string, limit int) (map[string]string, int, error) {
+	lookup := new(struct {
+		Group struct {
+			ID string `json:"id"`
+		} `json:"group"`
+	})
+	if err := getFlickrAPI("flickr.urls.lookupGroup", url.Values{"url": {link}}, lookup); err != nil {
+		return nil, 0, err
 	}
-	return nil, errors.New("Encountered invalid URL while trying to get long URL from short Flickr Album URL")
+	return getFlickrPhotoList("flickr.groups.pools.getPhotos", url.Values{"group_id": {lookup.Group.ID}}, limit)
 }
 
 //#endregion
@@ -492,13 +763,13 @@ func getGoogleDriveUrls(url string) (map[string]string, error) {
 	return map[string]string{"https://drive.google.com/uc?export=download&id=" + fileId: ""}, nil
 }
 
-func getGoogleDriveFolderUrls(url string) (map[string]string, error) {
+func getGoogleDriveFolderUrls(url string, limit int) (map[string]string, int, error) {
 	matches := regexUrlGoogleDriveFolder.FindStringSubmatch(url)
 	if len(matches) < 4 || matches[3] == "" {
-		return nil, errors.New("unable to find google drive folder ID in link")
+		return nil, 0, errors.New("unable to find google drive folder ID in link")
 	}
 	if googleDriveService.BasePath == "" {
-		return nil, errors.New("please set up google credentials")
+		return nil, 0, errors.New("please set up google credentials")
 	}
 	googleDriveFolderID := matches[3]
 
@@ -506,31 +777,34 @@ func getGoogleDriveFolderUrls(url string) (map[string]string, error) {
 
 	driveQuery := fmt.Sprintf("\"%s\" in parents", googleDriveFolderID)
 	driveFields := "nextPageToken, files(id)"
-	result, err := googleDriveService.Files.List().Q(driveQuery).Fields(googleapi.Field(driveFields)).PageSize(1000).Do()
-	if err != nil {
-		log.Println("driveQuery:", driveQuery)
-		log.Println("driveFields:", driveFields)
-		log.Println("err:", err)
-		return nil, err
-	}
-	for _, file := range result.Files {
-		fileUrl := "https://drive.google.com/uc?export=download&id=" + file.Id
-		links[fileUrl] = ""
-	}
-
+	pageToken := ""
 	for {
-		if result.NextPageToken == "" {
-			break
+		// Pages stop at the limit, so nothing past it is ever listed
+		pageSize := int64(1000)
+		if limit > 0 && int64(limit-len(links)) < pageSize {
+			pageSize = int64(limit - len(links))
 		}
-		result, err = googleDriveService.Files.List().Q(driveQuery).Fields(googleapi.Field(driveFields)).PageSize(1000).PageToken(result.NextPageToken).Do()
+		result, err := googleDriveService.Files.List().Q(driveQuery).Fields(googleapi.Field(driveFields)).PageSize(pageSize).PageToken(pageToken).Do()
 		if err != nil {
-			return nil, err
+			log.Println("driveQuery:", driveQuery)
+			log.Println("driveFields:", driveFields)
+			log.Println("err:", err)
+			return nil, 0, err
 		}
 		for _, file := range result.Files {
-			links[file.Id] = ""
+			fileUrl := "https://drive.google.com/uc?export=download&id=" + file.Id
+			links[fileUrl] = ""
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		// Folders don't say how big they are, so how much is left out isn't known
+		if limit > 0 && len(links) >= limit {
+			return links, -1, nil
 		}
+		pageToken = result.NextPageToken
 	}
-	return links, nil
+	return links, 0, nil
 }
 
 //#endregion
@@ -565,7 +839,7 @@ func getTistoryWithCDNUrls(urlI string) (map[string]string, error) {
 }
 
 func getPossibleTistorySiteUrls(url string) (map[string]string, error) {
-	client := new(http.Client)
+	client := getHTTPClient(url)
 	request, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return nil, err
@@ -648,24 +922,67 @@ type redditThreadObject []struct {
 	} `json:"data"`
 }
 
-func getRedditPostUrls(link string) (map[string]string, error) {
+func getRedditPostUrls(link string, limit int) (map[string]string, int, error) {
 	redditThread := new(redditThreadObject)
 	headers := make(map[string]string)
 	headers["Accept-Encoding"] = "identity"
 	headers["User-Agent"] = sneakyUserAgent
 	err := getJSONwithHeaders(link+".json", redditThread, headers)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to parse json from reddit post:\t%s", err)
+		return nil, 0, fmt.Errorf("Failed to parse json from reddit post:\t%s", err)
 	}
 
 	redditPost := (*redditThread)[0].Data.Children.([]interface{})[0].(map[string]interface{})
 	redditPostData := redditPost["data"].(map[string]interface{})
+	filenamePrefix := fmt.Sprintf("Reddit-%s_%s ", redditPostData["subreddit"].(string), redditPostData["id"].(string))
+	author, _ := redditPostData["author"].(string)
+	title, _ := redditPostData["title"].(string)
+	post := sitePost{Artist: author, PostID: redditPostData["id"].(string), PostTitle: title}
+	if gallery := getRedditGalleryUrls(redditPostData); len(gallery) > 0 {
+		links := make(map[string]string)
+		skipped := 0
+		for _, redditLink := range gallery {
+			if limit > 0 && len(links) >= limit {
+				skipped++
+				continue
+			}
+			links[redditLink] = filenamePrefix + filenameFromURL(redditLink)
+		}
+		setSitePost(links, post)
+		return links, skipped, nil
+	}
 	if redditPostData["url_overridden_by_dest"] != nil {
 		redditLink := redditPostData["url_overridden_by_dest"].(string)
-		filename := fmt.Sprintf("Reddit-%s_%s %s", redditPostData["subreddit"].(string), redditPostData["id"].(string), filenameFromURL(redditLink))
-		return map[string]string{redditLink: filename}, nil
+		links := map[string]string{redditLink: filenamePrefix + filenameFromURL(redditLink)}
+		setSitePost(links, post)
+		return links, 0, nil
 	}
-	return nil, nil
+	return nil, 0, nil
+}
+
+// Images of a gallery post in order, gallery_data has the order and media_metadata the type of each.
+func getRedditGalleryUrls(redditPostData map[string]interface{}) []string {
+	galleryData, _ := redditPostData["gallery_data"].(map[string]interface{})
+	mediaMetadata, _ := redditPostData["media_metadata"].(map[string]interface{})
+	if galleryData == nil || mediaMetadata == nil {
+		return nil
+	}
+	items, _ := galleryData["items"].([]interface{})
+	var links []string
+	for _, item := range items {
+		galleryItem, _ := item.(map[string]interface{})
+		mediaID, _ := galleryItem["media_id"].(string)
+		media, _ := mediaMetadata[mediaID].(map[string]interface{})
+		if mediaID == "" || media == nil {
+			continue
+		}
+		mimeType, _ := media["m"].(string)
+		if !strings.Contains(mimeType, "/") {
+			continue
+		}
+		links = append(links, "https://i.redd.it/"+mediaID+"."+mimeType[strings.Index(mimeType, "/")+1:])
+	}
+	return links
 }
 
 //#endregion
@@ -690,6 +1007,10 @@ func getMastodonPostUrls(link string) (map[string]string, error) {
 			attachment := attachmentObj.(map[string]interface{})
 			files[attachment["url"].(string)] = ""
 		}
+		// Both are links, the account's ends in its name and the post's in its ID
+		account, _ := post["attributedTo"].(string)
+		postID, _ := post["id"].(string)
+		setSitePost(files, sitePost{Artist: account[strings.LastIndex(account, "/")+1:], PostID: postID[strings.LastIndex(postID, "/")+1:]})
 		return files, nil
 	}
 
@@ -697,3 +1018,119 @@ func getMastodonPostUrls(link string) (map[string]string, error) {
 }
 
 //#endregion
+
+//#region Tenor & Giphy
+
+// Formats gifFormat can be, both sites have everything as either.
+const (
+	gifFormatGIF = "gif"
+	gifFormatMP4 = "mp4"
+)
+
+var gifFormats = []string{gifFormatGIF, gifFormatMP4}
+
+func getGifFormat(channelID string) string {
+	if isChannelRegistered(channelID) {
+		return *getChannelConfig(channelID).GifFormat
+	}
+	return ccdGifFormat
+}
+
+// The first link in format, otherwise the first in any format gifFormat can be, "" if there's neither.
+func pickGifFormat(links []string, format string) string {
+	for _, want := range append([]string{format}, gifFormats...) {
+		for _, link := range links {
+			if strings.EqualFold(filepathExtension(link), "."+want) {
+				return link
+			}
+		}
+	}
+	return ""
+}
+
+// Every string under key in decoded JSON, however deep.
+func findJSONStrings(data interface{}, key string) []string {
+	var found []string
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if s, ok := value.(string); ok && k == key {
+				found = append(found, s)
+			} else {
+				found = append(found, findJSONStrings(value, key)...)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = append(found, findJSONStrings(value, key)...)
+		}
+	}
+	return found
+}
+
+// Tenor pages describe the media in JSON-LD, the gif and mp4 each have a contentUrl.
+func getTenorUrls(link string, channelID string) (map[string]string, error) {
+	request, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("User-Agent", sneakyUserAgent)
+	response, err := getHTTPClient(link).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("Tenor answered %s", response.Status)
+	}
+	doc, err := goquery.NewDocumentFromResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		var data interface{}
+		if json.Unmarshal([]byte(s.Text()), &data) == nil {
+			found = append(found, findJSONStrings(data, "contentUrl")...)
+		}
+	})
+	sort.Strings(found)
+	media := pickGifFormat(found, getGifFormat(channelID))
+	if media == "" {
+		return nil, errors.New("No gif or mp4 on the Tenor page")
+	}
+	return map[string]string{media: ""}, nil
+}
+
+type giphyObject struct {
+	Data struct {
+		Images struct {
+			Original struct {
+				URL string `json:"url"`
+				Mp4 string `json:"mp4"`
+			} `json:"original"`
+		} `json:"images"`
+	} `json:"data"`
+}
+
+// Giphy's CDN has every gif at the same path, the API is only asked with giphyApiKey.
+func getGiphyUrls(link string, channelID string) (map[string]string, error) {
+	settings := getConfig()
+	id := regexUrlGiphy.FindStringSubmatch(link)[6]
+	format := getGifFormat(channelID)
+	media := fmt.Sprintf("https://media.giphy.com/media/%s/giphy.%s", id, format)
+	if settings.Credentials.GiphyApiKey != "" {
+		giphy := new(giphyObject)
+		err := getJSON(fmt.Sprintf("https://api.giphy.com/v1/gifs/%s?api_key=%s", id, url.QueryEscape(settings.Credentials.GiphyApiKey)), giphy)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse json from Giphy:\t%s", err)
+		}
+		if picked := pickGifFormat([]string{giphy.Data.Images.Original.URL, giphy.Data.Images.Original.Mp4}, format); picked != "" {
+			media = picked
+		}
+	}
+	// Every file is named giphy, so it's given the ID instead
+	return map[string]string{media: fmt.Sprintf("Giphy-%s%s", id, filepathExtension(media))}, nil
+}
+
+//#endregion
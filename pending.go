@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Links found in messages are journaled before downloading starts and removed as each finishes,
+// so a restart mid-burst or mid-history picks up where it left off instead of losing them.
+
+const pendingDownloadMaxAgeDefault = 24 * time.Hour
+
+var logPrefixPending = color.HiCyanString("[Pending]")
+
+// Counted once at launch, for the status command
+var (
+	pendingRecovered int64 // atomic
+	pendingDropped   int64 // atomic
+)
+
+// Returns the journal entry's ID, or -1 when the download isn't worth recovering.
+func journalPendingDownload(download downloadRequestStruct) int {
+	if download.DryRun || download.ManualDownload || download.EmojiCmd || download.Message == nil {
+		return -1
+	}
+	id, err := dbInsertPendingDownload(download, false)
+	if err != nil {
+		log.Println(logPrefixPending, color.HiRedString("Failed to journal %s:\t%s", download.InputURL, err))
+		return -1
+	}
+	return id
+}
+
+func completePendingDownload(id int) {
+	if id < 0 {
+		return
+	}
+	if err := dbDeletePendingDownload(id); err != nil {
+		log.Println(logPrefixPending, color.HiRedString("Failed to clear journaled download %d:\t%s", id, err))
+	}
+}
+
+func getPendingDownloadMaxAge() time.Duration {
+	settings := getConfig()
+	if settings.PendingDownloadMaxAge != "" {
+		if maxAge, err := time.ParseDuration(settings.PendingDownloadMaxAge); err == nil {
+			return maxAge
+		}
+	}
+	return pendingDownloadMaxAgeDefault
+}
+
+// Downloads whatever was still journaled when the bot last stopped, run once at launch. Downloads deferred by the
+// schedule are left for its window.
+func recoverPendingDownloads() {
+	pending := dbGetPendingDownloads()
+	for id, item := range pending {
+		if item.deferred {
+			delete(pending, id)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	log.Println(logPrefixPending, color.CyanString("Found %d download%s left over from the last run...", len(pending), pluralS(len(pending))))
+	maxAge := getPendingDownloadMaxAge()
+	for id, item := range pending {
+		drop := ""
+		if maxAge > 0 && time.Since(item.queued) > maxAge {
+			drop = "queued too long ago"
+		} else {
+			drop = preparePendingDownload(&item)
+		}
+		if drop != "" {
+			log.Println(logPrefixPending, color.YellowString("Dropped %s from message %s, %s", item.request.InputURL, item.messageID, drop))
+			atomic.AddInt64(&pendingDropped, 1)
+			completePendingDownload(id)
+			continue
+		}
+		status := startDownload(item.request)
+		if status.Status == downloadSuccess {
+			log.Println(logPrefixPending, color.HiGreenString("Recovered %s from message %s", item.request.InputURL, item.messageID))
+		}
+		atomic.AddInt64(&pendingRecovered, 1)
+		completePendingDownload(id)
+	}
+	log.Println(logPrefixPending, color.HiCyanString("Finished recovering downloads, %d retried and %d dropped",
+		atomic.LoadInt64(&pendingRecovered), atomic.LoadInt64(&pendingDropped)))
+}
+
+// Fetches the message a journaled download came from again, returns why it can't be downloaded if it can't.
+func preparePendingDownload(item *pendingDownload) string {
+	if !isChannelRegistered(item.channelID) {
+		return "channel is no longer registered"
+	}
+	message, err := bot.ChannelMessage(item.channelID, item.messageID)
+	if err != nil || message == nil {
+		return "message couldn't be fetched"
+	}
+	if message.GuildID == "" {
+		message.GuildID = getChannelGuildID(item.channelID)
+	}
+	item.request.Message = message
+	return ""
+}
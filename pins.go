@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+)
+
+var logPrefixPins = color.HiCyanString("[Pins]")
+
+// Runs the usual pipeline over pinned messages not processed before, returns how many were new and the files saved.
+// Pins are only ever added to what's recorded, unpinning changes nothing.
+func downloadPins(channelID string, onlyNew bool, options historyOptions) (int, int64, error) {
+	pinned, err := bot.ChannelMessagesPinned(channelID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var processed []string
+	if onlyNew {
+		processed = dbPinnedMessageIDs(channelID)
+	}
+	messages := 0
+	var downloads int64
+	for _, message := range pinned {
+		if stringInSlice(message.ID, processed) {
+			continue
+		}
+		messages++
+		// Ran like history, replies and deleting after download are for new messages only
+		if count := handleMessage(message, false, &options); count > 0 {
+			downloads += count
+		}
+		if options.dryRun == nil {
+			if err := dbAddPinnedMessage(channelID, message.ID); err != nil {
+				log.Println(logPrefixPins, color.HiRedString("Failed to record pinned message %s:\t%s", message.ID, err))
+			}
+		}
+	}
+	return messages, downloads, nil
+}
+
+// For the "history pins" command.
+func handlePinsHistory(commandingMessage *discordgo.Message, channelID string, options historyOptions) int {
+	started := time.Now()
+	log.Println(logPrefixPins, color.CyanString("Began checking pins for %s...", channelID))
+	messages, downloads, err := downloadPins(channelID, false, options)
+	content := fmt.Sprintf("``%s:`` **%s total files downloaded!**\n``%s pinned messages processed``\n\n`Server:` **%s**\n`Channel:` _#%s_\n\n**FINISHED!**",
+		durafmt.ParseShort(time.Since(started)).String(),
+		formatNumber(downloads), formatNumber(int64(messages)),
+		getGuildName(getChannelGuildID(channelID)), getChannelName(channelID),
+	)
+	if err != nil {
+		content = fmt.Sprintf("Encountered an error requesting pins for %s: %s", channelID, err)
+		log.Println(logPrefixPins, color.HiRedString("Error requesting pins for %s:\t%s", channelID, err))
+	} else {
+		log.Println(logPrefixPins, color.HiCyanString("Finished pins for %s, %s files", channelID, formatNumber(downloads)))
+	}
+	if commandingMessage != nil && options.dryRun == nil {
+		if _, err := replyEmbed(commandingMessage, "Command — History", content); err != nil {
+			log.Println(logPrefixPins, color.HiRedString("Failed to send command embed message:\t%s", err))
+		}
+	}
+	return int(downloads)
+}
+
+func channelPinsUpdate(s *discordgo.Session, p *discordgo.ChannelPinsUpdate) {
+	if !isChannelRegistered(p.ChannelID) {
+		return
+	}
+	channelConfig := getChannelConfig(p.ChannelID)
+	if channelConfig.AutoDownloadPins == nil || !*channelConfig.AutoDownloadPins || !*channelConfig.Enabled {
+		return
+	}
+	messages, downloads, err := downloadPins(p.ChannelID, true, historyOptions{})
+	if err != nil {
+		log.Println(logPrefixPins, color.HiRedString("Error requesting pins for %s:\t%s", p.ChannelID, err))
+	} else if messages > 0 {
+		log.Println(logPrefixPins, color.HiCyanString("%d new pinned message%s in #%s, %s files", messages, pluralS(messages), getChannelName(p.ChannelID), formatNumber(downloads)))
+	}
+}
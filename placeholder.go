@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Sites serve these with a 200 in place of media that's gone. Hashes are 64-bit difference hashes,
+// learned ones are added by the markPlaceholder command and kept apart from the settings file.
+
+const (
+	placeholderLearnedPath = cachePath + string(os.PathSeparator) + "placeholders.txt"
+	// Most bits a hash can differ by and still count as the same placeholder
+	placeholderMaxDistance = 4
+)
+
+// Where known placeholders end up after redirects, e.g. Imgur sends removed images to removed.png
+var placeholderURLs = []string{
+	"://i.imgur.com/removed.png",
+	"://imgur.com/removed.png",
+}
+
+type placeholderImage struct {
+	hash uint64
+	size int64 // bytes, only checked if set
+}
+
+var (
+	placeholdersLearned      []placeholderImage
+	placeholdersLearnedMutex sync.Mutex
+	placeholdersLearnedOnce  sync.Once
+)
+
+// Entries are the hash in hex, optionally followed by ":" and the exact size in bytes.
+func parsePlaceholder(entry string) (placeholderImage, error) {
+	entry = strings.TrimSpace(entry)
+	var placeholder placeholderImage
+	hash, size := entry, ""
+	if i := strings.Index(entry, ":"); i >= 0 {
+		hash, size = entry[:i], entry[i+1:]
+	}
+	parsed, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil || len(hash) != 16 {
+		return placeholder, fmt.Errorf("\"%s\" isn't a 16 digit hex hash", hash)
+	}
+	placeholder.hash = parsed
+	if size != "" {
+		if placeholder.size, err = strconv.ParseInt(size, 10, 64); err != nil || placeholder.size <= 0 {
+			return placeholder, fmt.Errorf("\"%s\" isn't a size in bytes", size)
+		}
+	}
+	return placeholder, nil
+}
+
+func formatPlaceholder(placeholder placeholderImage) string {
+	entry := fmt.Sprintf("%016x", placeholder.hash)
+	if placeholder.size > 0 {
+		entry += ":" + strconv.FormatInt(placeholder.size, 10)
+	}
+	return entry
+}
+
+func loadLearnedPlaceholders() {
+	placeholdersLearnedOnce.Do(func() {
+		file, err := os.Open(placeholderLearnedPath)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				if placeholder, err := parsePlaceholder(line); err == nil {
+					placeholdersLearned = append(placeholdersLearned, placeholder)
+				}
+			}
+		}
+	})
+}
+
+// Learned placeholders and the placeholderHashes setting, already validated.
+func getPlaceholders() []placeholderImage {
+	loadLearnedPlaceholders()
+	placeholdersLearnedMutex.Lock()
+	placeholders := append([]placeholderImage{}, placeholdersLearned...)
+	placeholdersLearnedMutex.Unlock()
+	for _, entry := range getConfig().PlaceholderHashes {
+		if placeholder, err := parsePlaceholder(entry); err == nil {
+			placeholders = append(placeholders, placeholder)
+		}
+	}
+	return placeholders
+}
+
+// Adds a placeholder to the learned list, returns false if it was already known.
+func learnPlaceholder(placeholder placeholderImage) (bool, error) {
+	for _, known := range getPlaceholders() {
+		if known.hash == placeholder.hash && known.size == placeholder.size {
+			return false, nil
+		}
+	}
+	placeholdersLearnedMutex.Lock()
+	defer placeholdersLearnedMutex.Unlock()
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(placeholderLearnedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(formatPlaceholder(placeholder) + "\n"); err != nil {
+		return false, err
+	}
+	placeholdersLearned = append(placeholdersLearned, placeholder)
+	return true, nil
+}
+
+// Difference hash: the image shrunk to 9x8 in grayscale, one bit per pixel for whether it's brighter than its right neighbour.
+func hashPlaceholder(body []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0, fmt.Errorf("image is empty")
+	}
+	var gray [8][9]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			// Average of the block of pixels this cell covers
+			x0, x1 := bounds.Min.X+x*bounds.Dx()/9, bounds.Min.X+(x+1)*bounds.Dx()/9
+			y0, y1 := bounds.Min.Y+y*bounds.Dy()/8, bounds.Min.Y+(y+1)*bounds.Dy()/8
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			if y1 <= y0 {
+				y1 = y0 + 1
+			}
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			gray[y][x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// Reads an example placeholder for the markPlaceholder command, from a URL or a local file.
+func readPlaceholderSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	response, err := getHTTPClient(source).Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", source, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// Checks where the file came from, then its hash and size. Returns why it's a placeholder, or "".
+func detectPlaceholder(finalURL string, body []byte, isImage bool) string {
+	for _, suffix := range placeholderURLs {
+		if strings.HasSuffix(strings.ToLower(finalURL), suffix) {
+			return "redirected to " + finalURL
+		}
+	}
+	if !isImage {
+		return ""
+	}
+	placeholders := getPlaceholders()
+	if len(placeholders) == 0 {
+		return ""
+	}
+	hash, err := hashPlaceholder(body)
+	if err != nil {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Couldn't hash image to check for placeholders:\t%s", err))
+		}
+		return ""
+	}
+	for _, placeholder := range placeholders {
+		if placeholder.size > 0 && placeholder.size != int64(len(body)) {
+			continue
+		}
+		if bits.OnesCount64(hash^placeholder.hash) <= placeholderMaxDistance {
+			return "matches placeholder " + formatPlaceholder(placeholder)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hako/durafmt"
+)
+
+const (
+	// Discord only takes a handful of presence updates per minute, anything faster gets dropped
+	presenceMinInterval = 15 * time.Second
+)
+
+var presenceActivityTypes = map[string]discordgo.GameType{
+	"playing":   discordgo.GameTypeGame,
+	"listening": discordgo.GameTypeListening,
+	"watching":  discordgo.GameTypeWatching,
+}
+
+var presenceStatuses = []string{
+	string(discordgo.StatusOnline),
+	string(discordgo.StatusIdle),
+	string(discordgo.StatusDoNotDisturb),
+	string(discordgo.StatusInvisible),
+}
+
+//#region Counters
+
+// Kept up to date as files are saved so presence updates never have to go through the database.
+var presenceStats struct {
+	sync.Mutex
+	downloads    int64
+	bytes        int64
+	lastFilename string
+	lastChannel  string
+}
+
+// Downloads started and not yet finished, retries included.
+var downloadsInProgress int64
+
+func recordPresenceDownload(channelID string, filename string, size int64) {
+	channelName := getChannelName(channelID)
+	presenceStats.Lock()
+	defer presenceStats.Unlock()
+	presenceStats.downloads++
+	presenceStats.bytes += size
+	presenceStats.lastFilename = filename
+	presenceStats.lastChannel = channelName
+}
+
+func presenceDownloadCount() int64 {
+	presenceStats.Lock()
+	defer presenceStats.Unlock()
+	return presenceStats.downloads
+}
+
+//#endregion
+
+//#region History Progress
+
+type presenceHistoryProgress struct {
+	channelName     string
+	files, messages int64
+	started         time.Time
+	updated         time.Time
+}
+
+var (
+	presenceHistory      = make(map[string]*presenceHistoryProgress)
+	presenceHistoryMutex sync.Mutex
+)
+
+// Shows a running history's progress instead of the rotation, the most recently updated one if there are several.
+func setPresenceHistory(channelID string, files int64, messages int64, started time.Time) {
+	presenceHistoryMutex.Lock()
+	progress, exists := presenceHistory[channelID]
+	if !exists {
+		progress = &presenceHistoryProgress{channelName: getChannelName(channelID), started: started}
+		presenceHistory[channelID] = progress
+	}
+	progress.files, progress.messages = files, messages
+	progress.updated = time.Now()
+	presenceHistoryMutex.Unlock()
+}
+
+func clearPresenceHistory(channelID string) {
+	presenceHistoryMutex.Lock()
+	defer presenceHistoryMutex.Unlock()
+	delete(presenceHistory, channelID)
+}
+
+func currentPresenceHistory() *presenceHistoryProgress {
+	presenceHistoryMutex.Lock()
+	defer presenceHistoryMutex.Unlock()
+	var latest *presenceHistoryProgress
+	for _, progress := range presenceHistory {
+		if latest == nil || progress.updated.After(latest.updated) {
+			latest = progress
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	copied := *latest
+	return &copied
+}
+
+// Every running history, longest running first.
+func getPresenceHistories() []presenceHistoryProgress {
+	presenceHistoryMutex.Lock()
+	defer presenceHistoryMutex.Unlock()
+	histories := make([]presenceHistoryProgress, 0, len(presenceHistory))
+	for _, progress := range presenceHistory {
+		histories = append(histories, *progress)
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].started.Before(histories[j].started)
+	})
+	return histories
+}
+
+//#endregion
+
+//#region Rotation
+
+var (
+	presenceRotation     int64
+	presenceRotationOnce sync.Once
+)
+
+// Moves to the next template every interval, also keeps the presence from going blank after reconnects.
+func startPresenceRotation() {
+	presenceRotationOnce.Do(func() {
+		go func() {
+			for {
+				interval := presenceMinInterval
+				if presence := getConfig().Presence; presence != nil {
+					if parsed, err := time.ParseDuration(presence.Interval); err == nil && parsed > interval {
+						interval = parsed
+					}
+				}
+				time.Sleep(interval)
+
+				atomic.AddInt64(&presenceRotation, 1)
+				if getConfig().Presence != nil {
+					updateDiscordPresence()
+				}
+			}
+		}()
+	})
+}
+
+// Text for the rotation's current template, or empty if there's nothing to rotate.
+func presenceRotationText() string {
+	settings := getConfig()
+	if settings.Presence == nil {
+		return ""
+	}
+	if progress := currentPresenceHistory(); progress != nil && settings.Presence.History != nil && *settings.Presence.History != "" {
+		return presenceTemplateReplacement(*settings.Presence.History, progress)
+	}
+	if len(settings.Presence.Templates) == 0 {
+		return ""
+	}
+	template := settings.Presence.Templates[atomic.LoadInt64(&presenceRotation)%int64(len(settings.Presence.Templates))]
+	return presenceTemplateReplacement(template, nil)
+}
+
+// Activity type and status from the presence block, falling back to presenceType and presenceStatus.
+func presenceActivity() (discordgo.GameType, string) {
+	settings := getConfig()
+	activityType, status := settings.PresenceType, settings.PresenceStatus
+	if settings.Presence != nil {
+		if settings.Presence.Type != "" {
+			activityType = presenceActivityTypes[settings.Presence.Type]
+		}
+		if settings.Presence.Status != "" {
+			status = settings.Presence.Status
+		}
+	}
+	return activityType, status
+}
+
+// Single brace tokens from the counters, after the {{placeholders}} shared with presenceOverwrite.
+func presenceTemplateReplacement(input string, progress *presenceHistoryProgress) string {
+	input = presenceKeyReplacement(input)
+	if !strings.Contains(input, "{") {
+		return input
+	}
+	presenceStats.Lock()
+	keys := [][]string{
+		{"{downloadCount}", formatNumber(presenceStats.downloads + *getConfig().InflateCount)},
+		{"{totalSizeSaved}", formatBytes(presenceStats.bytes)},
+		{"{lastFilename}", presenceStats.lastFilename},
+		{"{lastChannelName}", presenceStats.lastChannel},
+	}
+	presenceStats.Unlock()
+	keys = append(keys,
+		[]string{"{queueLength}", formatNumber(atomic.LoadInt64(&downloadsInProgress))},
+		[]string{"{uptime}", durafmt.ParseShort(time.Since(startTime)).String()},
+	)
+	if progress != nil {
+		keys = append(keys,
+			[]string{"{historyChannelName}", progress.channelName},
+			[]string{"{historyFiles}", formatNumber(progress.files)},
+			[]string{"{historyMessages}", formatNumber(progress.messages)},
+			[]string{"{historyDuration}", durafmt.ParseShort(time.Since(progress.started)).String()},
+		)
+	}
+	for _, key := range keys {
+		input = strings.ReplaceAll(input, key[0], key[1])
+	}
+	return input
+}
+
+//#endregion
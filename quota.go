@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Usage is added up from the database the first time it's needed in a period, then kept up to date
+// as downloads are recorded.
+
+var quotaPeriods = []string{"day", "week", "month", "total"}
+
+// Users listed under the user quota in the stats command
+const quotaStatsTopUsers = 5
+
+type quotaUsage struct {
+	start time.Time // period the bytes were counted from
+	bytes int64
+}
+
+type quota struct {
+	scope string // "channel" or "user"
+	key   string
+	start time.Time
+	used  int64
+	limit int64
+}
+
+var (
+	quotaUsages   = make(map[string]*quotaUsage)
+	quotaNotified = make(map[string]time.Time) // quota key to the period the user was last told about
+	quotaMutex    sync.Mutex
+)
+
+// Start of the current period in the channel's quotaTimezone, weeks start on Monday.
+func getQuotaPeriodStart(channelConfig configurationChannel, now time.Time) time.Time {
+	if channelConfig.QuotaPeriod == nil || *channelConfig.QuotaPeriod == "total" {
+		return time.Time{}
+	}
+	location := time.Local
+	if channelConfig.QuotaTimezone != nil {
+		if loaded, err := time.LoadLocation(*channelConfig.QuotaTimezone); err == nil {
+			location = loaded
+		}
+	}
+	now = now.In(location)
+	year, month, day := now.Date()
+	switch *channelConfig.QuotaPeriod {
+	case "week":
+		return time.Date(year, month, day-(int(now.Weekday())+6)%7, 0, 0, 0, 0, location)
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, location)
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, location)
+}
+
+func getQuotaPeriodLabel(channelConfig configurationChannel) string {
+	switch *channelConfig.QuotaPeriod {
+	case "day":
+		return "today"
+	case "week":
+		return "this week"
+	case "month":
+		return "this month"
+	}
+	return "in total"
+}
+
+func getQuotaUsage(key string, channelID string, userID string, start time.Time) int64 {
+	quotaMutex.Lock()
+	if usage, exists := quotaUsages[key]; exists && usage.start.Equal(start) {
+		defer quotaMutex.Unlock()
+		return usage.bytes
+	}
+	quotaMutex.Unlock()
+
+	bytes := dbDownloadSizeByChannel(channelID, userID, start)
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+	quotaUsages[key] = &quotaUsage{start, bytes}
+	return bytes
+}
+
+// Quotas set for the channel, the user's included if userID isn't empty.
+func getQuotas(channelConfig configurationChannel, channelID string, userID string) []quota {
+	var quotas []quota
+	start := getQuotaPeriodStart(channelConfig, time.Now())
+	if channelConfig.MaxBytesPerChannel != nil {
+		if limit, err := parseByteSize(*channelConfig.MaxBytesPerChannel); err == nil {
+			key := "channel:" + channelID
+			quotas = append(quotas, quota{"channel", key, start, getQuotaUsage(key, channelID, "", start), limit})
+		}
+	}
+	if channelConfig.MaxBytesPerUser != nil && userID != "" {
+		if limit, err := parseByteSize(*channelConfig.MaxBytesPerUser); err == nil {
+			key := "user:" + channelID + ":" + userID
+			quotas = append(quotas, quota{"user", key, start, getQuotaUsage(key, channelID, userID, start), limit})
+		}
+	}
+	return quotas
+}
+
+// Returns the first quota that's been used up, or nil.
+func getExceededQuota(channelConfig configurationChannel, channelID string, userID string) *quota {
+	for _, q := range getQuotas(channelConfig, channelID, userID) {
+		if q.used >= q.limit {
+			return &q
+		}
+	}
+	return nil
+}
+
+// Quota usage for the stats command, the channel's and the users closest to theirs.
+func getQuotaStats(channelConfig configurationChannel, channelID string) string {
+	var lines []string
+	period := getQuotaPeriodLabel(channelConfig)
+	for _, q := range getQuotas(channelConfig, channelID, "") {
+		lines = append(lines, fmt.Sprintf("• **Channel Quota —** %s of %s used %s", formatBytes(q.used), formatBytes(q.limit), period))
+	}
+	if channelConfig.MaxBytesPerUser != nil {
+		limit, err := parseByteSize(*channelConfig.MaxBytesPerUser)
+		if err != nil {
+			return strings.Join(lines, "\n")
+		}
+		lines = append(lines, fmt.Sprintf("• **User Quota —** %s each %s", formatBytes(limit), period))
+		sizes := dbDownloadSizeByChannelUsers(channelID, getQuotaPeriodStart(channelConfig, time.Now()))
+		users := make([]string, 0, len(sizes))
+		for userID := range sizes {
+			users = append(users, userID)
+		}
+		sort.Slice(users, func(i, j int) bool { return sizes[users[i]] > sizes[users[j]] })
+		for i, userID := range users {
+			if i == quotaStatsTopUsers {
+				lines = append(lines, fmt.Sprintf("   _...and %d more_", len(users)-i))
+				break
+			}
+			line := fmt.Sprintf("   <@%s> — %s", userID, formatBytes(sizes[userID]))
+			if limit > 0 {
+				line += fmt.Sprintf(" (%d%%)", sizes[userID]*100/limit)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func recordQuotaUsage(channelID string, userID string, size int64) {
+	if size <= 0 {
+		return
+	}
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+	for _, key := range []string{"channel:" + channelID, "user:" + channelID + ":" + userID} {
+		if usage, exists := quotaUsages[key]; exists {
+			usage.bytes += size
+		}
+	}
+}
+
+// Lets the user know by DM their downloads are being skipped, once per period.
+func notifyQuotaExceeded(q quota, channelConfig configurationChannel, channelID string, userID string) {
+	notifiedKey := q.key + "|" + userID
+	quotaMutex.Lock()
+	if notified, exists := quotaNotified[notifiedKey]; exists && notified.Equal(q.start) {
+		quotaMutex.Unlock()
+		return
+	}
+	quotaNotified[notifiedKey] = q.start
+	quotaMutex.Unlock()
+
+	content := fmt.Sprintf("Downloads from <#%s> have used %s of the %s allowed %s",
+		channelID, formatBytes(q.used), formatBytes(q.limit), getQuotaPeriodLabel(channelConfig))
+	if q.scope == "user" {
+		content = fmt.Sprintf("Your downloads from <#%s> have used %s of the %s allowed %s",
+			channelID, formatBytes(q.used), formatBytes(q.limit), getQuotaPeriodLabel(channelConfig))
+	}
+	if *channelConfig.QuotaPeriod == "total" {
+		content += ", nothing more will be saved."
+	} else {
+		content += ", nothing more will be saved until the quota resets."
+	}
+
+	dm, err := bot.UserChannelCreate(userID)
+	if err == nil {
+		_, err = bot.ChannelMessageSendEmbed(dm.ID, buildEmbed(channelID, "Quota Reached", content))
+	}
+	if err != nil {
+		log.Println(logPrefixErrorLabel("Quota"), color.HiRedString("Failed to notify %s of reaching the quota for %s:\t%s", userID, channelID, err))
+	}
+}
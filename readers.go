@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Alt tokens only ever page through channel messages for history. Everything else, downloads, replies,
+// reactions and the database, goes through the primary session. With no alt tokens history uses bot alone.
+
+var logPrefixReaders = color.HiCyanString("[History Readers]")
+
+type historyReader struct {
+	session *discordgo.Session
+	label   string
+
+	mutex        sync.Mutex
+	excluded     map[string]bool // channels it can't read
+	limitedUntil time.Time
+}
+
+var (
+	historyReaders      []*historyReader // primary first, empty without alt tokens
+	historyReadersNext  int
+	historyReadersMutex sync.Mutex
+)
+
+// Logs into each alt token over REST only, they never open a gateway connection.
+func openHistoryReaders() {
+	settings := getConfig()
+	if len(settings.Credentials.AltTokens) == 0 {
+		return
+	}
+	readers := []*historyReader{{session: bot, label: "primary", excluded: make(map[string]bool)}}
+	for i, token := range settings.Credentials.AltTokens {
+		if !settings.Credentials.UserBot {
+			token = "Bot " + token
+		}
+		session, err := discordgo.New(token)
+		if err == nil {
+			applyNetworkSettingsToDiscord(session)
+			var altUser *discordgo.User
+			if altUser, err = session.User("@me"); err == nil {
+				reader := &historyReader{session: session, label: getUserIdentifier(*altUser), excluded: make(map[string]bool)}
+				// Sessions have their own rate limits, one waiting out a 429 is passed over instead of holding up the rest
+				session.AddHandler(func(s *discordgo.Session, r *discordgo.RateLimit) {
+					reader.mutex.Lock()
+					reader.limitedUntil = time.Now().Add(r.RetryAfter * time.Millisecond)
+					reader.mutex.Unlock()
+				})
+				readers = append(readers, reader)
+				log.Println(logPrefixReaders, color.GreenString("Alt token %d logged in as %s", i+1, reader.label))
+				continue
+			}
+		}
+		log.Println(logPrefixReaders, color.HiRedString("Alt token %d couldn't log in, it won't be used:\t%s", i+1, err))
+	}
+	if len(readers) == 1 {
+		return
+	}
+	historyReadersMutex.Lock()
+	historyReaders = readers
+	historyReadersMutex.Unlock()
+}
+
+// Next reader that can read the channel and isn't rate limited, in turn. If every reader is limited,
+// the one free soonest is used and waits in discordgo's own limiter.
+func nextHistoryReader(channelID string) *historyReader {
+	historyReadersMutex.Lock()
+	defer historyReadersMutex.Unlock()
+	var soonest *historyReader
+	for range historyReaders {
+		reader := historyReaders[historyReadersNext%len(historyReaders)]
+		historyReadersNext++
+		reader.mutex.Lock()
+		excluded, limitedUntil := reader.excluded[channelID], reader.limitedUntil
+		reader.mutex.Unlock()
+		if excluded {
+			continue
+		}
+		if time.Now().After(limitedUntil) {
+			return reader
+		}
+		if soonest == nil || limitedUntil.Before(soonest.limitedUntil) {
+			soonest = reader
+		}
+	}
+	return soonest
+}
+
+func isMissingAccess(err error) bool {
+	if restErr, ok := err.(*discordgo.RESTError); ok {
+		if restErr.Message != nil && (restErr.Message.Code == discordgo.ErrCodeMissingAccess || restErr.Message.Code == discordgo.ErrCodeUnknownChannel) {
+			return true
+		}
+		return restErr.Response != nil && (restErr.Response.StatusCode == http.StatusForbidden || restErr.Response.StatusCode == http.StatusNotFound)
+	}
+	return false
+}
+
+// A batch of up to 100 messages for history, spread across the alt tokens when there are any.
+// Readers that can't see the channel are left out of it for the rest of the session.
+func getHistoryMessages(channelID string, limit int, beforeID string, sinceID string) ([]*discordgo.Message, error) {
+	if len(historyReaders) == 0 {
+		return bot.ChannelMessages(channelID, limit, beforeID, sinceID, "")
+	}
+	for {
+		reader := nextHistoryReader(channelID)
+		if reader == nil || reader.session == bot {
+			return bot.ChannelMessages(channelID, limit, beforeID, sinceID, "")
+		}
+		messages, err := reader.session.ChannelMessages(channelID, limit, beforeID, sinceID, "")
+		if err == nil || !isMissingAccess(err) {
+			return messages, err
+		}
+		reader.mutex.Lock()
+		reader.excluded[channelID] = true
+		reader.mutex.Unlock()
+		log.Println(logPrefixReaders, color.YellowString("%s can't read %s, leaving it out for that channel", reader.label, channelID))
+	}
+}
@@ -17,6 +17,8 @@ const (
 	regexpUrlFlickrPhoto          = `^http(s)?:\/\/(www\.)?flickr\.com\/photos\/([0-9]+)@([A-Z0-9]+)\/([0-9]+)(\/)?(\/in\/album-([0-9]+)(\/)?)?$`
 	regexpUrlFlickrAlbum          = `^http(s)?:\/\/(www\.)?flickr\.com\/photos\/(([0-9]+)@([A-Z0-9]+)|[A-Za-z0-9]+)\/(albums\/(with\/)?|(sets\/)?)([0-9]+)(\/)?$`
 	regexpUrlFlickrAlbumShort     = `^http(s)?:\/\/((www\.)?flickr\.com\/gp\/[0-9]+@[A-Z0-9]+\/[A-Za-z0-9]+|flic\.kr\/s\/[a-zA-Z0-9]+)$`
+	regexpUrlFlickrGallery        = `^http(s)?:\/\/(www\.)?flickr\.com\/photos\/[^\/]+\/galleries\/[0-9]+(\/)?$`
+	regexpUrlFlickrGroupPool      = `^http(s)?:\/\/(www\.)?flickr\.com\/groups\/[^\/]+\/pool(\/)?$`
 	regexpUrlGoogleDrive          = `^http(s?):\/\/drive\.google\.com\/file\/d\/[^/]+\/view$`
 	regexpUrlGoogleDriveFolder    = `^http(s?):\/\/drive\.google\.com\/(drive\/folders\/|open\?id=)([^/]+)$`
 	regexpUrlTistory              = `^http(s?):\/\/t[0-9]+\.daumcdn\.net\/cfile\/tistory\/([A-Z0-9]+?)(\?original)?$`
@@ -26,6 +28,9 @@ const (
 	regexpUrlRedditPost           = `^http(s?):\/\/(www\.)?reddit\.com\/r\/([0-9a-zA-Z'_]+)?\/comments\/([0-9a-zA-Z'_]+)\/?([0-9a-zA-Z'_]+)?(.*)?$`
 	regexpUrlMastodonPost1        = `^http(s)?:\/\/([0-9a-zA-Z\.-]+)?\/@([0-9a-zA-Z'_]+)?\/([0-9]+)?$`
 	regexpUrlMastodonPost2        = `^http(s)?:\/\/([0-9a-zA-Z\.-]+)?\/web\/statuses\/([0-9]+)?$`
+	regexpUrlDiscordMessage       = `^http(s?):\/\/((ptb|canary)\.)?discord(app)?\.com\/channels\/([0-9]+|@me)\/([0-9]+)\/([0-9]+)\/?$`
+	regexpUrlTenor                = `^http(s?):\/\/(www\.)?tenor\.com\/([a-z]{2}(-[A-Z]{2})?\/)?view\/[^\/?#]+\/?(\?.*)?$`
+	regexpUrlGiphy                = `^http(s?):\/\/(www\.)?giphy\.com\/(gifs|stickers)\/(([^\/?#]+)-)?([A-Za-z0-9]+)\/?(\?.*)?$`
 )
 
 var (
@@ -39,6 +44,8 @@ var (
 	regexUrlFlickrPhoto          *regexp.Regexp
 	regexUrlFlickrAlbum          *regexp.Regexp
 	regexUrlFlickrAlbumShort     *regexp.Regexp
+	regexUrlFlickrGallery        *regexp.Regexp
+	regexUrlFlickrGroupPool      *regexp.Regexp
 	regexUrlGoogleDrive          *regexp.Regexp
 	regexUrlGoogleDriveFolder    *regexp.Regexp
 	regexUrlTistory              *regexp.Regexp
@@ -48,6 +55,9 @@ var (
 	regexUrlRedditPost           *regexp.Regexp
 	regexUrlMastodonPost1        *regexp.Regexp
 	regexUrlMastodonPost2        *regexp.Regexp
+	regexUrlDiscordMessage       *regexp.Regexp
+	regexUrlTenor                *regexp.Regexp
+	regexUrlGiphy                *regexp.Regexp
 )
 
 func compileRegex() error {
@@ -93,6 +103,14 @@ func compileRegex() error {
 	if err != nil {
 		return err
 	}
+	regexUrlFlickrGallery, err = regexp.Compile(regexpUrlFlickrGallery)
+	if err != nil {
+		return err
+	}
+	regexUrlFlickrGroupPool, err = regexp.Compile(regexpUrlFlickrGroupPool)
+	if err != nil {
+		return err
+	}
 	regexUrlGoogleDrive, err = regexp.Compile(regexpUrlGoogleDrive)
 	if err != nil {
 		return err
@@ -129,6 +147,18 @@ func compileRegex() error {
 	if err != nil {
 		return err
 	}
+	regexUrlDiscordMessage, err = regexp.Compile(regexpUrlDiscordMessage)
+	if err != nil {
+		return err
+	}
+	regexUrlTenor, err = regexp.Compile(regexpUrlTenor)
+	if err != nil {
+		return err
+	}
+	regexUrlGiphy, err = regexp.Compile(regexpUrlGiphy)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
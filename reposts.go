@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Files the archive posts back into Discord, by this bot or by another tool's account, would otherwise be saved
+// again every time they're posted. Messages by the bot itself (unless scanOwnMessages) and by anyone in
+// ignoreAuthors are left alone, and with ignoreRecordedLinks links already in the database from any channel are
+// dropped before they're looked up. History runs go through the same checks.
+
+// This session, for the stats command
+var (
+	ignoredOwnMessages    int64 // atomic
+	ignoredAuthorMessages int64 // atomic
+	ignoredRecordedLinks  int64 // atomic
+)
+
+// Whether the message's author is one whose messages are never downloaded from, counting it if it had anything to
+// download in a registered channel.
+func isIgnoredAuthor(m *discordgo.Message) bool {
+	settings := getConfig()
+	counter := &ignoredAuthorMessages
+	if m.Author.ID == user.ID && !settings.ScanOwnMessages {
+		counter = &ignoredOwnMessages
+	} else if !stringInSlice(m.Author.ID, settings.IgnoreAuthors) {
+		return false
+	}
+	if settings.isChannelRegistered(m.ChannelID) && len(getMessageRawLinks(m, settings)) > 0 {
+		atomic.AddInt64(counter, 1)
+	}
+	return true
+}
+
+// Drops links with a row from any channel whose file isn't missing. History runs with --force or --missing-only
+// keep them, they're downloading again on purpose.
+func trimRecordedLinks(channelID string, links []*fileItem, run *historyOptions) []*fileItem {
+	settings := getConfig()
+	history := run != nil
+	if !settings.IgnoreRecordedLinks || (history && (run.force || run.missingOnly)) {
+		return links
+	}
+	var kept []*fileItem
+	for _, link := range links {
+		recorded := false
+		for _, row := range dbFindDownloadByURL(link.Link) {
+			if row.Missing.IsZero() {
+				recorded = true
+				break
+			}
+		}
+		if !recorded {
+			kept = append(kept, link)
+			continue
+		}
+		atomic.AddInt64(&ignoredRecordedLinks, 1)
+		if history {
+			recordHistorySkip(channelID, downloadSkippedAlreadyRecorded)
+		}
+		if settings.DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Ignoring %s, it's already recorded in the database", link.Link))
+		}
+	}
+	return kept
+}
+
+// For the stats command, "" if nothing was ignored.
+func getIgnoredStats() string {
+	var parts []string
+	if count := atomic.LoadInt64(&ignoredOwnMessages); count > 0 {
+		parts = append(parts, fmt.Sprintf("%s own message%s", formatNumber(count), pluralS(int(count))))
+	}
+	if count := atomic.LoadInt64(&ignoredAuthorMessages); count > 0 {
+		parts = append(parts, fmt.Sprintf("%s message%s by ignored authors", formatNumber(count), pluralS(int(count))))
+	}
+	if count := atomic.LoadInt64(&ignoredRecordedLinks); count > 0 {
+		parts = append(parts, fmt.Sprintf("%s link%s already recorded", formatNumber(count), pluralS(int(count))))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "• **Ignored this Session —** " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Channels with retention have files older than it deleted, along with their thumbnails, and their rows marked as
+// pruned rather than removed so the links aren't downloaded again. The first pass after retention is set or
+// changed only logs what it would delete. Files other rows still point to, from another channel's hardlinks or
+// CAS blobs or filesystem duplicates, are kept.
+
+var logPrefixRetention = color.HiRedString("[Retention]")
+
+const (
+	// How often rows are checked against their channel's retention
+	retentionCheckInterval = time.Hour
+	// Files listed by a dry run pass for each channel, the rest are only counted
+	retentionDryRunListed = 20
+)
+
+// Channel ID to the retention its files were last checked against, a different one means a dry run first.
+// Only touched by the pruning job.
+var retentionSeen = make(map[string]string)
+
+// A Go duration, or a number of days like "90d".
+func parseRetention(value string) (time.Duration, error) {
+	if strings.HasSuffix(strings.ToLower(value), "d") {
+		days, err := strconv.ParseFloat(value[:len(value)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("\"%s\" isn't a number of days", value)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err == nil && retention <= 0 {
+		err = fmt.Errorf("\"%s\" isn't above zero", value)
+	}
+	return retention, err
+}
+
+type retentionRow struct {
+	id          int
+	channelID   string
+	destination string
+	thumbnail   string
+	blobPath    string
+}
+
+type retentionTally struct {
+	files, rows int
+	bytes       int64
+	listed      []string
+}
+
+// Runs a pass every retentionCheckInterval from launch, passes do nothing while no channel has retention.
+func startRetention() {
+	for {
+		pruneExpiredDownloads()
+		time.Sleep(retentionCheckInterval)
+	}
+}
+
+func getRetentions() map[string]string {
+	retentions := make(map[string]string)
+	for _, channelID := range getAllChannels() {
+		if channelConfig := getChannelConfig(channelID); channelConfig.Retention != nil {
+			retentions[channelID] = *channelConfig.Retention
+		}
+	}
+	return retentions
+}
+
+// Finds rows past their channel's retention and deletes their files, or logs what it would for channels whose
+// retention is new. Sends a summary to the channels' digests and admin status channels.
+func pruneExpiredDownloads() {
+	retentions := getRetentions()
+	if len(retentions) == 0 {
+		return
+	}
+	cutoffs := make(map[string]time.Time)
+	for channelID, value := range retentions {
+		if retention, err := parseRetention(value); err == nil {
+			cutoffs[channelID] = time.Now().Add(-retention)
+		}
+	}
+
+	// Every row that isn't pruned yet refers to its files, expired or not
+	var expired []retentionRow
+	references := make(map[string][]int)
+	dbDownloads().ForEachDoc(func(id int, docContent []byte) bool {
+		var doc map[string]interface{}
+		if json.Unmarshal(docContent, &doc) != nil {
+			return true
+		}
+		if _, pruned := doc["Pruned"]; pruned {
+			return true
+		}
+		row := retentionRow{
+			id:          id,
+			channelID:   dbReadString(doc, "ChannelID"),
+			destination: dbReadString(doc, "Destination"),
+			thumbnail:   dbReadString(doc, "ThumbnailPath"),
+			blobPath:    dbReadString(doc, "BlobPath"),
+		}
+		for _, path := range []string{row.destination, dbReadString(doc, "LinkedTo"), row.blobPath} {
+			if path != "" {
+				references[cleanRetentionPath(path)] = append(references[cleanRetentionPath(path)], id)
+			}
+		}
+		if cutoff, exists := cutoffs[row.channelID]; exists {
+			if downloaded := dbReadTime(doc, "Time"); !downloaded.IsZero() && downloaded.Before(cutoff) {
+				expired = append(expired, row)
+			}
+		}
+		return true
+	})
+
+	dryRun := make(map[string]bool)
+	for channelID, value := range retentions {
+		if retentionSeen[channelID] != value {
+			dryRun[channelID] = true
+			retentionSeen[channelID] = value
+		}
+	}
+	pruning := make(map[int]bool)
+	for _, row := range expired {
+		if !dryRun[row.channelID] {
+			pruning[row.id] = true
+		}
+	}
+	// Kept if anything not being pruned still points to it
+	deletable := func(path string) bool {
+		for _, id := range references[cleanRetentionPath(path)] {
+			if !pruning[id] {
+				return false
+			}
+		}
+		return true
+	}
+
+	tallies := make(map[string]*retentionTally)
+	pruned := time.Now()
+	for _, row := range expired {
+		tally := tallies[row.channelID]
+		if tally == nil {
+			tally = &retentionTally{}
+			tallies[row.channelID] = tally
+		}
+		tally.rows++
+		if dryRun[row.channelID] {
+			if row.destination != "" {
+				tally.files++
+				if info, err := os.Stat(row.destination); err == nil {
+					tally.bytes += info.Size()
+				}
+				if len(tally.listed) < retentionDryRunListed {
+					tally.listed = append(tally.listed, row.destination)
+				}
+			}
+			continue
+		}
+		for _, path := range []string{row.destination, row.thumbnail, row.blobPath} {
+			if path == "" || !deletable(path) {
+				continue
+			}
+			if size, removed := removePrunedFile(path); removed {
+				tally.files++
+				tally.bytes += size
+			}
+		}
+		if row.destination != "" {
+			removeFromImgStore(row.destination)
+		}
+		if err := dbMarkPruned(row.id, pruned); err != nil {
+			log.Println(logPrefixRetention, color.HiRedString("Failed to mark download %d as pruned:\t%s", row.id, err))
+		}
+	}
+
+	channels := make([]string, 0, len(tallies))
+	for channelID := range tallies {
+		channels = append(channels, channelID)
+	}
+	sort.Strings(channels)
+	summary := ""
+	for _, channelID := range channels {
+		tally := tallies[channelID]
+		source := getSourceName(getChannelGuildID(channelID), channelID)
+		if dryRun[channelID] {
+			log.Println(logPrefixRetention, color.YellowString("Retention for %s is new, would delete %d file%s (%s) older than %s, deleting from the next check",
+				source, tally.files, pluralS(tally.files), formatBytes(tally.bytes), retentions[channelID]))
+			for _, path := range tally.listed {
+				log.Println(logPrefixRetention, color.YellowString("Would delete \"%s\"", path))
+			}
+			if tally.files > len(tally.listed) {
+				log.Println(logPrefixRetention, color.YellowString("...and %d more", tally.files-len(tally.listed)))
+			}
+			continue
+		}
+		log.Println(logPrefixRetention, color.HiCyanString("Pruned %d download%s from %s older than %s, %d file%s deleted (%s)",
+			tally.rows, pluralS(tally.rows), source, retentions[channelID], tally.files, pluralS(tally.files), formatBytes(tally.bytes)))
+		summary += fmt.Sprintf("\n<#%s> — **%s** download%s older than %s, %s file%s deleted (%s)", channelID,
+			formatNumber(int64(tally.rows)), pluralS(tally.rows), retentions[channelID],
+			formatNumber(int64(tally.files)), pluralS(tally.files), formatBytes(tally.bytes))
+	}
+	if summary != "" {
+		sendRetentionSummary(channels, strings.TrimPrefix(summary, "\n"))
+	}
+}
+
+func cleanRetentionPath(path string) string {
+	if isRemoteDestination(path) {
+		return path
+	}
+	return filepath.Clean(path)
+}
+
+// Deletes a file wherever it's kept, returning its size if it could tell and whether it's gone.
+func removePrunedFile(path string) (int64, bool) {
+	var size int64
+	if !isRemoteDestination(path) {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return 0, false
+		}
+		// Symlinks to CAS blobs are counted when the blob goes
+		if err == nil && info.Mode().IsRegular() {
+			size = info.Size()
+		}
+	}
+	storage, err := getStorageBackend(path)
+	if err == nil {
+		err = storage.remove(path)
+	}
+	if err != nil {
+		log.Println(logPrefixRetention, color.HiRedString("Failed to delete \"%s\":\t%s", path, err))
+		return 0, false
+	}
+	return size, true
+}
+
+// To the digests of the channels pruned and admin channels with logStatus, once each.
+func sendRetentionSummary(channelIDs []string, content string) {
+	var targets []string
+	for _, channelID := range channelIDs {
+		for _, target := range getDigestTargets(channelID) {
+			if !stringInSlice(target.ChannelID, targets) {
+				targets = append(targets, target.ChannelID)
+			}
+		}
+	}
+	for _, adminChannel := range getConfig().AdminChannels {
+		if *adminChannel.LogStatus && !stringInSlice(adminChannel.ChannelID, targets) {
+			targets = append(targets, adminChannel.ChannelID)
+		}
+	}
+	if bot == nil {
+		return
+	}
+	for _, target := range targets {
+		if !hasPerms(target, discordgo.PermissionSendMessages) {
+			log.Println(logPrefixRetention, color.HiRedString(fmtBotSendPerm, target))
+			continue
+		}
+		if _, err := bot.ChannelMessageSendEmbed(target, buildEmbed(target, "Log — Retention", content)); err != nil {
+			log.Println(logPrefixRetention, color.HiRedString("Failed to send retention summary to %s:\t%s", target, err))
+		}
+	}
+}
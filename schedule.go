@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+)
+
+// With a schedule, files over deferAbove found outside its windows are journaled instead of downloaded, and
+// downloaded once a window opens. Smaller files go straight away. History runs wait for a window unless told not to.
+
+var logPrefixSchedule = color.HiCyanString("[Schedule]")
+
+const (
+	scheduleTimeLayout        = "15:04"
+	scheduleDeferAboveDefault = "10MB"
+	// How often deferred downloads are checked on while no window is open
+	scheduleCheckInterval = time.Minute
+)
+
+// Journaled downloads waiting for a window
+var deferredDownloads int64 // atomic
+
+func getScheduleWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if day == name || day == name[:3] {
+			return weekday, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// When the window opens and closes if it opens on day's date, false if it doesn't open that day.
+func getScheduleWindowOn(window configurationScheduleWindow, day time.Time) (time.Time, time.Time, bool) {
+	if len(window.Days) > 0 {
+		opensToday := false
+		for _, name := range window.Days {
+			if weekday, _ := getScheduleWeekday(name); weekday == day.Weekday() {
+				opensToday = true
+			}
+		}
+		if !opensToday {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	start, _ := time.Parse(scheduleTimeLayout, window.Start)
+	end, _ := time.Parse(scheduleTimeLayout, window.End)
+	opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, day.Location())
+	closes := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, day.Location())
+	if !closes.After(opens) {
+		closes = closes.AddDate(0, 0, 1)
+	}
+	return opens, closes, true
+}
+
+// Always true without a schedule.
+func isInScheduleWindow(t time.Time) bool {
+	settings := getConfig()
+	if settings.Schedule == nil {
+		return true
+	}
+	for _, window := range settings.Schedule.Windows {
+		// Yesterday's window might run past midnight
+		for offset := -1; offset <= 0; offset++ {
+			if opens, closes, ok := getScheduleWindowOn(window, t.AddDate(0, 0, offset)); ok && !t.Before(opens) && t.Before(closes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// When the next window opens after t, zero without a schedule.
+func getNextScheduleWindow(t time.Time) time.Time {
+	settings := getConfig()
+	var next time.Time
+	if settings.Schedule == nil {
+		return next
+	}
+	for _, window := range settings.Schedule.Windows {
+		for offset := 0; offset <= 7; offset++ {
+			if opens, _, ok := getScheduleWindowOn(window, t.AddDate(0, 0, offset)); ok && opens.After(t) {
+				if next.IsZero() || opens.Before(next) {
+					next = opens
+				}
+				break
+			}
+		}
+	}
+	return next
+}
+
+func formatScheduleWindow(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (in %s)", t.Format("Mon 15:04"), durafmt.ParseShort(time.Until(t).Round(time.Minute)))
+}
+
+// Journals the download for later if it's outside the schedule's windows and over deferAbove, or its size can't be
+// told without downloading it. Returns whether it was.
+func deferDownload(download downloadRequestStruct) bool {
+	settings := getConfig()
+	if settings.Schedule == nil || download.DryRun || download.ManualDownload || download.EmojiCmd || download.Message == nil ||
+		isInScheduleWindow(time.Now()) {
+		return false
+	}
+	threshold, _ := parseByteSize(settings.Schedule.DeferAbove)
+	size := download.ExpectedSize
+	if size <= 0 {
+		size = getRemoteSize(download.InputURL)
+	}
+	if size >= 0 && size <= threshold {
+		return false
+	}
+	if _, err := dbInsertPendingDownload(download, true); err != nil {
+		log.Println(logPrefixSchedule, color.HiRedString("Failed to defer %s, downloading it now:\t%s", download.InputURL, err))
+		return false
+	}
+	atomic.AddInt64(&deferredDownloads, 1)
+	sizeLabel := "unknown size"
+	if size >= 0 {
+		sizeLabel = formatBytes(size)
+	}
+	log.Println(logPrefixSchedule, color.CyanString("Deferred %s (%s) until the window opens %s", download.InputURL, sizeLabel, formatScheduleWindow(getNextScheduleWindow(time.Now()))))
+	return true
+}
+
+// Content-Length from a HEAD request, -1 if the server doesn't say.
+func getRemoteSize(inputURL string) int64 {
+	client := getHTTPClient(inputURL)
+	client.Timeout = 15 * time.Second
+	response, err := client.Head(inputURL)
+	if err != nil {
+		return -1
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return -1
+	}
+	return response.ContentLength
+}
+
+// Downloads what was deferred whenever a window is open, run once at launch.
+func startSchedule() {
+	deferred := 0
+	for _, item := range dbGetPendingDownloads() {
+		if item.deferred {
+			deferred++
+		}
+	}
+	atomic.StoreInt64(&deferredDownloads, int64(deferred))
+	if deferred > 0 {
+		log.Println(logPrefixSchedule, color.CyanString("%d download%s deferred from the last run", deferred, pluralS(deferred)))
+	}
+	for {
+		if atomic.LoadInt64(&deferredDownloads) > 0 && isInScheduleWindow(time.Now()) {
+			drainDeferredDownloads()
+		}
+		time.Sleep(scheduleCheckInterval)
+	}
+}
+
+// Oldest first, stopping if the window closes partway.
+func drainDeferredDownloads() {
+	pending := dbGetPendingDownloads()
+	var ids []int
+	for id, item := range pending {
+		if item.deferred {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	log.Println(logPrefixSchedule, color.CyanString("Window open, downloading %d deferred file%s...", len(ids), pluralS(len(ids))))
+	downloaded, dropped := 0, 0
+	for i, id := range ids {
+		if !isInScheduleWindow(time.Now()) {
+			log.Println(logPrefixSchedule, color.YellowString("Window closed, %d file%s left deferred", len(ids)-i, pluralS(len(ids)-i)))
+			break
+		}
+		item := pending[id]
+		if drop := preparePendingDownload(&item); drop != "" {
+			log.Println(logPrefixSchedule, color.YellowString("Dropped %s from message %s, %s", item.request.InputURL, item.messageID, drop))
+			dropped++
+		} else if startDownload(item.request).Status == downloadSuccess {
+			downloaded++
+		}
+		completePendingDownload(id)
+		atomic.AddInt64(&deferredDownloads, -1)
+	}
+	log.Println(logPrefixSchedule, color.HiCyanString("Finished deferred downloads, %d downloaded and %d dropped", downloaded, dropped))
+}
+
+// Holds a history run until a window opens, letting whoever started it know. Returns false if it was cancelled
+// while waiting.
+func waitForScheduleWindow(commandingMessage *discordgo.Message, channelID string) bool {
+	if isInScheduleWindow(time.Now()) {
+		return true
+	}
+	next := getNextScheduleWindow(time.Now())
+	if commandingMessage != nil {
+		replyEmbed(commandingMessage, "Command — History", fmt.Sprintf("Outside the download schedule, history for _#%s_ will start %s.\n\n"+
+			"Use `nowait` to start now instead, files over %s would wait for the window.",
+			getChannelName(channelID), formatScheduleWindow(next), getConfig().Schedule.DeferAbove))
+	}
+	log.Println(logPrefixHistory, color.YellowString("%s: Outside the download schedule, waiting until %s", channelID, formatScheduleWindow(next)))
+	for !isInScheduleWindow(time.Now()) {
+		if getHistoryStatus(channelID) == "cancel" {
+			log.Println(logPrefixHistory, color.CyanString("%s: Cancelled while waiting for the download schedule", channelID))
+			return false
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return true
+}
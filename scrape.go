@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+)
+
+var logPrefixScrape = color.HiCyanString("[Scrape]")
+
+const (
+	// Folder scraped accounts go in, unless manualDestinations has a "scrape" entry
+	scrapeDestinationDefault = "scrape"
+	scrapeLimitDefault       = 200
+	// Twitter's timeline doesn't go back further than this anyway
+	scrapeLimitMax = 3200
+	// Times a rate limited request is tried again before giving up
+	scrapeRetryMax = 5
+	// Progress message is edited every this many posts
+	scrapeProgressEvery = 10
+)
+
+var scrapeSites = []string{"twitter", "reddit", "pixiv"}
+
+type scrapePost struct {
+	id    string
+	links map[string]string
+	time  time.Time
+}
+
+// Accounts being scraped, by "site:name", so the same one isn't run twice at once
+var scrapesRunning sync.Map
+
+// Folder for a scraped account, e.g. scrape/twitter/name.
+func getScrapeDestination(site string, name string) string {
+	root := scrapeDestinationDefault
+	if path, exists := getConfig().ManualDestinations["scrape"]; exists {
+		root = path
+	}
+	return filepath.Join(root, site, sanitizePathSegment(name))
+}
+
+// Waits out a rate limit, doubling each time unless the site says when to come back.
+func scrapeBackoff(site string, attempt int, until time.Time) {
+	wait := time.Duration(5<<uint(attempt)) * time.Second
+	if !until.IsZero() && time.Until(until) > 0 {
+		wait = time.Until(until) + time.Second
+	}
+	log.Println(logPrefixScrape, color.YellowString("Rate limited by %s, waiting %s...", site, durafmt.ParseShort(wait)))
+	time.Sleep(wait)
+}
+
+//#region Twitter
+
+// Media tweets newer than sinceID, newest first. Complete if nothing newer was left out.
+func getTwitterScrapePosts(name string, sinceID string, limit int, channelID string) ([]scrapePost, bool, error) {
+	if twitterClient == nil {
+		return nil, false, errors.New("Twitter API credentials aren't set")
+	}
+	var posts []scrapePost
+	maxID := ""
+	for len(posts) < limit {
+		values := url.Values{}
+		values.Set("screen_name", name)
+		values.Set("count", "200")
+		values.Set("include_rts", "false")
+		values.Set("tweet_mode", "extended")
+		if sinceID != "" {
+			values.Set("since_id", sinceID)
+		}
+		if maxID != "" {
+			values.Set("max_id", maxID)
+		}
+
+		var timeline []anaconda.Tweet
+		var err error
+		for attempt := 0; ; attempt++ {
+			timeline, err = twitterClient.GetUserTimeline(values)
+			if apiErr, ok := err.(*anaconda.ApiError); ok && attempt < scrapeRetryMax {
+				if limited, nextWindow := apiErr.RateLimitCheck(); limited {
+					scrapeBackoff("Twitter", attempt, nextWindow)
+					continue
+				}
+			}
+			break
+		}
+		if err != nil {
+			return posts, false, err
+		}
+		if len(timeline) == 0 {
+			return posts, true, nil
+		}
+
+		for _, tweet := range timeline {
+			if len(posts) >= limit {
+				break
+			}
+			if len(tweet.ExtendedEntities.Media) == 0 {
+				continue
+			}
+			postTime, _ := tweet.CreatedAtTime()
+			posts = append(posts, scrapePost{tweet.IdStr, getTweetMediaUrls(tweet, channelID), postTime})
+		}
+		maxID = strconv.FormatInt(timeline[len(timeline)-1].Id-1, 10)
+	}
+	return posts, false, nil
+}
+
+//#endregion
+
+//#region Reddit
+
+type redditListing struct {
+	Data struct {
+		After    string `json:"after"`
+		Children []struct {
+			Data struct {
+				Name          string  `json:"name"`
+				ID            string  `json:"id"`
+				Subreddit     string  `json:"subreddit"`
+				Author        string  `json:"author"`
+				Title         string  `json:"title"`
+				Created       float64 `json:"created_utc"`
+				URL           string  `json:"url_overridden_by_dest"`
+				IsGallery     bool    `json:"is_gallery"`
+				MediaMetadata map[string]struct {
+					Source struct {
+						URL string `json:"u"`
+						GIF string `json:"gif"`
+					} `json:"s"`
+				} `json:"media_metadata"`
+				GalleryData struct {
+					Items []struct {
+						MediaID string `json:"media_id"`
+					} `json:"items"`
+				} `json:"gallery_data"`
+			} `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+func getRedditListing(link string, listing *redditListing) error {
+	for attempt := 0; ; attempt++ {
+		request, _ := http.NewRequest("GET", link, nil)
+		request.Header.Set("Accept-Encoding", "identity")
+		request.Header.Set("User-Agent", sneakyUserAgent)
+		response, err := getHTTPClient(link).Do(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode == http.StatusTooManyRequests && attempt < scrapeRetryMax {
+			response.Body.Close()
+			var until time.Time
+			if reset, err := strconv.ParseFloat(response.Header.Get("X-Ratelimit-Reset"), 64); err == nil {
+				until = time.Now().Add(time.Duration(reset) * time.Second)
+			}
+			scrapeBackoff("Reddit", attempt, until)
+			continue
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("Reddit responded %s", response.Status)
+		}
+		return json.NewDecoder(response.Body).Decode(listing)
+	}
+}
+
+// New posts with media in a subreddit up to the one sinceID names, newest first. Complete if nothing newer was left out.
+func getRedditScrapePosts(subreddit string, sinceID string, limit int, channelID string) ([]scrapePost, bool, error) {
+	var posts []scrapePost
+	after := ""
+	for len(posts) < limit {
+		link := fmt.Sprintf("https://www.reddit.com/r/%s/new.json?limit=100&raw_json=1", url.PathEscape(subreddit))
+		if after != "" {
+			link += "&after=" + after
+		}
+		var listing redditListing
+		if err := getRedditListing(link, &listing); err != nil {
+			return posts, false, err
+		}
+		for _, child := range listing.Data.Children {
+			post := child.Data
+			if post.Name == sinceID {
+				return posts, true, nil
+			}
+			if len(posts) >= limit {
+				return posts, false, nil
+			}
+			links := make(map[string]string)
+			if post.IsGallery {
+				for i, item := range post.GalleryData.Items {
+					media := post.MediaMetadata[item.MediaID]
+					link := media.Source.URL
+					if link == "" {
+						link = media.Source.GIF
+					}
+					if link != "" {
+						link = html.UnescapeString(link)
+						links[link] = fmt.Sprintf("Reddit-%s_%s_%d %s", post.Subreddit, post.ID, i+1, filenameFromURL(link))
+					}
+				}
+			} else if post.URL != "" {
+				for link, filename := range getDownloadLinks(post.URL, channelID) {
+					if filename == "" && link == post.URL {
+						filename = fmt.Sprintf("Reddit-%s_%s %s", post.Subreddit, post.ID, filenameFromURL(link))
+					}
+					links[link] = filename
+				}
+			}
+			if len(links) > 0 {
+				setSitePost(links, sitePost{Artist: post.Author, PostID: post.ID, PostTitle: post.Title})
+				posts = append(posts, scrapePost{post.Name, links, time.Unix(int64(post.Created), 0)})
+			}
+		}
+		if listing.Data.After == "" {
+			return posts, true, nil
+		}
+		after = listing.Data.After
+	}
+	return posts, false, nil
+}
+
+//#endregion
+
+// For the "scrape" command. Goes through an account's posts oldest first, so if it's stopped partway
+// the next run carries on from the last post it finished.
+func handleScrape(commandingMessage *discordgo.Message, site string, name string, limit int) {
+	key := site + ":" + strings.ToLower(name)
+	if _, running := scrapesRunning.LoadOrStore(key, true); running {
+		if _, err := replyEmbed(commandingMessage, "Command — Scrape", fmt.Sprintf("Already scraping %s on %s.", name, site)); err != nil {
+			log.Println(logPrefixScrape, color.HiRedString("Failed to send command embed message:\t%s", err))
+		}
+		return
+	}
+	defer scrapesRunning.Delete(key)
+
+	started := time.Now()
+	destination := getScrapeDestination(site, name)
+	sinceID := dbGetScrapeCursor(key)
+	log.Println(logPrefixScrape, color.CyanString("%s requested %s on %s be scraped to \"%s\"", getUserIdentifier(*commandingMessage.Author), name, site, destination))
+
+	header := fmt.Sprintf("`Site:` **%s**\n`Account:` _%s_\n`Destination:` `%s`\n\n", site, name, destination)
+	if sinceID != "" {
+		header += "_Only posts since the last scrape._\n\n"
+	}
+	status, err := replyEmbed(commandingMessage, "Command — Scrape", header+"Finding posts, please wait...")
+	if err != nil {
+		log.Println(logPrefixScrape, color.HiRedString("Failed to send command embed message:\t%s", err))
+	}
+	update := func(content string) {
+		if status != nil {
+			if _, err := editEmbed(status, "Command — Scrape", header+content); err != nil {
+				log.Println(logPrefixScrape, color.HiRedString("Failed to edit status message:\t%s", err))
+			}
+		}
+	}
+
+	var posts []scrapePost
+	var complete bool
+	switch site {
+	case "twitter":
+		posts, complete, err = getTwitterScrapePosts(name, sinceID, limit, commandingMessage.ChannelID)
+	case "reddit":
+		posts, complete, err = getRedditScrapePosts(name, sinceID, limit, commandingMessage.ChannelID)
+	}
+	// Moving the cursor past posts that were never listed would skip them for good, a first run
+	// only ever wants the newest anyway
+	saveProgress := !dryRunMode && err == nil && (sinceID == "" || complete)
+	if err != nil {
+		log.Println(logPrefixScrape, color.HiRedString("Error listing %s on %s:\t%s", name, site, err))
+		if len(posts) == 0 {
+			update(fmt.Sprintf("Encountered an error listing posts: %s", err))
+			return
+		}
+	}
+
+	var downloads int64
+	for i := len(posts) - 1; i >= 0; i-- {
+		post := posts[i]
+		for link, filename := range post.links {
+			result := startDownload(downloadRequestStruct{
+				InputURL:       link,
+				Filename:       filename,
+				Path:           destination,
+				Message:        commandingMessage,
+				FileTime:       post.time,
+				Post:           getSitePost(link),
+				HistoryCmd:     true,
+				ManualDownload: true,
+				DryRun:         dryRunMode,
+			})
+			if result.Status == downloadSuccess {
+				downloads++
+			}
+		}
+		if saveProgress {
+			if err := dbSetScrapeCursor(key, post.id); err != nil {
+				log.Println(logPrefixScrape, color.HiRedString("Failed to save progress for %s:\t%s", key, err))
+			}
+		}
+		if done := len(posts) - i; done%scrapeProgressEvery == 0 {
+			update(fmt.Sprintf("``%s:`` **%s files downloaded...**\n``%s of %s posts processed``",
+				durafmt.ParseShort(time.Since(started)).String(),
+				formatNumber(downloads), formatNumber(int64(done)), formatNumber(int64(len(posts))),
+			))
+		}
+	}
+
+	content := fmt.Sprintf("``%s:`` **%s total files downloaded!**\n``%s posts processed``\n\n**FINISHED!**",
+		durafmt.ParseShort(time.Since(started)).String(),
+		formatNumber(downloads), formatNumber(int64(len(posts))),
+	)
+	if err != nil {
+		content += fmt.Sprintf("\n\n_Stopped early, %s_", err)
+	} else if !saveProgress && !dryRunMode {
+		content += fmt.Sprintf("\n\n_There were more than %d new posts, run it again with a higher limit to get the rest._", limit)
+	}
+	if status == nil {
+		if _, err := replyEmbed(commandingMessage, "Command — Scrape", header+content); err != nil {
+			log.Println(logPrefixScrape, color.HiRedString("Failed to send command embed message:\t%s", err))
+		}
+	}
+	update(content)
+	log.Println(logPrefixScrape, color.HiCyanString("Finished scraping %s on %s, %s files", name, site, formatNumber(downloads)))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// The "handler selfcheck" command asks the live sites, so it can't run in tests. The handlers are tested offline
+// against recorded responses in sites_test.go, this is for noticing a site has changed since.
+
+type siteHandlerCheck struct {
+	handler siteHandler
+	links   int
+	err     error
+	took    time.Duration
+	skipped string
+}
+
+// Runs every enabled handler against its checkURL from settings at once, skipping the cache.
+func checkSiteHandlers() []siteHandlerCheck {
+	checks := make([]siteHandlerCheck, len(siteHandlers))
+	var wg sync.WaitGroup
+	for i, handler := range siteHandlers {
+		checks[i].handler = handler
+		checkURL := getConfig().Handlers[handler.name].CheckURL
+		if !isSiteHandlerEnabled(handler.name) {
+			checks[i].skipped = "turned off"
+			continue
+		}
+		if checkURL == "" {
+			checks[i].skipped = "no checkURL"
+			continue
+		}
+		if !handler.matches(checkURL) {
+			checks[i].err = errors.New("checkURL isn't a link it handles")
+			continue
+		}
+		wg.Add(1)
+		go func(check *siteHandlerCheck) {
+			defer wg.Done()
+			started := time.Now()
+			links, err := runSiteHandler(check.handler, checkURL, "", 0)
+			check.links, check.err, check.took = len(links), err, time.Since(started)
+			if err == nil && len(links) == 0 {
+				check.err = errors.New("found nothing")
+			}
+		}(&checks[i])
+	}
+	wg.Wait()
+	return checks
+}
+
+// For the "handler selfcheck" command.
+func handleSiteHandlerSelfcheck(commandingMessage *discordgo.Message) {
+	logPrefixHere := color.CyanString("[Handler Self-check]")
+	status, err := replyEmbed(commandingMessage, "Command — Handler Self-check", "Checking handlers, please wait...")
+	if err != nil {
+		log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message:\t%s", err))
+	}
+	checks := checkSiteHandlers()
+
+	var lines []string
+	passed, failed := 0, 0
+	for _, check := range checks {
+		switch {
+		case check.skipped != "":
+			lines = append(lines, fmt.Sprintf(":white_circle: `%s` %s", check.handler.name, check.skipped))
+		case check.err != nil:
+			failed++
+			lines = append(lines, fmt.Sprintf(":red_circle: `%s` **failed**, %s", check.handler.name, check.err))
+			log.Println(logPrefixHere, color.HiRedString("%s failed:\t%s", check.handler.label, check.err))
+		default:
+			passed++
+			lines = append(lines, fmt.Sprintf(":green_circle: `%s` found %d link%s in %s", check.handler.name, check.links, pluralS(check.links), check.took.Round(time.Millisecond)))
+		}
+	}
+	content := fmt.Sprintf("**%d passed, %d failed**\n\n%s", passed, failed, strings.Join(lines, "\n"))
+	if status == nil {
+		replyEmbed(commandingMessage, "Command — Handler Self-check", content)
+	} else if _, err := editEmbed(status, "Command — Handler Self-check", content); err != nil {
+		log.Println(logPrefixHere, color.HiRedString("Failed to edit status message:\t%s", err))
+	}
+	log.Println(logPrefixHere, color.HiCyanString("%s ran the handler self-check, %d passed, %d failed", getUserIdentifier(*commandingMessage.Author), passed, failed))
+}
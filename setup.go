@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// The -setup flag walks through making a settings file: logging in with a token, picking channels from the servers
+// the account can see, a destination and the common options. An existing settings file is only replaced when told
+// to, and one using upstream discord-downloader-go's names can be migrated to these instead.
+
+// Upstream's names for settings, and what they're called here
+var setupUpstreamRenames = map[string]string{
+	"allowGlobalCommands": "allowGlobalCommmands",
+}
+
+type setupReader struct {
+	*bufio.Reader
+}
+
+func (r setupReader) ask(question string, fallback string) string {
+	if fallback != "" {
+		question += fmt.Sprintf(" [%s]", fallback)
+	}
+	log.Print(color.HiCyanString(question + ": "))
+	answer, _ := r.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return fallback
+	}
+	return answer
+}
+
+func (r setupReader) confirm(question string, fallback bool) bool {
+	options := "y/N"
+	if fallback {
+		options = "Y/n"
+	}
+	for {
+		switch strings.ToLower(r.ask(question+" ("+options+")", "")) {
+		case "":
+			return fallback
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// Runs the setup wizard, then exits.
+func runSetup() {
+	reader := setupReader{bufio.NewReader(os.Stdin)}
+	initConfig()
+	if existing, err := ioutil.ReadFile(configFile); err == nil {
+		if migrated, changes, err := migrateUpstreamConfig(existing); err != nil {
+			log.Println(logPrefixSetup, color.YellowString("\"%s\" couldn't be read to check it for upstream settings:\t%s", configFile, err))
+		} else if len(changes) > 0 {
+			log.Println(logPrefixSetup, color.HiYellowString("\"%s\" uses upstream discord-downloader-go's settings:", configFile))
+			for _, change := range changes {
+				log.Println(logPrefixSetup, color.YellowString("- %s", change))
+			}
+			if reader.confirm("Migrate it? The original is kept as "+configFile+".bak", true) {
+				writeSetupConfig(migrated)
+				return
+			}
+		}
+		if !reader.confirm(fmt.Sprintf("\"%s\" already exists, replace it with new settings? The original is kept as %s.bak", configFile, configFile), false) {
+			log.Println(logPrefixSetup, color.HiYellowString("Left \"%s\" as it was", configFile))
+			return
+		}
+	}
+
+	// Login
+	var session *discordgo.Session
+	var account *discordgo.User
+	credentials := configurationCredentials{}
+	for session == nil {
+		token := strings.TrimPrefix(reader.ask("Bot token", ""), "Bot ")
+		if token == "" {
+			continue
+		}
+		log.Println(logPrefixSetup, color.YellowString("Checking token..."))
+		for _, userBot := range []bool{false, true} {
+			attempt, err := discordgo.New(map[bool]string{false: "Bot " + token, true: token}[userBot])
+			if err != nil {
+				continue
+			}
+			if account, err = attempt.User("@me"); err == nil {
+				session = attempt
+				credentials.Token, credentials.UserBot = token, userBot
+				break
+			}
+		}
+		if session == nil {
+			log.Println(logPrefixSetup, color.HiRedString("Discord didn't accept that token, try again"))
+		}
+	}
+	log.Println(logPrefixSetup, color.HiGreenString("Logged in as %s", getUserIdentifier(*account)))
+
+	// Channels
+	channels := listSetupChannels(session)
+	if len(channels) == 0 {
+		log.Println(logPrefixSetup, color.HiRedString("The account can't see any text channels, add it to a server first"))
+		return
+	}
+	for i, channel := range channels {
+		log.Println(color.CyanString("%3d) %s", i+1, channel.label))
+	}
+	var picked []setupChannel
+	for len(picked) == 0 {
+		picked = nil
+		valid := true
+		for _, field := range strings.FieldsFunc(reader.ask("Channels to download from, by number, e.g. 1,3,4", ""), func(r rune) bool { return r == ',' || r == ' ' }) {
+			number, err := strconv.Atoi(field)
+			if err != nil || number < 1 || number > len(channels) {
+				log.Println(logPrefixSetup, color.HiRedString("\"%s\" isn't one of the numbers listed", field))
+				valid = false
+				break
+			}
+			picked = append(picked, channels[number-1])
+		}
+		if !valid {
+			picked = nil
+		}
+	}
+	destination := reader.ask("Folder to download to", "downloads")
+
+	// Options
+	saveImages := reader.confirm("Save images?", ccdSaveImages)
+	saveVideos := reader.confirm("Save videos?", ccdSaveVideos)
+	divideByChannel := reader.confirm("Put each channel's files in its own folder?", true)
+	divideByUser := reader.confirm("Put each user's files in their own folder?", ccdDivideFoldersByUser)
+	react := reader.confirm("React to messages once their files are downloaded?", cdReactWhenDownloaded)
+	admin := ""
+	for {
+		admin = reader.ask("Your Discord user ID, for admin commands (blank to skip)", "")
+		if admin == "" || isNumeric(admin) {
+			break
+		}
+		log.Println(logPrefixSetup, color.HiRedString("That isn't a Discord user ID, they're only digits"))
+	}
+
+	newConfig := configuration{
+		Credentials:          credentials,
+		CommandPrefix:        cdCommandPrefix,
+		AllowSkipping:        cdAllowSkipping,
+		ScanOwnMessages:      cdScanOwnMessages,
+		PresenceEnabled:      cdPresenceEnabled,
+		PresenceStatus:       cdPresenceStatus,
+		PresenceType:         cdPresenceType,
+		ReactWhenDownloaded:  cdReactWhenDownloaded,
+		GithubUpdateChecking: cdGithubUpdateChecking,
+	}
+	if admin != "" {
+		newConfig.Admins = []string{admin}
+	}
+	ids := make([]string, len(picked))
+	for i, channel := range picked {
+		ids[i] = channel.id
+	}
+	entry := configurationChannel{
+		Destination:            destination,
+		SaveImages:             &saveImages,
+		SaveVideos:             &saveVideos,
+		DivideFoldersByChannel: &divideByChannel,
+		DivideFoldersByUser:    &divideByUser,
+		ReactWhenDownloaded:    &react,
+	}
+	if len(ids) == 1 {
+		entry.ChannelID = ids[0]
+	} else {
+		entry.ChannelIDs = &ids
+	}
+	newConfig.Channels = []configurationChannel{entry}
+
+	content, err := json.MarshalIndent(newConfig, "", "\t")
+	if err != nil {
+		log.Println(logPrefixSetup, color.HiRedString("Failed to format the settings...\t%s", err))
+		return
+	}
+	writeSetupConfig(content)
+}
+
+type setupChannel struct {
+	id, label string
+}
+
+// Text channels of every server the account is in, by server then position.
+func listSetupChannels(session *discordgo.Session) []setupChannel {
+	var channels []setupChannel
+	guilds, err := session.UserGuilds(100, "", "")
+	if err != nil {
+		log.Println(logPrefixSetup, color.HiRedString("Couldn't list servers:\t%s", err))
+		return nil
+	}
+	sort.Slice(guilds, func(i, j int) bool { return strings.ToLower(guilds[i].Name) < strings.ToLower(guilds[j].Name) })
+	for _, guild := range guilds {
+		guildChannels, err := session.GuildChannels(guild.ID)
+		if err != nil {
+			log.Println(logPrefixSetup, color.YellowString("Couldn't list the channels of \"%s\":\t%s", guild.Name, err))
+			continue
+		}
+		sort.Slice(guildChannels, func(i, j int) bool { return guildChannels[i].Position < guildChannels[j].Position })
+		for _, channel := range guildChannels {
+			if channel.Type == discordgo.ChannelTypeGuildText || channel.Type == discordgo.ChannelTypeGuildNews {
+				channels = append(channels, setupChannel{channel.ID, fmt.Sprintf("%s — #%s", guild.Name, channel.Name)})
+			}
+		}
+	}
+	return channels
+}
+
+// Writes the settings as settings.json, after checking they load, keeping whatever was there before as a backup.
+func writeSetupConfig(content []byte) {
+	configFileFormat, configFileC = "json", false
+	if _, err := parseConfig(content); err != nil {
+		log.Println(logPrefixSetup, color.HiRedString("The new settings don't load, nothing was written...\t%s", err))
+		return
+	}
+	if _, err := os.Stat(configFile); err == nil {
+		if err := os.Rename(configFile, configFile+".bak"); err != nil {
+			log.Println(logPrefixSetup, color.HiRedString("Couldn't back up \"%s\", nothing was written...\t%s", configFile, err))
+			return
+		}
+		log.Println(logPrefixSetup, color.YellowString("Kept the old settings as \"%s.bak\"", configFile))
+	}
+	configFile = configFileBase + ".json"
+	if err := ioutil.WriteFile(configFile, content, 0600); err != nil {
+		log.Println(logPrefixSetup, color.HiRedString("Failed to save \"%s\"...\t%s", configFile, err))
+		return
+	}
+	log.Println(logPrefixSetup, color.HiGreenString("Saved \"%s\", start the bot without -setup to begin downloading", configFile))
+	log.Println(logPrefixSetup, color.MagentaString("There are many more settings, see the README for them"))
+}
+
+// Renames upstream's settings in the file to these. Returns the settings as JSON and what was changed, nothing if
+// there was nothing to change.
+func migrateUpstreamConfig(content []byte) ([]byte, []string, error) {
+	var raw map[string]interface{}
+	if err := unmarshalConfig(content, &raw); err != nil {
+		return nil, nil, err
+	}
+	var changes []string
+	for upstream, name := range setupUpstreamRenames {
+		if value, exists := raw[upstream]; exists {
+			if _, taken := raw[name]; !taken {
+				raw[name] = value
+			}
+			delete(raw, upstream)
+			changes = append(changes, fmt.Sprintf("\"%s\" is \"%s\"", upstream, name))
+		}
+	}
+	// Upstream keeps channels and servers together
+	if sources, ok := raw["sources"].([]interface{}); ok {
+		channels, _ := raw["channels"].([]interface{})
+		servers, _ := raw["servers"].([]interface{})
+		for _, source := range sources {
+			if entry, ok := source.(map[string]interface{}); ok && (entry["server"] != nil || entry["servers"] != nil) {
+				servers = append(servers, source)
+			} else {
+				channels = append(channels, source)
+			}
+		}
+		raw["channels"], raw["servers"] = channels, servers
+		delete(raw, "sources")
+		changes = append(changes, fmt.Sprintf("\"sources\" is split into \"channels\" and \"servers\", %d and %d", len(channels), len(servers)))
+	}
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+	sort.Strings(changes)
+	for _, key := range getUnknownConfigKeys(raw) {
+		changes = append(changes, fmt.Sprintf("\"%s\" isn't a setting here and will be ignored", key))
+	}
+	migrated, err := json.MarshalIndent(raw, "", "\t")
+	return migrated, changes, err
+}
+
+// Top level keys the settings don't have.
+func getUnknownConfigKeys(raw map[string]interface{}) []string {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	var unknown []string
+	for key := range raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
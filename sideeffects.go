@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Reactions and presence updates share the session's rate limits with everything downloads need, so they're sent
+// from a queue of their own at reactionsPerMinute. History drops reactions once too many are waiting, and a
+// reaction that gets rate limited only holds up the reactions behind it.
+
+var logPrefixSideEffects = color.HiMagentaString("[Reactions]")
+
+const (
+	// History's reactions are dropped once this many are waiting
+	reactionQueueHistoryDepth = 25
+	// Anything past this is dropped, it'd be hours behind at the usual rate
+	reactionQueueSize = 500
+)
+
+type queuedReaction struct {
+	channelID string
+	messageID string
+	emoji     string
+}
+
+var (
+	reactionQueue   = make(chan queuedReaction, reactionQueueSize)
+	presenceQueued  = make(chan struct{}, 1) // updates queued while one's waiting are the same update
+	sideEffectsOnce sync.Once
+
+	// Set when Discord rate limits a reaction, the queue waits it out on top of the usual pace
+	reactionsLimitedUntil      time.Time
+	reactionsLimitedUntilMutex sync.Mutex
+
+	reactionsDropped      = make(map[string]int64) // by channel
+	reactionsDroppedMutex sync.Mutex
+)
+
+func startSideEffectQueue() {
+	sideEffectsOnce.Do(func() {
+		go func() {
+			for {
+				select {
+				case <-presenceQueued:
+					updateDiscordPresence()
+				case reaction := <-reactionQueue:
+					sendQueuedReaction(reaction)
+					time.Sleep(getReactionInterval())
+				}
+			}
+		}()
+	})
+}
+
+func getReactionInterval() time.Duration {
+	interval := time.Minute / time.Duration(getConfig().ReactionsPerMinute)
+	reactionsLimitedUntilMutex.Lock()
+	defer reactionsLimitedUntilMutex.Unlock()
+	if wait := time.Until(reactionsLimitedUntil); wait > interval {
+		return wait
+	}
+	return interval
+}
+
+// Queues a reaction to a message, returns false if it was dropped instead.
+func queueReaction(channelID string, messageID string, emoji string, history bool) bool {
+	if history && len(reactionQueue) >= reactionQueueHistoryDepth {
+		recordReactionDropped(channelID)
+		return false
+	}
+	select {
+	case reactionQueue <- queuedReaction{channelID, messageID, emoji}:
+		return true
+	default:
+		recordReactionDropped(channelID)
+		return false
+	}
+}
+
+func queuePresenceUpdate() {
+	select {
+	case presenceQueued <- struct{}{}:
+	default:
+	}
+}
+
+func sendQueuedReaction(reaction queuedReaction) {
+	if err := bot.MessageReactionAdd(reaction.channelID, reaction.messageID, reaction.emoji); err != nil {
+		log.Println(logPrefixSideEffects, color.RedString("Error adding reaction to message: %s", err))
+	}
+}
+
+// Handler for the session's rate limit events.
+func reactionRateLimited(_ *discordgo.Session, r *discordgo.RateLimit) {
+	if !strings.Contains(r.URL, "/reactions/") {
+		return
+	}
+	reactionsLimitedUntilMutex.Lock()
+	reactionsLimitedUntil = time.Now().Add(r.RetryAfter * time.Millisecond)
+	reactionsLimitedUntilMutex.Unlock()
+	if getConfig().DebugOutput {
+		log.Println(logPrefixDebug, logPrefixSideEffects, color.YellowString("Rate limited adding reactions, %d waiting", len(reactionQueue)))
+	}
+}
+
+func recordReactionDropped(channelID string) {
+	reactionsDroppedMutex.Lock()
+	defer reactionsDroppedMutex.Unlock()
+	reactionsDropped[channelID]++
+}
+
+// Returns and resets the channel's count.
+func takeReactionsDropped(channelID string) int64 {
+	reactionsDroppedMutex.Lock()
+	defer reactionsDroppedMutex.Unlock()
+	count := reactionsDropped[channelID]
+	delete(reactionsDropped, channelID)
+	return count
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Site handlers turn links to posts into links to their media. Each can be turned off or given its own
+// timeout with the "handlers" setting, links a handler is off for are downloaded as they are.
+
+const siteHandlerTimeoutDefault = 15 * time.Second
+
+type siteHandler struct {
+	name    string // key in settings.handlers
+	label   string // for logging failures
+	matches func(inputURL string) bool
+	fetch   func(inputURL string, channelID string, albumLimit int) (map[string]string, error)
+	quiet   []string // errors containing these aren't logged
+}
+
+// In the order they're tried, the first to find anything wins.
+var siteHandlers []siteHandler
+
+// Set in init, twitterStatus goes back through getDownloadLinks.
+func init() {
+	siteHandlers = []siteHandler{
+		{"twitter", "Twitter Media fetch", matchesRegex(&regexUrlTwitter),
+			func(u string, _ string, _ int) (map[string]string, error) { return getTwitterUrls(u) }, []string{"suspended"}},
+		{"twitterStatus", "Twitter Status fetch", matchesRegex(&regexUrlTwitterStatus),
+			func(u string, channelID string, _ int) (map[string]string, error) {
+				return getTwitterStatusUrls(u, channelID)
+			},
+			[]string{"suspended", "No status found"}},
+		{"instagram", "Instagram fetch", matchesRegex(&regexUrlInstagram),
+			func(u string, _ string, _ int) (map[string]string, error) { return getInstagramUrls(u) }, nil},
+		{"imgur", "Imgur Media fetch", matchesRegex(&regexUrlImgurSingle),
+			func(u string, _ string, _ int) (map[string]string, error) { return getImgurSingleUrls(u) }, nil},
+		{"imgurAlbum", "Imgur Album fetch", matchesRegex(&regexUrlImgurAlbum),
+			limitAlbum(getImgurAlbumUrls), nil},
+		{"streamable", "Streamable fetch", matchesRegex(&regexUrlStreamable),
+			func(u string, _ string, _ int) (map[string]string, error) { return getStreamableUrls(u) }, nil},
+		{"gfycat", "Gfycat fetch", matchesRegex(&regexUrlGfycat),
+			func(u string, _ string, _ int) (map[string]string, error) { return getGfycatUrls(u) }, nil},
+		{"flickr", "Flickr Photo fetch", matchesRegex(&regexUrlFlickrPhoto),
+			func(u string, _ string, _ int) (map[string]string, error) { return getFlickrPhotoUrls(u) }, []string{flickrErrorPrivateOrLimited}},
+		{"flickrAlbum", "Flickr Album fetch", matchesRegex(&regexUrlFlickrAlbum),
+			limitAlbum(getFlickrAlbumUrls), nil},
+		{"flickrAlbumShort", "Flickr Album (short) fetch", matchesRegex(&regexUrlFlickrAlbumShort),
+			limitAlbum(getFlickrAlbumShortUrls), nil},
+		{"flickrGallery", "Flickr Gallery fetch", matchesRegex(&regexUrlFlickrGallery),
+			limitAlbum(getFlickrGalleryUrls), nil},
+		{"flickrGroupPool", "Flickr Group Pool fetch", matchesRegex(&regexUrlFlickrGroupPool),
+			limitAlbum(getFlickrGroupPoolUrls), nil},
+		{"googleDrive", "Google Drive Album URL", func(u string) bool {
+			return getConfig().Credentials.GoogleDriveCredentialsJSON != "" && regexUrlGoogleDrive.MatchString(u)
+		}, func(u string, _ string, _ int) (map[string]string, error) { return getGoogleDriveUrls(u) }, nil},
+		{"googleDriveFolder", "Google Drive Folder URL", func(u string) bool {
+			return getConfig().Credentials.GoogleDriveCredentialsJSON != "" && regexUrlGoogleDriveFolder.MatchString(u)
+		}, limitAlbum(getGoogleDriveFolderUrls), nil},
+		{"tistory", "Tistory URL", matchesRegex(&regexUrlTistory),
+			func(u string, _ string, _ int) (map[string]string, error) { return getTistoryUrls(u) }, nil},
+		{"tistoryLegacy", "Legacy Tistory URL", matchesRegex(&regexUrlTistoryLegacy),
+			func(u string, _ string, _ int) (map[string]string, error) { return getLegacyTistoryUrls(u) }, nil},
+		{"reddit", "Reddit Post URL", matchesRegex(&regexUrlRedditPost),
+			limitAlbum(getRedditPostUrls), nil},
+		{"mastodon", "Mastodon Post URL", func(u string) bool {
+			return regexUrlMastodonPost1.MatchString(u) || regexUrlMastodonPost2.MatchString(u)
+		}, func(u string, _ string, _ int) (map[string]string, error) { return getMastodonPostUrls(u) }, nil},
+		{"tenor", "Tenor GIF fetch", matchesRegex(&regexUrlTenor),
+			func(u string, channelID string, _ int) (map[string]string, error) { return getTenorUrls(u, channelID) }, nil},
+		{"giphy", "Giphy GIF fetch", matchesRegex(&regexUrlGiphy),
+			func(u string, channelID string, _ int) (map[string]string, error) { return getGiphyUrls(u, channelID) }, nil},
+		// Requests nearly every link it doesn't recognise, the one most worth turning off
+		{"tistoryPossible", "Checking for Tistory site", matchesRegex(&regexUrlPossibleTistorySite),
+			func(u string, _ string, _ int) (map[string]string, error) { return getPossibleTistorySiteUrls(u) }, nil},
+	}
+}
+
+// The patterns are compiled after init, so they're looked up on each match.
+func matchesRegex(pattern **regexp.Regexp) func(string) bool {
+	return func(inputURL string) bool {
+		return (*pattern).MatchString(inputURL)
+	}
+}
+
+func getSiteHandlerNames() []string {
+	names := make([]string, len(siteHandlers))
+	for i, handler := range siteHandlers {
+		names[i] = handler.name
+	}
+	return names
+}
+
+func isSiteHandlerEnabled(name string) bool {
+	if handler, exists := getConfig().Handlers[name]; exists && handler.Enabled != nil {
+		return *handler.Enabled
+	}
+	return true
+}
+
+func getSiteHandlerTimeout(name string) time.Duration {
+	if handler, exists := getConfig().Handlers[name]; exists && handler.Timeout != "" {
+		if timeout, err := time.ParseDuration(handler.Timeout); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return siteHandlerTimeoutDefault
+}
+
+// Runs the handler with its deadline. Handlers can't be interrupted, one that runs over is left to
+// finish in the background and what it finds is thrown away.
+func runSiteHandler(handler siteHandler, inputURL string, channelID string, albumLimit int) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), getSiteHandlerTimeout(handler.name))
+	defer cancel()
+	type result struct {
+		links map[string]string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		links, err := handler.fetch(inputURL, channelID, albumLimit)
+		done <- result{links, err}
+	}()
+	select {
+	case r := <-done:
+		return r.links, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up after %s", getSiteHandlerTimeout(handler.name))
+	}
+}
+
+// Links from the first enabled handler matching the URL that finds anything, nil if none do.
+// The result can be cached unless no handler ran or one failed in a way that might not happen again.
+func matchSiteHandlers(handlers []siteHandler, inputURL string, channelID string, albumLimit int) (map[string]string, bool) {
+	ran, cacheable := false, true
+	logPrefixErrorHere := color.HiRedString("[getDownloadLinks]")
+	for _, handler := range handlers {
+		if !handler.matches(inputURL) {
+			continue
+		}
+		if !isSiteHandlerEnabled(handler.name) {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Handler %s is turned off, downloading %s as it is", handler.name, inputURL))
+			}
+			continue
+		}
+		ran = true
+		links, err := runSiteHandler(handler, inputURL, channelID, albumLimit)
+		if err != nil {
+			if !handler.isQuiet(err) {
+				log.Println(logPrefixErrorHere, color.RedString("%s failed for %s -- %s", handler.label, inputURL, err))
+				cacheable = false
+			}
+		} else if len(links) > 0 {
+			if stringInSlice(handler.name, htmlSiteHandlers) {
+				for link := range links {
+					htmlSiteLinks.Store(link, true)
+				}
+			}
+			return links, true
+		}
+	}
+	return nil, ran && cacheable
+}
+
+func (handler siteHandler) isQuiet(err error) bool {
+	for _, quiet := range handler.quiet {
+		if strings.Contains(err.Error(), quiet) {
+			return true
+		}
+	}
+	return false
+}
+
+//#region Cache
+
+// History runs come across the same posts over and over, what handlers found is kept for a while
+// so each one is only looked up once.
+
+const (
+	siteCacheDurationDefault = time.Hour
+	// Posts that were gone or had nothing are looked up again sooner
+	siteCacheNegativeDuration = 5 * time.Minute
+	siteCacheMaxEntries       = 10000
+)
+
+type siteCacheEntry struct {
+	links   map[string]string
+	stored  time.Time
+	expires time.Time
+}
+
+var (
+	siteCache      = make(map[string]siteCacheEntry)
+	siteCacheMutex sync.Mutex
+)
+
+// How long handler results are kept, 0 if they aren't.
+func getSiteCacheDuration() time.Duration {
+	settings := getConfig()
+	if settings.HandlerCacheDuration != "" {
+		if duration, err := time.ParseDuration(settings.HandlerCacheDuration); err == nil {
+			return duration
+		}
+	}
+	return siteCacheDurationDefault
+}
+
+func getCachedSiteLinks(inputURL string) (map[string]string, bool) {
+	siteCacheMutex.Lock()
+	defer siteCacheMutex.Unlock()
+	entry, exists := siteCache[inputURL]
+	if !exists {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(siteCache, inputURL)
+		return nil, false
+	}
+	return copyLinks(entry.links), true
+}
+
+func setCachedSiteLinks(inputURL string, links map[string]string) {
+	duration := getSiteCacheDuration()
+	if duration <= 0 {
+		return
+	}
+	if len(links) == 0 && duration > siteCacheNegativeDuration {
+		duration = siteCacheNegativeDuration
+	}
+	siteCacheMutex.Lock()
+	defer siteCacheMutex.Unlock()
+	if _, exists := siteCache[inputURL]; !exists && len(siteCache) >= siteCacheMaxEntries {
+		// Expired entries go first, then the oldest if that wasn't enough
+		var oldestURL string
+		var oldest time.Time
+		for cachedURL, entry := range siteCache {
+			if time.Now().After(entry.expires) {
+				delete(siteCache, cachedURL)
+			} else if oldestURL == "" || entry.stored.Before(oldest) {
+				oldestURL, oldest = cachedURL, entry.stored
+			}
+		}
+		if len(siteCache) >= siteCacheMaxEntries {
+			delete(siteCache, oldestURL)
+		}
+	}
+	siteCache[inputURL] = siteCacheEntry{copyLinks(links), time.Now(), time.Now().Add(duration)}
+}
+
+// Handlers through the cache, fresh skips reading it but still stores what's found.
+func matchSiteHandlersCached(inputURL string, channelID string, fresh bool, albumLimit int) map[string]string {
+	// Albums stop at the limit, so results are only shared between lookups with the same one
+	key := inputURL
+	if albumLimit > 0 {
+		key = fmt.Sprintf("%s (limit %d)", inputURL, albumLimit)
+	}
+	// Tenor and Giphy give the channel's gifFormat
+	if format := getGifFormat(channelID); format != ccdGifFormat && (regexUrlTenor.MatchString(inputURL) || regexUrlGiphy.MatchString(inputURL)) {
+		key = fmt.Sprintf("%s (%s)", key, format)
+	}
+	if !fresh {
+		if links, cached := getCachedSiteLinks(key); cached {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Using cached handler result for %s", inputURL))
+			}
+			return links
+		}
+	}
+	links, cacheable := matchSiteHandlers(siteHandlers, inputURL, channelID, albumLimit)
+	if cacheable {
+		setCachedSiteLinks(key, links)
+	}
+	return links
+}
+
+func copyLinks(links map[string]string) map[string]string {
+	if links == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(links))
+	for link, filename := range links {
+		copied[link] = filename
+	}
+	return copied
+}
+
+//#endregion
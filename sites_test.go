@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Handlers run against responses recorded from each site, kept under testdata/handlers. Every request, whatever its
+// host, goes to a local server answering from the fixtures, so nothing reaches the sites and a handler that stops
+// understanding a site's answers fails here rather than quietly downloading nothing.
+
+// Keys are the host and path requested, with any query parameters that have to match after a "?". Values are the
+// fixture to answer with, after a status code if it isn't 200, or where to for redirects. Anything else gets a 404.
+type fixtureRoutes map[string]string
+
+func (routes fixtureRoutes) find(r *http.Request) (int, string, bool) {
+	for route, fixture := range routes {
+		path, query := route, ""
+		if i := strings.Index(route, "?"); i >= 0 {
+			path, query = route[:i], route[i+1:]
+		}
+		if path != r.Host+r.URL.Path {
+			continue
+		}
+		wanted, _ := url.ParseQuery(query)
+		matches := true
+		for key := range wanted {
+			if r.URL.Query().Get(key) != wanted.Get(key) {
+				matches = false
+			}
+		}
+		if !matches {
+			continue
+		}
+		status := http.StatusOK
+		if parts := strings.SplitN(fixture, " ", 2); len(parts) == 2 {
+			status, _ = strconv.Atoi(parts[0])
+			fixture = parts[1]
+		}
+		return status, fixture, true
+	}
+	return 0, "", false
+}
+
+// Points every handler request at a server answering from routes until the test ends.
+func serveFixtures(t *testing.T, routes fixtureRoutes) {
+	t.Helper()
+	serveTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, fixture, found := routes.find(r)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		if status >= 300 && status < 400 {
+			http.Redirect(w, r, fixture, status)
+			return
+		}
+		body, err := ioutil.ReadFile(filepath.Join("testdata", "handlers", fixture))
+		if err != nil {
+			t.Errorf("Missing fixture %s: %s", fixture, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if strings.HasSuffix(fixture, ".html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+}
+
+// Sends every request made through the shared transports to handler until the test ends, whatever its host.
+func serveTestHandler(t *testing.T, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+		// The server's certificate is for itself, not the hosts asked for
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	httpTransportsMutex.Lock()
+	previous := httpTransports
+	httpTransports = map[string]*http.Transport{"": transport}
+	httpTransportsMutex.Unlock()
+
+	t.Cleanup(func() {
+		httpTransportsMutex.Lock()
+		httpTransports = previous
+		httpTransportsMutex.Unlock()
+		server.Close()
+	})
+}
+
+func getSiteHandler(t *testing.T, name string) siteHandler {
+	t.Helper()
+	for _, handler := range siteHandlers {
+		if handler.name == name {
+			return handler
+		}
+	}
+	t.Fatalf("No site handler named %s", name)
+	return siteHandler{}
+}
+
+func TestSiteHandlers(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) { settings.Credentials.FlickrApiKey = "test" })
+
+	tests := []struct {
+		name     string
+		handler  string
+		inputURL string
+		routes   fixtureRoutes
+		want     map[string]string
+		post     sitePost // what every link is recorded as coming from, for divideFoldersByArtist and the post tokens
+		wantErr  string   // part of the error, "" for none
+	}{
+		// Single images
+		{
+			name:     "imgur single",
+			handler:  "imgur",
+			inputURL: "https://imgur.com/q1w2e3",
+			want:     map[string]string{"https://imgur.com/download/q1w2e3": ""},
+		},
+		{
+			name:     "flickr photo",
+			handler:  "flickr",
+			inputURL: "https://www.flickr.com/photos/12345678@N00/5678",
+			routes: fixtureRoutes{
+				"www.flickr.com/services/rest/?method=flickr.photos.getSizes&photo_id=5678": "flickr/sizes.json",
+				"www.flickr.com/services/rest/?method=flickr.photos.getInfo&photo_id=5678":  "flickr/info.json",
+			},
+			want: map[string]string{"https://live.staticflickr.com/65535/5678_d4e5f6_o.jpg": "Sunset-over-the-bay 5678.jpg"},
+		},
+		{
+			name:     "reddit image",
+			handler:  "reddit",
+			inputURL: "https://www.reddit.com/r/aww/comments/def456/my_cat",
+			routes:   fixtureRoutes{"www.reddit.com/r/aww/comments/def456/my_cat.json": "reddit/image.json"},
+			want:     map[string]string{"https://i.redd.it/q1w2e3r4.jpg": "Reddit-aww_def456 q1w2e3r4.jpg"},
+			post:     sitePost{Artist: "poster", PostID: "def456", PostTitle: "My cat"},
+		},
+		{
+			name:     "instagram picture",
+			handler:  "instagram",
+			inputURL: "https://www.instagram.com/p/PiCtUrE/",
+			routes:   fixtureRoutes{"www.instagram.com/p/PiCtUrE/": "instagram/picture.html"},
+			want:     map[string]string{"https://www.instagram.com/p/PiCtUrE/media/?size=l": "instagram painter - PiCtUrE.jpg"},
+			post:     sitePost{Artist: "painter", PostID: "PiCtUrE"},
+		},
+		{
+			name:     "giphy without an API key",
+			handler:  "giphy",
+			inputURL: "https://giphy.com/gifs/cat-abcDEF123",
+			want:     map[string]string{"https://media.giphy.com/media/abcDEF123/giphy.gif": "Giphy-abcDEF123.gif"},
+		},
+
+		// Albums
+		{
+			name:     "imgur album",
+			handler:  "imgurAlbum",
+			inputURL: "https://imgur.com/a/XyZ12",
+			routes:   fixtureRoutes{"api.imgur.com/3/album/XyZ12/images": "imgur/album.json"},
+			want: map[string]string{
+				"https://i.imgur.com/one.jpg":   "",
+				"https://i.imgur.com/two.png":   "",
+				"https://i.imgur.com/three.mp4": "",
+			},
+		},
+		{
+			name:     "flickr album",
+			handler:  "flickrAlbum",
+			inputURL: "https://www.flickr.com/photos/someone/albums/72157",
+			routes: fixtureRoutes{
+				"www.flickr.com/services/rest/?method=flickr.photosets.getPhotos&photoset_id=72157&page=1": "flickr/album.json",
+			},
+			want: map[string]string{
+				"https://live.staticflickr.com/65535/111_aaa_o.jpg": "First-light 111.jpg",
+				"https://live.staticflickr.com/65535/222_ccc_k.jpg": "222.jpg",
+				"https://live.staticflickr.com/65535/333_ddd_b.jpg": "Harbour 333.jpg",
+			},
+		},
+		{
+			name:     "reddit gallery",
+			handler:  "reddit",
+			inputURL: "https://www.reddit.com/r/pics/comments/abc123/a_gallery",
+			routes:   fixtureRoutes{"www.reddit.com/r/pics/comments/abc123/a_gallery.json": "reddit/gallery.json"},
+			want: map[string]string{
+				"https://i.redd.it/m1abc.jpg": "Reddit-pics_abc123 m1abc.jpg",
+				"https://i.redd.it/m2def.png": "Reddit-pics_abc123 m2def.png",
+				"https://i.redd.it/m3ghi.jpg": "Reddit-pics_abc123 m3ghi.jpg",
+			},
+			post: sitePost{Artist: "someone", PostID: "abc123", PostTitle: "A gallery"},
+		},
+		{
+			name:     "instagram album",
+			handler:  "instagram",
+			inputURL: "https://www.instagram.com/p/AlBuM1/",
+			routes:   fixtureRoutes{"www.instagram.com/p/AlBuM1/": "instagram/album.html"},
+			want: map[string]string{
+				"https://scontent.cdninstagram.com/v/first.jpg":  "instagram artist - AlBuM1 1.jpg",
+				"https://scontent.cdninstagram.com/v/second.jpg": "instagram artist - AlBuM1 2.jpg",
+			},
+			post: sitePost{Artist: "artist", PostID: "AlBuM1"},
+		},
+		{
+			name:     "mastodon post",
+			handler:  "mastodon",
+			inputURL: "https://mastodon.social/@artist/109876543210",
+			routes:   fixtureRoutes{"mastodon.social/@artist/109876543210.json": "mastodon/post.json"},
+			want: map[string]string{
+				"https://files.mastodon.social/media_attachments/files/109/876/543/original/a1b2c3.png": "",
+				"https://files.mastodon.social/media_attachments/files/109/876/544/original/d4e5f6.mp4": "",
+			},
+			post: sitePost{Artist: "artist", PostID: "109876543210"},
+		},
+
+		// Videos
+		{
+			name:     "streamable",
+			handler:  "streamable",
+			inputURL: "https://streamable.com/abc12",
+			routes:   fixtureRoutes{"api.streamable.com/videos/abc12": "streamable/video.json"},
+			want:     map[string]string{"https://cdn-cf-east.streamable.com/video/mp4/abc12.mp4?Expires=1700000000": ""},
+		},
+		{
+			name:     "tenor",
+			handler:  "tenor",
+			inputURL: "https://tenor.com/view/cat-dance-gif-12345",
+			routes:   fixtureRoutes{"tenor.com/view/cat-dance-gif-12345": "tenor/view.html"},
+			want:     map[string]string{"https://media.tenor.com/AbCdEfGhIjKAAAAC/cat-dance.gif": ""},
+		},
+		{
+			name:     "instagram video",
+			handler:  "instagram",
+			inputURL: "https://www.instagram.com/p/ViDeO1/",
+			routes:   fixtureRoutes{"www.instagram.com/p/ViDeO1/": "instagram/video.html"},
+			want:     map[string]string{"https://scontent.cdninstagram.com/v/clip.mp4": "instagram filmer - ViDeO1.mp4"},
+			post:     sitePost{Artist: "filmer", PostID: "ViDeO1"},
+		},
+
+		// Deleted
+		{
+			name:     "imgur album gone",
+			handler:  "imgurAlbum",
+			inputURL: "https://imgur.com/a/Gone1",
+			routes:   fixtureRoutes{"api.imgur.com/3/album/Gone1/images": "404 imgur/album_gone.json"},
+			// Downloaded as a single image instead
+			want: map[string]string{"https://imgur.com/download/a/Gone1": ""},
+		},
+		{
+			name:     "streamable gone",
+			handler:  "streamable",
+			inputURL: "https://streamable.com/gone9",
+			routes:   fixtureRoutes{"api.streamable.com/videos/gone9": "404 streamable/gone.json"},
+			wantErr:  "no download candidate",
+		},
+		{
+			name:     "reddit deleted",
+			handler:  "reddit",
+			inputURL: "https://www.reddit.com/r/pics/comments/ghi789/deleted",
+			routes:   fixtureRoutes{"www.reddit.com/r/pics/comments/ghi789/deleted.json": "reddit/deleted.json"},
+			want:     nil,
+		},
+		{
+			name:     "tenor gone",
+			handler:  "tenor",
+			inputURL: "https://tenor.com/view/gone-gif-404",
+			wantErr:  "Tenor answered 404",
+		},
+		{
+			name:     "flickr private",
+			handler:  "flickr",
+			inputURL: "https://www.flickr.com/photos/12345678@N00/9999",
+			routes:   fixtureRoutes{"www.flickr.com/services/rest/?method=flickr.photos.getSizes&photo_id=9999": "flickr/private.json"},
+			wantErr:  flickrErrorPrivateOrLimited,
+		},
+
+		// Suspended
+		{
+			name:     "mastodon suspended",
+			handler:  "mastodon",
+			inputURL: "https://mastodon.social/@banned/109000000000",
+			routes:   fixtureRoutes{"mastodon.social/@banned/109000000000.json": "410 mastodon/suspended.json"},
+			wantErr:  "suspended",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serveFixtures(t, test.routes)
+			handler := getSiteHandler(t, test.handler)
+			if !handler.matches(test.inputURL) {
+				t.Fatalf("%s doesn't match %s", test.handler, test.inputURL)
+			}
+			links, err := handler.fetch(test.inputURL, "", 0)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+			if len(links) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(links, test.want) {
+				t.Errorf("Got %v, want %v", links, test.want)
+			}
+			for link := range links {
+				if post := getSitePost(link); post != test.post {
+					t.Errorf("%s is recorded as from %+v, want %+v", link, post, test.post)
+				}
+			}
+		})
+	}
+}
+
+// Albums stop at the limit and say how many they left out, limitAlbum logs it.
+func TestSiteHandlerAlbumLimits(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) { settings.Credentials.FlickrApiKey = "test" })
+
+	serveFixtures(t, fixtureRoutes{
+		"api.imgur.com/3/album/XyZ12/images": "imgur/album.json",
+		"www.flickr.com/services/rest/?method=flickr.photosets.getPhotos&photoset_id=72157&page=1&per_page=2": "flickr/album.json",
+		"www.reddit.com/r/pics/comments/abc123/a_gallery.json":                                                "reddit/gallery.json",
+	})
+	tests := []struct {
+		name        string
+		fetch       func(inputURL string, limit int) (map[string]string, int, error)
+		inputURL    string
+		wantLinks   int
+		wantSkipped int
+	}{
+		{"imgur", getImgurAlbumUrls, "https://imgur.com/a/XyZ12", 2, 1},
+		{"flickr", getFlickrAlbumUrls, "https://www.flickr.com/photos/someone/albums/72157", 2, 1},
+		{"reddit", getRedditPostUrls, "https://www.reddit.com/r/pics/comments/abc123/a_gallery", 2, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			links, skipped, err := test.fetch(test.inputURL, 2)
+			if err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+			if len(links) != test.wantLinks || skipped != test.wantSkipped {
+				t.Errorf("Got %d links and %d skipped, want %d and %d", len(links), skipped, test.wantLinks, test.wantSkipped)
+			}
+		})
+	}
+}
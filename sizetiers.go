@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+)
+
+// With largeFileDestination, files over largeFileThreshold are saved under it instead, with the same subfolders and
+// filenames they'd have had under the usual destination. Either root can already have a file, wherever the threshold
+// was when it was saved, so both are checked before saving.
+
+// The channel's largeFileDestination filled in for the message, "" if it doesn't have one.
+func getLargeFileRoot(channelConfig configurationChannel, download downloadRequestStruct) string {
+	if channelConfig.LargeFileDestination == nil || *channelConfig.LargeFileDestination == "" || download.Message == nil {
+		return ""
+	}
+	return resolveDestination(*channelConfig.LargeFileDestination, download.Message)
+}
+
+func isLargeFile(channelConfig configurationChannel, size int64) bool {
+	threshold, err := parseByteSize(*channelConfig.LargeFileThreshold)
+	if err != nil {
+		threshold, _ = parseByteSize(ccdLargeFileThreshold)
+	}
+	return size > threshold
+}
+
+// Where path, under root, would be under otherRoot.
+func getTierCounterpart(path string, root string, otherRoot string) string {
+	relative := strings.TrimPrefix(path, root)
+	if otherSeparator := getPathSeparator(otherRoot); otherSeparator != getPathSeparator(root) {
+		relative = strings.ReplaceAll(relative, getPathSeparator(root), otherSeparator)
+	}
+	return strings.TrimRight(otherRoot, `/\`) + getPathSeparator(otherRoot) + strings.TrimLeft(relative, `/\`)
+}
+
+func getPathSeparator(path string) string {
+	if storage, err := getStorageBackend(path); err == nil {
+		return storage.separator()
+	}
+	return "/"
+}
+
+// Checks for a file at path, then at the same place under otherRoot if there is one. Returns the storage and path of
+// the one that exists, or of path if neither does.
+func findTieredFile(storage storageBackend, path string, root string, otherRoot string) (storageBackend, string, bool, error) {
+	exists, err := storage.exists(path)
+	if err != nil || exists || otherRoot == "" {
+		return storage, path, exists, err
+	}
+	counterpart := getTierCounterpart(path, root, otherRoot)
+	otherStorage, err := getStorageBackend(counterpart)
+	if err != nil {
+		return storage, path, false, err
+	}
+	if exists, err = otherStorage.exists(counterpart); err != nil || !exists {
+		return storage, path, false, err
+	}
+	return otherStorage, counterpart, true, nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Snapshots record what each server and its channels were called, and the settings its registered channels were
+// downloaded with, so folders of IDs still make sense years later. They're written as JSON into every local
+// destination root the server downloads to, under _snapshots/<server ID>, with its icon and banner alongside. With
+// the snapshots setting a new one is taken every interval and whenever a name changes, keeping the last few, and
+// the snapshot command takes one any time. Destinations that can't be written to are logged and left alone.
+
+var logPrefixSnapshots = color.HiCyanString("[Snapshots]")
+
+const (
+	snapshotFolder     = "_snapshots"
+	snapshotTimeFormat = "2006-01-02_15-04-05"
+	// How often snapshots are checked for being due
+	snapshotCheckInterval = time.Hour
+	// Longest a destination may take to be written to, so a hung mount only holds up its own snapshots
+	snapshotWriteTimeout = 2 * time.Minute
+)
+
+type guildSnapshot struct {
+	Taken      time.Time
+	GuildID    string
+	Name       string
+	IconFile   string `json:",omitempty"` // saved next to the snapshot
+	BannerFile string `json:",omitempty"`
+	Channels   []channelSnapshot
+}
+
+type channelSnapshot struct {
+	ID       string
+	Name     string
+	Topic    string `json:",omitempty"`
+	Type     string
+	Category string `json:",omitempty"`
+	Position int
+	NSFW     bool `json:",omitempty"`
+	// The registered channel's settings in effect when the snapshot was taken
+	Settings *configurationChannel `json:",omitempty"`
+}
+
+var (
+	snapshotLast      = make(map[string]*guildSnapshot) // by guild, the last one taken or found on disk
+	snapshotWriting   = make(map[string]bool)           // by destination root, still being written to
+	snapshotMutex     sync.Mutex
+	snapshotGuildLock sync.Map // by guild, one snapshot at a time
+	snapshotsOnce     sync.Once
+)
+
+// Local destination roots each server downloads to.
+func getSnapshotTargets() map[string][]string {
+	settings := getConfig()
+	targets := make(map[string][]string)
+	add := func(guildID string, destination string) {
+		if guildID == "" || strings.TrimSpace(destination) == "" || isRemoteDestination(destination) {
+			return
+		}
+		root := filepath.Clean(getDestinationRoot(getConfig().BasePath, destination))
+		if !stringInSlice(root, targets[guildID]) {
+			targets[guildID] = append(targets[guildID], root)
+		}
+	}
+	for _, item := range settings.Channels {
+		ids := []string{item.ChannelID}
+		if item.ChannelIDs != nil {
+			ids = *item.ChannelIDs
+		}
+		for _, id := range ids {
+			add(getChannelGuildID(id), item.Destination)
+		}
+	}
+	for _, item := range settings.Servers {
+		ids := []string{item.ServerID}
+		if item.ServerIDs != nil {
+			ids = *item.ServerIDs
+		}
+		for _, id := range ids {
+			add(id, item.Destination)
+		}
+	}
+	if settings.All != nil {
+		for _, guild := range bot.State.Guilds {
+			if settings.AllBlacklistServers == nil || !stringInSlice(guild.ID, *settings.AllBlacklistServers) {
+				add(guild.ID, settings.All.Destination)
+			}
+		}
+	}
+	return targets
+}
+
+func getChannelTypeName(channelType discordgo.ChannelType) string {
+	switch channelType {
+	case discordgo.ChannelTypeGuildText:
+		return "text"
+	case discordgo.ChannelTypeGuildVoice:
+		return "voice"
+	case discordgo.ChannelTypeGuildCategory:
+		return "category"
+	case discordgo.ChannelTypeGuildNews:
+		return "news"
+	case discordgo.ChannelTypeGuildStore:
+		return "store"
+	}
+	return fmt.Sprint(int(channelType))
+}
+
+// The server as it is now, from the state.
+func takeGuildSnapshot(guildID string) (*guildSnapshot, error) {
+	guild, err := bot.State.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &guildSnapshot{Taken: time.Now(), GuildID: guild.ID, Name: guild.Name}
+	categories := make(map[string]string)
+	for _, channel := range guild.Channels {
+		if channel.Type == discordgo.ChannelTypeGuildCategory {
+			categories[channel.ID] = channel.Name
+		}
+	}
+	for _, channel := range guild.Channels {
+		item := channelSnapshot{
+			ID:       channel.ID,
+			Name:     channel.Name,
+			Topic:    channel.Topic,
+			Type:     getChannelTypeName(channel.Type),
+			Category: categories[channel.ParentID],
+			Position: channel.Position,
+			NSFW:     channel.NSFW,
+		}
+		if isChannelRegistered(channel.ID) {
+			settings := getChannelConfig(channel.ID)
+			item.Settings = &settings
+		}
+		snapshot.Channels = append(snapshot.Channels, item)
+	}
+	sort.Slice(snapshot.Channels, func(i, j int) bool {
+		a, b := snapshot.Channels[i], snapshot.Channels[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.ID < b.ID
+	})
+	if guild.Icon != "" {
+		snapshot.IconFile = getSnapshotImageName("icon", guild.Icon)
+	}
+	if guild.Banner != "" {
+		snapshot.BannerFile = getSnapshotImageName("banner", guild.Banner)
+	}
+	return snapshot, nil
+}
+
+func getSnapshotImageName(kind string, hash string) string {
+	if strings.HasPrefix(hash, "a_") {
+		return kind + " " + hash + ".gif"
+	}
+	return kind + " " + hash + ".png"
+}
+
+// What a rename changes, the server's name and its channels' names and categories.
+func (s *guildSnapshot) names() string {
+	names := []string{s.Name}
+	for _, channel := range s.Channels {
+		names = append(names, channel.ID+"\t"+channel.Name+"\t"+channel.Category)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n")
+}
+
+// The newest snapshot in the folder, nil if there's none or it can't be read.
+func readLatestSnapshot(folder string) *guildSnapshot {
+	files, _ := filepath.Glob(filepath.Join(folder, "*.json"))
+	if len(files) == 0 {
+		return nil
+	}
+	sort.Strings(files)
+	data, err := ioutil.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil
+	}
+	var snapshot guildSnapshot
+	if json.Unmarshal(data, &snapshot) != nil {
+		return nil
+	}
+	return &snapshot
+}
+
+// Takes a snapshot of the server and writes it to each of its destinations, if forced, if the last one is older
+// than the interval or if anything was renamed since. Returns the roots written to.
+func refreshGuildSnapshot(guildID string, roots []string, force bool) ([]string, error) {
+	lock, _ := snapshotGuildLock.LoadOrStore(guildID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	snapshot, err := takeGuildSnapshot(guildID)
+	if err != nil {
+		return nil, err
+	}
+	snapshotMutex.Lock()
+	last := snapshotLast[guildID]
+	snapshotMutex.Unlock()
+	if last == nil {
+		for _, root := range roots {
+			if last = readLatestSnapshot(filepath.Join(root, snapshotFolder, guildID)); last != nil {
+				break
+			}
+		}
+	}
+	settings := getSnapshotSettings()
+	interval, _ := time.ParseDuration(settings.Interval)
+	if !force && last != nil && time.Since(last.Taken) < interval && last.names() == snapshot.names() {
+		snapshotMutex.Lock()
+		snapshotLast[guildID] = last
+		snapshotMutex.Unlock()
+		return nil, nil
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, root := range roots {
+		if err := writeSnapshotWithTimeout(root, snapshot, data, settings.Keep); err != nil {
+			log.Println(logPrefixSnapshots, color.YellowString("Couldn't write the snapshot of \"%s\" to \"%s\":\t%s", snapshot.Name, root, err))
+			continue
+		}
+		written = append(written, root)
+	}
+	if len(written) > 0 {
+		snapshotMutex.Lock()
+		snapshotLast[guildID] = snapshot
+		snapshotMutex.Unlock()
+		log.Println(logPrefixSnapshots, color.HiGreenString("Saved a snapshot of \"%s\" to %d destination%s", snapshot.Name, len(written), pluralS(len(written))))
+	}
+	return written, nil
+}
+
+// Gives up on a root that takes too long, like a hung network mount, and skips it until that write finishes.
+func writeSnapshotWithTimeout(root string, snapshot *guildSnapshot, data []byte, keep int) error {
+	snapshotMutex.Lock()
+	if snapshotWriting[root] {
+		snapshotMutex.Unlock()
+		return fmt.Errorf("still waiting on an earlier write")
+	}
+	snapshotWriting[root] = true
+	snapshotMutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			snapshotMutex.Lock()
+			delete(snapshotWriting, root)
+			snapshotMutex.Unlock()
+		}()
+		done <- writeSnapshot(root, snapshot, data, keep)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(snapshotWriteTimeout):
+		return fmt.Errorf("timed out after %s", snapshotWriteTimeout)
+	}
+}
+
+func writeSnapshot(root string, snapshot *guildSnapshot, data []byte, keep int) error {
+	// A mount that's gone shouldn't have the folders made on whatever's underneath
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+	folder := filepath.Join(root, snapshotFolder, snapshot.GuildID)
+	if err := writeFileAtomic(filepath.Join(folder, snapshot.Taken.Format(snapshotTimeFormat)+".json"), data); err != nil {
+		return err
+	}
+	images := map[string]string{}
+	if snapshot.IconFile != "" {
+		if guild, err := bot.State.Guild(snapshot.GuildID); err == nil {
+			images[snapshot.IconFile] = guild.IconURL()
+		}
+	}
+	if snapshot.BannerFile != "" {
+		hash := strings.TrimSuffix(strings.TrimPrefix(snapshot.BannerFile, "banner "), filepath.Ext(snapshot.BannerFile))
+		images[snapshot.BannerFile] = discordgo.EndpointGuildBanner(snapshot.GuildID, hash)
+	}
+	for name, imageURL := range images {
+		path := filepath.Join(folder, name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if strings.HasSuffix(name, ".gif") {
+			imageURL = strings.TrimSuffix(imageURL, ".png") + ".gif"
+		}
+		if err := saveSnapshotImage(imageURL+"?size=4096", path); err != nil {
+			log.Println(logPrefixSnapshots, color.YellowString("Couldn't save the %s of \"%s\":\t%s", strings.Fields(name)[0], snapshot.Name, err))
+		}
+	}
+	pruneSnapshots(folder, keep)
+	return nil
+}
+
+func saveSnapshotImage(imageURL string, path string) error {
+	response, err := getHTTPClient(imageURL).Get(imageURL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", imageURL, response.Status)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// Deletes all but the newest snapshots, and icons and banners none of the rest refer to.
+func pruneSnapshots(folder string, keep int) {
+	files, _ := filepath.Glob(filepath.Join(folder, "*.json"))
+	sort.Strings(files)
+	if len(files) > keep {
+		for _, file := range files[:len(files)-keep] {
+			if err := os.Remove(file); err != nil {
+				log.Println(logPrefixSnapshots, color.YellowString("Couldn't delete old snapshot \"%s\":\t%s", file, err))
+			}
+		}
+		files = files[len(files)-keep:]
+	}
+	referenced := make(map[string]bool)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return // can't tell what's still used
+		}
+		var snapshot guildSnapshot
+		if json.Unmarshal(data, &snapshot) != nil {
+			return
+		}
+		referenced[snapshot.IconFile] = true
+		referenced[snapshot.BannerFile] = true
+	}
+	for _, pattern := range []string{"icon *", "banner *"} {
+		images, _ := filepath.Glob(filepath.Join(folder, pattern))
+		for _, image := range images {
+			if !referenced[filepath.Base(image)] {
+				os.Remove(image)
+			}
+		}
+	}
+}
+
+// The snapshots setting, or its defaults for the command when it isn't set.
+func getSnapshotSettings() configurationSnapshots {
+	if getConfig().Snapshots != nil {
+		return *getConfig().Snapshots
+	}
+	settings := configurationSnapshots{}
+	snapshotsDefault(&settings)
+	return settings
+}
+
+// Refreshes every server's snapshots that are due, or all of them if forced. Returns how many servers were written
+// and how many couldn't be.
+func refreshSnapshots(force bool) (int, int) {
+	written, failed := 0, 0
+	for guildID, roots := range getSnapshotTargets() {
+		saved, err := refreshGuildSnapshot(guildID, roots, force)
+		if err != nil {
+			log.Println(logPrefixSnapshots, color.YellowString("Couldn't take a snapshot of server %s:\t%s", guildID, err))
+		}
+		if len(saved) > 0 {
+			written++
+		} else if err != nil || force {
+			failed++
+		}
+	}
+	return written, failed
+}
+
+// Checks for due snapshots at launch and every hour after, with the snapshots setting.
+func startSnapshots() {
+	if getConfig().Snapshots == nil {
+		return
+	}
+	snapshotsOnce.Do(func() {
+		go func() {
+			for {
+				if getConfig().Snapshots != nil {
+					refreshSnapshots(false)
+				}
+				time.Sleep(snapshotCheckInterval)
+			}
+		}()
+	})
+}
+
+//#region Events
+
+// Renames are picked up as they happen, the state already has the new names.
+func snapshotChannelUpdate(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+	if getConfig().Snapshots != nil && c.Channel != nil && c.GuildID != "" {
+		go refreshSnapshotsOf(c.GuildID)
+	}
+}
+
+func snapshotGuildUpdate(s *discordgo.Session, g *discordgo.GuildUpdate) {
+	if getConfig().Snapshots != nil && g.Guild != nil {
+		go refreshSnapshotsOf(g.ID)
+	}
+}
+
+func refreshSnapshotsOf(guildID string) {
+	if roots := getSnapshotTargets()[guildID]; len(roots) > 0 {
+		if _, err := refreshGuildSnapshot(guildID, roots, false); err != nil {
+			log.Println(logPrefixSnapshots, color.YellowString("Couldn't take a snapshot of server %s:\t%s", guildID, err))
+		}
+	}
+}
+
+//#endregion
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Sites answer with a 200 and a login wall or "not found" page instead of the file often enough that the
+// page would otherwise be saved in its place. These are skipped as soft failures.
+
+// Matched against HTML pages ignoring case, in addition to softFailurePhrases. Text files are left alone,
+// they could say anything.
+var softFailurePhrases = []string{
+	"log in to continue",
+	"login to continue",
+	"sign in to continue",
+	"this content isn't available",
+	"this content is not available",
+	"this page isn't available",
+	"page not found",
+	"content not found",
+}
+
+// Handlers whose links are pages meant to be saved as they are, never taken for soft failures. None yet.
+var htmlSiteHandlers = []string{}
+
+// Links htmlSiteHandlers found
+var htmlSiteLinks sync.Map
+
+func isHTMLExpected(inputURL string) bool {
+	_, expected := htmlSiteLinks.Load(inputURL)
+	return expected
+}
+
+// "image" or "video" if that's what the extension says the file is, "" if it doesn't say.
+func getExpectedContentType(extension string) string {
+	expected := fixContentType(extension, "")
+	if expected == "" {
+		expected = strings.Split(mime.TypeByExtension(extension), "/")[0]
+	}
+	if expected == "image" || expected == "video" {
+		return expected
+	}
+	return ""
+}
+
+// Why a response looks like a page standing in for the file, "" if it doesn't.
+func detectSoftFailure(inputURL string, extension string, contentType string, body []byte) string {
+	if !strings.HasPrefix(contentType, "text/html") || isHTMLExpected(inputURL) {
+		return ""
+	}
+	if expected := getExpectedContentType(extension); expected != "" {
+		return "expected " + expected + ", got an HTML page"
+	}
+	text := strings.ToLower(string(body))
+	for _, phrase := range append(softFailurePhrases, getConfig().SoftFailurePhrases...) {
+		if phrase != "" && strings.Contains(text, strings.ToLower(phrase)) {
+			return "page says \"" + phrase + "\""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Figures for the status command are counted as things happen, or refreshed in the background when
+// they'd need the disk, so the command never has to wait on either.
+
+// How often the database size and free space of destinations are checked
+const statusGaugeInterval = time.Minute
+
+//#region Session Counters
+
+var (
+	sessionStatusCounts      = make(map[downloadStatus]int64)
+	sessionStatusCountsMutex sync.Mutex
+
+	// Rows in the downloads database, counted at launch and on every insert.
+	dbRowCount int64 // atomic
+)
+
+func recordSessionStatus(status downloadStatus) {
+	sessionStatusCountsMutex.Lock()
+	defer sessionStatusCountsMutex.Unlock()
+	sessionStatusCounts[status]++
+}
+
+func getSessionStatusCounts() map[downloadStatus]int64 {
+	sessionStatusCountsMutex.Lock()
+	defer sessionStatusCountsMutex.Unlock()
+	counts := make(map[downloadStatus]int64, len(sessionStatusCounts))
+	for status, count := range sessionStatusCounts {
+		counts[status] = count
+	}
+	return counts
+}
+
+//#endregion
+
+//#region Gauges
+
+type destinationSpace struct {
+	path string
+	free int64 // bytes, -1 if it couldn't be read
+}
+
+var (
+	statusGauges struct {
+		sync.Mutex
+		updated      time.Time
+		databaseSize int64
+		destinations []destinationSpace
+	}
+	statusGaugesOnce sync.Once
+)
+
+func startStatusGauges() {
+	statusGaugesOnce.Do(func() {
+		go func() {
+			for {
+				refreshStatusGauges()
+				time.Sleep(statusGaugeInterval)
+			}
+		}()
+	})
+}
+
+func refreshStatusGauges() {
+	paths := getLocalDestinations()
+
+	var databaseSize int64
+	filepath.Walk(databasePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			databaseSize += info.Size()
+		}
+		return nil
+	})
+
+	destinations := make([]destinationSpace, 0, len(paths))
+	for _, path := range paths {
+		free, err := getDiskFreeSpace(getExistingParent(path))
+		if err != nil {
+			free = -1
+		}
+		destinations = append(destinations, destinationSpace{path, free})
+	}
+
+	statusGauges.Lock()
+	defer statusGauges.Unlock()
+	statusGauges.updated = time.Now()
+	statusGauges.databaseSize = databaseSize
+	statusGauges.destinations = destinations
+}
+
+// Local destinations across every registration, plus manual download folders.
+func getLocalDestinations() []string {
+	settings := getConfig()
+	var paths []string
+	add := func(path string) {
+		if strings.TrimSpace(path) == "" || isRemoteDestination(path) {
+			return
+		}
+		path = filepath.Clean(getDestinationRoot(getConfig().BasePath, path))
+		if !stringInSlice(path, paths) {
+			paths = append(paths, path)
+		}
+	}
+	if settings.All != nil {
+		add(settings.All.Destination)
+	}
+	for _, item := range settings.Servers {
+		add(item.Destination)
+	}
+	for _, item := range settings.Channels {
+		add(item.Destination)
+	}
+	if _, exists := settings.ManualDestinations["default"]; !exists {
+		add(manualDestinationDefault)
+	}
+	for _, path := range settings.ManualDestinations {
+		add(path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Destinations are created on first download, free space is read from the closest folder that exists.
+func getExistingParent(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+//#endregion
+
+//#region Status Snapshot
+
+type statusSnapshot struct {
+	inProgress     int64
+	connected      int
+	autoHistory    int
+	statusCounts   map[downloadStatus]int64
+	imgStoreCount  int
+	imgStoreMemory int64
+	imgStoreQuery  time.Duration // average this run
+	dbRows         int64
+	databaseSize   int64
+	destinations   []destinationSpace
+	gaugesUpdated  time.Time
+	historyRunning []presenceHistoryProgress
+	// Journaled downloads from the last run, handled at launch
+	pendingRecovered int64
+	pendingDropped   int64
+	// Waiting for the schedule's next window, zero if one's open
+	deferred   int64
+	nextWindow time.Time
+}
+
+func getStatusSnapshot() statusSnapshot {
+	snapshot := statusSnapshot{
+		inProgress:     atomic.LoadInt64(&downloadsInProgress),
+		connected:      getActiveDomainConnections(),
+		autoHistory:    len(autoHistoryQueue),
+		statusCounts:   getSessionStatusCounts(),
+		dbRows:         atomic.LoadInt64(&dbRowCount),
+		historyRunning: getPresenceHistories(),
+	}
+	snapshot.pendingRecovered = atomic.LoadInt64(&pendingRecovered)
+	snapshot.pendingDropped = atomic.LoadInt64(&pendingDropped)
+	snapshot.deferred = atomic.LoadInt64(&deferredDownloads)
+	if !isInScheduleWindow(time.Now()) {
+		snapshot.nextWindow = getNextScheduleWindow(time.Now())
+	}
+	if imgStore != nil {
+		snapshot.imgStoreCount = imgStoreCount()
+		snapshot.imgStoreMemory = imgStoreMemory()
+		snapshot.imgStoreQuery = imgStoreAverageQuery()
+	}
+	statusGauges.Lock()
+	snapshot.databaseSize = statusGauges.databaseSize
+	snapshot.destinations = statusGauges.destinations
+	snapshot.gaugesUpdated = statusGauges.updated
+	statusGauges.Unlock()
+	return snapshot
+}
+
+//#endregion
+
+//#region Transfer Stats
+
+const (
+	// Channels and domains listed by the stats command
+	transferStatsTop = 5
+	// Domains with fewer timed downloads aren't ranked, one slow file says little
+	transferStatsMinDownloads = 3
+)
+
+type transferTotal struct {
+	bytes, ms, count int64
+}
+
+// Bytes per second, 0 if nothing took measurable time.
+func (t transferTotal) speed() int64 {
+	if t.ms <= 0 {
+		return 0
+	}
+	return t.bytes * 1000 / t.ms
+}
+
+// Bandwidth by channel, space by destination and speed by domain for the stats command, from the whole database.
+func getTransferStats(channelID string) string {
+	channels, domains, roots := dbTransferTotals()
+	lines := []string{fmt.Sprintf("• **Received in this Channel —** %s", formatBytes(channels[channelID]))}
+
+	channelIDs := make([]string, 0, len(channels))
+	for id, total := range channels {
+		if total > 0 {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	sort.Slice(channelIDs, func(i, j int) bool { return channels[channelIDs[i]] > channels[channelIDs[j]] })
+	if len(channelIDs) > 0 {
+		lines = append(lines, "• **Most Received by Channel —**")
+	}
+	for i, id := range channelIDs {
+		if i == transferStatsTop {
+			lines = append(lines, fmt.Sprintf("   _...and %d more_", len(channelIDs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("   <#%s> — %s", id, formatBytes(channels[id])))
+	}
+
+	rootPaths := make([]string, 0, len(roots))
+	for root := range roots {
+		rootPaths = append(rootPaths, root)
+	}
+	sort.Slice(rootPaths, func(i, j int) bool { return roots[rootPaths[i]] > roots[rootPaths[j]] })
+	if len(rootPaths) > 0 {
+		lines = append(lines, "• **Saved by Destination —**")
+	}
+	for i, root := range rootPaths {
+		if i == transferStatsTop {
+			lines = append(lines, fmt.Sprintf("   _...and %d more_", len(rootPaths)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("   `%s` — %s", root, formatBytes(roots[root])))
+	}
+
+	var ranked []string
+	for domain, total := range domains {
+		if total.count >= transferStatsMinDownloads && total.ms > 0 {
+			ranked = append(ranked, domain)
+		}
+	}
+	if len(ranked) == 0 {
+		return strings.Join(lines, "\n")
+	}
+	sort.Slice(ranked, func(i, j int) bool { return domains[ranked[i]].speed() < domains[ranked[j]].speed() })
+	formatDomain := func(domain string) string {
+		total := domains[domain]
+		return fmt.Sprintf("   `%s` — %s/s average, %s each over %s downloads", domain, formatBytes(total.speed()),
+			(time.Duration(total.ms/total.count) * time.Millisecond).Round(time.Millisecond), formatNumber(total.count))
+	}
+	lines = append(lines, "• **Slowest Domains —**")
+	for i := 0; i < len(ranked) && i < transferStatsTop; i++ {
+		lines = append(lines, formatDomain(ranked[i]))
+	}
+	if len(ranked) > transferStatsTop {
+		lines = append(lines, "• **Fastest Domains —**")
+		for i := len(ranked) - 1; i >= 0 && i >= len(ranked)-transferStatsTop && i >= transferStatsTop; i-- {
+			lines = append(lines, formatDomain(ranked[i]))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+//#endregion
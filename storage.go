@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Where downloads get written. Paths given to a backend are full destination paths,
+// e.g. "X:\Downloads\file.jpg" or "s3://bucket/prefix/file.jpg".
+type storageBackend interface {
+	separator() string
+	mkdirAll(path string) error
+	exists(path string) (bool, error)
+	write(path string, data []byte, modTime time.Time) error
+	remove(path string) error
+}
+
+// Backends that can copy in a local file without reading all of it into memory.
+type fileStorage interface {
+	writeFile(path string, source string, modTime time.Time) error
+}
+
+// Writes a local file to storage, streamed where the backend can.
+func writeStorageFile(storage storageBackend, path string, source string, modTime time.Time) error {
+	if streaming, ok := storage.(fileStorage); ok {
+		return streaming.writeFile(path, source, modTime)
+	}
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return storage.write(path, data, modTime)
+}
+
+var errIncompleteWrite = errors.New("incomplete write")
+
+// Wraps errors worth retrying, e.g. a server that's temporarily unavailable.
+type transientStorageError struct {
+	err error
+}
+
+func (e transientStorageError) Error() string { return e.err.Error() }
+func (e transientStorageError) Unwrap() error { return e.err }
+
+// True for dropped connections, timeouts and temporary server errors.
+func isTransientStorageError(err error) bool {
+	var transient transientStorageError
+	var netErr net.Error
+	return errors.As(err, &transient) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, sftp.ErrSSHFxConnectionLost)
+}
+
+// Status for a failed storage operation, transient errors get their own so it's clear the destination was unreachable.
+func storageFailureStatus(err error, status downloadStatus) downloadStatus {
+	if isTransientStorageError(err) {
+		return downloadFailedStorageUnavailable
+	}
+	return status
+}
+
+// Returns the backend handling a destination, chosen by URL scheme. Anything else is a local path.
+func getStorageBackend(destination string) (storageBackend, error) {
+	switch {
+	case strings.HasPrefix(destination, "s3://"):
+		return getS3Storage()
+	case strings.HasPrefix(destination, "webdav://"), strings.HasPrefix(destination, "webdavs://"):
+		return webdavClient, nil
+	case strings.HasPrefix(destination, "sftp://"):
+		return getSFTPStorage(destination)
+	case isRemoteDestination(destination):
+		return nil, fmt.Errorf("unsupported destination \"%s\"", destination)
+	}
+	return localStorage{}, nil
+}
+
+func isRemoteDestination(destination string) bool {
+	return strings.Contains(destination, "://")
+}
+
+// Checks a remote destination is usable with the given credentials, without connecting.
+func checkRemoteDestination(destination string, credentials configurationCredentials) error {
+	location, err := parseRemoteLocation(destination)
+	if err != nil {
+		return err
+	}
+	switch location.scheme {
+	case "s3":
+		if credentials.S3Endpoint == "" {
+			return errors.New("s3 destination requires credentials.s3Endpoint")
+		}
+		return nil
+	case "webdav", "webdavs":
+		return nil
+	case "sftp":
+		if location.user == nil && credentials.SFTPUsername == "" {
+			return errors.New("sftp destination requires a user, e.g. sftp://user@host/path or credentials.sftpUsername")
+		}
+		if _, hasPassword := location.password(); !hasPassword && credentials.SFTPPassword == "" && credentials.SFTPKeyFile == "" {
+			return errors.New("sftp destination requires credentials.sftpPassword or credentials.sftpKeyFile")
+		}
+		return nil
+	}
+	return errors.New("unsupported destination, expected a local path or s3://, webdav://, webdavs:// or sftp://")
+}
+
+type remoteLocation struct {
+	scheme string
+	user   *url.Userinfo
+	host   string // including the port if given
+	path   string // always starts with "/"
+}
+
+// Splits "scheme://[user[:password]@]host[:port]/some/path".
+// The path isn't URL decoded since filenames can contain characters like ? and #.
+func parseRemoteLocation(destination string) (remoteLocation, error) {
+	i := strings.Index(destination, "://")
+	if i < 0 {
+		return remoteLocation{}, fmt.Errorf("not a remote destination \"%s\"", destination)
+	}
+	location := remoteLocation{scheme: strings.ToLower(destination[:i]), path: "/"}
+	authority := strings.ReplaceAll(destination[i+3:], "\\", "/")
+	if j := strings.Index(authority, "/"); j >= 0 {
+		authority, location.path = authority[:j], authority[j:]
+	}
+	parsed, err := url.Parse("//" + authority)
+	if err != nil || parsed.Host == "" {
+		return remoteLocation{}, fmt.Errorf("no host in \"%s\"", destination)
+	}
+	location.user, location.host = parsed.User, parsed.Host
+	return location, nil
+}
+
+func (l remoteLocation) password() (string, bool) {
+	if l.user == nil {
+		return "", false
+	}
+	return l.user.Password()
+}
+
+//#region Local
+
+type localStorage struct{}
+
+func (localStorage) separator() string {
+	return string(os.PathSeparator)
+}
+
+func (localStorage) mkdirAll(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+func (localStorage) exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (localStorage) write(path string, data []byte, modTime time.Time) error {
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() != int64(len(data)) {
+		os.Remove(path)
+		return fmt.Errorf("%w, wrote %d of %d bytes", errIncompleteWrite, info.Size(), len(data))
+	}
+	// Failing to set the time isn't worth failing the download over
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		log.Println(color.RedString("Error while changing metadata date \"%s\": %s", path, err))
+	}
+	return nil
+}
+
+func (localStorage) writeFile(path string, source string, modTime time.Time) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(output, input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if info, err := input.Stat(); err == nil && info.Size() != written {
+		os.Remove(path)
+		return fmt.Errorf("%w, wrote %d of %d bytes", errIncompleteWrite, written, info.Size())
+	}
+	// Failing to set the time isn't worth failing the download over
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		log.Println(color.RedString("Error while changing metadata date \"%s\": %s", path, err))
+	}
+	return nil
+}
+
+func (localStorage) remove(path string) error {
+	return os.Remove(path)
+}
+
+//#endregion
+
+//#region S3
+
+type s3Storage struct {
+	client *minio.Client
+}
+
+var (
+	s3Client      *s3Storage
+	s3ClientMutex sync.Mutex
+)
+
+func getS3Storage() (*s3Storage, error) {
+	settings := getConfig()
+	s3ClientMutex.Lock()
+	defer s3ClientMutex.Unlock()
+	if s3Client != nil {
+		return s3Client, nil
+	}
+	if settings.Credentials.S3Endpoint == "" {
+		return nil, errors.New("s3 destination used but credentials.s3Endpoint is missing")
+	}
+	client, err := minio.New(settings.Credentials.S3Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(settings.Credentials.S3AccessKey, settings.Credentials.S3SecretKey, ""),
+		Secure:    !settings.Credentials.S3DisableSSL,
+		Region:    settings.Credentials.S3Region,
+		Transport: getHTTPClient("https://" + settings.Credentials.S3Endpoint).Transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s3Client = &s3Storage{client: client}
+	return s3Client, nil
+}
+
+// "s3://bucket/some/key" -> "bucket", "some/key"
+func parseS3Path(path string) (string, string, error) {
+	location, err := parseRemoteLocation(path)
+	if err != nil {
+		return "", "", err
+	}
+	return location.host, strings.TrimPrefix(location.path, "/"), nil
+}
+
+func (s *s3Storage) separator() string {
+	return "/"
+}
+
+// Object storage has no folders, key prefixes are created along with the objects.
+func (s *s3Storage) mkdirAll(path string) error {
+	_, _, err := parseS3Path(path)
+	return err
+}
+
+func (s *s3Storage) exists(path string) (bool, error) {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.StatObject(context.Background(), bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Large files are uploaded in parts by the client automatically.
+func (s *s3Storage) write(path string, data []byte, modTime time.Time) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(context.Background(), bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		UserMetadata: map[string]string{
+			"Mtime": modTime.UTC().Format(time.RFC3339),
+		},
+	})
+	return err
+}
+
+func (s *s3Storage) writeFile(path string, source string, modTime time.Time) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.FPutObject(context.Background(), bucket, key, source, minio.PutObjectOptions{
+		UserMetadata: map[string]string{
+			"Mtime": modTime.UTC().Format(time.RFC3339),
+		},
+	})
+	return err
+}
+
+func (s *s3Storage) remove(path string) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{})
+}
+
+//#endregion
+
+//#region WebDAV
+
+// webdav:// connects over HTTP, webdavs:// over HTTPS. Requests share pooled connections through getHTTPClient.
+type webdavStorage struct {
+	folders sync.Map // folders known to exist, saves a MKCOL per file during history runs
+}
+
+var webdavClient = &webdavStorage{}
+
+func (s *webdavStorage) url(path string) (string, *url.Userinfo, error) {
+	location, err := parseRemoteLocation(path)
+	if err != nil {
+		return "", nil, err
+	}
+	scheme := "http"
+	if location.scheme == "webdavs" {
+		scheme = "https"
+	}
+	target := url.URL{Scheme: scheme, Host: location.host, Path: location.path}
+	return target.String(), location.user, nil
+}
+
+// Sends a request and returns the status code, 5xx and 429 responses are transient errors.
+func (s *webdavStorage) do(method string, path string, body []byte, headers map[string]string) (int, error) {
+	settings := getConfig()
+	target, user, err := s.url(path)
+	if err != nil {
+		return 0, err
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return 0, err
+	}
+	username, password := settings.Credentials.WebDAVUsername, settings.Credentials.WebDAVPassword
+	if user != nil {
+		username = user.Username()
+		if urlPassword, hasPassword := user.Password(); hasPassword {
+			password = urlPassword
+		}
+	}
+	if username != "" {
+		request.SetBasicAuth(username, password)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	client := getHTTPClient(target)
+	client.Timeout = time.Duration(settings.DownloadTimeout) * time.Second
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	// Drained so the connection goes back to the pool
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+
+	if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+		return response.StatusCode, transientStorageError{fmt.Errorf("%s %s: %s", method, path, response.Status)}
+	}
+	if response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("%s %s: %s", method, path, response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+func (s *webdavStorage) separator() string {
+	return "/"
+}
+
+func (s *webdavStorage) mkdirAll(path string) error {
+	path = strings.TrimRight(path, "/")
+	if _, exists := s.folders.Load(path); exists {
+		return nil
+	}
+	status, err := s.mkcol(path)
+	if status == http.StatusConflict { // parent is missing
+		parent := path[:strings.LastIndex(path, "/")]
+		if strings.Contains(parent[strings.Index(parent, "://")+3:], "/") {
+			if err := s.mkdirAll(parent); err != nil {
+				return err
+			}
+			_, err = s.mkcol(path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.folders.Store(path, true)
+	return nil
+}
+
+func (s *webdavStorage) mkcol(path string) (int, error) {
+	status, err := s.do("MKCOL", path+"/", nil, nil)
+	if status == http.StatusMethodNotAllowed { // already exists
+		return status, nil
+	}
+	return status, err
+}
+
+func (s *webdavStorage) exists(path string) (bool, error) {
+	status, err := s.do(http.MethodHead, path, nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Uploads to a temporary name then moves it into place, so a dropped connection never leaves a partial file.
+// WebDAV can't set modification times, files get the upload time.
+func (s *webdavStorage) write(path string, data []byte, modTime time.Time) error {
+	dir, name := pathpkg.Split(path)
+	temp := dir + "." + name + ".ddg-upload"
+	if _, err := s.do(http.MethodPut, temp, data, nil); err != nil {
+		return err
+	}
+	destination, _, err := s.url(path)
+	if err != nil {
+		return err
+	}
+	if _, err := s.do("MOVE", temp, nil, map[string]string{"Destination": destination, "Overwrite": "T"}); err != nil {
+		s.do(http.MethodDelete, temp, nil, nil)
+		if isTransientStorageError(err) {
+			return err
+		}
+		// Server doesn't allow moves, upload directly instead
+		_, err = s.do(http.MethodPut, path, data, nil)
+		return err
+	}
+	return nil
+}
+
+func (s *webdavStorage) remove(path string) error {
+	_, err := s.do(http.MethodDelete, path, nil, nil)
+	return err
+}
+
+//#endregion
+
+//#region SFTP
+
+// One SSH connection per user@host, shared by all downloads. SFTP requests are multiplexed over it.
+type sftpStorage struct {
+	conn    *ssh.Client
+	client  *sftp.Client
+	folders sync.Map // folders known to exist
+}
+
+var (
+	sftpConnections      = make(map[string]*sftpStorage)
+	sftpConnectionsMutex sync.Mutex
+)
+
+func getSFTPStorage(destination string) (*sftpStorage, error) {
+	settings := getConfig()
+	location, err := parseRemoteLocation(destination)
+	if err != nil {
+		return nil, err
+	}
+	username, password := settings.Credentials.SFTPUsername, settings.Credentials.SFTPPassword
+	if location.user != nil {
+		username = location.user.Username()
+		if urlPassword, hasPassword := location.password(); hasPassword {
+			password = urlPassword
+		}
+	}
+	if username == "" {
+		return nil, errors.New("sftp destination used but no user is set")
+	}
+	host := location.host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	key := username + "@" + host
+
+	sftpConnectionsMutex.Lock()
+	defer sftpConnectionsMutex.Unlock()
+	if storage, exists := sftpConnections[key]; exists {
+		return storage, nil
+	}
+
+	var auth []ssh.AuthMethod
+	if settings.Credentials.SFTPKeyFile != "" {
+		keyFile, err := ioutil.ReadFile(settings.Credentials.SFTPKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftpKeyFile: %w", err)
+		}
+		var signer ssh.Signer
+		if settings.Credentials.SFTPKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyFile, []byte(settings.Credentials.SFTPKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(keyFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftpKeyFile: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	hostKeyCallback, err := getSFTPHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         time.Duration(settings.DownloadTimeout) * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	storage := &sftpStorage{conn: conn, client: client}
+	sftpConnections[key] = storage
+
+	// Forget the connection once it drops so the next download reconnects
+	go func() {
+		conn.Wait()
+		client.Close()
+		sftpConnectionsMutex.Lock()
+		if sftpConnections[key] == storage {
+			delete(sftpConnections, key)
+		}
+		sftpConnectionsMutex.Unlock()
+	}()
+
+	return storage, nil
+}
+
+// Verifies against sftpKnownHostsFile or ~/.ssh/known_hosts. Without either, host keys are accepted and logged.
+func getSFTPHostKeyCallback() (ssh.HostKeyCallback, error) {
+	knownHostsFile := getConfig().Credentials.SFTPKnownHostsFile
+	if knownHostsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, ".ssh", "known_hosts")); err == nil {
+				knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+			}
+		}
+	}
+	if knownHostsFile != "" {
+		return knownhosts.New(knownHostsFile)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		log.Println(color.YellowString("No known_hosts file to verify %s against, accepting host key %s", hostname, ssh.FingerprintSHA256(key)))
+		return nil
+	}, nil
+}
+
+// "sftp://host/some/path" is absolute, "sftp://host/~/some/path" is relative to the login folder.
+func (s *sftpStorage) remotePath(path string) (string, error) {
+	location, err := parseRemoteLocation(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(location.path, "/~/") {
+		return location.path[3:], nil
+	}
+	return location.path, nil
+}
+
+// Closes the connection on transient errors so the retry gets a fresh one.
+func (s *sftpStorage) check(err error) error {
+	if err != nil && isTransientStorageError(err) {
+		s.conn.Close()
+	}
+	return err
+}
+
+func (s *sftpStorage) separator() string {
+	return "/"
+}
+
+func (s *sftpStorage) mkdirAll(path string) error {
+	remote, err := s.remotePath(strings.TrimRight(path, "/"))
+	if err != nil {
+		return err
+	}
+	if _, exists := s.folders.Load(remote); exists {
+		return nil
+	}
+	if err := s.client.MkdirAll(remote); err != nil {
+		return s.check(err)
+	}
+	s.folders.Store(remote, true)
+	return nil
+}
+
+func (s *sftpStorage) exists(path string) (bool, error) {
+	remote, err := s.remotePath(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.Stat(remote)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, s.check(err)
+}
+
+// Uploads to a temporary name then renames it into place, so a dropped connection never leaves a partial file.
+func (s *sftpStorage) write(path string, data []byte, modTime time.Time) error {
+	remote, err := s.remotePath(path)
+	if err != nil {
+		return err
+	}
+	dir, name := pathpkg.Split(remote)
+	temp := dir + "." + name + ".ddg-upload"
+
+	file, err := s.client.Create(temp)
+	if err != nil {
+		return s.check(err)
+	}
+	_, err = file.ReadFrom(bytes.NewReader(data))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if info, statErr := s.client.Stat(temp); statErr == nil && info.Size() != int64(len(data)) {
+			err = fmt.Errorf("%w, wrote %d of %d bytes", errIncompleteWrite, info.Size(), len(data))
+		}
+	}
+	if err != nil {
+		s.client.Remove(temp)
+		return s.check(err)
+	}
+
+	if err := s.client.PosixRename(temp, remote); err != nil {
+		if isTransientStorageError(err) {
+			return s.check(err)
+		}
+		// Plain renames can't replace existing files
+		s.client.Remove(remote)
+		if err := s.client.Rename(temp, remote); err != nil {
+			s.client.Remove(temp)
+			return s.check(err)
+		}
+	}
+
+	// Failing to set the time isn't worth failing the download over
+	if err := s.client.Chtimes(remote, modTime, modTime); err != nil {
+		log.Println(color.RedString("Error while changing metadata date \"%s\": %s", path, err))
+	}
+	return nil
+}
+
+func (s *sftpStorage) remove(path string) error {
+	remote, err := s.remotePath(path)
+	if err != nil {
+		return err
+	}
+	return s.check(s.client.Remove(remote))
+}
+
+//#endregion
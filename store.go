@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// The Downloads collection grows with every file, so it can be kept in SQLite or PostgreSQL instead of the embedded
+// database with settings.database. Everything else stays in the embedded database, it's small.
+
+const (
+	databaseTypeTiedot   = "tiedot"
+	databaseTypeSQLite   = "sqlite"
+	databaseTypePostgres = "postgres"
+	databasePathDefault  = "downloads.db"
+	// Rows copied between progress updates by "database migrate"
+	databaseMigrateProgressEvery = 10000
+)
+
+// Operations on the Downloads collection, rows are the same maps of field to value whichever database has them.
+type downloadStore interface {
+	Insert(doc map[string]interface{}) (int, error)
+	// For migrating, keeps the row's ID from the database it came from
+	InsertWithID(id int, doc map[string]interface{}) error
+	Read(id int) (map[string]interface{}, error)
+	Update(id int, doc map[string]interface{}) error
+	ForEachDoc(fun func(id int, doc []byte) (moveOn bool))
+	// Rows whose field is exactly value, only for indexed fields
+	FindBy(field string, value string) []int
+	HasIndex(field string) bool
+	Count() int
+	Close() error
+}
+
+var downloadsStore downloadStore
+
+func dbDownloads() downloadStore {
+	return downloadsStore
+}
+
+// Opens the database settings.database picks, the embedded one has to be open already.
+func openDownloadStore() (downloadStore, error) {
+	settings := getConfig()
+	if settings.Database != nil {
+		switch settings.Database.Type {
+		case databaseTypeSQLite:
+			return openSQLStore(databaseTypeSQLite, settings.Database.Path)
+		case databaseTypePostgres:
+			return openSQLStore(databaseTypePostgres, settings.Database.DSN)
+		}
+	}
+	return tiedotStore{myDB.Use("Downloads")}, nil
+}
+
+//#region Embedded
+
+type tiedotStore struct {
+	*db.Col
+}
+
+func (store tiedotStore) InsertWithID(id int, doc map[string]interface{}) error {
+	return store.InsertRecovery(id, doc)
+}
+
+func (store tiedotStore) FindBy(field string, value string) []int {
+	encoded, _ := json.Marshal(value)
+	var query interface{}
+	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": %s, "in": [%q]}]`, encoded, field)), &query)
+	queryResult := make(map[int]struct{})
+	db.EvalQuery(query, store.Col, &queryResult)
+
+	ids := make([]int, 0, len(queryResult))
+	for id := range queryResult {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (store tiedotStore) HasIndex(field string) bool {
+	for _, path := range store.AllIndexes() {
+		if len(path) == 1 && path[0] == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (store tiedotStore) Count() int {
+	i := 0
+	store.Col.ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		i++
+		return true
+	})
+	return i
+}
+
+// Closed with the rest of the embedded database.
+func (store tiedotStore) Close() error {
+	return nil
+}
+
+//#endregion
+
+//#region SQL
+
+// Fields looked up by FindBy, each has an index
+var sqlStoreIndexes = []string{"URL", "CanonicalURL", "ChannelID", "UserID", "Hash", "MessageID", "Destination"}
+
+// Rows are kept whole as JSON, so fields added later need no schema changes. Both databases read fields with
+// doc->>'Field', which the indexes are on.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string // databaseTypeSQLite or databaseTypePostgres
+}
+
+// The driver's name is the dialect's, source is SQLite's file or PostgreSQL's DSN.
+func openSQLStore(dialect string, source string) (downloadStore, error) {
+	if dialect == databaseTypeSQLite {
+		if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := sql.Open(dialect, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var statements []string
+	if dialect == databaseTypeSQLite {
+		// One writer at a time, waiting on each other rather than failing with "database is locked"
+		conn.SetMaxOpenConns(1)
+		statements = []string{
+			`PRAGMA journal_mode = WAL`,
+			`CREATE TABLE IF NOT EXISTS downloads (id INTEGER PRIMARY KEY AUTOINCREMENT, doc TEXT NOT NULL)`,
+		}
+	} else {
+		statements = []string{`CREATE TABLE IF NOT EXISTS downloads (id BIGSERIAL PRIMARY KEY, doc JSONB NOT NULL)`}
+	}
+	for _, field := range sqlStoreIndexes {
+		statements = append(statements, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS downloads_%s ON downloads ((doc->>'%s'))`, field, field))
+	}
+	for _, statement := range statements {
+		if _, err := conn.Exec(statement); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return sqlStore{conn, dialect}, nil
+}
+
+// Passed as text, SQLite would take bytes for its own binary JSON.
+func encodeSQLDoc(doc map[string]interface{}) (string, error) {
+	encoded, err := json.Marshal(doc)
+	return string(encoded), err
+}
+
+func (store sqlStore) Insert(doc map[string]interface{}) (int, error) {
+	encoded, err := encodeSQLDoc(doc)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = store.db.QueryRow(`INSERT INTO downloads (doc) VALUES ($1) RETURNING id`, encoded).Scan(&id)
+	return id, err
+}
+
+// PostgreSQL's sequence is moved past the ID, so rows inserted after don't take it.
+func (store sqlStore) InsertWithID(id int, doc map[string]interface{}) error {
+	encoded, err := encodeSQLDoc(doc)
+	if err != nil {
+		return err
+	}
+	if _, err := store.db.Exec(`INSERT INTO downloads (id, doc) VALUES ($1, $2)`, id, encoded); err != nil {
+		return err
+	}
+	if store.dialect == databaseTypePostgres {
+		_, err = store.db.Exec(`SELECT setval(pg_get_serial_sequence('downloads', 'id'), GREATEST($1, (SELECT MAX(id) FROM downloads)))`, id)
+	}
+	return err
+}
+
+func (store sqlStore) Read(id int) (map[string]interface{}, error) {
+	var encoded []byte
+	if err := store.db.QueryRow(`SELECT doc FROM downloads WHERE id = $1`, id).Scan(&encoded); err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	err := json.Unmarshal(encoded, &doc)
+	return doc, err
+}
+
+func (store sqlStore) Update(id int, doc map[string]interface{}) error {
+	encoded, err := encodeSQLDoc(doc)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec(`UPDATE downloads SET doc = $1 WHERE id = $2`, encoded, id)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("row %d doesn't exist", id)
+	}
+	return nil
+}
+
+// Read in full before fun sees any of it. SQLite only has the one connection, which the rows would hold onto
+// while fun looks things up.
+func (store sqlStore) ForEachDoc(fun func(id int, doc []byte) (moveOn bool)) {
+	if store.dialect == databaseTypeSQLite {
+		type row struct {
+			id  int
+			doc []byte
+		}
+		var all []row
+		store.eachRow(func(id int, doc []byte) bool {
+			all = append(all, row{id, doc})
+			return true
+		})
+		for _, row := range all {
+			if !fun(row.id, row.doc) {
+				return
+			}
+		}
+		return
+	}
+	// Streamed, lookups fun makes while it runs get a connection of their own from the pool
+	store.eachRow(fun)
+}
+
+func (store sqlStore) eachRow(fun func(id int, doc []byte) (moveOn bool)) {
+	rows, err := store.db.Query(`SELECT id, doc FROM downloads ORDER BY id`)
+	if err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to read downloads:\t%s", err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var doc []byte
+		if rows.Scan(&id, &doc) == nil && !fun(id, doc) {
+			return
+		}
+	}
+}
+
+func (store sqlStore) FindBy(field string, value string) []int {
+	if !store.HasIndex(field) {
+		return nil
+	}
+	rows, err := store.db.Query(fmt.Sprintf(`SELECT id FROM downloads WHERE doc->>'%s' = $1`, field), value)
+	if err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to look up downloads by %s:\t%s", field, err))
+		return nil
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (store sqlStore) HasIndex(field string) bool {
+	return stringInSlice(field, sqlStoreIndexes)
+}
+
+func (store sqlStore) Count() int {
+	var count int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM downloads`).Scan(&count); err != nil {
+		log.Println(logPrefixDatabase, color.HiRedString("Failed to count downloads:\t%s", err))
+	}
+	return count
+}
+
+func (store sqlStore) Close() error {
+	return store.db.Close()
+}
+
+//#endregion
+
+// For the "database migrate" command. Copies every row of the embedded database's Downloads into the one
+// settings.database picks, which has to be empty so nothing is copied twice. Rows keep their IDs, which the
+// gallery's links and everything else recording a row go by.
+func handleDatabaseMigrate(commandingMessage *discordgo.Message) {
+	settings := getConfig()
+	logPrefixHere := color.CyanString("[Database Migrate]")
+	source := myDB.Use("Downloads")
+	if _, embedded := dbDownloads().(tiedotStore); embedded || source == nil {
+		replyEmbed(commandingMessage, "Command — Database Migrate", "Set `database` to another type in settings and restart first, downloads are already in the embedded database.")
+		return
+	}
+	if existing := dbDownloads().Count(); existing > 0 {
+		replyEmbed(commandingMessage, "Command — Database Migrate", fmt.Sprintf("The %s database already has %s downloads, it has to be empty to migrate into.", settings.Database.Type, formatNumber(int64(existing))))
+		return
+	}
+
+	started := time.Now()
+	total := tiedotStore{source}.Count()
+	log.Println(logPrefixHere, color.CyanString("%s started migrating %d downloads to %s", getUserIdentifier(*commandingMessage.Author), total, settings.Database.Type))
+	header := fmt.Sprintf("`From:` embedded database\n`To:` %s\n`Downloads:` **%s**\n\n", settings.Database.Type, formatNumber(int64(total)))
+	status, err := replyEmbed(commandingMessage, "Command — Database Migrate", header+"Copying downloads, please wait...")
+	if err != nil {
+		log.Println(logPrefixHere, color.HiRedString("Failed to send command embed message:\t%s", err))
+	}
+
+	copied, failed := 0, 0
+	report := func() string {
+		content := fmt.Sprintf("``%s:`` **%s of %s copied**", durafmt.ParseShort(time.Since(started)).String(),
+			formatNumber(int64(copied)), formatNumber(int64(total)))
+		if failed > 0 {
+			content += fmt.Sprintf("\n``%s failed``", formatNumber(int64(failed)))
+		}
+		return content
+	}
+	copied, failed = migrateDownloads(tiedotStore{source}, dbDownloads(), func(copiedSoFar int, failedSoFar int) {
+		copied, failed = copiedSoFar, failedSoFar
+		if (copied+failed)%databaseMigrateProgressEvery == 0 && status != nil {
+			editEmbed(status, "Command — Database Migrate", header+report())
+		}
+	})
+
+	atomic.StoreInt64(&dbRowCount, int64(dbDownloads().Count()))
+	content := report() + "\n\n**FINISHED!** The embedded database's downloads were left as they were."
+	if status == nil {
+		replyEmbed(commandingMessage, "Command — Database Migrate", header+content)
+	} else if _, err := editEmbed(status, "Command — Database Migrate", header+content); err != nil {
+		log.Println(logPrefixHere, color.HiRedString("Failed to edit status message:\t%s", err))
+	}
+	log.Println(logPrefixHere, color.HiCyanString("Copied %d downloads to %s, %d failed", copied, settings.Database.Type, failed))
+}
+
+// Copies every row from source into target with the same ID, calling progress after each with the counts so far.
+func migrateDownloads(source downloadStore, target downloadStore, progress func(copied int, failed int)) (int, int) {
+	logPrefixHere := color.CyanString("[Database Migrate]")
+	copied, failed := 0, 0
+	source.ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docContent, &doc); err != nil {
+			failed++
+		} else if err := target.InsertWithID(id, doc); err != nil {
+			log.Println(logPrefixHere, color.HiRedString("Failed to copy row %d:\t%s", id, err))
+			failed++
+		} else {
+			copied++
+		}
+		if progress != nil {
+			progress(copied, failed)
+		}
+		return true
+	})
+	return copied, failed
+}
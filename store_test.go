@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/HouzuoGuo/tiedot/db"
+)
+
+func openTestSQLite(t *testing.T) downloadStore {
+	t.Helper()
+	store, err := openSQLStore(databaseTypeSQLite, filepath.Join(t.TempDir(), "nested", databasePathDefault))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store := openTestSQLite(t)
+
+	first, err := store.Insert(map[string]interface{}{"URL": "https://example.com/a.png", "ChannelID": "1"})
+	if err != nil {
+		t.Fatalf("Insert failed: %s", err)
+	}
+	second, _ := store.Insert(map[string]interface{}{"URL": "https://example.com/b.png", "ChannelID": "1"})
+	store.Insert(map[string]interface{}{"URL": "https://example.com/a.png", "ChannelID": "2"})
+
+	if count := store.Count(); count != 3 {
+		t.Errorf("Count is %d, want 3", count)
+	}
+	found := store.FindBy("URL", "https://example.com/a.png")
+	sort.Ints(found)
+	if len(found) != 2 || found[0] != first {
+		t.Errorf("FindBy URL gave %v, want 2 rows starting with %d", found, first)
+	}
+	if found := store.FindBy("ChannelID", "1"); len(found) != 2 {
+		t.Errorf("FindBy ChannelID gave %v, want 2 rows", found)
+	}
+	if found := store.FindBy("Filename", "a.png"); found != nil {
+		t.Errorf("FindBy an unindexed field gave %v, want nothing", found)
+	}
+
+	if err := store.Update(second, map[string]interface{}{"URL": "https://example.com/b.png", "Hash": "abc"}); err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	doc, err := store.Read(second)
+	if err != nil || doc["Hash"] != "abc" {
+		t.Errorf("Read after update gave %v, %v", doc, err)
+	}
+	if err := store.Update(999, map[string]interface{}{}); err == nil {
+		t.Error("Updating a missing row didn't fail")
+	}
+
+	// Lookups while going through every row, SQLite only has the one connection
+	seen := 0
+	store.ForEachDoc(func(id int, doc []byte) bool {
+		seen++
+		store.FindBy("ChannelID", "1")
+		return true
+	})
+	if seen != 3 {
+		t.Errorf("ForEachDoc saw %d rows, want 3", seen)
+	}
+}
+
+// Rows keep their IDs, and rows added afterwards don't take one of them.
+func TestMigrateDownloadsKeepsIDs(t *testing.T) {
+	embedded, err := db.OpenDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open tiedot: %s", err)
+	}
+	defer embedded.Close()
+	if err := embedded.Create("Downloads"); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+	source := tiedotStore{embedded.Use("Downloads")}
+	want := make(map[int]string)
+	for _, link := range []string{"https://example.com/1.png", "https://example.com/2.png", "https://example.com/3.png"} {
+		id, err := source.Insert(map[string]interface{}{"URL": link})
+		if err != nil {
+			t.Fatalf("Insert failed: %s", err)
+		}
+		want[id] = link
+	}
+
+	target := openTestSQLite(t)
+	copied, failed := migrateDownloads(source, target, nil)
+	if copied != 3 || failed != 0 {
+		t.Fatalf("Copied %d and failed %d, want 3 and 0", copied, failed)
+	}
+	got := make(map[int]string)
+	for id := range want {
+		doc, err := target.Read(id)
+		if err != nil {
+			t.Fatalf("Row %d wasn't copied with its ID: %s", id, err)
+		}
+		got[id] = doc["URL"].(string)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	next, err := target.Insert(map[string]interface{}{"URL": "https://example.com/4.png"})
+	if err != nil {
+		t.Fatalf("Insert after migrating failed: %s", err)
+	}
+	if _, taken := want[next]; taken {
+		t.Errorf("Row inserted after migrating took ID %d of a migrated row", next)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+var logPrefixTags = color.HiCyanString("[Tags]")
+
+const (
+	// Forum tags can be changed, channels are looked up again after this long
+	channelInfoCacheDuration = 10 * time.Minute
+	// Reactions are counted again once a download is this old
+	reactionRefreshAfter = 24 * time.Hour
+	// Downloads older than this when first seen are left with what they had
+	reactionRefreshWindow = 7 * 24 * time.Hour
+	// Most messages looked up each pass, the rest wait for the next
+	reactionRefreshBatch = 200
+)
+
+//#region Forum Tags
+
+// discordgo doesn't know about forums or threads yet, these come straight from the API.
+type channelInfo struct {
+	Type          int      `json:"type"`
+	NSFW          bool     `json:"nsfw"`
+	ParentID      string   `json:"parent_id"`
+	AppliedTags   []string `json:"applied_tags"`
+	AvailableTags []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"available_tags"`
+}
+
+type channelInfoCacheEntry struct {
+	info    *channelInfo
+	fetched time.Time
+}
+
+var channelInfoCache sync.Map
+
+func getChannelInfo(channelID string) *channelInfo {
+	if cached, exists := channelInfoCache.Load(channelID); exists {
+		if entry := cached.(channelInfoCacheEntry); time.Since(entry.fetched) < channelInfoCacheDuration {
+			return entry.info
+		}
+	}
+	var info *channelInfo
+	endpoint := interactionsEndpoint + "channels/" + channelID
+	if response, err := bot.RequestWithBucketID("GET", endpoint, nil, endpoint); err != nil {
+		if getConfig().DebugOutput {
+			log.Println(logPrefixDebug, color.YellowString("Failed to look up channel %s for tags:\t%s", channelID, err))
+		}
+	} else if err := json.Unmarshal(response, &info); err != nil {
+		info = nil
+	}
+	// Failures are cached too, so a channel that can't be read isn't asked for on every file
+	channelInfoCache.Store(channelID, channelInfoCacheEntry{info, time.Now()})
+	return info
+}
+
+// Names of the forum tags on the post a message is in, nil outside of forums.
+func getMessageTags(channelID string) []string {
+	if channelID == "" {
+		return nil
+	}
+	thread := getChannelInfo(channelID)
+	if thread == nil || len(thread.AppliedTags) == 0 || thread.ParentID == "" {
+		return nil
+	}
+	forum := getChannelInfo(thread.ParentID)
+	if forum == nil {
+		return nil
+	}
+	var tags []string
+	for _, applied := range thread.AppliedTags {
+		for _, available := range forum.AvailableTags {
+			if available.ID == applied {
+				tags = append(tags, available.Name)
+				break
+			}
+		}
+	}
+	return tags
+}
+
+//#endregion
+
+//#region Reactions
+
+// Count of each emoji by name, and every reaction together.
+func getReactionCounts(reactions []*discordgo.MessageReactions) (map[string]int, int) {
+	if len(reactions) == 0 {
+		return nil, 0
+	}
+	counts := make(map[string]int)
+	total := 0
+	for _, reaction := range reactions {
+		if reaction == nil || reaction.Emoji == nil {
+			continue
+		}
+		counts[reaction.Emoji.Name] += reaction.Count
+		total += reaction.Count
+	}
+	return counts, total
+}
+
+var reactionRefreshOnce sync.Once
+
+// Counts reactions again for downloads a day old, new messages rarely have any when they're saved.
+func startReactionRefresh() {
+	reactionRefreshOnce.Do(func() {
+		go func() {
+			for {
+				refreshReactions()
+				time.Sleep(time.Hour)
+			}
+		}()
+	})
+}
+
+func refreshReactions() {
+	due := dbReactionRefreshDue(reactionRefreshAfter, reactionRefreshWindow, reactionRefreshBatch)
+	if len(due) == 0 {
+		return
+	}
+	updated := 0
+	for message, ids := range due {
+		counts, total := map[string]int(nil), 0
+		m, err := bot.ChannelMessage(message.channelID, message.messageID)
+		if err == nil {
+			counts, total = getReactionCounts(m.Reactions)
+			updated++
+		} else if getConfig().DebugOutput {
+			// Left as it was, but marked so it isn't tried again
+			log.Println(logPrefixDebug, color.YellowString("Couldn't recount reactions on %s/%s:\t%s", message.channelID, message.messageID, err))
+		}
+		for _, id := range ids {
+			if err := dbUpdateReactions(id, counts, total, err == nil); err != nil {
+				log.Println(logPrefixTags, color.HiRedString("Failed to update reactions for row %d:\t%s", id, err))
+			}
+		}
+	}
+	log.Println(logPrefixTags, color.CyanString("Recounted reactions on %d of %d message%s", updated, len(due), pluralS(len(due))))
+}
+
+//#endregion
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+	"github.com/hako/durafmt"
+	"golang.org/x/image/draw"
+)
+
+// With generateThumbnails, saved images and videos get a small JPEG under .thumbs in basePath, at the same path
+// as the file with .jpg added, e.g. .thumbs/Server/channel/image.png.jpg. Only local destinations have them.
+
+var logPrefixThumbnails = color.HiCyanString("[Thumbnails]")
+
+const (
+	thumbnailsFolder = ".thumbs"
+	// Longest side, smaller images are only re-encoded
+	thumbnailMaxSize = 320
+	thumbnailQuality = 80
+	// Longest grabbing a video's frame may take
+	thumbnailTimeout = time.Minute
+	// Backfill progress message is edited every this many files
+	thumbnailProgressEvery = 100
+)
+
+// Where a file's thumbnail goes. Files outside basePath (or the working folder) keep their whole path under it.
+func getThumbnailPath(destination string) string {
+	settings := getConfig()
+	root := applyBasePath(settings.BasePath, thumbnailsFolder)
+	base := settings.BasePath
+	if base == "" {
+		base = "."
+	}
+	relative := filepath.Clean(destination)
+	if absolute, err := filepath.Abs(destination); err == nil {
+		relative = absolute
+		if absoluteBase, err := filepath.Abs(base); err == nil {
+			if inside, err := filepath.Rel(absoluteBase, absolute); err == nil && !strings.HasPrefix(inside, "..") {
+				relative = inside
+			}
+		}
+	}
+	relative = strings.TrimLeft(strings.TrimPrefix(relative, filepath.VolumeName(relative)), `/\`)
+	return filepath.Join(root, relative+".jpg")
+}
+
+// Videos need ffmpeg, without it they're left out.
+func isThumbnailable(contentType string) bool {
+	return contentType == "image" || (contentType == "video" && getConfig().FFmpegPath != "")
+}
+
+// Writes the thumbnail for a saved file, returns where it went.
+func generateThumbnail(source string, contentType string) (string, error) {
+	var frame image.Image
+	switch contentType {
+	case "image":
+		file, err := os.Open(source)
+		if err != nil {
+			return "", err
+		}
+		frame, _, err = image.Decode(file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	case "video":
+		var err error
+		if frame, err = grabVideoFrame(source); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%s files don't have thumbnails", contentType)
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, scaleThumbnail(frame), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", err
+	}
+	path := getThumbnailPath(source)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, encoded.Bytes(), 0644)
+}
+
+// Shrunk to fit thumbnailMaxSize, on white since JPEG can't be transparent.
+func scaleThumbnail(frame image.Image) image.Image {
+	bounds := frame.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailMaxSize || height > thumbnailMaxSize {
+		if width >= height {
+			width, height = thumbnailMaxSize, height*thumbnailMaxSize/width
+		} else {
+			width, height = width*thumbnailMaxSize/height, thumbnailMaxSize
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumbnail, thumbnail.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), frame, bounds, draw.Over, nil)
+	return thumbnail
+}
+
+// ffmpeg's thumbnail filter picks a typical frame from near the start, rather than a black first one.
+func grabVideoFrame(source string) (image.Image, error) {
+	settings := getConfig()
+	if settings.FFmpegPath == "" {
+		return nil, errors.New("videos need ffmpegPath")
+	}
+	dir, err := ioutil.TempDir("", "ddg-thumbnail-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "frame.png")
+
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, settings.FFmpegPath, "-y", "-loglevel", "error",
+		"-i", source, "-vf", "thumbnail", "-frames:v", "1", output)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", thumbnailTimeout)
+		}
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	frame, err := ioutil.ReadFile(output)
+	if err != nil {
+		return nil, errors.New("ffmpeg didn't write a frame")
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(frame))
+	return decoded, err
+}
+
+// For the "thumbnails backfill" command. Makes thumbnails for saved images and videos that don't have one,
+// from every channel or just channelID.
+func handleThumbnailBackfill(commandingMessage *discordgo.Message, channelID string) {
+	started := time.Now()
+	due := dbThumbnailsMissing(channelID)
+	where := "every channel"
+	if channelID != "" {
+		where = "<#" + channelID + ">"
+	}
+	log.Println(logPrefixThumbnails, color.CyanString("%s requested thumbnails for %s, %d file%s without one",
+		getUserIdentifier(*commandingMessage.Author), where, len(due), pluralS(len(due))))
+
+	header := fmt.Sprintf("`Files:` %s\n`Without thumbnails:` **%s**\n\n", where, formatNumber(int64(len(due))))
+	status, err := replyEmbed(commandingMessage, "Command — Thumbnails", header+"Generating thumbnails, please wait...")
+	if err != nil {
+		log.Println(logPrefixThumbnails, color.HiRedString("Failed to send command embed message:\t%s", err))
+	}
+
+	made, missing, failed := 0, 0, 0
+	report := func() string {
+		content := fmt.Sprintf("``%s:`` **%s thumbnail%s generated**\n``%s of %s files processed``",
+			durafmt.ParseShort(time.Since(started)).String(), formatNumber(int64(made)), pluralS(made),
+			formatNumber(int64(made+missing+failed)), formatNumber(int64(len(due))))
+		if missing > 0 {
+			content += fmt.Sprintf("\n``%s file%s no longer on disk``", formatNumber(int64(missing)), pluralS(missing))
+		}
+		if failed > 0 {
+			content += fmt.Sprintf("\n``%s file%s couldn't be read``", formatNumber(int64(failed)), pluralS(failed))
+		}
+		return content
+	}
+	for id, destination := range due {
+		if _, err := os.Stat(destination); err != nil {
+			missing++
+		} else if path, err := generateThumbnail(destination, getExpectedContentType(filepathExtension(destination))); err != nil {
+			if getConfig().DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Couldn't make a thumbnail for \"%s\":\t%s", destination, err))
+			}
+			failed++
+		} else if err := dbSetThumbnailPath(id, path); err != nil {
+			log.Println(logPrefixThumbnails, color.HiRedString("Failed to record thumbnail for row %d:\t%s", id, err))
+			failed++
+		} else {
+			made++
+		}
+		if done := made + missing + failed; done%thumbnailProgressEvery == 0 && status != nil {
+			editEmbed(status, "Command — Thumbnails", header+report())
+		}
+	}
+
+	content := report() + "\n\n**FINISHED!**"
+	if status == nil {
+		replyEmbed(commandingMessage, "Command — Thumbnails", header+content)
+	} else if _, err := editEmbed(status, "Command — Thumbnails", header+content); err != nil {
+		log.Println(logPrefixThumbnails, color.HiRedString("Failed to edit status message:\t%s", err))
+	}
+	log.Println(logPrefixThumbnails, color.HiCyanString("Generated %d thumbnail%s for %s, %d missing, %d failed", made, pluralS(made), where, missing, failed))
+}
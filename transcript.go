@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// Message content is saved per message next to its first file, or appended to a transcript of the channel.
+const (
+	messageContentPerMessage = "per-message"
+	messageContentChannelLog = "channel-log"
+)
+
+var (
+	messageContentModes   = []string{messageContentPerMessage, messageContentChannelLog}
+	messageContentFormats = []string{"markdown", "text", "jsonl"}
+)
+
+var logPrefixTranscript = color.HiCyanString("[Transcript]")
+
+const transcriptTimeFormat = "2006-01-02 15:04:05 MST"
+
+//#region Rendering
+
+var (
+	regexMentionUser    = regexp.MustCompile(`<@!?(\d+)>`)
+	regexMentionRole    = regexp.MustCompile(`<@&(\d+)>`)
+	regexMentionChannel = regexp.MustCompile(`<#(\d+)>`)
+	regexCustomEmoji    = regexp.MustCompile(`<a?(:\w+:)\d+>`)
+	regexTimestamp      = regexp.MustCompile(`<t:(-?\d+)(?::[tTdDfFR])?>`)
+
+	// Paired Discord markdown, stripped for plain text
+	regexMarkdown = []*regexp.Regexp{
+		regexp.MustCompile("(?s)```(?:\\w*\\n)?(.*?)```"),
+		regexp.MustCompile("`([^`]+)`"),
+		regexp.MustCompile(`\*\*(.+?)\*\*`),
+		regexp.MustCompile(`__(.+?)__`),
+		regexp.MustCompile(`~~(.+?)~~`),
+		regexp.MustCompile(`\|\|(.+?)\|\|`),
+		regexp.MustCompile(`\*([^*\s][^*]*?)\*`),
+	}
+)
+
+// Resolves mentions, custom emoji and timestamps to what Discord would show, using the state cache.
+func renderMessageContent(m *discordgo.Message, guildID string) string {
+	content := regexMentionUser.ReplaceAllStringFunc(m.Content, func(match string) string {
+		userID := regexMentionUser.FindStringSubmatch(match)[1]
+		if guildID != "" {
+			if member, err := bot.State.Member(guildID, userID); err == nil && member.User != nil {
+				if member.Nick != "" {
+					return "@" + member.Nick
+				}
+				return "@" + member.User.Username
+			}
+		}
+		for _, mentioned := range m.Mentions {
+			if mentioned.ID == userID {
+				return "@" + mentioned.Username
+			}
+		}
+		return match
+	})
+	content = regexMentionRole.ReplaceAllStringFunc(content, func(match string) string {
+		if guildID != "" {
+			if role, err := bot.State.Role(guildID, regexMentionRole.FindStringSubmatch(match)[1]); err == nil {
+				return "@" + role.Name
+			}
+		}
+		return match
+	})
+	content = regexMentionChannel.ReplaceAllStringFunc(content, func(match string) string {
+		return "#" + getChannelName(regexMentionChannel.FindStringSubmatch(match)[1])
+	})
+	content = regexCustomEmoji.ReplaceAllString(content, "$1")
+	content = regexTimestamp.ReplaceAllStringFunc(content, func(match string) string {
+		var unix int64
+		fmt.Sscan(regexTimestamp.FindStringSubmatch(match)[1], &unix)
+		return time.Unix(unix, 0).Format(transcriptTimeFormat)
+	})
+	return content
+}
+
+func stripDiscordMarkdown(content string) string {
+	for _, regex := range regexMarkdown {
+		content = regex.ReplaceAllString(content, "$1")
+	}
+	return content
+}
+
+type transcriptEntry struct {
+	ID          string   `json:"id"`
+	Time        string   `json:"time"`
+	ChannelID   string   `json:"channelID"`
+	AuthorID    string   `json:"authorID"`
+	Author      string   `json:"author"`
+	Content     string   `json:"content"`
+	Rendered    string   `json:"rendered"`
+	Attachments []string `json:"attachments,omitempty"`
+	Edited      bool     `json:"edited,omitempty"`
+}
+
+func getTranscriptEntry(m *discordgo.Message, edited bool) transcriptEntry {
+	guildID := m.GuildID
+	if guildID == "" {
+		if channel := getChannelState(m.ChannelID); channel != nil {
+			guildID = channel.GuildID
+		}
+	}
+	entry := transcriptEntry{
+		ID:        m.ID,
+		ChannelID: m.ChannelID,
+		AuthorID:  m.Author.ID,
+		Author:    m.Author.Username,
+		Content:   m.Content,
+		Rendered:  renderMessageContent(m, guildID),
+		Edited:    edited,
+	}
+	if sent, err := m.Timestamp.Parse(); err == nil {
+		entry.Time = sent.Format(transcriptTimeFormat)
+	}
+	if guildID != "" {
+		if member, err := bot.State.Member(guildID, m.Author.ID); err == nil && member.Nick != "" {
+			entry.Author = member.Nick
+		}
+	}
+	for _, attachment := range m.Attachments {
+		entry.Attachments = append(entry.Attachments, attachment.URL)
+	}
+	return entry
+}
+
+// Each entry starts with a line holding its message ID, which is what transcripts are sorted and deduplicated by.
+func formatTranscriptEntry(entry transcriptEntry, format string) string {
+	switch format {
+	case "jsonl":
+		line, _ := json.Marshal(entry)
+		return string(line) + "\n"
+	case "text":
+		text := fmt.Sprintf("[%s] %s (message:%s)", entry.Time, entry.Author, entry.ID)
+		if entry.Edited {
+			text += " (edited)"
+		}
+		if entry.Rendered != "" {
+			text += "\n" + stripDiscordMarkdown(entry.Rendered)
+		}
+		for _, attachment := range entry.Attachments {
+			text += "\n  " + attachment
+		}
+		return text + "\n\n"
+	}
+	text := fmt.Sprintf("<!-- message:%s -->\n**%s** — %s", entry.ID, entry.Author, entry.Time)
+	if entry.Edited {
+		text += " _(edited)_"
+	}
+	if entry.Rendered != "" {
+		text += "\n" + entry.Rendered
+	}
+	for _, attachment := range entry.Attachments {
+		text += fmt.Sprintf("\n- <%s>", attachment)
+	}
+	return text + "\n\n"
+}
+
+func getMessageContentFormat(channelConfig configurationChannel) string {
+	if channelConfig.SaveMessageContentFormat != nil {
+		return *channelConfig.SaveMessageContentFormat
+	}
+	return "markdown"
+}
+
+func getMessageContentExtension(format string) string {
+	switch format {
+	case "jsonl":
+		return ".jsonl"
+	case "text":
+		return ".txt"
+	}
+	return ".md"
+}
+
+//#endregion
+
+//#region Per Message
+
+// Writes the message next to the first file saved from it, e.g. "photo.message.md" beside "photo.jpg".
+func saveMessageContentFile(m *discordgo.Message, channelConfig configurationChannel, statuses []downloadStatusStruct) {
+	var saved *downloadItem
+	for _, status := range statuses {
+		if status.Status == downloadSuccess && status.Saved != nil {
+			saved = status.Saved
+			break
+		}
+	}
+	if saved == nil {
+		return
+	}
+
+	format := getMessageContentFormat(channelConfig)
+	content := formatTranscriptEntry(getTranscriptEntry(m, false), format)
+	extension := getMessageContentExtension(format)
+	if format == "jsonl" {
+		extension = ".json"
+	}
+	path := strings.TrimSuffix(saved.Destination, filepath.Ext(saved.Destination)) + ".message" + extension
+
+	storage, err := getStorageBackend(path)
+	if err == nil {
+		err = storage.write(path, []byte(strings.TrimSpace(content)+"\n"), saved.Time)
+	}
+	if err != nil {
+		log.Println(logPrefixTranscript, color.HiRedString("Failed to save message content to \"%s\":\t%s", path, err))
+	}
+}
+
+//#endregion
+
+//#region Channel Log
+
+var (
+	transcriptMutex sync.Mutex
+	// Message IDs already in each transcript, read from the file the first time it's appended to
+	transcriptIDs = make(map[string]map[string]bool)
+	// Highest message ID appended to each transcript, anything lower puts it out of order
+	transcriptLastID = make(map[string]string)
+	// Transcripts put out of order by history runs, by channel, sorted when the run finishes
+	transcriptsUnsorted = make(map[string]map[string]string)
+	transcriptWarned    = make(map[string]bool)
+)
+
+var (
+	regexTranscriptIDMarkdown = regexp.MustCompile(`(?m)^<!-- message:(\d+) -->$`)
+	regexTranscriptIDText     = regexp.MustCompile(`(?m)^\[[^\]]*\] .* \(message:(\d+)\)(?: \(edited\))?$`)
+	regexTranscriptIDJSON     = regexp.MustCompile(`(?m)^\{"id":"(\d+)"`)
+)
+
+func getTranscriptIDRegex(format string) *regexp.Regexp {
+	switch format {
+	case "jsonl":
+		return regexTranscriptIDJSON
+	case "text":
+		return regexTranscriptIDText
+	}
+	return regexTranscriptIDMarkdown
+}
+
+// Transcripts go where logLinks would write, divided the same way, or the channel's destination if it isn't set.
+func getTranscriptPath(m *discordgo.Message, channelConfig configurationChannel, format string) (string, error) {
+	folder := resolveDestination(channelConfig.Destination, m)
+	divideByServer, divideByChannel, divideByUser := false, true, false
+	if channelConfig.LogLinks != nil && channelConfig.LogLinks.Destination != "" {
+		folder = channelConfig.LogLinks.Destination
+		if channelConfig.LogLinks.DestinationIsFolder == nil || !*channelConfig.LogLinks.DestinationIsFolder {
+			folder = filepath.Dir(folder)
+		}
+		divideByServer = channelConfig.LogLinks.DivideLogsByServer != nil && *channelConfig.LogLinks.DivideLogsByServer
+		divideByChannel = channelConfig.LogLinks.DivideLogsByChannel != nil && *channelConfig.LogLinks.DivideLogsByChannel
+		divideByUser = channelConfig.LogLinks.DivideLogsByUser != nil && *channelConfig.LogLinks.DivideLogsByUser
+	}
+	if isRemoteDestination(folder) {
+		return "", fmt.Errorf("transcripts can't be appended to remote destinations like \"%s\"", folder)
+	}
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return "", err
+	}
+
+	name := "Transcript"
+	if divideByServer {
+		guildID := m.GuildID
+		if guildID == "" {
+			if channel := getChannelState(m.ChannelID); channel != nil {
+				guildID = channel.GuildID
+			}
+		}
+		name += " SID_" + guildID
+	}
+	if divideByChannel {
+		name += " CID_" + m.ChannelID
+	}
+	if divideByUser {
+		name += " UID_" + m.Author.ID
+	}
+	return filepath.Join(folder, name+getMessageContentExtension(format)), nil
+}
+
+// Appends the message to its channel's transcript, once. Edits are appended again as edits.
+func appendTranscript(m *discordgo.Message, channelConfig configurationChannel, edited bool, history bool) {
+	format := getMessageContentFormat(channelConfig)
+	path, err := getTranscriptPath(m, channelConfig, format)
+
+	transcriptMutex.Lock()
+	defer transcriptMutex.Unlock()
+	if err != nil {
+		if !transcriptWarned[m.ChannelID] {
+			transcriptWarned[m.ChannelID] = true
+			log.Println(logPrefixTranscript, color.HiRedString("Can't save a transcript for %s:\t%s", m.ChannelID, err))
+		}
+		return
+	}
+
+	ids, loaded := transcriptIDs[path]
+	if !loaded {
+		ids = make(map[string]bool)
+		if existing, err := ioutil.ReadFile(path); err == nil {
+			for _, match := range getTranscriptIDRegex(format).FindAllStringSubmatch(string(existing), -1) {
+				ids[match[1]] = true
+				if compareMessageIDs(match[1], transcriptLastID[path]) > 0 {
+					transcriptLastID[path] = match[1]
+				}
+			}
+		}
+		transcriptIDs[path] = ids
+	}
+	if ids[m.ID] && !edited {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println(logPrefixTranscript, color.HiRedString("Failed to open \"%s\":\t%s", path, err))
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(formatTranscriptEntry(getTranscriptEntry(m, edited), format)); err != nil {
+		log.Println(logPrefixTranscript, color.HiRedString("Failed to append to \"%s\":\t%s", path, err))
+		return
+	}
+	ids[m.ID] = true
+
+	if compareMessageIDs(m.ID, transcriptLastID[path]) > 0 {
+		transcriptLastID[path] = m.ID
+	} else if history {
+		if transcriptsUnsorted[m.ChannelID] == nil {
+			transcriptsUnsorted[m.ChannelID] = make(map[string]string)
+		}
+		transcriptsUnsorted[m.ChannelID][path] = format
+	}
+}
+
+// Snowflakes only grow, so a longer ID is always newer.
+func compareMessageIDs(a string, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// History runs newest to oldest, this puts the transcripts it appended to back in order once it's done.
+func sortTranscripts(channelID string) {
+	transcriptMutex.Lock()
+	defer transcriptMutex.Unlock()
+	for path, format := range transcriptsUnsorted[channelID] {
+		existing, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		content := string(existing)
+		matches := getTranscriptIDRegex(format).FindAllStringSubmatchIndex(content, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		type block struct {
+			id   string
+			text string
+		}
+		blocks := make([]block, len(matches))
+		for i, match := range matches {
+			end := len(content)
+			if i+1 < len(matches) {
+				end = matches[i+1][0]
+			}
+			blocks[i] = block{content[match[2]:match[3]], content[match[0]:end]}
+		}
+		sort.SliceStable(blocks, func(i, j int) bool {
+			return compareMessageIDs(blocks[i].id, blocks[j].id) < 0
+		})
+		sorted := content[:matches[0][0]]
+		for _, b := range blocks {
+			sorted += b.text
+		}
+		if err := ioutil.WriteFile(path, []byte(sorted), 0644); err != nil {
+			log.Println(logPrefixTranscript, color.HiRedString("Failed to sort \"%s\":\t%s", path, err))
+		}
+	}
+	delete(transcriptsUnsorted, channelID)
+}
+
+//#endregion
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+//#region Frontends
+
+// Alternative frontends and embed fixers, mapped back to the site they show so its handler picks the link up.
+// Entries match subdomains too, urlRewrites adds to these and an empty replacement turns one off.
+var urlFrontends = map[string]string{
+	"x.com":                 "twitter.com",
+	"nitter.net":            "twitter.com",
+	"fxtwitter.com":         "twitter.com",
+	"vxtwitter.com":         "twitter.com",
+	"fixupx.com":            "twitter.com",
+	"fixvx.com":             "twitter.com",
+	"ddinstagram.com":       "instagram.com",
+	"rxddit.com":            "reddit.com",
+	"vxreddit.com":          "reddit.com",
+	"old.reddit.com":        "reddit.com",
+	"teddit.net":            "reddit.com",
+	"libreddit.spike.codes": "reddit.com",
+	"phixiv.net":            "pixiv.net",
+}
+
+// Built-in frontends with the urlRewrites setting applied.
+func getURLRewrites() map[string]string {
+	settings := getConfig()
+	rewrites := make(map[string]string, len(urlFrontends)+len(settings.URLRewrites))
+	for domain, replacement := range urlFrontends {
+		rewrites[domain] = replacement
+	}
+	for domain, replacement := range settings.URLRewrites {
+		if replacement == "" {
+			delete(rewrites, domain)
+		} else {
+			rewrites[domain] = replacement
+		}
+	}
+	return rewrites
+}
+
+// Replaces the host of a frontend link with the canonical domain, the most specific entry wins.
+// Fragments are dropped since frontends add their own, e.g. Nitter's "#m".
+func rewriteURL(inputURL string, rewrites map[string]string) string {
+	u, err := url.Parse(inputURL)
+	if err != nil || u.Host == "" {
+		return inputURL
+	}
+	host := strings.ToLower(u.Hostname())
+	match := ""
+	for domain := range rewrites {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(match) {
+			match = domain
+		}
+	}
+	if match == "" {
+		return inputURL
+	}
+	u.Host = rewrites[match]
+	u.Fragment = ""
+	return u.String()
+}
+
+//#endregion
+
+//#region Shorteners
+
+// Most redirects this follows for one link
+const urlUnwrapMaxRedirects = 5
+
+// Redirectors worth following, urlShorteners adds to these and urlShortenersIgnored takes them out.
+var urlShorteners = []string{
+	"t.co",
+	"bit.ly",
+	"tinyurl.com",
+	"goo.gl",
+	"ow.ly",
+	"buff.ly",
+	"is.gd",
+	"dlvr.it",
+}
+
+func isURLShortener(host string) bool {
+	settings := getConfig()
+	host = strings.ToLower(host)
+	matches := func(domains []string) bool {
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+		return false
+	}
+	return !matches(settings.URLShortenersIgnored) && (matches(urlShorteners) || matches(settings.URLShorteners))
+}
+
+// Follows a shortener's redirects with HEAD requests until they lead somewhere that isn't a shortener.
+// Anything that goes wrong leaves the link as far as it got.
+func resolveShortURL(inputURL string) string {
+	settings := getConfig()
+	current := inputURL
+	for hop := 0; hop < urlUnwrapMaxRedirects; hop++ {
+		u, err := url.Parse(current)
+		if err != nil || !isURLShortener(u.Hostname()) {
+			break
+		}
+		client := *getHTTPClient(current)
+		client.Timeout = 10 * time.Second
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		request, err := http.NewRequest("HEAD", current, nil)
+		if err != nil {
+			break
+		}
+		request.Header.Set("User-Agent", sneakyUserAgent)
+		response, err := client.Do(request)
+		if err != nil {
+			if settings.DebugOutput {
+				log.Println(logPrefixDebug, color.YellowString("Failed to resolve short link %s:\t%s", current, err))
+			}
+			break
+		}
+		response.Body.Close()
+		location, err := response.Location()
+		if err != nil {
+			break
+		}
+		current = location.String()
+	}
+	if settings.DebugOutput && current != inputURL {
+		log.Println(logPrefixDebug, color.CyanString("Resolved short link %s to %s", inputURL, current))
+	}
+	return current
+}
+
+//#endregion
+
+// Turns frontend and shortened links into the canonical link for the site, before it's matched against handlers.
+func normalizeURL(inputURL string) string {
+	rewrites := getURLRewrites()
+	normalized := rewriteURL(inputURL, rewrites)
+	if u, err := url.Parse(normalized); err == nil && isURLShortener(u.Hostname()) {
+		normalized = rewriteURL(resolveShortURL(normalized), rewrites)
+	}
+	return normalized
+}
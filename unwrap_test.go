@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) {
+		settings.URLRewrites = map[string]string{
+			"nitter.example.org": "twitter.com",
+			"phixiv.net":         "", // turned off
+		}
+	})
+	rewrites := getURLRewrites()
+
+	tests := []struct {
+		inputURL string
+		want     string
+	}{
+		{"https://nitter.net/user/status/123#m", "https://twitter.com/user/status/123"},
+		{"https://x.com/user/status/123?s=20", "https://twitter.com/user/status/123?s=20"},
+		{"https://d.fxtwitter.com/user/status/123", "https://twitter.com/user/status/123"},
+		{"https://www.ddinstagram.com/p/abc/", "https://instagram.com/p/abc/"},
+		{"https://rxddit.com/r/pics/comments/abc/title/", "https://reddit.com/r/pics/comments/abc/title/"},
+		{"https://old.reddit.com/r/pics/comments/abc/", "https://reddit.com/r/pics/comments/abc/"},
+		{"https://teddit.net/r/pics/comments/abc/", "https://reddit.com/r/pics/comments/abc/"},
+		{"https://NITTER.NET/user/status/1", "https://twitter.com/user/status/1"},
+		{"https://nitter.example.org/user/status/1", "https://twitter.com/user/status/1"},
+		{"https://phixiv.net/artworks/1", "https://phixiv.net/artworks/1"},
+		{"https://notnitter.net/user/status/1", "https://notnitter.net/user/status/1"},
+		{"https://twitter.com/user/status/1#frag", "https://twitter.com/user/status/1#frag"},
+		{"not a link", "not a link"},
+	}
+	for _, test := range tests {
+		if got := rewriteURL(test.inputURL, rewrites); got != test.want {
+			t.Errorf("rewriteURL(%q) = %q, want %q", test.inputURL, got, test.want)
+		}
+	}
+}
+
+func TestIsURLShortener(t *testing.T) {
+	useTestConfig(t, func(settings *configuration) {
+		settings.URLShorteners = []string{"short.example"}
+		settings.URLShortenersIgnored = []string{"goo.gl"}
+	})
+
+	tests := map[string]bool{
+		"t.co":            true,
+		"BIT.LY":          true,
+		"www.tinyurl.com": true,
+		"short.example":   true,
+		"goo.gl":          false,
+		"notbit.ly":       false,
+		"twitter.com":     false,
+	}
+	for host, want := range tests {
+		if got := isURLShortener(host); got != want {
+			t.Errorf("isURLShortener(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	serveFixtures(t, fixtureRoutes{
+		"t.co/one":        "301 https://bit.ly/two",
+		"bit.ly/two":      "302 https://nitter.net/user/status/123",
+		"tinyurl.com/a":   "301 https://tinyurl.com/b",
+		"tinyurl.com/b":   "301 https://tinyurl.com/c",
+		"tinyurl.com/c":   "301 https://tinyurl.com/d",
+		"tinyurl.com/d":   "301 https://tinyurl.com/e",
+		"tinyurl.com/e":   "301 https://tinyurl.com/f",
+		"tinyurl.com/f":   "301 https://example.com/end",
+		"is.gd/relative":  "301 /elsewhere",
+		"is.gd/elsewhere": "301 https://example.com/file.png",
+	})
+
+	tests := []struct {
+		inputURL string
+		want     string
+	}{
+		// Through two shorteners, then the frontend is rewritten
+		{"https://t.co/one", "https://twitter.com/user/status/123"},
+		{"https://t.co/dead", "https://t.co/dead"}, // no redirect, nothing routed there
+		// Gives up after urlUnwrapMaxRedirects
+		{"https://tinyurl.com/a", "https://tinyurl.com/f"},
+		{"https://is.gd/relative", "https://example.com/file.png"},
+		{"https://x.com/user/status/1", "https://twitter.com/user/status/1"},
+		{"https://example.com/file.png", "https://example.com/file.png"},
+	}
+	for _, test := range tests {
+		if got := normalizeURL(test.inputURL); got != test.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", test.inputURL, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+// User accounts run the same download pipeline, filters and database, but the things that make a
+// self-bot stand out, or that only exist for bots, are gated on isUserSession in one place here.
+
+// Set at login from what Discord says the account is, not from userBot
+var isUserSession bool
+
+// Logs in with the token as given, and if Discord rejects it tries it as the other kind of token,
+// since userBot is easy to get wrong.
+func detectTokenType(session *discordgo.Session) *discordgo.Session {
+	settings := getConfig()
+	if _, err := session.User("@me"); err == nil || !isUnauthorized(err) {
+		return session
+	}
+	token, kind := "Bot "+settings.Credentials.Token, "bot"
+	if !settings.Credentials.UserBot {
+		token, kind = settings.Credentials.Token, "user"
+	}
+	retry, err := discordgo.New(token)
+	if err != nil {
+		return session
+	}
+	applyNetworkSettingsToDiscord(retry)
+	if _, err := retry.User("@me"); err != nil {
+		return session
+	}
+	log.Println(logPrefixDiscord, color.HiYellowString("Token was rejected as configured but works as a %s token, set userBot to %t to skip this check", kind, kind == "user"))
+	return retry
+}
+
+func isUnauthorized(err error) bool {
+	if err == discordgo.ErrUnauthorized {
+		return true
+	}
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Response != nil && restErr.Response.StatusCode == 401
+}
+
+func canReact() bool {
+	return !isUserSession || *getConfig().UserSession.Reactions
+}
+
+func canUpdatePresence() bool {
+	return !isUserSession || *getConfig().UserSession.Presence
+}
+
+// User accounts can't send embeds, messages that would have one are sent as text instead.
+func canSendEmbeds() bool {
+	return !isUserSession || *getConfig().UserSession.Embeds
+}
+
+// Slash commands, application info and the like.
+func canUseBotOnlyAPIs() bool {
+	return !isUserSession
+}
+
+// Paces history between batches for user accounts, with jitter so requests don't land like clockwork.
+func waitUserSessionHistory() {
+	if !isUserSession {
+		return
+	}
+	delay, err := time.ParseDuration(getConfig().UserSession.HistoryDelay)
+	if err != nil || delay <= 0 {
+		return
+	}
+	time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+}
+
+// Message with an embed, or the same content as text where embeds aren't sent.
+func embedMessage(channelID string, content string, title string, description string) *discordgo.MessageSend {
+	if canSendEmbeds() {
+		return &discordgo.MessageSend{Content: content, Embed: buildEmbed(channelID, title, description)}
+	}
+	return &discordgo.MessageSend{Content: embedText(content, title, description)}
+}
+
+func embedText(content string, title string, description string) string {
+	text := description
+	if title != "" {
+		text = fmt.Sprintf("**%s**\n%s", title, description)
+	}
+	if content != "" {
+		text = content + "\n" + text
+	}
+	// Message content is limited to 2000 characters
+	if len(text) > 2000 {
+		text = text[:1997] + "..."
+	}
+	return text
+}
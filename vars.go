@@ -28,8 +28,9 @@ const (
 )
 
 var (
-	configFile  string
-	configFileC bool
+	configFile       string
+	configFileC      bool
+	configFileFormat string // json, yaml, toml
 )
 
 // Log prefixes aren't to be used for constant messages where context is obvious.
@@ -48,6 +49,7 @@ var (
 	logPrefixDiscord     = color.HiBlueString("[Discord]")
 	logPrefixTwitter     = color.HiCyanString("[Twitter]")
 	logPrefixGoogleDrive = color.HiGreenString("[Google Drive]")
+	logPrefixFlickr      = color.HiMagentaString("[Flickr]")
 
 	logPrefixFileSkip = color.GreenString(">>> SKIPPING FILE:")
 )